@@ -3,10 +3,14 @@ module github.com/firefart/stunner
 go 1.19
 
 require (
+	filippo.io/age v1.1.1
+	github.com/Microsoft/go-winio v0.6.1
 	github.com/firefart/gosocks v0.2.0
 	github.com/pion/dtls/v2 v2.2.6
 	github.com/sirupsen/logrus v1.9.0
 	github.com/urfave/cli/v2 v2.25.1
+	golang.org/x/text v0.9.0
+	gopkg.in/yaml.v3 v3.0.1
 )
 
 require (
@@ -17,5 +21,7 @@ require (
 	github.com/russross/blackfriday/v2 v2.1.0 // indirect
 	github.com/xrash/smetrics v0.0.0-20201216005158-039620a65673 // indirect
 	golang.org/x/crypto v0.8.0 // indirect
+	golang.org/x/mod v0.8.0 // indirect
 	golang.org/x/sys v0.7.0 // indirect
+	golang.org/x/tools v0.6.0 // indirect
 )
@@ -0,0 +1,387 @@
+package internal
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"net/netip"
+	"sync"
+	"time"
+
+	"github.com/firefart/stunner/internal/helper"
+)
+
+// lifetimes from https://datatracker.ietf.org/doc/html/rfc5766#section-11
+const (
+	// DefaultAllocationLifetime is used if the server does not send a LIFETIME
+	DefaultAllocationLifetime = 10 * time.Minute
+	// ChannelBindingLifetime is the time after a channel binding expires
+	ChannelBindingLifetime = 10 * time.Minute
+	// PermissionLifetime is the time after a permission expires. It is
+	// shorter than the channel binding, which does not keep it alive
+	PermissionLifetime = 5 * time.Minute
+	// refreshMargin is the time before the expiry when bindings are renewed.
+	// Allocations with a lifetime of less than twice the margin are renewed
+	// after half of their lifetime
+	refreshMargin = 1 * time.Minute
+	// minRefreshDelay is the shortest time between two refreshes, so a
+	// failing clock or a tiny lifetime can not spin the refresh loop
+	minRefreshDelay = 1 * time.Second
+)
+
+// Allocation is an authenticated UDP allocation with a channel bound to
+// a single peer. Allocations, channel bindings and permissions expire on
+// the server, so long running users either read the data of the peer
+// with Relay, which renews them on a timer, or call KeepAlive regularly.
+type Allocation struct {
+	Conn    net.Conn
+	Channel []byte
+
+	logger   DebugLogger
+	username string
+	password string
+	realm    string
+	nonce    string
+	target   netip.Addr
+	port     uint16
+	timeout  time.Duration
+
+	mu sync.Mutex
+	// lifetime is the lifetime the server granted the allocation
+	lifetime         time.Duration
+	allocationExpiry time.Time
+	channelExpiry    time.Time
+	permissionExpiry time.Time
+	// responses and relayDone are set while Relay reads the connection
+	responses chan *Stun
+	relayDone chan struct{}
+}
+
+// NewAllocation allocates a relay on the server and binds a random
+// channel to the target
+func NewAllocation(logger DebugLogger, connectProtocol string, turnServer string, useTLS bool, tlsVerify bool, timeout time.Duration, targetHost netip.Addr, targetPort uint16, username, password string) (*Allocation, error) {
+	remote, realm, nonce, lifetime, err := setupTurnAllocation(logger, connectProtocol, turnServer, useTLS, tlsVerify, timeout, targetHost, targetPort, username, password)
+	if err != nil {
+		return nil, err
+	}
+
+	a := &Allocation{
+		Conn:             remote,
+		Channel:          helper.RandomChannelNumber(),
+		logger:           logger,
+		username:         username,
+		password:         password,
+		realm:            realm,
+		nonce:            nonce,
+		target:           targetHost,
+		port:             targetPort,
+		timeout:          timeout,
+		lifetime:         lifetime,
+		allocationExpiry: time.Now().Add(lifetime),
+	}
+	if err := a.bind(); err != nil {
+		remote.Close()
+		return nil, err
+	}
+	return a, nil
+}
+
+// ChannelExpiry returns the time the channel binding expires
+func (a *Allocation) ChannelExpiry() time.Time {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.channelExpiry
+}
+
+// KeepAlive refreshes the allocation and rebinds the channel if the
+// allocation, the channel binding or the permission expire soon. Unless
+// Relay reads the connection, it must not be called while another
+// goroutine reads from it as it waits for the responses on it.
+func (a *Allocation) KeepAlive() error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if time.Now().Add(a.allocationMargin()).After(a.allocationExpiry) {
+		a.logger.Debugf("[conn %s] refreshing allocation expiring at %s", ConnID(a.Conn), a.allocationExpiry)
+		if err := a.refresh(); err != nil {
+			return err
+		}
+	}
+	deadline := time.Now().Add(refreshMargin)
+	if deadline.After(a.channelExpiry) || deadline.After(a.permissionExpiry) {
+		a.logger.Debugf("[conn %s] rebinding channel %02x expiring at %s with the permission expiring at %s", ConnID(a.Conn), a.Channel, a.channelExpiry, a.permissionExpiry)
+		if err := a.bind(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Ping refreshes the allocation and returns the round trip time to the
+// TURN server. Like KeepAlive it must not be called while another
+// goroutine than Relay reads from the connection.
+func (a *Allocation) Ping() (time.Duration, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	start := time.Now()
+	if err := a.refresh(); err != nil {
+		return 0, err
+	}
+	return time.Since(start), nil
+}
+
+// Relay passes the payloads of the channel data of the peer to receive
+// until the connection fails or receive returns an error. It is the single
+// reader of the connection: responses are routed to the waiting KeepAlive
+// or Ping and the allocation, the channel binding and the permission are
+// renewed on a timer before they expire, so a busy peer can not delay the
+// refreshes.
+func (a *Allocation) Relay(receive func(payload []byte) error) error {
+	responses := make(chan *Stun, 8)
+	done := make(chan struct{})
+	a.mu.Lock()
+	a.responses = responses
+	a.relayDone = done
+	a.mu.Unlock()
+
+	stop := make(chan struct{})
+	stopped := make(chan struct{})
+	refreshErr := make(chan error, 1)
+	go func() {
+		defer close(stopped)
+		a.refreshLoop(stop, refreshErr)
+	}()
+	defer func() {
+		close(stop)
+		// wakes up a KeepAlive waiting for its response
+		close(done)
+		<-stopped
+		a.mu.Lock()
+		a.responses = nil
+		a.relayDone = nil
+		a.mu.Unlock()
+	}()
+
+	padded := isStream(a.Conn)
+	for {
+		buf, err := readMessage(a.Conn, a.timeout)
+		if errors.Is(err, helper.ErrTimeout) {
+			continue
+		}
+		if err == nil && len(buf) == 0 {
+			err = fmt.Errorf("connection closed by the TURN server")
+		}
+		if err != nil {
+			select {
+			case err := <-refreshErr:
+				return err
+			default:
+			}
+			return fmt.Errorf("ConnectionRead: %w", err)
+		}
+
+		if IsChannelData(buf) {
+			payloads, _ := SplitChannelData(buf, padded)
+			for _, p := range payloads {
+				if err := receive(p); err != nil {
+					return err
+				}
+			}
+			continue
+		}
+		resp, err := fromBytes(buf)
+		if err != nil {
+			a.logger.Debugf("[conn %s] ignoring invalid message: %v", ConnID(a.Conn), err)
+			continue
+		}
+		a.logger.Debugf("%s Received\n%s", logTag(a.Conn, resp.Header.TransactionID), resp.String())
+		select {
+		case responses <- resp:
+		default:
+			a.logger.Debugf("%s ignoring response as no request is waiting", logTag(a.Conn, resp.Header.TransactionID))
+		}
+	}
+}
+
+// refreshLoop calls KeepAlive whenever the allocation, the channel binding
+// or the permission expire soon until stop is closed. On an error the
+// connection is closed to end Relay
+func (a *Allocation) refreshLoop(stop <-chan struct{}, errs chan<- error) {
+	for {
+		delay := time.Until(a.nextRefresh())
+		if delay < minRefreshDelay {
+			delay = minRefreshDelay
+		}
+		timer := time.NewTimer(delay)
+		select {
+		case <-stop:
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+		if err := a.KeepAlive(); err != nil {
+			select {
+			case <-stop:
+				return
+			default:
+			}
+			errs <- fmt.Errorf("could not keep the allocation alive: %w", err)
+			a.Conn.Close()
+			return
+		}
+	}
+}
+
+// nextRefresh returns the time KeepAlive needs to renew the allocation,
+// the channel binding or the permission
+func (a *Allocation) nextRefresh() time.Time {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	next := a.allocationExpiry.Add(-a.allocationMargin())
+	for _, expiry := range []time.Time{a.channelExpiry, a.permissionExpiry} {
+		if expiry := expiry.Add(-refreshMargin); expiry.Before(next) {
+			next = expiry
+		}
+	}
+	return next
+}
+
+// allocationMargin returns the time before its expiry the allocation is
+// renewed
+func (a *Allocation) allocationMargin() time.Duration {
+	if a.lifetime > 0 && a.lifetime/2 < refreshMargin {
+		return a.lifetime / 2
+	}
+	return refreshMargin
+}
+
+// Close closes the underlying connection
+func (a *Allocation) Close() error {
+	return a.Conn.Close()
+}
+
+// bind binds the channel to the target. Binding an already bound
+// channel renews the binding and the permission
+func (a *Allocation) bind() error {
+	_, err := a.sendAndReceive(func() (*Stun, error) {
+		req, err := ChannelBindRequest(a.username, a.password, a.nonce, a.realm, a.target, a.port, a.Channel)
+		if err != nil {
+			return nil, fmt.Errorf("error on generating ChannelBindRequest: %w", err)
+		}
+		return req, nil
+	})
+	if err != nil {
+		return fmt.Errorf("error on ChannelBind: %w", err)
+	}
+	a.channelExpiry = time.Now().Add(ChannelBindingLifetime)
+	a.permissionExpiry = time.Now().Add(PermissionLifetime)
+	return nil
+}
+
+// refresh renews the allocation
+func (a *Allocation) refresh() error {
+	resp, err := a.sendAndReceive(func() (*Stun, error) {
+		return RefreshRequest(a.username, a.password, a.nonce, a.realm), nil
+	})
+	if err != nil {
+		return fmt.Errorf("error on Refresh: %w", err)
+	}
+	lifetime := DefaultAllocationLifetime
+	if v := resp.GetAttribute(AttrLifetime).Value; len(v) == 4 {
+		lifetime = time.Duration(binary.BigEndian.Uint32(v)) * time.Second
+	}
+	a.lifetime = lifetime
+	a.allocationExpiry = time.Now().Add(lifetime)
+	return nil
+}
+
+// sendAndReceive sends the request and returns the response. On a stale
+// nonce the request is rebuilt with the new nonce and resent once.
+func (a *Allocation) sendAndReceive(build func() (*Stun, error)) (*Stun, error) {
+	for attempt := 0; ; attempt++ {
+		req, err := build()
+		if err != nil {
+			return nil, err
+		}
+		a.discardResponses()
+		if err := req.Send(a.logger, a.Conn, a.timeout); err != nil {
+			return nil, err
+		}
+		resp, err := a.receive(req.Header.TransactionID)
+		if err != nil {
+			return nil, err
+		}
+		if resp.Header.MessageType.Class != MsgTypeClassError {
+			return resp, nil
+		}
+		respErr := resp.GetError()
+		var stunErr *ResponseError
+		if attempt == 0 && errors.As(respErr, &stunErr) && stunErr.Code == ErrorStaleNonce {
+			a.nonce = string(resp.GetAttribute(AttrNonce).Value)
+			continue
+		}
+		return nil, respErr
+	}
+}
+
+// discardResponses drops late responses Relay routed after an earlier
+// request timed out
+func (a *Allocation) discardResponses() {
+	for {
+		select {
+		case <-a.responses:
+		default:
+			return
+		}
+	}
+}
+
+// receive returns the response to the transaction. While Relay reads the
+// connection the response is taken from it, otherwise the STUN messages are
+// read from the connection and late channel data and responses that are
+// still in flight are skipped
+func (a *Allocation) receive(transactionID string) (*Stun, error) {
+	if a.responses != nil {
+		return a.receiveRelayed(transactionID)
+	}
+	for {
+		buf, err := readMessage(a.Conn, a.timeout)
+		if err != nil {
+			return nil, fmt.Errorf("ConnectionRead: %w", err)
+		}
+		if IsChannelData(buf) {
+			a.logger.Debugf("[conn %s] ignoring %d bytes of channel data while waiting for a response", ConnID(a.Conn), len(buf))
+			continue
+		}
+		resp, err := fromBytes(buf)
+		if err != nil {
+			return nil, fmt.Errorf("fromBytes: %w", err)
+		}
+		a.logger.Debugf("%s Received\n%s", logTag(a.Conn, resp.Header.TransactionID), resp.String())
+		if resp.Header.TransactionID != transactionID {
+			a.logger.Debugf("%s ignoring late response", logTag(a.Conn, resp.Header.TransactionID))
+			continue
+		}
+		return resp, nil
+	}
+}
+
+// receiveRelayed waits for Relay to route the response to the transaction
+func (a *Allocation) receiveRelayed(transactionID string) (*Stun, error) {
+	timer := time.NewTimer(a.timeout)
+	defer timer.Stop()
+	for {
+		select {
+		case resp := <-a.responses:
+			if resp.Header.TransactionID == transactionID {
+				return resp, nil
+			}
+			a.logger.Debugf("%s ignoring late response", logTag(a.Conn, resp.Header.TransactionID))
+		case <-a.relayDone:
+			return nil, fmt.Errorf("ConnectionRead: %w", net.ErrClosed)
+		case <-timer.C:
+			return nil, fmt.Errorf("ConnectionRead: %w", helper.ErrTimeout)
+		}
+	}
+}
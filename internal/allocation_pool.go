@@ -0,0 +1,263 @@
+package internal
+
+import (
+	"errors"
+	"net"
+	"net/netip"
+	"sync"
+	"time"
+)
+
+const (
+	// poolRefreshInterval is the interval the allocations of a pool are
+	// refreshed in. It is well below the default lifetime of 10 minutes
+	poolRefreshInterval = 2 * time.Minute
+	// poolCheckInterval is the interval idle allocations are released in
+	poolCheckInterval = 15 * time.Second
+)
+
+// TCPAllocationPool shares TCP allocations between connections, so a new
+// connection only needs a Connect and a ConnectionBind instead of a new
+// allocation. Every allocation carries up to a maximum number of
+// connections. Allocations are refreshed while they are in the pool and
+// released once they were idle for some time
+type TCPAllocationPool struct {
+	logger         DebugLogger
+	newAllocation  func(addressFamily AllocateProtocol) (*TCPAllocation, error)
+	maxConnections int
+	idleTimeout    time.Duration
+
+	mu          sync.Mutex
+	allocations []*pooledAllocation
+	stop        chan struct{}
+	closeOnce   sync.Once
+}
+
+type pooledAllocation struct {
+	allocation    *TCPAllocation
+	addressFamily AllocateProtocol
+	connections   int
+	idleSince     time.Time
+	lastRefresh   time.Time
+	// peers are the targets the allocation was used for. Their
+	// permissions expire after 5 minutes and are renewed on every refresh
+	peers map[netip.Addr]bool
+}
+
+// NewTCPAllocationPool returns a pool that allocates relays with
+// newAllocation. Close releases all allocations of the pool
+func NewTCPAllocationPool(logger DebugLogger, newAllocation func(addressFamily AllocateProtocol) (*TCPAllocation, error), maxConnections int, idleTimeout time.Duration) *TCPAllocationPool {
+	p := &TCPAllocationPool{
+		logger:         logger,
+		newAllocation:  newAllocation,
+		maxConnections: maxConnections,
+		idleTimeout:    idleTimeout,
+		stop:           make(chan struct{}),
+	}
+	go p.maintain()
+	return p
+}
+
+// Dial connects to the target on an allocation of the pool. A new
+// allocation is made if all allocations carry the maximum number of
+// connections. Closing the returned connection frees its slot
+func (p *TCPAllocationPool) Dial(targetHost netip.Addr, targetPort uint16) (*PooledConn, error) {
+	addressFamily := AddressFamily(targetHost)
+	entry, err := p.acquire(addressFamily)
+	if err != nil {
+		return nil, err
+	}
+	dataConnection, err := entry.allocation.Dial(targetHost, targetPort)
+	if err != nil {
+		// errors of the target leave the allocation intact
+		var stunErr *ResponseError
+		broken := !errors.As(err, &stunErr) || stunErr.Code == ErrorAllocationMismatch
+		p.release(entry, broken)
+		return nil, err
+	}
+	p.mu.Lock()
+	entry.peers[targetHost] = true
+	p.mu.Unlock()
+	return &PooledConn{TCPConn: dataConnection, pool: p, entry: entry}, nil
+}
+
+// acquire returns an allocation with a free slot and takes the slot
+func (p *TCPAllocationPool) acquire(addressFamily AllocateProtocol) (*pooledAllocation, error) {
+	p.mu.Lock()
+	for _, entry := range p.allocations {
+		if entry.addressFamily == addressFamily && entry.connections < p.maxConnections {
+			entry.connections++
+			p.mu.Unlock()
+			return entry, nil
+		}
+	}
+	p.mu.Unlock()
+
+	// allocate without holding the lock, so other connections are not
+	// blocked by a slow server
+	allocation, err := p.newAllocation(addressFamily)
+	if err != nil {
+		return nil, err
+	}
+	entry := &pooledAllocation{
+		allocation:    allocation,
+		addressFamily: addressFamily,
+		connections:   1,
+		lastRefresh:   time.Now(),
+		peers:         make(map[netip.Addr]bool),
+	}
+	p.mu.Lock()
+	p.allocations = append(p.allocations, entry)
+	count := len(p.allocations)
+	p.mu.Unlock()
+	p.logger.Debugf("[conn %s] added allocation to the pool, %d allocations", ConnID(allocation.Control), count)
+	return entry, nil
+}
+
+// release frees the slot of a connection. Broken allocations are removed
+// from the pool and closed
+func (p *TCPAllocationPool) release(entry *pooledAllocation, broken bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	entry.connections--
+	if entry.connections == 0 {
+		entry.idleSince = time.Now()
+	}
+	if broken {
+		p.remove(entry)
+	}
+}
+
+// remove closes the allocation and removes it from the pool. The caller
+// needs to hold the lock
+func (p *TCPAllocationPool) remove(entry *pooledAllocation) {
+	for i, e := range p.allocations {
+		if e == entry {
+			p.allocations = append(p.allocations[:i], p.allocations[i+1:]...)
+			entry.allocation.Close()
+			return
+		}
+	}
+}
+
+// Len returns the number of allocations in the pool
+func (p *TCPAllocationPool) Len() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return len(p.allocations)
+}
+
+// maintain releases idle allocations and refreshes the others until the
+// pool is closed
+func (p *TCPAllocationPool) maintain() {
+	tick := time.NewTicker(poolCheckInterval)
+	defer tick.Stop()
+	for {
+		select {
+		case <-p.stop:
+			return
+		case <-tick.C:
+		}
+		p.releaseIdle(time.Now())
+		p.refresh(time.Now())
+	}
+}
+
+// releaseIdle closes the allocations without connections that were idle
+// for longer than the idle timeout
+func (p *TCPAllocationPool) releaseIdle(now time.Time) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	var kept []*pooledAllocation
+	for _, entry := range p.allocations {
+		if entry.connections == 0 && now.Sub(entry.idleSince) >= p.idleTimeout {
+			p.logger.Debugf("[conn %s] releasing idle allocation", ConnID(entry.allocation.Control))
+			entry.allocation.Close()
+			continue
+		}
+		kept = append(kept, entry)
+	}
+	p.allocations = kept
+}
+
+// refresh refreshes the allocations that were not refreshed for the
+// refresh interval and the permissions of their peers. Allocations that can
+// not be refreshed are removed
+func (p *TCPAllocationPool) refresh(now time.Time) {
+	p.mu.Lock()
+	var due []*pooledAllocation
+	for _, entry := range p.allocations {
+		if now.Sub(entry.lastRefresh) >= poolRefreshInterval {
+			due = append(due, entry)
+		}
+	}
+	p.mu.Unlock()
+
+	for _, entry := range due {
+		_, err := entry.allocation.Refresh()
+		p.mu.Lock()
+		if err != nil {
+			p.logger.Debugf("[conn %s] removing allocation from the pool: %v", ConnID(entry.allocation.Control), err)
+			p.remove(entry)
+			p.mu.Unlock()
+			continue
+		}
+		entry.lastRefresh = now
+		peers := make([]netip.Addr, 0, len(entry.peers))
+		for peer := range entry.peers {
+			peers = append(peers, peer)
+		}
+		p.mu.Unlock()
+		p.permit(entry, peers)
+	}
+}
+
+// permit renews the permissions of the peers of the allocation. Peers the
+// server refuses a permission for are forgotten
+func (p *TCPAllocationPool) permit(entry *pooledAllocation, peers []netip.Addr) {
+	for _, peer := range peers {
+		if err := entry.allocation.Permit(peer); err != nil {
+			p.logger.Debugf("[conn %s] could not renew the permission of %s: %v", ConnID(entry.allocation.Control), peer, err)
+			p.mu.Lock()
+			delete(entry.peers, peer)
+			p.mu.Unlock()
+		}
+	}
+}
+
+// Close releases all allocations of the pool. Connections on them are
+// closed by the server
+func (p *TCPAllocationPool) Close() error {
+	p.closeOnce.Do(func() {
+		close(p.stop)
+	})
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for _, entry := range p.allocations {
+		entry.allocation.Close()
+	}
+	p.allocations = nil
+	return nil
+}
+
+// PooledConn is a data connection on an allocation of a pool. Closing it
+// frees its slot in the pool
+type PooledConn struct {
+	*net.TCPConn
+	pool      *TCPAllocationPool
+	entry     *pooledAllocation
+	closeOnce sync.Once
+}
+
+func (c *PooledConn) Close() error {
+	err := c.TCPConn.Close()
+	c.closeOnce.Do(func() {
+		c.pool.release(c.entry, false)
+	})
+	return err
+}
+
+// Relayed returns the relayed address of the allocation of the connection
+func (c *PooledConn) Relayed() netip.AddrPort {
+	return c.entry.allocation.Relayed()
+}
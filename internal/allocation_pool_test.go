@@ -0,0 +1,169 @@
+package internal
+
+import (
+	"net"
+	"net/netip"
+	"testing"
+	"time"
+)
+
+func TestTCPAllocationPool(t *testing.T) {
+	t.Parallel()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			defer conn.Close()
+		}
+	}()
+
+	allocations := 0
+	p := NewTCPAllocationPool(nilLogger{}, func(AllocateProtocol) (*TCPAllocation, error) {
+		conn, err := net.Dial("tcp", listener.Addr().String())
+		if err != nil {
+			return nil, err
+		}
+		allocations++
+		return &TCPAllocation{Control: conn.(*net.TCPConn), logger: nilLogger{}}, nil
+	}, 2, time.Minute)
+	defer p.Close()
+
+	// two connections share the first allocation, the third needs a new one
+	var entries []*pooledAllocation
+	for i := 0; i < 3; i++ {
+		entry, err := p.acquire(AllocateProtocolIgnore)
+		if err != nil {
+			t.Fatal(err)
+		}
+		entries = append(entries, entry)
+	}
+	if allocations != 2 || p.Len() != 2 {
+		t.Fatalf("expected 2 allocations but got %d with %d in the pool", allocations, p.Len())
+	}
+	if entries[0] != entries[1] || entries[1] == entries[2] {
+		t.Errorf("unexpected distribution of the connections")
+	}
+	// IPv6 targets need an allocation of their own
+	if _, err := p.acquire(AllocateProtocolIPv6); err != nil {
+		t.Fatal(err)
+	}
+	if p.Len() != 3 {
+		t.Errorf("expected 3 allocations but got %d", p.Len())
+	}
+
+	// a freed slot is reused
+	p.release(entries[1], false)
+	if entry, err := p.acquire(AllocateProtocolIgnore); err != nil || entry != entries[0] {
+		t.Errorf("expected the freed slot to be reused")
+	}
+
+	// idle allocations are released after the idle timeout
+	p.release(entries[2], false)
+	p.releaseIdle(time.Now())
+	if p.Len() != 3 {
+		t.Errorf("expected the idle allocation to be kept until the timeout")
+	}
+	p.releaseIdle(time.Now().Add(time.Minute))
+	if p.Len() != 2 {
+		t.Errorf("expected the idle allocation to be released but got %d allocations", p.Len())
+	}
+
+	// broken allocations are removed at once
+	p.release(entries[0], true)
+	if p.Len() != 1 {
+		t.Errorf("expected the broken allocation to be removed but got %d allocations", p.Len())
+	}
+}
+
+func TestTCPAllocationPoolRefresh(t *testing.T) {
+	t.Parallel()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+
+	// answers every request with a success response and records the
+	// methods and the permitted peers
+	requests := make(chan string, 10)
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		buf := make([]byte, 1024)
+		for {
+			n, err := conn.Read(buf)
+			if err != nil {
+				return
+			}
+			req, err := fromBytes(buf[:n])
+			if err != nil {
+				return
+			}
+			request := MessageTypeMethodString(req.Header.MessageType.Method)
+			if v := req.GetAttribute(AttrXorPeerAddress).Value; len(v) > 0 {
+				host, _, err := ConvertXORAddr(v, req.Header.TransactionID)
+				if err != nil {
+					return
+				}
+				request += " " + host
+			}
+			requests <- request
+			resp := newStun()
+			resp.Header.TransactionID = req.Header.TransactionID
+			resp.Header.MessageType = MessageType{Class: MsgTypeClassSuccess, Method: req.Header.MessageType.Method}
+			data, err := resp.Serialize()
+			if err != nil {
+				return
+			}
+			if _, err := conn.Write(data); err != nil {
+				return
+			}
+		}
+	}()
+
+	p := NewTCPAllocationPool(nilLogger{}, func(AllocateProtocol) (*TCPAllocation, error) {
+		conn, err := net.Dial("tcp", listener.Addr().String())
+		if err != nil {
+			return nil, err
+		}
+		return &TCPAllocation{Control: conn.(*net.TCPConn), logger: nilLogger{}, timeout: time.Second}, nil
+	}, 2, time.Hour)
+	defer p.Close()
+
+	entry, err := p.acquire(AllocateProtocolIgnore)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the connection to the peer is already closed, the allocation idles
+	entry.peers[netip.MustParseAddr("10.0.0.1")] = true
+	p.release(entry, false)
+
+	p.refresh(time.Now().Add(poolRefreshInterval))
+	expected := []string{
+		MessageTypeMethodString(MsgTypeMethodRefresh),
+		MessageTypeMethodString(MsgTypeMethodCreatePermission) + " 10.0.0.1",
+	}
+	for _, e := range expected {
+		select {
+		case got := <-requests:
+			if got != e {
+				t.Errorf("expected %q but got %q", e, got)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("missing %q", e)
+		}
+	}
+	if p.Len() != 1 {
+		t.Errorf("expected the allocation to stay in the pool")
+	}
+}
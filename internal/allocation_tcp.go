@@ -0,0 +1,465 @@
+package internal
+
+import (
+	"encoding/binary"
+	"fmt"
+	"net"
+	"net/netip"
+	"sync"
+	"time"
+
+	"github.com/firefart/stunner/internal/helper"
+)
+
+// TCPAllocation is an authenticated TCP allocation as defined in RFC 6062.
+// Multiple connections to peers can be opened on the same allocation, every
+// connection uses its own data connection to the server. The allocation
+// lives as long as the control connection is open and it is refreshed.
+type TCPAllocation struct {
+	Control *net.TCPConn
+
+	logger     DebugLogger
+	turnServer string
+	useTLS     bool
+	tlsVerify  bool
+	timeout    time.Duration
+	username   string
+	password   string
+	realm      string
+	nonce      string
+	// relayed is the address of the relay peers connect to. It is not
+	// known for handed off allocations
+	relayed netip.AddrPort
+	// lifetime was granted by the last Allocate or Refresh
+	lifetime time.Duration
+
+	// mu serializes the requests on the control connection
+	mu sync.Mutex
+}
+
+// NewTCPAllocation connects to the server and allocates a TCP relay
+func NewTCPAllocation(logger DebugLogger, turnServer string, useTLS bool, tlsVerify bool, timeout time.Duration, addressFamily AllocateProtocol, username, password string) (*TCPAllocation, error) {
+	throttle := currentAllocationThrottle()
+	throttle.wait()
+	allocation, err := newTCPAllocation(logger, turnServer, useTLS, tlsVerify, timeout, addressFamily, username, password)
+	throttle.done(err)
+	return allocation, err
+}
+
+func newTCPAllocation(logger DebugLogger, turnServer string, useTLS bool, tlsVerify bool, timeout time.Duration, addressFamily AllocateProtocol, username, password string) (*TCPAllocation, error) {
+	// protocol needs to be tcp
+	controlConnectionRaw, err := dial("tcp", turnServer, useTLS, tlsVerify, timeout)
+	if err != nil {
+		return nil, fmt.Errorf("error on establishing control connection: %w", err)
+	}
+
+	controlConnection, ok := controlConnectionRaw.(*net.TCPConn)
+	if !ok {
+		controlConnectionRaw.Close()
+		return nil, fmt.Errorf("could not cast control connection to TCPConn")
+	}
+	trackConn(controlConnection)
+	// close the connection if the setup fails
+	success := false
+	defer func() {
+		if !success {
+			untrackConn(controlConnection)
+			controlConnection.Close()
+		}
+	}()
+	if err := controlConnection.SetKeepAlive(true); err != nil {
+		return nil, fmt.Errorf("could not set KeepAlive on control connection: %w", err)
+	}
+
+	logger.Debugf("[conn %s] opened turn tcp control connection from %s to %s", ConnID(controlConnection), controlConnection.LocalAddr().String(), controlConnection.RemoteAddr().String())
+
+	allocateRequest := AllocateRequest(RequestedTransportTCP, addressFamily)
+	allocateResponse, err := allocateRequest.SendAndReceive(logger, controlConnection, timeout)
+	if err != nil {
+		return nil, fmt.Errorf("error on sending allocate request 1: %w", err)
+	}
+	if allocateResponse.Header.MessageType.Class != MsgTypeClassError {
+		return nil, fmt.Errorf("MessageClass is not Error (should be not authenticated)")
+	}
+
+	realm := string(allocateResponse.GetAttribute(AttrRealm).Value)
+	nonce := string(allocateResponse.GetAttribute(AttrNonce).Value)
+
+	allocateRequest = AllocateRequestAuth(username, password, nonce, realm, RequestedTransportTCP, addressFamily)
+	allocateResponse, err = allocateRequest.SendAndReceive(logger, controlConnection, timeout)
+	if err != nil {
+		return nil, fmt.Errorf("error on sending allocate request 2: %w", err)
+	}
+	if allocateResponse.Header.MessageType.Class == MsgTypeClassError {
+		return nil, fmt.Errorf("error on allocate response: %w", allocateResponse.GetError())
+	}
+
+	lifetime := DefaultAllocationLifetime
+	if v := allocateResponse.GetAttribute(AttrLifetime).Value; len(v) == 4 {
+		lifetime = time.Duration(binary.BigEndian.Uint32(v)) * time.Second
+	}
+
+	var relayed netip.AddrPort
+	if host, port, err := ConvertXORAddr(allocateResponse.GetAttribute(AttrXorRelayedAddress).Value, allocateResponse.Header.TransactionID); err == nil {
+		if ip, err := netip.ParseAddr(host); err == nil {
+			relayed = netip.AddrPortFrom(ip, port)
+		}
+	}
+
+	success = true
+	return &TCPAllocation{
+		Control:    controlConnection,
+		logger:     logger,
+		turnServer: turnServer,
+		useTLS:     useTLS,
+		tlsVerify:  tlsVerify,
+		timeout:    timeout,
+		username:   username,
+		password:   password,
+		realm:      realm,
+		nonce:      nonce,
+		relayed:    relayed,
+		lifetime:   lifetime,
+	}, nil
+}
+
+// Dial connects the relay to the target and returns the bound data
+// connection
+func (a *TCPAllocation) Dial(targetHost netip.Addr, targetPort uint16) (*net.TCPConn, error) {
+	a.mu.Lock()
+	connectResponse, err := a.connect(targetHost, targetPort)
+	a.mu.Unlock()
+	if err != nil {
+		return nil, err
+	}
+	if connectResponse.Header.MessageType.Class == MsgTypeClassError {
+		return nil, fmt.Errorf("error on Connect response: %w", connectResponse.GetError())
+	}
+
+	return a.bind(connectResponse.GetAttribute(AttrConnectionID).Value)
+}
+
+// connect sends the Connect request for the target. The nonce of long
+// lived allocations goes stale, so a stale nonce is replaced and the
+// request is resent once
+func (a *TCPAllocation) connect(targetHost netip.Addr, targetPort uint16) (*Stun, error) {
+	for attempt := 0; ; attempt++ {
+		connectRequest, err := ConnectRequestAuth(a.username, a.password, a.nonce, a.realm, targetHost, targetPort)
+		if err != nil {
+			return nil, fmt.Errorf("error on generating Connect request: %w", err)
+		}
+		connectResponse, err := connectRequest.SendAndReceive(a.logger, a.Control, a.timeout)
+		if err != nil {
+			return nil, fmt.Errorf("error on sending Connect request: %w", err)
+		}
+		if code, ok := connectResponse.GetErrorCode(); attempt == 0 && ok && code == ErrorStaleNonce {
+			a.nonce = string(connectResponse.GetAttribute(AttrNonce).Value)
+			continue
+		}
+		return connectResponse, nil
+	}
+}
+
+// Permit installs a permission for the peer, so it can connect to the
+// relayed address of the allocation
+func (a *TCPAllocation) Permit(peer netip.Addr) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	for attempt := 0; ; attempt++ {
+		permissionRequest, err := CreatePermissionRequest(a.username, a.password, a.nonce, a.realm, peer, 0)
+		if err != nil {
+			return fmt.Errorf("error on generating CreatePermission request: %w", err)
+		}
+		permissionResponse, err := permissionRequest.SendAndReceive(a.logger, a.Control, a.timeout)
+		if err != nil {
+			return fmt.Errorf("error on sending CreatePermission request: %w", err)
+		}
+		if permissionResponse.Header.MessageType.Class != MsgTypeClassError {
+			return nil
+		}
+		if code, ok := permissionResponse.GetErrorCode(); attempt == 0 && ok && code == ErrorStaleNonce {
+			a.nonce = string(permissionResponse.GetAttribute(AttrNonce).Value)
+			continue
+		}
+		return fmt.Errorf("error on CreatePermission response: %w", permissionResponse.GetError())
+	}
+}
+
+// Accept waits for a permitted peer to connect to the relayed address and
+// returns the bound data connection and the address of the peer. The
+// allocation can not be refreshed while waiting
+func (a *TCPAllocation) Accept(timeout time.Duration) (*net.TCPConn, netip.AddrPort, error) {
+	a.mu.Lock()
+	connectionID, peer, err := a.receiveConnectionAttempt(time.Now().Add(timeout))
+	a.mu.Unlock()
+	if err != nil {
+		return nil, netip.AddrPort{}, err
+	}
+	a.logger.Debugf("[conn %s] connection attempt %02x from %s", ConnID(a.Control), connectionID, peer)
+	dataConnection, err := a.bind(connectionID)
+	if err != nil {
+		return nil, netip.AddrPort{}, err
+	}
+	return dataConnection, peer, nil
+}
+
+// receiveConnectionAttempt reads from the control connection until a
+// ConnectionAttempt indication arrives
+func (a *TCPAllocation) receiveConnectionAttempt(deadline time.Time) ([]byte, netip.AddrPort, error) {
+	for {
+		remaining := time.Until(deadline)
+		if remaining <= 0 {
+			return nil, netip.AddrPort{}, fmt.Errorf("no connection attempt: %w", helper.ErrTimeout)
+		}
+		buf, err := readMessage(a.Control, remaining)
+		countMessage(a.Control, 0, len(buf))
+		if err != nil {
+			return nil, netip.AddrPort{}, fmt.Errorf("ConnectionRead: %w", err)
+		}
+		msg, err := fromBytes(buf)
+		if err != nil {
+			return nil, netip.AddrPort{}, fmt.Errorf("fromBytes: %w", err)
+		}
+		a.logger.Debugf("%s Received\n%s", logTag(a.Control, msg.Header.TransactionID), msg.String())
+		if msg.Header.MessageType.Class != MsgTypeClassIndication || msg.Header.MessageType.Method != MsgTypeMethodConnectionAttempt {
+			a.logger.Debugf("%s skipping message while waiting for a connection attempt", logTag(a.Control, msg.Header.TransactionID))
+			continue
+		}
+		host, port, err := ConvertXORAddr(msg.GetAttribute(AttrXorPeerAddress).Value, msg.Header.TransactionID)
+		if err != nil {
+			return nil, netip.AddrPort{}, fmt.Errorf("invalid peer address: %w", err)
+		}
+		ip, err := netip.ParseAddr(host)
+		if err != nil {
+			return nil, netip.AddrPort{}, fmt.Errorf("invalid peer address: %w", err)
+		}
+		return msg.GetAttribute(AttrConnectionID).Value, netip.AddrPortFrom(ip, port), nil
+	}
+}
+
+// bind opens a data connection and binds it to the connection of the
+// relay to a peer
+func (a *TCPAllocation) bind(connectionID []byte) (*net.TCPConn, error) {
+	// data connections hold no allocation and are closed by the caller
+	dataConnectionRaw, err := dial("tcp", a.turnServer, a.useTLS, a.tlsVerify, a.timeout)
+	if err != nil {
+		return nil, fmt.Errorf("error on establishing data connection: %w", err)
+	}
+
+	dataConnection, ok := dataConnectionRaw.(*net.TCPConn)
+	if !ok {
+		dataConnectionRaw.Close()
+		return nil, fmt.Errorf("could not cast data connection to TCPConn")
+	}
+	success := false
+	defer func() {
+		if !success {
+			dataConnection.Close()
+		}
+	}()
+	if err := dataConnection.SetKeepAlive(true); err != nil {
+		return nil, fmt.Errorf("could not set KeepAlive on data connection: %w", err)
+	}
+
+	a.logger.Debugf("[conn %s] opened turn tcp data connection from %s to %s", ConnID(dataConnection), dataConnection.LocalAddr().String(), dataConnection.RemoteAddr().String())
+
+	a.mu.Lock()
+	connectionBindRequest := ConnectionBindRequest(connectionID, a.username, a.password, a.nonce, a.realm)
+	a.mu.Unlock()
+	connectionBindResponse, err := connectionBindRequest.SendAndReceive(a.logger, dataConnection, a.timeout)
+	if err != nil {
+		return nil, fmt.Errorf("error on sending ConnectionBind request: %w", err)
+	}
+	if connectionBindResponse.Header.MessageType.Class == MsgTypeClassError {
+		return nil, fmt.Errorf("error on ConnectionBind reposnse: %s", connectionBindResponse.GetErrorString())
+	}
+
+	success = true
+	return dataConnection, nil
+}
+
+// Probe sends a Connect request to the target without binding a data
+// connection and returns the response and its round trip time. Responses to
+// earlier probes that timed out are skipped, so Probe should not be mixed
+// with Dial and Refresh on the same allocation. The server closes unbound
+// connections to targets after 30 seconds
+func (a *TCPAllocation) Probe(targetHost netip.Addr, targetPort uint16, timeout time.Duration) (*Stun, time.Duration, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	for attempt := 0; ; attempt++ {
+		connectRequest, err := ConnectRequestAuth(a.username, a.password, a.nonce, a.realm, targetHost, targetPort)
+		if err != nil {
+			return nil, 0, fmt.Errorf("error on generating Connect request: %w", err)
+		}
+		start := time.Now()
+		if err := connectRequest.Send(a.logger, a.Control, a.timeout); err != nil {
+			countTransaction(connectRequest, nil)
+			return nil, 0, err
+		}
+		connectResponse, err := a.receiveResponse(connectRequest, start.Add(timeout))
+		elapsed := time.Since(start)
+		countTransaction(connectRequest, connectResponse)
+		if err != nil {
+			return nil, elapsed, err
+		}
+		if code, ok := connectResponse.GetErrorCode(); attempt == 0 && ok && code == ErrorStaleNonce {
+			a.nonce = string(connectResponse.GetAttribute(AttrNonce).Value)
+			continue
+		}
+		return connectResponse, elapsed, nil
+	}
+}
+
+// receiveResponse reads from the control connection until the response to
+// req arrives
+func (a *TCPAllocation) receiveResponse(req *Stun, deadline time.Time) (*Stun, error) {
+	for {
+		remaining := time.Until(deadline)
+		if remaining <= 0 {
+			return nil, fmt.Errorf("no response to transaction %02x: %w", req.Header.TransactionID, helper.ErrTimeout)
+		}
+		buf, err := readMessage(a.Control, remaining)
+		countMessage(a.Control, 0, len(buf))
+		if err != nil {
+			return nil, fmt.Errorf("ConnectionRead: %w", err)
+		}
+		resp, err := fromBytes(buf)
+		if err != nil {
+			return nil, fmt.Errorf("fromBytes: %w", err)
+		}
+		if resp.Header.TransactionID != req.Header.TransactionID {
+			a.logger.Debugf("%s skipping late message", logTag(a.Control, resp.Header.TransactionID))
+			continue
+		}
+		a.logger.Debugf("%s Received\n%s", logTag(a.Control, resp.Header.TransactionID), resp.String())
+		return resp, nil
+	}
+}
+
+// Refresh renews the allocation with the default lifetime. A stale nonce
+// is replaced and the request is resent once
+func (a *TCPAllocation) Refresh() (time.Duration, error) {
+	return a.RefreshLifetime(0)
+}
+
+// RefreshLifetime renews the allocation and requests the lifetime. The
+// server caps it at its maximum lifetime. A lifetime of 0 requests the
+// default lifetime. It returns the granted lifetime
+func (a *TCPAllocation) RefreshLifetime(lifetime time.Duration) (time.Duration, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	a.logger.Debugf("[conn %s] refreshing allocation", ConnID(a.Control))
+	for attempt := 0; ; attempt++ {
+		refresh := RefreshRequest(a.username, a.password, a.nonce, a.realm)
+		if lifetime > 0 {
+			refresh.Attributes = append(refresh.Attributes, Attribute{
+				Type:  AttrLifetime,
+				Value: helper.PutUint32(uint32(lifetime.Seconds())),
+			})
+		}
+		response, err := refresh.SendAndReceive(a.logger, a.Control, a.timeout)
+		if err != nil {
+			return 0, fmt.Errorf("error on Refresh: %w", err)
+		}
+		if response.Header.MessageType.Class != MsgTypeClassError {
+			granted := DefaultAllocationLifetime
+			if v := response.GetAttribute(AttrLifetime).Value; len(v) == 4 {
+				granted = time.Duration(binary.BigEndian.Uint32(v)) * time.Second
+			}
+			a.lifetime = granted
+			return granted, nil
+		}
+		if attempt == 1 {
+			return 0, fmt.Errorf("error on Refresh: %w", response.GetError())
+		}
+		if r := response.GetAttribute(AttrRealm).Value; len(r) > 0 {
+			a.realm = string(r)
+		}
+		a.nonce = string(response.GetAttribute(AttrNonce).Value)
+	}
+}
+
+// KeepAlive refreshes the allocation every interval until stop is closed
+// or a refresh fails
+func (a *TCPAllocation) KeepAlive(interval time.Duration, stop <-chan struct{}) error {
+	tick := time.NewTicker(interval)
+	defer tick.Stop()
+	for {
+		select {
+		case <-stop:
+			return nil
+		case <-tick.C:
+		}
+		if _, err := a.Refresh(); err != nil {
+			return err
+		}
+	}
+}
+
+// handoffState is the state passed along with the control connection on a
+// handoff
+type handoffState struct {
+	TurnServer string `json:"turnserver"`
+	UseTLS     bool   `json:"tls"`
+	TLSVerify  bool   `json:"tlsverify"`
+	Username   string `json:"username"`
+	Password   string `json:"password"`
+	Realm      string `json:"realm"`
+	Nonce      string `json:"nonce"`
+}
+
+func (a *TCPAllocation) state() handoffState {
+	return handoffState{
+		TurnServer: a.turnServer,
+		UseTLS:     a.useTLS,
+		TLSVerify:  a.tlsVerify,
+		Username:   a.username,
+		Password:   a.password,
+		Realm:      a.realm,
+		Nonce:      a.nonce,
+	}
+}
+
+func (s handoffState) allocation(logger DebugLogger, control *net.TCPConn, timeout time.Duration) *TCPAllocation {
+	return &TCPAllocation{
+		Control:    control,
+		logger:     logger,
+		turnServer: s.TurnServer,
+		useTLS:     s.UseTLS,
+		tlsVerify:  s.TLSVerify,
+		timeout:    timeout,
+		username:   s.Username,
+		password:   s.Password,
+		realm:      s.Realm,
+		nonce:      s.Nonce,
+		// the remaining lifetime is not passed along
+		lifetime: DefaultAllocationLifetime,
+	}
+}
+
+// Lifetime returns the lifetime granted by the last Allocate or Refresh
+func (a *TCPAllocation) Lifetime() time.Duration {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.lifetime
+}
+
+// Relayed returns the address of the relay peers connect to. It is not
+// valid for handed off allocations
+func (a *TCPAllocation) Relayed() netip.AddrPort {
+	return a.relayed
+}
+
+// TurnServer returns the server the allocation was made on
+func (a *TCPAllocation) TurnServer() string {
+	return a.turnServer
+}
+
+// Close closes the control connection which releases the allocation
+func (a *TCPAllocation) Close() error {
+	untrackConn(a.Control)
+	return a.Control.Close()
+}
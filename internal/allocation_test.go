@@ -0,0 +1,466 @@
+package internal
+
+import (
+	"net"
+	"net/netip"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+type nilLogger struct{}
+
+func (nilLogger) Debugf(string, ...interface{}) {}
+
+// fakeServer answers every request with a success response after sending
+// some late channel data and a late response to an earlier request. It
+// returns the methods of all received requests
+func fakeServer(t *testing.T, conn net.Conn, requests int) <-chan []MessageTypeMethod {
+	t.Helper()
+	done := make(chan []MessageTypeMethod, 1)
+	go func() {
+		var methods []MessageTypeMethod
+		defer func() { done <- methods }()
+		buf := make([]byte, 1024)
+		for i := 0; i < requests; i++ {
+			n, err := conn.Read(buf)
+			if err != nil {
+				return
+			}
+			req, err := fromBytes(buf[:n])
+			if err != nil {
+				return
+			}
+			methods = append(methods, req.Header.MessageType.Method)
+			// channel data still in flight
+			if _, err := conn.Write([]byte{0x40, 0x00, 0x00, 0x01, 0xff}); err != nil {
+				return
+			}
+			// the late response has another transaction ID and a lifetime
+			// of a second
+			late := newStun()
+			late.Attributes = append(late.Attributes, Attribute{Type: AttrLifetime, Value: []byte{0x00, 0x00, 0x00, 0x01}})
+			resp := newStun()
+			resp.Header.TransactionID = req.Header.TransactionID
+			for _, resp := range []*Stun{late, resp} {
+				resp.Header.MessageType = MessageType{
+					Class:  MsgTypeClassSuccess,
+					Method: req.Header.MessageType.Method,
+				}
+				data, err := resp.Serialize()
+				if err != nil {
+					return
+				}
+				if _, err := conn.Write(data); err != nil {
+					return
+				}
+			}
+		}
+	}()
+	return done
+}
+
+func TestAllocationKeepAlive(t *testing.T) {
+	t.Parallel()
+	client, server := net.Pipe()
+	defer client.Close()
+	defer server.Close()
+
+	a := &Allocation{
+		Conn:    client,
+		Channel: []byte{0x40, 0x00},
+		logger:  nilLogger{},
+		target:  netip.MustParseAddr("10.0.0.1"),
+		port:    53,
+		timeout: time.Second,
+		// the allocation is still valid but the channel is about to expire
+		allocationExpiry: time.Now().Add(DefaultAllocationLifetime),
+		channelExpiry:    time.Now().Add(10 * time.Second),
+	}
+
+	done := fakeServer(t, server, 1)
+	if err := a.KeepAlive(); err != nil {
+		t.Fatal(err)
+	}
+	server.Close()
+	methods := <-done
+	if len(methods) != 1 || methods[0] != MsgTypeMethodChannelbind {
+		t.Fatalf("expected a single ChannelBind, got %v", methods)
+	}
+	if time.Until(a.ChannelExpiry()) < ChannelBindingLifetime-time.Minute {
+		t.Errorf("channel expiry was not renewed: %s", a.ChannelExpiry())
+	}
+
+	// nothing is due so no request must be sent
+	if err := a.KeepAlive(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestAllocationKeepAlivePermission(t *testing.T) {
+	t.Parallel()
+	client, server := net.Pipe()
+	defer client.Close()
+	defer server.Close()
+
+	a := &Allocation{
+		Conn:    client,
+		Channel: []byte{0x40, 0x00},
+		logger:  nilLogger{},
+		target:  netip.MustParseAddr("10.0.0.1"),
+		port:    53,
+		timeout: time.Second,
+		// the channel is bound for a while but the permission expires
+		// after half of the time
+		allocationExpiry: time.Now().Add(DefaultAllocationLifetime),
+		channelExpiry:    time.Now().Add(ChannelBindingLifetime / 2),
+		permissionExpiry: time.Now().Add(10 * time.Second),
+	}
+
+	done := fakeServer(t, server, 1)
+	if err := a.KeepAlive(); err != nil {
+		t.Fatal(err)
+	}
+	server.Close()
+	if methods := <-done; len(methods) != 1 || methods[0] != MsgTypeMethodChannelbind {
+		t.Fatalf("expected a single ChannelBind, got %v", methods)
+	}
+	if time.Until(a.permissionExpiry) < PermissionLifetime-time.Minute {
+		t.Errorf("permission expiry was not renewed: %s", a.permissionExpiry)
+	}
+}
+
+func TestAllocationPing(t *testing.T) {
+	t.Parallel()
+	client, server := net.Pipe()
+	defer client.Close()
+	defer server.Close()
+
+	a := &Allocation{
+		Conn:    client,
+		Channel: []byte{0x40, 0x00},
+		logger:  nilLogger{},
+		timeout: time.Second,
+	}
+	done := fakeServer(t, server, 1)
+	rtt, err := a.Ping()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rtt <= 0 {
+		t.Errorf("expected a positive rtt, got %s", rtt)
+	}
+	server.Close()
+	if methods := <-done; len(methods) != 1 || methods[0] != MsgTypeMethodRefresh {
+		t.Fatalf("expected a single Refresh, got %v", methods)
+	}
+	if time.Until(a.allocationExpiry) < DefaultAllocationLifetime-time.Minute {
+		t.Errorf("allocation expiry was not renewed: %s", a.allocationExpiry)
+	}
+}
+
+func TestAllocationNextRefresh(t *testing.T) {
+	t.Parallel()
+	now := time.Now()
+	tt := []struct {
+		name     string
+		lifetime time.Duration
+		want     time.Duration
+	}{
+		{name: "default lifetime", lifetime: DefaultAllocationLifetime, want: DefaultAllocationLifetime - refreshMargin},
+		{name: "short lifetime", lifetime: 30 * time.Second, want: 15 * time.Second},
+		{name: "lifetime of the margin", lifetime: refreshMargin, want: refreshMargin / 2},
+	}
+	for _, tc := range tt {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+			a := &Allocation{
+				lifetime:         tc.lifetime,
+				allocationExpiry: now.Add(tc.lifetime),
+				channelExpiry:    now.Add(ChannelBindingLifetime),
+				permissionExpiry: now.Add(ChannelBindingLifetime),
+			}
+			if got := a.nextRefresh().Sub(now); got != tc.want {
+				t.Errorf("expected the refresh after %s, got %s", tc.want, got)
+			}
+		})
+	}
+}
+
+func TestAllocationRelay(t *testing.T) {
+	t.Parallel()
+	client, server := net.Pipe()
+	defer client.Close()
+	defer server.Close()
+
+	a := &Allocation{
+		Conn:    client,
+		Channel: []byte{0x40, 0x00},
+		logger:  nilLogger{},
+		target:  netip.MustParseAddr("10.0.0.1"),
+		port:    53,
+		timeout: time.Second,
+		// the permission is due for a refresh in a moment
+		allocationExpiry: time.Now().Add(DefaultAllocationLifetime),
+		channelExpiry:    time.Now().Add(ChannelBindingLifetime),
+		permissionExpiry: time.Now().Add(refreshMargin + 50*time.Millisecond),
+	}
+
+	// the peer sends data all the time
+	stop := make(chan struct{})
+	defer close(stop)
+	go func() {
+		for {
+			select {
+			case <-stop:
+				return
+			default:
+			}
+			if _, err := server.Write([]byte{0x40, 0x00, 0x00, 0x01, 0xff}); err != nil {
+				return
+			}
+			time.Sleep(time.Millisecond)
+		}
+	}()
+	methods := make(chan MessageTypeMethod, 1)
+	go func() {
+		buf := make([]byte, 1024)
+		n, err := server.Read(buf)
+		if err != nil {
+			return
+		}
+		req, err := fromBytes(buf[:n])
+		if err != nil {
+			return
+		}
+		resp := newStun()
+		resp.Header.TransactionID = req.Header.TransactionID
+		resp.Header.MessageType = MessageType{
+			Class:  MsgTypeClassSuccess,
+			Method: req.Header.MessageType.Method,
+		}
+		data, err := resp.Serialize()
+		if err != nil {
+			return
+		}
+		if _, err := server.Write(data); err != nil {
+			return
+		}
+		methods <- req.Header.MessageType.Method
+	}()
+
+	var received atomic.Int64
+	relayDone := make(chan error, 1)
+	go func() {
+		relayDone <- a.Relay(func(payload []byte) error {
+			if len(payload) != 1 || payload[0] != 0xff {
+				t.Errorf("unexpected payload %02x", payload)
+			}
+			received.Add(1)
+			return nil
+		})
+	}()
+
+	select {
+	case method := <-methods:
+		if method != MsgTypeMethodChannelbind {
+			t.Fatalf("expected a ChannelBind, got %v", method)
+		}
+	case err := <-relayDone:
+		t.Fatalf("relay returned before the refresh: %v", err)
+	case <-time.After(5 * time.Second):
+		t.Fatal("the permission was not refreshed while the peer was busy")
+	}
+	// wait for the response to be processed
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Until(a.nextRefresh()) < time.Minute {
+		if time.Now().After(deadline) {
+			t.Fatalf("permission expiry was not renewed: %s", a.nextRefresh())
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	client.Close()
+	if err := <-relayDone; err == nil {
+		t.Error("expected an error after the connection was closed")
+	}
+	if received.Load() == 0 {
+		t.Error("no channel data was passed on")
+	}
+}
+
+func TestIsChannelData(t *testing.T) {
+	t.Parallel()
+	if !IsChannelData([]byte{0x40, 0x01, 0x00, 0x00}) {
+		t.Error("expected channel data")
+	}
+	if IsChannelData([]byte{0x01, 0x01, 0x00, 0x00}) {
+		t.Error("STUN message detected as channel data")
+	}
+	if IsChannelData([]byte{0x40}) {
+		t.Error("short buffer detected as channel data")
+	}
+}
+
+func TestTCPAllocationProbe(t *testing.T) {
+	t.Parallel()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		buf := make([]byte, 1024)
+		n, err := conn.Read(buf)
+		if err != nil {
+			return
+		}
+		req, err := fromBytes(buf[:n])
+		if err != nil {
+			return
+		}
+		// a late response to an earlier probe and the response to this one
+		// arrive in a single read
+		late := newStun()
+		late.Header.MessageType = MessageType{Class: MsgTypeClassError, Method: MsgTypeMethodConnect}
+		resp := newStun()
+		resp.Header.TransactionID = req.Header.TransactionID
+		resp.Header.MessageType = MessageType{Class: MsgTypeClassSuccess, Method: MsgTypeMethodConnect}
+		var data []byte
+		for _, s := range []*Stun{late, resp} {
+			b, err := s.Serialize()
+			if err != nil {
+				return
+			}
+			data = append(data, b...)
+		}
+		if _, err := conn.Write(data); err != nil {
+			return
+		}
+		// keep the connection open until the client is done
+		_, _ = conn.Read(buf)
+	}()
+
+	conn, err := net.Dial("tcp", listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	a := &TCPAllocation{
+		Control:  conn.(*net.TCPConn),
+		logger:   nilLogger{},
+		timeout:  time.Second,
+		username: "user",
+		password: "pass",
+	}
+	resp, _, err := a.Probe(netip.MustParseAddr("10.0.0.1"), 80, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.Header.MessageType.Class != MsgTypeClassSuccess {
+		t.Errorf("got the late response instead of the success response")
+	}
+}
+
+func TestTCPAllocationAccept(t *testing.T) {
+	t.Parallel()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+
+	connectionID := []byte{0x01, 0x02, 0x03, 0x04}
+	peer := netip.MustParseAddrPort("10.0.0.2:20")
+	bound := make(chan []byte, 1)
+	go func() {
+		defer close(bound)
+		control, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer control.Close()
+		// a late response and the connection attempt of the peer
+		late := newStun()
+		late.Header.MessageType = MessageType{Class: MsgTypeClassSuccess, Method: MsgTypeMethodRefresh}
+		attempt := newStun()
+		attempt.Header.MessageType = MessageType{Class: MsgTypeClassIndication, Method: MsgTypeMethodConnectionAttempt}
+		peerXOR, err := xorAddr(peer.Addr(), peer.Port(), []byte(attempt.Header.TransactionID))
+		if err != nil {
+			return
+		}
+		attempt.Attributes = []Attribute{
+			{Type: AttrConnectionID, Value: connectionID},
+			{Type: AttrXorPeerAddress, Value: peerXOR},
+		}
+		for _, s := range []*Stun{late, attempt} {
+			b, err := s.Serialize()
+			if err != nil {
+				return
+			}
+			if _, err := control.Write(b); err != nil {
+				return
+			}
+		}
+
+		data, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer data.Close()
+		buf := make([]byte, 1024)
+		n, err := data.Read(buf)
+		if err != nil {
+			return
+		}
+		req, err := fromBytes(buf[:n])
+		if err != nil {
+			return
+		}
+		bound <- req.GetAttribute(AttrConnectionID).Value
+		resp := newStun()
+		resp.Header.TransactionID = req.Header.TransactionID
+		resp.Header.MessageType = MessageType{Class: MsgTypeClassSuccess, Method: MsgTypeMethodConnectionBind}
+		b, err := resp.Serialize()
+		if err != nil {
+			return
+		}
+		if _, err := data.Write(b); err != nil {
+			return
+		}
+		// keep the connections open until the client is done
+		_, _ = data.Read(buf)
+	}()
+
+	conn, err := net.Dial("tcp", listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	a := &TCPAllocation{
+		Control:    conn.(*net.TCPConn),
+		logger:     nilLogger{},
+		turnServer: listener.Addr().String(),
+		timeout:    time.Second,
+		username:   "user",
+		password:   "pass",
+	}
+	dataConnection, from, err := a.Accept(time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dataConnection.Close()
+	if from != peer {
+		t.Errorf("expected peer %s but got %s", peer, from)
+	}
+	if id := <-bound; string(id) != string(connectionID) {
+		t.Errorf("expected connection id %02x to be bound but got %02x", connectionID, id)
+	}
+}
@@ -0,0 +1,92 @@
+package internal
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+)
+
+// ChannelData returns a ChannelData message carrying the payload on the
+// channel. Over TCP and TLS connections to the TURN server the message
+// needs to be padded to a multiple of 4 bytes so padded needs to be set.
+func ChannelData(channel []byte, payload []byte, padded bool) ([]byte, error) {
+	if len(channel) != 2 || channel[0]&0xc0 != 0x40 {
+		return nil, fmt.Errorf("invalid channel number %x", channel)
+	}
+	if len(payload) > math.MaxUint16 {
+		return nil, fmt.Errorf("payload of %d bytes exceeds the maximum of %d bytes", len(payload), math.MaxUint16)
+	}
+	length := 4 + len(payload)
+	if padded {
+		length = 4 + (len(payload)+3)&^3
+	}
+	buf := make([]byte, length)
+	copy(buf, channel)
+	binary.BigEndian.PutUint16(buf[2:4], uint16(len(payload)))
+	copy(buf[4:], payload)
+	return buf, nil
+}
+
+// WriteChannelData sends the payload on the channel as a single
+// ChannelData message. Set padded on TCP and TLS connections to the TURN
+// server. Payloads larger than the path MTU are not split, use
+// SplitPayload before if needed.
+func WriteChannelData(w io.Writer, channel []byte, payload []byte, padded bool) error {
+	buf, err := ChannelData(channel, payload, padded)
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(buf); err != nil {
+		return fmt.Errorf("could not write channel data: %w", err)
+	}
+	return nil
+}
+
+// ReadChannelData reads the next ChannelData message from the connection
+// to the TURN server and returns the channel and the payload. STUN messages
+// received in between, like refresh responses, are skipped.
+// On UDP connections every read returns a single datagram so padded must
+// not be set. On TCP and TLS connections the messages are read from the
+// stream one by one and padded needs to be set.
+// Deadlines need to be set on the connection by the caller.
+func ReadChannelData(r io.Reader, padded bool) ([]byte, []byte, error) {
+	if !padded {
+		buf := make([]byte, math.MaxUint16+4)
+		for {
+			n, err := r.Read(buf)
+			if err != nil {
+				return nil, nil, err
+			}
+			if !IsChannelData(buf[:n]) {
+				continue
+			}
+			channel, data, err := ExtractChannelData(buf[:n])
+			if err != nil {
+				return nil, nil, err
+			}
+			// do not hand out the read buffer
+			return append([]byte(nil), channel...), append([]byte(nil), data...), nil
+		}
+	}
+
+	header := make([]byte, 4)
+	for {
+		if _, err := io.ReadFull(r, header); err != nil {
+			return nil, nil, err
+		}
+		length := int(binary.BigEndian.Uint16(header[2:4]))
+		if !IsChannelData(header) {
+			// the length of STUN messages does not include the header
+			if _, err := io.CopyN(io.Discard, r, int64(headerSize-4+length)); err != nil {
+				return nil, nil, err
+			}
+			continue
+		}
+		data := make([]byte, (length+3)&^3)
+		if _, err := io.ReadFull(r, data); err != nil {
+			return nil, nil, err
+		}
+		return append([]byte(nil), header[:2]...), data[:length], nil
+	}
+}
@@ -0,0 +1,106 @@
+package internal
+
+import (
+	"bytes"
+	"io"
+	"testing"
+)
+
+func TestChannelData(t *testing.T) {
+	t.Parallel()
+	channel := []byte{0x40, 0x01}
+
+	buf, err := ChannelData(channel, []byte("hello"), false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []byte{0x40, 0x01, 0x00, 0x05, 'h', 'e', 'l', 'l', 'o'}
+	if !bytes.Equal(buf, expected) {
+		t.Errorf("expected %x, got %x", expected, buf)
+	}
+
+	buf, err = ChannelData(channel, []byte("hello"), true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected = append(expected, 0x00, 0x00, 0x00)
+	if !bytes.Equal(buf, expected) {
+		t.Errorf("expected %x, got %x", expected, buf)
+	}
+
+	if _, err := ChannelData([]byte{0x00, 0x01}, nil, false); err == nil {
+		t.Error("expected an error on an invalid channel number")
+	}
+	if _, err := ChannelData(channel, make([]byte, 65536), false); err == nil {
+		t.Error("expected an error on an oversized payload")
+	}
+}
+
+func TestReadChannelDataStream(t *testing.T) {
+	t.Parallel()
+	channel := []byte{0x40, 0x01}
+	var stream bytes.Buffer
+	if err := WriteChannelData(&stream, channel, []byte("abc"), true); err != nil {
+		t.Fatal(err)
+	}
+	// a STUN message in between is skipped
+	stun, err := RefreshRequest("user", "pass", "nonce", "realm").Serialize()
+	if err != nil {
+		t.Fatal(err)
+	}
+	stream.Write(stun)
+	if err := WriteChannelData(&stream, channel, []byte("defgh"), true); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, expected := range []string{"abc", "defgh"} {
+		c, data, err := ReadChannelData(&stream, true)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(c, channel) {
+			t.Errorf("expected channel %x, got %x", channel, c)
+		}
+		if string(data) != expected {
+			t.Errorf("expected %q, got %q", expected, data)
+		}
+	}
+	if _, _, err := ReadChannelData(&stream, true); err != io.EOF {
+		t.Errorf("expected EOF, got %v", err)
+	}
+}
+
+// datagramReader returns a single datagram on every read
+type datagramReader struct {
+	datagrams [][]byte
+}
+
+func (d *datagramReader) Read(p []byte) (int, error) {
+	if len(d.datagrams) == 0 {
+		return 0, io.EOF
+	}
+	n := copy(p, d.datagrams[0])
+	d.datagrams = d.datagrams[1:]
+	return n, nil
+}
+
+func TestReadChannelDataDatagram(t *testing.T) {
+	t.Parallel()
+	channel := []byte{0x40, 0x01}
+	msg, err := ChannelData(channel, []byte("hello"), false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stun, err := RefreshRequest("user", "pass", "nonce", "realm").Serialize()
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := &datagramReader{datagrams: [][]byte{stun, msg}}
+	c, data, err := ReadChannelData(r, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(c, channel) || string(data) != "hello" {
+		t.Errorf("unexpected channel %x and payload %q", c, data)
+	}
+}
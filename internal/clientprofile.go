@@ -0,0 +1,118 @@
+package internal
+
+import (
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ClientProfile describes how a WebRTC stack builds and sends its TURN
+// requests. Relays and middleboxes sometimes only accept clients that look
+// like a browser, and servers may behave differently depending on the
+// attributes they see. The values approximate the defaults of the stacks
+type ClientProfile struct {
+	Name string
+	// Order are the attributes in the order the stack sends them. Attributes
+	// not in the list keep their position after the listed ones
+	Order []AttributeType
+	// Fingerprint adds a FINGERPRINT attribute to all requests
+	Fingerprint bool
+	// RTO is the initial retransmission timeout for unanswered requests on
+	// UDP and DTLS. It is doubled after every retransmission
+	RTO time.Duration
+}
+
+var clientProfiles = map[string]ClientProfile{
+	// libwebrtc as used by Chrome and most native apps
+	"chrome": {
+		Order:       []AttributeType{AttrRequestedTransport, AttrRequestedAddressFamily, AttrXorPeerAddress, AttrChannelNumber, AttrLifetime, AttrUsername, AttrRealm, AttrNonce},
+		Fingerprint: true,
+		RTO:         250 * time.Millisecond,
+	},
+	// nICEr as used by Firefox
+	"firefox": {
+		Order:       []AttributeType{AttrUsername, AttrRealm, AttrNonce, AttrRequestedTransport, AttrRequestedAddressFamily, AttrXorPeerAddress, AttrChannelNumber, AttrLifetime},
+		Fingerprint: false,
+		RTO:         100 * time.Millisecond,
+	},
+	"pion": {
+		Order:       []AttributeType{AttrRequestedTransport, AttrRequestedAddressFamily, AttrXorPeerAddress, AttrChannelNumber, AttrLifetime, AttrUsername, AttrRealm, AttrNonce},
+		Fingerprint: true,
+		RTO:         200 * time.Millisecond,
+	},
+}
+
+var (
+	clientProfileMu sync.RWMutex
+	clientProfile   *ClientProfile
+)
+
+// ClientProfileNames returns the names of all available client profiles
+func ClientProfileNames() []string {
+	names := make([]string, 0, len(clientProfiles))
+	for name := range clientProfiles {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// GetClientProfile returns the client profile with the given name
+func GetClientProfile(name string) (*ClientProfile, error) {
+	p, ok := clientProfiles[strings.ToLower(name)]
+	if !ok {
+		return nil, fmt.Errorf("invalid client profile %q. Supported values: %s", name, strings.Join(ClientProfileNames(), ", "))
+	}
+	p.Name = strings.ToLower(name)
+	return &p, nil
+}
+
+// SetClientProfile applies the profile to all following requests. nil
+// restores the default behaviour
+func SetClientProfile(p *ClientProfile) {
+	clientProfileMu.Lock()
+	defer clientProfileMu.Unlock()
+	clientProfile = p
+}
+
+func currentClientProfile() *ClientProfile {
+	clientProfileMu.RLock()
+	defer clientProfileMu.RUnlock()
+	return clientProfile
+}
+
+// applyClientProfile reorders the attributes of requests and enables the
+// fingerprint according to the current profile. It needs to be called
+// before serializing
+func applyClientProfile(s *Stun) {
+	p := currentClientProfile()
+	if p == nil || s.Header.MessageType.Class != MsgTypeClassRequest {
+		return
+	}
+	rank := func(t AttributeType) int {
+		for i, o := range p.Order {
+			if o == t {
+				return i
+			}
+		}
+		return len(p.Order)
+	}
+	sort.SliceStable(s.Attributes, func(i, j int) bool {
+		return rank(s.Attributes[i].Type) < rank(s.Attributes[j].Type)
+	})
+	s.Fingerprint = p.Fingerprint
+}
+
+// retransmitTimeout returns the initial retransmission timeout on the
+// connection or 0 if requests should not be retransmitted. Streams are
+// reliable so only datagram connections retransmit
+func retransmitTimeout(conn net.Conn) time.Duration {
+	p := currentClientProfile()
+	if p == nil || isStream(conn) {
+		return 0
+	}
+	return p.RTO
+}
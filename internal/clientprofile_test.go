@@ -0,0 +1,72 @@
+package internal
+
+import (
+	"bytes"
+	"encoding/binary"
+	"hash/crc32"
+	"testing"
+)
+
+// no t.Parallel as the client profile is global
+func TestApplyClientProfile(t *testing.T) {
+	p, err := GetClientProfile("Firefox")
+	if err != nil {
+		t.Fatal(err)
+	}
+	SetClientProfile(p)
+	defer SetClientProfile(nil)
+
+	req := AllocateRequestAuth("user", "pass", "nonce", "realm", RequestedTransportUDP, AllocateProtocolIgnore)
+	req.Attributes = append(req.Attributes, Attribute{Type: AttrSoftware, Value: []byte("test")})
+	applyClientProfile(req)
+	var got []AttributeType
+	for _, a := range req.Attributes {
+		got = append(got, a.Type)
+	}
+	want := []AttributeType{AttrUsername, AttrRealm, AttrNonce, AttrRequestedTransport, AttrSoftware}
+	if len(got) != len(want) {
+		t.Fatalf("got attributes %v, want %v", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("got attributes %v, want %v", got, want)
+		}
+	}
+
+	if _, err := GetClientProfile("netscape"); err == nil {
+		t.Error("expected an error for an unknown profile")
+	}
+}
+
+func TestSerializeFingerprint(t *testing.T) {
+	t.Parallel()
+
+	req := AllocateRequestAuth("user", "pass", "nonce", "realm", RequestedTransportUDP, AllocateProtocolIgnore)
+	req.Fingerprint = true
+	buf, err := req.Serialize()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got := int(binary.BigEndian.Uint16(buf[2:4])); got != len(buf)-headerSize {
+		t.Fatalf("header length is %d, want %d", got, len(buf)-headerSize)
+	}
+	fp := buf[len(buf)-8:]
+	if AttributeType(binary.BigEndian.Uint16(fp[0:2])) != AttrFingerprint {
+		t.Fatalf("last attribute is %02x, want FINGERPRINT", fp[0:2])
+	}
+	if crc := crc32.ChecksumIEEE(buf[:len(buf)-8]) ^ fingerprint; binary.BigEndian.Uint32(fp[4:]) != crc {
+		t.Errorf("invalid fingerprint %02x", fp[4:])
+	}
+
+	// the message integrity is calculated with the length excluding the fingerprint
+	msg := append([]byte{}, buf[:len(buf)-8]...)
+	binary.BigEndian.PutUint16(msg[2:4], uint16(len(msg)-headerSize))
+	integrity, err := calculateMessageIntegrity(msg[:len(msg)-24], "user", "realm", "pass")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(msg[len(msg)-20:], integrity) {
+		t.Error("message integrity does not match")
+	}
+}
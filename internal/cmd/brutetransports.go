@@ -1,11 +1,17 @@
 package cmd
 
 import (
+	"errors"
 	"fmt"
+	"net"
+	"sort"
+	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/firefart/stunner/internal"
+	"github.com/firefart/stunner/internal/results"
 	"github.com/sirupsen/logrus"
 )
 
@@ -18,6 +24,16 @@ type BruteTransportOpts struct {
 	TlsVerify  bool
 	Timeout    time.Duration
 	Log        *logrus.Logger
+	// Transports and Ports are the combinations to connect to the TURN
+	// server with. Without ports the port of TurnServer is used
+	Transports []internal.Transport
+	Ports      []string
+	// Workers is the number of requests sent in parallel
+	Workers int
+	// SkipOtherAddresses disables connecting to the endpoints announced in
+	// OTHER-ADDRESS and ALTERNATE-SERVER
+	SkipOtherAddresses bool
+	Output             string
 }
 
 func (opts BruteTransportOpts) Validate() error {
@@ -39,56 +55,310 @@ func (opts BruteTransportOpts) Validate() error {
 	if opts.Log == nil {
 		return fmt.Errorf("please supply a valid logger")
 	}
+	for _, port := range opts.Ports {
+		if _, err := strconv.ParseUint(strings.TrimSpace(port), 10, 16); err != nil {
+			return fmt.Errorf("invalid port %q: %w", port, err)
+		}
+	}
+	if opts.Workers < 1 {
+		return fmt.Errorf("please supply a valid number of workers")
+	}
 
 	return nil
 }
 
+// transportCombination is a way to connect to the TURN server
+type transportCombination struct {
+	Transport internal.Transport
+	Server    string
+	// Announced is set for endpoints announced by the TURN server
+	Announced bool
+}
+
+// combinationEvidence is what the TURN server revealed on a combination
+type combinationEvidence struct {
+	transportCombination
+	// Handshake is the error of the connection or the TLS/DTLS handshake
+	Handshake error
+	// AuthRequired is set if unauthenticated allocations are rejected
+	AuthRequired bool
+	Realm        string
+	Software     string
+	Error        error
+}
+
+// requestedTransportResult is the answer of the TURN server to an allocation
+// with a REQUESTED-TRANSPORT
+type requestedTransportResult struct {
+	Transport internal.RequestedTransport
+	Supported bool
+	Result    string
+}
+
+// BruteTransports connects to the TURN server on all combinations of
+// transports and ports and tries all REQUESTED-TRANSPORT values on the
+// transport given by Protocol and UseTLS
 func BruteTransports(opts BruteTransportOpts) error {
 	if err := opts.Validate(); err != nil {
 		return err
 	}
 
-	for i := 0; i <= 255; i++ {
-		conn, err := internal.Connect(opts.Protocol, opts.TurnServer, opts.UseTLS, opts.TlsVerify, opts.Timeout)
-		if err != nil {
+	writer, err := results.NewWriter(opts.Output)
+	if err != nil {
+		return err
+	}
+	defer writer.Close()
+
+	host, defaultPort, err := net.SplitHostPort(opts.TurnServer)
+	if err != nil {
+		return fmt.Errorf("invalid turnserver %s: %w", opts.TurnServer, err)
+	}
+	ports := opts.Ports
+	if len(ports) == 0 {
+		ports = []string{defaultPort}
+	}
+	transports := opts.Transports
+	if len(transports) == 0 {
+		transports = []internal.Transport{{Protocol: opts.Protocol, TLS: opts.UseTLS}}
+	}
+	var combinations []transportCombination
+	for _, port := range ports {
+		for _, t := range transports {
+			combinations = append(combinations, transportCombination{
+				Transport: t,
+				Server:    net.JoinHostPort(host, strings.TrimSpace(port)),
+			})
+		}
+	}
+	if !opts.SkipOtherAddresses {
+		combinations = append(combinations, announcedCombinations(opts, transports)...)
+	}
+
+	evidence := make([]combinationEvidence, len(combinations))
+	parallel(opts.Workers, len(combinations), func(i int) {
+		evidence[i] = probeCombination(opts, combinations[i])
+	})
+	for _, e := range evidence {
+		details := map[string]string{
+			"transport": e.Transport.String(),
+			"handshake": "ok",
+		}
+		if e.Announced {
+			details["source"] = "other-address"
+		}
+		switch {
+		case e.Handshake != nil:
+			details["handshake"] = e.Handshake.Error()
+			opts.Log.Infof("%s via %s: no connection: %v", e.Server, e.Transport, e.Handshake)
+		case e.Error != nil:
+			opts.Log.Warnf("%s via %s: connected but no TURN response: %v", e.Server, e.Transport, e.Error)
+		default:
+			details["auth"] = "required"
+			if !e.AuthRequired {
+				details["auth"] = "not required"
+			}
+			details["realm"] = e.Realm
+			details["software"] = e.Software
+			opts.Log.Infof("%s via %s: TURN server found, authentication %s, realm %q, software %q", e.Server, e.Transport, details["auth"], e.Realm, e.Software)
+			if !e.AuthRequired {
+				opts.Log.Warnf("%s via %s allows allocations without authentication", e.Server, e.Transport)
+			}
+		}
+		finding := results.Finding{
+			Module:    "brute-transports",
+			Relay:     opts.TurnServer,
+			Host:      e.Server,
+			Protocol:  e.Transport.Protocol,
+			Service:   "combination",
+			Details:   details,
+			Transport: e.Transport.String(),
+		}
+		if _, port, err := net.SplitHostPort(e.Server); err == nil {
+			if p, err := strconv.ParseUint(port, 10, 16); err == nil {
+				finding.Port = uint16(p)
+			}
+		}
+		if e.Error != nil {
+			finding.Error = e.Error.Error()
+		}
+		if err := writer.Write(finding); err != nil {
 			return err
 		}
+	}
 
-		x := internal.RequestedTransport(uint32(i))
-		allocateRequest := internal.AllocateRequest(x, internal.AllocateProtocolIgnore)
-		allocateResponse, err := allocateRequest.SendAndReceive(opts.Log, conn, opts.Timeout)
+	opts.Log.Infof("trying all requested transports via %s", internal.Transport{Protocol: opts.Protocol, TLS: opts.UseTLS})
+	requested := make([]requestedTransportResult, 256)
+	var firstErr error
+	var errMu sync.Mutex
+	parallel(opts.Workers, len(requested), func(i int) {
+		r, err := probeRequestedTransport(opts, internal.RequestedTransport(uint32(i)))
 		if err != nil {
-			return fmt.Errorf("error on sending allocate request: %w", err)
+			errMu.Lock()
+			if firstErr == nil {
+				firstErr = err
+			}
+			errMu.Unlock()
+			return
 		}
+		requested[i] = r
+	})
+	if firstErr != nil {
+		return firstErr
+	}
 
-		realm := string(allocateResponse.GetAttribute(internal.AttrRealm).Value)
-		nonce := string(allocateResponse.GetAttribute(internal.AttrNonce).Value)
+	// group the rejections to not log 256 lines
+	rejected := make(map[string][]string)
+	for i, r := range requested {
+		if !r.Supported {
+			rejected[r.Result] = append(rejected[r.Result], strconv.Itoa(i))
+			continue
+		}
+		switch r.Transport {
+		case internal.RequestedTransportTCP:
+			opts.Log.Infof("Found supported protocol %d which is TCP and a default protocol", i)
+		case internal.RequestedTransportUDP:
+			opts.Log.Infof("Found supported protocol %d which is UDP and a default protocol", i)
+		default:
+			opts.Log.Warnf("Found non standard protocol %d", i)
+		}
+		if err := writer.Write(results.Finding{
+			Module:    "brute-transports",
+			Relay:     opts.TurnServer,
+			Host:      opts.TurnServer,
+			Protocol:  opts.Protocol,
+			Service:   "requested-transport",
+			Details:   map[string]string{"requested_transport": strconv.Itoa(i)},
+			Transport: internal.Transport{Protocol: opts.Protocol, TLS: opts.UseTLS}.String(),
+		}); err != nil {
+			return err
+		}
+	}
+	var reasons []string
+	for reason := range rejected {
+		reasons = append(reasons, reason)
+	}
+	sort.Strings(reasons)
+	for _, reason := range reasons {
+		opts.Log.Debugf("rejected with %s: %s", reason, strings.Join(rejected[reason], ","))
+		opts.Log.Infof("%d requested transports rejected with %s", len(rejected[reason]), reason)
+	}
+	return nil
+}
 
-		allocateRequest = internal.AllocateRequestAuth(opts.Username, opts.Password, nonce, realm, x, internal.AllocateProtocolIgnore)
-		allocateResponse, err = allocateRequest.SendAndReceive(opts.Log, conn, opts.Timeout)
+// announcedCombinations returns the combinations of all transports with the
+// endpoints the TURN server announces. The announced hosts are also tried
+// on all requested ports
+func announcedCombinations(opts BruteTransportOpts, transports []internal.Transport) []transportCombination {
+	endpoints, err := discoverOtherAddresses(opts.Log, opts.Protocol, opts.TurnServer, opts.UseTLS, opts.TlsVerify, opts.Timeout)
+	if err != nil {
+		opts.Log.Debugf("could not discover other addresses: %v", err)
+		return nil
+	}
+	var ret []transportCombination
+	seen := make(map[string]bool)
+	for _, endpoint := range endpoints {
+		host, port, err := net.SplitHostPort(endpoint)
 		if err != nil {
-			return fmt.Errorf("error on sending allocate request auth: %w", err)
+			continue
 		}
-		if allocateResponse.Header.MessageType.Class != internal.MsgTypeClassSuccess {
-			errorCode := allocateResponse.GetAttribute(internal.AttrErrorCode).Value[4:]
-			opts.Log.Errorf("%d %s", i, string(errorCode))
-			if allocateResponse.Header.MessageType.Class != internal.MsgTypeClassError {
-				opts.Log.Infof("%d %02x", i, allocateResponse.Header.MessageType)
+		for _, p := range append([]string{port}, opts.Ports...) {
+			server := net.JoinHostPort(host, strings.TrimSpace(p))
+			if seen[server] {
+				continue
 			}
-		} else {
-			// valid transport found
-			switch x {
-			case internal.RequestedTransportTCP:
-				opts.Log.Infof("Found supported protocol %d which is TCP and a default protocol", i)
-			case internal.RequestedTransportUDP:
-				opts.Log.Infof("Found supported protocol %d which is UDP and a default protocol", i)
-			default:
-				opts.Log.Infof("Found non standard protocol %d", i)
+			seen[server] = true
+			for _, t := range transports {
+				ret = append(ret, transportCombination{
+					Transport: t,
+					Server:    server,
+					Announced: true,
+				})
 			}
 		}
-		if err := conn.Close(); err != nil {
-			return fmt.Errorf("error on closing connection: %w", err)
+	}
+	return ret
+}
+
+// probeCombination connects to the TURN server and sends an
+// unauthenticated allocate request
+func probeCombination(opts BruteTransportOpts, c transportCombination) combinationEvidence {
+	e := combinationEvidence{transportCombination: c}
+	conn, err := internal.Connect(c.Transport.Protocol, c.Server, c.Transport.TLS, opts.TlsVerify, opts.Timeout)
+	if err != nil {
+		e.Handshake = err
+		return e
+	}
+	defer conn.Close()
+
+	allocateRequest := internal.AllocateRequest(internal.RequestedTransportUDP, internal.AllocateProtocolIgnore)
+	allocateResponse, err := allocateRequest.SendAndReceive(opts.Log, conn, opts.Timeout)
+	if err != nil {
+		e.Error = fmt.Errorf("error on sending allocate request: %w", err)
+		return e
+	}
+	e.Software = string(allocateResponse.GetAttribute(internal.AttrSoftware).Value)
+	e.Realm = string(allocateResponse.GetAttribute(internal.AttrRealm).Value)
+	if allocateResponse.Header.MessageType.Class == internal.MsgTypeClassError {
+		var respErr *internal.ResponseError
+		if errors.As(allocateResponse.GetError(), &respErr) && respErr.Code != internal.ErrorUnauthorized {
+			e.Error = fmt.Errorf("unexpected allocate response: %w", respErr)
+			return e
 		}
+		e.AuthRequired = true
 	}
-	return nil
+	return e
+}
+
+// probeRequestedTransport requests an allocation with the transport
+func probeRequestedTransport(opts BruteTransportOpts, x internal.RequestedTransport) (requestedTransportResult, error) {
+	r := requestedTransportResult{Transport: x}
+	conn, err := internal.Connect(opts.Protocol, opts.TurnServer, opts.UseTLS, opts.TlsVerify, opts.Timeout)
+	if err != nil {
+		return r, err
+	}
+	defer conn.Close()
+
+	allocateRequest := internal.AllocateRequest(x, internal.AllocateProtocolIgnore)
+	allocateResponse, err := allocateRequest.SendAndReceive(opts.Log, conn, opts.Timeout)
+	if err != nil {
+		return r, fmt.Errorf("error on sending allocate request: %w", err)
+	}
+
+	realm := string(allocateResponse.GetAttribute(internal.AttrRealm).Value)
+	nonce := string(allocateResponse.GetAttribute(internal.AttrNonce).Value)
+
+	allocateRequest = internal.AllocateRequestAuth(opts.Username, opts.Password, nonce, realm, x, internal.AllocateProtocolIgnore)
+	allocateResponse, err = allocateRequest.SendAndReceive(opts.Log, conn, opts.Timeout)
+	if err != nil {
+		return r, fmt.Errorf("error on sending allocate request auth: %w", err)
+	}
+	switch allocateResponse.Header.MessageType.Class {
+	case internal.MsgTypeClassSuccess:
+		r.Supported = true
+	case internal.MsgTypeClassError:
+		r.Result = allocateResponse.GetErrorString()
+	default:
+		r.Result = fmt.Sprintf("message type %02x", allocateResponse.Header.MessageType)
+	}
+	return r, nil
+}
+
+// parallel calls f for all indexes below n with the given number of workers
+func parallel(workers, n int, f func(i int)) {
+	indexes := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range indexes {
+				f(i)
+			}
+		}()
+	}
+	for i := 0; i < n; i++ {
+		indexes <- i
+	}
+	close(indexes)
+	wg.Wait()
 }
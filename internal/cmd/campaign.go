@@ -0,0 +1,314 @@
+package cmd
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/firefart/stunner/internal"
+	"github.com/firefart/stunner/internal/helper"
+	"github.com/firefart/stunner/internal/results"
+	"github.com/sirupsen/logrus"
+)
+
+// campaignChecks are the checks that can be run against every target of a
+// campaign
+var campaignChecks = map[string]string{
+	"auth":    "check if the credentials are accepted and the realm matches",
+	"defense": "run the checks of the defense-check command",
+}
+
+// CampaignCheckNames returns the names of all campaign checks
+func CampaignCheckNames() []string {
+	var names []string
+	for name := range campaignChecks {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+type CampaignOpts struct {
+	Targets []helper.CampaignTarget
+	// Protocol and UseTLS are used for targets that are no TURN URI
+	Protocol  string
+	UseTLS    bool
+	TlsVerify bool
+	Timeout   time.Duration
+	Log       *logrus.Logger
+	Checks    []string
+	// Workers is the number of targets checked in parallel
+	Workers int
+	// Delay is the time to wait between two checks on the same target
+	Delay            time.Duration
+	QuotaAllocations int
+	Output           string
+}
+
+func (opts CampaignOpts) Validate() error {
+	if len(opts.Targets) == 0 {
+		return fmt.Errorf("please supply valid targets")
+	}
+	if opts.Protocol != "tcp" && opts.Protocol != "udp" {
+		return fmt.Errorf("protocol needs to be either tcp or udp")
+	}
+	if opts.Log == nil {
+		return fmt.Errorf("please supply a valid logger")
+	}
+	if len(opts.Checks) == 0 {
+		return fmt.Errorf("please supply valid checks")
+	}
+	for _, check := range opts.Checks {
+		if _, ok := campaignChecks[check]; !ok {
+			return fmt.Errorf("invalid check %q. Supported values: %s", check, strings.Join(CampaignCheckNames(), ", "))
+		}
+	}
+	if opts.Workers < 1 {
+		return fmt.Errorf("please supply a valid number of workers")
+	}
+	if opts.Delay < 0 {
+		return fmt.Errorf("delay can not be negative")
+	}
+	if opts.QuotaAllocations < 1 {
+		return fmt.Errorf("please supply a valid number of quota allocations")
+	}
+	return nil
+}
+
+// campaignResult is the outcome of all checks against a single target
+type campaignResult struct {
+	TurnServer string
+	Auth       checkStatus
+	// Failed is the number of failed defense checks, -1 if not run
+	Failed int
+	Error  string
+}
+
+// Campaign runs the checks against a fleet of TURN servers with their own
+// credentials and writes all findings to a single report
+func Campaign(opts CampaignOpts) error {
+	if err := opts.Validate(); err != nil {
+		return err
+	}
+
+	writer, err := results.NewWriter(opts.Output)
+	if err != nil {
+		return err
+	}
+	defer writer.Close()
+
+	checks := make(map[string]bool, len(opts.Checks))
+	for _, check := range opts.Checks {
+		checks[check] = true
+	}
+
+	targets := make(chan helper.CampaignTarget)
+	resultsChan := make(chan campaignResult, len(opts.Targets))
+	var wg sync.WaitGroup
+	for i := 0; i < opts.Workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for target := range targets {
+				resultsChan <- campaignTarget(opts, target, checks, writer)
+			}
+		}()
+	}
+	for _, target := range opts.Targets {
+		targets <- target
+	}
+	close(targets)
+	wg.Wait()
+	close(resultsChan)
+
+	var campaignResults []campaignResult
+	for r := range resultsChan {
+		campaignResults = append(campaignResults, r)
+	}
+	sort.Slice(campaignResults, func(i, j int) bool {
+		return campaignResults[i].TurnServer < campaignResults[j].TurnServer
+	})
+
+	opts.Log.Infof("campaign report for %d targets:", len(campaignResults))
+	for _, r := range campaignResults {
+		defense := "not run"
+		if r.Failed >= 0 {
+			defense = fmt.Sprintf("%d of %d failed", r.Failed, len(defenseChecks))
+		}
+		line := fmt.Sprintf("\t%-40s auth: %-7s defense checks: %s", r.TurnServer, r.Auth, defense)
+		if r.Error != "" {
+			opts.Log.Warnf("%s (%s)", line, r.Error)
+		} else {
+			opts.Log.Info(line)
+		}
+
+		details := map[string]string{
+			"auth": string(r.Auth),
+		}
+		if r.Failed >= 0 {
+			details["failed_checks"] = strconv.Itoa(r.Failed)
+		}
+		if err := writer.Write(results.Finding{
+			Module:  "campaign",
+			Relay:   r.TurnServer,
+			Host:    r.TurnServer,
+			Service: "summary",
+			Details: details,
+			Error:   r.Error,
+		}); err != nil {
+			return err
+		}
+	}
+	if opts.Output != "" {
+		opts.Log.Infof("results written to %s", opts.Output)
+	}
+	return nil
+}
+
+// campaignTarget runs the checks against a single target
+func campaignTarget(opts CampaignOpts, target helper.CampaignTarget, checks map[string]bool, writer *results.Writer) campaignResult {
+	defenseOpts := DefenseCheckOpts{
+		TurnServer:       target.TurnServer,
+		Protocol:         opts.Protocol,
+		Username:         target.Username,
+		Password:         target.Password,
+		UseTLS:           opts.UseTLS,
+		TlsVerify:        opts.TlsVerify,
+		Timeout:          opts.Timeout,
+		Log:              targetLogger(opts.Log, target.TurnServer),
+		QuotaAllocations: opts.QuotaAllocations,
+		Delay:            opts.Delay,
+	}
+	result := campaignResult{
+		TurnServer: target.TurnServer,
+		Auth:       "not run",
+		Failed:     -1,
+	}
+	if helper.IsTurnURI(target.TurnServer) {
+		uri, err := helper.ParseTurnURI(target.TurnServer)
+		if err != nil {
+			result.Error = err.Error()
+			return result
+		}
+		defenseOpts.TurnServer = uri.Server()
+		defenseOpts.Protocol = uri.Protocol
+		defenseOpts.UseTLS = uri.TLS
+	}
+	if err := defenseOpts.Validate(); err != nil {
+		result.Error = err.Error()
+		return result
+	}
+
+	if checks["auth"] {
+		status, realm, detail := checkCampaignAuth(defenseOpts, target.Realm)
+		result.Auth = status
+		switch status {
+		case checkPass:
+			defenseOpts.Log.Infof("[%s] credentials accepted: %s", status, detail)
+		case checkFail:
+			defenseOpts.Log.Warnf("[%s] credentials rejected: %s", status, detail)
+		default:
+			defenseOpts.Log.Errorf("[%s] could not check the credentials: %s", status, detail)
+		}
+		if err := writer.Write(results.Finding{
+			Module:  "campaign",
+			Relay:   defenseOpts.TurnServer,
+			Host:    defenseOpts.TurnServer,
+			Service: "auth",
+			Details: map[string]string{
+				"status": string(status),
+				"detail": detail,
+				"realm":  realm,
+			},
+		}); err != nil {
+			result.Error = err.Error()
+			return result
+		}
+		// the other checks need working credentials
+		if status != checkPass {
+			return result
+		}
+	}
+
+	if checks["defense"] {
+		if checks["auth"] {
+			time.Sleep(opts.Delay)
+		}
+		_, failed, err := runDefenseChecks(defenseOpts, writer)
+		if err != nil {
+			result.Error = err.Error()
+			return result
+		}
+		result.Failed = failed
+	}
+	return result
+}
+
+// checkCampaignAuth allocates a relay with the credentials of the target
+// and compares the realm. It returns the status, the realm of the server
+// and details
+func checkCampaignAuth(opts DefenseCheckOpts, expectedRealm string) (checkStatus, string, string) {
+	conn, err := internal.Connect(opts.Protocol, opts.TurnServer, opts.UseTLS, opts.TlsVerify, opts.Timeout)
+	if err != nil {
+		return checkError, "", err.Error()
+	}
+	defer conn.Close()
+
+	allocateRequest := internal.AllocateRequest(internal.RequestedTransportUDP, internal.AllocateProtocolIgnore)
+	allocateResponse, err := allocateRequest.SendAndReceive(opts.Log, conn, opts.Timeout)
+	if err != nil {
+		return checkError, "", fmt.Sprintf("error on sending allocate request: %v", err)
+	}
+	if allocateResponse.Header.MessageType.Class != internal.MsgTypeClassError {
+		return checkPass, "", "the server does not require authentication"
+	}
+	realm := string(allocateResponse.GetAttribute(internal.AttrRealm).Value)
+	nonce := string(allocateResponse.GetAttribute(internal.AttrNonce).Value)
+	if expectedRealm != "" && realm != expectedRealm {
+		opts.Log.Warnf("the server uses the realm %q instead of %q", realm, expectedRealm)
+	}
+
+	allocateRequest = internal.AllocateRequestAuth(opts.Username, opts.Password, nonce, realm, internal.RequestedTransportUDP, internal.AllocateProtocolIgnore)
+	allocateResponse, err = allocateRequest.SendAndReceive(opts.Log, conn, opts.Timeout)
+	if err != nil {
+		return checkError, realm, fmt.Sprintf("error on sending authenticated allocate request: %v", err)
+	}
+	if allocateResponse.Header.MessageType.Class == internal.MsgTypeClassError {
+		return checkFail, realm, allocateResponse.GetErrorString()
+	}
+	return checkPass, realm, fmt.Sprintf("allocation granted in realm %q", realm)
+}
+
+// campaignHook adds the TURN server to every log entry so the logs of
+// targets checked in parallel can be told apart
+type campaignHook struct {
+	relay string
+}
+
+func (h campaignHook) Levels() []logrus.Level {
+	return logrus.AllLevels
+}
+
+func (h campaignHook) Fire(entry *logrus.Entry) error {
+	entry.Data["relay"] = h.relay
+	return nil
+}
+
+// targetLogger returns a copy of log that adds the TURN server to every
+// log entry
+func targetLogger(log *logrus.Logger, relay string) *logrus.Logger {
+	l := logrus.New()
+	l.SetOutput(log.Out)
+	l.SetFormatter(log.Formatter)
+	l.SetLevel(log.GetLevel())
+	for _, hooks := range log.Hooks {
+		for _, hook := range hooks {
+			l.AddHook(hook)
+		}
+	}
+	l.AddHook(campaignHook{relay: relay})
+	return l
+}
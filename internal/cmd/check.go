@@ -0,0 +1,303 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/netip"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/firefart/stunner/internal"
+	"github.com/firefart/stunner/internal/helper"
+	"github.com/firefart/stunner/internal/results"
+	"github.com/sirupsen/logrus"
+)
+
+type CheckOpts struct {
+	TurnServer string
+	Protocol   string
+	Username   string
+	Password   string
+	UseTLS     bool
+	TlsVerify  bool
+	Timeout    time.Duration
+	Log        *logrus.Logger
+	// Target is a known-good UDP service the relay should be able to reach
+	Target netip.AddrPort
+	// Payload is sent to the target. If empty a DNS query is sent so the
+	// target needs to be a DNS server
+	Payload []byte
+	Output  string
+}
+
+func (opts CheckOpts) Validate() error {
+	if opts.TurnServer == "" {
+		return fmt.Errorf("need a valid turnserver")
+	}
+	if !strings.Contains(opts.TurnServer, ":") {
+		return fmt.Errorf("turnserver needs a port")
+	}
+	if opts.Protocol != "tcp" && opts.Protocol != "udp" {
+		return fmt.Errorf("protocol needs to be either tcp or udp")
+	}
+	if opts.Username == "" {
+		return fmt.Errorf("please supply a username")
+	}
+	if opts.Password == "" {
+		return fmt.Errorf("please supply a password")
+	}
+	if opts.Log == nil {
+		return fmt.Errorf("please supply a valid logger")
+	}
+	if !opts.Target.IsValid() {
+		return fmt.Errorf("please supply a valid target")
+	}
+
+	return nil
+}
+
+// checkSkip means the check was not run because a previous one failed
+const checkSkip checkStatus = "SKIP"
+
+// checkResult is a row of the check matrix
+type checkResult struct {
+	name   string
+	status checkStatus
+	detail string
+}
+
+// checkSession holds the state shared by the steps of Check
+type checkSession struct {
+	opts    CheckOpts
+	conn    net.Conn
+	realm   string
+	nonce   string
+	channel []byte
+	// allocated is set once the allocation needs to be deleted
+	allocated bool
+}
+
+// Check runs the steps needed to relay data through the TURN server one
+// after the other and prints a PASS/FAIL matrix. A step is only run if all
+// previous steps passed
+func Check(opts CheckOpts) error {
+	if err := opts.Validate(); err != nil {
+		return err
+	}
+
+	writer, err := results.NewWriter(opts.Output)
+	if err != nil {
+		return err
+	}
+	defer writer.Close()
+
+	s := &checkSession{opts: opts}
+	defer s.close()
+
+	steps := []struct {
+		name string
+		run  func() (checkStatus, string)
+	}{
+		{"connect", s.connect},
+		{"auth-required", s.authRequired},
+		{"credentials", s.allocate},
+		{"permission", s.permission},
+		{"channel-bind", s.channelBind},
+		{"data", s.exchange},
+	}
+
+	var matrix []checkResult
+	failed := false
+	for _, step := range steps {
+		if failed {
+			matrix = append(matrix, checkResult{name: step.name, status: checkSkip})
+			continue
+		}
+		status, detail := step.run()
+		if status != checkPass {
+			failed = true
+		}
+		matrix = append(matrix, checkResult{name: step.name, status: status, detail: detail})
+	}
+
+	details := make(map[string]string)
+	for _, r := range matrix {
+		line := fmt.Sprintf("[%-5s] %-13s %s", r.status, r.name, r.detail)
+		switch r.status {
+		case checkPass:
+			opts.Log.Info(line)
+		case checkSkip:
+			opts.Log.Debug(line)
+		default:
+			opts.Log.Warn(line)
+		}
+		details[r.name] = string(r.status)
+		if r.detail != "" && r.status != checkPass {
+			details[r.name+"_detail"] = r.detail
+		}
+	}
+
+	verdict := "pass"
+	if failed {
+		verdict = "fail"
+		opts.Log.Errorf("relaying to %s does not work", opts.Target)
+	} else {
+		opts.Log.Infof("relaying to %s works", opts.Target)
+	}
+
+	return writer.Write(results.Finding{
+		Module:   "check",
+		Relay:    opts.TurnServer,
+		Host:     opts.Target.Addr().String(),
+		Port:     opts.Target.Port(),
+		Protocol: "udp",
+		Service:  verdict,
+		Details:  details,
+	})
+}
+
+func (s *checkSession) connect() (checkStatus, string) {
+	conn, err := internal.Connect(s.opts.Protocol, s.opts.TurnServer, s.opts.UseTLS, s.opts.TlsVerify, s.opts.Timeout)
+	if err != nil {
+		return checkFail, err.Error()
+	}
+	s.conn = conn
+	return checkPass, conn.RemoteAddr().String()
+}
+
+// authRequired sends an allocation without credentials to get the realm
+// and nonce. Servers not requiring authentication fail this step
+func (s *checkSession) authRequired() (checkStatus, string) {
+	allocateRequest := internal.AllocateRequest(internal.RequestedTransportUDP, s.addressFamily())
+	allocateResponse, err := allocateRequest.SendAndReceive(s.opts.Log, s.conn, s.opts.Timeout)
+	if err != nil {
+		return checkError, fmt.Sprintf("error on sending allocate request: %v", err)
+	}
+	if allocateResponse.Header.MessageType.Class != internal.MsgTypeClassError {
+		s.allocated = true
+		return checkFail, "allocation without credentials was granted"
+	}
+	s.realm = string(allocateResponse.GetAttribute(internal.AttrRealm).Value)
+	s.nonce = string(allocateResponse.GetAttribute(internal.AttrNonce).Value)
+
+	detail := fmt.Sprintf("realm %q", s.realm)
+	if software := allocateResponse.GetAttribute(internal.AttrSoftware).Value; len(software) > 0 {
+		detail = fmt.Sprintf("%s, software %q", detail, string(software))
+	}
+	return checkPass, detail
+}
+
+func (s *checkSession) allocate() (checkStatus, string) {
+	allocateRequest := internal.AllocateRequestAuth(s.opts.Username, s.opts.Password, s.nonce, s.realm, internal.RequestedTransportUDP, s.addressFamily())
+	allocateResponse, err := allocateRequest.SendAndReceive(s.opts.Log, s.conn, s.opts.Timeout)
+	if err != nil {
+		return checkError, fmt.Sprintf("error on sending allocate request: %v", err)
+	}
+	if allocateResponse.Header.MessageType.Class == internal.MsgTypeClassError {
+		return checkFail, allocateResponse.GetErrorString()
+	}
+	s.allocated = true
+
+	host, port, err := internal.ConvertXORAddr(allocateResponse.GetAttribute(internal.AttrXorRelayedAddress).Value, allocateResponse.Header.TransactionID)
+	if err != nil {
+		return checkPass, "allocation granted"
+	}
+	return checkPass, fmt.Sprintf("relayed address %s", net.JoinHostPort(host, strconv.Itoa(int(port))))
+}
+
+func (s *checkSession) permission() (checkStatus, string) {
+	permissionRequest, err := internal.CreatePermissionRequest(s.opts.Username, s.opts.Password, s.nonce, s.realm, s.opts.Target.Addr(), s.opts.Target.Port())
+	if err != nil {
+		return checkError, fmt.Sprintf("error on generating CreatePermissionRequest: %v", err)
+	}
+	return s.request(permissionRequest)
+}
+
+func (s *checkSession) channelBind() (checkStatus, string) {
+	channel := helper.RandomChannelNumber()
+	channelBindRequest, err := internal.ChannelBindRequest(s.opts.Username, s.opts.Password, s.nonce, s.realm, s.opts.Target.Addr(), s.opts.Target.Port(), channel)
+	if err != nil {
+		return checkError, fmt.Sprintf("error on generating ChannelBindRequest: %v", err)
+	}
+	status, detail := s.request(channelBindRequest)
+	if status == checkPass {
+		s.channel = channel
+		detail = fmt.Sprintf("channel %02x", channel)
+	}
+	return status, detail
+}
+
+// exchange sends the payload to the target over the channel and waits for
+// an answer
+func (s *checkSession) exchange() (checkStatus, string) {
+	start := time.Now()
+	if len(s.opts.Payload) == 0 {
+		msg, err := relayDNSQuery(s.opts.Log, s.conn, s.channel, ".", helper.DNSTypeNS, s.opts.Protocol == "tcp", s.opts.Timeout)
+		if err != nil {
+			return s.exchangeError(err)
+		}
+		return checkPass, fmt.Sprintf("DNS response with %d answers in %s", len(msg.Answers), time.Since(start).Round(time.Millisecond))
+	}
+
+	// ChannelData over TCP needs to be padded to a multiple of 4 bytes
+	buf, err := internal.ChannelData(s.channel, s.opts.Payload, s.opts.Protocol == "tcp")
+	if err != nil {
+		return checkError, err.Error()
+	}
+	if err := helper.ConnectionWrite(s.conn, buf, s.opts.Timeout); err != nil {
+		return checkError, fmt.Sprintf("error on sending data: %v", err)
+	}
+	resp, err := helper.ConnectionRead(s.conn, s.opts.Timeout)
+	if err != nil {
+		return s.exchangeError(err)
+	}
+	_, data, err := internal.ExtractChannelData(resp)
+	if err != nil {
+		return checkError, fmt.Sprintf("invalid response: %v", err)
+	}
+	return checkPass, fmt.Sprintf("received %d bytes in %s", len(data), time.Since(start).Round(time.Millisecond))
+}
+
+func (s *checkSession) exchangeError(err error) (checkStatus, string) {
+	if errors.Is(err, helper.ErrTimeout) {
+		return checkFail, fmt.Sprintf("no response from %s", s.opts.Target)
+	}
+	return checkError, err.Error()
+}
+
+// request sends an authenticated request and maps the response to a status
+func (s *checkSession) request(req *internal.Stun) (checkStatus, string) {
+	resp, err := req.SendAndReceive(s.opts.Log, s.conn, s.opts.Timeout)
+	if err != nil {
+		return checkError, err.Error()
+	}
+	if resp.Header.MessageType.Class == internal.MsgTypeClassError {
+		return checkFail, resp.GetErrorString()
+	}
+	return checkPass, ""
+}
+
+func (s *checkSession) addressFamily() internal.AllocateProtocol {
+	if s.opts.Target.Addr().Is6() {
+		return internal.AllocateProtocolIPv6
+	}
+	return internal.AllocateProtocolIgnore
+}
+
+// close deletes the allocation so it does not count against the quota of
+// the user until it expires
+func (s *checkSession) close() {
+	if s.conn == nil {
+		return
+	}
+	defer s.conn.Close()
+	if !s.allocated {
+		return
+	}
+	deallocateRequest := internal.DeallocateRequest(s.opts.Username, s.opts.Password, s.nonce, s.realm)
+	if _, err := deallocateRequest.SendAndReceive(s.opts.Log, s.conn, s.opts.Timeout); err != nil {
+		s.opts.Log.Debugf("could not delete the allocation: %v", err)
+	}
+}
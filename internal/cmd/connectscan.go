@@ -0,0 +1,265 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"net/netip"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/firefart/stunner/internal"
+	"github.com/firefart/stunner/internal/helper"
+	"github.com/firefart/stunner/internal/results"
+	"github.com/sirupsen/logrus"
+)
+
+type ConnectScanOpts struct {
+	TurnServer string
+	Username   string
+	Password   string
+	UseTLS     bool
+	TlsVerify  bool
+	Timeout    time.Duration
+	Log        *logrus.Logger
+	Ports      []string
+	IPs        []string
+	// Loopback adds the IPv6 loopback address to the scanned ranges
+	Loopback bool
+	// ConnectTimeout is the time to wait for the answer to a Connect
+	// request. Relays answer with an error once their own connection
+	// attempt timed out, which can take a lot longer than the timeout to
+	// the TURN server
+	ConnectTimeout time.Duration
+	// Workers is the number of allocations scanning in parallel
+	Workers int
+	Output  string
+	// Timing writes the time every host was probed first and last
+	Timing bool
+}
+
+func (opts ConnectScanOpts) Validate() error {
+	if opts.TurnServer == "" {
+		return fmt.Errorf("need a valid turnserver")
+	}
+	if !strings.Contains(opts.TurnServer, ":") {
+		return fmt.Errorf("turnserver needs a port")
+	}
+	if opts.Username == "" {
+		return fmt.Errorf("please supply a username")
+	}
+	if opts.Password == "" {
+		return fmt.Errorf("please supply a password")
+	}
+	if opts.Log == nil {
+		return fmt.Errorf("please supply a valid logger")
+	}
+	if len(opts.Ports) == 0 {
+		return fmt.Errorf("please supply valid ports")
+	}
+	if opts.ConnectTimeout <= 0 {
+		return fmt.Errorf("please supply a valid connect timeout")
+	}
+	if opts.Workers < 1 {
+		return fmt.Errorf("please supply a valid number of workers")
+	}
+	// no need to check IPs, it can be nil
+
+	return nil
+}
+
+// ConnectScan scans TCP ports with Connect requests only. The state of a
+// port is inferred from the answer of the relay and its response time. No
+// data connections are opened, so a single allocation scans many ports
+func ConnectScan(opts ConnectScanOpts) error {
+	if err := opts.Validate(); err != nil {
+		return err
+	}
+
+	var ports []uint16
+	for _, port := range opts.Ports {
+		port := strings.TrimSpace(port)
+		portI, err := strconv.ParseUint(port, 10, 16)
+		if err != nil {
+			return fmt.Errorf("Invalid port %s: %w", port, err)
+		}
+		ports = append(ports, uint16(portI))
+	}
+
+	writer, err := results.NewWriter(opts.Output)
+	if err != nil {
+		return err
+	}
+	defer writer.Close()
+
+	ipInput := helper.ScanRanges(opts.IPs, opts.Loopback)
+
+	targets := make(chan netip.AddrPort)
+	go func() {
+		defer close(targets)
+		for ip := range helper.IPIterator(ipInput) {
+			if ip.Error != nil {
+				opts.Log.Error(ip.Error)
+				continue
+			}
+			for _, port := range ports {
+				targets <- netip.AddrPortFrom(ip.IP, port)
+			}
+		}
+	}()
+
+	var times *results.HostTimes
+	if opts.Timing {
+		times = results.NewHostTimes()
+	}
+
+	var mu sync.Mutex
+	counts := make(map[helper.PortState]int)
+	var writeErr error
+	var wg sync.WaitGroup
+	for w := 0; w < opts.Workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			scanner := &connectScanner{opts: opts, allocations: make(map[bool]*connectScanAllocation)}
+			defer scanner.close()
+			for target := range targets {
+				start := time.Now()
+				probe, err := scanner.probe(target)
+				times.Record(target.Addr().String(), start, time.Now())
+				if err != nil {
+					opts.Log.Errorf("could not scan %s: %v", target, err)
+					continue
+				}
+				state := probe.State()
+				switch state {
+				case helper.PortOpen:
+					opts.Log.Infof("%s is open", target)
+				case helper.PortClosed:
+					opts.Log.Infof("%s is closed", target)
+				default:
+					opts.Log.Debugf("%s is %s (%d after %s)", target, state, probe.Code, probe.Elapsed.Round(time.Millisecond))
+				}
+
+				mu.Lock()
+				counts[state]++
+				// filtered ports are the default on internal networks and
+				// would only bloat the results
+				if state == helper.PortOpen || state == helper.PortClosed {
+					if err := writer.Write(results.Finding{
+						Module:   "connect-scan",
+						Relay:    opts.TurnServer,
+						Host:     target.Addr().String(),
+						Port:     target.Port(),
+						Protocol: "tcp",
+						Service:  string(state),
+						Details: map[string]string{
+							"response_time": probe.Elapsed.Round(time.Millisecond).String(),
+							"baseline":      probe.Baseline.Round(time.Millisecond).String(),
+						},
+					}); err != nil && writeErr == nil {
+						writeErr = err
+					}
+				}
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+
+	for _, finding := range times.Findings("connect-scan", opts.TurnServer) {
+		if err := writer.Write(finding); err != nil && writeErr == nil {
+			writeErr = err
+		}
+	}
+
+	opts.Log.Infof("%d open, %d closed, %d filtered, %d forbidden and %d unknown ports", counts[helper.PortOpen], counts[helper.PortClosed], counts[helper.PortFiltered], counts[helper.PortForbidden], counts[helper.PortUnknown])
+	return writeErr
+}
+
+// connectScanAllocation is a TCP allocation with the round trip time to
+// the relay
+type connectScanAllocation struct {
+	*internal.TCPAllocation
+	baseline time.Duration
+}
+
+// connectScanner holds the allocations of a worker, one per address family
+type connectScanner struct {
+	opts        ConnectScanOpts
+	allocations map[bool]*connectScanAllocation
+}
+
+// probe sends a Connect request for the target. If the control connection
+// failed a new allocation is requested and the target is probed again
+func (s *connectScanner) probe(target netip.AddrPort) (helper.ConnectProbe, error) {
+	for attempt := 0; ; attempt++ {
+		allocation, err := s.allocation(target.Addr().Is6())
+		if err != nil {
+			return helper.ConnectProbe{}, err
+		}
+
+		probe := helper.ConnectProbe{Baseline: allocation.baseline}
+		resp, elapsed, err := allocation.Probe(target.Addr(), target.Port(), s.opts.ConnectTimeout)
+		probe.Elapsed = elapsed
+		switch {
+		case errors.Is(err, helper.ErrTimeout):
+			probe.Timeout = true
+			return probe, nil
+		case err != nil:
+			s.drop(target.Addr().Is6())
+			if attempt > 0 {
+				return probe, err
+			}
+			s.opts.Log.Debugf("control connection failed, requesting a new allocation: %v", err)
+			continue
+		}
+
+		if code, ok := resp.GetErrorCode(); ok {
+			probe.Code = int(code)
+		} else {
+			probe.Connected = resp.Header.MessageType.Class == internal.MsgTypeClassSuccess
+		}
+		return probe, nil
+	}
+}
+
+// allocation returns the allocation for the address family and requests
+// it on first use
+func (s *connectScanner) allocation(ipv6 bool) (*connectScanAllocation, error) {
+	if a, ok := s.allocations[ipv6]; ok {
+		return a, nil
+	}
+	addressFamily := internal.AllocateProtocolIgnore
+	if ipv6 {
+		addressFamily = internal.AllocateProtocolIPv6
+	}
+	tcpAllocation, err := internal.NewTCPAllocation(s.opts.Log, s.opts.TurnServer, s.opts.UseTLS, s.opts.TlsVerify, s.opts.Timeout, addressFamily, s.opts.Username, s.opts.Password)
+	if err != nil {
+		return nil, err
+	}
+	// the refresh is answered without contacting a peer
+	start := time.Now()
+	if _, err := tcpAllocation.Refresh(); err != nil {
+		tcpAllocation.Close()
+		return nil, fmt.Errorf("could not measure the round trip time: %w", err)
+	}
+	a := &connectScanAllocation{TCPAllocation: tcpAllocation, baseline: time.Since(start)}
+	s.opts.Log.Debugf("round trip time to the relay is %s", a.baseline)
+	s.allocations[ipv6] = a
+	return a, nil
+}
+
+func (s *connectScanner) drop(ipv6 bool) {
+	if a, ok := s.allocations[ipv6]; ok {
+		a.Close()
+		delete(s.allocations, ipv6)
+	}
+}
+
+func (s *connectScanner) close() {
+	for ipv6 := range s.allocations {
+		s.drop(ipv6)
+	}
+}
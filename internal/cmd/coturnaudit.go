@@ -0,0 +1,235 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"net/netip"
+	"strings"
+	"time"
+
+	"github.com/firefart/stunner/internal"
+	"github.com/firefart/stunner/internal/results"
+)
+
+// peerRange is a range of peer addresses as used in the
+// denied-peer-ip directive of coturn
+type peerRange struct {
+	Start string
+	End   string
+}
+
+// coturnRecommendedDenied are the ranges the coturn example configuration
+// recommends to deny
+var coturnRecommendedDenied = []peerRange{
+	{"0.0.0.0", "0.255.255.255"},
+	{"10.0.0.0", "10.255.255.255"},
+	{"100.64.0.0", "100.127.255.255"},
+	{"127.0.0.0", "127.255.255.255"},
+	{"169.254.0.0", "169.254.255.255"},
+	{"172.16.0.0", "172.31.255.255"},
+	{"192.0.0.0", "192.0.0.255"},
+	{"192.0.2.0", "192.0.2.255"},
+	{"192.88.99.0", "192.88.99.255"},
+	{"192.168.0.0", "192.168.255.255"},
+	{"198.18.0.0", "198.19.255.255"},
+	{"198.51.100.0", "198.51.100.255"},
+	{"203.0.113.0", "203.0.113.255"},
+	{"240.0.0.0", "255.255.255.255"},
+	{"::1", "::1"},
+	{"64:ff9b::", "64:ff9b::ffff:ffff"},
+	{"fc00::", "fdff:ffff:ffff:ffff:ffff:ffff:ffff:ffff"},
+	{"fe80::", "febf:ffff:ffff:ffff:ffff:ffff:ffff:ffff"},
+}
+
+// inferredDirective is a coturn configuration line that matches
+// the observed behavior of the server. Directives that are not
+// set are commented out
+type inferredDirective struct {
+	Directive string
+	Evidence  string
+}
+
+// inferCoturnConfig infers the likely coturn configuration from the
+// behavior of the server so admins can verify their configuration took
+// effect
+func inferCoturnConfig(opts DefenseCheckOpts, obs *defenseObservations, writer *results.Writer) error {
+	opts.Log.Info("inferring the coturn configuration, this might take a while")
+
+	var directives []inferredDirective
+	directives = append(directives, inferRelayTransports(opts)...)
+	directives = append(directives, inferDeniedPeers(opts)...)
+	directives = append(directives, inferQuota(obs))
+	if opts.NonceWait > 0 {
+		directives = append(directives, inferStaleNonce(opts))
+	}
+
+	opts.Log.Info("inferred coturn configuration:")
+	details := make(map[string]string, len(directives))
+	for _, d := range directives {
+		opts.Log.Infof("\t%-60s (%s)", d.Directive, d.Evidence)
+		details[d.Directive] = d.Evidence
+	}
+
+	return writer.Write(results.Finding{
+		Module:  "defense-check",
+		Relay:   opts.TurnServer,
+		Host:    opts.TurnServer,
+		Service: "coturn-config",
+		Details: details,
+	})
+}
+
+// inferRelayTransports checks if UDP and TCP allocations are granted
+func inferRelayTransports(opts DefenseCheckOpts) []inferredDirective {
+	var ret []inferredDirective
+
+	transports := []struct {
+		name      string
+		transport internal.RequestedTransport
+	}{
+		{"udp", internal.RequestedTransportUDP},
+		{"tcp", internal.RequestedTransportTCP},
+	}
+	for _, t := range transports {
+		a, err := newProbeAllocation(opts, t.transport, internal.AllocateProtocolIgnore)
+		directive := fmt.Sprintf("no-%s-relay", t.name)
+		switch {
+		case err == nil:
+			a.Close()
+			ret = append(ret, inferredDirective{"# " + directive, fmt.Sprintf("%s allocation granted", strings.ToUpper(t.name))})
+		case isUnsupportedTransport(err):
+			ret = append(ret, inferredDirective{directive, err.Error()})
+		default:
+			opts.Log.Debugf("could not check %s relaying: %v", t.name, err)
+		}
+	}
+	return ret
+}
+
+// inferDeniedPeers checks the first and last address of every recommended
+// range to find the denied-peer-ip entries
+func inferDeniedPeers(opts DefenseCheckOpts) []inferredDirective {
+	allocations := make(map[internal.AllocateProtocol]*probeAllocation)
+	defer func() {
+		for _, a := range allocations {
+			a.Close()
+		}
+	}()
+
+	var ret []inferredDirective
+	for _, r := range coturnRecommendedDenied {
+		start := netip.MustParseAddr(r.Start)
+		end := netip.MustParseAddr(r.End)
+
+		family := internal.AllocateProtocolIgnore
+		if start.Is6() {
+			family = internal.AllocateProtocolIPv6
+		}
+		a, ok := allocations[family]
+		if !ok {
+			var err error
+			a, err = newProbeAllocation(opts, internal.RequestedTransportUDP, family)
+			if err != nil {
+				opts.Log.Debugf("could not allocate for %s: %v", r.Start, err)
+				continue
+			}
+			allocations[family] = a
+		}
+
+		startDenied, err := permissionDenied(a, start)
+		if err != nil {
+			opts.Log.Debugf("could not check %s: %v", start, err)
+			continue
+		}
+		endDenied, err := permissionDenied(a, end)
+		if err != nil {
+			opts.Log.Debugf("could not check %s: %v", end, err)
+			continue
+		}
+
+		directive := fmt.Sprintf("denied-peer-ip=%s-%s", r.Start, r.End)
+		if r.Start == r.End {
+			directive = fmt.Sprintf("denied-peer-ip=%s", r.Start)
+		}
+		switch {
+		case startDenied && endDenied:
+			ret = append(ret, inferredDirective{directive, "permissions denied"})
+		case startDenied || endDenied:
+			ret = append(ret, inferredDirective{"# " + directive, "only partially denied"})
+		default:
+			ret = append(ret, inferredDirective{"# " + directive, "permissions granted"})
+		}
+	}
+	return ret
+}
+
+// permissionDenied returns true if the server rejects a permission
+// for the target with 403 Forbidden
+func permissionDenied(a *probeAllocation, target netip.Addr) (bool, error) {
+	err := a.createPermission(target)
+	if err == nil {
+		return false, nil
+	}
+	if isForbidden(err) {
+		return true, nil
+	}
+	return false, err
+}
+
+// inferQuota uses the result of the allocation quota check
+func inferQuota(obs *defenseObservations) inferredDirective {
+	if obs.quotaError == nil {
+		return inferredDirective{
+			"# user-quota and total-quota",
+			fmt.Sprintf("no quota below %d allocations", obs.quotaGranted),
+		}
+	}
+	if obs.quotaError.Code == internal.ErrorInsufficientCapacity {
+		// the server ran out of relay ports or bandwidth, this is no quota
+		return inferredDirective{
+			"# user-quota and total-quota",
+			fmt.Sprintf("no capacity left after %d allocations: %v", obs.quotaGranted, obs.quotaError),
+		}
+	}
+	// both quotas are reported with the same error so they can't be told apart
+	return inferredDirective{
+		fmt.Sprintf("user-quota=%d or total-quota=%d", obs.quotaGranted, obs.quotaGranted),
+		obs.quotaError.Error(),
+	}
+}
+
+// inferStaleNonce reuses a nonce after waiting opts.NonceWait. coturn
+// rejects nonces older than the stale-nonce lifetime with 438 Stale Nonce
+func inferStaleNonce(opts DefenseCheckOpts) inferredDirective {
+	a, err := newProbeAllocation(opts, internal.RequestedTransportUDP, internal.AllocateProtocolIgnore)
+	if err != nil {
+		return inferredDirective{"# stale-nonce", fmt.Sprintf("could not check: %v", err)}
+	}
+	defer a.Close()
+
+	opts.Log.Infof("waiting %s before reusing the nonce", opts.NonceWait)
+	time.Sleep(opts.NonceWait)
+
+	// the target does not matter, only the nonce is checked
+	err = a.createPermission(netip.MustParseAddr("192.0.2.1"))
+	var respErr *internal.ResponseError
+	switch {
+	case errors.As(err, &respErr) && respErr.Code == internal.ErrorStaleNonce:
+		return inferredDirective{
+			"stale-nonce",
+			fmt.Sprintf("nonce rejected after %s so the lifetime is at most %d seconds", opts.NonceWait, int(opts.NonceWait.Seconds())),
+		}
+	case errors.As(err, &respErr) && respErr.Code == internal.ErrorAllocationMismatch:
+		return inferredDirective{"# stale-nonce", "the allocation expired while waiting, use a shorter wait"}
+	case err == nil, isForbidden(err):
+		return inferredDirective{"# stale-nonce", fmt.Sprintf("nonce still accepted after %s", opts.NonceWait)}
+	}
+	return inferredDirective{"# stale-nonce", fmt.Sprintf("could not check: %v", err)}
+}
+
+// isUnsupportedTransport returns true if the server rejected the
+// allocation with 442 Unsupported Transport Protocol
+func isUnsupportedTransport(err error) bool {
+	var respErr *internal.ResponseError
+	return errors.As(err, &respErr) && respErr.Code == internal.ErrorUnsupportedTransportProtocol
+}
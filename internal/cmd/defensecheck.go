@@ -0,0 +1,408 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/netip"
+	"strings"
+	"time"
+
+	"github.com/firefart/stunner/internal"
+	"github.com/firefart/stunner/internal/results"
+	"github.com/sirupsen/logrus"
+)
+
+type DefenseCheckOpts struct {
+	TurnServer string
+	Protocol   string
+	Username   string
+	Password   string
+	UseTLS     bool
+	TlsVerify  bool
+	Timeout    time.Duration
+	Log        *logrus.Logger
+	// QuotaAllocations is the number of parallel allocations opened
+	// to check if a quota is enforced
+	QuotaAllocations int
+	Output           string
+	// InferConfig enables the inference of the coturn configuration
+	InferConfig bool
+	// NonceWait is the time to wait before reusing a nonce to check if
+	// stale nonces are rejected. 0 skips the check
+	NonceWait time.Duration
+	// Delay is the time to wait between two checks
+	Delay time.Duration
+}
+
+func (opts DefenseCheckOpts) Validate() error {
+	if opts.TurnServer == "" {
+		return fmt.Errorf("need a valid turnserver")
+	}
+	if !strings.Contains(opts.TurnServer, ":") {
+		return fmt.Errorf("turnserver needs a port")
+	}
+	if opts.Protocol != "tcp" && opts.Protocol != "udp" {
+		return fmt.Errorf("protocol needs to be either tcp or udp")
+	}
+	if opts.Username == "" {
+		return fmt.Errorf("please supply a username")
+	}
+	if opts.Password == "" {
+		return fmt.Errorf("please supply a password")
+	}
+	if opts.Log == nil {
+		return fmt.Errorf("please supply a valid logger")
+	}
+	if opts.QuotaAllocations < 1 {
+		return fmt.Errorf("please supply a valid number of quota allocations")
+	}
+	if opts.NonceWait < 0 {
+		return fmt.Errorf("nonce wait can not be negative")
+	}
+	if opts.Delay < 0 {
+		return fmt.Errorf("delay can not be negative")
+	}
+
+	return nil
+}
+
+type checkStatus string
+
+const (
+	checkPass checkStatus = "PASS"
+	checkFail checkStatus = "FAIL"
+	// checkError means the check could not be completed
+	checkError checkStatus = "ERROR"
+)
+
+// defenseCheck is a single hardening check of the defense-check command
+type defenseCheck struct {
+	Name        string
+	Description string
+	// Remediation lists the coturn configuration directives that fix a failed check
+	Remediation string
+	Run         func(opts DefenseCheckOpts, obs *defenseObservations) (checkStatus, string)
+}
+
+// defenseObservations collects the behavior seen during the checks
+// that is also used to infer the server configuration
+type defenseObservations struct {
+	// quotaGranted is the number of parallel allocations granted
+	quotaGranted int
+	// quotaError is the error returned once the quota was reached
+	quotaError *internal.ResponseError
+}
+
+var defenseChecks = []defenseCheck{
+	{
+		Name:        "anonymous-allocation",
+		Description: "allocations require authentication",
+		Remediation: "enable lt-cred-mech or use-auth-secret and remove no-auth",
+		Run:         checkAnonymousAllocation,
+	},
+	{
+		Name:        "loopback-udp",
+		Description: "UDP relaying to loopback and unspecified addresses is denied",
+		Remediation: "remove allow-loopback-peers and add denied-peer-ip=0.0.0.0-0.255.255.255, denied-peer-ip=127.0.0.0-127.255.255.255 and denied-peer-ip=::1",
+		Run: func(opts DefenseCheckOpts, _ *defenseObservations) (checkStatus, string) {
+			return checkRelayDenied(opts, "udp", []string{"127.0.0.1", "0.0.0.0", "::1"})
+		},
+	},
+	{
+		Name:        "private-udp",
+		Description: "UDP relaying to private and link local ranges is denied",
+		Remediation: "add denied-peer-ip=10.0.0.0-10.255.255.255, denied-peer-ip=172.16.0.0-172.31.255.255, denied-peer-ip=192.168.0.0-192.168.255.255, denied-peer-ip=169.254.0.0-169.254.255.255 and denied-peer-ip=fc00::-fdff:ffff:ffff:ffff:ffff:ffff:ffff:ffff for all internal ranges",
+		Run: func(opts DefenseCheckOpts, _ *defenseObservations) (checkStatus, string) {
+			return checkRelayDenied(opts, "udp", []string{"10.0.0.1", "172.16.0.1", "192.168.0.1", "169.254.169.254", "fd00::1"})
+		},
+	},
+	{
+		Name:        "loopback-tcp",
+		Description: "TCP relaying to loopback addresses is denied",
+		Remediation: "set no-tcp-relay if TCP relaying is not needed, otherwise remove allow-loopback-peers and add denied-peer-ip=127.0.0.0-127.255.255.255 and denied-peer-ip=::1",
+		Run: func(opts DefenseCheckOpts, _ *defenseObservations) (checkStatus, string) {
+			return checkRelayDenied(opts, "tcp", []string{"127.0.0.1", "::1"})
+		},
+	},
+	{
+		Name:        "private-tcp",
+		Description: "TCP relaying to private ranges is denied",
+		Remediation: "set no-tcp-relay if TCP relaying is not needed, otherwise add denied-peer-ip entries for all internal ranges",
+		Run: func(opts DefenseCheckOpts, _ *defenseObservations) (checkStatus, string) {
+			return checkRelayDenied(opts, "tcp", []string{"10.0.0.1", "172.16.0.1", "192.168.0.1", "169.254.169.254"})
+		},
+	},
+	{
+		Name:        "allocation-quota",
+		Description: "the number of allocations per user is limited",
+		Remediation: "set user-quota and total-quota to limit the allocations per user and in total",
+		Run:         checkAllocationQuota,
+	},
+}
+
+// DefenseCheck runs all misuse checks against a TURN server under the control
+// of the operator and prints a hardening report with remediations
+func DefenseCheck(opts DefenseCheckOpts) error {
+	if err := opts.Validate(); err != nil {
+		return err
+	}
+
+	writer, err := results.NewWriter(opts.Output)
+	if err != nil {
+		return err
+	}
+	defer writer.Close()
+
+	obs, _, err := runDefenseChecks(opts, writer)
+	if err != nil {
+		return err
+	}
+
+	if !opts.InferConfig {
+		return nil
+	}
+	return inferCoturnConfig(opts, obs, writer)
+}
+
+// runDefenseChecks runs all checks, logs the report and writes a finding
+// per check. It returns the observations and the number of failed checks
+func runDefenseChecks(opts DefenseCheckOpts, writer *results.Writer) (*defenseObservations, int, error) {
+	var obs defenseObservations
+	failed := 0
+	for i, check := range defenseChecks {
+		if i > 0 {
+			time.Sleep(opts.Delay)
+		}
+		opts.Log.Debugf("running check %s", check.Name)
+		status, detail := check.Run(opts, &obs)
+		switch status {
+		case checkPass:
+			opts.Log.Infof("[%s] %s: %s", status, check.Name, check.Description)
+		case checkFail:
+			failed++
+			opts.Log.Warnf("[%s] %s: %s", status, check.Name, check.Description)
+			opts.Log.Warnf("\tremediation: %s", check.Remediation)
+		default:
+			opts.Log.Errorf("[%s] %s: %s", status, check.Name, check.Description)
+		}
+		if detail != "" {
+			opts.Log.Infof("\t%s", detail)
+		}
+
+		details := map[string]string{
+			"status": string(status),
+			"detail": detail,
+		}
+		if status == checkFail {
+			details["remediation"] = check.Remediation
+		}
+		if err := writer.Write(results.Finding{
+			Module:  "defense-check",
+			Relay:   opts.TurnServer,
+			Host:    opts.TurnServer,
+			Service: check.Name,
+			Details: details,
+		}); err != nil {
+			return nil, 0, err
+		}
+	}
+
+	if failed > 0 {
+		opts.Log.Warnf("%d of %d checks failed", failed, len(defenseChecks))
+	} else {
+		opts.Log.Infof("all %d checks passed", len(defenseChecks))
+	}
+	return &obs, failed, nil
+}
+
+// checkAnonymousAllocation sends an allocation without credentials
+// which must be answered with an error
+func checkAnonymousAllocation(opts DefenseCheckOpts, _ *defenseObservations) (checkStatus, string) {
+	conn, err := internal.Connect(opts.Protocol, opts.TurnServer, opts.UseTLS, opts.TlsVerify, opts.Timeout)
+	if err != nil {
+		return checkError, err.Error()
+	}
+	defer conn.Close()
+
+	allocateRequest := internal.AllocateRequest(internal.RequestedTransportUDP, internal.AllocateProtocolIgnore)
+	allocateResponse, err := allocateRequest.SendAndReceive(opts.Log, conn, opts.Timeout)
+	if err != nil {
+		return checkError, fmt.Sprintf("error on sending allocate request: %v", err)
+	}
+	if allocateResponse.Header.MessageType.Class != internal.MsgTypeClassError {
+		return checkFail, "allocation without credentials was granted"
+	}
+	return checkPass, allocateResponse.GetErrorString()
+}
+
+// checkRelayDenied checks if relaying to all targets is denied. Checks
+// are run with the given transport so tcp targets use a TURN over TCP
+// allocation
+func checkRelayDenied(opts DefenseCheckOpts, transport string, targets []string) (checkStatus, string) {
+	rangeOpts := RangeScanOpts{
+		TurnServer: opts.TurnServer,
+		Protocol:   opts.Protocol,
+		Username:   opts.Username,
+		Password:   opts.Password,
+		UseTLS:     opts.UseTLS,
+		TlsVerify:  opts.TlsVerify,
+		Timeout:    opts.Timeout,
+		Log:        opts.Log,
+	}
+
+	// TURN over TCP allocations are only possible on TCP connections
+	if transport == "tcp" {
+		rangeOpts.Protocol = "tcp"
+	}
+
+	var allowed, inconclusive []string
+	for _, target := range targets {
+		ip := netip.MustParseAddr(target)
+		var suc bool
+		var err error
+		if transport == "tcp" {
+			suc, err = scanTCP(rangeOpts, ip, 80)
+		} else {
+			suc, err = scanUDP(rangeOpts, ip, 80)
+		}
+		var respErr *internal.ResponseError
+		isRespErr := errors.As(err, &respErr)
+		switch {
+		// the server tried to connect to the target
+		case suc, isRespErr && respErr.Code == internal.ErrorConnectionTimeoutOrFailure:
+			allowed = append(allowed, target)
+		// no-tcp-relay results in an unsupported transport
+		case isForbidden(err), isRespErr && respErr.Code == internal.ErrorUnsupportedTransportProtocol:
+			opts.Log.Debugf("%s relaying to %s denied: %v", transport, target, err)
+		default:
+			opts.Log.Debugf("%s relaying to %s: %v", transport, target, err)
+			inconclusive = append(inconclusive, target)
+		}
+	}
+
+	if len(allowed) > 0 {
+		return checkFail, fmt.Sprintf("relaying allowed to %s", strings.Join(allowed, ", "))
+	}
+	if len(inconclusive) > 0 {
+		return checkError, fmt.Sprintf("could not check %s, run with --debug for details", strings.Join(inconclusive, ", "))
+	}
+	return checkPass, ""
+}
+
+// checkAllocationQuota opens parallel allocations with the same credentials
+// and expects the server to reject them at some point
+func checkAllocationQuota(opts DefenseCheckOpts, obs *defenseObservations) (checkStatus, string) {
+	var allocations []*probeAllocation
+	defer func() {
+		// free the quota on the server for the following checks
+		for _, a := range allocations {
+			a.Close()
+		}
+	}()
+
+	for i := 0; i < opts.QuotaAllocations; i++ {
+		a, err := newProbeAllocation(opts, internal.RequestedTransportUDP, internal.AllocateProtocolIgnore)
+		if err != nil {
+			var respErr *internal.ResponseError
+			if errors.As(err, &respErr) && (respErr.Code == internal.ErrorAllocationQuotaReached || respErr.Code == internal.ErrorInsufficientCapacity) {
+				obs.quotaGranted = i
+				obs.quotaError = respErr
+				return checkPass, fmt.Sprintf("allocation %d was rejected: %v", i+1, err)
+			}
+			return checkError, err.Error()
+		}
+		allocations = append(allocations, a)
+	}
+	obs.quotaGranted = opts.QuotaAllocations
+	return checkFail, fmt.Sprintf("all %d parallel allocations were granted", opts.QuotaAllocations)
+}
+
+// probeAllocation is an authenticated allocation used to probe
+// the behavior of the server
+type probeAllocation struct {
+	opts  DefenseCheckOpts
+	conn  net.Conn
+	realm string
+	nonce string
+}
+
+// newProbeAllocation requests an authenticated allocation. TCP
+// allocations always use a TCP connection to the server
+func newProbeAllocation(opts DefenseCheckOpts, transport internal.RequestedTransport, addressFamily internal.AllocateProtocol) (*probeAllocation, error) {
+	protocol := opts.Protocol
+	if transport == internal.RequestedTransportTCP {
+		protocol = "tcp"
+	}
+	conn, err := internal.Connect(protocol, opts.TurnServer, opts.UseTLS, opts.TlsVerify, opts.Timeout)
+	if err != nil {
+		return nil, err
+	}
+
+	allocateRequest := internal.AllocateRequest(transport, addressFamily)
+	allocateResponse, err := allocateRequest.SendAndReceive(opts.Log, conn, opts.Timeout)
+	if err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("error on sending allocate request 1: %w", err)
+	}
+	if allocateResponse.Header.MessageType.Class != internal.MsgTypeClassError {
+		conn.Close()
+		return nil, fmt.Errorf("MessageClass is not Error (should be not authenticated)")
+	}
+
+	realm := string(allocateResponse.GetAttribute(internal.AttrRealm).Value)
+	nonce := string(allocateResponse.GetAttribute(internal.AttrNonce).Value)
+
+	allocateRequest = internal.AllocateRequestAuth(opts.Username, opts.Password, nonce, realm, transport, addressFamily)
+	allocateResponse, err = allocateRequest.SendAndReceive(opts.Log, conn, opts.Timeout)
+	if err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("error on sending allocate request 2: %w", err)
+	}
+	if allocateResponse.Header.MessageType.Class == internal.MsgTypeClassError {
+		conn.Close()
+		return nil, fmt.Errorf("error on allocate response: %w", allocateResponse.GetError())
+	}
+	return &probeAllocation{
+		opts:  opts,
+		conn:  conn,
+		realm: realm,
+		nonce: nonce,
+	}, nil
+}
+
+// createPermission installs a permission for the target and returns
+// the error response of the server if it was rejected
+func (a *probeAllocation) createPermission(target netip.Addr) error {
+	permissionRequest, err := internal.CreatePermissionRequest(a.opts.Username, a.opts.Password, a.nonce, a.realm, target, 80)
+	if err != nil {
+		return fmt.Errorf("error on generating CreatePermissionRequest: %w", err)
+	}
+	permissionResponse, err := permissionRequest.SendAndReceive(a.opts.Log, a.conn, a.opts.Timeout)
+	if err != nil {
+		return fmt.Errorf("error on sending CreatePermissionRequest: %w", err)
+	}
+	if permissionResponse.Header.MessageType.Class == internal.MsgTypeClassError {
+		if code, ok := permissionResponse.GetErrorCode(); ok && code == internal.ErrorStaleNonce {
+			// keep the allocation usable
+			a.nonce = string(permissionResponse.GetAttribute(internal.AttrNonce).Value)
+		}
+		return fmt.Errorf("error on CreatePermission: %w", permissionResponse.GetError())
+	}
+	return nil
+}
+
+// Close deletes the allocation on the server and closes the connection
+func (a *probeAllocation) Close() {
+	req := internal.DeallocateRequest(a.opts.Username, a.opts.Password, a.nonce, a.realm)
+	if _, err := req.SendAndReceive(a.opts.Log, a.conn, a.opts.Timeout); err != nil {
+		a.opts.Log.Debugf("could not delete allocation: %v", err)
+	}
+	a.conn.Close()
+}
+
+// isForbidden returns true if the server denied the request with 403 Forbidden
+func isForbidden(err error) bool {
+	var respErr *internal.ResponseError
+	return errors.As(err, &respErr) && respErr.Code == internal.ErrorForbidden
+}
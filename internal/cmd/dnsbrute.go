@@ -0,0 +1,201 @@
+package cmd
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"net"
+	"net/netip"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/firefart/stunner/internal"
+	"github.com/firefart/stunner/internal/helper"
+	"github.com/sirupsen/logrus"
+)
+
+type DNSBruteOpts struct {
+	TurnServer string
+	Protocol   string
+	Username   string
+	Password   string
+	UseTLS     bool
+	TlsVerify  bool
+	Timeout    time.Duration
+	Log        *logrus.Logger
+	DNSServer  netip.AddrPort
+	Domain     string
+	Wordlist   string
+	// ControlListen is the address of the pause and resume control API.
+	// The API is disabled if empty
+	ControlListen string
+	// ControlSDDL is the security descriptor of the named pipe of the
+	// control API on windows. Only the current user, SYSTEM and the
+	// administrators have access if empty
+	ControlSDDL string
+	// QuietHours pause the scan automatically
+	QuietHours helper.QuietHours
+	// Timeouts override Timeout for single phases of the scan
+	Timeouts helper.Timeouts
+}
+
+func (opts DNSBruteOpts) Validate() error {
+	if opts.TurnServer == "" {
+		return fmt.Errorf("need a valid turnserver")
+	}
+	if !strings.Contains(opts.TurnServer, ":") {
+		return fmt.Errorf("turnserver needs a port")
+	}
+	if opts.Protocol != "tcp" && opts.Protocol != "udp" {
+		return fmt.Errorf("protocol needs to be either tcp or udp")
+	}
+	if opts.Username == "" {
+		return fmt.Errorf("please supply a username")
+	}
+	if opts.Password == "" {
+		return fmt.Errorf("please supply a password")
+	}
+	if opts.Log == nil {
+		return fmt.Errorf("please supply a valid logger")
+	}
+	if !opts.DNSServer.IsValid() {
+		return fmt.Errorf("please supply a valid dns server")
+	}
+	if opts.Domain == "" {
+		return fmt.Errorf("please supply a valid domain name")
+	}
+	if opts.Wordlist == "" {
+		return fmt.Errorf("please supply a wordlist")
+	}
+
+	return nil
+}
+
+func DNSBrute(opts DNSBruteOpts) error {
+	if err := opts.Validate(); err != nil {
+		return err
+	}
+	opts.Timeouts = opts.Timeouts.WithDefault(opts.Timeout)
+
+	words, err := helper.ReadWordlist(opts.Wordlist)
+	if err != nil {
+		return fmt.Errorf("could not read wordlist: %w", err)
+	}
+
+	dnsServer := opts.DNSServer.Addr()
+	dnsPort := opts.DNSServer.Port()
+
+	pauser, stopPause, err := startPauseControl(opts.Log, "scan", opts.ControlListen, opts.ControlSDDL, opts.QuietHours, nil)
+	if err != nil {
+		return err
+	}
+	defer stopPause()
+
+	// all queries are sent over the same allocation
+	allocation, err := internal.NewAllocation(opts.Log, opts.Protocol, opts.TurnServer, opts.UseTLS, opts.TlsVerify, opts.Timeouts.Setup, dnsServer, dnsPort, opts.Username, opts.Password)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		allocation.Close()
+	}()
+
+	domain := strings.Trim(opts.Domain, ".")
+	hosts := make(map[string][]string)
+	for _, word := range words {
+		name := fmt.Sprintf("%s.%s", strings.Trim(word, "."), domain)
+		paused := pauser.Wait()
+		// big wordlists take longer than the lifetime of the channel binding
+		if err := allocation.KeepAlive(); err != nil {
+			if !paused {
+				return fmt.Errorf("could not keep the allocation alive: %w", err)
+			}
+			// the allocation might have expired during a long pause
+			opts.Log.Debugf("could not keep the allocation alive after the pause, allocating a new one: %v", err)
+			allocation.Close()
+			newAllocation, err := internal.NewAllocation(opts.Log, opts.Protocol, opts.TurnServer, opts.UseTLS, opts.TlsVerify, opts.Timeouts.Setup, dnsServer, dnsPort, opts.Username, opts.Password)
+			if err != nil {
+				return err
+			}
+			allocation = newAllocation
+		}
+		opts.Log.Debugf("resolving %s on %s", name, opts.DNSServer.String())
+		msg, err := relayDNSQuery(opts.Log, allocation.Conn, allocation.Channel, name, helper.DNSTypeA, opts.Protocol == "tcp", opts.Timeouts.DNS)
+		if err != nil {
+			if errors.Is(err, helper.ErrTimeout) {
+				opts.Log.Debugf("timeout on resolving %s", name)
+				continue
+			}
+			return err
+		}
+		if msg.RCode != 0 {
+			opts.Log.Debugf("%s: %s", name, helper.DNSRCodeString(msg.RCode))
+			continue
+		}
+		for _, a := range msg.Answers {
+			switch a.Type {
+			case helper.DNSTypeA, helper.DNSTypeAAAA:
+				opts.Log.Infof("%s: %s", a.Name, a.Data)
+				hosts[a.Data] = append(hosts[a.Data], a.Name)
+			case helper.DNSTypeCNAME:
+				opts.Log.Infof("%s: CNAME %s", a.Name, a.Data)
+			}
+		}
+	}
+
+	if len(hosts) == 0 {
+		opts.Log.Info("no hosts found")
+		return nil
+	}
+
+	ips := make([]string, 0, len(hosts))
+	for ip := range hosts {
+		ips = append(ips, ip)
+	}
+	sort.Strings(ips)
+	opts.Log.Infof("found %d unique IPs:", len(ips))
+	for _, ip := range ips {
+		opts.Log.Infof("\t%s (%s)", ip, strings.Join(hosts[ip], ", "))
+	}
+
+	return nil
+}
+
+// relayDNSQuery sends a DNS query over a bound channel and returns the
+// matching response. Set padded on TCP and TLS connections to the TURN
+// server
+func relayDNSQuery(logger internal.DebugLogger, remote net.Conn, channelNumber []byte, name string, qtype uint16, padded bool, timeout time.Duration) (*helper.DNSMessage, error) {
+	dns := helper.DNSQuery(name, qtype)
+	buf, err := internal.ChannelData(channelNumber, dns, padded)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := helper.ConnectionWrite(remote, buf, timeout); err != nil {
+		return nil, fmt.Errorf("error on sending DNS request: %w", err)
+	}
+
+	if err := remote.SetReadDeadline(time.Now().Add(timeout)); err != nil {
+		return nil, fmt.Errorf("could not set read deadline: %w", err)
+	}
+	for {
+		_, data, err := internal.ReadChannelData(remote, padded)
+		if err != nil {
+			return nil, channelReadError(err)
+		}
+
+		msg, err := helper.ParseDNSMessage(data)
+		if err != nil {
+			return nil, fmt.Errorf("could not parse DNS response: %w", err)
+		}
+
+		// late answers to previous queries can still arrive on the channel
+		if !bytes.Equal(helper.PutUint16(msg.ID), dns[:2]) {
+			logger.Debugf("ignoring DNS response with transaction id %d", msg.ID)
+			continue
+		}
+
+		return msg, nil
+	}
+}
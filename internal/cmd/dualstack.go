@@ -0,0 +1,240 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"net/netip"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/firefart/stunner/internal"
+	"github.com/firefart/stunner/internal/helper"
+	"github.com/firefart/stunner/internal/results"
+)
+
+// autoHosts collects the names and the services of the scanned addresses
+// so the findings of both address families can be merged per host
+type autoHosts struct {
+	mu sync.Mutex
+	// dnsServers are the internal DNS servers found during the scan
+	dnsServers []netip.Addr
+	// names maps the resolved names to their addresses
+	names    map[string][]netip.Addr
+	services map[netip.Addr][]string
+	scanned  map[netip.Addr]bool
+}
+
+func newAutoHosts() *autoHosts {
+	return &autoHosts{
+		names:    make(map[string][]netip.Addr),
+		services: make(map[netip.Addr][]string),
+		scanned:  make(map[netip.Addr]bool),
+	}
+}
+
+// addService records a service found on the address in the format port/protocol
+func (h *autoHosts) addService(ip netip.Addr, service string) {
+	if h == nil {
+		return
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.services[ip] = append(h.services[ip], service)
+}
+
+func (h *autoHosts) addDNSServer(ip netip.Addr) {
+	if h == nil {
+		return
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.dnsServers = append(h.dnsServers, ip)
+}
+
+func (h *autoHosts) setScanned(ip netip.Addr) {
+	if h == nil {
+		return
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.scanned[ip] = true
+}
+
+func (h *autoHosts) addName(name string, ip netip.Addr) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	name = strings.TrimSuffix(strings.ToLower(name), ".")
+	for _, existing := range h.names[name] {
+		if existing == ip {
+			return
+		}
+	}
+	h.names[name] = append(h.names[name], ip)
+}
+
+// unscannedIPv6 returns the resolved IPv6 addresses that were not scanned yet
+func (h *autoHosts) unscannedIPv6() []netip.Addr {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	seen := make(map[netip.Addr]bool)
+	var ret []netip.Addr
+	for _, addrs := range h.names {
+		for _, ip := range addrs {
+			if ip.Is6() && !h.scanned[ip] && !seen[ip] {
+				seen[ip] = true
+				ret = append(ret, ip)
+			}
+		}
+	}
+	sort.Slice(ret, func(i, j int) bool { return ret[i].Less(ret[j]) })
+	return ret
+}
+
+// logicalHost is a resolved name with the findings of all its addresses
+type logicalHost struct {
+	name string
+	ipv4 []string
+	ipv6 []string
+	// services maps the services to the address families they were found on
+	services map[string][]string
+}
+
+// merged returns the resolved names with services on any of their addresses
+func (h *autoHosts) merged() []logicalHost {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	var ret []logicalHost
+	for name, addrs := range h.names {
+		host := logicalHost{name: name, services: make(map[string][]string)}
+		for _, ip := range addrs {
+			family := "ipv4"
+			if ip.Is6() {
+				family = "ipv6"
+				host.ipv6 = append(host.ipv6, ip.String())
+			} else {
+				host.ipv4 = append(host.ipv4, ip.String())
+			}
+			for _, service := range h.services[ip] {
+				host.services[service] = append(host.services[service], family)
+			}
+		}
+		if len(host.services) > 0 {
+			ret = append(ret, host)
+		}
+	}
+	sort.Slice(ret, func(i, j int) bool { return ret[i].name < ret[j].name })
+	return ret
+}
+
+// finding returns the merged finding of the host. Services only reachable
+// over one address family point to firewall rules missing for the other
+func (l logicalHost) finding(relay string) results.Finding {
+	var services, ipv4Only, ipv6Only []string
+	for service, families := range l.services {
+		services = append(services, service)
+		v4, v6 := false, false
+		for _, family := range families {
+			v4 = v4 || family == "ipv4"
+			v6 = v6 || family == "ipv6"
+		}
+		switch {
+		case v4 && !v6 && len(l.ipv6) > 0:
+			ipv4Only = append(ipv4Only, service)
+		case v6 && !v4 && len(l.ipv4) > 0:
+			ipv6Only = append(ipv6Only, service)
+		}
+	}
+	sort.Strings(services)
+	sort.Strings(ipv4Only)
+	sort.Strings(ipv6Only)
+
+	details := map[string]string{
+		"ipv4":     strings.Join(l.ipv4, ","),
+		"ipv6":     strings.Join(l.ipv6, ","),
+		"services": strings.Join(services, ","),
+	}
+	if len(ipv4Only) > 0 {
+		details["ipv4_only"] = strings.Join(ipv4Only, ",")
+	}
+	if len(ipv6Only) > 0 {
+		details["ipv6_only"] = strings.Join(ipv6Only, ",")
+	}
+	return results.Finding{
+		Module:  "auto",
+		Relay:   relay,
+		Host:    l.name,
+		Service: "host",
+		Details: details,
+	}
+}
+
+// resolveDualStack resolves the name with A and AAAA queries on the DNS
+// server and records the addresses
+func resolveDualStack(opts UDPScannerOpts, hosts *autoHosts, dnsServer netip.Addr, name string) error {
+	allocation, err := internal.NewAllocation(opts.Log, opts.Protocol, opts.TurnServer, opts.UseTLS, opts.TlsVerify, opts.Timeouts.Setup, dnsServer, 53, opts.Username, opts.Password)
+	if err != nil {
+		return err
+	}
+	defer allocation.Close()
+
+	for _, qtype := range []uint16{helper.DNSTypeA, helper.DNSTypeAAAA} {
+		msg, err := relayDNSQuery(opts.Log, allocation.Conn, allocation.Channel, name, qtype, opts.Protocol == "tcp", opts.Timeouts.DNS)
+		if err != nil {
+			if errors.Is(err, helper.ErrTimeout) {
+				continue
+			}
+			return err
+		}
+		for _, a := range msg.Answers {
+			if a.Type != helper.DNSTypeA && a.Type != helper.DNSTypeAAAA {
+				continue
+			}
+			ip, err := netip.ParseAddr(a.Data)
+			if err != nil {
+				continue
+			}
+			opts.Log.Debugf("%s resolves to %s on %s", a.Name, ip, dnsServer)
+			hosts.addName(a.Name, ip)
+		}
+	}
+	return nil
+}
+
+// dualStackAvailable checks if the relay grants IPv6 allocations
+func dualStackAvailable(opts AutoOpts) (bool, error) {
+	conn, err := internal.Connect(opts.Protocol, opts.TurnServer, opts.UseTLS, opts.TlsVerify, opts.Timeouts.Setup)
+	if err != nil {
+		return false, err
+	}
+	defer conn.Close()
+
+	allocateRequest := internal.AllocateRequest(internal.RequestedTransportUDP, internal.AllocateProtocolIPv6)
+	allocateResponse, err := allocateRequest.SendAndReceive(opts.Log, conn, opts.Timeouts.Setup)
+	if err != nil {
+		return false, fmt.Errorf("error on sending AllocateRequest: %w", err)
+	}
+	if allocateResponse.Header.MessageType.Class != internal.MsgTypeClassError {
+		return false, fmt.Errorf("MessageClass is not Error (should be not authenticated)")
+	}
+	realm := string(allocateResponse.GetAttribute(internal.AttrRealm).Value)
+	nonce := string(allocateResponse.GetAttribute(internal.AttrNonce).Value)
+
+	allocateRequest = internal.AllocateRequestAuth(opts.Username, opts.Password, nonce, realm, internal.RequestedTransportUDP, internal.AllocateProtocolIPv6)
+	allocateResponse, err = allocateRequest.SendAndReceive(opts.Log, conn, opts.Timeouts.Setup)
+	if err != nil {
+		return false, fmt.Errorf("error on sending AllocateRequest Auth: %w", err)
+	}
+	if allocateResponse.Header.MessageType.Class == internal.MsgTypeClassError {
+		if code, ok := allocateResponse.GetErrorCode(); ok && code == internal.ErrorAddressFamilyNotSupported {
+			return false, nil
+		}
+		return false, fmt.Errorf("error on AllocateRequest Auth: %w", allocateResponse.GetError())
+	}
+
+	deallocateRequest := internal.DeallocateRequest(opts.Username, opts.Password, nonce, realm)
+	if _, err := deallocateRequest.SendAndReceive(opts.Log, conn, opts.Timeouts.Setup); err != nil {
+		opts.Log.Debugf("could not delete the IPv6 allocation: %v", err)
+	}
+	return true, nil
+}
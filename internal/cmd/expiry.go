@@ -0,0 +1,56 @@
+package cmd
+
+import (
+	"context"
+	"time"
+
+	"github.com/firefart/stunner/internal/helper"
+	"github.com/sirupsen/logrus"
+)
+
+// WatchCredentialExpiry logs a countdown until the time-limited credentials
+// returned by current expire. current is asked before every message, so
+// renewed credentials are picked up. Renewable credentials come from a
+// provider that returns new ones before the old ones expire. It returns
+// once ctx is done or the credentials are not time-limited
+func WatchCredentialExpiry(ctx context.Context, log *logrus.Logger, current func(context.Context) (helper.Credentials, error), renewable bool) {
+	var last helper.Credentials
+	for {
+		wait := time.Minute
+		creds, err := current(ctx)
+		if err != nil {
+			log.Debugf("could not get the credentials to check their expiry: %v", err)
+		} else {
+			expires, ok := creds.Expires()
+			if !ok {
+				return
+			}
+			left := time.Until(expires)
+			// providers deriving new credentials on every request would
+			// log this all the time
+			if last.Username != "" && creds.Username != last.Username && last.ExpiresWithin(helper.ExpiryMargin) {
+				log.Infof("renewed the credentials, they expire in %s at %s", left.Round(time.Second), expires.Format(time.RFC3339))
+			}
+			last = creds
+			switch {
+			case left <= 0 && !renewable:
+				log.Errorf("the credentials expired %s ago at %s, the server rejects new allocations and refreshes", (-left).Round(time.Second), expires.Format(time.RFC3339))
+				return
+			case left <= 0:
+				log.Errorf("the credentials expired %s ago at %s and were not renewed", (-left).Round(time.Second), expires.Format(time.RFC3339))
+			case left <= helper.ExpiryMargin && !renewable:
+				log.Warnf("the credentials expire in %s, new allocations fail afterwards. Use --credentials rest:USER where supported to derive new ones", left.Round(time.Second))
+			case left <= helper.ExpiryMargin:
+				log.Warnf("the credentials expire in %s, new allocations use renewed credentials", left.Round(time.Second))
+			default:
+				log.Infof("the credentials expire in %s at %s", left.Round(time.Second), expires.Format(time.RFC3339))
+			}
+			wait = helper.CountdownInterval(left)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(wait):
+		}
+	}
+}
@@ -0,0 +1,155 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/netip"
+	"strings"
+	"time"
+
+	"github.com/firefart/stunner/internal"
+	"github.com/firefart/stunner/internal/socksimplementations"
+	"github.com/sirupsen/logrus"
+)
+
+type ForwardOpts struct {
+	TurnServer string
+	Protocol   string
+	Username   string
+	Password   string
+	UseTLS     bool
+	TlsVerify  bool
+	Timeout    time.Duration
+	Log        *logrus.Logger
+	// Listen is the local address connections are accepted on
+	Listen string
+	// Remote is the internal address the connections are forwarded to
+	Remote netip.AddrPort
+	// Allow are the networks clients may connect from. All clients are
+	// allowed if empty
+	Allow []netip.Prefix
+	// number of retries on temporary server errors like 508
+	ConnectRetries int
+	RetryBackoff   time.Duration
+	// DrainTimeout is the time active connections have to finish on
+	// shutdown before their allocations are deleted
+	DrainTimeout time.Duration
+	// Pool shares allocations between connections instead of allocating a
+	// relay for every connection
+	Pool bool
+	// PoolConnections is the number of connections that share an allocation
+	PoolConnections int
+	// PoolIdle is the time an allocation without connections is kept open
+	PoolIdle time.Duration
+}
+
+func (opts ForwardOpts) Validate() error {
+	if opts.TurnServer == "" {
+		return fmt.Errorf("need a valid turnserver")
+	}
+	if !strings.Contains(opts.TurnServer, ":") {
+		return fmt.Errorf("turnserver needs a port")
+	}
+	if opts.Protocol != "tcp" && opts.Protocol != "udp" {
+		return fmt.Errorf("protocol needs to be either tcp or udp")
+	}
+	if opts.Username == "" {
+		return fmt.Errorf("please supply a username")
+	}
+	if opts.Password == "" {
+		return fmt.Errorf("please supply a password")
+	}
+	if opts.Log == nil {
+		return fmt.Errorf("please supply a valid logger")
+	}
+	if opts.Listen == "" {
+		return fmt.Errorf("please supply a valid listen address")
+	}
+	if !strings.Contains(opts.Listen, ":") {
+		return fmt.Errorf("listen %s must be in the format host:port", opts.Listen)
+	}
+	if !opts.Remote.IsValid() || opts.Remote.Port() == 0 {
+		return fmt.Errorf("please supply a valid remote address")
+	}
+	for _, prefix := range opts.Allow {
+		if !prefix.IsValid() {
+			return fmt.Errorf("please supply a valid allow list")
+		}
+	}
+	if opts.ConnectRetries < 0 {
+		return fmt.Errorf("connect retries can not be negative")
+	}
+	if opts.DrainTimeout < 0 {
+		return fmt.Errorf("drain timeout can not be negative")
+	}
+	if opts.Pool && opts.PoolConnections < 1 {
+		return fmt.Errorf("please supply a valid number of pooled connections")
+	}
+	if opts.Pool && opts.PoolIdle <= 0 {
+		return fmt.Errorf("please supply a valid pool idle timeout")
+	}
+
+	return nil
+}
+
+// Forward forwards all connections to the local address to the remote
+// address via the TURN server like ssh -L
+func Forward(opts ForwardOpts) error {
+	if err := opts.Validate(); err != nil {
+		return err
+	}
+
+	// the remote is chosen explicitly, so public addresses are allowed
+	handler, closeHandler := connectHandler(SocksOpts{
+		TurnServer:      opts.TurnServer,
+		Protocol:        opts.Protocol,
+		Username:        opts.Username,
+		Password:        opts.Password,
+		UseTLS:          opts.UseTLS,
+		TlsVerify:       opts.TlsVerify,
+		Timeout:         opts.Timeout,
+		Log:             opts.Log,
+		ConnectRetries:  opts.ConnectRetries,
+		RetryBackoff:    opts.RetryBackoff,
+		Pool:            opts.Pool,
+		PoolConnections: opts.PoolConnections,
+		PoolIdle:        opts.PoolIdle,
+	})
+	defer closeHandler()
+
+	server := &socksimplementations.ForwardServer{
+		Connect: handler,
+		Target:  opts.Remote,
+		Allow:   opts.Allow,
+		Log:     opts.Log,
+	}
+
+	// on shutdown no new connections are accepted and the active ones get
+	// some time to finish before their allocations are deleted
+	removeDrain := internal.OnDrain(func() {
+		if active := server.Active(); active > 0 && opts.DrainTimeout > 0 {
+			opts.Log.Infof("[forward] waiting up to %s for %d active connections to finish", opts.DrainTimeout, active)
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), opts.DrainTimeout)
+		defer cancel()
+		if err := server.Shutdown(ctx); err != nil && server.Active() > 0 {
+			opts.Log.Warnf("[forward] closing %d connections that did not finish in time", server.Active())
+		}
+	})
+	defer removeDrain()
+
+	listener, err := net.Listen("tcp", opts.Listen)
+	if err != nil {
+		return fmt.Errorf("could not listen on %s: %w", opts.Listen, err)
+	}
+	defer listener.Close()
+	opts.Log.Infof("forwarding %s to %s", opts.Listen, opts.Remote)
+	if len(opts.Allow) == 0 && !isLoopback(opts.Listen) {
+		opts.Log.Warnf("%s can be used by other hosts to reach %s, consider --allow", opts.Listen, opts.Remote)
+	}
+	go server.Serve(listener)
+	// runs until the listener is closed on shutdown
+	<-internal.Context().Done()
+	return nil
+}
@@ -0,0 +1,257 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/netip"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/firefart/stunner/internal"
+	"github.com/firefart/stunner/internal/helper"
+	"github.com/sirupsen/logrus"
+)
+
+type ForwardUDPOpts struct {
+	TurnServer string
+	Protocol   string
+	Username   string
+	Password   string
+	UseTLS     bool
+	TlsVerify  bool
+	Timeout    time.Duration
+	Log        *logrus.Logger
+	// Listen is the local address datagrams are accepted on
+	Listen string
+	// Remote is the internal address the datagrams are forwarded to
+	Remote netip.AddrPort
+	// Allow are the networks clients may send from. All clients are
+	// allowed if empty
+	Allow []netip.Prefix
+	// Idle is the time after which the allocation of a client that did
+	// not send or receive datagrams is released
+	Idle time.Duration
+}
+
+func (opts ForwardUDPOpts) Validate() error {
+	if opts.TurnServer == "" {
+		return fmt.Errorf("need a valid turnserver")
+	}
+	if !strings.Contains(opts.TurnServer, ":") {
+		return fmt.Errorf("turnserver needs a port")
+	}
+	if opts.Protocol != "tcp" && opts.Protocol != "udp" {
+		return fmt.Errorf("protocol needs to be either tcp or udp")
+	}
+	if opts.Username == "" {
+		return fmt.Errorf("please supply a username")
+	}
+	if opts.Password == "" {
+		return fmt.Errorf("please supply a password")
+	}
+	if opts.Log == nil {
+		return fmt.Errorf("please supply a valid logger")
+	}
+	if opts.Listen == "" {
+		return fmt.Errorf("please supply a valid listen address")
+	}
+	if !strings.Contains(opts.Listen, ":") {
+		return fmt.Errorf("listen %s must be in the format host:port", opts.Listen)
+	}
+	if !opts.Remote.IsValid() || opts.Remote.Port() == 0 {
+		return fmt.Errorf("please supply a valid remote address")
+	}
+	for _, prefix := range opts.Allow {
+		if !prefix.IsValid() {
+			return fmt.Errorf("please supply a valid allow list")
+		}
+	}
+	if opts.Idle <= 0 {
+		return fmt.Errorf("please supply a valid idle timeout")
+	}
+
+	return nil
+}
+
+// ForwardUDP forwards all datagrams sent to the local address to the
+// remote address via channels of the TURN server. Every client gets an
+// allocation of its own, so the responses can be sent back to it
+func ForwardUDP(opts ForwardUDPOpts) error {
+	if err := opts.Validate(); err != nil {
+		return err
+	}
+
+	addr, err := net.ResolveUDPAddr("udp", opts.Listen)
+	if err != nil {
+		return fmt.Errorf("invalid listen address %s: %w", opts.Listen, err)
+	}
+	conn, err := net.ListenUDP("udp", addr)
+	if err != nil {
+		return fmt.Errorf("could not listen on %s: %w", opts.Listen, err)
+	}
+	defer conn.Close()
+
+	f := &udpForwarder{
+		opts:    opts,
+		conn:    conn,
+		clients: make(map[netip.AddrPort]*udpForwardClient),
+	}
+	defer f.close()
+	opts.Log.Infof("forwarding udp://%s to udp://%s", opts.Listen, opts.Remote)
+	if len(opts.Allow) == 0 && !isLoopback(opts.Listen) {
+		opts.Log.Warnf("%s can be used by other hosts to reach %s, consider --allow", opts.Listen, opts.Remote)
+	}
+
+	stop := make(chan struct{})
+	defer close(stop)
+	go f.expire(stop)
+	return f.serve()
+}
+
+// udpForwarder relays the datagrams of the clients of the local socket
+type udpForwarder struct {
+	opts ForwardUDPOpts
+	conn *net.UDPConn
+
+	mu      sync.Mutex
+	clients map[netip.AddrPort]*udpForwardClient
+}
+
+// udpForwardClient is the allocation of a single client
+type udpForwardClient struct {
+	addr       netip.AddrPort
+	allocation *internal.Allocation
+	// lastSeen is the time of the last datagram in unix nanoseconds
+	lastSeen atomic.Int64
+}
+
+func (c *udpForwardClient) touch() {
+	c.lastSeen.Store(time.Now().UnixNano())
+}
+
+// serve relays the datagrams of the clients until the socket is closed
+func (f *udpForwarder) serve() error {
+	// ChannelData over TCP needs to be padded to a multiple of 4 bytes
+	padded := f.opts.Protocol == "tcp"
+	buf := make([]byte, 65535)
+	for {
+		n, from, err := f.conn.ReadFromUDPAddrPort(buf)
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return nil
+			}
+			return fmt.Errorf("error reading datagram: %w", err)
+		}
+		from = netip.AddrPortFrom(from.Addr().Unmap(), from.Port())
+		if !helper.InAllowList(f.opts.Allow, from.Addr()) {
+			f.opts.Log.Debugf("[forward] dropping datagram from %s as it is not in the allow list", from)
+			continue
+		}
+		client, err := f.client(from)
+		if err != nil {
+			f.opts.Log.Errorf("[forward] could not relay the datagrams of %s: %v", from, err)
+			continue
+		}
+		client.touch()
+		msg, err := internal.ChannelData(client.allocation.Channel, buf[:n], padded)
+		if err != nil {
+			f.opts.Log.Debugf("[forward] dropping datagram of %s: %v", from, err)
+			continue
+		}
+		if err := helper.ConnectionWrite(client.allocation.Conn, msg, f.opts.Timeout); err != nil {
+			f.opts.Log.Errorf("[forward] error sending datagram of %s: %v", from, err)
+			f.drop(client)
+		}
+	}
+}
+
+// client returns the allocation of the client and sets it up on its
+// first datagram
+func (f *udpForwarder) client(from netip.AddrPort) (*udpForwardClient, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if client, ok := f.clients[from]; ok {
+		return client, nil
+	}
+	allocation, err := internal.NewAllocation(f.opts.Log, f.opts.Protocol, f.opts.TurnServer, f.opts.UseTLS, f.opts.TlsVerify, f.opts.Timeout, f.opts.Remote.Addr(), f.opts.Remote.Port(), f.opts.Username, f.opts.Password)
+	if err != nil {
+		return nil, err
+	}
+	client := &udpForwardClient{addr: from, allocation: allocation}
+	client.touch()
+	f.clients[from] = client
+	f.opts.Log.Infof("[forward] relaying the datagrams of %s", from)
+	go f.receive(client)
+	return client, nil
+}
+
+// receive sends the datagrams of the remote to the client until the
+// allocation fails or is released
+func (f *udpForwarder) receive(client *udpForwardClient) {
+	err := client.allocation.Relay(func(payload []byte) error {
+		client.touch()
+		if _, err := f.conn.WriteToUDPAddrPort(payload, client.addr); err != nil {
+			f.opts.Log.Debugf("[forward] could not send datagram to %s: %v", client.addr, err)
+		}
+		return nil
+	})
+	if f.drop(client) {
+		f.opts.Log.Errorf("[forward] error receiving the datagrams of %s: %v", client.addr, err)
+	}
+}
+
+// expire releases the allocations of idle clients until stop is closed
+func (f *udpForwarder) expire(stop <-chan struct{}) {
+	interval := f.opts.Idle / 2
+	if interval > time.Minute {
+		interval = time.Minute
+	}
+	tick := time.NewTicker(interval)
+	defer tick.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-tick.C:
+		}
+		f.mu.Lock()
+		var idle []*udpForwardClient
+		for _, client := range f.clients {
+			if time.Since(time.Unix(0, client.lastSeen.Load())) >= f.opts.Idle {
+				idle = append(idle, client)
+			}
+		}
+		f.mu.Unlock()
+		for _, client := range idle {
+			if f.drop(client) {
+				f.opts.Log.Infof("[forward] released the idle allocation of %s", client.addr)
+			}
+		}
+	}
+}
+
+// drop closes the allocation of the client. The next datagram of the
+// client sets up a new one. Returns false if it was already dropped
+func (f *udpForwarder) drop(client *udpForwardClient) bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.clients[client.addr] != client {
+		return false
+	}
+	delete(f.clients, client.addr)
+	client.allocation.Close()
+	return true
+}
+
+// close closes the allocations of all clients
+func (f *udpForwarder) close() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for addr, client := range f.clients {
+		client.allocation.Close()
+		delete(f.clients, addr)
+	}
+}
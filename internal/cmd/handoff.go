@@ -0,0 +1,73 @@
+package cmd
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/firefart/stunner/internal"
+	"github.com/sirupsen/logrus"
+)
+
+type HandoffOpts struct {
+	TurnServer string
+	Username   string
+	Password   string
+	UseTLS     bool
+	TlsVerify  bool
+	Timeout    time.Duration
+	Log        *logrus.Logger
+	// Socket is the unix socket the allocation is handed off on
+	Socket string
+	// IPv6 requests a relay for IPv6 peers
+	IPv6 bool
+}
+
+func (opts HandoffOpts) Validate() error {
+	if opts.TurnServer == "" {
+		return fmt.Errorf("need a valid turnserver")
+	}
+	if !strings.Contains(opts.TurnServer, ":") {
+		return fmt.Errorf("turnserver needs a port")
+	}
+	if opts.Username == "" {
+		return fmt.Errorf("please supply a username")
+	}
+	if opts.Password == "" {
+		return fmt.Errorf("please supply a password")
+	}
+	if opts.Log == nil {
+		return fmt.Errorf("please supply a valid logger")
+	}
+	if opts.Socket == "" {
+		return fmt.Errorf("please supply a valid socket path")
+	}
+	return nil
+}
+
+// Handoff allocates a TCP relay and passes it to the first process that
+// connects to the socket, for example the socks command. This way single
+// use credentials can be used by multiple commands.
+func Handoff(opts HandoffOpts) error {
+	if err := opts.Validate(); err != nil {
+		return err
+	}
+
+	addressFamily := internal.AllocateProtocolIgnore
+	if opts.IPv6 {
+		addressFamily = internal.AllocateProtocolIPv6
+	}
+	allocation, err := internal.NewTCPAllocation(opts.Log, opts.TurnServer, opts.UseTLS, opts.TlsVerify, opts.Timeout, addressFamily, opts.Username, opts.Password)
+	if err != nil {
+		return err
+	}
+	// the receiving process keeps its own copy of the connection open
+	defer allocation.Close()
+
+	opts.Log.Infof("allocated a TCP relay, waiting for a process to take it over on %s", opts.Socket)
+	if err := allocation.Handoff(opts.Socket); err != nil {
+		return err
+	}
+	opts.Log.Info("allocation handed off")
+	return nil
+}
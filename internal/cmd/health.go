@@ -0,0 +1,115 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"net/netip"
+	"strings"
+	"time"
+
+	"github.com/firefart/stunner/internal"
+	"github.com/firefart/stunner/internal/helper"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// healthPings is the number of refresh requests used to measure the
+	// round trip time and the loss to the TURN server
+	healthPings = 10
+	// healthPingInterval is the pause between two refresh requests
+	healthPingInterval = 100 * time.Millisecond
+)
+
+// healthCheck allocates a relay on the TURN server, binds a channel to the
+// target and measures the round trip time and the loss to the server. If
+// the relay is unusable an error with a diagnosis is returned so long scans
+// are aborted before producing thousands of timeouts
+func healthCheck(log *logrus.Logger, protocol, turnServer string, useTLS, tlsVerify bool, timeout time.Duration, target netip.Addr, port uint16, username, password string) error {
+	log.Infof("checking the relay with a channel to %s", netip.AddrPortFrom(target, port))
+	allocation, err := internal.NewAllocation(log, protocol, turnServer, useTLS, tlsVerify, timeout, target, port, username, password)
+	if err != nil {
+		return fmt.Errorf("health check failed, %s: %w", diagnoseSetup(err, target), err)
+	}
+	defer allocation.Close()
+
+	var rtts []time.Duration
+	lost := 0
+	for i := 0; i < healthPings; i++ {
+		if i > 0 {
+			time.Sleep(healthPingInterval)
+		}
+		rtt, err := allocation.Ping()
+		if errors.Is(err, helper.ErrTimeout) {
+			lost++
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("health check failed, %s: %w", diagnoseSetup(err, target), err)
+		}
+		rtts = append(rtts, rtt)
+	}
+
+	if lost*2 >= healthPings {
+		return fmt.Errorf("health check failed, %d of %d requests to the TURN server were lost. The relay is unusable, check the network or increase --timeout", lost, healthPings)
+	}
+
+	var min, max, sum time.Duration
+	for i, rtt := range rtts {
+		if i == 0 || rtt < min {
+			min = rtt
+		}
+		if rtt > max {
+			max = rtt
+		}
+		sum += rtt
+	}
+	avg := sum / time.Duration(len(rtts))
+	log.Infof("relay is usable: rtt min/avg/max %s/%s/%s, %d%% loss", min.Round(time.Millisecond), avg.Round(time.Millisecond), max.Round(time.Millisecond), lost*100/healthPings)
+	if lost > 0 {
+		log.Warnf("%d of %d requests to the TURN server were lost, expect timeouts during the scan and consider --retries", lost, healthPings)
+	}
+	if avg > timeout/2 {
+		log.Warnf("the average rtt of %s is close to the timeout of %s, consider increasing --timeout", avg.Round(time.Millisecond), timeout)
+	}
+	return nil
+}
+
+// diagnoseSetup explains why the relay could not be set up
+func diagnoseSetup(err error, target netip.Addr) string {
+	if errors.Is(err, helper.ErrTimeout) {
+		return "the TURN server does not answer. The transport is probably filtered, try another --protocol"
+	}
+	var respErr *internal.ResponseError
+	if !errors.As(err, &respErr) {
+		return "could not set up the relay"
+	}
+	switch respErr.Code {
+	case internal.ErrorUnauthorized, internal.ErrorWrongCredentials:
+		return "the credentials were rejected. Temporary credentials may have expired"
+	case internal.ErrorForbidden:
+		return fmt.Sprintf("the relay refuses to forward to %s. The range is probably filtered", target)
+	case internal.ErrorAddressFamilyNotSupported, internal.ErrorPeerAddressFamilyMissmatch:
+		return fmt.Sprintf("the relay does not support the address family of %s", target)
+	case internal.ErrorAllocationQuotaReached:
+		return "the allocation quota of the user is reached. Wait until old allocations expire"
+	case internal.ErrorInsufficientCapacity:
+		return "the TURN server is out of capacity"
+	}
+	return "could not set up the relay"
+}
+
+// firstTarget returns the first address of the IP ranges as a target for
+// the health check
+func firstTarget(ipRanges []string) (netip.Addr, error) {
+	for _, r := range ipRanges {
+		if strings.Contains(r, "/") {
+			prefix, err := netip.ParsePrefix(r)
+			if err != nil {
+				return netip.Addr{}, err
+			}
+			return prefix.Masked().Addr(), nil
+		}
+		return netip.ParseAddr(r)
+	}
+	return netip.Addr{}, fmt.Errorf("no targets to check")
+}
@@ -0,0 +1,123 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strings"
+
+	"github.com/firefart/stunner/internal"
+	"github.com/firefart/stunner/internal/socksimplementations"
+)
+
+// HTTPProxyOpts are the options of the socks command the CONNECT handler
+// is built from. The options of BIND, UDP ASSOCIATE, the transparent proxy
+// and the data copy are not used
+type HTTPProxyOpts struct {
+	SocksOpts
+}
+
+func (opts HTTPProxyOpts) Validate() error {
+	if opts.TurnServer == "" {
+		return fmt.Errorf("need a valid turnserver")
+	}
+	if !strings.Contains(opts.TurnServer, ":") {
+		return fmt.Errorf("turnserver needs a port")
+	}
+	if opts.Protocol != "tcp" && opts.Protocol != "udp" {
+		return fmt.Errorf("protocol needs to be either tcp or udp")
+	}
+	if opts.Credentials == nil && opts.Username == "" {
+		return fmt.Errorf("please supply a username")
+	}
+	if opts.Credentials == nil && opts.Password == "" {
+		return fmt.Errorf("please supply a password")
+	}
+	if opts.Log == nil {
+		return fmt.Errorf("please supply a valid logger")
+	}
+	if opts.ConnectRetries < 0 {
+		return fmt.Errorf("connect retries can not be negative")
+	}
+	if opts.DrainTimeout < 0 {
+		return fmt.Errorf("drain timeout can not be negative")
+	}
+	if opts.Pool && opts.PoolConnections < 1 {
+		return fmt.Errorf("please supply a valid number of pooled connections")
+	}
+	if opts.Pool && opts.PoolIdle <= 0 {
+		return fmt.Errorf("please supply a valid pool idle timeout")
+	}
+	if len(opts.Listen) == 0 {
+		return fmt.Errorf("please supply a valid listen address")
+	}
+	for username, password := range opts.Users {
+		if username == "" || password == "" {
+			return fmt.Errorf("please supply a valid proxy user")
+		}
+	}
+	for _, prefix := range opts.Allow {
+		if !prefix.IsValid() {
+			return fmt.Errorf("please supply a valid allow list")
+		}
+	}
+	for _, listen := range opts.Listen {
+		if listen == "" {
+			return fmt.Errorf("please supply a valid listen address")
+		}
+		if !strings.Contains(listen, ":") {
+			return fmt.Errorf("listen %s must be in the format host:port", listen)
+		}
+	}
+
+	return nil
+}
+
+// HTTPProxy starts an HTTP proxy that relays the connections of CONNECT
+// requests like the CONNECT command of the socks command
+func HTTPProxy(opts HTTPProxyOpts) error {
+	if err := opts.Validate(); err != nil {
+		return err
+	}
+
+	handler, closeHandler := connectHandler(opts.SocksOpts)
+	defer closeHandler()
+
+	server := &socksimplementations.HTTPServer{
+		Connect: handler,
+		Users:   opts.Users,
+		Allow:   opts.Allow,
+		Timeout: opts.Timeout,
+		Log:     opts.Log,
+	}
+
+	// on shutdown no new connections are accepted and the active ones get
+	// some time to finish before their allocations are deleted
+	removeDrain := internal.OnDrain(func() {
+		if active := server.Active(); active > 0 && opts.DrainTimeout > 0 {
+			opts.Log.Infof("[http] waiting up to %s for %d active connections to finish", opts.DrainTimeout, active)
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), opts.DrainTimeout)
+		defer cancel()
+		if err := server.Shutdown(ctx); err != nil && server.Active() > 0 {
+			opts.Log.Warnf("[http] closing %d connections that did not finish in time", server.Active())
+		}
+	})
+	defer removeDrain()
+
+	for _, listen := range opts.Listen {
+		listener, err := net.Listen("tcp", listen)
+		if err != nil {
+			return fmt.Errorf("could not start HTTP proxy on %s: %w", listen, err)
+		}
+		defer listener.Close()
+		opts.Log.Infof("starting HTTP proxy on %s", listen)
+		if len(opts.Users) == 0 && len(opts.Allow) == 0 && !isLoopback(listen) {
+			opts.Log.Warnf("the HTTP proxy on %s can be used by other hosts without authentication, consider --proxy-user or --allow", listen)
+		}
+		go server.Serve(listener)
+	}
+	// runs until the listeners are closed on shutdown
+	<-internal.Context().Done()
+	return nil
+}
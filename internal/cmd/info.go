@@ -1,12 +1,18 @@
 package cmd
 
 import (
+	"encoding/binary"
+	"errors"
 	"fmt"
+	"net"
+	"sort"
+	"strconv"
 	"strings"
 	"time"
 
 	"github.com/firefart/stunner/internal"
 	"github.com/firefart/stunner/internal/helper"
+	"github.com/firefart/stunner/internal/results"
 	"github.com/sirupsen/logrus"
 )
 
@@ -17,6 +23,14 @@ type InfoOpts struct {
 	Protocol   string
 	Timeout    time.Duration
 	Log        *logrus.Logger
+	// Username and Password are optional. Without them only what the
+	// server reveals to unauthenticated clients is probed
+	Username string
+	Password string
+	// QuotaProbe is the maximum number of allocations held at the same
+	// time to find the allocation quota of the user. 0 disables the probe
+	QuotaProbe int
+	Output     string
 }
 
 func (opts InfoOpts) Validate() error {
@@ -32,41 +46,367 @@ func (opts InfoOpts) Validate() error {
 	if opts.Log == nil {
 		return fmt.Errorf("please supply a valid logger")
 	}
+	if (opts.Username == "") != (opts.Password == "") {
+		return fmt.Errorf("please supply both a username and a password")
+	}
+	if opts.QuotaProbe < 0 {
+		return fmt.Errorf("quota probe can not be negative")
+	}
+	if opts.QuotaProbe > 0 && opts.Username == "" {
+		return fmt.Errorf("the quota probe needs a username and a password")
+	}
 
 	return nil
 }
 
+// Support is the result of probing a capability of the server
+type Support string
+
+const (
+	SupportYes     Support = "yes"
+	SupportNo      Support = "no"
+	SupportUnknown Support = "unknown"
+)
+
+// ServerProfile is the capability matrix of a TURN server. info writes it
+// as a finding so other commands can load it with LoadServerProfile
+// instead of probing the server again
+type ServerProfile struct {
+	Server    string
+	Transport string
+	STUN      Support
+	TURN      Support
+	Software  string
+	// SoftwareGuess is the implementation guessed from Software
+	SoftwareGuess string
+	Realm         string
+	// AuthModes are the authentication mechanisms offered to
+	// unauthenticated clients
+	AuthModes []string
+	RelayUDP  Support
+	RelayTCP  Support
+	IPv4      Support
+	IPv6      Support
+	// DefaultLifetime is the lifetime of a new allocation, MaxLifetime the
+	// longest lifetime granted on a refresh
+	DefaultLifetime time.Duration
+	MaxLifetime     time.Duration
+	// Quota is the number of allocations the user can hold at the same
+	// time. It is 0 if the limit was not reached within QuotaProbed
+	// allocations
+	Quota       int
+	QuotaProbed int
+	// OtherAddress is the alternate address announced for RFC 5780 NAT
+	// behaviour discovery
+	OtherAddress string
+	// OtherAddressSTUN and OtherAddressTURN are the results of probing the
+	// other address
+	OtherAddressSTUN Support
+	OtherAddressTURN Support
+	// Attributes are the non standard attributes returned by the server
+	Attributes map[string]string
+}
+
+func newServerProfile(opts InfoOpts) *ServerProfile {
+	return &ServerProfile{
+		Server:     opts.TurnServer,
+		Transport:  internal.Transport{Protocol: opts.Protocol, TLS: opts.UseTLS}.String(),
+		STUN:       SupportUnknown,
+		TURN:       SupportUnknown,
+		RelayUDP:   SupportUnknown,
+		RelayTCP:   SupportUnknown,
+		IPv4:       SupportUnknown,
+		IPv6:       SupportUnknown,
+		Attributes: make(map[string]string),
+	}
+}
+
+// Details returns the matrix as finding details
+func (p ServerProfile) Details() map[string]string {
+	details := map[string]string{
+		"stun":      string(p.STUN),
+		"turn":      string(p.TURN),
+		"relay_udp": string(p.RelayUDP),
+		"relay_tcp": string(p.RelayTCP),
+		"ipv4":      string(p.IPv4),
+		"ipv6":      string(p.IPv6),
+	}
+	set := func(key, value string) {
+		if value != "" {
+			details[key] = value
+		}
+	}
+	set("software", p.Software)
+	set("software_guess", p.SoftwareGuess)
+	set("realm", p.Realm)
+	set("auth_modes", strings.Join(p.AuthModes, ","))
+	set("other_address", p.OtherAddress)
+	if p.OtherAddress != "" {
+		set("other_address_stun", string(p.OtherAddressSTUN))
+		set("other_address_turn", string(p.OtherAddressTURN))
+	}
+	if p.DefaultLifetime > 0 {
+		details["default_lifetime"] = p.DefaultLifetime.String()
+	}
+	if p.MaxLifetime > 0 {
+		details["max_lifetime"] = p.MaxLifetime.String()
+	}
+	if p.QuotaProbed > 0 {
+		details["quota"] = strconv.Itoa(p.Quota)
+		details["quota_probed"] = strconv.Itoa(p.QuotaProbed)
+	}
+	for name, value := range p.Attributes {
+		details["attr_"+name] = value
+	}
+	return details
+}
+
+// Finding returns the profile as finding of the info module
+func (p ServerProfile) Finding() results.Finding {
+	host, _, err := net.SplitHostPort(p.Server)
+	if err != nil {
+		host = p.Server
+	}
+	return results.Finding{
+		Module:    "info",
+		Relay:     p.Server,
+		Host:      host,
+		Service:   "profile",
+		Transport: p.Transport,
+		Details:   p.Details(),
+	}
+}
+
+// profileFromFinding is the reverse of Finding
+func profileFromFinding(f results.Finding) *ServerProfile {
+	d := f.Details
+	support := func(key string) Support {
+		if v := Support(d[key]); v == SupportYes || v == SupportNo {
+			return v
+		}
+		return SupportUnknown
+	}
+	p := &ServerProfile{
+		Server:        f.Relay,
+		Transport:     f.Transport,
+		STUN:          support("stun"),
+		TURN:          support("turn"),
+		Software:      d["software"],
+		SoftwareGuess: d["software_guess"],
+		Realm:         d["realm"],
+		RelayUDP:      support("relay_udp"),
+		RelayTCP:      support("relay_tcp"),
+		IPv4:          support("ipv4"),
+		IPv6:          support("ipv6"),
+		OtherAddress:  d["other_address"],
+		Attributes:    make(map[string]string),
+	}
+	if p.OtherAddress != "" {
+		p.OtherAddressSTUN = support("other_address_stun")
+		p.OtherAddressTURN = support("other_address_turn")
+	}
+	if d["auth_modes"] != "" {
+		p.AuthModes = strings.Split(d["auth_modes"], ",")
+	}
+	p.DefaultLifetime, _ = time.ParseDuration(d["default_lifetime"])
+	p.MaxLifetime, _ = time.ParseDuration(d["max_lifetime"])
+	p.Quota, _ = strconv.Atoi(d["quota"])
+	p.QuotaProbed, _ = strconv.Atoi(d["quota_probed"])
+	for key, value := range d {
+		if name := strings.TrimPrefix(key, "attr_"); name != key {
+			p.Attributes[name] = value
+		}
+	}
+	return p
+}
+
+// LoadServerProfile returns the latest profile of the TURN server from a
+// results file written by info
+func LoadServerProfile(filename, turnServer string) (*ServerProfile, error) {
+	findings, err := results.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	var ret *ServerProfile
+	for _, f := range findings {
+		if f.Module == "info" && f.Service == "profile" && f.Relay == turnServer {
+			ret = profileFromFinding(f)
+		}
+	}
+	if ret == nil {
+		return nil, fmt.Errorf("%s contains no profile of %s, run info first", filename, turnServer)
+	}
+	return ret, nil
+}
+
+// Info probes the capabilities of the server and prints them as matrix.
+// Relaying, address families, lifetimes and the quota need credentials
 func Info(opts InfoOpts) error {
 	if err := opts.Validate(); err != nil {
 		return err
 	}
 
+	writer, err := results.NewWriter(opts.Output)
+	if err != nil {
+		return err
+	}
+	defer writer.Close()
+
+	p := ProbeServer(opts)
+	printProfile(opts.Log, p)
+	return writer.Write(p.Finding())
+}
+
+// ProbeServer builds the profile of the server. Failed probes leave the
+// capabilities unknown
+func ProbeServer(opts InfoOpts) *ServerProfile {
+	p := newServerProfile(opts)
+
 	if attr, err := testStun(opts); err != nil {
 		opts.Log.Debugf("STUN error: %v", err)
-		opts.Log.Error("this server does not support the STUN protocol")
+		p.STUN = SupportNo
 	} else {
-		opts.Log.Info("this server supports the STUN protocol")
-		printAttributes(opts, attr)
+		p.STUN = SupportYes
+		p.addAttributes(opts.Log, attr)
 	}
-	opts.Log.Info("opts %w", opts.TlsVerify)
 
 	if attr, err := testTurn(opts, internal.RequestedTransportUDP); err != nil {
 		opts.Log.Debugf("TURN UDP error: %v", err)
-		opts.Log.Error("this server does not support the TURN UDP protocol")
+		p.TURN = SupportNo
 	} else {
-		opts.Log.Info("this server supports the TURN protocol with UDP transports")
-		printAttributes(opts, attr)
+		p.TURN = SupportYes
+		p.addAttributes(opts.Log, attr)
+		p.addAuthModes(attr)
 	}
 
-	if attr, err := testTurn(opts, internal.RequestedTransportTCP); err != nil {
-		opts.Log.Debugf("TURN TCP error: %v", err)
-		opts.Log.Error("this server does not support the TURN TCP protocol")
-	} else {
-		opts.Log.Info("this server supports the TURN protocol with TCP transports")
-		printAttributes(opts, attr)
+	if p.OtherAddress != "" {
+		p.probeOtherAddress(opts)
 	}
 
-	return nil
+	if p.TURN != SupportYes {
+		return p
+	}
+	if opts.Username == "" {
+		// servers checking the transport before the credentials reveal
+		// if TCP allocations are supported
+		_, err := testTurn(opts, internal.RequestedTransportTCP)
+		var respErr *internal.ResponseError
+		if errors.As(err, &respErr) && respErr.Code == internal.ErrorUnsupportedTransportProtocol {
+			p.RelayTCP = SupportNo
+		}
+		opts.Log.Debug("no credentials supplied, skipping the authenticated probes")
+		return p
+	}
+
+	prober := &infoProber{opts: opts}
+	prober.probeRelaying(p)
+	prober.probeLifetimes(p)
+	if opts.QuotaProbe > 0 {
+		prober.probeQuota(p)
+	}
+	return p
+}
+
+func printProfile(log *logrus.Logger, p *ServerProfile) {
+	if p.STUN == SupportNo && p.TURN == SupportNo {
+		log.Errorf("%s does not speak STUN or TURN", p.Server)
+	}
+	details := p.Details()
+	rows := []string{"stun", "turn", "software", "software_guess", "realm", "auth_modes", "relay_udp", "relay_tcp", "ipv4", "ipv6", "default_lifetime", "max_lifetime", "quota", "other_address", "other_address_stun", "other_address_turn"}
+	for _, row := range rows {
+		value, ok := details[row]
+		if !ok {
+			continue
+		}
+		if row == "quota" && value == "0" {
+			value = fmt.Sprintf("more than %s", details["quota_probed"])
+		}
+		log.Infof("%-17s %s", row, value)
+	}
+
+	var names []string
+	for name := range p.Attributes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		log.Warnf("%-17s %s", "attribute "+name, p.Attributes[name])
+	}
+}
+
+// addAttributes records the software and the non standard attributes
+func (p *ServerProfile) addAttributes(log *logrus.Logger, attr []internal.Attribute) {
+	for _, a := range attr {
+		switch a.Type {
+		case internal.AttrSoftware:
+			p.Software = string(a.Value)
+			p.SoftwareGuess = helper.FingerprintTURNSoftware(p.Software)
+		case internal.AttrRealm:
+			p.Realm = string(a.Value)
+		case internal.AttrOtherAddress:
+			// old RFC5780 but still implemented (for example in coturn)
+			ip, port, err := internal.ParseMappedAdress(a.Value)
+			if err != nil {
+				log.Debugf("could not parse mapped address: %02x %v", a.Value, err)
+				continue
+			}
+			p.OtherAddress = net.JoinHostPort(ip.String(), strconv.Itoa(int(port)))
+		}
+		if internal.AttributeTypeString(a.Type) != "" {
+			continue
+		}
+		value := string(a.Value)
+		if !helper.IsPrintable(value) {
+			value = fmt.Sprintf("%02x", a.Value)
+		}
+		p.Attributes[fmt.Sprintf("%d", uint16(a.Type))] = value
+	}
+}
+
+// addAuthModes derives the authentication mechanisms from the answer to
+// an unauthenticated allocation
+func (p *ServerProfile) addAuthModes(attr []internal.Attribute) {
+	has := func(t internal.AttributeType) bool {
+		for _, a := range attr {
+			if a.Type == t {
+				return true
+			}
+		}
+		return false
+	}
+	if !has(internal.AttrErrorCode) {
+		p.AuthModes = append(p.AuthModes, "none")
+		return
+	}
+	if has(internal.AttrNonce) {
+		p.AuthModes = append(p.AuthModes, "long-term")
+	}
+	if has(internal.AttrPasswordAlgorithms) {
+		p.AuthModes = append(p.AuthModes, "long-term-sha256")
+	}
+	if has(internal.AttrThirdPartyAuthorization) {
+		p.AuthModes = append(p.AuthModes, "oauth")
+	}
+}
+
+// probeOtherAddress checks if the announced other address answers STUN and
+// TURN as well. It is often firewalled less strictly than the main address
+func (p *ServerProfile) probeOtherAddress(opts InfoOpts) {
+	other := opts
+	other.TurnServer = p.OtherAddress
+	p.OtherAddressSTUN = SupportYes
+	if _, err := testStun(other); err != nil {
+		opts.Log.Debugf("STUN error on other address %s: %v", p.OtherAddress, err)
+		p.OtherAddressSTUN = SupportNo
+	}
+	p.OtherAddressTURN = SupportYes
+	if _, err := testTurn(other, internal.RequestedTransportUDP); err != nil {
+		opts.Log.Debugf("TURN UDP error on other address %s: %v", p.OtherAddress, err)
+		p.OtherAddressTURN = SupportNo
+	}
+	if p.OtherAddressTURN == SupportYes && p.TURN != SupportYes {
+		opts.Log.Warnf("the other address %s speaks TURN while %s does not", p.OtherAddress, p.Server)
+	}
 }
 
 func testStun(opts InfoOpts) ([]internal.Attribute, error) {
@@ -88,6 +428,8 @@ func testStun(opts InfoOpts) ([]internal.Attribute, error) {
 	return bindingResponse.Attributes, nil
 }
 
+// testTurn sends an unauthenticated allocation. The response is returned
+// even if the allocation was granted, which is deleted right away
 func testTurn(opts InfoOpts, proto internal.RequestedTransport) ([]internal.Attribute, error) {
 	conn, err := internal.Connect(opts.Protocol, opts.TurnServer, opts.UseTLS, opts.TlsVerify, opts.Timeout)
 	if err != nil {
@@ -100,52 +442,185 @@ func testTurn(opts InfoOpts, proto internal.RequestedTransport) ([]internal.Attr
 	if err != nil {
 		return nil, fmt.Errorf("error on sending allocate request: %w", err)
 	}
-	if allocateResponse.Header.MessageType.Class != internal.MsgTypeClassError {
-		return nil, fmt.Errorf("MessageClass is not Error (should be not authenticated)")
+	switch allocateResponse.Header.MessageType.Class {
+	case internal.MsgTypeClassError:
+		code, ok := allocateResponse.GetErrorCode()
+		if !ok {
+			return nil, fmt.Errorf("error response without error code")
+		}
+		if code != internal.ErrorUnauthorized {
+			return nil, fmt.Errorf("unexpected error: %w", allocateResponse.GetError())
+		}
+	case internal.MsgTypeClassSuccess:
+		opts.Log.Warn("the server grants allocations without authentication")
+		// a refresh without credentials deletes an allocation granted without them
+		deallocateRequest := internal.RefreshRequest("", "", "", "")
+		deallocateRequest.Attributes = []internal.Attribute{{
+			Type:  internal.AttrLifetime,
+			Value: helper.PutUint32(0),
+		}}
+		if _, err := deallocateRequest.SendAndReceive(opts.Log, conn, opts.Timeout); err != nil {
+			opts.Log.Debugf("could not delete the allocation: %v", err)
+		}
+	default:
+		return nil, fmt.Errorf("unexpected message class %02x", allocateResponse.Header.MessageType.Class)
 	}
 
 	return allocateResponse.Attributes, nil
 }
 
-func printAttributes(opts InfoOpts, attr []internal.Attribute) {
-	if len(attr) == 0 {
-		return
+// infoProber runs the probes that need credentials
+type infoProber struct {
+	opts InfoOpts
+}
+
+// infoAllocation is an allocation held by the prober
+type infoAllocation struct {
+	conn  net.Conn
+	realm string
+	nonce string
+}
+
+// allocate requests an authenticated allocation on a new connection. The
+// allocation is nil if the request was rejected, the response is returned
+// in both cases
+func (i *infoProber) allocate(transport internal.RequestedTransport, family internal.AllocateProtocol) (*infoAllocation, *internal.Stun, error) {
+	conn, err := internal.Connect(i.opts.Protocol, i.opts.TurnServer, i.opts.UseTLS, i.opts.TlsVerify, i.opts.Timeout)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	allocateRequest := internal.AllocateRequest(transport, family)
+	allocateResponse, err := allocateRequest.SendAndReceive(i.opts.Log, conn, i.opts.Timeout)
+	if err != nil {
+		conn.Close()
+		return nil, nil, fmt.Errorf("error on sending allocate request: %w", err)
+	}
+	a := &infoAllocation{
+		conn:  conn,
+		realm: string(allocateResponse.GetAttribute(internal.AttrRealm).Value),
+		nonce: string(allocateResponse.GetAttribute(internal.AttrNonce).Value),
+	}
+	allocateRequest = internal.AllocateRequestAuth(i.opts.Username, i.opts.Password, a.nonce, a.realm, transport, family)
+	allocateResponse, err = allocateRequest.SendAndReceive(i.opts.Log, conn, i.opts.Timeout)
+	if err != nil {
+		conn.Close()
+		return nil, nil, fmt.Errorf("error on sending allocate request auth: %w", err)
+	}
+	if allocateResponse.Header.MessageType.Class != internal.MsgTypeClassSuccess {
+		conn.Close()
+		return nil, allocateResponse, nil
 	}
+	return a, allocateResponse, nil
+}
 
-	headerPrinted := false
+// release deletes the allocation and closes the connection
+func (i *infoProber) release(a *infoAllocation) {
+	defer a.conn.Close()
+	deallocateRequest := internal.DeallocateRequest(i.opts.Username, i.opts.Password, a.nonce, a.realm)
+	if _, err := deallocateRequest.SendAndReceive(i.opts.Log, a.conn, i.opts.Timeout); err != nil {
+		i.opts.Log.Debugf("could not delete the allocation: %v", err)
+	}
+}
 
-	for _, a := range attr {
-		// do not print common protocol related attributes
-		if a.Type == internal.AttrNonce || a.Type == internal.AttrErrorCode || a.Type == internal.AttrFingerprint || a.Type == internal.AttrXorMappedAddress || a.Type == internal.AttrMappedAddress {
-			continue
+// probeSupport requests an allocation and maps the response to the
+// support of the capability. rejected is the error code meaning the
+// capability is not supported
+func (i *infoProber) probeSupport(transport internal.RequestedTransport, family internal.AllocateProtocol, rejected internal.ErrorCode) (Support, *internal.Stun) {
+	a, resp, err := i.allocate(transport, family)
+	if err != nil {
+		i.opts.Log.Debugf("error on probing: %v", err)
+		return SupportUnknown, nil
+	}
+	if a == nil {
+		if code, ok := resp.GetErrorCode(); ok && code == rejected {
+			return SupportNo, resp
 		}
+		i.opts.Log.Debugf("allocation rejected: %s", resp.GetErrorString())
+		return SupportUnknown, resp
+	}
+	i.release(a)
+	return SupportYes, resp
+}
 
-		// inside here so we don't print an unnecessary "Attributes:" line
-		if !headerPrinted {
-			opts.Log.Info("Attributes:")
-			headerPrinted = true
+func (i *infoProber) probeRelaying(p *ServerProfile) {
+	var resp *internal.Stun
+	p.RelayUDP, resp = i.probeSupport(internal.RequestedTransportUDP, internal.AllocateProtocolIgnore, internal.ErrorUnsupportedTransportProtocol)
+	// the relayed address of the default allocation shows the default family
+	if p.RelayUDP == SupportYes {
+		host, _, err := internal.ConvertXORAddr(resp.GetAttribute(internal.AttrXorRelayedAddress).Value, resp.Header.TransactionID)
+		if err == nil && !strings.Contains(host, ":") {
+			p.IPv4 = SupportYes
 		}
+		if lifetime := resp.GetAttribute(internal.AttrLifetime).Value; len(lifetime) == 4 {
+			p.DefaultLifetime = time.Duration(binary.BigEndian.Uint32(lifetime)) * time.Second
+		}
+	}
+	p.RelayTCP, _ = i.probeSupport(internal.RequestedTransportTCP, internal.AllocateProtocolIgnore, internal.ErrorUnsupportedTransportProtocol)
+	p.IPv6, _ = i.probeSupport(internal.RequestedTransportUDP, internal.AllocateProtocolIPv6, internal.ErrorAddressFamilyNotSupported)
+	if p.IPv4 != SupportYes {
+		p.IPv4, _ = i.probeSupport(internal.RequestedTransportUDP, internal.AllocateProtocolIPv4, internal.ErrorAddressFamilyNotSupported)
+	}
+}
 
-		value := string(a.Value)
-		// checks for old RFC5780 but still implemented (for example in coturn)
-		if a.Type == internal.AttrResponseOrigin || a.Type == internal.AttrOtherAddress {
-			tmpIP, tmpPort, err := internal.ParseMappedAdress(a.Value)
-			if err != nil {
-				opts.Log.Errorf("could not parse mapped address: %02x %v", a.Value, err)
-				continue
-			}
-			value = fmt.Sprintf("%s:%d", tmpIP.String(), tmpPort)
+// probeLifetimes requests the longest possible lifetime on a refresh.
+// Servers grant the lower of the requested and their maximum lifetime
+func (i *infoProber) probeLifetimes(p *ServerProfile) {
+	a, resp, err := i.allocate(internal.RequestedTransportUDP, internal.AllocateProtocolIgnore)
+	if err != nil || a == nil {
+		i.opts.Log.Debugf("could not allocate to probe the lifetime: %v", err)
+		return
+	}
+	defer i.release(a)
+	if lifetime := resp.GetAttribute(internal.AttrLifetime).Value; len(lifetime) == 4 && p.DefaultLifetime == 0 {
+		p.DefaultLifetime = time.Duration(binary.BigEndian.Uint32(lifetime)) * time.Second
+	}
+
+	refreshRequest := internal.RefreshRequest(i.opts.Username, i.opts.Password, a.nonce, a.realm)
+	refreshRequest.Attributes = append(refreshRequest.Attributes, internal.Attribute{
+		Type:  internal.AttrLifetime,
+		Value: helper.PutUint32(0xffffffff),
+	})
+	refreshResponse, err := refreshRequest.SendAndReceive(i.opts.Log, a.conn, i.opts.Timeout)
+	if err != nil {
+		i.opts.Log.Debugf("error on sending refresh request: %v", err)
+		return
+	}
+	if refreshResponse.Header.MessageType.Class != internal.MsgTypeClassSuccess {
+		i.opts.Log.Debugf("refresh rejected: %s", refreshResponse.GetErrorString())
+		return
+	}
+	if lifetime := refreshResponse.GetAttribute(internal.AttrLifetime).Value; len(lifetime) == 4 {
+		p.MaxLifetime = time.Duration(binary.BigEndian.Uint32(lifetime)) * time.Second
+	}
+}
+
+// probeQuota holds up to QuotaProbe allocations at the same time until
+// the server answers with 486 Allocation Quota Reached
+func (i *infoProber) probeQuota(p *ServerProfile) {
+	var held []*infoAllocation
+	defer func() {
+		for _, a := range held {
+			i.release(a)
 		}
+	}()
 
-		humanName := internal.AttributeTypeString(a.Type)
-		if humanName == "" {
-			if helper.IsPrintable(value) {
-				opts.Log.Warnf("\tNon Standard Attribute %d returned with value %s", uint16(a.Type), value)
-			} else {
-				opts.Log.Warnf("\tNon Standard Attribute %d returned with value %02x", uint16(a.Type), a.Value)
+	for len(held) < i.opts.QuotaProbe {
+		a, resp, err := i.allocate(internal.RequestedTransportUDP, internal.AllocateProtocolIgnore)
+		if err != nil {
+			i.opts.Log.Debugf("error on probing the quota: %v", err)
+			return
+		}
+		if a == nil {
+			if code, ok := resp.GetErrorCode(); ok && code == internal.ErrorAllocationQuotaReached {
+				p.Quota = len(held)
+				p.QuotaProbed = len(held) + 1
+				return
 			}
-		} else {
-			opts.Log.Infof("\t%s: %s", humanName, value)
+			i.opts.Log.Debugf("allocation rejected while probing the quota: %s", resp.GetErrorString())
+			return
 		}
+		held = append(held, a)
 	}
+	p.QuotaProbed = len(held)
 }
@@ -0,0 +1,448 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/netip"
+	"strings"
+	"time"
+
+	"github.com/firefart/stunner/internal"
+	"github.com/firefart/stunner/internal/helper"
+	"github.com/firefart/stunner/internal/results"
+	"github.com/sirupsen/logrus"
+)
+
+// l2PermissionBatch is the number of peers installed with a single
+// CreatePermission request
+const l2PermissionBatch = 32
+
+type L2ProbeOpts struct {
+	TurnServer string
+	Protocol   string
+	Username   string
+	Password   string
+	UseTLS     bool
+	TlsVerify  bool
+	Timeout    time.Duration
+	Log        *logrus.Logger
+	// Subnet is the network segment of the relay host. Permissions for all
+	// hosts are installed so their unicast replies are forwarded and the
+	// NetBIOS query is sent to its broadcast address
+	Subnet netip.Prefix
+	// Wait is the time to collect the replies of each probe
+	Wait   time.Duration
+	Output string
+}
+
+func (opts L2ProbeOpts) Validate() error {
+	if opts.TurnServer == "" {
+		return fmt.Errorf("need a valid turnserver")
+	}
+	if !strings.Contains(opts.TurnServer, ":") {
+		return fmt.Errorf("turnserver needs a port")
+	}
+	if opts.Protocol != "tcp" && opts.Protocol != "udp" {
+		return fmt.Errorf("protocol needs to be either tcp or udp")
+	}
+	if opts.Username == "" {
+		return fmt.Errorf("please supply a username")
+	}
+	if opts.Password == "" {
+		return fmt.Errorf("please supply a password")
+	}
+	if opts.Log == nil {
+		return fmt.Errorf("please supply a valid logger")
+	}
+	if !opts.Subnet.IsValid() || !opts.Subnet.Addr().Is4() {
+		return fmt.Errorf("please supply a valid IPv4 subnet")
+	}
+	if opts.Subnet.Bits() < 22 {
+		return fmt.Errorf("subnet needs to be a /22 or smaller")
+	}
+	if opts.Wait <= 0 {
+		return fmt.Errorf("please supply a valid wait time")
+	}
+
+	return nil
+}
+
+// l2Probe is a single multicast or broadcast query
+type l2Probe struct {
+	service string
+	target  netip.AddrPort
+	payload []byte
+	// parse returns the details of a reply. The returned bool is set if
+	// the reply means the responder answers arbitrary names
+	parse func(data []byte) (map[string]string, bool, error)
+}
+
+// L2Probe sends LLMNR, NetBIOS, mDNS and SSDP queries via the relay to
+// find out if the relay forwards multicast and broadcast traffic and who
+// answers on the segment of the relay host. LLMNR and NetBIOS queries
+// ask for a random name so any answer comes from a poisoning tool
+func L2Probe(opts L2ProbeOpts) error {
+	if err := opts.Validate(); err != nil {
+		return err
+	}
+
+	writer, err := results.NewWriter(opts.Output)
+	if err != nil {
+		return err
+	}
+	defer writer.Close()
+
+	a, err := newL2Allocation(opts)
+	if err != nil {
+		return err
+	}
+	defer a.Close()
+
+	granted := a.permitSubnet()
+	if granted == 0 {
+		return fmt.Errorf("all permissions for %s were denied, replies from the segment can not be received", opts.Subnet)
+	}
+	opts.Log.Infof("installed permissions for %d hosts of %s", granted, opts.Subnet)
+
+	forwarded := false
+	for _, probe := range l2Probes(opts.Subnet) {
+		finding := results.Finding{
+			Module:   "l2-probe",
+			Relay:    opts.TurnServer,
+			Host:     probe.target.Addr().String(),
+			Port:     probe.target.Port(),
+			Protocol: "udp",
+		}
+		if err := a.createPermission([]netip.Addr{probe.target.Addr()}); err != nil {
+			opts.Log.Infof("%s: relay refuses to forward to %s: %v", probe.service, probe.target, err)
+			finding.Error = err.Error()
+			if err := writer.Write(finding); err != nil {
+				return err
+			}
+			continue
+		}
+
+		replies, err := a.probe(probe)
+		if err != nil {
+			return err
+		}
+		if len(replies) == 0 {
+			opts.Log.Infof("%s: no replies to the query to %s", probe.service, probe.target)
+			continue
+		}
+		for _, reply := range replies {
+			forwarded = true
+			finding := results.Finding{
+				Module:   "l2-probe",
+				Relay:    opts.TurnServer,
+				Host:     reply.from.Addr().String(),
+				Port:     reply.from.Port(),
+				Protocol: "udp",
+				Service:  probe.service,
+				Details:  reply.details,
+			}
+			finding.Details["probe_target"] = probe.target.String()
+			if reply.poisoner {
+				opts.Log.Warnf("%s: %s answers queries for random names, a poisoning tool like Responder is running on the segment", probe.service, reply.from.Addr())
+			} else {
+				opts.Log.Infof("%s: reply from %s: %s", probe.service, reply.from, l2Summary(reply.details))
+			}
+			if err := writer.Write(finding); err != nil {
+				return err
+			}
+		}
+	}
+
+	if forwarded {
+		opts.Log.Warnf("the relay host shares a broadcast domain with %s. An attacker on the relay host can answer LLMNR, NetBIOS and mDNS queries of the segment and relay the captured authentication", opts.Subnet)
+	} else {
+		opts.Log.Infof("no replies from %s. Either the relay drops multicast and broadcast traffic or nobody on the segment uses these protocols", opts.Subnet)
+	}
+	if opts.Output != "" {
+		opts.Log.Infof("results written to %s", opts.Output)
+	}
+	return nil
+}
+
+// l2Probes returns the queries sent to the segment
+func l2Probes(subnet netip.Prefix) []l2Probe {
+	return []l2Probe{
+		{
+			service: "llmnr",
+			target:  netip.MustParseAddrPort("224.0.0.252:5355"),
+			payload: helper.LinkLocalDNSQuery(strings.ToLower(helper.RandomString(12)), helper.DNSTypeA),
+			parse:   l2ParseLLMNR,
+		},
+		{
+			service: "nbns",
+			target:  netip.AddrPortFrom(broadcastAddr(subnet), 137),
+			payload: helper.NBNSQuery(helper.RandomString(12)),
+			parse:   l2ParseNBNS,
+		},
+		{
+			service: "mdns",
+			target:  netip.MustParseAddrPort("224.0.0.251:5353"),
+			payload: helper.LinkLocalDNSQuery("_services._dns-sd._udp.local", helper.DNSTypePTR),
+			parse:   l2ParseMDNS,
+		},
+		{
+			service: "ssdp",
+			target:  netip.MustParseAddrPort("239.255.255.250:1900"),
+			payload: helper.SSDPSearch(helper.SSDPMulticast, "ssdp:all"),
+			parse:   l2ParseSSDP,
+		},
+	}
+}
+
+// broadcastAddr returns the last address of the subnet
+func broadcastAddr(subnet netip.Prefix) netip.Addr {
+	ip := subnet.Masked().Addr().As4()
+	hostBits := 32 - subnet.Bits()
+	for i := 3; i >= 0 && hostBits > 0; i-- {
+		bits := hostBits
+		if bits > 8 {
+			bits = 8
+		}
+		ip[i] |= byte(1<<bits - 1)
+		hostBits -= bits
+	}
+	return netip.AddrFrom4(ip)
+}
+
+// l2ParseLLMNR parses a LLMNR reply. As the query is for a random
+// name every answer is poisoned
+func l2ParseLLMNR(data []byte) (map[string]string, bool, error) {
+	msg, err := helper.ParseDNSMessage(data)
+	if err != nil {
+		return nil, false, err
+	}
+	if msg.Flags&0x8000 == 0 {
+		return nil, false, fmt.Errorf("message is not a response")
+	}
+	var answers []string
+	for _, a := range msg.Answers {
+		answers = append(answers, a.Data)
+	}
+	details := map[string]string{
+		"rcode":   helper.DNSRCodeString(msg.RCode),
+		"answers": strings.Join(answers, ","),
+	}
+	return details, len(answers) > 0, nil
+}
+
+// l2ParseNBNS parses a NetBIOS name query response. As the query is for
+// a random name every positive response is poisoned
+func l2ParseNBNS(data []byte) (map[string]string, bool, error) {
+	resp, err := helper.ParseNBNSResponse(data)
+	if err != nil {
+		return nil, false, err
+	}
+	var answers []string
+	for _, a := range resp.Addrs {
+		answers = append(answers, a.String())
+	}
+	details := map[string]string{
+		"name":    resp.Name,
+		"answers": strings.Join(answers, ","),
+	}
+	return details, true, nil
+}
+
+func l2ParseMDNS(data []byte) (map[string]string, bool, error) {
+	msg, err := helper.ParseDNSMessage(data)
+	if err != nil {
+		return nil, false, err
+	}
+	if msg.Flags&0x8000 == 0 {
+		return nil, false, fmt.Errorf("message is not a response")
+	}
+	var services []string
+	for _, a := range msg.Answers {
+		services = append(services, a.Data)
+	}
+	return map[string]string{"services": strings.Join(services, ",")}, false, nil
+}
+
+func l2ParseSSDP(data []byte) (map[string]string, bool, error) {
+	resp, err := helper.ParseSSDPResponse(data)
+	if err != nil {
+		return nil, false, err
+	}
+	details := make(map[string]string)
+	for key, v := range map[string]string{"server": resp.Server, "location": resp.Location, "st": resp.ST} {
+		if v != "" {
+			details[key] = v
+		}
+	}
+	return details, false, nil
+}
+
+// l2Summary returns the details as a single line for logging
+func l2Summary(details map[string]string) string {
+	var parts []string
+	for _, key := range []string{"answers", "services", "server", "location", "st"} {
+		if v := details[key]; v != "" {
+			parts = append(parts, fmt.Sprintf("%s=%s", key, v))
+		}
+	}
+	return strings.Join(parts, " ")
+}
+
+// l2Reply is a parsed reply to a probe
+type l2Reply struct {
+	from     netip.AddrPort
+	details  map[string]string
+	poisoner bool
+}
+
+// l2Allocation is an authenticated UDP allocation with permissions for
+// many peers
+type l2Allocation struct {
+	opts  L2ProbeOpts
+	conn  net.Conn
+	realm string
+	nonce string
+}
+
+func newL2Allocation(opts L2ProbeOpts) (*l2Allocation, error) {
+	conn, err := internal.Connect(opts.Protocol, opts.TurnServer, opts.UseTLS, opts.TlsVerify, opts.Timeout)
+	if err != nil {
+		return nil, err
+	}
+
+	allocateRequest := internal.AllocateRequest(internal.RequestedTransportUDP, internal.AllocateProtocolIgnore)
+	allocateResponse, err := allocateRequest.SendAndReceive(opts.Log, conn, opts.Timeout)
+	if err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("error on sending AllocateRequest: %w", err)
+	}
+	if allocateResponse.Header.MessageType.Class != internal.MsgTypeClassError {
+		conn.Close()
+		return nil, fmt.Errorf("MessageClass is not Error (should be not authenticated)")
+	}
+
+	realm := string(allocateResponse.GetAttribute(internal.AttrRealm).Value)
+	nonce := string(allocateResponse.GetAttribute(internal.AttrNonce).Value)
+
+	allocateRequest = internal.AllocateRequestAuth(opts.Username, opts.Password, nonce, realm, internal.RequestedTransportUDP, internal.AllocateProtocolIgnore)
+	allocateResponse, err = allocateRequest.SendAndReceive(opts.Log, conn, opts.Timeout)
+	if err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("error on sending AllocateRequest Auth: %w", err)
+	}
+	if allocateResponse.Header.MessageType.Class == internal.MsgTypeClassError {
+		conn.Close()
+		return nil, fmt.Errorf("error on AllocateRequest Auth: %w", allocateResponse.GetError())
+	}
+	if host, port, err := internal.ConvertXORAddr(allocateResponse.GetAttribute(internal.AttrXorRelayedAddress).Value, allocateResponse.Header.TransactionID); err == nil {
+		opts.Log.Infof("relayed address of the allocation: %s:%d", host, port)
+	}
+
+	return &l2Allocation{
+		opts:  opts,
+		conn:  conn,
+		realm: realm,
+		nonce: nonce,
+	}, nil
+}
+
+// permitSubnet installs permissions for all hosts of the subnet in
+// batches and returns the number of granted permissions
+func (a *l2Allocation) permitSubnet() int {
+	var hosts []netip.Addr
+	for ip := a.opts.Subnet.Masked().Addr(); a.opts.Subnet.Contains(ip); ip = ip.Next() {
+		hosts = append(hosts, ip)
+	}
+	if a.opts.Subnet.Bits() < 31 {
+		// skip the network and the broadcast address
+		hosts = hosts[1 : len(hosts)-1]
+	}
+
+	granted := 0
+	for len(hosts) > 0 {
+		batch := hosts
+		if len(batch) > l2PermissionBatch {
+			batch = batch[:l2PermissionBatch]
+		}
+		hosts = hosts[len(batch):]
+		if err := a.createPermission(batch); err != nil {
+			a.opts.Log.Debugf("permission for %s - %s denied: %v", batch[0], batch[len(batch)-1], err)
+			continue
+		}
+		granted += len(batch)
+	}
+	return granted
+}
+
+// createPermission installs permissions for the targets. A stale nonce is
+// renewed and the request is retried once
+func (a *l2Allocation) createPermission(targets []netip.Addr) error {
+	for attempt := 0; ; attempt++ {
+		permissionRequest, err := internal.CreatePermissionsRequest(a.opts.Username, a.opts.Password, a.nonce, a.realm, targets)
+		if err != nil {
+			return fmt.Errorf("error on generating CreatePermissionRequest: %w", err)
+		}
+		permissionResponse, err := permissionRequest.SendAndReceive(a.opts.Log, a.conn, a.opts.Timeout)
+		if err != nil {
+			return fmt.Errorf("error on sending CreatePermissionRequest: %w", err)
+		}
+		if permissionResponse.Header.MessageType.Class != internal.MsgTypeClassError {
+			return nil
+		}
+		if code, ok := permissionResponse.GetErrorCode(); ok && code == internal.ErrorStaleNonce && attempt == 0 {
+			a.nonce = string(permissionResponse.GetAttribute(internal.AttrNonce).Value)
+			continue
+		}
+		return fmt.Errorf("error on CreatePermission: %w", permissionResponse.GetError())
+	}
+}
+
+// probe sends the query and collects the replies until the wait time
+// is over
+func (a *l2Allocation) probe(probe l2Probe) ([]l2Reply, error) {
+	indication, err := internal.SendIndication(probe.target.Addr(), probe.target.Port(), probe.payload, false)
+	if err != nil {
+		return nil, fmt.Errorf("could not create send indication: %w", err)
+	}
+	if err := indication.Send(a.opts.Log, a.conn, a.opts.Timeout); err != nil {
+		return nil, err
+	}
+
+	var replies []l2Reply
+	deadline := time.Now().Add(a.opts.Wait)
+	for time.Now().Before(deadline) {
+		resp, err := helper.ConnectionRead(a.conn, time.Until(deadline))
+		if errors.Is(err, helper.ErrTimeout) {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("error on reading response: %w", err)
+		}
+		from, data, err := internal.ParseDataIndication(resp)
+		if err != nil {
+			a.opts.Log.Debugf("ignoring invalid response: %v", err)
+			continue
+		}
+		details, poisoner, err := probe.parse(data)
+		if err != nil {
+			a.opts.Log.Debugf("ignoring invalid %s reply from %s: %v", probe.service, from, err)
+			continue
+		}
+		replies = append(replies, l2Reply{
+			from:     from,
+			details:  details,
+			poisoner: poisoner,
+		})
+	}
+	return replies, nil
+}
+
+// Close deletes the allocation on the server and closes the connection
+func (a *l2Allocation) Close() {
+	req := internal.DeallocateRequest(a.opts.Username, a.opts.Password, a.nonce, a.realm)
+	if _, err := req.SendAndReceive(a.opts.Log, a.conn, a.opts.Timeout); err != nil {
+		a.opts.Log.Debugf("could not delete allocation: %v", err)
+	}
+	a.conn.Close()
+}
@@ -0,0 +1,82 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/firefart/stunner/internal/results"
+	"github.com/sirupsen/logrus"
+)
+
+type MergeOpts struct {
+	Log    *logrus.Logger
+	Files  []string
+	Output string
+}
+
+func (opts MergeOpts) Validate() error {
+	if opts.Log == nil {
+		return fmt.Errorf("please supply a valid logger")
+	}
+	if len(opts.Files) == 0 {
+		return fmt.Errorf("please supply at least one result file")
+	}
+	if opts.Output == "" {
+		return fmt.Errorf("please supply an output file")
+	}
+	// the writer appends so an existing file would contain duplicates afterwards
+	if _, err := os.Stat(opts.Output); err == nil {
+		return fmt.Errorf("output file %s already exists", opts.Output)
+	} else if !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("could not check output file: %w", err)
+	}
+
+	return nil
+}
+
+// Merge combines the result files of multiple runs, dedupes
+// the findings and notes all relays a host was reachable through
+func Merge(opts MergeOpts) error {
+	if err := opts.Validate(); err != nil {
+		return err
+	}
+
+	var findings []results.Finding
+	for _, filename := range opts.Files {
+		f, err := results.ReadFile(filename)
+		if err != nil {
+			return fmt.Errorf("could not read %s: %w", filename, err)
+		}
+		opts.Log.Debugf("read %d findings from %s", len(f), filename)
+		findings = append(findings, f...)
+	}
+
+	merged := results.Merge(findings)
+
+	writer, err := results.NewWriter(opts.Output)
+	if err != nil {
+		return err
+	}
+	defer writer.Close()
+	for _, f := range merged {
+		if err := writer.Write(f); err != nil {
+			return err
+		}
+	}
+
+	hostRelays := results.HostRelays(merged)
+	hosts := make([]string, 0, len(hostRelays))
+	for host := range hostRelays {
+		hosts = append(hosts, host)
+	}
+	sort.Strings(hosts)
+	for _, host := range hosts {
+		opts.Log.Infof("%s reachable via %s", host, strings.Join(hostRelays[host], ", "))
+	}
+
+	opts.Log.Infof("merged %d findings into %d unique findings on %d hosts", len(findings), len(merged), len(hosts))
+	return nil
+}
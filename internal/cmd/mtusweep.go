@@ -0,0 +1,153 @@
+package cmd
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"net"
+	"net/netip"
+	"strings"
+	"time"
+
+	"github.com/firefart/stunner/internal"
+	"github.com/firefart/stunner/internal/helper"
+	"github.com/sirupsen/logrus"
+)
+
+type MTUSweepOpts struct {
+	TurnServer string
+	Protocol   string
+	Username   string
+	Password   string
+	UseTLS     bool
+	TlsVerify  bool
+	Timeout    time.Duration
+	Log        *logrus.Logger
+	Target     netip.AddrPort
+	MinSize    int
+	MaxSize    int
+}
+
+func (opts MTUSweepOpts) Validate() error {
+	if opts.TurnServer == "" {
+		return fmt.Errorf("need a valid turnserver")
+	}
+	if !strings.Contains(opts.TurnServer, ":") {
+		return fmt.Errorf("turnserver needs a port")
+	}
+	if opts.Protocol != "tcp" && opts.Protocol != "udp" {
+		return fmt.Errorf("protocol needs to be either tcp or udp")
+	}
+	if opts.Username == "" {
+		return fmt.Errorf("please supply a username")
+	}
+	if opts.Password == "" {
+		return fmt.Errorf("please supply a password")
+	}
+	if opts.Log == nil {
+		return fmt.Errorf("please supply a valid logger")
+	}
+	if !opts.Target.IsValid() {
+		return fmt.Errorf("please supply a valid target")
+	}
+	if opts.MinSize < 64 {
+		return fmt.Errorf("min size needs to be at least 64 bytes")
+	}
+	if opts.MaxSize <= opts.MinSize || opts.MaxSize > 65000 {
+		return fmt.Errorf("max size needs to be between min size and 65000 bytes")
+	}
+
+	return nil
+}
+
+// MTUSweep finds the largest payload the relay forwards to the target
+// without fragmentation. It sends padded DNS queries in Send indications
+// with the DONT-FRAGMENT attribute and searches for the largest query
+// that is still answered
+func MTUSweep(opts MTUSweepOpts) error {
+	if err := opts.Validate(); err != nil {
+		return err
+	}
+
+	remote, _, _, err := internal.SetupTurnConnection(opts.Log, opts.Protocol, opts.TurnServer, opts.UseTLS, opts.TlsVerify, opts.Timeout, opts.Target.Addr(), opts.Target.Port(), opts.Username, opts.Password)
+	if err != nil {
+		return err
+	}
+	defer remote.Close()
+
+	ok, err := mtuProbe(opts, remote, opts.MinSize)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return fmt.Errorf("no answer to a probe of %d bytes. The target needs to be a DNS server reachable via the relay and the relay needs to support DONT-FRAGMENT", opts.MinSize)
+	}
+
+	// lo is always answered, hi is always dropped
+	lo, hi := opts.MinSize, opts.MaxSize+1
+	for hi-lo > 1 {
+		size := lo + (hi-lo)/2
+		ok, err := mtuProbe(opts, remote, size)
+		if err != nil {
+			return err
+		}
+		opts.Log.Debugf("probe with %d bytes answered: %t", size, ok)
+		if ok {
+			lo = size
+		} else {
+			hi = size
+		}
+	}
+
+	indication, err := internal.SendIndication(opts.Target.Addr(), opts.Target.Port(), make([]byte, lo), true)
+	if err != nil {
+		return fmt.Errorf("could not create send indication: %w", err)
+	}
+	buf, err := indication.Serialize()
+	if err != nil {
+		return fmt.Errorf("could not serialize send indication: %w", err)
+	}
+
+	if lo == opts.MaxSize {
+		opts.Log.Infof("all probes up to the max size of %d bytes traversed the relay", lo)
+	}
+	opts.Log.Infof("largest payload forwarded without fragmentation: %d bytes (%d bytes Send indication)", lo, len(buf))
+	opts.Log.Infof("use --max-payload %d on the udp-scanner to flag probes that can not traverse the relay", lo)
+	return nil
+}
+
+// mtuProbe sends a padded DNS query of the given size and returns true
+// if it was answered. Probes are sent twice to not mistake packet loss
+// for a too large payload
+func mtuProbe(opts MTUSweepOpts, remote net.Conn, size int) (bool, error) {
+	for attempt := 0; attempt < 2; attempt++ {
+		query := helper.DNSQueryPadded(".", helper.DNSTypeNS, size)
+		indication, err := internal.SendIndication(opts.Target.Addr(), opts.Target.Port(), query, true)
+		if err != nil {
+			return false, fmt.Errorf("could not create send indication: %w", err)
+		}
+		if err := indication.Send(opts.Log, remote, opts.Timeout); err != nil {
+			return false, err
+		}
+
+		for {
+			resp, err := helper.ConnectionRead(remote, opts.Timeout)
+			if errors.Is(err, helper.ErrTimeout) {
+				break
+			}
+			if err != nil {
+				return false, fmt.Errorf("error on reading response: %w", err)
+			}
+			data, err := internal.ExtractDataIndication(resp)
+			if err != nil {
+				opts.Log.Debugf("ignoring invalid response: %v", err)
+				continue
+			}
+			// any answer with our transaction ID means the query arrived
+			if len(data) >= 2 && bytes.Equal(data[:2], query[:2]) {
+				return true, nil
+			}
+		}
+	}
+	return false, nil
+}
@@ -0,0 +1,219 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/netip"
+	"os"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/firefart/stunner/internal"
+	"github.com/firefart/stunner/internal/helper"
+	"github.com/sirupsen/logrus"
+)
+
+// ncChunkSize is the maximum payload of a single ChannelData message.
+// It is small enough to not get fragmented on common links
+const ncChunkSize = 1200
+
+type NCOpts struct {
+	TurnServer string
+	Protocol   string
+	Username   string
+	Password   string
+	UseTLS     bool
+	TlsVerify  bool
+	Timeout    time.Duration
+	Log        *logrus.Logger
+	Target     netip.AddrPort
+	// UDP relays the data to a UDP port of the target instead of
+	// connecting via TCP
+	UDP bool
+	// Wait is the time to wait for data of the target after stdin is
+	// closed. If 0 it waits until the target closes the connection
+	Wait time.Duration
+}
+
+func (opts NCOpts) Validate() error {
+	if opts.TurnServer == "" {
+		return fmt.Errorf("need a valid turnserver")
+	}
+	if !strings.Contains(opts.TurnServer, ":") {
+		return fmt.Errorf("turnserver needs a port")
+	}
+	if opts.Protocol != "tcp" && opts.Protocol != "udp" {
+		return fmt.Errorf("protocol needs to be either tcp or udp")
+	}
+	if opts.Username == "" {
+		return fmt.Errorf("please supply a username")
+	}
+	if opts.Password == "" {
+		return fmt.Errorf("please supply a password")
+	}
+	if opts.Log == nil {
+		return fmt.Errorf("please supply a valid logger")
+	}
+	if !opts.Target.IsValid() {
+		return fmt.Errorf("please supply a valid target")
+	}
+	if opts.Wait < 0 {
+		return fmt.Errorf("wait must not be negative")
+	}
+
+	return nil
+}
+
+// NC connects stdin and stdout to a single target via the TURN server.
+// All log messages are written to stderr so the output can be piped
+func NC(opts NCOpts) error {
+	if err := opts.Validate(); err != nil {
+		return err
+	}
+
+	if opts.UDP {
+		return ncUDP(opts)
+	}
+	return ncTCP(opts)
+}
+
+// ncTCP uses a TCP allocation with a Connect request to the target. TCP
+// allocations always use TCP to the TURN server so opts.Protocol is ignored
+func ncTCP(opts NCOpts) error {
+	conn, err := internal.DialTurnTCP(opts.Log, opts.TurnServer, opts.UseTLS, opts.TlsVerify, opts.Timeout, opts.Target.Addr(), opts.Target.Port(), opts.Username, opts.Password)
+	if err != nil {
+		return fmt.Errorf("could not connect to %s: %w", opts.Target, err)
+	}
+	defer conn.Close()
+	opts.Log.Infof("connected to tcp://%s", opts.Target)
+
+	out := newActivityWriter(os.Stdout)
+	remoteDone := make(chan error, 1)
+	go func() {
+		n, err := io.Copy(out, conn)
+		opts.Log.Debugf("received %d bytes from %s", n, opts.Target)
+		remoteDone <- err
+	}()
+
+	stdinDone := make(chan error, 1)
+	go func() {
+		n, err := io.Copy(conn, os.Stdin)
+		opts.Log.Debugf("sent %d bytes to %s", n, opts.Target)
+		if err == nil {
+			// signal the end of the input to the target
+			err = conn.CloseWrite()
+		}
+		stdinDone <- err
+	}()
+
+	return ncWait(opts, out, remoteDone, stdinDone)
+}
+
+// ncUDP uses a UDP allocation with a channel bound to the target. Every
+// read from stdin is sent as a single datagram
+func ncUDP(opts NCOpts) error {
+	allocation, err := internal.NewAllocation(opts.Log, opts.Protocol, opts.TurnServer, opts.UseTLS, opts.TlsVerify, opts.Timeout, opts.Target.Addr(), opts.Target.Port(), opts.Username, opts.Password)
+	if err != nil {
+		return fmt.Errorf("could not set up a channel to %s: %w", opts.Target, err)
+	}
+	defer allocation.Close()
+	opts.Log.Infof("connected to udp://%s", opts.Target)
+
+	// ChannelData over TCP needs to be padded to a multiple of 4 bytes
+	padded := opts.Protocol == "tcp"
+
+	out := newActivityWriter(os.Stdout)
+	remoteDone := make(chan error, 1)
+	go func() {
+		remoteDone <- allocation.Relay(func(payload []byte) error {
+			_, err := out.Write(payload)
+			return err
+		})
+	}()
+
+	stdinDone := make(chan error, 1)
+	go func() {
+		buf := make([]byte, ncChunkSize)
+		for {
+			n, err := os.Stdin.Read(buf)
+			if n > 0 {
+				msg, err := internal.ChannelData(allocation.Channel, buf[:n], padded)
+				if err != nil {
+					stdinDone <- err
+					return
+				}
+				if err := helper.ConnectionWrite(allocation.Conn, msg, opts.Timeout); err != nil {
+					stdinDone <- err
+					return
+				}
+			}
+			if errors.Is(err, io.EOF) {
+				stdinDone <- nil
+				return
+			}
+			if err != nil {
+				stdinDone <- err
+				return
+			}
+		}
+	}()
+
+	return ncWait(opts, out, remoteDone, stdinDone)
+}
+
+// ncWait returns when the target closed the connection or, after stdin
+// was closed, no data was received for opts.Wait
+func ncWait(opts NCOpts, out *activityWriter, remoteDone, stdinDone <-chan error) error {
+	select {
+	case err := <-remoteDone:
+		return err
+	case err := <-stdinDone:
+		if err != nil {
+			return fmt.Errorf("error on sending data: %w", err)
+		}
+	}
+
+	opts.Log.Debug("stdin closed, waiting for the target")
+	if opts.Wait == 0 {
+		return <-remoteDone
+	}
+	out.touch()
+	for {
+		idle := time.Until(out.lastActivity().Add(opts.Wait))
+		if idle <= 0 {
+			return nil
+		}
+		select {
+		case err := <-remoteDone:
+			return err
+		case <-time.After(idle):
+		}
+	}
+}
+
+// activityWriter records the time of the last write
+type activityWriter struct {
+	w    io.Writer
+	last atomic.Int64
+}
+
+func newActivityWriter(w io.Writer) *activityWriter {
+	a := &activityWriter{w: w}
+	a.touch()
+	return a
+}
+
+func (a *activityWriter) Write(p []byte) (int, error) {
+	a.touch()
+	return a.w.Write(p)
+}
+
+func (a *activityWriter) touch() {
+	a.last.Store(time.Now().UnixNano())
+}
+
+func (a *activityWriter) lastActivity() time.Time {
+	return time.Unix(0, a.last.Load())
+}
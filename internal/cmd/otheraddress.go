@@ -0,0 +1,80 @@
+package cmd
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"time"
+
+	"github.com/firefart/stunner/internal"
+	"github.com/sirupsen/logrus"
+)
+
+// discoverOtherAddresses sends a binding and an unauthenticated allocate
+// request and returns the endpoints the server announces in OTHER-ADDRESS
+// and ALTERNATE-SERVER. Secondary addresses are often firewalled less
+// strictly than the main one
+func discoverOtherAddresses(log *logrus.Logger, protocol, server string, useTLS, tlsVerify bool, timeout time.Duration) ([]string, error) {
+	var attr []internal.Attribute
+	var firstErr error
+	requests := map[string]*internal.Stun{
+		"binding":  internal.BindingRequest(),
+		"allocate": internal.AllocateRequest(internal.RequestedTransportUDP, internal.AllocateProtocolIgnore),
+	}
+	for _, name := range []string{"binding", "allocate"} {
+		a, err := requestAttributes(log, protocol, server, useTLS, tlsVerify, timeout, name, requests[name])
+		if err != nil {
+			if firstErr == nil {
+				firstErr = err
+			}
+			continue
+		}
+		attr = append(attr, a...)
+	}
+	endpoints := otherAddresses(log, server, attr)
+	if len(endpoints) == 0 && firstErr != nil {
+		return nil, firstErr
+	}
+	return endpoints, nil
+}
+
+// requestAttributes sends the request on a new connection and returns the
+// attributes of the response
+func requestAttributes(log *logrus.Logger, protocol, server string, useTLS, tlsVerify bool, timeout time.Duration, name string, request *internal.Stun) ([]internal.Attribute, error) {
+	conn, err := internal.Connect(protocol, server, useTLS, tlsVerify, timeout)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	response, err := request.SendAndReceive(log, conn, timeout)
+	if err != nil {
+		return nil, fmt.Errorf("error on sending %s request: %w", name, err)
+	}
+	return response.Attributes, nil
+}
+
+// otherAddresses returns the unique endpoints of all OTHER-ADDRESS and
+// ALTERNATE-SERVER attributes that differ from server
+func otherAddresses(log *logrus.Logger, server string, attr []internal.Attribute) []string {
+	seen := map[string]bool{server: true}
+	var ret []string
+	for _, a := range attr {
+		if a.Type != internal.AttrOtherAddress && a.Type != internal.AttrAlternateServer {
+			continue
+		}
+		ip, port, err := internal.ParseMappedAdress(a.Value)
+		if err != nil {
+			log.Debugf("could not parse %s: %02x %v", internal.AttributeTypeString(a.Type), a.Value, err)
+			continue
+		}
+		endpoint := net.JoinHostPort(ip.Unmap().String(), strconv.Itoa(int(port)))
+		if seen[endpoint] {
+			continue
+		}
+		seen[endpoint] = true
+		log.Infof("%s announces %s %s", server, internal.AttributeTypeString(a.Type), endpoint)
+		ret = append(ret, endpoint)
+	}
+	return ret
+}
@@ -0,0 +1,144 @@
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"os/signal"
+	"time"
+
+	"github.com/firefart/stunner/internal"
+	"github.com/firefart/stunner/internal/helper"
+	"github.com/sirupsen/logrus"
+)
+
+// startPauseControl returns a Pauser that is toggled by SIGUSR1 and, if
+// listen is set, by the control API. The control API listens on a TCP
+// address or on windows also on a named pipe protected by sddl. During the
+// quiet hours it is paused automatically. routes are additional endpoints
+// of the control API. The returned function stops all of them.
+func startPauseControl(log *logrus.Logger, subject, listen, sddl string, quiet helper.QuietHours, routes map[string]http.HandlerFunc) (*helper.Pauser, func(), error) {
+	pauser := helper.NewPauser()
+	logState := func() {
+		if pauser.Paused() {
+			log.Warnf("%s paused", subject)
+		} else {
+			log.Infof("%s resumed", subject)
+		}
+	}
+
+	var server *http.Server
+	if listen != "" {
+		l, err := helper.ListenControl(listen, sddl)
+		if err != nil {
+			return nil, nil, fmt.Errorf("could not start control API: %w", err)
+		}
+		server = &http.Server{
+			Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if r.URL.Path == "/stats" {
+					serveStats(w, r)
+					return
+				}
+				if route, ok := routes[r.URL.Path]; ok {
+					route(w, r)
+					return
+				}
+				paused := pauser.Paused()
+				pauser.ServeHTTP(w, r)
+				if paused != pauser.Paused() {
+					logState()
+				}
+			}),
+			ReadHeaderTimeout: 5 * time.Second,
+		}
+		go func() {
+			if err := server.Serve(l); err != nil && !errors.Is(err, http.ErrServerClosed) {
+				log.Errorf("error on control API: %v", err)
+			}
+		}()
+		log.Infof("control API listening on %s", l.Addr().String())
+	}
+
+	signals := make(chan os.Signal, 1)
+	done := make(chan struct{})
+	if s := helper.PauseSignals(); len(s) > 0 {
+		// Notify without signals would relay all signals
+		signal.Notify(signals, s...)
+	}
+	go func() {
+		for {
+			select {
+			case <-signals:
+				pauser.Toggle()
+				logState()
+			case <-done:
+				return
+			}
+		}
+	}()
+
+	if len(quiet) > 0 {
+		go quietHoursLoop(log, pauser, quiet, done)
+	}
+
+	stop := func() {
+		signal.Stop(signals)
+		close(done)
+		// release all waiting workers
+		pauser.Resume()
+		if server != nil {
+			ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+			defer cancel()
+			_ = server.Shutdown(ctx)
+		}
+	}
+	return pauser, stop, nil
+}
+
+// serveStats returns the connection statistics as JSON
+func serveStats(w http.ResponseWriter, r *http.Request) {
+	serveJSON(w, r, internal.Stats())
+}
+
+// serveJSON answers GET requests with v as JSON
+func serveJSON(w http.ResponseWriter, r *http.Request, v interface{}) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+// quietHoursLoop pauses the Pauser when the quiet hours start and resumes
+// it when they end. Manual resumes during the quiet hours are respected
+func quietHoursLoop(log *logrus.Logger, pauser *helper.Pauser, quiet helper.QuietHours, done <-chan struct{}) {
+	tick := time.NewTicker(30 * time.Second)
+	defer tick.Stop()
+	quietPaused := false
+	for {
+		active := quiet.Active(time.Now())
+		switch {
+		case active && !quietPaused:
+			quietPaused = true
+			if pauser.Pause() {
+				log.Warn("quiet hours started, scan paused")
+			}
+		case !active && quietPaused:
+			quietPaused = false
+			if pauser.Resume() {
+				log.Info("quiet hours ended, scan resumed")
+			}
+		}
+		select {
+		case <-tick.C:
+		case <-done:
+			return
+		}
+	}
+}
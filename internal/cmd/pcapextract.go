@@ -0,0 +1,161 @@
+package cmd
+
+import (
+	"encoding/hex"
+	"fmt"
+	"net/netip"
+
+	"github.com/firefart/stunner/internal"
+	"github.com/firefart/stunner/internal/helper"
+	"github.com/firefart/stunner/internal/pcap"
+	"github.com/firefart/stunner/internal/results"
+	"github.com/sirupsen/logrus"
+)
+
+type PcapExtractOpts struct {
+	Log  *logrus.Logger
+	File string
+	// Output is the file the extracted messages are written to
+	Output string
+	// Passfile is an optional wordlist to crack the passwords offline
+	Passfile string
+}
+
+func (opts PcapExtractOpts) Validate() error {
+	if opts.Log == nil {
+		return fmt.Errorf("please supply a valid logger")
+	}
+	if opts.File == "" {
+		return fmt.Errorf("please supply a pcap file")
+	}
+
+	return nil
+}
+
+// extractedAuth is an authenticated message together with the
+// server it was sent to
+type extractedAuth struct {
+	msg      internal.AuthMessage
+	server   netip.AddrPort
+	client   netip.AddrPort
+	protocol string
+	password string
+}
+
+// PcapExtract extracts the usernames, realms, nonces and MESSAGE-INTEGRITY
+// values of all authenticated STUN and TURN messages in a packet capture
+// and optionally cracks the passwords offline
+func PcapExtract(opts PcapExtractOpts) error {
+	if err := opts.Validate(); err != nil {
+		return err
+	}
+
+	packets, err := pcap.ReadFile(opts.File)
+	if err != nil {
+		return fmt.Errorf("could not read pcap file: %w", err)
+	}
+	opts.Log.Debugf("read %d packets with payload", len(packets))
+
+	var found []*extractedAuth
+	seen := make(map[string]struct{})
+	for _, p := range packets {
+		for _, msg := range internal.ParseAuthMessages(p.Payload) {
+			server, client := p.Dst, p.Src
+			if msg.Header.MessageType.Class == internal.MsgTypeClassSuccess || msg.Header.MessageType.Class == internal.MsgTypeClassError {
+				server, client = p.Src, p.Dst
+			}
+			// one message per nonce is enough for cracking
+			key := fmt.Sprintf("%s|%s|%s|%s", server, msg.Username, msg.Realm, msg.Nonce)
+			if _, ok := seen[key]; ok {
+				continue
+			}
+			seen[key] = struct{}{}
+			found = append(found, &extractedAuth{
+				msg:      msg,
+				server:   server,
+				client:   client,
+				protocol: p.Protocol,
+			})
+			opts.Log.Infof("%s/%s %s: username %q realm %q nonce %q message-integrity %x", server, p.Protocol, internal.MessageTypeMethodString(msg.Header.MessageType.Method), msg.Username, msg.Realm, msg.Nonce, msg.Integrity)
+		}
+	}
+
+	if len(found) == 0 {
+		opts.Log.Info("no authenticated STUN messages found")
+		return nil
+	}
+
+	if opts.Passfile != "" {
+		passwords, err := helper.ReadWordlist(opts.Passfile)
+		if err != nil {
+			return fmt.Errorf("could not read password file: %w", err)
+		}
+		crackPasswords(opts, found, passwords)
+	}
+
+	writer, err := results.NewWriter(opts.Output)
+	if err != nil {
+		return err
+	}
+	defer writer.Close()
+	for _, f := range found {
+		details := map[string]string{
+			"client":            f.client.String(),
+			"method":            internal.MessageTypeMethodString(f.msg.Header.MessageType.Method),
+			"username":          f.msg.Username,
+			"realm":             f.msg.Realm,
+			"nonce":             f.msg.Nonce,
+			"message_integrity": hex.EncodeToString(f.msg.Integrity),
+			"signed_message":    hex.EncodeToString(f.msg.Signed),
+		}
+		if f.password != "" {
+			details["password"] = f.password
+		}
+		if err := writer.Write(results.Finding{
+			Module:   "pcap-extract",
+			Relay:    f.server.String(),
+			Host:     f.server.Addr().String(),
+			Port:     f.server.Port(),
+			Protocol: f.protocol,
+			Service:  "turn",
+			Details:  details,
+		}); err != nil {
+			return err
+		}
+	}
+
+	opts.Log.Infof("extracted %d authenticated messages", len(found))
+	if opts.Output != "" {
+		opts.Log.Infof("results written to %s", opts.Output)
+	}
+	return nil
+}
+
+// crackPasswords tries all passwords against one message of every username
+// and realm combination and stores the found password in all messages
+func crackPasswords(opts PcapExtractOpts, found []*extractedAuth, passwords []string) {
+	cracked := make(map[string]string)
+	tried := make(map[string]struct{})
+	for _, f := range found {
+		key := fmt.Sprintf("%s|%s", f.msg.Username, f.msg.Realm)
+		if password, ok := cracked[key]; ok {
+			f.password = password
+			continue
+		}
+		if _, ok := tried[key]; ok {
+			continue
+		}
+		tried[key] = struct{}{}
+		for _, password := range passwords {
+			if f.msg.CheckPassword(password) {
+				opts.Log.Warnf("found password for username %q realm %q: %s", f.msg.Username, f.msg.Realm, password)
+				cracked[key] = password
+				f.password = password
+				break
+			}
+		}
+	}
+	if len(cracked) == 0 {
+		opts.Log.Info("no password found in the password file")
+	}
+}
@@ -0,0 +1,193 @@
+package cmd
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/firefart/stunner/internal"
+	"github.com/firefart/stunner/internal/helper"
+	"github.com/firefart/stunner/internal/results"
+	"github.com/sirupsen/logrus"
+)
+
+type PortAllocationOpts struct {
+	TurnServer string
+	Protocol   string
+	Username   string
+	Password   string
+	UseTLS     bool
+	TlsVerify  bool
+	Timeout    time.Duration
+	Log        *logrus.Logger
+	// Allocations is the number of allocations to request
+	Allocations int
+	// Release deletes every allocation before requesting the next one
+	// instead of holding all of them until the end
+	Release bool
+	Output  string
+}
+
+func (opts PortAllocationOpts) Validate() error {
+	if opts.TurnServer == "" {
+		return fmt.Errorf("need a valid turnserver")
+	}
+	if !strings.Contains(opts.TurnServer, ":") {
+		return fmt.Errorf("turnserver needs a port")
+	}
+	if opts.Protocol != "tcp" && opts.Protocol != "udp" {
+		return fmt.Errorf("protocol needs to be either tcp or udp")
+	}
+	if opts.Username == "" {
+		return fmt.Errorf("please supply a username")
+	}
+	if opts.Password == "" {
+		return fmt.Errorf("please supply a password")
+	}
+	if opts.Log == nil {
+		return fmt.Errorf("please supply a valid logger")
+	}
+	if opts.Allocations < 3 {
+		return fmt.Errorf("need at least 3 allocations to infer a pattern")
+	}
+
+	return nil
+}
+
+// PortAllocation requests several allocations and infers how the relay
+// assigns the relayed ports
+func PortAllocation(opts PortAllocationOpts) error {
+	if err := opts.Validate(); err != nil {
+		return err
+	}
+
+	writer, err := results.NewWriter(opts.Output)
+	if err != nil {
+		return err
+	}
+	defer writer.Close()
+
+	var held []*relayAllocation
+	defer func() {
+		for _, a := range held {
+			a.release(opts)
+		}
+	}()
+
+	var ports []uint16
+	var relayHost string
+	for i := 0; i < opts.Allocations; i++ {
+		a, host, port, err := newRelayAllocation(opts)
+		if err != nil {
+			if len(ports) == 0 {
+				return err
+			}
+			// the quota was probably reached, use what we have
+			opts.Log.Warnf("allocation %d failed, stopping: %v", i+1, err)
+			break
+		}
+		if opts.Release {
+			a.release(opts)
+		} else {
+			held = append(held, a)
+		}
+		if relayHost != "" && host != relayHost {
+			opts.Log.Infof("allocation %d was relayed on a different address %s", i+1, host)
+		}
+		relayHost = host
+		opts.Log.Infof("allocation %d: relayed address %s", i+1, net.JoinHostPort(host, strconv.Itoa(int(port))))
+		ports = append(ports, port)
+	}
+
+	pattern := helper.AnalyzeRelayPorts(ports)
+	opts.Log.Infof("port assignment of %d allocations: %s", len(ports), pattern)
+	if pattern.AllEven {
+		opts.Log.Info("all relayed ports are even")
+	}
+	if pattern.Reused > 0 {
+		opts.Log.Infof("%d released ports were assigned again", pattern.Reused)
+	}
+	if pattern.Kind == "sequential" {
+		opts.Log.Warn("the next relayed ports can be predicted")
+	}
+
+	var portStrings []string
+	for _, port := range ports {
+		portStrings = append(portStrings, strconv.Itoa(int(port)))
+	}
+	return writer.Write(results.Finding{
+		Module:  "port-allocation",
+		Relay:   opts.TurnServer,
+		Host:    relayHost,
+		Service: pattern.Kind,
+		Details: map[string]string{
+			"ports":         strings.Join(portStrings, ","),
+			"step":          strconv.Itoa(pattern.Step),
+			"estimated_min": strconv.Itoa(int(pattern.EstimatedMin)),
+			"estimated_max": strconv.Itoa(int(pattern.EstimatedMax)),
+			"all_even":      strconv.FormatBool(pattern.AllEven),
+			"reused":        strconv.Itoa(pattern.Reused),
+			"released":      strconv.FormatBool(opts.Release),
+		},
+	})
+}
+
+// relayAllocation is an allocation held to block its relayed port
+type relayAllocation struct {
+	conn  net.Conn
+	realm string
+	nonce string
+}
+
+// newRelayAllocation requests an allocation and returns it with the
+// relayed address
+func newRelayAllocation(opts PortAllocationOpts) (*relayAllocation, string, uint16, error) {
+	conn, err := internal.Connect(opts.Protocol, opts.TurnServer, opts.UseTLS, opts.TlsVerify, opts.Timeout)
+	if err != nil {
+		return nil, "", 0, err
+	}
+
+	allocateRequest := internal.AllocateRequest(internal.RequestedTransportUDP, internal.AllocateProtocolIgnore)
+	allocateResponse, err := allocateRequest.SendAndReceive(opts.Log, conn, opts.Timeout)
+	if err != nil {
+		conn.Close()
+		return nil, "", 0, fmt.Errorf("error on sending AllocateRequest: %w", err)
+	}
+	if allocateResponse.Header.MessageType.Class != internal.MsgTypeClassError {
+		conn.Close()
+		return nil, "", 0, fmt.Errorf("MessageClass is not Error (should be not authenticated)")
+	}
+
+	realm := string(allocateResponse.GetAttribute(internal.AttrRealm).Value)
+	nonce := string(allocateResponse.GetAttribute(internal.AttrNonce).Value)
+
+	allocateRequest = internal.AllocateRequestAuth(opts.Username, opts.Password, nonce, realm, internal.RequestedTransportUDP, internal.AllocateProtocolIgnore)
+	allocateResponse, err = allocateRequest.SendAndReceive(opts.Log, conn, opts.Timeout)
+	if err != nil {
+		conn.Close()
+		return nil, "", 0, fmt.Errorf("error on sending AllocateRequest Auth: %w", err)
+	}
+	if allocateResponse.Header.MessageType.Class == internal.MsgTypeClassError {
+		conn.Close()
+		return nil, "", 0, fmt.Errorf("error on AllocateRequest Auth: %w", allocateResponse.GetError())
+	}
+	a := &relayAllocation{conn: conn, realm: realm, nonce: nonce}
+	host, port, err := internal.ConvertXORAddr(allocateResponse.GetAttribute(internal.AttrXorRelayedAddress).Value, allocateResponse.Header.TransactionID)
+	if err != nil {
+		a.release(opts)
+		return nil, "", 0, fmt.Errorf("invalid relayed address: %w", err)
+	}
+	return a, host, port, nil
+}
+
+// release deletes the allocation on the server so the port can be assigned
+// again. Closing a UDP connection alone keeps the allocation until it expires
+func (a *relayAllocation) release(opts PortAllocationOpts) {
+	defer a.conn.Close()
+	deallocateRequest := internal.DeallocateRequest(opts.Username, opts.Password, a.nonce, a.realm)
+	if _, err := deallocateRequest.SendAndReceive(opts.Log, a.conn, opts.Timeout); err != nil {
+		opts.Log.Debugf("could not release the allocation: %v", err)
+	}
+}
@@ -0,0 +1,66 @@
+package cmd
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Profile bundles the scan settings so they don't need to be
+// tuned one by one to match the engagement constraints
+type Profile struct {
+	// Workers is the number of hosts scanned in parallel
+	Workers int
+	// Delay is the time to wait between two probes of a worker
+	Delay time.Duration
+	// Retries is the number of times an unanswered UDP probe is resent
+	Retries int
+	// Timeout is the timeout for every request
+	Timeout time.Duration
+	// Ports are the TCP ports that are probed
+	Ports string
+}
+
+var profiles = map[string]Profile{
+	"stealthy": {
+		Workers: 1,
+		Delay:   2 * time.Second,
+		Retries: 0,
+		Timeout: 3 * time.Second,
+		Ports:   "22,80,443,3389",
+	},
+	"normal": {
+		Workers: 1,
+		Delay:   0,
+		Retries: 0,
+		Timeout: 1 * time.Second,
+		Ports:   "21,22,25,80,443,445,3306,3389,8080,8443",
+	},
+	"aggressive": {
+		Workers: 20,
+		Delay:   0,
+		Retries: 2,
+		Timeout: 500 * time.Millisecond,
+		Ports:   "21,22,23,25,53,80,88,110,111,135,139,143,389,443,445,636,1433,1521,2049,3306,3389,5432,5900,5985,6379,8000,8080,8443,9200,27017",
+	},
+}
+
+// ProfileNames returns the names of all available profiles
+func ProfileNames() []string {
+	names := make([]string, 0, len(profiles))
+	for name := range profiles {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// GetProfile returns the profile with the given name
+func GetProfile(name string) (Profile, error) {
+	p, ok := profiles[strings.ToLower(name)]
+	if !ok {
+		return Profile{}, fmt.Errorf("invalid profile %q. Supported values: %s", name, strings.Join(ProfileNames(), ", "))
+	}
+	return p, nil
+}
@@ -0,0 +1,155 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"net/netip"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/firefart/stunner/internal/helper"
+)
+
+// dnsResolvers are the DNS servers that answered during a scan
+type dnsResolvers struct {
+	mu    sync.Mutex
+	addrs []netip.AddrPort
+}
+
+func (r *dnsResolvers) add(addr netip.AddrPort) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, a := range r.addrs {
+		if a == addr {
+			return
+		}
+	}
+	r.addrs = append(r.addrs, addr)
+}
+
+func (r *dnsResolvers) list() []netip.AddrPort {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]netip.AddrPort(nil), r.addrs...)
+}
+
+// ptrSweep looks up the PTR record of every IP of the ranges on the DNS
+// servers found during the scan and logs the hostname map. Every worker
+// keeps one allocation per server for all its lookups
+func ptrSweep(opts UDPScannerOpts, ipInput []string, pauser *helper.Pauser, limiter *helper.RateLimiter) {
+	resolvers := opts.resolvers.list()
+	if len(resolvers) == 0 {
+		opts.Log.Warn("no DNS server found, skipping the PTR sweep")
+		return
+	}
+	var servers []string
+	for _, r := range resolvers {
+		servers = append(servers, r.String())
+	}
+	opts.Log.Infof("starting the PTR sweep on %s", strings.Join(servers, ", "))
+
+	var mu sync.Mutex
+	hostnames := make(map[netip.Addr][]string)
+
+	ipChan := helper.IPIterator(ipInput)
+	var wg sync.WaitGroup
+	for i := 0; i < opts.Workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			allocations := make(map[netip.Addr]*udpAllocation)
+			defer func() {
+				for _, a := range allocations {
+					a.Close()
+				}
+			}()
+			for ip := range ipChan {
+				if ip.Error != nil {
+					opts.Log.Error(ip.Error)
+					continue
+				}
+				pauser.Wait()
+				limiter.Wait()
+				names := ptrLookup(opts, allocations, resolvers, ip.IP)
+				if len(names) > 0 {
+					opts.Log.Infof("%s %s", ip.IP.String(), strings.Join(names, ", "))
+					mu.Lock()
+					hostnames[ip.IP] = names
+					mu.Unlock()
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	var ips []netip.Addr
+	for ip := range hostnames {
+		ips = append(ips, ip)
+	}
+	sort.Slice(ips, func(i, j int) bool { return ips[i].Less(ips[j]) })
+	opts.Log.Infof("PTR sweep found %d hostnames", len(ips))
+	for _, ip := range ips {
+		opts.Log.Infof("  %-39s %s", ip.String(), strings.Join(hostnames[ip], ", "))
+	}
+}
+
+// ptrLookup asks the servers in turn for the PTR records of the IP until
+// one of them answers
+func ptrLookup(opts UDPScannerOpts, allocations map[netip.Addr]*udpAllocation, resolvers []netip.AddrPort, ip netip.Addr) []string {
+	for _, resolver := range resolvers {
+		allocation, ok := allocations[resolver.Addr()]
+		if !ok {
+			allocation = &udpAllocation{ip: resolver.Addr()}
+			allocations[resolver.Addr()] = allocation
+		}
+		msg, err := ptrQuery(opts, allocation, resolver, ip)
+		if err != nil {
+			opts.Log.Debugf("PTR lookup of %s on %s: %v", ip.String(), resolver, err)
+			continue
+		}
+		if msg == nil {
+			continue
+		}
+		var names []string
+		for _, answer := range msg.Answers {
+			if answer.Type == helper.DNSTypePTR {
+				names = append(names, strings.TrimSuffix(answer.Data, "."))
+			}
+		}
+		return names
+	}
+	return nil
+}
+
+// ptrQuery sends the PTR query for the IP to the resolver. A nil message
+// without an error means the resolver did not answer. Late answers to the
+// queries of earlier IPs are skipped
+func ptrQuery(opts UDPScannerOpts, allocation *udpAllocation, resolver netip.AddrPort, ip netip.Addr) (*helper.DNSMessage, error) {
+	channelNumber, err := allocation.channel(opts, resolver.Port())
+	if err != nil {
+		return nil, err
+	}
+	name := helper.ReverseDNSName(ip)
+	data, err := sendChannelData(opts, allocation.remote, channelNumber, helper.DNSQuery(name, helper.DNSTypePTR), opts.Timeouts.DNS)
+	for {
+		if err != nil {
+			// ignore timeouts
+			if errors.Is(err, helper.ErrTimeout) {
+				return nil, nil
+			}
+			// the next lookup sets up a new allocation
+			allocation.Close()
+			return nil, fmt.Errorf("error on DNS request: %w", err)
+		}
+		msg, parseErr := helper.ParseDNSMessage(data)
+		if parseErr != nil {
+			return nil, fmt.Errorf("invalid DNS response: %w", parseErr)
+		}
+		if len(msg.Questions) == 1 && strings.EqualFold(msg.Questions[0], name) {
+			return msg, nil
+		}
+		opts.Log.Debugf("skipping late DNS answer for %v from %s", msg.Questions, resolver)
+		data, err = readChannelResponse(opts, allocation.remote, channelNumber, opts.Timeouts.DNS)
+	}
+}
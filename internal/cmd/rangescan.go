@@ -21,6 +21,11 @@ type RangeScanOpts struct {
 	TlsVerify  bool
 	Timeout    time.Duration
 	Log        *logrus.Logger
+	// Timing infers the deny list of TCP relaying from the response
+	// times instead of only reporting successful connections
+	Timing bool
+	// TimingSamples is the number of connect requests per target
+	TimingSamples int
 }
 
 func (opts RangeScanOpts) Validate() error {
@@ -42,92 +47,77 @@ func (opts RangeScanOpts) Validate() error {
 	if opts.Log == nil {
 		return fmt.Errorf("please supply a valid logger")
 	}
+	if opts.Timing && opts.TimingSamples < 1 {
+		return fmt.Errorf("please supply a valid number of timing samples")
+	}
 
 	return nil
 }
 
+type restrictedRange struct {
+	Name string
+	IPs  []string
+}
+
+var restrictedRanges = []restrictedRange{
+	{Name: "all", IPs: []string{"0.0.0.0", "::"}},
+	{Name: "localhost", IPs: []string{"127.0.0.1", "127.0.0.8", "127.255.255.254", "::1"}},
+	{Name: "private", IPs: []string{"10.0.0.1", "10.255.255.254", "172.16.0.1", "172.31.255.254", "192.168.0.1", "192.168.255.254"}},
+	{Name: "link local", IPs: []string{"169.254.0.1", "169.254.254.255"}},
+	{Name: "multicast", IPs: []string{"224.0.0.1", "239.255.255.254"}},
+	{Name: "shared address space", IPs: []string{"100.64.0.0", "100.127.255.254"}},
+	{Name: "ietf", IPs: []string{"192.0.0.1", "192.0.0.254"}},
+	{Name: "TEST-NET-1", IPs: []string{"192.0.2.1", "192.0.2.254"}},
+	{Name: "benchmark", IPs: []string{"198.18.0.1", "198.19.255.254"}},
+	{Name: "TEST-NET-2", IPs: []string{"198.51.100.1", "198.51.100.254"}},
+	{Name: "TEST-NET-3", IPs: []string{"203.0.113.1", "203.0.113.254"}},
+	{Name: "reserved", IPs: []string{"240.0.0.1"}},
+	{Name: "broadcast", IPs: []string{"255.255.255.255"}},
+	{Name: "cloud metadata services", IPs: []string{"169.254.169.254"}},
+}
+
 func RangeScan(opts RangeScanOpts) error {
 	if err := opts.Validate(); err != nil {
 		return err
 	}
 
-	ranges := []string{
-		// all
-		"0.0.0.0",
-		"::",
-		// localhosts
-		"127.0.0.1",
-		"127.0.0.8",
-		"127.255.255.254",
-		"::1",
-		// private ranges
-		"10.0.0.1",
-		"10.255.255.254",
-		"172.16.0.1",
-		"172.31.255.254",
-		"192.168.0.1",
-		"192.168.255.254",
-		// Link Local
-		"169.254.0.1",
-		"169.254.254.255",
-		// Multicast
-		"224.0.0.1",
-		"239.255.255.254",
-		// Shared Address Space
-		"100.64.0.0",
-		"100.127.255.254",
-		// ietf
-		"192.0.0.1",
-		"192.0.0.254",
-		// TEST-NET-1
-		"192.0.2.1",
-		"192.0.2.254",
-		// Benchmark
-		"198.18.0.1",
-		"198.19.255.254",
-		// TEST-NET-2
-		"198.51.100.1",
-		"198.51.100.254",
-		// TEST-NET-3
-		"203.0.113.1",
-		"203.0.113.254",
-		// Reserved
-		"240.0.0.1",
-		// Broadcast
-		"255.255.255.255",
-		// Cloud Metadata Services
-		"169.254.169.254",
-	}
-
 	// UDP scanning
-	for _, ipString := range ranges {
-		ip, err := netip.ParseAddr(ipString)
-		if err != nil {
-			return fmt.Errorf("target is no valid ip address: %w", err)
-		}
+	for _, r := range restrictedRanges {
+		for _, ipString := range r.IPs {
+			ip, err := netip.ParseAddr(ipString)
+			if err != nil {
+				return fmt.Errorf("target is no valid ip address: %w", err)
+			}
 
-		suc, err := scanUDP(opts, ip, 80)
-		if err != nil {
-			opts.Log.Errorf("UDP %s: %v", ip, err)
-		}
-		if suc {
-			opts.Log.Warnf("UDP %s was successful!", ip)
+			suc, err := scanUDP(opts, ip, 80)
+			if err != nil {
+				opts.Log.Errorf("UDP %s: %v", ip, err)
+			}
+			if suc {
+				opts.Log.Warnf("UDP %s was successful!", ip)
+			}
 		}
 	}
 
+	if opts.Timing {
+		return timingScan(opts)
+	}
+
 	// TCP scanning
-	for _, ipString := range ranges {
-		ip, err := netip.ParseAddr(ipString)
-		if err != nil {
-			return fmt.Errorf("target is no valid ip address: %w", err)
-		}
+	for _, r := range restrictedRanges {
+		for _, ipString := range r.IPs {
+			ip, err := netip.ParseAddr(ipString)
+			if err != nil {
+				return fmt.Errorf("target is no valid ip address: %w", err)
+			}
 
-		suc, err := scanTCP(opts, ip, 80)
-		if err != nil {
-			opts.Log.Errorf("TCP %s: %v", ip, err)
-		}
-		if suc {
-			opts.Log.Warnf("TCP %s was successful!", ip)
+			suc, err := scanTCP(opts, ip, 80)
+			if err != nil {
+				opts.Log.Errorf("TCP %s: %v", ip, err)
+			}
+			if suc {
+				opts.Log.Warnf("TCP %s was successful!", ip)
+			}
 		}
 	}
 	return nil
@@ -140,10 +130,7 @@ func scanTCP(opts RangeScanOpts, targetHost netip.Addr, targetPort uint16) (bool
 	}
 	defer conn.Close()
 
-	addressFamily := internal.AllocateProtocolIgnore
-	if targetHost.Is6() {
-		addressFamily = internal.AllocateProtocolIPv6
-	}
+	addressFamily := internal.AddressFamily(targetHost)
 
 	allocateRequest := internal.AllocateRequest(internal.RequestedTransportTCP, addressFamily)
 	allocateResponse, err := allocateRequest.SendAndReceive(opts.Log, conn, opts.Timeout)
@@ -163,7 +150,7 @@ func scanTCP(opts RangeScanOpts, targetHost netip.Addr, targetPort uint16) (bool
 		return false, fmt.Errorf("error on sending allocate request 2: %w", err)
 	}
 	if allocateResponse.Header.MessageType.Class == internal.MsgTypeClassError {
-		return false, fmt.Errorf("error on allocate response: %s", allocateResponse.GetErrorString())
+		return false, fmt.Errorf("error on allocate response: %w", allocateResponse.GetError())
 	}
 
 	connectRequest, err := internal.ConnectRequestAuth(opts.Username, opts.Password, nonce, realm, targetHost, targetPort)
@@ -179,7 +166,7 @@ func scanTCP(opts RangeScanOpts, targetHost netip.Addr, targetPort uint16) (bool
 		return false, fmt.Errorf("error on sending Connect request: %w", err)
 	}
 	if connectResponse.Header.MessageType.Class == internal.MsgTypeClassError {
-		return false, fmt.Errorf("error on Connect response: %s", connectResponse.GetErrorString())
+		return false, fmt.Errorf("error on Connect response: %w", connectResponse.GetError())
 	}
 
 	return true, nil
@@ -194,3 +181,123 @@ func scanUDP(opts RangeScanOpts, targetHost netip.Addr, targetPort uint16) (bool
 
 	return true, nil
 }
+
+// timingScan connects to all ranges via TCP and compares the response times
+// to the round trip time of the control connection. A denied peer is
+// rejected without a connection attempt while the relay needs to wait for
+// a dead host, even if both result in the same error
+func timingScan(opts RangeScanOpts) error {
+	opts.Log.Info("inferring the TCP deny list from the response times")
+	var blocked []string
+	for _, r := range restrictedRanges {
+		var verdicts []helper.PeerVerdict
+		for _, ipString := range r.IPs {
+			ip, err := netip.ParseAddr(ipString)
+			if err != nil {
+				return fmt.Errorf("target is no valid ip address: %w", err)
+			}
+
+			timing, err := timeTCP(opts, ip, 80)
+			if err != nil {
+				opts.Log.Errorf("TCP %s: %v", ip, err)
+				continue
+			}
+			verdict := timing.Verdict()
+			verdicts = append(verdicts, verdict)
+			result := "success"
+			switch {
+			case timing.Timeout:
+				result = "timeout"
+			case !timing.Connected:
+				result = fmt.Sprintf("error %d", timing.Code)
+			}
+			msg := fmt.Sprintf("TCP %s: %s (%s after %s, baseline %s)", ip, verdict, result, timing.Median().Round(time.Millisecond), timing.Baseline.Round(time.Millisecond))
+			if verdict == helper.PeerFiltered {
+				opts.Log.Info(msg)
+			} else {
+				opts.Log.Warn(msg)
+			}
+		}
+
+		rangeVerdict := helper.RangeVerdict(verdicts)
+		opts.Log.Infof("range %s: %s", r.Name, rangeVerdict)
+		if rangeVerdict == "blocked" {
+			blocked = append(blocked, r.Name)
+		}
+	}
+
+	if len(blocked) == 0 {
+		opts.Log.Warn("no range is blocked by the relay")
+	} else {
+		opts.Log.Infof("inferred blocked ranges: %s", strings.Join(blocked, ", "))
+	}
+	return nil
+}
+
+// timeTCP allocates a TCP relay and sends opts.TimingSamples connect
+// requests to the target. The fastest allocate request is used as the
+// baseline
+func timeTCP(opts RangeScanOpts, targetHost netip.Addr, targetPort uint16) (*helper.PeerTiming, error) {
+	conn, err := internal.Connect(opts.Protocol, opts.TurnServer, opts.UseTLS, opts.TlsVerify, opts.Timeout)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	addressFamily := internal.AddressFamily(targetHost)
+
+	timing := &helper.PeerTiming{}
+	start := time.Now()
+	allocateRequest := internal.AllocateRequest(internal.RequestedTransportTCP, addressFamily)
+	allocateResponse, err := allocateRequest.SendAndReceive(opts.Log, conn, opts.Timeout)
+	if err != nil {
+		return nil, fmt.Errorf("error on sending allocate request 1: %w", err)
+	}
+	timing.Baseline = time.Since(start)
+	if allocateResponse.Header.MessageType.Class != internal.MsgTypeClassError {
+		return nil, fmt.Errorf("MessageClass is not Error (should be not authenticated)")
+	}
+
+	realm := string(allocateResponse.GetAttribute(internal.AttrRealm).Value)
+	nonce := string(allocateResponse.GetAttribute(internal.AttrNonce).Value)
+
+	start = time.Now()
+	allocateRequest = internal.AllocateRequestAuth(opts.Username, opts.Password, nonce, realm, internal.RequestedTransportTCP, addressFamily)
+	allocateResponse, err = allocateRequest.SendAndReceive(opts.Log, conn, opts.Timeout)
+	if err != nil {
+		return nil, fmt.Errorf("error on sending allocate request 2: %w", err)
+	}
+	if elapsed := time.Since(start); elapsed < timing.Baseline {
+		timing.Baseline = elapsed
+	}
+	if allocateResponse.Header.MessageType.Class == internal.MsgTypeClassError {
+		return nil, fmt.Errorf("error on allocate response: %w", allocateResponse.GetError())
+	}
+
+	for i := 0; i < opts.TimingSamples; i++ {
+		connectRequest, err := internal.ConnectRequestAuth(opts.Username, opts.Password, nonce, realm, targetHost, targetPort)
+		if err != nil {
+			return nil, fmt.Errorf("error on generating Connect request: %w", err)
+		}
+		start = time.Now()
+		connectResponse, err := connectRequest.SendAndReceive(opts.Log, conn, opts.Timeout)
+		if err != nil {
+			if errors.Is(err, helper.ErrTimeout) {
+				timing.Samples = append(timing.Samples, time.Since(start))
+				timing.Timeout = true
+				return timing, nil
+			}
+			return nil, fmt.Errorf("error on sending Connect request: %w", err)
+		}
+		timing.Samples = append(timing.Samples, time.Since(start))
+		if connectResponse.Header.MessageType.Class != internal.MsgTypeClassError {
+			// the connection is established, further requests fail
+			// with connection already exists
+			timing.Connected = true
+			return timing, nil
+		}
+		code, _ := connectResponse.GetErrorCode()
+		timing.Code = int(code)
+	}
+	return timing, nil
+}
@@ -0,0 +1,146 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/firefart/stunner/internal/helper"
+	"github.com/firefart/stunner/internal/results"
+	"github.com/firefart/stunner/internal/sdp"
+	"github.com/sirupsen/logrus"
+)
+
+type SDPExtractOpts struct {
+	Log   *logrus.Logger
+	Files []string
+	// Output is the file the extracted servers and candidates are written to
+	Output string
+}
+
+func (opts SDPExtractOpts) Validate() error {
+	if opts.Log == nil {
+		return fmt.Errorf("please supply a valid logger")
+	}
+	if len(opts.Files) == 0 {
+		return fmt.Errorf("please supply at least one SDP file")
+	}
+
+	return nil
+}
+
+// SDPExtract extracts the STUN and TURN servers, their credentials and the
+// ICE candidates from SDP offers and answers so they can be used as input
+// for the other commands
+func SDPExtract(opts SDPExtractOpts) error {
+	if err := opts.Validate(); err != nil {
+		return err
+	}
+
+	var info sdp.Info
+	for _, filename := range opts.Files {
+		data, err := os.ReadFile(filename)
+		if err != nil {
+			return fmt.Errorf("could not read %s: %w", filename, err)
+		}
+		i := sdp.Parse(data)
+		opts.Log.Debugf("found %d servers and %d candidates in %s", len(i.Servers), len(i.Candidates), filename)
+		info.Servers = append(info.Servers, i.Servers...)
+		info.Candidates = append(info.Candidates, i.Candidates...)
+	}
+
+	if len(info.Servers) == 0 && len(info.Candidates) == 0 {
+		opts.Log.Info("no servers or candidates found")
+		return nil
+	}
+
+	writer, err := results.NewWriter(opts.Output)
+	if err != nil {
+		return err
+	}
+	defer writer.Close()
+
+	for _, s := range info.Servers {
+		opts.Log.Infof("%s server %s: %s", strings.ToUpper(s.Scheme), s.URL, serverArgs(s))
+		details := map[string]string{
+			"url":  s.URL,
+			"tls":  strconv.FormatBool(s.TLS()),
+			"args": serverArgs(s),
+		}
+		if s.Username != "" {
+			details["username"] = s.Username
+		}
+		if s.Credential != "" {
+			details["password"] = s.Credential
+		}
+		service := "stun"
+		if s.IsTURN() {
+			service = "turn"
+		}
+		if err := writer.Write(results.Finding{
+			Module:   "sdp-extract",
+			Relay:    s.Address(),
+			Host:     s.Host,
+			Port:     s.Port,
+			Protocol: s.Transport,
+			Service:  service,
+			Details:  details,
+		}); err != nil {
+			return err
+		}
+	}
+
+	for _, c := range info.Candidates {
+		details := map[string]string{
+			"type":     c.Type,
+			"priority": strconv.FormatUint(uint64(c.Priority), 10),
+		}
+		msg := fmt.Sprintf("%s candidate %s:%d/%s", c.Type, c.Address, c.Port, c.Transport)
+		if c.RelatedAddress != "" {
+			details["related_address"] = fmt.Sprintf("%s:%d", c.RelatedAddress, c.RelatedPort)
+			msg += fmt.Sprintf(" (related %s:%d)", c.RelatedAddress, c.RelatedPort)
+		}
+		if ip, ok := c.Addr(); ok {
+			if helper.IsPublicIP(ip) {
+				details["scope"] = "public"
+			} else {
+				details["scope"] = "internal"
+				msg += " internal address"
+			}
+		}
+		opts.Log.Info(msg)
+		if err := writer.Write(results.Finding{
+			Module:   "sdp-extract",
+			Host:     c.Address,
+			Port:     c.Port,
+			Protocol: c.Transport,
+			Service:  "ice-candidate",
+			Details:  details,
+		}); err != nil {
+			return err
+		}
+	}
+
+	opts.Log.Infof("extracted %d servers and %d candidates", len(info.Servers), len(info.Candidates))
+	if opts.Output != "" {
+		opts.Log.Infof("results written to %s", opts.Output)
+	}
+	return nil
+}
+
+// serverArgs returns the command line arguments to use the server
+// with the other commands
+func serverArgs(s sdp.Server) string {
+	args := []string{"-s", s.Address(), "--protocol", s.Transport}
+	if s.TLS() {
+		args = append(args, "--tls")
+	}
+	if s.Username != "" {
+		args = append(args, "-u", strconv.Quote(s.Username))
+	}
+	if s.Credential != "" {
+		args = append(args, "-p", strconv.Quote(s.Credential))
+	}
+	return strings.Join(args, " ")
+}
@@ -0,0 +1,261 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/netip"
+	"net/textproto"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/firefart/stunner/internal"
+	"github.com/firefart/stunner/internal/helper"
+	"github.com/firefart/stunner/internal/results"
+	"github.com/sirupsen/logrus"
+)
+
+// smtpTimeout is the time a single SMTP dialog may take. Mail servers
+// often delay the greeting so this is a lot longer than the connect timeout
+const smtpTimeout = 30 * time.Second
+
+type SMTPCheckOpts struct {
+	TurnServer string
+	Username   string
+	Password   string
+	UseTLS     bool
+	TlsVerify  bool
+	Timeout    time.Duration
+	Log        *logrus.Logger
+	// Targets are internal mail servers to test for open relaying
+	Targets []netip.AddrPort
+	// EgressTarget is an external mail server in the format host:port
+	// used to check if the TURN server allows outbound SMTP
+	EgressTarget string
+	// From and To are the addresses used for the relay test. Both
+	// should be external so accepting the recipient means relaying
+	From   string
+	To     string
+	Helo   string
+	Output string
+}
+
+func (opts SMTPCheckOpts) Validate() error {
+	if opts.TurnServer == "" {
+		return fmt.Errorf("need a valid turnserver")
+	}
+	if !strings.Contains(opts.TurnServer, ":") {
+		return fmt.Errorf("turnserver needs a port")
+	}
+	if opts.Username == "" {
+		return fmt.Errorf("please supply a username")
+	}
+	if opts.Password == "" {
+		return fmt.Errorf("please supply a password")
+	}
+	if opts.Log == nil {
+		return fmt.Errorf("please supply a valid logger")
+	}
+	if len(opts.Targets) == 0 && opts.EgressTarget == "" {
+		return fmt.Errorf("please supply a target or an egress target")
+	}
+	if opts.EgressTarget != "" {
+		if _, _, err := net.SplitHostPort(opts.EgressTarget); err != nil {
+			return fmt.Errorf("egress target needs to be in the format host:port: %w", err)
+		}
+	}
+	if !strings.Contains(opts.From, "@") || !strings.Contains(opts.To, "@") {
+		return fmt.Errorf("please supply valid from and to addresses")
+	}
+	if opts.Helo == "" {
+		return fmt.Errorf("please supply a helo name")
+	}
+
+	return nil
+}
+
+// smtpResult is the outcome of a single SMTP dialog
+type smtpResult struct {
+	banner   string
+	starttls bool
+	auth     string
+	// rcptCode is the reply code to the external recipient, 0 if the
+	// dialog ended before
+	rcptCode int
+	rcptText string
+}
+
+// SMTPCheck tests if internal mail servers reachable via the TURN server
+// relay mail to external recipients and if the TURN server allows
+// outbound connections to port 25
+func SMTPCheck(opts SMTPCheckOpts) error {
+	if err := opts.Validate(); err != nil {
+		return err
+	}
+
+	writer, err := results.NewWriter(opts.Output)
+	if err != nil {
+		return err
+	}
+	defer writer.Close()
+
+	if opts.EgressTarget != "" {
+		if err := smtpEgress(opts, writer); err != nil {
+			return err
+		}
+	}
+
+	for _, target := range opts.Targets {
+		res, err := smtpDialog(opts, target, true)
+		finding := results.Finding{
+			Module:   "smtp-check",
+			Relay:    opts.TurnServer,
+			Host:     target.Addr().String(),
+			Port:     target.Port(),
+			Protocol: "tcp",
+		}
+		if err != nil {
+			opts.Log.Errorf("%s: %v", target, err)
+			finding.Error = err.Error()
+			if err := writer.Write(finding); err != nil {
+				return err
+			}
+			continue
+		}
+
+		openRelay := res.rcptCode == 250 || res.rcptCode == 251
+		if openRelay {
+			opts.Log.Warnf("%s is an open relay: RCPT TO:<%s> answered with %d %s", target, opts.To, res.rcptCode, res.rcptText)
+		} else {
+			opts.Log.Infof("%s denies relaying: RCPT TO:<%s> answered with %d %s", target, opts.To, res.rcptCode, res.rcptText)
+		}
+		finding.Service = "smtp"
+		finding.Details = res.details()
+		finding.Details["open_relay"] = strconv.FormatBool(openRelay)
+		if err := writer.Write(finding); err != nil {
+			return err
+		}
+	}
+
+	if opts.Output != "" {
+		opts.Log.Infof("results written to %s", opts.Output)
+	}
+	return nil
+}
+
+// smtpEgress connects to an external mail server and reads the greeting
+func smtpEgress(opts SMTPCheckOpts, writer *results.Writer) error {
+	host, portString, _ := net.SplitHostPort(opts.EgressTarget)
+	port, err := strconv.ParseUint(portString, 10, 16)
+	if err != nil {
+		return fmt.Errorf("invalid egress port %q: %w", portString, err)
+	}
+	ips, err := helper.ResolveName(context.Background(), host)
+	if err != nil {
+		return fmt.Errorf("could not resolve %s: %w", host, err)
+	}
+	if len(ips) == 0 {
+		return fmt.Errorf("%s could not be resolved", host)
+	}
+	target := netip.AddrPortFrom(ips[0], uint16(port))
+
+	finding := results.Finding{
+		Module:   "smtp-check",
+		Relay:    opts.TurnServer,
+		Host:     target.Addr().String(),
+		Port:     target.Port(),
+		Protocol: "tcp",
+	}
+	res, err := smtpDialog(opts, target, false)
+	if err != nil {
+		opts.Log.Infof("outbound SMTP to %s (%s) is blocked: %v", opts.EgressTarget, target, err)
+		finding.Error = err.Error()
+		return writer.Write(finding)
+	}
+	opts.Log.Warnf("outbound SMTP to %s (%s) is allowed: %s", opts.EgressTarget, target, res.banner)
+	finding.Service = "smtp-egress"
+	finding.Details = res.details()
+	finding.Details["hostname"] = host
+	return writer.Write(finding)
+}
+
+// smtpDialog connects to the mail server via the TURN server and reads
+// the greeting and the extensions. If relay is set the external
+// recipient is tried. No mail is sent
+func smtpDialog(opts SMTPCheckOpts, target netip.AddrPort, relay bool) (*smtpResult, error) {
+	conn, err := internal.DialTurnTCP(opts.Log, opts.TurnServer, opts.UseTLS, opts.TlsVerify, opts.Timeout, target.Addr(), target.Port(), opts.Username, opts.Password)
+	if err != nil {
+		return nil, fmt.Errorf("could not connect: %w", err)
+	}
+	defer conn.Close()
+	if err := conn.SetDeadline(time.Now().Add(smtpTimeout)); err != nil {
+		return nil, fmt.Errorf("could not set deadline: %w", err)
+	}
+
+	text := textproto.NewConn(conn)
+	_, banner, err := text.ReadResponse(220)
+	if err != nil {
+		return nil, fmt.Errorf("invalid greeting: %w", err)
+	}
+	res := &smtpResult{banner: banner}
+
+	_, ehlo, err := smtpCmd(text, 250, "EHLO %s", opts.Helo)
+	if err != nil {
+		// servers without ESMTP support only know HELO
+		if _, _, err := smtpCmd(text, 250, "HELO %s", opts.Helo); err != nil {
+			return nil, fmt.Errorf("error on HELO: %w", err)
+		}
+	}
+	// the first line is the greeting, all others are extensions
+	lines := strings.Split(ehlo, "\n")
+	for _, line := range lines[1:] {
+		keyword, params, _ := strings.Cut(line, " ")
+		switch strings.ToUpper(keyword) {
+		case "STARTTLS":
+			res.starttls = true
+		case "AUTH":
+			res.auth = params
+		}
+	}
+
+	if relay {
+		if _, _, err := smtpCmd(text, 250, "MAIL FROM:<%s>", opts.From); err != nil {
+			return nil, fmt.Errorf("error on MAIL FROM: %w", err)
+		}
+		// the reply is checked by the caller
+		res.rcptCode, res.rcptText, err = smtpCmd(text, 0, "RCPT TO:<%s>", opts.To)
+		if err != nil {
+			return nil, fmt.Errorf("error on RCPT TO: %w", err)
+		}
+		_, _, _ = smtpCmd(text, 250, "RSET")
+	}
+	_, _, _ = smtpCmd(text, 221, "QUIT")
+	return res, nil
+}
+
+// smtpCmd sends a command and reads the reply. An expectCode of 0
+// accepts all replies
+func smtpCmd(text *textproto.Conn, expectCode int, format string, args ...interface{}) (int, string, error) {
+	id, err := text.Cmd(format, args...)
+	if err != nil {
+		return 0, "", err
+	}
+	text.StartResponse(id)
+	defer text.EndResponse(id)
+	return text.ReadResponse(expectCode)
+}
+
+func (r *smtpResult) details() map[string]string {
+	details := map[string]string{
+		"banner":   r.banner,
+		"starttls": strconv.FormatBool(r.starttls),
+	}
+	if r.auth != "" {
+		details["auth"] = r.auth
+	}
+	if r.rcptCode != 0 {
+		details["rcpt_response"] = fmt.Sprintf("%d %s", r.rcptCode, r.rcptText)
+	}
+	return details
+}
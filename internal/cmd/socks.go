@@ -2,15 +2,26 @@ package cmd
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"os"
+	"os/signal"
 	"strings"
 	"time"
 
-	socks "github.com/firefart/gosocks"
+	"github.com/firefart/stunner/internal"
+	"github.com/firefart/stunner/internal/helper"
 	"github.com/firefart/stunner/internal/socksimplementations"
 	"github.com/sirupsen/logrus"
 )
 
+// handoffRefreshInterval is the interval a handed off allocation is
+// refreshed in
+const handoffRefreshInterval = 2 * time.Minute
+
 type SocksOpts struct {
 	TurnServer string
 	Protocol   string
@@ -20,8 +31,69 @@ type SocksOpts struct {
 	TlsVerify  bool
 	Timeout    time.Duration
 	Log        *logrus.Logger
-	Listen     string
+	Listen     []string
+	// Transparent is the address connections redirected with iptables are
+	// accepted on. Disabled if empty
+	Transparent string
+	// Users are the usernames and passwords clients of the proxy need to
+	// authenticate with. No authentication is needed if empty
+	Users map[string]string
+	// Allow are the networks clients may connect from. All clients are
+	// allowed if empty
+	Allow      []netip.Prefix
 	DropPublic bool
+	// ACL restricts the destinations by range and port. All destinations
+	// are allowed if nil
+	ACL    *helper.ACL
+	Enrich bool
+	// number of retries on temporary server errors like 508
+	ConnectRetries int
+	RetryBackoff   time.Duration
+	// Pace sends the data in packets of the size and interval of a
+	// WebRTC video stream
+	Pace bool
+	// Shaping adds random delays, batching and random packet sizes to the
+	// data to make it harder to correlate with the traffic of the relay
+	Shaping helper.Shaping
+	// BufferSize is the size of a single read of the data copy
+	BufferSize int
+	// WriteTimeout is the longest a write may block on a side that does
+	// not read the data fast enough
+	WriteTimeout time.Duration
+	// SessionTimeout closes connections after this time
+	SessionTimeout time.Duration
+	// DrainTimeout is the time active connections have to finish on
+	// shutdown before their allocations are deleted
+	DrainTimeout time.Duration
+	// QuietHours are the time windows in which new connections are refused
+	QuietHours helper.QuietHours
+	// ControlListen is the address of the control API that pauses and
+	// resumes accepting new connections. The API is disabled if empty
+	ControlListen string
+	// ControlSDDL is the security descriptor of the named pipe of the
+	// control API on windows
+	ControlSDDL string
+	// Handoff is the unix socket an allocation is taken over from instead
+	// of authenticating on every connection
+	Handoff string
+	// StrictDNS refuses requests that need a local DNS lookup, so names of
+	// targets never reach the local resolver
+	StrictDNS bool
+	// RelayDNS is a DNS server that domain names are resolved on through
+	// the relay instead of locally. Disabled if not valid
+	RelayDNS netip.AddrPort
+	// BindTimeout is the time the peer of a BIND request has to connect
+	BindTimeout time.Duration
+	// Pool shares allocations between connections instead of allocating a
+	// relay for every connection
+	Pool bool
+	// PoolConnections is the number of connections that share an allocation
+	PoolConnections int
+	// PoolIdle is the time an allocation without connections is kept open
+	PoolIdle time.Duration
+	// Credentials returns the credentials for every new allocation instead
+	// of Username and Password, so they can be rotated while running
+	Credentials helper.CredentialProvider
 }
 
 func (opts SocksOpts) Validate() error {
@@ -34,20 +106,77 @@ func (opts SocksOpts) Validate() error {
 	if opts.Protocol != "tcp" && opts.Protocol != "udp" {
 		return fmt.Errorf("protocol needs to be either tcp or udp")
 	}
-	if opts.Username == "" {
+	// the credentials are part of a handed off allocation or are
+	// returned by the provider
+	needCredentials := opts.Handoff == "" && opts.Credentials == nil
+	if needCredentials && opts.Username == "" {
 		return fmt.Errorf("please supply a username")
 	}
-	if opts.Password == "" {
+	if needCredentials && opts.Password == "" {
 		return fmt.Errorf("please supply a password")
 	}
 	if opts.Log == nil {
 		return fmt.Errorf("please supply a valid logger")
 	}
-	if opts.Listen == "" {
+	if opts.ConnectRetries < 0 {
+		return fmt.Errorf("connect retries can not be negative")
+	}
+	if opts.RelayDNS.IsValid() && opts.Credentials == nil && (opts.Username == "" || opts.Password == "") {
+		return fmt.Errorf("resolving names through the relay needs a username and a password")
+	}
+	if opts.StrictDNS && opts.Enrich {
+		return fmt.Errorf("enrich looks up names of public destinations locally and can not be used in strict DNS mode")
+	}
+	if opts.BufferSize < 0 {
+		return fmt.Errorf("buffer size can not be negative")
+	}
+	if opts.WriteTimeout < 0 {
+		return fmt.Errorf("write timeout can not be negative")
+	}
+	if opts.SessionTimeout < 0 {
+		return fmt.Errorf("session timeout can not be negative")
+	}
+	if opts.DrainTimeout < 0 {
+		return fmt.Errorf("drain timeout can not be negative")
+	}
+	if opts.BindTimeout <= 0 {
+		return fmt.Errorf("please supply a valid bind timeout")
+	}
+	if opts.Pool && opts.PoolConnections < 1 {
+		return fmt.Errorf("please supply a valid number of pooled connections")
+	}
+	if opts.Pool && opts.PoolIdle <= 0 {
+		return fmt.Errorf("please supply a valid pool idle timeout")
+	}
+	if opts.Pool && opts.Handoff != "" {
+		return fmt.Errorf("a handed off allocation can not be used with the pool")
+	}
+	if err := opts.Shaping.Validate(); err != nil {
+		return err
+	}
+	if len(opts.Listen) == 0 {
 		return fmt.Errorf("please supply a valid listen address")
 	}
-	if !strings.Contains(opts.Listen, ":") {
-		return fmt.Errorf("listen must be in the format host:port")
+	for username, password := range opts.Users {
+		if username == "" || password == "" {
+			return fmt.Errorf("please supply a valid SOCKS user")
+		}
+	}
+	for _, prefix := range opts.Allow {
+		if !prefix.IsValid() {
+			return fmt.Errorf("please supply a valid allow list")
+		}
+	}
+	for _, listen := range opts.Listen {
+		if listen == "" {
+			return fmt.Errorf("please supply a valid listen address")
+		}
+		if !strings.Contains(listen, ":") {
+			return fmt.Errorf("listen %s must be in the format host:port", listen)
+		}
+	}
+	if opts.Transparent != "" && !strings.Contains(opts.Transparent, ":") {
+		return fmt.Errorf("transparent %s must be in the format host:port", opts.Transparent)
 	}
 
 	return nil
@@ -58,26 +187,287 @@ func Socks(opts SocksOpts) error {
 		return err
 	}
 
+	handler, closeHandler := connectHandler(opts)
+	defer closeHandler()
+	if opts.Handoff != "" {
+		allocation, err := internal.ReceiveTCPAllocation(opts.Log, opts.Handoff, opts.Timeout)
+		if err != nil {
+			return fmt.Errorf("could not take over allocation: %w", err)
+		}
+		defer allocation.Close()
+		if allocation.TurnServer() != opts.TurnServer {
+			return fmt.Errorf("the handed off allocation is on %s and not on %s", allocation.TurnServer(), opts.TurnServer)
+		}
+		opts.Log.Infof("took over the allocation on %s", allocation.TurnServer())
+		stop := make(chan struct{})
+		defer close(stop)
+		go func() {
+			if err := allocation.KeepAlive(handoffRefreshInterval, stop); err != nil {
+				opts.Log.Errorf("[socks] error on refreshing the handed off allocation: %v", err)
+			}
+		}()
+		handler.Allocation = allocation
+	}
+	handler.Stats = socksimplementations.NewStats()
+	stopStats := logStatsOnSignal(opts.Log, handler.Stats)
+	defer stopStats()
+	routes := map[string]http.HandlerFunc{
+		"/connections": func(w http.ResponseWriter, r *http.Request) {
+			serveJSON(w, r, handler.Stats.Snapshot())
+		},
+	}
+	pauser, stopPause, err := startPauseControl(opts.Log, "socks proxy", opts.ControlListen, opts.ControlSDDL, nil, routes)
+	if err != nil {
+		return err
+	}
+	defer stopPause()
+	handler.Pauser = pauser
+
+	server := &socksimplementations.Server{
+		Connect: handler,
+		Users:   opts.Users,
+		Allow:   opts.Allow,
+		Timeout: opts.Timeout,
+		Log:     opts.Log,
+	}
+	// BIND and UDP ASSOCIATE need allocations of their own and can not use
+	// a handed off allocation
+	if opts.Credentials != nil || (opts.Username != "" && opts.Password != "") {
+		server.Bind = &socksimplementations.SocksTurnBindHandler{
+			Ctx:                    handler.Ctx,
+			TURNUsername:           opts.Username,
+			TURNPassword:           opts.Password,
+			Server:                 opts.TurnServer,
+			Timeout:                opts.Timeout,
+			UseTLS:                 opts.UseTLS,
+			TlsVerify:              opts.TlsVerify,
+			DropNonPrivateRequests: opts.DropPublic,
+			ACL:                    opts.ACL,
+			Log:                    opts.Log,
+			AcceptTimeout:          opts.BindTimeout,
+			StrictDNS:              opts.StrictDNS,
+			Resolver:               handler.Resolver,
+			Credentials:            opts.Credentials,
+			CopyOptions:            handler.CopyOptions,
+			QuietHours:             opts.QuietHours,
+			Pauser:                 pauser,
+		}
+		server.Associate = &socksimplementations.SocksTurnUDPHandler{
+			Ctx:                    handler.Ctx,
+			TURNUsername:           opts.Username,
+			TURNPassword:           opts.Password,
+			Server:                 opts.TurnServer,
+			ConnectProtocol:        opts.Protocol,
+			Timeout:                opts.Timeout,
+			UseTLS:                 opts.UseTLS,
+			TlsVerify:              opts.TlsVerify,
+			DropNonPrivateRequests: opts.DropPublic,
+			ACL:                    opts.ACL,
+			Log:                    opts.Log,
+			StrictDNS:              opts.StrictDNS,
+			Resolver:               handler.Resolver,
+			Credentials:            opts.Credentials,
+			QuietHours:             opts.QuietHours,
+			Pauser:                 pauser,
+		}
+	} else {
+		opts.Log.Warn("BIND and UDP ASSOCIATE need a username and a password and are disabled")
+	}
+
+	transparent := &socksimplementations.TransparentServer{
+		Connect: handler,
+		Allow:   opts.Allow,
+		Log:     opts.Log,
+	}
+
+	// on shutdown no new connections are accepted and the active ones get
+	// some time to finish before their allocations are deleted
+	removeDrain := internal.OnDrain(func() {
+		if active := server.Active() + transparent.Active(); active > 0 && opts.DrainTimeout > 0 {
+			opts.Log.Infof("[socks] waiting up to %s for %d active connections to finish", opts.DrainTimeout, active)
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), opts.DrainTimeout)
+		defer cancel()
+		// both servers stop accepting connections at once
+		errTransparent := make(chan error, 1)
+		go func() {
+			errTransparent <- transparent.Shutdown(ctx)
+		}()
+		err := server.Shutdown(ctx)
+		if err == nil {
+			err = <-errTransparent
+		}
+		if err != nil && server.Active()+transparent.Active() > 0 {
+			opts.Log.Warnf("[socks] closing %d connections that did not finish in time", server.Active()+transparent.Active())
+		}
+	})
+	defer removeDrain()
+
+	// all listeners share the same handler and therefore the same backend state
+	for _, listen := range opts.Listen {
+		listener, err := net.Listen("tcp", listen)
+		if err != nil {
+			return fmt.Errorf("could not start SOCKS server on %s: %w", listen, err)
+		}
+		defer listener.Close()
+		opts.Log.Infof("starting SOCKS server on %s", listen)
+		if len(opts.Users) == 0 && len(opts.Allow) == 0 && !isLoopback(listen) {
+			opts.Log.Warnf("the SOCKS server on %s can be used by other hosts without authentication, consider --socks-user or --allow", listen)
+		}
+		go server.Serve(listener)
+	}
+	if opts.Transparent != "" {
+		listener, tproxy, err := helper.ListenTransparent(opts.Transparent)
+		if err != nil {
+			return fmt.Errorf("could not start transparent proxy on %s: %w", opts.Transparent, err)
+		}
+		defer listener.Close()
+		opts.Log.Infof("starting transparent proxy on %s", opts.Transparent)
+		if !tproxy {
+			opts.Log.Warn("could not set IP_TRANSPARENT, only connections redirected with REDIRECT rules are relayed. TPROXY rules need CAP_NET_ADMIN")
+		}
+		go transparent.Serve(listener)
+	}
+	// runs until the listeners are closed on shutdown
+	<-internal.Context().Done()
+	return nil
+}
+
+// connectHandler returns the handler of CONNECT requests shared by the
+// socks and http-proxy commands. The returned function releases the
+// allocations of the pool
+func connectHandler(opts SocksOpts) (*socksimplementations.SocksTurnTCPHandler, func()) {
 	handler := &socksimplementations.SocksTurnTCPHandler{
-		Ctx:                    context.Background(),
+		Ctx:                    internal.Context(),
 		Server:                 opts.TurnServer,
 		TURNUsername:           opts.Username,
 		TURNPassword:           opts.Password,
 		Timeout:                opts.Timeout,
 		UseTLS:                 opts.UseTLS,
+		TlsVerify:              opts.TlsVerify,
 		DropNonPrivateRequests: opts.DropPublic,
+		ACL:                    opts.ACL,
 		Log:                    opts.Log,
+		ConnectRetries:         opts.ConnectRetries,
+		RetryBackoff:           opts.RetryBackoff,
+		Pace:                   opts.Pace,
+		Shaping:                opts.Shaping,
+		CopyOptions: helper.CopyOptions{
+			BufferSize:   opts.BufferSize,
+			WriteTimeout: opts.WriteTimeout,
+		},
+		SessionTimeout: opts.SessionTimeout,
+		QuietHours:     opts.QuietHours,
+		StrictDNS:      opts.StrictDNS,
+		Credentials:    opts.Credentials,
 	}
-	p := socks.Proxy{
-		ServerAddr:   opts.Listen,
-		Proxyhandler: handler,
-		Timeout:      opts.Timeout,
-		Log:          opts.Log,
+	if opts.Credentials != nil {
+		opts.Log.Infof("reading the credentials from %s", opts.Credentials)
+		go WatchCredentialExpiry(handler.Ctx, opts.Log, opts.Credentials.Credentials, true)
 	}
-	opts.Log.Infof("starting SOCKS server on %s", opts.Listen)
-	if err := p.Start(); err != nil {
-		return err
+	if opts.RelayDNS.IsValid() {
+		handler.Resolver = relayResolver(opts)
 	}
-	<-p.Done
-	return nil
+	if opts.Enrich {
+		handler.Enricher = helper.NewEnricher(opts.Timeout)
+	}
+	if opts.Pool {
+		pool := internal.NewTCPAllocationPool(opts.Log, func(addressFamily internal.AllocateProtocol) (*internal.TCPAllocation, error) {
+			creds, err := opts.credentials(handler.Ctx)
+			if err != nil {
+				return nil, fmt.Errorf("could not get credentials from %s: %w", opts.Credentials, err)
+			}
+			return internal.NewTCPAllocation(opts.Log, opts.TurnServer, opts.UseTLS, opts.TlsVerify, opts.Timeout, addressFamily, creds.Username, creds.Password)
+		}, opts.PoolConnections, opts.PoolIdle)
+		handler.Pool = pool
+		return handler, func() { pool.Close() }
+	}
+	return handler, func() {}
+}
+
+// logStatsOnSignal logs the statistics of the proxy whenever SIGUSR2 is
+// received. The returned function stops it
+func logStatsOnSignal(log *logrus.Logger, stats *socksimplementations.Stats) func() {
+	signals := make(chan os.Signal, 1)
+	done := make(chan struct{})
+	if s := helper.StatsSignals(); len(s) > 0 {
+		// Notify without signals would relay all signals
+		signal.Notify(signals, s...)
+	}
+	go func() {
+		for {
+			select {
+			case <-signals:
+				log.Infof("[socks] %s", stats.Snapshot())
+			case <-done:
+				return
+			}
+		}
+	}()
+	return func() {
+		signal.Stop(signals)
+		close(done)
+	}
+}
+
+// isLoopback returns true if the listen address only accepts connections
+// from the local machine
+func isLoopback(listen string) bool {
+	host, _, err := net.SplitHostPort(listen)
+	if err != nil {
+		return false
+	}
+	if host == "localhost" {
+		return true
+	}
+	ip, err := netip.ParseAddr(host)
+	return err == nil && ip.IsLoopback()
+}
+
+// relayResolver resolves names on the DNS server of the options through
+// the relay. IPv4 addresses are preferred
+func relayResolver(opts SocksOpts) socksimplementations.Resolver {
+	return func(ctx context.Context, name string) (netip.Addr, error) {
+		creds, err := opts.credentials(ctx)
+		if err != nil {
+			return netip.Addr{}, err
+		}
+		allocation, err := internal.NewAllocation(opts.Log, opts.Protocol, opts.TurnServer, opts.UseTLS, opts.TlsVerify, opts.Timeout, opts.RelayDNS.Addr(), opts.RelayDNS.Port(), creds.Username, creds.Password)
+		if err != nil {
+			return netip.Addr{}, err
+		}
+		defer allocation.Close()
+
+		for _, qtype := range []uint16{helper.DNSTypeA, helper.DNSTypeAAAA} {
+			if ctx.Err() != nil {
+				return netip.Addr{}, ctx.Err()
+			}
+			msg, err := relayDNSQuery(opts.Log, allocation.Conn, allocation.Channel, name, qtype, opts.Protocol == "tcp", opts.Timeout)
+			if err != nil {
+				if errors.Is(err, helper.ErrTimeout) {
+					continue
+				}
+				return netip.Addr{}, err
+			}
+			for _, a := range msg.Answers {
+				if a.Type != qtype {
+					continue
+				}
+				if ip, err := netip.ParseAddr(a.Data); err == nil {
+					opts.Log.Debugf("[socks] resolved %s to %s on %s", name, ip, opts.RelayDNS)
+					return ip, nil
+				}
+			}
+		}
+		return netip.Addr{}, fmt.Errorf("%s could not be resolved on %s", name, opts.RelayDNS)
+	}
+}
+
+// credentials returns the credentials of the provider or the static ones
+// if no provider is set
+func (opts SocksOpts) credentials(ctx context.Context) (helper.Credentials, error) {
+	if opts.Credentials == nil {
+		return helper.Credentials{Username: opts.Username, Password: opts.Password}, nil
+	}
+	return opts.Credentials.Credentials(ctx)
 }
@@ -0,0 +1,473 @@
+package cmd
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"net"
+	"net/netip"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/firefart/stunner/internal"
+	"github.com/firefart/stunner/internal/helper"
+	"github.com/firefart/stunner/internal/results"
+	"github.com/sirupsen/logrus"
+)
+
+type StateLeakOpts struct {
+	TurnServer string
+	Protocol   string
+	Username   string
+	Password   string
+	UseTLS     bool
+	TlsVerify  bool
+	Timeout    time.Duration
+	Log        *logrus.Logger
+	// DNSServer answers the queries used to see if data is relayed
+	DNSServer netip.AddrPort
+	Output    string
+}
+
+func (opts StateLeakOpts) Validate() error {
+	if opts.TurnServer == "" {
+		return fmt.Errorf("need a valid turnserver")
+	}
+	if !strings.Contains(opts.TurnServer, ":") {
+		return fmt.Errorf("turnserver needs a port")
+	}
+	if opts.Protocol != "tcp" && opts.Protocol != "udp" {
+		return fmt.Errorf("protocol needs to be either tcp or udp")
+	}
+	if opts.Username == "" {
+		return fmt.Errorf("please supply a username")
+	}
+	if opts.Password == "" {
+		return fmt.Errorf("please supply a password")
+	}
+	if opts.Log == nil {
+		return fmt.Errorf("please supply a valid logger")
+	}
+	if !opts.DNSServer.IsValid() {
+		return fmt.Errorf("please supply a valid dns server")
+	}
+
+	return nil
+}
+
+// stateLeakCheck is a single check of the state-leak command. The checks
+// run in order on the same connection and depend on the previous ones
+type stateLeakCheck struct {
+	Name        string
+	Description string
+	Run         func(s *leakSession) (checkStatus, string)
+}
+
+var stateLeakChecks = []stateLeakCheck{
+	{
+		Name:        "double-allocation",
+		Description: "a second allocation on the same 5-tuple is rejected",
+		Run:         checkDoubleAllocation,
+	},
+	{
+		Name:        "deleted-channel",
+		Description: "no data is relayed on a channel of a deleted allocation",
+		Run:         checkDeletedChannel,
+	},
+	{
+		Name:        "channel-leak",
+		Description: "channels of a deleted allocation are not bound on a new allocation on the same 5-tuple",
+		Run:         checkChannelLeak,
+	},
+	{
+		Name:        "permission-leak",
+		Description: "permissions of a deleted allocation do not exist on a new allocation on the same 5-tuple",
+		Run:         checkPermissionLeak,
+	},
+	{
+		Name:        "channel-rebind",
+		Description: "channel numbers of a deleted allocation can be bound to a different peer",
+		Run:         checkChannelRebind,
+	},
+}
+
+// leakSession is the connection and the state shared by the checks
+type leakSession struct {
+	opts  StateLeakOpts
+	conn  net.Conn
+	realm string
+	nonce string
+	// channel is bound to the DNS server on the first allocation
+	channel []byte
+	// relayed is the relayed address of the first allocation
+	relayed string
+	// reallocated is set if the allocation was deleted and requested again.
+	// reallocateErr is set if that failed
+	reallocated   bool
+	reallocateErr error
+	allocated     bool
+}
+
+// StateLeak checks if channels and permissions survive the deletion of an
+// allocation when a new one is requested on the same 5-tuple right after.
+// Relays keyed by the 5-tuple have exposed bugs like this before
+func StateLeak(opts StateLeakOpts) error {
+	if err := opts.Validate(); err != nil {
+		return err
+	}
+
+	writer, err := results.NewWriter(opts.Output)
+	if err != nil {
+		return err
+	}
+	defer writer.Close()
+
+	conn, err := internal.Connect(opts.Protocol, opts.TurnServer, opts.UseTLS, opts.TlsVerify, opts.Timeout)
+	if err != nil {
+		return err
+	}
+	s := &leakSession{opts: opts, conn: conn}
+	defer s.close()
+
+	// baseline: the DNS server needs to answer through the relay for the
+	// checks to mean anything
+	if err := s.setup(); err != nil {
+		return err
+	}
+
+	failed := 0
+	for _, check := range stateLeakChecks {
+		opts.Log.Debugf("running check %s", check.Name)
+		status, detail := check.Run(s)
+		switch status {
+		case checkPass:
+			opts.Log.Infof("[%s] %s: %s", status, check.Name, check.Description)
+		case checkFail:
+			failed++
+			opts.Log.Warnf("[%s] %s: %s", status, check.Name, check.Description)
+		default:
+			opts.Log.Errorf("[%s] %s: %s", status, check.Name, check.Description)
+		}
+		if detail != "" {
+			opts.Log.Infof("\t%s", detail)
+		}
+
+		if err := writer.Write(results.Finding{
+			Module:   "state-leak",
+			Relay:    opts.TurnServer,
+			Host:     opts.DNSServer.Addr().String(),
+			Port:     opts.DNSServer.Port(),
+			Protocol: "udp",
+			Service:  check.Name,
+			Details: map[string]string{
+				"status": string(status),
+				"detail": detail,
+			},
+		}); err != nil {
+			return err
+		}
+	}
+
+	if failed > 0 {
+		opts.Log.Warnf("%d of %d checks failed, allocation state leaks on this relay", failed, len(stateLeakChecks))
+	} else {
+		opts.Log.Infof("all %d checks passed", len(stateLeakChecks))
+	}
+	return nil
+}
+
+// setup allocates, binds a channel to the DNS server and verifies that
+// queries are answered over the channel and with send indications
+func (s *leakSession) setup() error {
+	allocateRequest := internal.AllocateRequest(internal.RequestedTransportUDP, s.addressFamily())
+	allocateResponse, err := allocateRequest.SendAndReceive(s.opts.Log, s.conn, s.opts.Timeout)
+	if err != nil {
+		return fmt.Errorf("error on sending AllocateRequest: %w", err)
+	}
+	if allocateResponse.Header.MessageType.Class != internal.MsgTypeClassError {
+		s.allocated = true
+		return fmt.Errorf("MessageClass is not Error (should be not authenticated)")
+	}
+	s.realm = string(allocateResponse.GetAttribute(internal.AttrRealm).Value)
+	s.nonce = string(allocateResponse.GetAttribute(internal.AttrNonce).Value)
+
+	relayed, err := s.allocate()
+	if err != nil {
+		return err
+	}
+	s.relayed = relayed
+	s.opts.Log.Infof("allocation relayed on %s", relayed)
+
+	if err := s.permit(); err != nil {
+		return err
+	}
+	s.channel = helper.RandomChannelNumber()
+	if err := s.bind(s.channel, s.opts.DNSServer); err != nil {
+		return err
+	}
+
+	answered, err := s.queryChannel()
+	if err != nil {
+		return err
+	}
+	if !answered {
+		return fmt.Errorf("%s does not answer over the channel, use a DNS server reachable via the relay", s.opts.DNSServer)
+	}
+	answered, err = s.queryIndication()
+	if err != nil {
+		return err
+	}
+	if !answered {
+		return fmt.Errorf("%s does not answer send indications, use a DNS server reachable via the relay", s.opts.DNSServer)
+	}
+	return nil
+}
+
+func checkDoubleAllocation(s *leakSession) (checkStatus, string) {
+	allocateResponse, err := s.request(func() (*internal.Stun, error) {
+		return internal.AllocateRequestAuth(s.opts.Username, s.opts.Password, s.nonce, s.realm, internal.RequestedTransportUDP, s.addressFamily()), nil
+	})
+	if err != nil {
+		return checkError, err.Error()
+	}
+	if allocateResponse.Header.MessageType.Class != internal.MsgTypeClassError {
+		return checkFail, "a second allocation was granted on the same 5-tuple"
+	}
+	if code, ok := allocateResponse.GetErrorCode(); ok && code != internal.ErrorAllocationMismatch {
+		return checkPass, fmt.Sprintf("rejected with %s instead of %d", allocateResponse.GetErrorString(), internal.ErrorAllocationMismatch)
+	}
+	return checkPass, allocateResponse.GetErrorString()
+}
+
+func checkDeletedChannel(s *leakSession) (checkStatus, string) {
+	if err := s.deallocate(); err != nil {
+		return checkError, err.Error()
+	}
+	answered, err := s.queryChannel()
+	if err != nil {
+		return checkError, err.Error()
+	}
+	if answered {
+		return checkFail, "the DNS server answered over the channel after the allocation was deleted"
+	}
+	return checkPass, ""
+}
+
+func checkChannelLeak(s *leakSession) (checkStatus, string) {
+	if err := s.reallocate(); err != nil {
+		return checkError, err.Error()
+	}
+	answered, err := s.queryChannel()
+	if err != nil {
+		return checkError, err.Error()
+	}
+	if answered {
+		return checkFail, fmt.Sprintf("channel %02x of the deleted allocation relays data to %s", s.channel, s.opts.DNSServer)
+	}
+	return checkPass, ""
+}
+
+func checkPermissionLeak(s *leakSession) (checkStatus, string) {
+	if err := s.reallocate(); err != nil {
+		return checkError, err.Error()
+	}
+	answered, err := s.queryIndication()
+	if err != nil {
+		return checkError, err.Error()
+	}
+	if answered {
+		return checkFail, fmt.Sprintf("data was relayed to %s without creating a permission", s.opts.DNSServer)
+	}
+	return checkPass, ""
+}
+
+func checkChannelRebind(s *leakSession) (checkStatus, string) {
+	if err := s.reallocate(); err != nil {
+		return checkError, err.Error()
+	}
+	// a channel can only be bound to one peer per allocation, so an error
+	// shows the binding of the deleted allocation still exists
+	other := netip.AddrPortFrom(s.opts.DNSServer.Addr(), s.opts.DNSServer.Port()+1)
+	if err := s.bind(s.channel, other); err != nil {
+		return checkFail, fmt.Sprintf("binding channel %02x to %s failed: %v", s.channel, other, err)
+	}
+	return checkPass, ""
+}
+
+// reallocate requests a new allocation on the same connection once. Later
+// calls return the result of the first one
+func (s *leakSession) reallocate() error {
+	if s.reallocated {
+		return s.reallocateErr
+	}
+	s.reallocated = true
+	relayed, err := s.allocate()
+	if err != nil {
+		s.reallocateErr = fmt.Errorf("could not allocate again on the same 5-tuple: %w", err)
+		return s.reallocateErr
+	}
+	if relayed == s.relayed {
+		s.opts.Log.Infof("new allocation got the same relayed address %s", relayed)
+	} else {
+		s.opts.Log.Infof("new allocation relayed on %s", relayed)
+	}
+	return nil
+}
+
+// allocate requests an authenticated allocation and returns the relayed address
+func (s *leakSession) allocate() (string, error) {
+	allocateResponse, err := s.request(func() (*internal.Stun, error) {
+		return internal.AllocateRequestAuth(s.opts.Username, s.opts.Password, s.nonce, s.realm, internal.RequestedTransportUDP, s.addressFamily()), nil
+	})
+	if err != nil {
+		return "", err
+	}
+	if allocateResponse.Header.MessageType.Class == internal.MsgTypeClassError {
+		return "", fmt.Errorf("error on AllocateRequest Auth: %w", allocateResponse.GetError())
+	}
+	s.allocated = true
+	host, port, err := internal.ConvertXORAddr(allocateResponse.GetAttribute(internal.AttrXorRelayedAddress).Value, allocateResponse.Header.TransactionID)
+	if err != nil {
+		return "", fmt.Errorf("invalid relayed address: %w", err)
+	}
+	return net.JoinHostPort(host, strconv.Itoa(int(port))), nil
+}
+
+func (s *leakSession) deallocate() error {
+	resp, err := s.request(func() (*internal.Stun, error) {
+		return internal.DeallocateRequest(s.opts.Username, s.opts.Password, s.nonce, s.realm), nil
+	})
+	if err != nil {
+		return err
+	}
+	if resp.Header.MessageType.Class == internal.MsgTypeClassError {
+		return fmt.Errorf("error on deleting the allocation: %w", resp.GetError())
+	}
+	s.allocated = false
+	return nil
+}
+
+func (s *leakSession) permit() error {
+	resp, err := s.request(func() (*internal.Stun, error) {
+		return internal.CreatePermissionRequest(s.opts.Username, s.opts.Password, s.nonce, s.realm, s.opts.DNSServer.Addr(), s.opts.DNSServer.Port())
+	})
+	if err != nil {
+		return err
+	}
+	if resp.Header.MessageType.Class == internal.MsgTypeClassError {
+		return fmt.Errorf("error on CreatePermission: %w", resp.GetError())
+	}
+	return nil
+}
+
+func (s *leakSession) bind(channel []byte, peer netip.AddrPort) error {
+	resp, err := s.request(func() (*internal.Stun, error) {
+		return internal.ChannelBindRequest(s.opts.Username, s.opts.Password, s.nonce, s.realm, peer.Addr(), peer.Port(), channel)
+	})
+	if err != nil {
+		return err
+	}
+	if resp.Header.MessageType.Class == internal.MsgTypeClassError {
+		return fmt.Errorf("error on ChannelBind: %w", resp.GetError())
+	}
+	return nil
+}
+
+// request sends an authenticated request and retries once with the new
+// nonce if the nonce is stale
+func (s *leakSession) request(build func() (*internal.Stun, error)) (*internal.Stun, error) {
+	for attempt := 0; ; attempt++ {
+		req, err := build()
+		if err != nil {
+			return nil, err
+		}
+		resp, err := req.SendAndReceive(s.opts.Log, s.conn, s.opts.Timeout)
+		if err != nil {
+			return nil, err
+		}
+		if code, ok := resp.GetErrorCode(); attempt == 0 && ok && code == internal.ErrorStaleNonce {
+			s.nonce = string(resp.GetAttribute(internal.AttrNonce).Value)
+			continue
+		}
+		return resp, nil
+	}
+}
+
+// queryChannel sends a DNS query over the channel and reports if it was answered
+func (s *leakSession) queryChannel() (bool, error) {
+	query := helper.DNSQuery(".", helper.DNSTypeNS)
+	// ChannelData over TCP needs to be padded to a multiple of 4 bytes
+	buf, err := internal.ChannelData(s.channel, query, s.opts.Protocol == "tcp")
+	if err != nil {
+		return false, err
+	}
+	if err := helper.ConnectionWrite(s.conn, buf, s.opts.Timeout); err != nil {
+		return false, fmt.Errorf("error on sending data: %w", err)
+	}
+	return s.waitForAnswer(query[:2])
+}
+
+// queryIndication sends a DNS query with a send indication and reports if
+// it was answered
+func (s *leakSession) queryIndication() (bool, error) {
+	query := helper.DNSQuery(".", helper.DNSTypeNS)
+	indication, err := internal.SendIndication(s.opts.DNSServer.Addr(), s.opts.DNSServer.Port(), query, false)
+	if err != nil {
+		return false, err
+	}
+	if err := indication.Send(s.opts.Log, s.conn, s.opts.Timeout); err != nil {
+		return false, err
+	}
+	return s.waitForAnswer(query[:2])
+}
+
+// waitForAnswer reads until a DNS response with the id arrives as channel
+// data or data indication. It returns false if none arrives in time
+func (s *leakSession) waitForAnswer(id []byte) (bool, error) {
+	for {
+		buf, err := helper.ConnectionRead(s.conn, s.opts.Timeout)
+		if errors.Is(err, helper.ErrTimeout) {
+			return false, nil
+		}
+		if err != nil {
+			return false, err
+		}
+
+		var payloads [][]byte
+		if internal.IsChannelData(buf) {
+			payloads, _ = internal.SplitChannelData(buf, s.opts.Protocol == "tcp")
+		} else {
+			_, data, err := internal.ParseDataIndication(buf)
+			if err != nil {
+				s.opts.Log.Debugf("ignoring unexpected message: %v", err)
+				continue
+			}
+			payloads = append(payloads, data)
+		}
+		for _, data := range payloads {
+			if len(data) >= 2 && bytes.Equal(data[:2], id) {
+				return true, nil
+			}
+			s.opts.Log.Debugf("ignoring %d bytes not answering the query", len(data))
+		}
+	}
+}
+
+func (s *leakSession) addressFamily() internal.AllocateProtocol {
+	if s.opts.DNSServer.Addr().Is6() {
+		return internal.AllocateProtocolIPv6
+	}
+	return internal.AllocateProtocolIgnore
+}
+
+// close deletes the current allocation and closes the connection
+func (s *leakSession) close() {
+	defer s.conn.Close()
+	if !s.allocated {
+		return
+	}
+	if err := s.deallocate(); err != nil {
+		s.opts.Log.Debugf("could not delete the allocation: %v", err)
+	}
+}
@@ -27,6 +27,25 @@ type TCPScannerOpts struct {
 	Log        *logrus.Logger
 	Ports      []string
 	IPs        []string
+	// Loopback adds the IPv6 loopback address to the scanned ranges
+	Loopback bool
+	Delay    time.Duration
+	// LogLimit is the number of similar errors logged before they are
+	// suppressed. 0 logs all errors
+	LogLimit int
+	// ControlListen is the address of the pause and resume control API.
+	// The API is disabled if empty
+	ControlListen string
+	// ControlSDDL is the security descriptor of the named pipe of the
+	// control API on windows. Only the current user, SYSTEM and the
+	// administrators have access if empty
+	ControlSDDL string
+	// QuietHours pause the scan automatically
+	QuietHours helper.QuietHours
+	// Timeouts override Timeout for single phases of the scan
+	Timeouts helper.Timeouts
+	// SkipHealthCheck disables the check of the relay before the scan
+	SkipHealthCheck bool
 }
 
 func (opts TCPScannerOpts) Validate() error {
@@ -51,6 +70,9 @@ func (opts TCPScannerOpts) Validate() error {
 	if len(opts.Ports) == 0 {
 		return fmt.Errorf("please supply valid ports")
 	}
+	if opts.LogLimit < 0 {
+		return fmt.Errorf("log limit can not be negative")
+	}
 	// no need to check IPs, it can be nil
 
 	return nil
@@ -60,12 +82,33 @@ func TCPScanner(opts TCPScannerOpts) error {
 	if err := opts.Validate(); err != nil {
 		return err
 	}
+	opts.Timeouts = opts.Timeouts.WithDefault(opts.Timeout)
+
+	ipInput := helper.ScanRanges(opts.IPs, opts.Loopback)
 
-	ipInput := opts.IPs
-	if len(ipInput) == 0 {
-		ipInput = helper.PrivateRanges
+	if !opts.SkipHealthCheck {
+		target, err := firstTarget(ipInput)
+		if err != nil {
+			return fmt.Errorf("invalid ip range: %w", err)
+		}
+		port, err := strconv.ParseUint(strings.TrimSpace(opts.Ports[0]), 10, 16)
+		if err != nil {
+			return fmt.Errorf("Invalid port %s: %w", opts.Ports[0], err)
+		}
+		if err := healthCheck(opts.Log, opts.Protocol, opts.TurnServer, opts.UseTLS, opts.TlsVerify, opts.Timeouts.Setup, target, uint16(port), opts.Username, opts.Password); err != nil {
+			return err
+		}
 	}
 
+	sampler := helper.NewLogSampler(opts.Log, opts.LogLimit)
+	defer sampler.Flush()
+
+	pauser, stopPause, err := startPauseControl(opts.Log, "scan", opts.ControlListen, opts.ControlSDDL, opts.QuietHours, nil)
+	if err != nil {
+		return err
+	}
+	defer stopPause()
+
 	ipChan := helper.IPIterator(ipInput)
 
 	for ip := range ipChan {
@@ -79,10 +122,12 @@ func TCPScanner(opts TCPScannerOpts) error {
 			if err != nil {
 				return fmt.Errorf("Invalid port %s: %w", port, err)
 			}
+			pauser.Wait()
 			opts.Log.Debugf("Scanning %s:%d", ip.IP.String(), portI)
 			if err := httpScan(opts, ip.IP, uint16(portI)); err != nil {
-				opts.Log.Errorf("error on running HTTP Scan for %s:%d: %v", ip.IP.String(), portI, err)
+				sampler.Errorf("error on running HTTP Scan for %s:%d: %v", ip.IP.String(), portI, err)
 			}
+			time.Sleep(opts.Delay)
 		}
 	}
 
@@ -90,7 +135,7 @@ func TCPScanner(opts TCPScannerOpts) error {
 }
 
 func httpScan(opts TCPScannerOpts, ip netip.Addr, port uint16) error {
-	controlConnection, dataConnection, err := internal.SetupTurnTCPConnection(opts.Log, opts.TurnServer, opts.UseTLS, opts.TlsVerify, opts.Timeout, ip, port, opts.Username, opts.Password)
+	controlConnection, dataConnection, err := internal.SetupTurnTCPConnection(opts.Log, opts.TurnServer, opts.UseTLS, opts.TlsVerify, opts.Timeouts.Setup, ip, port, opts.Username, opts.Password)
 	if err != nil {
 		return err
 	}
@@ -104,27 +149,36 @@ func httpScan(opts TCPScannerOpts, ip netip.Addr, port uint16) error {
 
 	if useTLS {
 		tlsConn := tls.Client(dataConnection, &tls.Config{InsecureSkipVerify: true})
-		if err := helper.ConnectionWrite(tlsConn, []byte(httpRequest), opts.Timeout); err != nil {
+		if err := helper.ConnectionWrite(tlsConn, []byte(httpRequest), opts.Timeouts.TCP); err != nil {
 			return fmt.Errorf("error on sending TLS data: %w", err)
 		}
-		data, err := helper.ConnectionRead(tlsConn, opts.Timeout)
+		data, truncated, err := helper.ConnectionReadResponse(tlsConn, opts.Timeouts.TCP)
 		if err != nil {
 			return fmt.Errorf("error on reading after sending TLS data: %w", err)
 		}
+		logTruncated(opts.Log, ip, port, data, truncated)
 		opts.Log.Info(string(data))
 		opts.Log.Info(hex.EncodeToString(data))
 		return nil
 	}
 
 	// plain text connection
-	if err := helper.ConnectionWrite(dataConnection, []byte(httpRequest), opts.Timeout); err != nil {
+	if err := helper.ConnectionWrite(dataConnection, []byte(httpRequest), opts.Timeouts.TCP); err != nil {
 		return fmt.Errorf("error on sending data: %w", err)
 	}
-	data, err := helper.ConnectionRead(dataConnection, opts.Timeout)
+	data, truncated, err := helper.ConnectionReadResponse(dataConnection, opts.Timeouts.TCP)
 	if err != nil {
 		return fmt.Errorf("error on reading after sending data: %w", err)
 	}
+	logTruncated(opts.Log, ip, port, data, truncated)
 	opts.Log.Info(string(data))
 	opts.Log.Info(hex.EncodeToString(data))
 	return nil
 }
+
+// logTruncated warns that only the first part of a response is shown
+func logTruncated(log *logrus.Logger, ip netip.Addr, port uint16, data []byte, truncated bool) {
+	if truncated {
+		log.Warnf("response of %s:%d truncated after %d bytes", ip, port, len(data))
+	}
+}
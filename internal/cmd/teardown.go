@@ -0,0 +1,265 @@
+package cmd
+
+import (
+	"encoding/binary"
+	"fmt"
+	"net/netip"
+	"strings"
+	"time"
+
+	"github.com/firefart/stunner/internal"
+	"github.com/firefart/stunner/internal/results"
+	"github.com/sirupsen/logrus"
+)
+
+type TeardownOpts struct {
+	TurnServer string
+	Protocol   string
+	Username   string
+	Password   string
+	UseTLS     bool
+	TlsVerify  bool
+	Timeout    time.Duration
+	Log        *logrus.Logger
+	// DNSServer answers the queries used to see if data is relayed
+	DNSServer netip.AddrPort
+	// ExpiryWait is the longest time to wait for an allocation to expire.
+	// The idle-expiry check is skipped if the lifetime granted by the
+	// server is longer
+	ExpiryWait time.Duration
+	Output     string
+}
+
+func (opts TeardownOpts) Validate() error {
+	if opts.TurnServer == "" {
+		return fmt.Errorf("need a valid turnserver")
+	}
+	if !strings.Contains(opts.TurnServer, ":") {
+		return fmt.Errorf("turnserver needs a port")
+	}
+	if opts.Protocol != "tcp" && opts.Protocol != "udp" {
+		return fmt.Errorf("protocol needs to be either tcp or udp")
+	}
+	if opts.Username == "" {
+		return fmt.Errorf("please supply a username")
+	}
+	if opts.Password == "" {
+		return fmt.Errorf("please supply a password")
+	}
+	if opts.Log == nil {
+		return fmt.Errorf("please supply a valid logger")
+	}
+	if !opts.DNSServer.IsValid() {
+		return fmt.Errorf("please supply a valid dns server")
+	}
+	if opts.ExpiryWait < 0 {
+		return fmt.Errorf("expiry wait can not be negative")
+	}
+
+	return nil
+}
+
+// teardownGrace is added to the lifetime of an allocation before it is
+// expected to be gone
+const teardownGrace = 10 * time.Second
+
+// teardownCheck closes an allocation in one way. Every check runs on its
+// own allocation
+type teardownCheck struct {
+	Name        string
+	Description string
+	Run         func(opts TeardownOpts, s *leakSession) (checkStatus, string)
+}
+
+var teardownChecks = []teardownCheck{
+	{
+		Name:        "lifetime-zero",
+		Description: "the relayed address stops relaying after a refresh with lifetime 0",
+		Run:         checkTeardownLifetimeZero,
+	},
+	{
+		Name:        "socket-close",
+		Description: "the allocation is deleted when the connection is closed abruptly",
+		Run:         checkTeardownSocketClose,
+	},
+	{
+		Name:        "idle-expiry",
+		Description: "the allocation is deleted once its lifetime expired",
+		Run:         checkTeardownIdleExpiry,
+	},
+}
+
+// Teardown closes allocations with a lifetime 0 refresh, an abrupt close
+// of the connection and by letting them expire. After each it checks if
+// the relayed address still relays data and if the allocation still
+// exists on the 5-tuple, which shows relay ports leaking after the client
+// is gone
+func Teardown(opts TeardownOpts) error {
+	if err := opts.Validate(); err != nil {
+		return err
+	}
+
+	writer, err := results.NewWriter(opts.Output)
+	if err != nil {
+		return err
+	}
+	defer writer.Close()
+
+	failed := 0
+	for _, check := range teardownChecks {
+		opts.Log.Debugf("running check %s", check.Name)
+		status, detail := runTeardownCheck(opts, check)
+		switch status {
+		case checkPass:
+			opts.Log.Infof("[%s] %s: %s", status, check.Name, check.Description)
+		case checkFail:
+			failed++
+			opts.Log.Warnf("[%s] %s: %s", status, check.Name, check.Description)
+		case checkSkip:
+			opts.Log.Infof("[%s] %s: %s", status, check.Name, check.Description)
+		default:
+			opts.Log.Errorf("[%s] %s: %s", status, check.Name, check.Description)
+		}
+		if detail != "" {
+			opts.Log.Infof("\t%s", detail)
+		}
+
+		if err := writer.Write(results.Finding{
+			Module:   "teardown",
+			Relay:    opts.TurnServer,
+			Host:     opts.DNSServer.Addr().String(),
+			Port:     opts.DNSServer.Port(),
+			Protocol: "udp",
+			Service:  check.Name,
+			Details: map[string]string{
+				"status": string(status),
+				"detail": detail,
+			},
+		}); err != nil {
+			return err
+		}
+	}
+
+	if failed > 0 {
+		opts.Log.Warnf("%d of %d checks failed, relay ports outlive the client on this relay", failed, len(teardownChecks))
+	}
+	return nil
+}
+
+// runTeardownCheck sets up a new allocation with a channel to the DNS
+// server and runs the check on it
+func runTeardownCheck(opts TeardownOpts, check teardownCheck) (checkStatus, string) {
+	conn, err := internal.Connect(opts.Protocol, opts.TurnServer, opts.UseTLS, opts.TlsVerify, opts.Timeout)
+	if err != nil {
+		return checkError, err.Error()
+	}
+	s := &leakSession{
+		opts: StateLeakOpts{
+			TurnServer: opts.TurnServer,
+			Protocol:   opts.Protocol,
+			Username:   opts.Username,
+			Password:   opts.Password,
+			UseTLS:     opts.UseTLS,
+			TlsVerify:  opts.TlsVerify,
+			Timeout:    opts.Timeout,
+			Log:        opts.Log,
+			DNSServer:  opts.DNSServer,
+		},
+		conn: conn,
+	}
+	defer s.close()
+
+	if err := s.setup(); err != nil {
+		return checkError, err.Error()
+	}
+	return check.Run(opts, s)
+}
+
+func checkTeardownLifetimeZero(opts TeardownOpts, s *leakSession) (checkStatus, string) {
+	if err := s.deallocate(); err != nil {
+		return checkError, err.Error()
+	}
+	return probeTeardown(s)
+}
+
+// checkTeardownSocketClose resets the connection and connects again from
+// the same local address, so the probes are sent on the old 5-tuple
+func checkTeardownSocketClose(opts TeardownOpts, s *leakSession) (checkStatus, string) {
+	local := s.conn.LocalAddr()
+	if err := internal.Abort(s.conn); err != nil {
+		s.opts.Log.Debugf("error on closing the connection: %v", err)
+	}
+	conn, err := internal.ConnectFrom(s.opts.Protocol, s.opts.TurnServer, s.opts.UseTLS, s.opts.TlsVerify, s.opts.Timeout, local)
+	if err != nil {
+		s.allocated = false
+		return checkError, fmt.Sprintf("could not connect again from %s: %v", local, err)
+	}
+	s.conn = conn
+
+	status, detail := probeTeardown(s)
+	if status == checkFail && s.opts.Protocol == "udp" {
+		// the relay is not notified of a closed UDP socket, so the
+		// allocation is expected to live until its lifetime expired
+		return checkPass, detail + ". This is expected over UDP as the relay does not see the socket being closed"
+	}
+	return status, detail
+}
+
+// checkTeardownIdleExpiry waits for the allocation to expire without
+// sending anything on the connection
+func checkTeardownIdleExpiry(opts TeardownOpts, s *leakSession) (checkStatus, string) {
+	// a refresh without lifetime restarts the default lifetime and
+	// returns it
+	resp, err := s.request(func() (*internal.Stun, error) {
+		return internal.RefreshRequest(s.opts.Username, s.opts.Password, s.nonce, s.realm), nil
+	})
+	if err != nil {
+		return checkError, err.Error()
+	}
+	if resp.Header.MessageType.Class == internal.MsgTypeClassError {
+		return checkError, fmt.Sprintf("error on Refresh: %s", resp.GetErrorString())
+	}
+	value := resp.GetAttribute(internal.AttrLifetime).Value
+	if len(value) != 4 {
+		return checkError, "the refresh response contains no lifetime"
+	}
+	lifetime := time.Duration(binary.BigEndian.Uint32(value)) * time.Second
+	wait := lifetime + teardownGrace
+	if wait > opts.ExpiryWait {
+		return checkSkip, fmt.Sprintf("the allocation expires after %s, use --expiry-wait %s to run this check", lifetime, wait)
+	}
+
+	s.opts.Log.Infof("waiting %s for the allocation to expire", wait)
+	time.Sleep(wait)
+	return probeTeardown(s)
+}
+
+// probeTeardown refreshes the allocation on the 5-tuple of the session
+// and sends a DNS query over the channel. A deleted allocation is neither
+// refreshed nor relays the query
+func probeTeardown(s *leakSession) (checkStatus, string) {
+	resp, err := s.request(func() (*internal.Stun, error) {
+		return internal.RefreshRequest(s.opts.Username, s.opts.Password, s.nonce, s.realm), nil
+	})
+	if err != nil {
+		return checkError, err.Error()
+	}
+	alive := resp.Header.MessageType.Class == internal.MsgTypeClassSuccess
+	// delete the allocation on close if it still exists
+	s.allocated = alive
+
+	answered, err := s.queryChannel()
+	if err != nil {
+		return checkError, err.Error()
+	}
+
+	switch {
+	case alive && answered:
+		return checkFail, fmt.Sprintf("the allocation still exists and %s still relays data to %s", s.relayed, s.opts.DNSServer)
+	case alive:
+		return checkFail, "the allocation still exists and can be refreshed"
+	case answered:
+		return checkFail, fmt.Sprintf("the allocation is gone (%s) but %s still relays data to %s", resp.GetErrorString(), s.relayed, s.opts.DNSServer)
+	}
+	return checkPass, resp.GetErrorString()
+}
@@ -0,0 +1,574 @@
+package cmd
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/firefart/stunner/internal"
+	"github.com/firefart/stunner/internal/helper"
+	"github.com/sirupsen/logrus"
+)
+
+type TransferOpts struct {
+	TurnServer string
+	Username   string
+	Password   string
+	UseTLS     bool
+	TlsVerify  bool
+	Timeout    time.Duration
+	Log        *logrus.Logger
+	// URL is the remote file. Supported schemes are http, https, ftp and smb
+	URL string
+	// File is the local file
+	File string
+	// Resume continues a previous partial transfer
+	Resume bool
+	// TargetTlsVerify verifies the certificates of HTTPS servers
+	TargetTlsVerify bool
+	// StrictDNS refuses host names in the url that need a local DNS lookup
+	StrictDNS bool
+	// RelayDNS is a DNS server that host names in the url are resolved on
+	// through the relay instead of locally. Disabled if not valid
+	RelayDNS netip.AddrPort
+}
+
+func (opts TransferOpts) Validate() error {
+	if opts.TurnServer == "" {
+		return fmt.Errorf("need a valid turnserver")
+	}
+	if !strings.Contains(opts.TurnServer, ":") {
+		return fmt.Errorf("turnserver needs a port")
+	}
+	if opts.Username == "" {
+		return fmt.Errorf("please supply a username")
+	}
+	if opts.Password == "" {
+		return fmt.Errorf("please supply a password")
+	}
+	if opts.Log == nil {
+		return fmt.Errorf("please supply a valid logger")
+	}
+	if opts.File == "" {
+		return fmt.Errorf("please supply a local file")
+	}
+	u, err := url.Parse(opts.URL)
+	if err != nil {
+		return fmt.Errorf("invalid url: %w", err)
+	}
+	switch u.Scheme {
+	case "http", "https", "ftp", "smb":
+	default:
+		return fmt.Errorf("unsupported url scheme %q, supported schemes are http, https, ftp and smb", u.Scheme)
+	}
+	if u.Hostname() == "" {
+		return fmt.Errorf("url needs a host")
+	}
+	if u.Scheme == "ftp" && strings.Trim(u.Path, "/") == "" {
+		return fmt.Errorf("ftp url needs a file path")
+	}
+	if share, file, _ := strings.Cut(strings.Trim(u.Path, "/"), "/"); u.Scheme == "smb" && (share == "" || file == "") {
+		return fmt.Errorf("smb url needs a share and a file path")
+	}
+
+	return nil
+}
+
+// Get downloads a file from an internal HTTP, FTP or SMB server via the TURN
+// server
+func Get(opts TransferOpts) error {
+	if err := opts.Validate(); err != nil {
+		return err
+	}
+
+	u, _ := url.Parse(opts.URL)
+	var offset int64
+	if opts.Resume {
+		if fi, err := os.Stat(opts.File); err == nil {
+			offset = fi.Size()
+		} else if !errors.Is(err, os.ErrNotExist) {
+			return fmt.Errorf("could not check local file: %w", err)
+		}
+	}
+
+	switch u.Scheme {
+	case "ftp":
+		return ftpGet(opts, u, offset)
+	case "smb":
+		return smbGet(opts, u, offset)
+	}
+	return httpGet(opts, u, offset)
+}
+
+// Put uploads a file to an internal HTTP, FTP or SMB server via the TURN
+// server. HTTP uploads use the PUT method
+func Put(opts TransferOpts) error {
+	if err := opts.Validate(); err != nil {
+		return err
+	}
+
+	u, _ := url.Parse(opts.URL)
+	f, err := os.Open(opts.File)
+	if err != nil {
+		return fmt.Errorf("could not open local file: %w", err)
+	}
+	defer f.Close()
+	fi, err := f.Stat()
+	if err != nil {
+		return fmt.Errorf("could not stat local file: %w", err)
+	}
+
+	switch u.Scheme {
+	case "ftp":
+		return ftpPut(opts, u, f, fi.Size())
+	case "smb":
+		return smbPut(opts, u, f, fi.Size())
+	}
+	if opts.Resume {
+		return fmt.Errorf("resume is not supported for HTTP uploads")
+	}
+	return httpPut(opts, u, f, fi.Size())
+}
+
+// dialTarget connects to host:port via the TURN server
+func dialTarget(ctx context.Context, opts TransferOpts, address string) (*internal.RelayConn, error) {
+	host, portString, err := net.SplitHostPort(address)
+	if err != nil {
+		return nil, err
+	}
+	port, err := strconv.ParseUint(portString, 10, 16)
+	if err != nil {
+		return nil, fmt.Errorf("invalid port %q: %w", portString, err)
+	}
+	ip, err := targetAddr(ctx, opts, host)
+	if err != nil {
+		return nil, err
+	}
+	opts.Log.Debugf("connecting to %s:%d", ip, port)
+	return internal.DialTurnTCP(opts.Log, opts.TurnServer, opts.UseTLS, opts.TlsVerify, opts.Timeout, ip, uint16(port), opts.Username, opts.Password)
+}
+
+// targetAddr returns the IP of a host that is either an IP or a
+// hostname. Hostnames are resolved through the relay with RelayDNS, refused
+// with StrictDNS and resolved locally otherwise
+func targetAddr(ctx context.Context, opts TransferOpts, host string) (netip.Addr, error) {
+	if ip, err := netip.ParseAddr(host); err == nil {
+		return ip, nil
+	}
+	if opts.RelayDNS.IsValid() {
+		// the data of the relay is always sent via TCP
+		return relayResolver(SocksOpts{
+			TurnServer: opts.TurnServer,
+			Protocol:   "tcp",
+			Username:   opts.Username,
+			Password:   opts.Password,
+			UseTLS:     opts.UseTLS,
+			TlsVerify:  opts.TlsVerify,
+			Timeout:    opts.Timeout,
+			Log:        opts.Log,
+			RelayDNS:   opts.RelayDNS,
+		})(ctx, host)
+	}
+	if opts.StrictDNS {
+		return netip.Addr{}, fmt.Errorf("%s needs a local DNS lookup which is refused in strict DNS mode, use an IP address or --relay-dns", host)
+	}
+	ips, err := helper.ResolveName(ctx, host)
+	if err != nil {
+		return netip.Addr{}, err
+	}
+	if len(ips) == 0 {
+		return netip.Addr{}, fmt.Errorf("%s could not be resolved", host)
+	}
+	return ips[0], nil
+}
+
+// httpClient returns a client that connects via the TURN server
+func httpClient(opts TransferOpts) *http.Client {
+	return &http.Client{
+		Transport: &http.Transport{
+			DialContext: func(ctx context.Context, _, addr string) (net.Conn, error) {
+				conn, err := dialTarget(ctx, opts, addr)
+				if err != nil {
+					return nil, err
+				}
+				return conn, nil
+			},
+			// internal servers mostly use self signed certificates, so
+			// they are only verified on request
+			TLSClientConfig:       &tls.Config{InsecureSkipVerify: !opts.TargetTlsVerify}, // nolint:gosec
+			ResponseHeaderTimeout: 30 * time.Second,
+		},
+	}
+}
+
+func httpGet(opts TransferOpts, u *url.URL, offset int64) error {
+	req, err := http.NewRequest(http.MethodGet, u.String(), nil)
+	if err != nil {
+		return err
+	}
+	if offset > 0 {
+		req.Header.Set("Range", fmt.Sprintf("bytes=%d-", offset))
+	}
+	resp, err := httpClient(opts).Do(req)
+	if err != nil {
+		return fmt.Errorf("error on request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	flags := os.O_CREATE | os.O_WRONLY | os.O_TRUNC
+	total := resp.ContentLength
+	switch {
+	case offset > 0 && resp.StatusCode == http.StatusPartialContent:
+		opts.Log.Infof("resuming download at %s", helper.FormatBytes(offset))
+		flags = os.O_WRONLY | os.O_APPEND
+		if total >= 0 {
+			total += offset
+		}
+	case offset > 0 && resp.StatusCode == http.StatusRequestedRangeNotSatisfiable:
+		opts.Log.Info("the local file is already complete")
+		return nil
+	case resp.StatusCode == http.StatusOK:
+		if offset > 0 {
+			opts.Log.Warn("server does not support ranges, restarting the download")
+		}
+		offset = 0
+	default:
+		return fmt.Errorf("server returned %s", resp.Status)
+	}
+
+	f, err := os.OpenFile(opts.File, flags, 0o644)
+	if err != nil {
+		return fmt.Errorf("could not open local file: %w", err)
+	}
+	defer f.Close()
+
+	progress := helper.NewProgress(offset, total)
+	return runTransfer(opts, progress, func() error {
+		_, err := io.Copy(f, progress.Reader(resp.Body))
+		return err
+	})
+}
+
+func httpPut(opts TransferOpts, u *url.URL, f *os.File, size int64) error {
+	progress := helper.NewProgress(0, size)
+	return runTransfer(opts, progress, func() error {
+		req, err := http.NewRequest(http.MethodPut, u.String(), progress.Reader(f))
+		if err != nil {
+			return err
+		}
+		req.ContentLength = size
+		resp, err := httpClient(opts).Do(req)
+		if err != nil {
+			return fmt.Errorf("error on request: %w", err)
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode < 200 || resp.StatusCode > 299 {
+			return fmt.Errorf("server returned %s", resp.Status)
+		}
+		return nil
+	})
+}
+
+// ftpConnect logs in to the FTP server of the url. Credentials in the
+// url are used, otherwise an anonymous login is done
+func ftpConnect(opts TransferOpts, u *url.URL) (*helper.FTPClient, error) {
+	port := u.Port()
+	if port == "" {
+		port = "21"
+	}
+	host, err := targetAddr(context.Background(), opts, u.Hostname())
+	if err != nil {
+		return nil, err
+	}
+	conn, err := dialTarget(context.Background(), opts, net.JoinHostPort(host.String(), port))
+	if err != nil {
+		return nil, err
+	}
+	dial := func(addr netip.AddrPort) (net.Conn, error) {
+		conn, err := internal.DialTurnTCP(opts.Log, opts.TurnServer, opts.UseTLS, opts.TlsVerify, opts.Timeout, addr.Addr(), addr.Port(), opts.Username, opts.Password)
+		if err != nil {
+			return nil, err
+		}
+		return conn, nil
+	}
+	client, err := helper.NewFTPClient(conn, host, dial, 30*time.Second)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	username := "anonymous"
+	password := "anonymous@"
+	if u.User != nil {
+		username = u.User.Username()
+		if p, ok := u.User.Password(); ok {
+			password = p
+		}
+	}
+	if err := client.Login(username, password); err != nil {
+		client.Quit()
+		return nil, fmt.Errorf("could not log in as %s: %w", username, err)
+	}
+	return client, nil
+}
+
+func ftpGet(opts TransferOpts, u *url.URL, offset int64) error {
+	client, err := ftpConnect(opts, u)
+	if err != nil {
+		return err
+	}
+	defer client.Quit()
+
+	total, err := client.Size(u.Path)
+	if err != nil {
+		opts.Log.Debugf("could not get the size of %s: %v", u.Path, err)
+		total = -1
+	}
+	if offset > 0 && offset == total {
+		opts.Log.Info("the local file is already complete")
+		return nil
+	}
+
+	flags := os.O_CREATE | os.O_WRONLY | os.O_TRUNC
+	if offset > 0 {
+		opts.Log.Infof("resuming download at %s", helper.FormatBytes(offset))
+		flags = os.O_WRONLY | os.O_APPEND
+	}
+	f, err := os.OpenFile(opts.File, flags, 0o644)
+	if err != nil {
+		return fmt.Errorf("could not open local file: %w", err)
+	}
+	defer f.Close()
+
+	r, err := client.Retrieve(u.Path, offset)
+	if err != nil {
+		return fmt.Errorf("could not retrieve %s: %w", u.Path, err)
+	}
+	progress := helper.NewProgress(offset, total)
+	return runTransfer(opts, progress, func() error {
+		_, err := io.Copy(f, progress.Reader(r))
+		if cerr := r.Close(); err == nil {
+			err = cerr
+		}
+		return err
+	})
+}
+
+func ftpPut(opts TransferOpts, u *url.URL, f *os.File, size int64) error {
+	client, err := ftpConnect(opts, u)
+	if err != nil {
+		return err
+	}
+	defer client.Quit()
+
+	var offset int64
+	if opts.Resume {
+		remoteSize, err := client.Size(u.Path)
+		var ftpErr *helper.FTPError
+		switch {
+		case err == nil:
+			offset = remoteSize
+		case errors.As(err, &ftpErr):
+			// the file does not exist yet
+			opts.Log.Debugf("could not get the size of %s: %v", u.Path, err)
+		default:
+			return err
+		}
+	}
+	if offset > size {
+		return fmt.Errorf("remote file is larger than the local file")
+	}
+	if offset == size && offset > 0 {
+		opts.Log.Info("the remote file is already complete")
+		return nil
+	}
+	if offset > 0 {
+		opts.Log.Infof("resuming upload at %s", helper.FormatBytes(offset))
+		if _, err := f.Seek(offset, io.SeekStart); err != nil {
+			return fmt.Errorf("could not seek local file: %w", err)
+		}
+	}
+
+	w, err := client.Store(u.Path, offset > 0)
+	if err != nil {
+		return fmt.Errorf("could not store %s: %w", u.Path, err)
+	}
+	progress := helper.NewProgress(offset, size)
+	return runTransfer(opts, progress, func() error {
+		_, err := io.Copy(w, progress.Reader(f))
+		if cerr := w.Close(); err == nil {
+			err = cerr
+		}
+		return err
+	})
+}
+
+// smbConnect logs in to the SMB server of the url and connects to the
+// share in the first element of the path. Credentials are supplied in the
+// url as domain;user:password, otherwise an anonymous login is done. It
+// returns the path of the file on the share
+func smbConnect(opts TransferOpts, u *url.URL) (*helper.SMBClient, string, error) {
+	port := u.Port()
+	if port == "" {
+		port = "445"
+	}
+	host, err := targetAddr(context.Background(), opts, u.Hostname())
+	if err != nil {
+		return nil, "", err
+	}
+	conn, err := dialTarget(context.Background(), opts, net.JoinHostPort(host.String(), port))
+	if err != nil {
+		return nil, "", err
+	}
+	client, err := helper.NewSMBClient(conn, 30*time.Second)
+	if err != nil {
+		conn.Close()
+		return nil, "", err
+	}
+
+	var domain, username, password string
+	if u.User != nil {
+		username = u.User.Username()
+		password, _ = u.User.Password()
+		if d, user, ok := strings.Cut(username, ";"); ok {
+			domain, username = d, user
+		}
+	}
+	if err := client.Login(domain, username, password); err != nil {
+		client.Logoff()
+		if username == "" {
+			return nil, "", fmt.Errorf("could not log in anonymously: %w", err)
+		}
+		return nil, "", fmt.Errorf("could not log in as %s: %w", username, err)
+	}
+	share, file, _ := strings.Cut(strings.Trim(u.Path, "/"), "/")
+	if err := client.TreeConnect(u.Hostname(), share); err != nil {
+		client.Logoff()
+		return nil, "", err
+	}
+	return client, file, nil
+}
+
+func smbGet(opts TransferOpts, u *url.URL, offset int64) error {
+	client, name, err := smbConnect(opts, u)
+	if err != nil {
+		return err
+	}
+	defer client.Logoff()
+
+	r, err := client.Open(name, false, false)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+	total := r.Size()
+	if offset > 0 && offset == total {
+		opts.Log.Info("the local file is already complete")
+		return nil
+	}
+	if offset > total {
+		opts.Log.Warn("the local file is larger than the remote file, restarting the download")
+		offset = 0
+	}
+
+	flags := os.O_CREATE | os.O_WRONLY | os.O_TRUNC
+	if offset > 0 {
+		opts.Log.Infof("resuming download at %s", helper.FormatBytes(offset))
+		flags = os.O_WRONLY | os.O_APPEND
+		if _, err := r.Seek(offset, io.SeekStart); err != nil {
+			return err
+		}
+	}
+	f, err := os.OpenFile(opts.File, flags, 0o644)
+	if err != nil {
+		return fmt.Errorf("could not open local file: %w", err)
+	}
+	defer f.Close()
+
+	progress := helper.NewProgress(offset, total)
+	return runTransfer(opts, progress, func() error {
+		_, err := io.Copy(f, progress.Reader(r))
+		return err
+	})
+}
+
+func smbPut(opts TransferOpts, u *url.URL, f *os.File, size int64) error {
+	client, name, err := smbConnect(opts, u)
+	if err != nil {
+		return err
+	}
+	defer client.Logoff()
+
+	// the remote file is only kept to resume the upload
+	w, err := client.Open(name, true, opts.Resume)
+	if err != nil {
+		return err
+	}
+	var offset int64
+	if opts.Resume {
+		offset = w.Size()
+	}
+	if offset > size {
+		w.Close()
+		return fmt.Errorf("remote file is larger than the local file")
+	}
+	if offset == size && offset > 0 {
+		w.Close()
+		opts.Log.Info("the remote file is already complete")
+		return nil
+	}
+	if offset > 0 {
+		opts.Log.Infof("resuming upload at %s", helper.FormatBytes(offset))
+		if _, err := f.Seek(offset, io.SeekStart); err != nil {
+			w.Close()
+			return fmt.Errorf("could not seek local file: %w", err)
+		}
+		if _, err := w.Seek(offset, io.SeekStart); err != nil {
+			w.Close()
+			return err
+		}
+	}
+
+	progress := helper.NewProgress(offset, size)
+	return runTransfer(opts, progress, func() error {
+		_, err := io.Copy(w, progress.Reader(f))
+		if cerr := w.Close(); err == nil {
+			err = cerr
+		}
+		return err
+	})
+}
+
+// runTransfer runs the transfer and logs the progress every 2 seconds
+func runTransfer(opts TransferOpts, progress *helper.Progress, transfer func() error) error {
+	done := make(chan struct{})
+	go func() {
+		tick := time.NewTicker(2 * time.Second)
+		defer tick.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-tick.C:
+				opts.Log.Info(progress.String())
+			}
+		}
+	}()
+	err := transfer()
+	close(done)
+	if err != nil {
+		return fmt.Errorf("transfer failed after %s: %w", helper.FormatBytes(progress.Transferred()), err)
+	}
+	opts.Log.Infof("transfer complete: %s", progress.String())
+	return nil
+}
@@ -0,0 +1,683 @@
+package cmd
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math/rand"
+	"net/netip"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/firefart/stunner/internal/helper"
+)
+
+// UDPProbe is a protocol probe of the UDP scanner. Probes are registered
+// with RegisterUDPProbe and selected by name with --probes
+type UDPProbe interface {
+	// Name is used to select the probe and as the phase of --timeouts
+	Name() string
+	// Port is the UDP port the probe is sent to unless other ports are
+	// configured
+	Port() uint16
+	// BuildPayload returns the request sent to the target
+	BuildPayload(opts UDPScannerOpts, target netip.AddrPort) ([]byte, error)
+	// ParseResponse parses the response to request and returns a short
+	// description of the service that is logged
+	ParseResponse(opts UDPScannerOpts, request, response []byte) (string, error)
+}
+
+// UDPProbeRunner is implemented by probes that need more than a single
+// request and response, like bruteforces or services answering with
+// multiple datagrams. Run is called for every port instead of sending the
+// payload
+type UDPProbeRunner interface {
+	UDPProbe
+	Run(opts UDPScannerOpts, target netip.AddrPort) error
+}
+
+// UDPProbeSplitter is implemented by probes whose application protocol
+// reassembles a request sent in several datagrams. Requests above
+// --max-payload are then split into Send indications instead of being
+// reported as too large
+type UDPProbeSplitter interface {
+	Splittable() bool
+}
+
+// UDPProbeDefaultPorts is implemented by probes that are sent to more than
+// one port by default
+type UDPProbeDefaultPorts interface {
+	DefaultPorts() []uint16
+}
+
+type registeredUDPProbe struct {
+	probe UDPProbe
+	// byDefault probes run if no probes are selected
+	byDefault bool
+}
+
+var (
+	udpProbesMu sync.RWMutex
+	// udpProbes are run in the order they are registered
+	udpProbes []registeredUDPProbe
+)
+
+func init() {
+	RegisterUDPProbe(snmpProbe{})
+	RegisterUDPProbe(snmpv3Probe{})
+	RegisterUDPProbe(dnsProbe{})
+	RegisterUDPProbe(ntpProbe{})
+	RegisterUDPProbe(netbiosProbe{})
+	RegisterUDPProbe(ssdpProbe{})
+	RegisterUDPProbe(tftpProbe{})
+	RegisterUDPProbe(sipProbe{})
+	RegisterUDPProbe(ikeProbe{})
+	RegisterUDPProbe(cldapProbe{})
+	RegisterUDPProbe(memcachedProbe{})
+	RegisterUDPProbe(rpcProbe{})
+	RegisterUDPProbe(wsdProbe{})
+	// mdns sends a query for every service type, so it only runs if it is
+	// selected or enabled with --mdns
+	registerUDPProbe(mdnsProbe{}, false)
+}
+
+// RegisterUDPProbe adds a probe to the UDP scanner that runs by default.
+// It panics if a probe with the same name is already registered
+func RegisterUDPProbe(probe UDPProbe) {
+	registerUDPProbe(probe, true)
+}
+
+func registerUDPProbe(probe UDPProbe, byDefault bool) {
+	udpProbesMu.Lock()
+	defer udpProbesMu.Unlock()
+	name := probe.Name()
+	if name == "" || name != strings.ToLower(name) {
+		panic(fmt.Sprintf("invalid udp probe name %q", name))
+	}
+	for _, p := range udpProbes {
+		if p.probe.Name() == name {
+			panic(fmt.Sprintf("udp probe %s is already registered", name))
+		}
+	}
+	udpProbes = append(udpProbes, registeredUDPProbe{probe: probe, byDefault: byDefault})
+}
+
+// UDPProbeNames returns the sorted names of all registered probes
+func UDPProbeNames() []string {
+	udpProbesMu.RLock()
+	defer udpProbesMu.RUnlock()
+	var names []string
+	for _, p := range udpProbes {
+		names = append(names, p.probe.Name())
+	}
+	sort.Strings(names)
+	return names
+}
+
+// selectUDPProbes returns the probes with the given names or the default
+// probes if names is empty. The mdns probe is added if enableMDNS is set.
+// The extra probes of the payload file are selected like default probes
+// and may share a name
+func selectUDPProbes(names []string, enableMDNS bool, extra []UDPProbe) ([]UDPProbe, error) {
+	selected := make(map[string]bool)
+	for _, name := range names {
+		selected[strings.ToLower(strings.TrimSpace(name))] = true
+	}
+	if enableMDNS {
+		selected["mdns"] = true
+	}
+
+	udpProbesMu.RLock()
+	candidates := append([]registeredUDPProbe{}, udpProbes...)
+	udpProbesMu.RUnlock()
+	for _, probe := range extra {
+		candidates = append(candidates, registeredUDPProbe{probe: probe, byDefault: true})
+	}
+
+	var probes []UDPProbe
+	found := make(map[string]bool)
+	for _, p := range candidates {
+		name := p.probe.Name()
+		if selected[name] || (len(names) == 0 && p.byDefault) {
+			probes = append(probes, p.probe)
+			found[name] = true
+		}
+	}
+	for name := range selected {
+		if !found[name] {
+			return nil, fmt.Errorf("invalid probe %q. Supported values: %s", name, strings.Join(UDPProbeNames(), ", "))
+		}
+	}
+	return probes, nil
+}
+
+// UDPProbePorts returns the default ports of the registered probe with
+// the given name
+func UDPProbePorts(name string) []uint16 {
+	udpProbesMu.RLock()
+	defer udpProbesMu.RUnlock()
+	for _, p := range udpProbes {
+		if p.probe.Name() == name {
+			return defaultProbePorts(p.probe)
+		}
+	}
+	return nil
+}
+
+func defaultProbePorts(probe UDPProbe) []uint16 {
+	if p, ok := probe.(UDPProbeDefaultPorts); ok {
+		return p.DefaultPorts()
+	}
+	return []uint16{probe.Port()}
+}
+
+// probePorts returns the configured ports of the probe or its default
+// ports
+func probePorts(opts UDPScannerOpts, probe UDPProbe) []uint16 {
+	// the ports of payloads are set in the payload file
+	if _, ok := probe.(payloadProbe); ok {
+		return []uint16{probe.Port()}
+	}
+	if ports, ok := opts.ProbePorts[probe.Name()]; ok {
+		return ports
+	}
+	return defaultProbePorts(probe)
+}
+
+// runUDPProbe runs the probe against the target
+func runUDPProbe(opts UDPScannerOpts, probe UDPProbe, target netip.AddrPort) error {
+	if runner, ok := probe.(UDPProbeRunner); ok {
+		return runner.Run(opts, target)
+	}
+	return sendUDPProbe(opts, probe, target)
+}
+
+// sendUDPProbe sends the payload of the probe to the target and logs the
+// parsed response. Unanswered probes are not an error
+func sendUDPProbe(opts UDPScannerOpts, probe UDPProbe, target netip.AddrPort) error {
+	request, err := probe.BuildPayload(opts, target)
+	if err != nil {
+		return fmt.Errorf("could not build the %s payload: %w", probe.Name(), err)
+	}
+	splitter, ok := probe.(UDPProbeSplitter)
+	splittable := ok && splitter.Splittable()
+	data, err := exchangeUDPProbe(opts, target, request, splittable, probeTimeout(opts, probe.Name()))
+	if err != nil || data == nil {
+		return err
+	}
+	result, err := probe.ParseResponse(opts, request, data)
+	if err != nil {
+		return fmt.Errorf("invalid %s response from %s: %w", probe.Name(), target, err)
+	}
+	opts.Log.Infof("%s on %s: %s", probe.Name(), target, result)
+	return nil
+}
+
+// exchangeUDPProbe sends the request to the target over the allocation of
+// the host and returns the response. A nil response without an error
+// means the target did not answer. Oversized requests are split if
+// splittable is set
+func exchangeUDPProbe(opts UDPScannerOpts, target netip.AddrPort, request []byte, splittable bool, timeout time.Duration) ([]byte, error) {
+	remote, channelNumber, err := setupChannel(opts, target.Addr(), target.Port())
+	if err != nil {
+		// ignore timeouts
+		if errors.Is(err, helper.ErrTimeout) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	defer remote.Close()
+
+	from, data, err := sendRelayedData(opts, remote, channelNumber, target, request, splittable, timeout)
+	if err != nil {
+		// ignore timeouts
+		if errors.Is(err, helper.ErrTimeout) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("error on request to %s: %w", target, err)
+	}
+	opts.Log.Debugf("received %d bytes from %s", len(data), from)
+	return data, nil
+}
+
+// probeTimeout returns the timeout of the phase named like the probe.
+// Probes without a phase use the global timeout
+func probeTimeout(opts UDPScannerOpts, name string) time.Duration {
+	if timeout, ok := opts.Timeouts.Phase(name); ok && timeout > 0 {
+		return timeout
+	}
+	return opts.Timeout
+}
+
+// snmpProbe sends a get-next request with every community string and
+// tries the communities of the community file against hosts that accepted
+// one of them
+type snmpProbe struct{}
+
+func (snmpProbe) Name() string { return "snmp" }
+func (snmpProbe) Port() uint16 { return 161 }
+
+func (snmpProbe) BuildPayload(opts UDPScannerOpts, _ netip.AddrPort) ([]byte, error) {
+	request, _ := snmpRequest(opts.CommunityStrings[0])
+	return request, nil
+}
+
+func (snmpProbe) ParseResponse(_ UDPScannerOpts, request, response []byte) (string, error) {
+	requestID, err := helper.SNMPRequestID(request)
+	if err != nil {
+		return "", err
+	}
+	msg, err := helper.ParseSNMPResponse(response, requestID)
+	if err != nil {
+		return "", err
+	}
+	return msg.String(), nil
+}
+
+func (snmpProbe) Run(opts UDPScannerOpts, target netip.AddrPort) error {
+	found, err := snmpBruteforce(opts, target.Addr(), target.Port(), opts.CommunityStrings)
+	if err != nil {
+		return err
+	}
+	// only bruteforce hosts that speak SNMP at all
+	if len(found) > 0 && len(opts.communities) > 0 {
+		_, err := snmpBruteforce(opts, target.Addr(), target.Port(), opts.communities)
+		return err
+	}
+	return nil
+}
+
+// snmpv3Probe discovers the engine of SNMPv3 agents, which works without
+// knowing a user or community
+type snmpv3Probe struct{}
+
+func (snmpv3Probe) Name() string { return "snmpv3" }
+func (snmpv3Probe) Port() uint16 { return 161 }
+
+func (snmpv3Probe) BuildPayload(_ UDPScannerOpts, _ netip.AddrPort) ([]byte, error) {
+	return helper.SNMPv3Discovery(rand.Int31()), nil
+}
+
+func (snmpv3Probe) ParseResponse(_ UDPScannerOpts, request, response []byte) (string, error) {
+	msgID, err := helper.SNMPv3MessageID(request)
+	if err != nil {
+		return "", err
+	}
+	engine, err := helper.ParseSNMPv3Report(response, msgID)
+	if err != nil {
+		return "", err
+	}
+	return engine.String(), nil
+}
+
+// dnsProbe resolves the domain name of the options and records the
+// servers that answered for the PTR sweep
+type dnsProbe struct{}
+
+func (dnsProbe) Name() string { return "dns" }
+func (dnsProbe) Port() uint16 { return 53 }
+
+func (dnsProbe) BuildPayload(opts UDPScannerOpts, _ netip.AddrPort) ([]byte, error) {
+	return helper.DNSQuery(opts.DomainName, helper.DNSTypeA), nil
+}
+
+func (dnsProbe) ParseResponse(_ UDPScannerOpts, _, response []byte) (string, error) {
+	msg, err := helper.ParseDNSMessage(response)
+	if err != nil {
+		return "", err
+	}
+	return dnsResponseString(msg), nil
+}
+
+func (dnsProbe) Run(opts UDPScannerOpts, target netip.AddrPort) error {
+	_, err := dnsScan(opts, target.Addr(), target.Port(), opts.DomainName)
+	return err
+}
+
+// ntpProbe sends a NTP client request
+type ntpProbe struct{}
+
+func (ntpProbe) Name() string { return "ntp" }
+func (ntpProbe) Port() uint16 { return 123 }
+
+func (ntpProbe) BuildPayload(_ UDPScannerOpts, _ netip.AddrPort) ([]byte, error) {
+	return helper.NTPRequest(), nil
+}
+
+func (ntpProbe) ParseResponse(_ UDPScannerOpts, request, response []byte) (string, error) {
+	ntp, err := helper.ParseNTPResponse(response, request)
+	if err != nil {
+		return "", err
+	}
+	return ntp.String(), nil
+}
+
+// netbiosProbe sends a NetBIOS node status request for the name table
+type netbiosProbe struct{}
+
+func (netbiosProbe) Name() string { return "netbios" }
+func (netbiosProbe) Port() uint16 { return 137 }
+
+func (netbiosProbe) BuildPayload(_ UDPScannerOpts, _ netip.AddrPort) ([]byte, error) {
+	return helper.NBSTATQuery(), nil
+}
+
+func (netbiosProbe) ParseResponse(_ UDPScannerOpts, _, response []byte) (string, error) {
+	nbstat, err := helper.ParseNBSTATResponse(response)
+	if err != nil {
+		return "", err
+	}
+	var names []string
+	for _, name := range nbstat.Names {
+		names = append(names, name.String())
+	}
+	return fmt.Sprintf("name %s, domain %s, MAC %s, names: %s", nbstat.Hostname(), nbstat.Domain(), nbstat.MAC, strings.Join(names, ", ")), nil
+}
+
+// ssdpProbe searches for all UPnP devices and services
+type ssdpProbe struct{}
+
+func (ssdpProbe) Name() string { return "ssdp" }
+func (ssdpProbe) Port() uint16 { return 1900 }
+
+func (ssdpProbe) BuildPayload(_ UDPScannerOpts, target netip.AddrPort) ([]byte, error) {
+	return helper.SSDPSearch(target.String(), "ssdp:all"), nil
+}
+
+func (ssdpProbe) ParseResponse(_ UDPScannerOpts, _, response []byte) (string, error) {
+	ssdp, err := helper.ParseSSDPResponse(response)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("server %s, location %s", ssdp.Server, ssdp.Location), nil
+}
+
+func (ssdpProbe) Run(opts UDPScannerOpts, target netip.AddrPort) error {
+	_, err := ssdpScan(opts, target.Addr(), target.Port())
+	return err
+}
+
+// tftpProbe requests the files of the options from TFTP servers
+type tftpProbe struct{}
+
+func (tftpProbe) Name() string { return "tftp" }
+func (tftpProbe) Port() uint16 { return 69 }
+
+func (tftpProbe) BuildPayload(opts UDPScannerOpts, _ netip.AddrPort) ([]byte, error) {
+	if len(opts.TFTPFiles) == 0 {
+		return helper.TFTPReadRequest(DefaultTFTPFiles[0]), nil
+	}
+	return helper.TFTPReadRequest(opts.TFTPFiles[0]), nil
+}
+
+func (tftpProbe) ParseResponse(_ UDPScannerOpts, _, response []byte) (string, error) {
+	packet, err := helper.ParseTFTPPacket(response)
+	if err != nil {
+		return "", err
+	}
+	if packet.Opcode == helper.TFTPOpError {
+		return fmt.Sprintf("error %d %s", packet.ErrorCode, packet.ErrorMessage), nil
+	}
+	return fmt.Sprintf("received %d bytes of block %d", len(packet.Data), packet.Block), nil
+}
+
+func (tftpProbe) Run(opts UDPScannerOpts, target netip.AddrPort) error {
+	_, err := tftpScan(opts, target.Addr(), target.Port())
+	return err
+}
+
+// sipProbe sends a SIP OPTIONS request
+type sipProbe struct{}
+
+func (sipProbe) Name() string { return "sip" }
+func (sipProbe) Port() uint16 { return 5060 }
+
+func (sipProbe) BuildPayload(_ UDPScannerOpts, target netip.AddrPort) ([]byte, error) {
+	return helper.SIPOptions(target), nil
+}
+
+func (sipProbe) ParseResponse(_ UDPScannerOpts, _, response []byte) (string, error) {
+	sip, err := helper.ParseSIPResponse(response)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%d %s, product %q, allow %s", sip.StatusCode, sip.Reason, sip.Product(), sip.Allow), nil
+}
+
+// ikeProbe sends an IKEv1 main mode request to port 500 and 4500
+type ikeProbe struct{}
+
+// ikeNATTPort is the NAT traversal port of IKE
+const ikeNATTPort = 4500
+
+func (ikeProbe) Name() string { return "ike" }
+func (ikeProbe) Port() uint16 { return 500 }
+
+// DefaultPorts includes 4500, which is used by gateways behind NAT and is
+// the only open port of some IKEv2 only gateways
+func (p ikeProbe) DefaultPorts() []uint16 { return []uint16{p.Port(), ikeNATTPort} }
+
+func (ikeProbe) BuildPayload(_ UDPScannerOpts, _ netip.AddrPort) ([]byte, error) {
+	request, _ := helper.IKEMainMode()
+	return request, nil
+}
+
+func (ikeProbe) ParseResponse(_ UDPScannerOpts, request, response []byte) (string, error) {
+	if len(request) < 8 {
+		return "", fmt.Errorf("invalid request")
+	}
+	ike, err := helper.ParseIKEResponse(response, request[:8])
+	if err != nil {
+		return "", err
+	}
+	return ike.String(), nil
+}
+
+// Run prefixes the request to the NAT traversal port with the non-ESP
+// marker
+func (p ikeProbe) Run(opts UDPScannerOpts, target netip.AddrPort) error {
+	if target.Port() == ikeNATTPort {
+		return sendUDPProbe(opts, ikeNATTProbe{p}, target)
+	}
+	return sendUDPProbe(opts, p, target)
+}
+
+// ikeNATTProbe prefixes the IKE messages with the non-ESP marker of the
+// NAT traversal port
+type ikeNATTProbe struct {
+	ikeProbe
+}
+
+func (ikeNATTProbe) Port() uint16 { return ikeNATTPort }
+
+func (p ikeNATTProbe) BuildPayload(opts UDPScannerOpts, target netip.AddrPort) ([]byte, error) {
+	request, err := p.ikeProbe.BuildPayload(opts, target)
+	if err != nil {
+		return nil, err
+	}
+	return append(append([]byte{}, helper.IKENonESPMarker...), request...), nil
+}
+
+func (p ikeNATTProbe) ParseResponse(opts UDPScannerOpts, request, response []byte) (string, error) {
+	request = bytes.TrimPrefix(request, helper.IKENonESPMarker)
+	response = bytes.TrimPrefix(response, helper.IKENonESPMarker)
+	return p.ikeProbe.ParseResponse(opts, request, response)
+}
+
+// cldapProbe searches the rootDSE of domain controllers
+type cldapProbe struct{}
+
+func (cldapProbe) Name() string { return "cldap" }
+func (cldapProbe) Port() uint16 { return 389 }
+
+func (cldapProbe) BuildPayload(_ UDPScannerOpts, _ netip.AddrPort) ([]byte, error) {
+	return helper.CLDAPRootDSE(int64(rand.Int31())), nil
+}
+
+func (cldapProbe) ParseResponse(_ UDPScannerOpts, request, response []byte) (string, error) {
+	messageID, err := helper.LDAPMessageID(request)
+	if err != nil {
+		return "", err
+	}
+	dse, err := helper.ParseCLDAPResponse(response, messageID)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("domain controller %s, domain %s (%s), forest %s", dse.DNSHostName, dse.Domain(), dse.DefaultNamingContext, dse.Forest()), nil
+}
+
+// memcachedProbe sends a stats command
+type memcachedProbe struct{}
+
+func (memcachedProbe) Name() string { return "memcached" }
+func (memcachedProbe) Port() uint16 { return 11211 }
+
+func (memcachedProbe) BuildPayload(_ UDPScannerOpts, _ netip.AddrPort) ([]byte, error) {
+	return helper.MemcachedStatsRequest(uint16(rand.Uint32())), nil
+}
+
+func (memcachedProbe) ParseResponse(_ UDPScannerOpts, request, response []byte) (string, error) {
+	if len(request) < 2 {
+		return "", fmt.Errorf("invalid request")
+	}
+	_, _, payload, err := helper.ParseMemcachedFrame(response, binary.BigEndian.Uint16(request[0:2]))
+	if err != nil {
+		return "", err
+	}
+	stats, err := helper.ParseMemcachedStats(payload)
+	if err != nil {
+		return "", err
+	}
+	return stats.String(), nil
+}
+
+func (memcachedProbe) Run(opts UDPScannerOpts, target netip.AddrPort) error {
+	_, err := memcachedScan(opts, target.Addr(), target.Port())
+	return err
+}
+
+// rpcProbe dumps the programs registered at the portmapper
+type rpcProbe struct{}
+
+func (rpcProbe) Name() string { return "rpc" }
+func (rpcProbe) Port() uint16 { return 111 }
+
+func (rpcProbe) BuildPayload(_ UDPScannerOpts, _ netip.AddrPort) ([]byte, error) {
+	return helper.RPCPortmapDump(rand.Uint32()), nil
+}
+
+func (rpcProbe) ParseResponse(_ UDPScannerOpts, request, response []byte) (string, error) {
+	if len(request) < 4 {
+		return "", fmt.Errorf("invalid request")
+	}
+	mappings, err := helper.ParseRPCPortmapDump(response, binary.BigEndian.Uint32(request[0:4]))
+	if err != nil {
+		return "", err
+	}
+	var programs []string
+	for _, m := range mappings {
+		programs = append(programs, m.String())
+	}
+	return fmt.Sprintf("portmapper with %d programs: %s", len(mappings), strings.Join(programs, ", ")), nil
+}
+
+// wsdProbe sends a WS-Discovery probe for all device types
+type wsdProbe struct{}
+
+func (wsdProbe) Name() string { return "wsd" }
+func (wsdProbe) Port() uint16 { return 3702 }
+
+func (wsdProbe) BuildPayload(_ UDPScannerOpts, _ netip.AddrPort) ([]byte, error) {
+	msg, _ := helper.WSDProbe()
+	return msg, nil
+}
+
+func (wsdProbe) ParseResponse(_ UDPScannerOpts, request, response []byte) (string, error) {
+	messageID, err := helper.WSDMessageID(request)
+	if err != nil {
+		return "", err
+	}
+	matches, err := helper.ParseWSDProbeMatches(response, messageID)
+	if err != nil {
+		return "", err
+	}
+	var devices []string
+	for _, m := range matches {
+		devices = append(devices, m.String())
+	}
+	return strings.Join(devices, "; "), nil
+}
+
+// mdnsProbe discovers the Bonjour services of a host
+type mdnsProbe struct{}
+
+func (mdnsProbe) Name() string { return "mdns" }
+func (mdnsProbe) Port() uint16 { return 5353 }
+
+func (mdnsProbe) BuildPayload(_ UDPScannerOpts, _ netip.AddrPort) ([]byte, error) {
+	return helper.LinkLocalDNSQuery(mdnsServicesName, helper.DNSTypePTR), nil
+}
+
+func (mdnsProbe) ParseResponse(_ UDPScannerOpts, _, response []byte) (string, error) {
+	msg, err := helper.ParseDNSMessage(response)
+	if err != nil {
+		return "", err
+	}
+	var serviceTypes []string
+	for _, answer := range msg.Answers {
+		if answer.Type == helper.DNSTypePTR {
+			serviceTypes = append(serviceTypes, answer.Data)
+		}
+	}
+	return fmt.Sprintf("service types %s", strings.Join(serviceTypes, ", ")), nil
+}
+
+func (mdnsProbe) Run(opts UDPScannerOpts, target netip.AddrPort) error {
+	_, err := mdnsScan(opts, target.Addr(), target.Port())
+	return err
+}
+
+// payloadProbe sends a raw payload of the payload file to one of its ports
+type payloadProbe struct {
+	payload helper.UDPPayload
+	port    uint16
+}
+
+// maxPayloadPreview is the number of bytes of a response that are logged
+const maxPayloadPreview = 64
+
+func (p payloadProbe) Name() string { return p.payload.Name }
+func (p payloadProbe) Port() uint16 { return p.port }
+
+// Splittable is set for payloads marked with split in the payload file
+func (p payloadProbe) Splittable() bool { return p.payload.Split }
+
+func (p payloadProbe) BuildPayload(_ UDPScannerOpts, _ netip.AddrPort) ([]byte, error) {
+	return p.payload.Data, nil
+}
+
+func (payloadProbe) ParseResponse(_ UDPScannerOpts, _, response []byte) (string, error) {
+	preview := response
+	if len(preview) > maxPayloadPreview {
+		preview = preview[:maxPayloadPreview]
+	}
+	result := fmt.Sprintf("received %d bytes %q", len(response), preview)
+	if service, product := helper.FingerprintBanner(response); service != "" {
+		result += fmt.Sprintf(", service %s %s", service, product)
+	}
+	return result, nil
+}
+
+// payloadProbes returns a probe for every port of every payload
+func payloadProbes(payloads []helper.UDPPayload) []UDPProbe {
+	var probes []UDPProbe
+	for _, payload := range payloads {
+		for _, port := range payload.Ports {
+			probes = append(probes, payloadProbe{payload: payload, port: port})
+		}
+	}
+	return probes
+}
@@ -1,11 +1,16 @@
 package cmd
 
 import (
+	"bytes"
 	"errors"
 	"fmt"
 	"math/rand"
+	"net"
 	"net/netip"
+	"os"
+	"path/filepath"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/firefart/stunner/internal"
@@ -14,19 +19,81 @@ import (
 )
 
 type UDPScannerOpts struct {
-	TurnServer      string
-	Protocol        string
-	Username        string
-	Password        string
-	UseTLS          bool
-	TlsVerify       bool
-	Timeout         time.Duration
-	Log             *logrus.Logger
-	CommunityString string
-	DomainName      string
-	IPs             []string
+	TurnServer string
+	Protocol   string
+	Username   string
+	Password   string
+	UseTLS     bool
+	TlsVerify  bool
+	Timeout    time.Duration
+	Log        *logrus.Logger
+	// CommunityStrings are tried against every host. The communities of
+	// CommunityFile are only tried against hosts that accepted one of them
+	CommunityStrings []string
+	CommunityFile    string
+	DomainName       string
+	IPs              []string
+	// Loopback adds the IPv6 loopback address to the scanned ranges
+	Loopback bool
+	Delay    time.Duration
+	Retries  int
+	// MaxPayload is the largest payload the relay forwards without
+	// fragmentation as found by the mtu-sweep command. 0 disables the check
+	MaxPayload int
+	// LogLimit is the number of similar errors logged before they are
+	// suppressed. 0 logs all errors
+	LogLimit int
+	// ControlListen is the address of the pause and resume control API.
+	// The API is disabled if empty
+	ControlListen string
+	// ControlSDDL is the security descriptor of the named pipe of the
+	// control API on windows. Only the current user, SYSTEM and the
+	// administrators have access if empty
+	ControlSDDL string
+	// QuietHours pause the scan automatically
+	QuietHours helper.QuietHours
+	// Timeouts override Timeout for single phases of the scan
+	Timeouts helper.Timeouts
+	// SkipHealthCheck disables the check of the relay before the scan
+	SkipHealthCheck bool
+	// EnableMDNS queries every host for its Bonjour services
+	EnableMDNS bool
+	// TFTPFiles are requested from TFTP servers. DefaultTFTPFiles are used
+	// if empty
+	TFTPFiles []string
+	// TFTPDownload is the directory the first data block of every file
+	// found on a TFTP server is written to. Nothing is written if empty
+	TFTPDownload string
+	// Probes are the names of the probes to run. All probes enabled by
+	// default are run if empty
+	Probes []string
+	// ProbePorts overrides the ports of the probes by name
+	ProbePorts map[string][]uint16
+	// PTRSweep looks up the PTR record of every scanned IP on the DNS
+	// servers found during the scan
+	PTRSweep bool
+	// PayloadFile contains raw payloads sent to the ports of every host in
+	// the format of helper.ParseUDPPayloads. The payloads are probes named
+	// like the payload
+	PayloadFile string
+	// Workers is the number of hosts scanned in parallel
+	Workers int
+	// Rate is the maximum number of probes per second across all workers.
+	// 0 disables the limit
+	Rate float64
+
+	// communities are read from CommunityFile
+	communities []string
+	// allocation is shared by the probes to the host currently scanned
+	allocation *udpAllocation
+	// resolvers collects the DNS servers found for the PTR sweep
+	resolvers *dnsResolvers
 }
 
+// DefaultTFTPFiles are config files of network devices often served by
+// TFTP for autoinstall and backups
+var DefaultTFTPFiles = []string{"startup-config", "running-config", "network-confg"}
+
 func (opts UDPScannerOpts) Validate() error {
 	if opts.TurnServer == "" {
 		return fmt.Errorf("need a valid turnserver")
@@ -46,13 +113,41 @@ func (opts UDPScannerOpts) Validate() error {
 	if opts.Log == nil {
 		return fmt.Errorf("please supply a valid logger")
 	}
-	if opts.CommunityString == "" {
+	if len(opts.CommunityStrings) == 0 {
 		return fmt.Errorf("please supply a valid community string")
 	}
+	for _, community := range opts.CommunityStrings {
+		if community == "" {
+			return fmt.Errorf("please supply a valid community string")
+		}
+	}
 	if opts.DomainName == "" {
 		return fmt.Errorf("please supply a valid domain name")
 	}
-	// no need to check IPs, it can be nil
+	if opts.MaxPayload < 0 {
+		return fmt.Errorf("max payload can not be negative")
+	}
+	if opts.Retries < 0 {
+		return fmt.Errorf("retries can not be negative")
+	}
+	if opts.LogLimit < 0 {
+		return fmt.Errorf("log limit can not be negative")
+	}
+	if opts.Workers < 1 {
+		return fmt.Errorf("please supply a valid number of workers")
+	}
+	if opts.Rate < 0 {
+		return fmt.Errorf("rate can not be negative")
+	}
+	for name, ports := range opts.ProbePorts {
+		if UDPProbePorts(name) == nil {
+			return fmt.Errorf("ports for invalid probe %q", name)
+		}
+		if len(ports) == 0 {
+			return fmt.Errorf("please supply the ports of the %s probe", name)
+		}
+	}
+	// no need to check IPs and TFTPFiles, they can be nil
 
 	return nil
 }
@@ -61,200 +156,795 @@ func UDPScanner(opts UDPScannerOpts) error {
 	if err := opts.Validate(); err != nil {
 		return err
 	}
+	opts.Timeouts = opts.Timeouts.WithDefault(opts.Timeout)
+	if len(opts.TFTPFiles) == 0 {
+		opts.TFTPFiles = DefaultTFTPFiles
+	}
+	if opts.TFTPDownload != "" {
+		if err := os.MkdirAll(opts.TFTPDownload, 0o700); err != nil {
+			return fmt.Errorf("could not create download directory: %w", err)
+		}
+	}
+
+	var payloads []helper.UDPPayload
+	if opts.PayloadFile != "" {
+		var err error
+		payloads, err = helper.ReadUDPPayloads(opts.PayloadFile)
+		if err != nil {
+			return fmt.Errorf("could not read payload file: %w", err)
+		}
+	}
+	probes, err := selectUDPProbes(opts.Probes, opts.EnableMDNS, payloadProbes(payloads))
+	if err != nil {
+		return err
+	}
 
-	ipInput := opts.IPs
-	if len(ipInput) == 0 {
-		ipInput = helper.PrivateRanges
+	ipInput := helper.ScanRanges(opts.IPs, opts.Loopback)
+
+	if !opts.SkipHealthCheck {
+		target, err := firstTarget(ipInput)
+		if err != nil {
+			return fmt.Errorf("invalid ip range: %w", err)
+		}
+		if err := healthCheck(opts.Log, opts.Protocol, opts.TurnServer, opts.UseTLS, opts.TlsVerify, opts.Timeouts.Setup, target, 161, opts.Username, opts.Password); err != nil {
+			return err
+		}
 	}
 
+	if opts.CommunityFile != "" {
+		communities, err := helper.ReadWordlist(opts.CommunityFile)
+		if err != nil {
+			return fmt.Errorf("could not read community file: %w", err)
+		}
+		// the community strings were already tried
+		tried := make(map[string]bool)
+		for _, community := range opts.CommunityStrings {
+			tried[community] = true
+		}
+		for _, community := range communities {
+			if !tried[community] {
+				opts.communities = append(opts.communities, community)
+			}
+		}
+	}
+
+	sampler := helper.NewLogSampler(opts.Log, opts.LogLimit)
+	defer sampler.Flush()
+
+	pauser, stopPause, err := startPauseControl(opts.Log, "scan", opts.ControlListen, opts.ControlSDDL, opts.QuietHours, nil)
+	if err != nil {
+		return err
+	}
+	defer stopPause()
+
+	if opts.PTRSweep {
+		opts.resolvers = &dnsResolvers{}
+	}
+
+	limiter := helper.NewRateLimiter(opts.Rate)
 	ipChan := helper.IPIterator(ipInput)
 
-	for ip := range ipChan {
-		if ip.Error != nil {
-			opts.Log.Error(ip.Error)
+	var wg sync.WaitGroup
+	for i := 0; i < opts.Workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for ip := range ipChan {
+				if ip.Error != nil {
+					opts.Log.Error(ip.Error)
+					continue
+				}
+				pauser.Wait()
+				udpScanHost(opts, probes, sampler, limiter, ip.IP)
+				time.Sleep(opts.Delay)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if opts.PTRSweep {
+		ptrSweep(opts, ipInput, pauser, limiter)
+	}
+
+	return nil
+}
+
+// udpScanHost runs all probes against the host. The probes share a single
+// allocation that is closed afterwards
+func udpScanHost(opts UDPScannerOpts, probes []UDPProbe, sampler *helper.LogSampler, limiter *helper.RateLimiter, ip netip.Addr) {
+	opts.Log.Debugf("Scanning %s", ip.String())
+	opts.allocation = &udpAllocation{ip: ip}
+	defer opts.allocation.Close()
+
+	for _, probe := range probes {
+		for _, port := range probePorts(opts, probe) {
+			limiter.Wait()
+			target := netip.AddrPortFrom(ip, port)
+			if err := runUDPProbe(opts, probe, target); err != nil {
+				sampler.Errorf("error on running %s probe for %s: %v", probe.Name(), target.String(), err)
+			}
+		}
+	}
+}
+
+// snmpRequest builds a SNMP v2c GetRequest for sysDescr and sysName and
+// returns it with its request ID
+func snmpRequest(community string) ([]byte, int32) {
+	requestID := rand.Int31()
+	return helper.SNMPGetRequest(community, requestID, helper.SNMPSysDescr, helper.SNMPSysName), requestID
+}
+
+// sendChannelData sends the payload on the channel and returns the payload
+// of the response on the channel
+func sendChannelData(opts UDPScannerOpts, remote net.Conn, channelNumber []byte, payload []byte, timeout time.Duration) ([]byte, error) {
+	// the messages of the probes can not be split across multiple datagrams
+	messages, err := relayMessages(opts, channelNumber, netip.AddrPort{}, payload, false)
+	if err != nil {
+		return nil, err
+	}
+	var data []byte
+	err = exchangeMessages(opts, remote, messages, timeout, func() error {
+		var err error
+		data, err = readChannelResponse(opts, remote, channelNumber, timeout)
+		return err
+	})
+	return data, err
+}
+
+// sendRelayedData sends the payload to the target and returns the sender
+// and the payload of the response. Unlike sendChannelData responses from
+// other ports of the target arriving as data indications are returned.
+// Payloads above opts.MaxPayload are split into Send indications if
+// splittable is set
+func sendRelayedData(opts UDPScannerOpts, remote net.Conn, channelNumber []byte, target netip.AddrPort, payload []byte, splittable bool, timeout time.Duration) (netip.AddrPort, []byte, error) {
+	messages, err := relayMessages(opts, channelNumber, target, payload, splittable)
+	if err != nil {
+		return netip.AddrPort{}, nil, err
+	}
+	var from netip.AddrPort
+	var data []byte
+	err = exchangeMessages(opts, remote, messages, timeout, func() error {
+		var err error
+		from, data, err = readRelayedResponse(opts, remote, channelNumber, target, timeout)
+		return err
+	})
+	return from, data, err
+}
+
+// relayMessages returns the messages carrying the payload to the target.
+// A payload that fits into opts.MaxPayload is sent as ChannelData. Larger
+// payloads are split into Send indications of at most opts.MaxPayload bytes
+// if the application protocol reassembles them, otherwise they can not
+// traverse the relay
+func relayMessages(opts UDPScannerOpts, channelNumber []byte, target netip.AddrPort, payload []byte, splittable bool) ([][]byte, error) {
+	chunks, err := internal.SplitPayload(payload, opts.MaxPayload, splittable)
+	if err != nil {
+		return nil, fmt.Errorf("probe can not traverse the relay: %w", err)
+	}
+	if len(chunks) == 1 {
+		// ChannelData over TCP needs to be padded to a multiple of 4 bytes
+		buf, err := internal.ChannelData(channelNumber, payload, opts.Protocol == "tcp")
+		if err != nil {
+			return nil, err
+		}
+		return [][]byte{buf}, nil
+	}
+
+	opts.Log.Debugf("splitting the %d bytes for %s into %d send indications", len(payload), target, len(chunks))
+	messages := make([][]byte, 0, len(chunks))
+	for _, chunk := range chunks {
+		indication, err := internal.SendIndication(target.Addr(), target.Port(), chunk, false)
+		if err != nil {
+			return nil, err
+		}
+		buf, err := indication.Serialize()
+		if err != nil {
+			return nil, err
+		}
+		messages = append(messages, buf)
+	}
+	return messages, nil
+}
+
+// exchangeMessages sends the messages and waits for the response with
+// read. Unanswered requests are resent up to opts.Retries times
+func exchangeMessages(opts UDPScannerOpts, remote net.Conn, messages [][]byte, timeout time.Duration, read func() error) error {
+	for attempt := 0; ; attempt++ {
+		for _, buf := range messages {
+			if err := helper.ConnectionWrite(remote, buf, timeout); err != nil {
+				return fmt.Errorf("error on sending data: %w", err)
+			}
+		}
+
+		err := read()
+		if err == nil {
+			return nil
+		}
+		if !errors.Is(err, helper.ErrTimeout) || attempt >= opts.Retries {
+			return fmt.Errorf("error on reading response: %w", err)
+		}
+		opts.Log.Debugf("no response from %s, retrying (%d/%d)", remote.RemoteAddr().String(), attempt+1, opts.Retries)
+	}
+}
+
+// readChannelResponse returns the payload of the next message on the
+// channel. Data on other channels of a shared allocation is a late
+// response to an earlier probe and skipped
+func readChannelResponse(opts UDPScannerOpts, remote net.Conn, channelNumber []byte, timeout time.Duration) ([]byte, error) {
+	if err := remote.SetReadDeadline(time.Now().Add(timeout)); err != nil {
+		return nil, fmt.Errorf("could not set read deadline: %w", err)
+	}
+	for {
+		channel, data, err := internal.ReadChannelData(remote, opts.Protocol == "tcp")
+		if err != nil {
+			return nil, channelReadError(err)
+		}
+		if !bytes.Equal(channel, channelNumber) {
+			opts.Log.Debugf("skipping %d bytes of late data on channel %02x", len(data), channel)
 			continue
 		}
-		opts.Log.Debugf("Scanning %s", ip.IP.String())
-		if err := snmpScan(opts, ip.IP, 161, opts.CommunityString); err != nil {
-			opts.Log.Errorf("error on running SNMP Scan for ip %s: %v", ip.IP.String(), err)
+		return data, nil
+	}
+}
+
+// readRelayedResponse returns the sender and the payload of the next
+// message on the channel or data indication
+func readRelayedResponse(opts UDPScannerOpts, remote net.Conn, channelNumber []byte, target netip.AddrPort, timeout time.Duration) (netip.AddrPort, []byte, error) {
+	deadline := time.Now().Add(timeout)
+	for {
+		resp, err := internal.ReadMessage(remote, time.Until(deadline))
+		if err != nil {
+			return netip.AddrPort{}, nil, err
+		}
+		if internal.IsChannelData(resp) && !bytes.Equal(resp[:2], channelNumber) {
+			opts.Log.Debugf("skipping %d bytes of late data on channel %02x", len(resp), resp[:2])
+			continue
 		}
-		if err := dnsScan(opts, ip.IP, 53, opts.DomainName); err != nil {
-			opts.Log.Errorf("error on running DNS Scan for ip %s: %v", ip.IP.String(), err)
+		return relayedData(resp, target, opts.Protocol == "tcp")
+	}
+}
+
+// channelReadError maps timeouts of reads with a deadline to
+// helper.ErrTimeout
+func channelReadError(err error) error {
+	if netErr, ok := err.(net.Error); ok && netErr.Timeout() {
+		return helper.ErrTimeout
+	}
+	return err
+}
+
+// setupChannel allocates a relay and binds a channel to the target. If
+// the host has a shared allocation the channel is bound on it and the
+// returned connection is only closed with the allocation
+func setupChannel(opts UDPScannerOpts, ip netip.Addr, port uint16) (net.Conn, []byte, error) {
+	if opts.allocation != nil && opts.allocation.ip == ip {
+		channelNumber, err := opts.allocation.channel(opts, port)
+		if err != nil {
+			return nil, nil, err
 		}
+		return sharedConn{opts.allocation.remote}, channelNumber, nil
 	}
 
+	remote, realm, nonce, err := internal.SetupTurnConnection(opts.Log, opts.Protocol, opts.TurnServer, opts.UseTLS, opts.TlsVerify, opts.Timeouts.Setup, ip, port, opts.Username, opts.Password)
+	if err != nil {
+		return nil, nil, err
+	}
+	channelNumber := helper.RandomChannelNumber()
+	if err := bindChannel(opts, remote, realm, nonce, ip, port, channelNumber); err != nil {
+		remote.Close()
+		return nil, nil, err
+	}
+	return remote, channelNumber, nil
+}
+
+// bindChannel binds the channel to the target. The ChannelBind has its own
+// timeout as some servers are slow to answer it
+func bindChannel(opts UDPScannerOpts, remote net.Conn, realm, nonce string, ip netip.Addr, port uint16, channelNumber []byte) error {
+	channelBindRequest, err := internal.ChannelBindRequest(opts.Username, opts.Password, nonce, realm, ip, port, channelNumber)
+	if err != nil {
+		return fmt.Errorf("error on generating ChannelBindRequest: %w", err)
+	}
+	channelBindResponse, err := channelBindRequest.SendAndReceive(opts.Log, remote, opts.Timeouts.ChannelBind)
+	if err != nil {
+		return fmt.Errorf("error on sending ChannelBindRequest: %w", err)
+	}
+	if channelBindResponse.Header.MessageType.Class == internal.MsgTypeClassError {
+		return fmt.Errorf("error on ChannelBind: %w", channelBindResponse.GetError())
+	}
 	return nil
 }
 
-func snmpScan(opts UDPScannerOpts, ip netip.Addr, port uint16, community string) error {
-	remote, realm, nonce, err := internal.SetupTurnConnection(opts.Log, opts.Protocol, opts.TurnServer, opts.UseTLS, opts.TlsVerify, opts.Timeout, ip, port, opts.Username, opts.Password)
+// udpAllocation is shared by all probes to a host, so scanning a host
+// needs a single allocation instead of one per probe. Every port of the
+// host gets its own channel on it
+type udpAllocation struct {
+	ip       netip.Addr
+	remote   net.Conn
+	realm    string
+	nonce    string
+	channels map[uint16][]byte
+}
+
+// channel returns the channel bound to the port. The allocation is set up
+// on first use and dropped if a ChannelBind fails, so a late response to
+// it does not end up in the next probe
+func (a *udpAllocation) channel(opts UDPScannerOpts, port uint16) ([]byte, error) {
+	if channelNumber, ok := a.channels[port]; ok {
+		return channelNumber, nil
+	}
+	if a.remote == nil {
+		remote, realm, nonce, err := internal.SetupTurnConnection(opts.Log, opts.Protocol, opts.TurnServer, opts.UseTLS, opts.TlsVerify, opts.Timeouts.Setup, a.ip, port, opts.Username, opts.Password)
+		if err != nil {
+			return nil, err
+		}
+		a.remote, a.realm, a.nonce = remote, realm, nonce
+		a.channels = make(map[uint16][]byte)
+	}
+
+	channelNumber := helper.RandomChannelNumber()
+	for a.bound(channelNumber) {
+		channelNumber = helper.RandomChannelNumber()
+	}
+	if err := bindChannel(opts, a.remote, a.realm, a.nonce, a.ip, port, channelNumber); err != nil {
+		a.Close()
+		return nil, err
+	}
+	a.channels[port] = channelNumber
+	return channelNumber, nil
+}
+
+// bound returns true if the channel number is already bound to a port
+func (a *udpAllocation) bound(channelNumber []byte) bool {
+	for _, c := range a.channels {
+		if bytes.Equal(c, channelNumber) {
+			return true
+		}
+	}
+	return false
+}
+
+// Close releases the allocation
+func (a *udpAllocation) Close() error {
+	if a.remote == nil {
+		return nil
+	}
+	err := a.remote.Close()
+	a.remote = nil
+	a.channels = nil
+	return err
+}
+
+// sharedConn is the connection of a shared allocation handed to a probe.
+// Probes close their connection when they are done, which must not close
+// the allocation
+type sharedConn struct {
+	net.Conn
+}
+
+func (sharedConn) Close() error {
+	return nil
+}
+
+// sendSNMP sends a single SNMP request on the channel and returns the parsed response.
+// A nil response without an error means the request timed out. Responses
+// that are no answer to the request are ignored like timeouts
+func sendSNMP(opts UDPScannerOpts, remote net.Conn, channelNumber []byte, community string) (*helper.SNMPResponse, error) {
+	request, requestID := snmpRequest(community)
+	data, err := sendChannelData(opts, remote, channelNumber, request, opts.Timeouts.SNMP)
 	if err != nil {
 		// ignore timeouts
 		if errors.Is(err, helper.ErrTimeout) {
-			return nil
+			return nil, nil
 		}
-		return err
+		return nil, fmt.Errorf("error on SNMP request: %w", err)
+	}
+
+	msg, err := helper.ParseSNMPResponse(data, requestID)
+	if err != nil {
+		opts.Log.Debugf("invalid SNMP response for community %q: %v", community, err)
+		return nil, nil
+	}
+	return msg, nil
+}
+
+// snmpScan sends a SNMP request with the given community string
+// and returns true if the host answered
+func snmpScan(opts UDPScannerOpts, ip netip.Addr, port uint16, community string) (bool, error) {
+	remote, channelNumber, err := setupChannel(opts, ip, port)
+	if err != nil {
+		// ignore timeouts
+		if errors.Is(err, helper.ErrTimeout) {
+			return false, nil
+		}
+		return false, err
 	}
 	defer remote.Close()
 
-	channelNumber := helper.RandomChannelNumber()
-	channelBindRequest, err := internal.ChannelBindRequest(opts.Username, opts.Password, nonce, realm, ip, port, channelNumber)
+	msg, err := sendSNMP(opts, remote, channelNumber, community)
 	if err != nil {
-		return fmt.Errorf("error on generating ChannelBindRequest: %w", err)
+		return false, err
+	}
+	if msg == nil {
+		return false, nil
 	}
 
-	channelBindResponse, err := channelBindRequest.SendAndReceive(opts.Log, remote, opts.Timeout)
+	opts.Log.Infof("SNMP on %s:%d: %s", ip.String(), port, msg)
+
+	return true, nil
+}
+
+// snmpBruteforce tries all supplied community strings against a host
+// over a single allocation and returns the ones that grant access. Agents
+// silently drop requests with invalid communities, so an error-status
+// response also means access but no data
+func snmpBruteforce(opts UDPScannerOpts, ip netip.Addr, port uint16, communities []string) ([]string, error) {
+	remote, channelNumber, err := setupChannel(opts, ip, port)
 	if err != nil {
-		return fmt.Errorf("error on sending ChannelBindRequest: %w", err)
+		// ignore timeouts
+		if errors.Is(err, helper.ErrTimeout) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	defer remote.Close()
+
+	var found []string
+	for _, community := range communities {
+		opts.Log.Debugf("trying SNMP community %q on %s:%d", community, ip.String(), port)
+		msg, err := sendSNMP(opts, remote, channelNumber, community)
+		if err != nil {
+			return found, err
+		}
+		if msg == nil {
+			continue
+		}
+		found = append(found, community)
+		if msg.ErrorStatus != 0 {
+			opts.Log.Warnf("SNMP community %q grants access on %s:%d, but the agent answered with %s", community, ip.String(), port, msg)
+			continue
+		}
+		opts.Log.Warnf("SNMP community %q grants access on %s:%d: %s", community, ip.String(), port, msg)
 	}
 
-	if channelBindResponse.Header.MessageType.Class == internal.MsgTypeClassError {
-		return fmt.Errorf("error on ChannelBind: %s", channelBindResponse.GetErrorString())
-	}
-
-	var snmp []byte
-	var inner []byte
-	// junk before version
-	inner = append(inner, 0x02)
-	inner = append(inner, 0x01)
-	// version 1 == v2c
-	inner = append(inner, 1)
-	// 4 - some random stuff
-	inner = append(inner, 0x04)
-	// length of community string
-	inner = append(inner, uint8(len(community)))
-	// community string
-	inner = append(inner, []byte(community)...)
-	// get-next 1.3.6.1.2.1
-	inner = append(inner, []byte{0xa1, 0x19, 0x02, 0x04}...)
-	// request ID
-	inner = append(inner, helper.PutUint32(rand.Uint32())...)
-	// rest
-	inner = append(inner, 0x02, 0x01, 0x00, 0x02, 0x01, 0x00, 0x30, 0x0b, 0x30, 0x09, 0x06, 0x05, 0x2b, 0x06, 0x01, 0x02, 0x01, 0x05, 0x00)
-
-	// Sequence
-	snmp = append(snmp, 0x30)
-	// Overall Length
-	snmp = append(snmp, uint8(len(inner)))
-	snmp = append(snmp, inner...)
-
-	snmpLen := len(snmp)
-
-	var buf []byte
-	buf = append(buf, channelNumber...)
-	buf = append(buf, helper.PutUint16(uint16(snmpLen))...)
-	buf = append(buf, snmp...)
-
-	err = helper.ConnectionWrite(remote, buf, opts.Timeout)
+	return found, nil
+}
+
+// dnsScan resolves the name on the target and returns true if the host answered
+func dnsScan(opts UDPScannerOpts, ip netip.Addr, port uint16, dnsName string) (bool, error) {
+	remote, channelNumber, err := setupChannel(opts, ip, port)
 	if err != nil {
-		return fmt.Errorf("error on sending SNMP request: %w", err)
+		// ignore timeouts
+		if errors.Is(err, helper.ErrTimeout) {
+			return false, nil
+		}
+		return false, err
 	}
+	defer remote.Close()
 
-	resp, err := helper.ConnectionRead(remote, opts.Timeout)
+	dns := helper.DNSQuery(dnsName, helper.DNSTypeA)
+	data, err := sendChannelData(opts, remote, channelNumber, dns, opts.Timeouts.DNS)
 	if err != nil {
 		// ignore timeouts
 		if errors.Is(err, helper.ErrTimeout) {
-			return nil
+			return false, nil
 		}
-		return fmt.Errorf("error on reading SNMP response: %w", err)
+		return false, fmt.Errorf("error on DNS request: %w", err)
 	}
 
-	channel, data, err := internal.ExtractChannelData(resp)
+	msg, err := helper.ParseDNSMessage(data)
 	if err != nil {
-		return err
+		return false, fmt.Errorf("invalid DNS response from %s: %w", ip.String(), err)
 	}
 
-	opts.Log.Infof("received %d bytes on channel %02x for ip %s", len(data), channel, ip.String())
-	opts.Log.Infof("UDP Response: %s", string(resp))
+	opts.Log.Infof("DNS on %s:%d: %s", ip.String(), port, dnsResponseString(msg))
+	if opts.resolvers != nil {
+		opts.resolvers.add(netip.AddrPortFrom(ip, port))
+	}
 
-	return nil
+	return true, nil
 }
 
-func dnsScan(opts UDPScannerOpts, ip netip.Addr, port uint16, dnsName string) error {
-	remote, realm, nonce, err := internal.SetupTurnConnection(opts.Log, opts.Protocol, opts.TurnServer, opts.UseTLS, opts.TlsVerify, opts.Timeout, ip, port, opts.Username, opts.Password)
+// dnsResponseString returns the response code and the answers of a DNS
+// response
+func dnsResponseString(msg *helper.DNSMessage) string {
+	var answers []string
+	for _, answer := range msg.Answers {
+		answers = append(answers, answer.String())
+	}
+	return fmt.Sprintf("%s, answers: %s", helper.DNSRCodeString(msg.RCode), strings.Join(answers, ", "))
+}
+
+// mdnsServicesName lists all service types advertised by a host (RFC 6763
+// section 9)
+const mdnsServicesName = "_services._dns-sd._udp.local"
+
+// maxMDNSServiceTypes limits the follow-up queries per host
+const maxMDNSServiceTypes = 32
+
+// mdnsScan asks the host for the service types it advertises and the
+// instances of every type. It returns the service types if the host
+// answered
+func mdnsScan(opts UDPScannerOpts, ip netip.Addr, port uint16) ([]string, error) {
+	remote, channelNumber, err := setupChannel(opts, ip, port)
 	if err != nil {
 		// ignore timeouts
 		if errors.Is(err, helper.ErrTimeout) {
-			return nil
+			return nil, nil
 		}
-		return err
+		return nil, err
 	}
 	defer remote.Close()
 
-	channelNumber := helper.RandomChannelNumber()
-	channelBindRequest, err := internal.ChannelBindRequest(opts.Username, opts.Password, nonce, realm, ip, port, channelNumber)
+	msg, err := mdnsQuery(opts, remote, channelNumber, mdnsServicesName)
+	if err != nil || msg == nil {
+		return nil, err
+	}
+	var serviceTypes []string
+	for _, answer := range msg.Answers {
+		if answer.Type == helper.DNSTypePTR {
+			serviceTypes = append(serviceTypes, answer.Data)
+		}
+	}
+	opts.Log.Infof("mDNS on %s:%d advertises %d service types", ip.String(), port, len(serviceTypes))
+	if len(serviceTypes) > maxMDNSServiceTypes {
+		opts.Log.Warnf("only querying the first %d service types of %s", maxMDNSServiceTypes, ip.String())
+		serviceTypes = serviceTypes[:maxMDNSServiceTypes]
+	}
+
+	for _, serviceType := range serviceTypes {
+		msg, err := mdnsQuery(opts, remote, channelNumber, serviceType)
+		if err != nil {
+			return serviceTypes, err
+		}
+		if msg == nil {
+			opts.Log.Infof("  %s", serviceType)
+			continue
+		}
+		// responders send the SRV and TXT records of the instances and the
+		// addresses of their hosts along
+		logged := make(map[int]bool)
+		for _, answer := range msg.Answers {
+			if answer.Type != helper.DNSTypePTR {
+				continue
+			}
+			opts.Log.Infof("  %s: %s", serviceType, answer.Data)
+			for i, record := range msg.Additional {
+				if record.Name == answer.Data {
+					opts.Log.Infof("    %s", record)
+					logged[i] = true
+				}
+			}
+		}
+		for i, record := range msg.Additional {
+			if !logged[i] {
+				opts.Log.Infof("    %s", record)
+			}
+		}
+	}
+
+	return serviceTypes, nil
+}
+
+// mdnsQuery sends a PTR query on the channel. A nil message without an
+// error means the request timed out
+func mdnsQuery(opts UDPScannerOpts, remote net.Conn, channelNumber []byte, name string) (*helper.DNSMessage, error) {
+	data, err := sendChannelData(opts, remote, channelNumber, helper.LinkLocalDNSQuery(name, helper.DNSTypePTR), opts.Timeouts.MDNS)
 	if err != nil {
-		return fmt.Errorf("error on generating ChannelBindRequest: %w", err)
+		// ignore timeouts
+		if errors.Is(err, helper.ErrTimeout) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("error on mDNS request: %w", err)
+	}
+	msg, err := helper.ParseDNSMessage(data)
+	if err != nil {
+		return nil, fmt.Errorf("invalid mDNS response: %w", err)
 	}
+	return msg, nil
+}
 
-	channelBindResponse, err := channelBindRequest.SendAndReceive(opts.Log, remote, opts.Timeout)
+// maxSSDPResponses limits the responses read per host. Devices answer
+// ssdp:all once for every device and service they implement
+const maxSSDPResponses = 64
+
+// ssdpScan sends a M-SEARCH request for all devices and services and
+// returns the responses with distinct locations
+func ssdpScan(opts UDPScannerOpts, ip netip.Addr, port uint16) ([]*helper.SSDPResponse, error) {
+	remote, channelNumber, err := setupChannel(opts, ip, port)
 	if err != nil {
-		return fmt.Errorf("error on sending ChannelBindRequest: %w", err)
+		// ignore timeouts
+		if errors.Is(err, helper.ErrTimeout) {
+			return nil, nil
+		}
+		return nil, err
 	}
+	defer remote.Close()
 
-	if channelBindResponse.Header.MessageType.Class == internal.MsgTypeClassError {
-		return fmt.Errorf("error on ChannelBind: %s", channelBindResponse.GetErrorString())
-	}
-
-	var dns []byte
-
-	// transactionID
-	dns = append(dns, helper.PutUint16(uint16(rand.Uint32()))...)
-	// FLAGS: standard query
-	dns = append(dns, []byte{0x01, 0x00}...)
-	// Questions: 1
-	dns = append(dns, helper.PutUint16(1)...)
-	// Answer RRs: 0
-	dns = append(dns, helper.PutUint16(0)...)
-	// Authority RRs: 0
-	dns = append(dns, helper.PutUint16(0)...)
-	// Additional RRs: 0
-	dns = append(dns, helper.PutUint16(0)...)
-
-	// Query: LEN, DOMAIN (null byte terminated), 0x0001, 0x0001
-	domainParts := strings.Split(dnsName, ".")
-	var domainBuf []byte
-	for _, x := range domainParts {
-		domainBuf = append(domainBuf, uint8(len(x)))
-		domainBuf = append(domainBuf, []byte(x)...)
-	}
-	// terminate with a null byte
-	domainBuf = append(domainBuf, 0x00)
-	// Type A
-	domainBuf = append(domainBuf, helper.PutUint16(1)...)
-	// Class: IN
-	domainBuf = append(domainBuf, helper.PutUint16(1)...)
-
-	dns = append(dns, domainBuf...)
-
-	dnsLen := len(dns)
-
-	var buf []byte
-	buf = append(buf, channelNumber...)
-	buf = append(buf, helper.PutUint16(uint16(dnsLen))...)
-	buf = append(buf, dns...)
-
-	err = helper.ConnectionWrite(remote, buf, opts.Timeout)
+	host := netip.AddrPortFrom(ip, port).String()
+	data, err := sendChannelData(opts, remote, channelNumber, helper.SSDPSearch(host, "ssdp:all"), opts.Timeouts.SSDP)
 	if err != nil {
-		return fmt.Errorf("error on sending DNS request: %w", err)
+		// ignore timeouts
+		if errors.Is(err, helper.ErrTimeout) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("error on SSDP request: %w", err)
 	}
 
-	resp, err := helper.ConnectionRead(remote, opts.Timeout)
+	var devices []*helper.SSDPResponse
+	locations := make(map[string]bool)
+	for i := 0; i < maxSSDPResponses; i++ {
+		ssdp, err := helper.ParseSSDPResponse(data)
+		if err != nil {
+			return devices, fmt.Errorf("invalid SSDP response from %s: %w", ip.String(), err)
+		}
+		opts.Log.Debugf("SSDP on %s:%d: %s %s", ip.String(), port, ssdp.ST, ssdp.USN)
+		if !locations[ssdp.Location] {
+			locations[ssdp.Location] = true
+			devices = append(devices, ssdp)
+			opts.Log.Infof("SSDP on %s:%d: server %s, location %s", ip.String(), port, ssdp.Server, ssdp.Location)
+		}
+
+		data, err = readChannelResponse(opts, remote, channelNumber, opts.Timeouts.SSDP)
+		if errors.Is(err, helper.ErrTimeout) {
+			break
+		}
+		if err != nil {
+			return devices, fmt.Errorf("error on reading SSDP response: %w", err)
+		}
+	}
+
+	return devices, nil
+}
+
+// tftpScan requests every file of opts.TFTPFiles and returns the files
+// the server sent. TFTP servers answer from a new port, so the responses
+// arrive as data indications on the permission of the channel
+func tftpScan(opts UDPScannerOpts, ip netip.Addr, port uint16) ([]string, error) {
+	remote, channelNumber, err := setupChannel(opts, ip, port)
 	if err != nil {
 		// ignore timeouts
 		if errors.Is(err, helper.ErrTimeout) {
-			return nil
+			return nil, nil
+		}
+		return nil, err
+	}
+	defer remote.Close()
+
+	var found []string
+	answered := false
+	for _, filename := range opts.TFTPFiles {
+		from, data, err := sendRelayedData(opts, remote, channelNumber, netip.AddrPortFrom(ip, port), helper.TFTPReadRequest(filename), false, opts.Timeouts.TFTP)
+		if err != nil {
+			// servers answer every request, so there is none
+			if errors.Is(err, helper.ErrTimeout) {
+				return found, nil
+			}
+			return found, fmt.Errorf("error on TFTP request: %w", err)
+		}
+		packet, err := helper.ParseTFTPPacket(data)
+		if err != nil {
+			return found, fmt.Errorf("invalid TFTP response from %s: %w", from, err)
+		}
+		if !answered {
+			answered = true
+			opts.Log.Infof("TFTP server on %s:%d", ip.String(), port)
+		}
+		if packet.Opcode == helper.TFTPOpError {
+			opts.Log.Debugf("TFTP %s on %s: error %d %s", filename, from, packet.ErrorCode, packet.ErrorMessage)
+			continue
+		}
+
+		found = append(found, filename)
+		opts.Log.Warnf("TFTP %s on %s:%d is readable, received %d bytes of block %d", filename, ip.String(), port, len(packet.Data), packet.Block)
+		if err := abortTFTP(opts, remote, channelNumber, netip.AddrPortFrom(ip, port), from); err != nil {
+			opts.Log.Debugf("could not abort TFTP transfer of %s: %v", filename, err)
+		}
+		if opts.TFTPDownload != "" {
+			name := filepath.Join(opts.TFTPDownload, fmt.Sprintf("%s_%s", ip.String(), strings.NewReplacer("/", "_", "\\", "_", "..", "_").Replace(filename)))
+			if err := os.WriteFile(name, packet.Data, 0o600); err != nil {
+				return found, fmt.Errorf("could not write %s: %w", name, err)
+			}
+			opts.Log.Infof("wrote the first block of %s to %s", filename, name)
+		}
+	}
+
+	return found, nil
+}
+
+// relayedData returns the sender and payload of data received on a
+// channel or as data indication. Data on the channel is from target. Set
+// padded for messages read from TCP and TLS connections
+func relayedData(resp []byte, target netip.AddrPort, padded bool) (netip.AddrPort, []byte, error) {
+	if internal.IsChannelData(resp) {
+		payloads, _ := internal.SplitChannelData(resp, padded)
+		if len(payloads) != 1 {
+			return netip.AddrPort{}, nil, fmt.Errorf("invalid channel data of %d bytes", len(resp))
 		}
-		return fmt.Errorf("error on reading DNS response: %w", err)
+		return target, payloads[0], nil
 	}
+	return internal.ParseDataIndication(resp)
+}
 
-	channel, data, err := internal.ExtractChannelData(resp)
+// abortTFTP tells the server to stop sending the file, so it does not
+// retransmit the first block until it times out
+func abortTFTP(opts UDPScannerOpts, remote net.Conn, channelNumber []byte, target, from netip.AddrPort) error {
+	payload := helper.TFTPError(helper.TFTPErrorNotDefined, "transfer aborted")
+	if from == target {
+		buf, err := internal.ChannelData(channelNumber, payload, opts.Protocol == "tcp")
+		if err != nil {
+			return err
+		}
+		return helper.ConnectionWrite(remote, buf, opts.Timeouts.TFTP)
+	}
+	indication, err := internal.SendIndication(from.Addr(), from.Port(), payload, false)
 	if err != nil {
 		return err
 	}
+	return indication.Send(opts.Log, remote, opts.Timeouts.TFTP)
+}
 
-	opts.Log.Infof("received %d bytes on channel %02x for ip %s", len(data), channel, ip.String())
-	opts.Log.Infof("UDP Response: %s", string(resp))
+// memcachedScan sends a stats command and returns the parsed stats if the
+// host answered. The stats are split into datagrams of 1400 bytes by the
+// server, so the remaining datagrams are read after the first one
+func memcachedScan(opts UDPScannerOpts, ip netip.Addr, port uint16) (*helper.MemcachedStats, error) {
+	remote, channelNumber, err := setupChannel(opts, ip, port)
+	if err != nil {
+		// ignore timeouts
+		if errors.Is(err, helper.ErrTimeout) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	defer remote.Close()
 
-	return nil
+	requestID := uint16(rand.Uint32())
+	data, err := sendChannelData(opts, remote, channelNumber, helper.MemcachedStatsRequest(requestID), opts.Timeouts.Memcached)
+	if err != nil {
+		// ignore timeouts
+		if errors.Is(err, helper.ErrTimeout) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("error on memcached request: %w", err)
+	}
+
+	var payloads [][]byte
+	for {
+		seq, total, payload, err := helper.ParseMemcachedFrame(data, requestID)
+		if err != nil {
+			return nil, fmt.Errorf("invalid memcached response from %s: %w", ip.String(), err)
+		}
+		if payloads == nil {
+			payloads = make([][]byte, total)
+		}
+		if int(seq) < len(payloads) {
+			payloads[seq] = payload
+		}
+		if !hasMissing(payloads) {
+			break
+		}
+		data, err = readChannelResponse(opts, remote, channelNumber, opts.Timeouts.Memcached)
+		if err != nil {
+			// the stats are parsed from the datagrams received so far
+			opts.Log.Debugf("did not receive all memcached datagrams from %s: %v", ip.String(), err)
+			break
+		}
+	}
+
+	stats, err := helper.ParseMemcachedStats(bytes.Join(payloads, nil))
+	if err != nil {
+		return nil, fmt.Errorf("invalid memcached response from %s: %w", ip.String(), err)
+	}
+	opts.Log.Infof("memcached on %s:%d: %s", ip.String(), port, stats)
+
+	return stats, nil
+}
+
+// hasMissing returns true if a datagram was not received yet
+func hasMissing(payloads [][]byte) bool {
+	for _, p := range payloads {
+		if p == nil {
+			return true
+		}
+	}
+	return false
 }
@@ -0,0 +1,219 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/firefart/stunner/internal/helper"
+	"github.com/sirupsen/logrus"
+)
+
+// maxProbeDBSize is the largest probe database or signature downloaded
+const maxProbeDBSize = 10 * 1024 * 1024
+
+type UpdateProbesOpts struct {
+	Log *logrus.Logger
+	// URL is the location of the probe database. The signature is read
+	// from the same URL with .sig appended
+	URL string
+	// Import is a local probe database to install instead of downloading
+	// it. The signature is read from the file with .sig appended
+	Import    string
+	PublicKey string
+	// File is the installed probe database
+	File    string
+	Timeout time.Duration
+	// Check only reports if a newer version is available
+	Check bool
+	// Pin only allows this version to be installed from now on
+	Pin int
+	// Unpin removes the pinned version
+	Unpin bool
+}
+
+func (opts UpdateProbesOpts) Validate() error {
+	if opts.Log == nil {
+		return fmt.Errorf("please supply a valid logger")
+	}
+	if opts.URL == "" && opts.Import == "" {
+		return fmt.Errorf("please supply a URL or a file to import")
+	}
+	if opts.URL != "" && opts.Import != "" {
+		return fmt.Errorf("please supply either a URL or a file to import")
+	}
+	if opts.PublicKey == "" {
+		return fmt.Errorf("please supply the public key the probe database is signed with")
+	}
+	if opts.File == "" {
+		return fmt.Errorf("please supply the probe database file")
+	}
+	if opts.Pin < 0 {
+		return fmt.Errorf("pinned version can not be negative")
+	}
+	if opts.Pin > 0 && opts.Unpin {
+		return fmt.Errorf("please supply either a version to pin or unpin")
+	}
+	return nil
+}
+
+// UpdateProbes downloads or imports a signed probe database and installs
+// it if it is newer than the installed one. A pinned version is kept until
+// it is unpinned, so runs of an engagement use the same probes
+func UpdateProbes(opts UpdateProbesOpts) error {
+	if err := opts.Validate(); err != nil {
+		return err
+	}
+
+	key, err := helper.ParseProbeDBKey(opts.PublicKey)
+	if err != nil {
+		return err
+	}
+
+	pinFile := opts.File + ".pin"
+	pin, err := readProbePin(pinFile)
+	if err != nil {
+		return err
+	}
+	switch {
+	case opts.Unpin:
+		if err := os.Remove(pinFile); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return fmt.Errorf("could not remove pin: %w", err)
+		}
+		pin = 0
+	case opts.Pin > 0:
+		pin = opts.Pin
+	}
+
+	installed := 0
+	if db, err := helper.ReadProbeDB(opts.File); err == nil {
+		installed = db.Version
+	} else if !errors.Is(err, os.ErrNotExist) {
+		opts.Log.Warnf("ignoring invalid installed probe database %s: %v", opts.File, err)
+	}
+
+	data, signature, err := fetchProbeDB(opts)
+	if err != nil {
+		return err
+	}
+	if err := helper.VerifyProbeDB(data, signature, key); err != nil {
+		return fmt.Errorf("refusing the probe database: %w", err)
+	}
+	db, err := helper.ParseProbeDB(data)
+	if err != nil {
+		return err
+	}
+	opts.Log.Infof("probe database version %d with %d probes available (sha256 %s)", db.Version, len(db.Probes), helper.ProbeDBHash(data))
+
+	switch {
+	case pin > 0 && db.Version != pin:
+		opts.Log.Infof("keeping probe database version %d as version %d is pinned", installed, pin)
+		return writeProbePin(opts, pinFile, pin)
+	case pin == 0 && db.Version < installed:
+		return fmt.Errorf("refusing to downgrade the probe database from version %d to %d, pin the version to downgrade", installed, db.Version)
+	case db.Version == installed:
+		opts.Log.Infof("probe database version %d is up to date", installed)
+		return writeProbePin(opts, pinFile, pin)
+	}
+
+	if opts.Check {
+		opts.Log.Infof("update from version %d to %d available", installed, db.Version)
+		return nil
+	}
+
+	if err := os.MkdirAll(filepath.Dir(opts.File), 0o700); err != nil {
+		return fmt.Errorf("could not create directory: %w", err)
+	}
+	// the signature is kept so the database can be imported on other hosts
+	if err := os.WriteFile(opts.File+".sig", signature, 0o644); err != nil {
+		return fmt.Errorf("could not write signature: %w", err)
+	}
+	if err := os.WriteFile(opts.File, data, 0o644); err != nil {
+		return fmt.Errorf("could not write probe database: %w", err)
+	}
+	opts.Log.Infof("installed probe database version %d to %s", db.Version, opts.File)
+	return writeProbePin(opts, pinFile, pin)
+}
+
+// fetchProbeDB returns the probe database and its signature
+func fetchProbeDB(opts UpdateProbesOpts) ([]byte, []byte, error) {
+	if opts.Import != "" {
+		data, err := os.ReadFile(opts.Import)
+		if err != nil {
+			return nil, nil, fmt.Errorf("could not read probe database: %w", err)
+		}
+		signature, err := os.ReadFile(opts.Import + ".sig")
+		if err != nil {
+			return nil, nil, fmt.Errorf("could not read signature: %w", err)
+		}
+		return data, signature, nil
+	}
+
+	client := &http.Client{Timeout: opts.Timeout}
+	data, err := download(client, opts.URL)
+	if err != nil {
+		return nil, nil, fmt.Errorf("could not download probe database: %w", err)
+	}
+	signature, err := download(client, opts.URL+".sig")
+	if err != nil {
+		return nil, nil, fmt.Errorf("could not download signature: %w", err)
+	}
+	return data, signature, nil
+}
+
+func download(client *http.Client, u string) ([]byte, error) {
+	resp, err := client.Get(u)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%s: %s", u, resp.Status)
+	}
+	data, err := io.ReadAll(io.LimitReader(resp.Body, maxProbeDBSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(data) > maxProbeDBSize {
+		return nil, fmt.Errorf("%s is larger than %d bytes", u, maxProbeDBSize)
+	}
+	return data, nil
+}
+
+// readProbePin returns the pinned version or 0 if no version is pinned
+func readProbePin(filename string) (int, error) {
+	data, err := os.ReadFile(filename)
+	if errors.Is(err, os.ErrNotExist) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, fmt.Errorf("could not read pin: %w", err)
+	}
+	pin, err := strconv.Atoi(strings.TrimSpace(string(data)))
+	if err != nil || pin <= 0 {
+		return 0, fmt.Errorf("invalid pin in %s", filename)
+	}
+	return pin, nil
+}
+
+// writeProbePin stores a newly pinned version. Nothing is written in check
+// mode or if no version is pinned
+func writeProbePin(opts UpdateProbesOpts, filename string, pin int) error {
+	if opts.Check || opts.Pin == 0 {
+		return nil
+	}
+	if err := os.MkdirAll(filepath.Dir(filename), 0o700); err != nil {
+		return fmt.Errorf("could not create directory: %w", err)
+	}
+	if err := os.WriteFile(filename, []byte(strconv.Itoa(pin)+"\n"), 0o644); err != nil {
+		return fmt.Errorf("could not write pin: %w", err)
+	}
+	opts.Log.Infof("pinned probe database version %d", pin)
+	return nil
+}
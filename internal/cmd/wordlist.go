@@ -0,0 +1,141 @@
+package cmd
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/firefart/stunner/internal"
+	"github.com/firefart/stunner/internal/helper"
+	"github.com/sirupsen/logrus"
+)
+
+type WordlistOpts struct {
+	// TurnServer is optional. If set the realm is read from the server
+	TurnServer string
+	Protocol   string
+	UseTLS     bool
+	TlsVerify  bool
+	Timeout    time.Duration
+	Log        *logrus.Logger
+	Realms     []string
+	// Users are the user ids used in the timestamp usernames
+	Users []string
+	// TTL is the maximum lifetime of TURN REST API credentials. Usernames
+	// with all expiry timestamps from now to now+TTL are generated
+	TTL       time.Duration
+	Step      time.Duration
+	Separator string
+	Common    bool
+	// Output is the file the candidates are written to, stdout if empty
+	Output string
+}
+
+func (opts WordlistOpts) Validate() error {
+	if opts.TurnServer != "" {
+		if !strings.Contains(opts.TurnServer, ":") {
+			return fmt.Errorf("turnserver needs a port")
+		}
+		if opts.Protocol != "tcp" && opts.Protocol != "udp" {
+			return fmt.Errorf("protocol needs to be either tcp or udp")
+		}
+	}
+	if opts.Log == nil {
+		return fmt.Errorf("please supply a valid logger")
+	}
+	if opts.TTL < 0 {
+		return fmt.Errorf("ttl can not be negative")
+	}
+	if opts.TTL > 0 && opts.Step <= 0 {
+		return fmt.Errorf("step needs to be positive")
+	}
+	return nil
+}
+
+// Wordlist generates TURN username candidates: TURN REST API usernames
+// with an expiry timestamp, permutations of the realm and usernames common
+// in WebRTC deployments
+func Wordlist(opts WordlistOpts) error {
+	if err := opts.Validate(); err != nil {
+		return err
+	}
+
+	realms := opts.Realms
+	if opts.TurnServer != "" {
+		realm, err := turnRealm(opts)
+		if err != nil {
+			return fmt.Errorf("could not get the realm: %w", err)
+		}
+		opts.Log.Infof("realm of %s: %s", opts.TurnServer, realm)
+		realms = append(realms, realm)
+	}
+
+	var candidates []string
+	if opts.TTL > 0 {
+		now := time.Now().Truncate(opts.Step)
+		candidates = append(candidates, helper.TimestampUsernames(opts.Users, now, now.Add(opts.TTL), opts.Step, opts.Separator)...)
+	}
+	for _, realm := range realms {
+		candidates = append(candidates, helper.RealmUsernames(realm)...)
+	}
+	if opts.Common {
+		candidates = append(candidates, helper.CommonTURNUsernames...)
+	}
+	candidates = append(candidates, opts.Users...)
+
+	var out io.Writer = os.Stdout
+	if opts.Output != "" {
+		f, err := os.Create(opts.Output)
+		if err != nil {
+			return fmt.Errorf("could not create output file: %w", err)
+		}
+		defer f.Close()
+		out = f
+	}
+	w := bufio.NewWriter(out)
+	seen := make(map[string]struct{}, len(candidates))
+	for _, c := range candidates {
+		if _, ok := seen[c]; ok || c == "" {
+			continue
+		}
+		seen[c] = struct{}{}
+		if _, err := fmt.Fprintln(w, c); err != nil {
+			return fmt.Errorf("could not write candidate: %w", err)
+		}
+	}
+	if err := w.Flush(); err != nil {
+		return fmt.Errorf("could not write candidates: %w", err)
+	}
+
+	if opts.Output != "" {
+		opts.Log.Infof("%d candidates written to %s", len(seen), opts.Output)
+	}
+	return nil
+}
+
+// turnRealm reads the realm from the error response to an unauthenticated
+// allocate request
+func turnRealm(opts WordlistOpts) (string, error) {
+	remote, err := internal.Connect(opts.Protocol, opts.TurnServer, opts.UseTLS, opts.TlsVerify, opts.Timeout)
+	if err != nil {
+		return "", err
+	}
+	defer remote.Close()
+
+	allocateRequest := internal.AllocateRequest(internal.RequestedTransportUDP, internal.AllocateProtocolIgnore)
+	allocateResponse, err := allocateRequest.SendAndReceive(opts.Log, remote, opts.Timeout)
+	if err != nil {
+		return "", fmt.Errorf("error on sending AllocateRequest: %w", err)
+	}
+	if allocateResponse.Header.MessageType.Class != internal.MsgTypeClassError {
+		return "", fmt.Errorf("MessageClass is not Error (should be not authenticated)")
+	}
+	realm := string(allocateResponse.GetAttribute(internal.AttrRealm).Value)
+	if realm == "" {
+		return "", fmt.Errorf("server did not send a realm")
+	}
+	return realm, nil
+}
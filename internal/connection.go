@@ -3,6 +3,8 @@ package internal
 import (
 	"context"
 	"crypto/tls"
+	"encoding/binary"
+	"errors"
 	"fmt"
 	"net"
 	"time"
@@ -11,10 +13,55 @@ import (
 	"github.com/pion/dtls/v2"
 )
 
+// Connect connects to the TURN server. The connection is closed and
+// allocations on it are deleted on Shutdown
 func Connect(protocol string, turnServer string, useTLS bool, tlsVerify bool, timeout time.Duration) (net.Conn, error) {
+	conn, err := dial(protocol, turnServer, useTLS, tlsVerify, timeout)
+	if err != nil {
+		return nil, err
+	}
+	return newTrackedConn(conn), nil
+}
+
+// ConnectFrom connects to the TURN server from the local address. Used
+// together with Abort it reopens a closed connection on the same 5-tuple
+func ConnectFrom(protocol string, turnServer string, useTLS bool, tlsVerify bool, timeout time.Duration, local net.Addr) (net.Conn, error) {
+	conn, err := dialFrom(protocol, turnServer, useTLS, tlsVerify, timeout, local)
+	if err != nil {
+		return nil, err
+	}
+	return newTrackedConn(conn), nil
+}
+
+// Abort closes the connection without shutting it down gracefully. TCP
+// connections are reset, so the server sees an abrupt close and the local
+// port can be reused right away. No close_notify is sent on TLS
+func Abort(conn net.Conn) error {
+	if t, ok := conn.(*trackedConn); ok {
+		untrackConn(t)
+		conn = t.Conn
+	}
+	if tlsConn, ok := conn.(*tls.Conn); ok {
+		conn = tlsConn.NetConn()
+	}
+	if tcpConn, ok := conn.(*net.TCPConn); ok {
+		if err := tcpConn.SetLinger(0); err != nil {
+			tcpConn.Close()
+			return err
+		}
+	}
+	return conn.Close()
+}
+
+// dial connects to the TURN server without tracking the connection
+func dial(protocol string, turnServer string, useTLS bool, tlsVerify bool, timeout time.Duration) (net.Conn, error) {
+	return dialFrom(protocol, turnServer, useTLS, tlsVerify, timeout, nil)
+}
+
+func dialFrom(protocol string, turnServer string, useTLS bool, tlsVerify bool, timeout time.Duration, local net.Addr) (net.Conn, error) {
 	if !useTLS {
 		// non TLS connection
-		conn, err := net.DialTimeout(protocol, turnServer, timeout)
+		conn, err := helper.DialFrom(protocol, turnServer, local, timeout)
 		if err != nil {
 			return nil, fmt.Errorf("error on establishing a connection to the server: %w", err)
 		}
@@ -24,25 +71,41 @@ func Connect(protocol string, turnServer string, useTLS bool, tlsVerify bool, ti
 	// if we reach here we have a TLS connection
 	switch protocol {
 	case "tcp":
-		d := net.Dialer{
-			Timeout: timeout,
+		conn, err := helper.DialFrom(protocol, turnServer, local, timeout)
+		if err != nil {
+			return nil, fmt.Errorf("error on establishing a connection to the server: %w", err)
+		}
+		host, _, err := net.SplitHostPort(turnServer)
+		if err != nil {
+			conn.Close()
+			return nil, fmt.Errorf("invalid turnserver %s: %w", turnServer, err)
 		}
-		conn, err := tls.DialWithDialer(&d, protocol, turnServer, &tls.Config{
+		tlsConn := tls.Client(conn, &tls.Config{
 			InsecureSkipVerify: !tlsVerify,
+			ServerName:         serverName(host),
 		})
-		if err != nil {
+		ctx, cancel := context.WithTimeout(context.Background(), timeout)
+		defer cancel()
+		if err := tlsConn.HandshakeContext(ctx); err != nil {
+			conn.Close()
 			return nil, fmt.Errorf("error on establishing a TLS connection to the server: %w", err)
 		}
-		return conn, nil
+		return tlsConn, nil
 	case "udp":
-		conn, err := net.DialTimeout(protocol, turnServer, timeout)
+		conn, err := helper.DialFrom(protocol, turnServer, local, timeout)
 		if err != nil {
 			return nil, fmt.Errorf("error on establishing a connection to the server: %w", err)
 		}
+		host, _, err := net.SplitHostPort(turnServer)
+		if err != nil {
+			conn.Close()
+			return nil, fmt.Errorf("invalid turnserver %s: %w", turnServer, err)
+		}
 		ctx, cancel := context.WithTimeout(context.Background(), timeout)
 		defer cancel()
 		dtlsConn, err := dtls.ClientWithContext(ctx, conn, &dtls.Config{
 			InsecureSkipVerify: !tlsVerify,
+			ServerName:         serverName(host),
 		})
 		if err != nil {
 			return nil, fmt.Errorf("error on establishing a DTLS connection to the server: %w", err)
@@ -55,6 +118,9 @@ func Connect(protocol string, turnServer string, useTLS bool, tlsVerify bool, ti
 
 // send serializes a STUN object and sends it on the provided connection
 func (s *Stun) send(conn net.Conn, timeout time.Duration) error {
+	if err := checkScope(s); err != nil {
+		return err
+	}
 	data, err := s.Serialize()
 	if err != nil {
 		return fmt.Errorf("Serialize: %w", err)
@@ -62,25 +128,110 @@ func (s *Stun) send(conn net.Conn, timeout time.Duration) error {
 	if err := helper.ConnectionWrite(conn, data, timeout); err != nil {
 		return fmt.Errorf("ConnectionWrite: %w", err)
 	}
+	countMessage(conn, len(data), 0)
+
+	return nil
+}
 
+// Send sends a TURN message without waiting for a response. This
+// is used for indications
+func (s *Stun) Send(logger DebugLogger, conn net.Conn, timeout time.Duration) error {
+	applyClientProfile(s)
+	logger.Debugf("%s Sending\n%s", logTag(conn, s.Header.TransactionID), s.String())
+	if err := s.send(conn, timeout); err != nil {
+		return fmt.Errorf("Send: %w", err)
+	}
 	return nil
 }
 
-// SendAndReceive sends a TURN request on a connection and gets a response
+// SendAndReceive sends a TURN request on a connection and gets a response.
+// Channel data that is still in flight is skipped. If a client profile is
+// set, unanswered requests on datagram connections are retransmitted
+// until the timeout is reached
 func (s *Stun) SendAndReceive(logger DebugLogger, conn net.Conn, timeout time.Duration) (*Stun, error) {
-	logger.Debugf("Sending\n%s", s.String())
+	addOrigin(s)
+	applyClientProfile(s)
+	logger.Debugf("%s Sending\n%s", logTag(conn, s.Header.TransactionID), s.String())
 	err := s.send(conn, timeout)
 	if err != nil {
+		countTransaction(s, nil)
 		return nil, fmt.Errorf("Send: %w", err)
 	}
-	buffer, err := helper.ConnectionRead(conn, timeout)
-	if err != nil {
-		return nil, fmt.Errorf("ConnectionRead: %w", err)
+	deadline := time.Now().Add(timeout)
+	rto := retransmitTimeout(conn)
+	var buffer []byte
+	for {
+		wait := time.Until(deadline)
+		if rto > 0 && rto < wait {
+			wait = rto
+		}
+		buffer, err = readMessage(conn, wait)
+		countMessage(conn, 0, len(buffer))
+		if errors.Is(err, helper.ErrTimeout) && rto > 0 && time.Until(deadline) > 0 {
+			logger.Debugf("%s no response after %s, retransmitting", logTag(conn, s.Header.TransactionID), rto)
+			if err := s.send(conn, timeout); err != nil {
+				countTransaction(s, nil)
+				return nil, fmt.Errorf("Send: %w", err)
+			}
+			rto *= 2
+			continue
+		}
+		if err != nil {
+			countTransaction(s, nil)
+			return nil, fmt.Errorf("ConnectionRead: %w", err)
+		}
+		if !IsChannelData(buffer) {
+			break
+		}
+		logger.Debugf("[conn %s] ignoring %d bytes of channel data while waiting for a response", ConnID(conn), len(buffer))
 	}
 	resp, err := fromBytes(buffer)
 	if err != nil {
+		countTransaction(s, nil)
 		return nil, fmt.Errorf("fromBytes: %w", err)
 	}
-	logger.Debugf("Received\n%s", resp.String())
+	logger.Debugf("%s Received\n%s", logTag(conn, resp.Header.TransactionID), resp.String())
+	countTransaction(s, resp)
+	observeResponse(conn, s, resp)
+	if resp.Header.TransactionID != s.Header.TransactionID {
+		logger.Debugf("%s response does not belong to transaction %02x", logTag(conn, resp.Header.TransactionID), s.Header.TransactionID)
+	}
 	return resp, nil
 }
+
+// ReadMessage reads a single STUN or ChannelData message from the
+// connection to the TURN server. On TCP and TLS connections ChannelData
+// is returned with its padding
+func ReadMessage(conn net.Conn, timeout time.Duration) ([]byte, error) {
+	return readMessage(conn, timeout)
+}
+
+// readMessage reads a single STUN or ChannelData message. Datagram
+// connections like UDP and DTLS return one message per read. On streams
+// like TCP and TLS a message can be split over several reads or arrive
+// together with the next one, so it is read by the length in its header
+func readMessage(conn net.Conn, timeout time.Duration) ([]byte, error) {
+	if !isStream(conn) {
+		return helper.ConnectionRead(conn, timeout)
+	}
+	return helper.ConnectionReadMessage(conn, 4, messageLength, timeout)
+}
+
+// isStream returns true for TCP and TLS connections
+func isStream(conn net.Conn) bool {
+	return conn.LocalAddr() != nil && conn.LocalAddr().Network() == "tcp"
+}
+
+// messageLength returns the length of the STUN or ChannelData message
+// starting with header. ChannelData is padded to a multiple of 4 bytes on
+// streams
+func messageLength(header []byte) (int, error) {
+	length := int(binary.BigEndian.Uint16(header[2:4]))
+	if IsChannelData(header) {
+		return 4 + (length+3)/4*4, nil
+	}
+	if header[0]&0xc0 != 0 {
+		return 0, fmt.Errorf("invalid message header %02x", header)
+	}
+	return headerSize + length, nil
+}
@@ -0,0 +1,95 @@
+package internal
+
+import (
+	"net"
+	"testing"
+	"time"
+)
+
+func TestSendAndReceiveSplitResponse(t *testing.T) {
+	t.Parallel()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		buf := make([]byte, 1024)
+		n, err := conn.Read(buf)
+		if err != nil {
+			return
+		}
+		req, err := fromBytes(buf[:n])
+		if err != nil {
+			return
+		}
+		resp := newStun()
+		resp.Header.TransactionID = req.Header.TransactionID
+		resp.Header.MessageType = MessageType{Class: MsgTypeClassSuccess, Method: req.Header.MessageType.Method}
+		resp.Attributes = []Attribute{{Type: AttrSoftware, Value: []byte("test server")}}
+		data, err := resp.Serialize()
+		if err != nil {
+			return
+		}
+		// late channel data followed by the response, split like a
+		// response spanning two TLS records
+		data = append([]byte{0x40, 0x00, 0x00, 0x01, 0xff, 0x00, 0x00, 0x00}, data...)
+		for _, part := range [][]byte{data[:15], data[15:]} {
+			if _, err := conn.Write(part); err != nil {
+				return
+			}
+			time.Sleep(20 * time.Millisecond)
+		}
+		// keep the connection open until the client is done
+		_, _ = conn.Read(buf)
+	}()
+
+	conn, err := net.Dial("tcp", listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	resp, err := BindingRequest().SendAndReceive(nilLogger{}, conn, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := string(resp.GetAttribute(AttrSoftware).Value); got != "test server" {
+		t.Errorf("got SOFTWARE %q", got)
+	}
+}
+
+func TestMessageLength(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		name   string
+		header []byte
+		want   int
+	}{
+		{"STUN", []byte{0x01, 0x01, 0x00, 0x0c}, 32},
+		{"ChannelData padded", []byte{0x40, 0x00, 0x00, 0x05}, 12},
+		{"ChannelData aligned", []byte{0x40, 0x00, 0x00, 0x08}, 12},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			got, err := messageLength(tt.header)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Errorf("messageLength() = %d, want %d", got, tt.want)
+			}
+		})
+	}
+	if _, err := messageLength([]byte{0x80, 0x00, 0x00, 0x00}); err == nil {
+		t.Error("expected an error for an invalid header")
+	}
+}
@@ -0,0 +1,58 @@
+package internal
+
+import "sync"
+
+var (
+	frontingMu    sync.RWMutex
+	tlsServerName string
+	origin        string
+)
+
+// SetTLSServerName sets the server name presented with SNI and used to
+// verify the certificate on TLS and DTLS connections instead of the host
+// of the turnserver. This allows to connect to a CDN edge or a shared TLS
+// endpoint by IP while addressing the relay behind it. An empty name
+// restores the default
+func SetTLSServerName(name string) {
+	frontingMu.Lock()
+	defer frontingMu.Unlock()
+	tlsServerName = name
+}
+
+// SetOrigin adds an ORIGIN attribute with the value to all following STUN
+// requests. Relays shared by several services use it to select the realm
+// and the credentials. An empty origin disables the attribute
+func SetOrigin(o string) {
+	frontingMu.Lock()
+	defer frontingMu.Unlock()
+	origin = o
+}
+
+// serverName returns the name to present on TLS connections to host
+func serverName(host string) string {
+	frontingMu.RLock()
+	defer frontingMu.RUnlock()
+	if tlsServerName != "" {
+		return tlsServerName
+	}
+	return host
+}
+
+// addOrigin adds the ORIGIN attribute to requests if one is set. It
+// needs to be called before serializing so the attribute is covered by
+// the MESSAGE-INTEGRITY
+func addOrigin(s *Stun) {
+	frontingMu.RLock()
+	o := origin
+	frontingMu.RUnlock()
+	if o == "" || s.Header.MessageType.Class != MsgTypeClassRequest {
+		return
+	}
+	if len(s.GetAttribute(AttrOrigin).Value) > 0 {
+		return
+	}
+	s.Attributes = append(s.Attributes, Attribute{
+		Type:  AttrOrigin,
+		Value: []byte(o),
+	})
+}
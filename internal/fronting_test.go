@@ -0,0 +1,44 @@
+package internal
+
+import "testing"
+
+// no t.Parallel as the origin is global
+func TestAddOrigin(t *testing.T) {
+	SetOrigin("https://meet.example.com")
+	defer SetOrigin("")
+
+	req := AllocateRequest(RequestedTransportUDP, AllocateProtocolIgnore)
+	addOrigin(req)
+	addOrigin(req)
+	count := 0
+	for _, a := range req.Attributes {
+		if a.Type == AttrOrigin {
+			count++
+			if string(a.Value) != "https://meet.example.com" {
+				t.Errorf("got origin %q", a.Value)
+			}
+		}
+	}
+	if count != 1 {
+		t.Errorf("got %d ORIGIN attributes, want 1", count)
+	}
+
+	resp := newStun()
+	resp.Header.MessageType = MessageType{Class: MsgTypeClassSuccess, Method: MsgTypeMethodAllocate}
+	addOrigin(resp)
+	if len(resp.Attributes) != 0 {
+		t.Error("ORIGIN was added to a response")
+	}
+}
+
+// no t.Parallel as the server name is global
+func TestServerName(t *testing.T) {
+	if got := serverName("turn.example.com"); got != "turn.example.com" {
+		t.Errorf("serverName() = %q without override", got)
+	}
+	SetTLSServerName("front.example.net")
+	defer SetTLSServerName("")
+	if got := serverName("192.0.2.1"); got != "front.example.net" {
+		t.Errorf("serverName() = %q, want front.example.net", got)
+	}
+}
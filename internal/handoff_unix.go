@@ -0,0 +1,134 @@
+//go:build !windows
+
+package internal
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"os"
+	"syscall"
+	"time"
+)
+
+// Handoff passes the allocation to the first process connecting to the unix
+// socket at path. The control connection is sent as a file descriptor
+// together with the realm, nonce and credentials so the receiving process
+// can use the allocation without authenticating again. The allocation is
+// refreshed until the handoff is done and must not be used afterwards.
+func (a *TCPAllocation) Handoff(path string) error {
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("could not remove old socket %s: %w", path, err)
+	}
+	listener, err := net.ListenUnix("unix", &net.UnixAddr{Name: path, Net: "unix"})
+	if err != nil {
+		return fmt.Errorf("could not listen on %s: %w", path, err)
+	}
+	// removes the socket file
+	defer listener.Close()
+	// the state contains the credentials
+	if err := os.Chmod(path, 0o600); err != nil {
+		return fmt.Errorf("could not set permissions on %s: %w", path, err)
+	}
+
+	stop := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		if err := a.KeepAlive(relayRefreshInterval, stop); err != nil {
+			a.logger.Debugf("[conn %s] error on refreshing the allocation: %v", ConnID(a.Control), err)
+		}
+	}()
+
+	conn, err := listener.AcceptUnix()
+	// no refreshes must be sent after the handoff
+	close(stop)
+	<-done
+	if err != nil {
+		return fmt.Errorf("could not accept handoff connection: %w", err)
+	}
+	defer conn.Close()
+
+	state, err := json.Marshal(a.state())
+	if err != nil {
+		return fmt.Errorf("could not encode allocation state: %w", err)
+	}
+	f, err := a.Control.File()
+	if err != nil {
+		return fmt.Errorf("could not get file of control connection: %w", err)
+	}
+	defer f.Close()
+
+	if _, _, err := conn.WriteMsgUnix(state, syscall.UnixRights(int(f.Fd())), nil); err != nil {
+		return fmt.Errorf("could not send allocation: %w", err)
+	}
+	// the allocation belongs to the receiver now and must not be deleted
+	// if this process is interrupted
+	untrackConn(a.Control)
+	a.logger.Debugf("[conn %s] handed off allocation via %s", ConnID(a.Control), path)
+	return nil
+}
+
+// ReceiveTCPAllocation takes over an allocation from a process calling
+// Handoff on the unix socket at path
+func ReceiveTCPAllocation(logger DebugLogger, path string, timeout time.Duration) (*TCPAllocation, error) {
+	conn, err := net.DialTimeout("unix", path, timeout)
+	if err != nil {
+		return nil, fmt.Errorf("could not connect to %s: %w", path, err)
+	}
+	defer conn.Close()
+	unixConn, ok := conn.(*net.UnixConn)
+	if !ok {
+		return nil, fmt.Errorf("could not cast handoff connection to UnixConn")
+	}
+
+	if err := unixConn.SetReadDeadline(time.Now().Add(timeout)); err != nil {
+		return nil, fmt.Errorf("could not set deadline: %w", err)
+	}
+	buf := make([]byte, 64*1024)
+	oob := make([]byte, syscall.CmsgSpace(4))
+	n, oobn, _, _, err := unixConn.ReadMsgUnix(buf, oob)
+	if err != nil {
+		return nil, fmt.Errorf("could not receive allocation: %w", err)
+	}
+	messages, err := syscall.ParseSocketControlMessage(oob[:oobn])
+	if err != nil {
+		return nil, fmt.Errorf("could not parse control message: %w", err)
+	}
+	if len(messages) != 1 {
+		return nil, fmt.Errorf("expected a single control message but got %d", len(messages))
+	}
+	fds, err := syscall.ParseUnixRights(&messages[0])
+	if err != nil {
+		return nil, fmt.Errorf("could not parse file descriptors: %w", err)
+	}
+	if len(fds) != 1 {
+		for _, fd := range fds {
+			syscall.Close(fd)
+		}
+		return nil, fmt.Errorf("expected a single file descriptor but got %d", len(fds))
+	}
+	f := os.NewFile(uintptr(fds[0]), "control")
+	// FileConn duplicates the descriptor
+	defer f.Close()
+
+	var state handoffState
+	if err := json.Unmarshal(buf[:n], &state); err != nil {
+		return nil, fmt.Errorf("could not decode allocation state: %w", err)
+	}
+
+	controlConnectionRaw, err := net.FileConn(f)
+	if err != nil {
+		return nil, fmt.Errorf("could not create control connection: %w", err)
+	}
+	controlConnection, ok := controlConnectionRaw.(*net.TCPConn)
+	if !ok {
+		controlConnectionRaw.Close()
+		return nil, fmt.Errorf("could not cast control connection to TCPConn")
+	}
+	logger.Debugf("[conn %s] received allocation from %s to %s via %s", ConnID(controlConnection), controlConnection.LocalAddr().String(), controlConnection.RemoteAddr().String(), path)
+	a := state.allocation(logger, controlConnection, timeout)
+	// the allocation was granted to the sending process
+	trackAllocation(controlConnection, a.username, a.password, a.realm, a.nonce)
+	return a, nil
+}
@@ -0,0 +1,93 @@
+//go:build !windows
+
+package internal
+
+import (
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestTCPAllocationHandoff(t *testing.T) {
+	t.Parallel()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+
+	client, err := net.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	server, err := ln.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server.Close()
+
+	a := &TCPAllocation{
+		Control:    client.(*net.TCPConn),
+		logger:     nilLogger{},
+		turnServer: ln.Addr().String(),
+		timeout:    time.Second,
+		username:   "user",
+		password:   "pass",
+		realm:      "realm",
+		nonce:      "nonce",
+	}
+
+	path := filepath.Join(t.TempDir(), "handoff.sock")
+	errc := make(chan error, 1)
+	go func() {
+		errc <- a.Handoff(path)
+	}()
+	for i := 0; ; i++ {
+		if _, err := os.Stat(path); err == nil {
+			break
+		}
+		if i > 100 {
+			t.Fatal("handoff socket was not created")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	received, err := ReceiveTCPAllocation(nilLogger{}, path, time.Second)
+	if err != nil {
+		t.Fatalf("ReceiveTCPAllocation() error = %v", err)
+	}
+	defer received.Close()
+	if err := <-errc; err != nil {
+		t.Fatalf("Handoff() error = %v", err)
+	}
+	if got, want := received.state(), a.state(); got != want {
+		t.Errorf("received state %+v, want %+v", got, want)
+	}
+	if got, want := received.Control.LocalAddr().String(), client.LocalAddr().String(); got != want {
+		t.Errorf("received connection from %s, want %s", got, want)
+	}
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Errorf("handoff socket was not removed")
+	}
+
+	// the connection stays open in the receiving process
+	if err := a.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := received.Control.Write([]byte("ping")); err != nil {
+		t.Fatal(err)
+	}
+	buf := make([]byte, 4)
+	if err := server.SetReadDeadline(time.Now().Add(time.Second)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := io.ReadFull(server, buf); err != nil {
+		t.Fatal(err)
+	}
+	if string(buf) != "ping" {
+		t.Errorf("server received %q, want ping", buf)
+	}
+}
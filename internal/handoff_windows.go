@@ -0,0 +1,19 @@
+//go:build windows
+
+package internal
+
+import (
+	"fmt"
+	"time"
+)
+
+// Handoff is not supported on windows as sockets can not be passed to
+// other processes via unix sockets
+func (a *TCPAllocation) Handoff(path string) error {
+	return fmt.Errorf("allocation handoff is not supported on windows")
+}
+
+// ReceiveTCPAllocation is not supported on windows
+func ReceiveTCPAllocation(logger DebugLogger, path string, timeout time.Duration) (*TCPAllocation, error) {
+	return nil, fmt.Errorf("allocation handoff is not supported on windows")
+}
@@ -0,0 +1,116 @@
+package helper
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net/netip"
+	"os"
+	"strings"
+)
+
+// ACLRule allows or denies a range and, if set, only the given ports
+type ACLRule struct {
+	Allow  bool
+	Prefix netip.Prefix
+	// Ports are the ports the rule applies to. All ports if empty
+	Ports []PortRange
+}
+
+// ACL are the destinations that may be reached through the proxy. The
+// rules are checked in order and the first matching rule decides
+type ACL struct {
+	Rules []ACLRule
+}
+
+// ReadACL reads an ACL file
+func ReadACL(filename string) (*ACL, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return ParseACL(f)
+}
+
+// ParseACL parses an ACL with one rule per line. Empty lines and lines
+// starting with # are skipped
+//
+//	deny 10.10.99.0/24              deny a range on all ports
+//	allow 10.10.0.0/16 22,80,443    allow a range on some ports
+//	allow 192.168.5.10              allow a single address
+func ParseACL(r io.Reader) (*ACL, error) {
+	a := &ACL{}
+	scanner := bufio.NewScanner(r)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		fields := strings.Fields(text)
+		if len(fields) < 2 || len(fields) > 3 {
+			return nil, fmt.Errorf("line %d: rules need the format \"allow|deny range [ports]\"", line)
+		}
+		var rule ACLRule
+		switch strings.ToLower(fields[0]) {
+		case "allow":
+			rule.Allow = true
+		case "deny":
+		default:
+			return nil, fmt.Errorf("line %d: unknown rule %q. Supported rules: allow and deny", line, fields[0])
+		}
+		prefix, err := parseScopePrefix(fields[1])
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", line, err)
+		}
+		rule.Prefix = prefix
+		if len(fields) == 3 {
+			rule.Ports, err = parsePortRanges(fields[2])
+			if err != nil {
+				return nil, fmt.Errorf("line %d: %w", line, err)
+			}
+		}
+		a.Rules = append(a.Rules, rule)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if len(a.Rules) == 0 {
+		return nil, fmt.Errorf("the ACL contains no rules")
+	}
+	return a, nil
+}
+
+// Allowed returns true if the first rule matching the destination allows
+// it. Destinations no rule matches are denied if the ACL contains allow
+// rules, so a list of deny rules only blocks the listed ranges. A port of
+// 0 only checks the address. A nil ACL allows all destinations
+func (a *ACL) Allowed(ip netip.Addr, port uint16) bool {
+	if a == nil {
+		return true
+	}
+	ip = ip.Unmap()
+	hasAllow := false
+	for _, rule := range a.Rules {
+		hasAllow = hasAllow || rule.Allow
+		if rule.matches(ip, port) {
+			return rule.Allow
+		}
+	}
+	return !hasAllow
+}
+
+func (r ACLRule) matches(ip netip.Addr, port uint16) bool {
+	if !r.Prefix.Contains(ip) {
+		return false
+	}
+	if port == 0 || len(r.Ports) == 0 {
+		return true
+	}
+	for _, p := range r.Ports {
+		if port >= p.From && port <= p.To {
+			return true
+		}
+	}
+	return false
+}
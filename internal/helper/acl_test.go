@@ -0,0 +1,80 @@
+package helper
+
+import (
+	"net/netip"
+	"strings"
+	"testing"
+)
+
+const testACL = `# in scope hosts
+deny 10.10.99.0/24
+allow 10.10.0.0/16 22,80,8000-8100
+ALLOW 192.168.5.10
+`
+
+func TestParseACL(t *testing.T) {
+	t.Parallel()
+	a, err := ParseACL(strings.NewReader(testACL))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		addr string
+		port uint16
+		want bool
+	}{
+		{"10.10.1.1", 22, true},
+		{"10.10.1.1", 8050, true},
+		{"10.10.1.1", 443, false},
+		{"10.10.1.1", 0, true},
+		{"10.10.99.1", 22, false},
+		{"::ffff:10.10.1.1", 80, true},
+		{"192.168.5.10", 443, true},
+		{"192.168.5.11", 443, false},
+		{"fd00::1", 22, false},
+	}
+	for _, tt := range tests {
+		if got := a.Allowed(netip.MustParseAddr(tt.addr), tt.port); got != tt.want {
+			t.Errorf("%s:%d: got %t, want %t", tt.addr, tt.port, got, tt.want)
+		}
+	}
+}
+
+func TestACLDenyOnly(t *testing.T) {
+	t.Parallel()
+	a, err := ParseACL(strings.NewReader("deny 10.0.0.0/8\ndeny 192.168.1.1 445"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if a.Allowed(netip.MustParseAddr("10.1.1.1"), 80) {
+		t.Errorf("expected a denied range to be denied")
+	}
+	if a.Allowed(netip.MustParseAddr("192.168.1.1"), 445) {
+		t.Errorf("expected a denied port to be denied")
+	}
+	if !a.Allowed(netip.MustParseAddr("192.168.1.1"), 80) || !a.Allowed(netip.MustParseAddr("172.16.0.1"), 80) {
+		t.Errorf("expected destinations without a rule to be allowed")
+	}
+	var none *ACL
+	if !none.Allowed(netip.MustParseAddr("10.1.1.1"), 80) {
+		t.Errorf("expected a nil ACL to allow everything")
+	}
+}
+
+func TestParseACLFail(t *testing.T) {
+	t.Parallel()
+	for _, acl := range []string{
+		"",
+		"# only comments",
+		"allow",
+		"permit 10.0.0.0/8",
+		"allow 10.0.0.0/33",
+		"allow acme.internal",
+		"allow 10.0.0.0/8 http",
+		"allow 10.0.0.0/8 80 443",
+	} {
+		if _, err := ParseACL(strings.NewReader(acl)); err == nil {
+			t.Errorf("expected an error on %q", acl)
+		}
+	}
+}
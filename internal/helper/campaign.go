@@ -0,0 +1,74 @@
+package helper
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// CampaignTarget is a TURN server with its credentials
+type CampaignTarget struct {
+	// TurnServer is in the format host:port or a TURN URI
+	TurnServer string
+	Username   string
+	Password   string
+	// Realm is the expected realm of the server. Can be empty
+	Realm string
+}
+
+// ReadCampaignTargets reads the targets from a CSV file
+func ReadCampaignTargets(filename string) ([]CampaignTarget, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return ParseCampaignTargets(f)
+}
+
+// ParseCampaignTargets parses CSV records in the format
+// turnserver,username,password[,realm]. Lines starting with # and a header
+// line starting with turnserver are skipped
+func ParseCampaignTargets(r io.Reader) ([]CampaignTarget, error) {
+	reader := csv.NewReader(r)
+	reader.Comment = '#'
+	reader.FieldsPerRecord = -1
+	reader.TrimLeadingSpace = true
+
+	var ret []CampaignTarget
+	for {
+		record, err := reader.Read()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("invalid CSV: %w", err)
+		}
+		line, _ := reader.FieldPos(0)
+		if len(ret) == 0 && strings.EqualFold(strings.TrimSpace(record[0]), "turnserver") {
+			continue
+		}
+		if len(record) < 3 || len(record) > 4 {
+			return nil, fmt.Errorf("line %d: expected turnserver,username,password[,realm] but got %d fields", line, len(record))
+		}
+		t := CampaignTarget{
+			TurnServer: strings.TrimSpace(record[0]),
+			Username:   record[1],
+			Password:   record[2],
+		}
+		if len(record) == 4 {
+			t.Realm = strings.TrimSpace(record[3])
+		}
+		if t.TurnServer == "" || t.Username == "" || t.Password == "" {
+			return nil, fmt.Errorf("line %d: turnserver, username and password must not be empty", line)
+		}
+		ret = append(ret, t)
+	}
+	if len(ret) == 0 {
+		return nil, fmt.Errorf("no targets found")
+	}
+	return ret, nil
+}
@@ -0,0 +1,50 @@
+package helper
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseCampaignTargets(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		name    string
+		input   string
+		want    []CampaignTarget
+		wantErr bool
+	}{
+		{
+			name:  "header and comments",
+			input: "turnserver,username,password,realm\n# lab\n10.0.0.1:3478,user,pass,example.com\n\"turns:sbc.example.com?transport=tcp\",user2,\"p,ss\"\n",
+			want: []CampaignTarget{
+				{TurnServer: "10.0.0.1:3478", Username: "user", Password: "pass", Realm: "example.com"},
+				{TurnServer: "turns:sbc.example.com?transport=tcp", Username: "user2", Password: "p,ss"},
+			},
+		},
+		{
+			name:  "password with spaces",
+			input: "10.0.0.1:3478, user, pass word \n",
+			want: []CampaignTarget{
+				{TurnServer: "10.0.0.1:3478", Username: "user", Password: "pass word "},
+			},
+		},
+		{name: "missing password", input: "10.0.0.1:3478,user\n", wantErr: true},
+		{name: "too many fields", input: "10.0.0.1:3478,user,pass,realm,extra\n", wantErr: true},
+		{name: "empty username", input: "10.0.0.1:3478,,pass\n", wantErr: true},
+		{name: "only header", input: "turnserver,username,password\n", wantErr: true},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			got, err := ParseCampaignTargets(strings.NewReader(tt.input))
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseCampaignTargets() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ParseCampaignTargets() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
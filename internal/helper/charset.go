@@ -0,0 +1,51 @@
+package helper
+
+import (
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"golang.org/x/text/runes"
+	"golang.org/x/text/transform"
+	"golang.org/x/text/unicode/norm"
+)
+
+// utf8BOM is written in front of files by some editors on Windows
+const utf8BOM = "\ufeff"
+
+// NormalizeCredential returns a line of a wordlist as UTF-8. A byte order
+// mark is removed and lines that are not valid UTF-8 are decoded as
+// Latin-1, which most legacy wordlists are encoded in
+func NormalizeCredential(s string) string {
+	s = strings.TrimPrefix(s, utf8BOM)
+	if utf8.ValidString(s) {
+		return s
+	}
+	var b strings.Builder
+	b.Grow(len(s) * 2)
+	for i := 0; i < len(s); i++ {
+		b.WriteRune(rune(s[i]))
+	}
+	return b.String()
+}
+
+// FoldASCII removes the diacritics from s, so münchen becomes munchen.
+// Characters without an ASCII base letter are kept
+func FoldASCII(s string) string {
+	t := transform.Chain(norm.NFD, runes.Remove(runes.In(unicode.Mn)), norm.NFC)
+	folded, _, err := transform.String(t, s)
+	if err != nil {
+		return s
+	}
+	return folded
+}
+
+// isASCII returns true if s only contains ASCII characters
+func isASCII(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if s[i] >= utf8.RuneSelf {
+			return false
+		}
+	}
+	return true
+}
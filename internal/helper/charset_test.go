@@ -0,0 +1,40 @@
+package helper
+
+import "testing"
+
+func TestNormalizeCredential(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		in       string
+		expected string
+	}{
+		{"password", "password"},
+		{"\ufeffpassword", "password"},
+		{"passwört", "passwört"},
+		// passwört in Latin-1
+		{"passw\xf6rt", "passwört"},
+	}
+	for _, tt := range tests {
+		if x := NormalizeCredential(tt.in); x != tt.expected {
+			t.Errorf("%q: expected %q, got %q", tt.in, tt.expected, x)
+		}
+	}
+}
+
+func TestFoldASCII(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		in       string
+		expected string
+	}{
+		{"münchen", "munchen"},
+		{"téléphone", "telephone"},
+		{"straße", "straße"},
+		{"example", "example"},
+	}
+	for _, tt := range tests {
+		if x := FoldASCII(tt.in); x != tt.expected {
+			t.Errorf("%q: expected %q, got %q", tt.in, tt.expected, x)
+		}
+	}
+}
@@ -12,10 +12,43 @@ var ErrTimeout = errors.New("timeout occurred. you can try to increase the timeo
 
 // ConnectionRead reads all data from a connection
 func ConnectionRead(conn net.Conn, timeout time.Duration) ([]byte, error) {
+	audit := currentDeadlineAudit()
+	if audit == nil {
+		return connectionRead(conn, timeout)
+	}
+	start := time.Now()
+	ret, err := connectionRead(conn, timeout)
+	audit.check("read", conn, timeout, time.Since(start), err)
+	return ret, err
+}
+
+func connectionRead(conn net.Conn, timeout time.Duration) ([]byte, error) {
+	ret, _, err := connectionReadLimit(conn, timeout, 0)
+	return ret, err
+}
+
+// ConnectionReadResponse reads the response of a probed service like
+// ConnectionRead but stops after the current response limit. truncated is
+// true if the limit was reached, the rest of the response stays unread
+func ConnectionReadResponse(conn net.Conn, timeout time.Duration) (data []byte, truncated bool, err error) {
+	limit := currentResponseLimit()
+	audit := currentDeadlineAudit()
+	if audit == nil {
+		return connectionReadLimit(conn, timeout, limit)
+	}
+	start := time.Now()
+	data, truncated, err = connectionReadLimit(conn, timeout, limit)
+	audit.check("read", conn, timeout, time.Since(start), err)
+	return data, truncated, err
+}
+
+// connectionReadLimit reads all data from a connection but at most limit
+// bytes. A limit of 0 reads everything
+func connectionReadLimit(conn net.Conn, timeout time.Duration, limit int) ([]byte, bool, error) {
 	var ret []byte
 
 	if err := conn.SetReadDeadline(time.Now().Add(timeout)); err != nil {
-		return nil, fmt.Errorf("could not set read deadline: %w", err)
+		return nil, false, fmt.Errorf("could not set read deadline: %w", err)
 	}
 
 	bufLen := 1024
@@ -26,22 +59,86 @@ func ConnectionRead(conn net.Conn, timeout time.Duration) ([]byte, error) {
 			if err != io.EOF {
 				// also return read data on timeout so caller can use it
 				if netErr, ok := err.(net.Error); ok && netErr.Timeout() {
-					return ret, ErrTimeout
+					return ret, false, ErrTimeout
 				}
-				return nil, err
+				return nil, false, err
 			}
-			return ret, nil
+			return ret, false, nil
+		}
+		if limit > 0 && len(ret)+i >= limit {
+			// a full read at the limit may be followed by more data
+			truncated := len(ret)+i > limit || i == bufLen
+			return append(ret, buf[:limit-len(ret)]...), truncated, nil
 		}
 		ret = append(ret, buf[:i]...)
 		// we've read all data, bail out
 		if i < bufLen {
-			return ret, nil
+			return ret, false, nil
 		}
 	}
 }
 
+// ConnectionReadMessage reads a single length prefixed message from a
+// stream. The first headerLen bytes are read and length returns the size of
+// the whole message from them. The message is read across as many reads as
+// needed until the timeout, so messages split by the kernel or into several
+// TLS records are returned complete and following messages stay unread
+func ConnectionReadMessage(conn net.Conn, headerLen int, length func(header []byte) (int, error), timeout time.Duration) ([]byte, error) {
+	audit := currentDeadlineAudit()
+	if audit == nil {
+		return connectionReadMessage(conn, headerLen, length, timeout)
+	}
+	start := time.Now()
+	ret, err := connectionReadMessage(conn, headerLen, length, timeout)
+	audit.check("read", conn, timeout, time.Since(start), err)
+	return ret, err
+}
+
+func connectionReadMessage(conn net.Conn, headerLen int, length func(header []byte) (int, error), timeout time.Duration) ([]byte, error) {
+	if err := conn.SetReadDeadline(time.Now().Add(timeout)); err != nil {
+		return nil, fmt.Errorf("could not set read deadline: %w", err)
+	}
+
+	header := make([]byte, headerLen)
+	if _, err := io.ReadFull(conn, header); err != nil {
+		return nil, readError(err)
+	}
+	total, err := length(header)
+	if err != nil {
+		return nil, err
+	}
+	if total < headerLen {
+		return nil, fmt.Errorf("invalid message length %d", total)
+	}
+	ret := make([]byte, total)
+	copy(ret, header)
+	if _, err := io.ReadFull(conn, ret[headerLen:]); err != nil {
+		return nil, readError(err)
+	}
+	return ret, nil
+}
+
+// readError maps timeouts to ErrTimeout
+func readError(err error) error {
+	if netErr, ok := err.(net.Error); ok && netErr.Timeout() {
+		return ErrTimeout
+	}
+	return err
+}
+
 // ConnectionWrite makes sure to write all data to a connection
 func ConnectionWrite(conn net.Conn, data []byte, timeout time.Duration) error {
+	audit := currentDeadlineAudit()
+	if audit == nil {
+		return connectionWrite(conn, data, timeout)
+	}
+	start := time.Now()
+	err := connectionWrite(conn, data, timeout)
+	audit.check("write", conn, timeout, time.Since(start), err)
+	return err
+}
+
+func connectionWrite(conn net.Conn, data []byte, timeout time.Duration) error {
 	toWriteLeft := len(data)
 	written := 0
 	err := conn.SetWriteDeadline(time.Now().Add(timeout))
@@ -0,0 +1,73 @@
+package helper
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"net"
+	"testing"
+	"time"
+)
+
+func prefixLength(header []byte) (int, error) {
+	return 2 + int(binary.BigEndian.Uint16(header)), nil
+}
+
+func TestConnectionReadMessage(t *testing.T) {
+	t.Parallel()
+	client, server := net.Pipe()
+	defer client.Close()
+	defer server.Close()
+
+	go func() {
+		// the first message arrives in three parts, the second one
+		// together with the end of the first
+		for _, part := range [][]byte{{0x00}, {0x03, 'a'}, {'b', 'c', 0x00, 0x01, 'd'}} {
+			if _, err := server.Write(part); err != nil {
+				return
+			}
+		}
+	}()
+
+	for _, want := range []string{"abc", "d"} {
+		msg, err := ConnectionReadMessage(client, 2, prefixLength, time.Second)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := string(msg[2:]); got != want {
+			t.Errorf("got message %q, want %q", got, want)
+		}
+	}
+
+	if _, err := ConnectionReadMessage(client, 2, prefixLength, 10*time.Millisecond); !errors.Is(err, ErrTimeout) {
+		t.Errorf("expected a timeout, got %v", err)
+	}
+}
+
+func TestConnectionReadLimit(t *testing.T) {
+	t.Parallel()
+	data := bytes.Repeat([]byte("x"), 3000)
+	for _, tt := range []struct {
+		limit     int
+		size      int
+		truncated bool
+	}{
+		{limit: 0, size: 3000},
+		{limit: 5000, size: 3000},
+		{limit: 1500, size: 1500, truncated: true},
+	} {
+		client, server := net.Pipe()
+		go func() {
+			_, _ = server.Write(data)
+		}()
+		got, truncated, err := connectionReadLimit(client, time.Second, tt.limit)
+		client.Close()
+		server.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(got) != tt.size || truncated != tt.truncated {
+			t.Errorf("limit %d: expected %d bytes and truncated %t, got %d bytes and truncated %t", tt.limit, tt.size, tt.truncated, len(got), truncated)
+		}
+	}
+}
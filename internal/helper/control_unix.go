@@ -0,0 +1,17 @@
+//go:build !windows
+
+package helper
+
+import (
+	"fmt"
+	"net"
+)
+
+// ListenControl listens on the TCP address of the control API. Custom
+// security descriptors are only supported for named pipes on windows
+func ListenControl(address, sddl string) (net.Listener, error) {
+	if sddl != "" {
+		return nil, fmt.Errorf("security descriptors are only supported for named pipes on windows")
+	}
+	return net.Listen("tcp", address)
+}
@@ -0,0 +1,36 @@
+//go:build windows
+
+package helper
+
+import (
+	"fmt"
+	"net"
+	"strings"
+
+	"github.com/Microsoft/go-winio"
+)
+
+// controlPipeSDDL grants access to the named pipe only to the user running
+// stunner, SYSTEM and the administrators
+const controlPipeSDDL = "D:P(A;;GA;;;OW)(A;;GA;;;SY)(A;;GA;;;BA)"
+
+// ListenControl listens on the address of the control API. Addresses like
+// \\.\pipe\stunner create a named pipe protected by the security descriptor
+// sddl, all other addresses are TCP addresses
+func ListenControl(address, sddl string) (net.Listener, error) {
+	if !IsNamedPipe(address) {
+		if sddl != "" {
+			return nil, fmt.Errorf("security descriptors are only supported for named pipes")
+		}
+		return net.Listen("tcp", address)
+	}
+	if sddl == "" {
+		sddl = controlPipeSDDL
+	}
+	return winio.ListenPipe(address, &winio.PipeConfig{SecurityDescriptor: sddl})
+}
+
+// IsNamedPipe returns true if address is the path of a local named pipe
+func IsNamedPipe(address string) bool {
+	return strings.HasPrefix(strings.ToLower(address), `\\.\pipe\`)
+}
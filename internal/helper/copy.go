@@ -0,0 +1,127 @@
+package helper
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"time"
+)
+
+// DefaultCopyBufferSize is the buffer size of CopyContext if none is set
+const DefaultCopyBufferSize = 32 * 1024
+
+// copyPollInterval is the longest a read blocks before the context is
+// checked again
+const copyPollInterval = 500 * time.Millisecond
+
+// CopyOptions are the limits of a copy
+type CopyOptions struct {
+	// BufferSize is the size of a single read. DefaultCopyBufferSize is
+	// used if 0
+	BufferSize int
+	// WriteTimeout is the longest a single write may block because the
+	// destination does not read the data fast enough. 0 disables it
+	WriteTimeout time.Duration
+}
+
+type readDeadliner interface {
+	SetReadDeadline(t time.Time) error
+}
+
+type writeDeadliner interface {
+	SetWriteDeadline(t time.Time) error
+}
+
+// copier reads and writes in chunks bounded by deadlines, so a blocked
+// connection notices a done context within copyPollInterval
+type copier struct {
+	ctx  context.Context
+	dst  io.Writer
+	src  io.Reader
+	opts CopyOptions
+}
+
+func newCopier(ctx context.Context, dst io.Writer, src io.Reader, opts CopyOptions) *copier {
+	if opts.BufferSize <= 0 {
+		opts.BufferSize = DefaultCopyBufferSize
+	}
+	return &copier{
+		ctx:  ctx,
+		dst:  dst,
+		src:  src,
+		opts: opts,
+	}
+}
+
+// read reads into buf until data arrives or the context is done. Sources
+// without deadlines block until data arrives and only check the context
+// in between
+func (c *copier) read(buf []byte) (int, error) {
+	rd, hasDeadline := c.src.(readDeadliner)
+	for {
+		if err := c.ctx.Err(); err != nil {
+			return 0, err
+		}
+		if hasDeadline {
+			if err := rd.SetReadDeadline(time.Now().Add(copyPollInterval)); err != nil {
+				return 0, fmt.Errorf("could not set read deadline: %w", err)
+			}
+		}
+		n, err := c.src.Read(buf)
+		var netErr net.Error
+		if hasDeadline && errors.As(err, &netErr) && netErr.Timeout() {
+			if n > 0 {
+				return n, nil
+			}
+			continue
+		}
+		return n, err
+	}
+}
+
+// write writes b with the write timeout if the destination supports
+// deadlines
+func (c *copier) write(b []byte) (int, error) {
+	if wd, ok := c.dst.(writeDeadliner); ok && c.opts.WriteTimeout > 0 {
+		if err := wd.SetWriteDeadline(time.Now().Add(c.opts.WriteTimeout)); err != nil {
+			return 0, fmt.Errorf("could not set write deadline: %w", err)
+		}
+	}
+	n, err := c.dst.Write(b)
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return n, fmt.Errorf("destination did not accept data for %s: %w", c.opts.WriteTimeout, ErrTimeout)
+	}
+	if err == nil && n != len(b) {
+		return n, io.ErrShortWrite
+	}
+	return n, err
+}
+
+// CopyContext copies from src to dst until EOF like io.Copy. The copy
+// stops with the error of the context once it is done and enforces the
+// limits of opts. Blocked reads are only interrupted if src supports read
+// deadlines
+func CopyContext(ctx context.Context, dst io.Writer, src io.Reader, opts CopyOptions) (int64, error) {
+	c := newCopier(ctx, dst, src, opts)
+	buf := make([]byte, c.opts.BufferSize)
+	var written int64
+	for {
+		n, readErr := c.read(buf)
+		if n > 0 {
+			m, err := c.write(buf[:n])
+			written += int64(m)
+			if err != nil {
+				return written, err
+			}
+		}
+		if readErr == io.EOF {
+			return written, nil
+		}
+		if readErr != nil {
+			return written, readErr
+		}
+	}
+}
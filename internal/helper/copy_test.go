@@ -0,0 +1,61 @@
+package helper
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCopyContext(t *testing.T) {
+	t.Parallel()
+	var dst bytes.Buffer
+	n, err := CopyContext(context.Background(), &dst, strings.NewReader("0123456789"), CopyOptions{BufferSize: 3})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 10 || dst.String() != "0123456789" {
+		t.Errorf("copied %d bytes: %q", n, dst.String())
+	}
+}
+
+func TestCopyContextCancel(t *testing.T) {
+	t.Parallel()
+	client, server := net.Pipe()
+	defer client.Close()
+	defer server.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() {
+		// nothing is ever written to client, so the read blocks
+		_, err := CopyContext(ctx, &bytes.Buffer{}, server, CopyOptions{})
+		done <- err
+	}()
+	time.Sleep(50 * time.Millisecond)
+	cancel()
+	select {
+	case err := <-done:
+		if !errors.Is(err, context.Canceled) {
+			t.Errorf("expected context.Canceled, got %v", err)
+		}
+	case <-time.After(2 * copyPollInterval):
+		t.Fatal("copy did not stop after the context was canceled")
+	}
+}
+
+func TestCopyContextWriteTimeout(t *testing.T) {
+	t.Parallel()
+	client, server := net.Pipe()
+	defer client.Close()
+	defer server.Close()
+
+	// nobody reads from client, so the write to server blocks
+	_, err := CopyContext(context.Background(), server, strings.NewReader("data"), CopyOptions{WriteTimeout: 50 * time.Millisecond})
+	if !errors.Is(err, ErrTimeout) {
+		t.Errorf("expected ErrTimeout, got %v", err)
+	}
+}
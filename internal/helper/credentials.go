@@ -0,0 +1,339 @@
+package helper
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultRESTTTL is the lifetime of credentials derived by RESTProvider
+const DefaultRESTTTL = 24 * time.Hour
+
+// CredentialTimeout is the time a provider may take to return the
+// credentials. Commands like the AWS CLI can take a few seconds
+const CredentialTimeout = 30 * time.Second
+
+// Credentials are the long term credentials of a TURN server
+type Credentials struct {
+	Username string `json:"username"`
+	Password string `json:"password"`
+}
+
+// CredentialProvider returns the current credentials of a TURN server.
+// Providers are asked again on every new allocation, so rotated
+// credentials are picked up without a restart
+type CredentialProvider interface {
+	Credentials(ctx context.Context) (Credentials, error)
+	// String describes the source of the credentials for logging. It must
+	// not contain any secrets
+	String() string
+}
+
+// EnvProvider reads the credentials from environment variables
+type EnvProvider struct {
+	UsernameVar string
+	PasswordVar string
+}
+
+func (p EnvProvider) Credentials(_ context.Context) (Credentials, error) {
+	c := Credentials{
+		Username: os.Getenv(p.UsernameVar),
+		Password: os.Getenv(p.PasswordVar),
+	}
+	if c.Username == "" || c.Password == "" {
+		return Credentials{}, fmt.Errorf("environment variables %s and %s need to be set", p.UsernameVar, p.PasswordVar)
+	}
+	return c, nil
+}
+
+func (p EnvProvider) String() string {
+	return fmt.Sprintf("env:%s:%s", p.UsernameVar, p.PasswordVar)
+}
+
+// FileProvider reads the credentials from a file on every request. See
+// ParseCredentials for the format
+type FileProvider struct {
+	Path string
+}
+
+func (p FileProvider) Credentials(_ context.Context) (Credentials, error) {
+	data, err := os.ReadFile(p.Path)
+	if err != nil {
+		return Credentials{}, fmt.Errorf("could not read credentials: %w", err)
+	}
+	return ParseCredentials(data)
+}
+
+func (p FileProvider) String() string {
+	return "file:" + p.Path
+}
+
+// ExecProvider runs a command and reads the credentials from its output.
+// See ParseCredentials for the format
+type ExecProvider struct {
+	Command []string
+	Timeout time.Duration
+}
+
+func (p ExecProvider) Credentials(ctx context.Context) (Credentials, error) {
+	if len(p.Command) == 0 {
+		return Credentials{}, fmt.Errorf("no command to get the credentials from")
+	}
+	if p.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, p.Timeout)
+		defer cancel()
+	}
+	var stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, p.Command[0], p.Command[1:]...)
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return Credentials{}, fmt.Errorf("could not run %s: %w: %s", p.Command[0], err, strings.TrimSpace(stderr.String()))
+	}
+	return ParseCredentials(out)
+}
+
+func (p ExecProvider) String() string {
+	return "exec:" + strings.Join(p.Command, " ")
+}
+
+// VaultProvider reads the credentials from the fields username and
+// password of a secret in HashiCorp Vault. KV version 1 and 2 secrets are
+// supported
+type VaultProvider struct {
+	// Address is the URL of the Vault server like https://vault:8200
+	Address string
+	Token   string
+	// Path is the API path of the secret without /v1/, for example
+	// secret/data/turn for the KV version 2 secret turn on the mount secret
+	Path   string
+	Client *http.Client
+}
+
+func (p VaultProvider) Credentials(ctx context.Context) (Credentials, error) {
+	u, err := url.JoinPath(p.Address, "v1", p.Path)
+	if err != nil {
+		return Credentials{}, fmt.Errorf("invalid vault address %q: %w", p.Address, err)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return Credentials{}, err
+	}
+	req.Header.Set("X-Vault-Token", p.Token)
+	client := p.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return Credentials{}, fmt.Errorf("could not read secret from vault: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return Credentials{}, fmt.Errorf("could not read secret %s from vault: %s", p.Path, resp.Status)
+	}
+
+	var secret struct {
+		Data json.RawMessage `json:"data"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&secret); err != nil {
+		return Credentials{}, fmt.Errorf("invalid response from vault: %w", err)
+	}
+	// KV version 2 wraps the secret in another data field
+	var kv2 struct {
+		Data *Credentials `json:"data"`
+	}
+	if err := json.Unmarshal(secret.Data, &kv2); err == nil && kv2.Data != nil {
+		return checkCredentials(*kv2.Data)
+	}
+	var c Credentials
+	if err := json.Unmarshal(secret.Data, &c); err != nil {
+		return Credentials{}, fmt.Errorf("invalid secret in vault: %w", err)
+	}
+	return checkCredentials(c)
+}
+
+func (p VaultProvider) String() string {
+	return "vault:" + p.Path
+}
+
+// RESTProvider derives time-limited credentials from the shared secret of
+// the TURN REST API, configured with use-auth-secret in coturn. New
+// credentials are derived on every request
+type RESTProvider struct {
+	Secret string
+	// User is appended to the expiry in the username. Can be empty
+	User string
+	TTL  time.Duration
+}
+
+func (p RESTProvider) Credentials(_ context.Context) (Credentials, error) {
+	username := strconv.FormatInt(time.Now().Add(p.TTL).Unix(), 10)
+	if p.User != "" {
+		username += ":" + p.User
+	}
+	mac := hmac.New(sha1.New, []byte(p.Secret))
+	if _, err := mac.Write([]byte(username)); err != nil {
+		return Credentials{}, err
+	}
+	return Credentials{
+		Username: username,
+		Password: base64.StdEncoding.EncodeToString(mac.Sum(nil)),
+	}, nil
+}
+
+func (p RESTProvider) String() string {
+	return "rest:" + p.User
+}
+
+// CachedProvider caches the credentials of a provider for a while, so
+// slow providers are not asked on every new connection. Time-limited
+// credentials are renewed once they expire within ExpiryMargin
+type CachedProvider struct {
+	Provider CredentialProvider
+	TTL      time.Duration
+
+	mu      sync.Mutex
+	cached  Credentials
+	expires time.Time
+}
+
+// NewCachedProvider returns a provider caching the credentials of p for ttl
+func NewCachedProvider(p CredentialProvider, ttl time.Duration) *CachedProvider {
+	return &CachedProvider{
+		Provider: p,
+		TTL:      ttl,
+	}
+}
+
+func (p *CachedProvider) Credentials(ctx context.Context) (Credentials, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if time.Now().Before(p.expires) && !p.cached.ExpiresWithin(ExpiryMargin) {
+		return p.cached, nil
+	}
+	c, err := p.Provider.Credentials(ctx)
+	if err != nil {
+		return Credentials{}, err
+	}
+	p.cached = c
+	p.expires = time.Now().Add(p.TTL)
+	return c, nil
+}
+
+func (p *CachedProvider) String() string {
+	return p.Provider.String()
+}
+
+// ParseCredentials parses credentials returned by files and commands.
+// They are either a JSON object with the fields username and password or
+// the username and the password on the first two lines
+func ParseCredentials(data []byte) (Credentials, error) {
+	data = bytes.TrimSpace(data)
+	if bytes.HasPrefix(data, []byte("{")) {
+		var c Credentials
+		if err := json.Unmarshal(data, &c); err != nil {
+			return Credentials{}, fmt.Errorf("invalid credentials: %w", err)
+		}
+		return checkCredentials(c)
+	}
+	lines := strings.SplitN(string(data), "\n", 3)
+	if len(lines) < 2 {
+		return Credentials{}, fmt.Errorf("invalid credentials: expected the username and the password on separate lines")
+	}
+	return checkCredentials(Credentials{
+		Username: strings.TrimRight(lines[0], "\r"),
+		Password: strings.TrimRight(lines[1], "\r"),
+	})
+}
+
+func checkCredentials(c Credentials) (Credentials, error) {
+	if c.Username == "" {
+		return Credentials{}, fmt.Errorf("invalid credentials: missing username")
+	}
+	if c.Password == "" {
+		return Credentials{}, fmt.Errorf("invalid credentials: missing password")
+	}
+	return c, nil
+}
+
+// ParseCredentialProvider parses a provider in one of the formats
+//
+//	env                      STUNNER_USERNAME and STUNNER_PASSWORD
+//	env:USERVAR:PASSVAR      the given environment variables
+//	file:PATH                the file at PATH
+//	exec:COMMAND ARGS        the output of the command
+//	vault:PATH               the secret at PATH on VAULT_ADDR with VAULT_TOKEN
+//	rest:USER[:TTL]          derived from the secret in STUNNER_AUTH_SECRET
+func ParseCredentialProvider(spec string, timeout time.Duration) (CredentialProvider, error) {
+	kind, value, _ := strings.Cut(spec, ":")
+	switch kind {
+	case "env":
+		if value == "" {
+			return EnvProvider{UsernameVar: "STUNNER_USERNAME", PasswordVar: "STUNNER_PASSWORD"}, nil
+		}
+		user, pass, ok := strings.Cut(value, ":")
+		if !ok || user == "" || pass == "" {
+			return nil, fmt.Errorf("invalid credential provider %q: expected env:USERVAR:PASSVAR", spec)
+		}
+		return EnvProvider{UsernameVar: user, PasswordVar: pass}, nil
+	case "file":
+		if value == "" {
+			return nil, fmt.Errorf("invalid credential provider %q: missing file", spec)
+		}
+		return FileProvider{Path: value}, nil
+	case "exec":
+		command := strings.Fields(value)
+		if len(command) == 0 {
+			return nil, fmt.Errorf("invalid credential provider %q: missing command", spec)
+		}
+		return ExecProvider{Command: command, Timeout: timeout}, nil
+	case "vault":
+		if value == "" {
+			return nil, fmt.Errorf("invalid credential provider %q: missing secret path", spec)
+		}
+		address := os.Getenv("VAULT_ADDR")
+		if address == "" {
+			return nil, fmt.Errorf("VAULT_ADDR needs to be set to use vault")
+		}
+		token := os.Getenv("VAULT_TOKEN")
+		if token == "" {
+			return nil, fmt.Errorf("VAULT_TOKEN needs to be set to use vault")
+		}
+		return VaultProvider{
+			Address: address,
+			Token:   token,
+			Path:    strings.TrimPrefix(value, "/"),
+			Client:  &http.Client{Timeout: timeout},
+		}, nil
+	case "rest":
+		secret := os.Getenv("STUNNER_AUTH_SECRET")
+		if secret == "" {
+			return nil, fmt.Errorf("STUNNER_AUTH_SECRET needs to be set to use rest")
+		}
+		p := RESTProvider{Secret: secret, User: value, TTL: DefaultRESTTTL}
+		if i := strings.LastIndex(value, ":"); i >= 0 {
+			if ttl, err := time.ParseDuration(value[i+1:]); err == nil {
+				if ttl <= 0 {
+					return nil, fmt.Errorf("invalid credential provider %q: the ttl needs to be positive", spec)
+				}
+				p.User, p.TTL = value[:i], ttl
+			}
+		}
+		return p, nil
+	}
+	return nil, fmt.Errorf("invalid credential provider %q. Supported values: env, file, exec, vault and rest", spec)
+}
@@ -0,0 +1,187 @@
+package helper
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha1"
+	"encoding/base64"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestParseCredentials(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		name    string
+		data    string
+		want    Credentials
+		wantErr bool
+	}{
+		{name: "json", data: `{"username":"1700000000:user","password":"secret"}`, want: Credentials{Username: "1700000000:user", Password: "secret"}},
+		{name: "lines", data: "user\r\npass:word\n", want: Credentials{Username: "user", Password: "pass:word"}},
+		{name: "single line", data: "user:pass", wantErr: true},
+		{name: "missing password", data: `{"username":"user"}`, wantErr: true},
+		{name: "invalid json", data: `{"username":`, wantErr: true},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			got, err := ParseCredentials([]byte(tt.data))
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("got error %v, want error %t", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("got %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFileProvider(t *testing.T) {
+	t.Parallel()
+	path := filepath.Join(t.TempDir(), "creds")
+	if err := os.WriteFile(path, []byte("user\nold\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	p := FileProvider{Path: path}
+	if c, err := p.Credentials(context.Background()); err != nil || c.Password != "old" {
+		t.Fatalf("got %+v, %v", c, err)
+	}
+	// rotated credentials are read on the next request
+	if err := os.WriteFile(path, []byte("user\nnew\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if c, err := p.Credentials(context.Background()); err != nil || c.Password != "new" {
+		t.Fatalf("got %+v, %v", c, err)
+	}
+}
+
+func TestVaultProvider(t *testing.T) {
+	t.Parallel()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("X-Vault-Token") != "token" {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		switch r.URL.Path {
+		case "/v1/secret/data/turn":
+			_, _ = w.Write([]byte(`{"data":{"data":{"username":"kv2","password":"pass"},"metadata":{"version":3}}}`))
+		case "/v1/kv/turn":
+			_, _ = w.Write([]byte(`{"data":{"username":"kv1","password":"pass"}}`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer srv.Close()
+
+	for path, want := range map[string]string{"secret/data/turn": "kv2", "kv/turn": "kv1"} {
+		p := VaultProvider{Address: srv.URL, Token: "token", Path: path, Client: srv.Client()}
+		c, err := p.Credentials(context.Background())
+		if err != nil {
+			t.Fatalf("%s: %v", path, err)
+		}
+		if c.Username != want {
+			t.Errorf("%s: got username %q, want %q", path, c.Username, want)
+		}
+	}
+
+	p := VaultProvider{Address: srv.URL, Token: "invalid", Path: "kv/turn", Client: srv.Client()}
+	if _, err := p.Credentials(context.Background()); err == nil {
+		t.Error("expected an error for an invalid token")
+	}
+}
+
+type countingProvider struct {
+	calls int
+}
+
+func (p *countingProvider) Credentials(_ context.Context) (Credentials, error) {
+	p.calls++
+	return Credentials{Username: "user", Password: "pass"}, nil
+}
+
+func (p *countingProvider) String() string {
+	return "counting"
+}
+
+func TestCachedProvider(t *testing.T) {
+	t.Parallel()
+	counter := &countingProvider{}
+	p := NewCachedProvider(counter, time.Hour)
+	for i := 0; i < 3; i++ {
+		if _, err := p.Credentials(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if counter.calls != 1 {
+		t.Errorf("provider was called %d times, want 1", counter.calls)
+	}
+}
+
+func TestCachedProviderRenewsExpiring(t *testing.T) {
+	t.Parallel()
+	// the derived credentials expire within the margin right away
+	p := NewCachedProvider(RESTProvider{Secret: "secret", User: "alice", TTL: time.Minute}, time.Hour)
+	first, err := p.Credentials(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(1100 * time.Millisecond)
+	second, err := p.Credentials(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if first.Username == second.Username {
+		t.Error("expiring credentials were not renewed")
+	}
+}
+
+func TestRESTProvider(t *testing.T) {
+	t.Parallel()
+	p := RESTProvider{Secret: "secret", User: "alice", TTL: time.Hour}
+	c, err := p.Credentials(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	expires, ok := c.Expires()
+	if !ok {
+		t.Fatalf("no expiry in %q", c.Username)
+	}
+	if d := time.Until(expires); d < 59*time.Minute || d > time.Hour {
+		t.Errorf("unexpected expiry in %s", d)
+	}
+	mac := hmac.New(sha1.New, []byte("secret"))
+	mac.Write([]byte(c.Username))
+	if want := base64.StdEncoding.EncodeToString(mac.Sum(nil)); c.Password != want {
+		t.Errorf("got password %q, want %q", c.Password, want)
+	}
+}
+
+func TestParseCredentialProvider(t *testing.T) {
+	t.Parallel()
+	valid := map[string]string{
+		"env":                    "env:STUNNER_USERNAME:STUNNER_PASSWORD",
+		"env:USER:PASS":          "env:USER:PASS",
+		"file:/tmp/creds":        "file:/tmp/creds",
+		"exec:pass show turn/xy": "exec:pass show turn/xy",
+	}
+	for spec, want := range valid {
+		p, err := ParseCredentialProvider(spec, time.Second)
+		if err != nil {
+			t.Fatalf("%s: %v", spec, err)
+		}
+		if p.String() != want {
+			t.Errorf("%s: got %q, want %q", spec, p.String(), want)
+		}
+	}
+	for _, spec := range []string{"", "env:USER", "file:", "exec:", "ldap:x"} {
+		if _, err := ParseCredentialProvider(spec, time.Second); err == nil {
+			t.Errorf("%s: expected an error", spec)
+		}
+	}
+}
@@ -0,0 +1,105 @@
+package helper
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+)
+
+// deadlineTolerance is the difference between the deadline and the actual
+// return of a read or write that is still accepted. Scheduling and timer
+// delays stay well below it
+const deadlineTolerance = 100 * time.Millisecond
+
+// DeadlineAudit checks that reads and writes done with ConnectionRead and
+// ConnectionWrite return in time. Timeouts that fire before the deadline,
+// operations that block past the deadline and timeouts that can never
+// succeed are reported as warnings. This helps to diagnose hangs on flaky
+// relays and connections that ignore deadlines like some DTLS sessions.
+type DeadlineAudit struct {
+	log ErrorLogger
+
+	mu      sync.Mutex
+	checked int
+	early   int
+	late    int
+	invalid int
+}
+
+var (
+	auditMu       sync.RWMutex
+	deadlineAudit *DeadlineAudit
+)
+
+// EnableDeadlineAudit audits all following reads and writes and reports
+// violations to log. A nil log disables the audit
+func EnableDeadlineAudit(log ErrorLogger) *DeadlineAudit {
+	auditMu.Lock()
+	defer auditMu.Unlock()
+	if log == nil {
+		deadlineAudit = nil
+		return nil
+	}
+	deadlineAudit = &DeadlineAudit{log: log}
+	return deadlineAudit
+}
+
+func currentDeadlineAudit() *DeadlineAudit {
+	auditMu.RLock()
+	defer auditMu.RUnlock()
+	return deadlineAudit
+}
+
+// check records a single read or write that took elapsed with the timeout
+func (a *DeadlineAudit) check(op string, conn net.Conn, timeout, elapsed time.Duration, err error) {
+	if a == nil {
+		return
+	}
+	a.mu.Lock()
+	a.checked++
+	a.mu.Unlock()
+
+	peer := "unknown"
+	if conn != nil && conn.LocalAddr() != nil && conn.RemoteAddr() != nil {
+		peer = fmt.Sprintf("%s->%s", conn.LocalAddr(), conn.RemoteAddr())
+	}
+	switch {
+	case timeout <= 0:
+		a.mu.Lock()
+		a.invalid++
+		a.mu.Unlock()
+		a.log.Warnf("deadline audit: %s on %s used a timeout of %s so the deadline was already over", op, peer, timeout)
+	case errors.Is(err, ErrTimeout) && elapsed < timeout-deadlineTolerance:
+		a.mu.Lock()
+		a.early++
+		a.mu.Unlock()
+		a.log.Warnf("deadline audit: %s on %s timed out after %s, before its timeout of %s. The deadline was probably changed concurrently", op, peer, elapsed.Round(time.Millisecond), timeout)
+	case elapsed > timeout+deadlineTolerance:
+		a.mu.Lock()
+		a.late++
+		a.mu.Unlock()
+		a.log.Warnf("deadline audit: %s on %s blocked for %s, exceeding its timeout of %s. The connection does not honor deadlines", op, peer, elapsed.Round(time.Millisecond), timeout)
+	}
+}
+
+// Summary returns the number of audited operations and violations
+func (a *DeadlineAudit) Summary() string {
+	if a == nil {
+		return ""
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return fmt.Sprintf("deadline audit: checked %d reads and writes, %d timed out early, %d blocked past the deadline, %d had an invalid timeout", a.checked, a.early, a.late, a.invalid)
+}
+
+// Violations returns the number of reported violations
+func (a *DeadlineAudit) Violations() int {
+	if a == nil {
+		return 0
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.early + a.late + a.invalid
+}
@@ -0,0 +1,91 @@
+package helper
+
+import (
+	"fmt"
+	"net"
+	"sync"
+	"testing"
+	"time"
+)
+
+type recordLogger struct {
+	mu   sync.Mutex
+	msgs []string
+}
+
+func (l *recordLogger) Errorf(format string, args ...interface{}) {
+	l.Warnf(format, args...)
+}
+
+func (l *recordLogger) Warnf(format string, args ...interface{}) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.msgs = append(l.msgs, fmt.Sprintf(format, args...))
+}
+
+type timeoutError struct{}
+
+func (timeoutError) Error() string   { return "i/o timeout" }
+func (timeoutError) Timeout() bool   { return true }
+func (timeoutError) Temporary() bool { return true }
+
+// auditConn ignores deadlines. Reads sleep for delay and then return
+// err or a single byte
+type auditConn struct {
+	net.Conn
+	delay time.Duration
+	err   error
+}
+
+func (c auditConn) Read(b []byte) (int, error) {
+	time.Sleep(c.delay)
+	if c.err != nil {
+		return 0, c.err
+	}
+	b[0] = 'x'
+	return 1, nil
+}
+
+func (c auditConn) SetReadDeadline(time.Time) error { return nil }
+func (c auditConn) LocalAddr() net.Addr             { return nil }
+func (c auditConn) RemoteAddr() net.Addr            { return nil }
+
+// not parallel as the audit is global
+func TestDeadlineAudit(t *testing.T) {
+	log := &recordLogger{}
+	audit := EnableDeadlineAudit(log)
+	defer EnableDeadlineAudit(nil)
+
+	client, server := net.Pipe()
+	defer client.Close()
+	defer server.Close()
+
+	// a regular timeout is fine
+	if _, err := ConnectionRead(client, 20*time.Millisecond); err != ErrTimeout {
+		t.Fatalf("expected a timeout, got %v", err)
+	}
+	if audit.Violations() != 0 {
+		t.Fatalf("unexpected violations %v", log.msgs)
+	}
+
+	// timeout before the deadline
+	_, _ = ConnectionRead(auditConn{err: timeoutError{}}, time.Second)
+	// read blocking past the deadline
+	_, _ = ConnectionRead(auditConn{delay: 250 * time.Millisecond}, 10*time.Millisecond)
+	// deadline in the past
+	_, _ = ConnectionRead(client, 0)
+
+	if audit.Violations() != 3 || len(log.msgs) != 3 {
+		t.Fatalf("expected 3 violations, got %v", log.msgs)
+	}
+	expected := "deadline audit: checked 4 reads and writes, 1 timed out early, 1 blocked past the deadline, 1 had an invalid timeout"
+	if x := audit.Summary(); x != expected {
+		t.Errorf("expected %q, got %q", expected, x)
+	}
+
+	EnableDeadlineAudit(nil)
+	_, _ = ConnectionRead(client, 0)
+	if audit.Violations() != 3 {
+		t.Error("disabled audit still records violations")
+	}
+}
@@ -0,0 +1,345 @@
+package helper
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math/rand"
+	"net/netip"
+	"strconv"
+	"strings"
+)
+
+// DNS record types used by stunner
+const (
+	DNSTypeA     uint16 = 1
+	DNSTypeNS    uint16 = 2
+	DNSTypeCNAME uint16 = 5
+	DNSTypeSOA   uint16 = 6
+	DNSTypePTR   uint16 = 12
+	DNSTypeMX    uint16 = 15
+	DNSTypeTXT   uint16 = 16
+	DNSTypeAAAA  uint16 = 28
+	DNSTypeSRV   uint16 = 33
+)
+
+var dnsTypeNames = map[uint16]string{
+	DNSTypeA:     "A",
+	DNSTypeNS:    "NS",
+	DNSTypeCNAME: "CNAME",
+	DNSTypeSOA:   "SOA",
+	DNSTypePTR:   "PTR",
+	DNSTypeMX:    "MX",
+	DNSTypeTXT:   "TXT",
+	DNSTypeAAAA:  "AAAA",
+	DNSTypeSRV:   "SRV",
+}
+
+// DNSTypeString returns the human readable name of a DNS record type
+func DNSTypeString(t uint16) string {
+	if str, ok := dnsTypeNames[t]; ok {
+		return str
+	}
+	return fmt.Sprintf("TYPE%d", t)
+}
+
+var dnsRCodeNames = map[uint8]string{
+	0: "NOERROR",
+	1: "FORMERR",
+	2: "SERVFAIL",
+	3: "NXDOMAIN",
+	4: "NOTIMP",
+	5: "REFUSED",
+}
+
+// DNSRCodeString returns the human readable name of a DNS response code
+func DNSRCodeString(r uint8) string {
+	if str, ok := dnsRCodeNames[r]; ok {
+		return str
+	}
+	return fmt.Sprintf("RCODE%d", r)
+}
+
+// DNSRecord holds a single resource record
+type DNSRecord struct {
+	Name  string
+	Type  uint16
+	Class uint16
+	TTL   uint32
+	// Data is the decoded record data. IPs for A and AAAA records,
+	// names for CNAME, NS and PTR records, priority, weight, port and
+	// target for SRV records, the quoted strings of TXT records and the
+	// raw data as hex for unknown types
+	Data string
+}
+
+func (r DNSRecord) String() string {
+	return fmt.Sprintf("%s %d %s %s", r.Name, r.TTL, DNSTypeString(r.Type), r.Data)
+}
+
+// DNSMessage holds the parsed parts of a DNS response we are interested in
+type DNSMessage struct {
+	ID        uint16
+	Flags     uint16
+	RCode     uint8
+	Questions []string
+	Answers   []DNSRecord
+	// Additional are the records of the additional section
+	Additional []DNSRecord
+}
+
+// DNSQuery builds a recursive DNS query for the given name and type
+func DNSQuery(name string, qtype uint16) []byte {
+	var dns []byte
+
+	// transactionID
+	dns = append(dns, PutUint16(uint16(rand.Uint32()))...)
+	// FLAGS: standard query
+	dns = append(dns, []byte{0x01, 0x00}...)
+	// Questions: 1
+	dns = append(dns, PutUint16(1)...)
+	// Answer RRs: 0
+	dns = append(dns, PutUint16(0)...)
+	// Authority RRs: 0
+	dns = append(dns, PutUint16(0)...)
+	// Additional RRs: 0
+	dns = append(dns, PutUint16(0)...)
+
+	// Query: LEN, DOMAIN (null byte terminated), TYPE, CLASS
+	dns = append(dns, encodeDNSName(name)...)
+	dns = append(dns, PutUint16(qtype)...)
+	// Class: IN
+	dns = append(dns, PutUint16(1)...)
+
+	return dns
+}
+
+// LinkLocalDNSQuery builds a non recursive query as used by LLMNR and
+// mDNS. Both use the DNS message format but recursion desired has a
+// different meaning in LLMNR
+func LinkLocalDNSQuery(name string, qtype uint16) []byte {
+	dns := DNSQuery(name, qtype)
+	copy(dns[2:4], PutUint16(0))
+	return dns
+}
+
+// DNSQueryPadded builds a DNS query with an EDNS0 padding option (RFC 7830)
+// so the query is exactly size bytes long. If size is smaller than the
+// query without any padding the unpadded query is returned
+func DNSQueryPadded(name string, qtype uint16, size int) []byte {
+	dns := DNSQuery(name, qtype)
+	// OPT RR: name, type, udp payload size, extended rcode and flags, rdlength
+	// followed by the padding option code and length
+	const optSize = 1 + 2 + 2 + 4 + 2 + 4
+	padding := size - len(dns) - optSize
+	if padding < 0 {
+		return dns
+	}
+
+	// Additional RRs: 1
+	copy(dns[10:12], PutUint16(1))
+	// root name
+	dns = append(dns, 0x00)
+	// Type: OPT
+	dns = append(dns, PutUint16(41)...)
+	// UDP payload size
+	dns = append(dns, PutUint16(4096)...)
+	// extended rcode, version and flags
+	dns = append(dns, PutUint32(0)...)
+	// RDLENGTH
+	dns = append(dns, PutUint16(uint16(4+padding))...)
+	// Option: Padding
+	dns = append(dns, PutUint16(12)...)
+	dns = append(dns, PutUint16(uint16(padding))...)
+	dns = append(dns, make([]byte, padding)...)
+
+	return dns
+}
+
+// ReverseDNSName returns the name of the PTR record of the address in
+// in-addr.arpa or ip6.arpa
+func ReverseDNSName(ip netip.Addr) string {
+	ip = ip.Unmap()
+	var labels []string
+	if ip.Is4() {
+		b := ip.As4()
+		for i := len(b) - 1; i >= 0; i-- {
+			labels = append(labels, strconv.Itoa(int(b[i])))
+		}
+		return strings.Join(labels, ".") + ".in-addr.arpa"
+	}
+	const hexDigits = "0123456789abcdef"
+	b := ip.As16()
+	for i := len(b) - 1; i >= 0; i-- {
+		labels = append(labels, string(hexDigits[b[i]&0x0f]), string(hexDigits[b[i]>>4]))
+	}
+	return strings.Join(labels, ".") + ".ip6.arpa"
+}
+
+func encodeDNSName(name string) []byte {
+	var buf []byte
+	for _, x := range strings.Split(strings.TrimSuffix(name, "."), ".") {
+		if x == "" {
+			continue
+		}
+		buf = append(buf, uint8(len(x)))
+		buf = append(buf, []byte(x)...)
+	}
+	// terminate with a null byte
+	buf = append(buf, 0x00)
+	return buf
+}
+
+// ParseDNSMessage parses a DNS response
+func ParseDNSMessage(buf []byte) (*DNSMessage, error) {
+	if len(buf) < 12 {
+		return nil, fmt.Errorf("invalid dns message length %d", len(buf))
+	}
+	msg := &DNSMessage{
+		ID:    binary.BigEndian.Uint16(buf[0:2]),
+		Flags: binary.BigEndian.Uint16(buf[2:4]),
+	}
+	msg.RCode = uint8(msg.Flags & 0x000f)
+	qdCount := int(binary.BigEndian.Uint16(buf[4:6]))
+	anCount := int(binary.BigEndian.Uint16(buf[6:8]))
+	nsCount := int(binary.BigEndian.Uint16(buf[8:10]))
+	arCount := int(binary.BigEndian.Uint16(buf[10:12]))
+
+	offset := 12
+	for i := 0; i < qdCount; i++ {
+		name, newOffset, err := readDNSName(buf, offset)
+		if err != nil {
+			return nil, fmt.Errorf("invalid question: %w", err)
+		}
+		// type and class
+		if newOffset+4 > len(buf) {
+			return nil, fmt.Errorf("question %d is truncated", i)
+		}
+		msg.Questions = append(msg.Questions, name)
+		offset = newOffset + 4
+	}
+
+	for i := 0; i < anCount; i++ {
+		record, newOffset, err := readDNSRecord(buf, offset)
+		if err != nil {
+			return nil, fmt.Errorf("answer %d: %w", i, err)
+		}
+		msg.Answers = append(msg.Answers, record)
+		offset = newOffset
+	}
+
+	// mDNS responders put the records of the answered names in the
+	// additional section. Errors in the authority and additional sections
+	// are ignored as the answers are complete
+	for i := 0; i < nsCount+arCount; i++ {
+		record, newOffset, err := readDNSRecord(buf, offset)
+		if err != nil {
+			break
+		}
+		if i >= nsCount {
+			msg.Additional = append(msg.Additional, record)
+		}
+		offset = newOffset
+	}
+
+	return msg, nil
+}
+
+// readDNSRecord reads the resource record starting at offset and returns
+// the record and the offset after the record
+func readDNSRecord(buf []byte, offset int) (DNSRecord, int, error) {
+	name, offset, err := readDNSName(buf, offset)
+	if err != nil {
+		return DNSRecord{}, 0, fmt.Errorf("invalid name: %w", err)
+	}
+	if offset+10 > len(buf) {
+		return DNSRecord{}, 0, fmt.Errorf("record is truncated")
+	}
+	record := DNSRecord{
+		Name:  name,
+		Type:  binary.BigEndian.Uint16(buf[offset : offset+2]),
+		Class: binary.BigEndian.Uint16(buf[offset+2 : offset+4]),
+		TTL:   binary.BigEndian.Uint32(buf[offset+4 : offset+8]),
+	}
+	dataLen := int(binary.BigEndian.Uint16(buf[offset+8 : offset+10]))
+	offset += 10
+	if offset+dataLen > len(buf) {
+		return DNSRecord{}, 0, fmt.Errorf("data of record is truncated")
+	}
+	data := buf[offset : offset+dataLen]
+	switch record.Type {
+	case DNSTypeA, DNSTypeAAAA:
+		ip, ok := netip.AddrFromSlice(data)
+		if !ok {
+			return DNSRecord{}, 0, fmt.Errorf("invalid IP %02x", data)
+		}
+		record.Data = ip.String()
+	case DNSTypeCNAME, DNSTypeNS, DNSTypePTR:
+		// names can be compressed so we need the whole buffer
+		target, _, err := readDNSName(buf, offset)
+		if err != nil {
+			return DNSRecord{}, 0, fmt.Errorf("invalid name in data: %w", err)
+		}
+		record.Data = target
+	case DNSTypeSRV:
+		if dataLen < 7 {
+			return DNSRecord{}, 0, fmt.Errorf("invalid SRV data length %d", dataLen)
+		}
+		target, _, err := readDNSName(buf, offset+6)
+		if err != nil {
+			return DNSRecord{}, 0, fmt.Errorf("invalid name in data: %w", err)
+		}
+		record.Data = fmt.Sprintf("%d %d %d %s", binary.BigEndian.Uint16(data[0:2]), binary.BigEndian.Uint16(data[2:4]), binary.BigEndian.Uint16(data[4:6]), target)
+	case DNSTypeTXT:
+		var texts []string
+		for len(data) > 0 {
+			l := int(data[0])
+			if 1+l > len(data) {
+				return DNSRecord{}, 0, fmt.Errorf("invalid TXT data")
+			}
+			texts = append(texts, strconv.Quote(string(data[1:1+l])))
+			data = data[1+l:]
+		}
+		record.Data = strings.Join(texts, " ")
+	default:
+		record.Data = fmt.Sprintf("%02x", data)
+	}
+	return record, offset + dataLen, nil
+}
+
+// readDNSName reads a possibly compressed name starting at offset and
+// returns the name and the offset after the name
+func readDNSName(buf []byte, offset int) (string, int, error) {
+	var labels []string
+	// offset to continue after the first pointer
+	end := -1
+	// protect against pointer loops
+	for jumps := 0; jumps < 64; {
+		if offset >= len(buf) {
+			return "", 0, fmt.Errorf("name exceeds buffer")
+		}
+		l := int(buf[offset])
+		switch {
+		case l == 0:
+			if end == -1 {
+				end = offset + 1
+			}
+			return strings.Join(labels, "."), end, nil
+		case l&0xc0 == 0xc0:
+			if offset+1 >= len(buf) {
+				return "", 0, fmt.Errorf("invalid compression pointer")
+			}
+			if end == -1 {
+				end = offset + 2
+			}
+			offset = int(binary.BigEndian.Uint16(buf[offset:offset+2]) & 0x3fff)
+			jumps++
+		default:
+			if offset+1+l > len(buf) {
+				return "", 0, fmt.Errorf("label exceeds buffer")
+			}
+			labels = append(labels, string(buf[offset+1:offset+1+l]))
+			offset += 1 + l
+		}
+	}
+	return "", 0, fmt.Errorf("too many compression pointers")
+}
@@ -0,0 +1,179 @@
+package helper
+
+import (
+	"encoding/hex"
+	"net/netip"
+	"testing"
+)
+
+func TestDNSQuery(t *testing.T) {
+	t.Parallel()
+	q := DNSQuery("www.example.com", DNSTypeA)
+	// skip the random transaction id
+	expected := "0100000100000000000003777777076578616d706c6503636f6d0000010001"
+	if h := hex.EncodeToString(q[2:]); h != expected {
+		t.Errorf("expected %q, got %q", expected, h)
+	}
+}
+
+func TestLinkLocalDNSQuery(t *testing.T) {
+	t.Parallel()
+	q := LinkLocalDNSQuery("wpad", DNSTypeA)
+	// skip the random transaction id
+	expected := "0000000100000000000004777061640000010001"
+	if h := hex.EncodeToString(q[2:]); h != expected {
+		t.Errorf("expected %q, got %q", expected, h)
+	}
+}
+
+func TestDNSQueryPadded(t *testing.T) {
+	t.Parallel()
+	for _, size := range []int{100, 512, 1400} {
+		q := DNSQueryPadded("example.com", DNSTypeA, size)
+		if len(q) != size {
+			t.Errorf("expected a query of %d bytes, got %d", size, len(q))
+		}
+		msg, err := ParseDNSMessage(q)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(msg.Questions) != 1 || msg.Questions[0] != "example.com" {
+			t.Errorf("unexpected questions %v", msg.Questions)
+		}
+	}
+	// too small for padding
+	if q := DNSQueryPadded("example.com", DNSTypeA, 10); len(q) != len(DNSQuery("example.com", DNSTypeA)) {
+		t.Errorf("expected an unpadded query, got %d bytes", len(q))
+	}
+}
+
+func TestReverseDNSName(t *testing.T) {
+	t.Parallel()
+	tests := map[string]string{
+		"10.1.2.3":           "3.2.1.10.in-addr.arpa",
+		"::ffff:192.168.0.1": "1.0.168.192.in-addr.arpa",
+		"2001:db8::567:89ab": "b.a.9.8.7.6.5.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.8.b.d.0.1.0.0.2.ip6.arpa",
+	}
+	for ip, expected := range tests {
+		if name := ReverseDNSName(netip.MustParseAddr(ip)); name != expected {
+			t.Errorf("expected %s for %s, got %s", expected, ip, name)
+		}
+	}
+}
+
+func TestParseDNSMessage(t *testing.T) {
+	t.Parallel()
+
+	var tests = []struct {
+		testName      string
+		input         string
+		expectedRCode uint8
+		expectedData  []string
+	}{
+		{"A record with compression", "abcd8180000100010000000003777777076578616d706c6503636f6d0000010001c00c000100010000012c00045db8d822", 0, []string{"93.184.216.34"}},
+		{"CNAME and A record", "abcd8180000100020000000003777777076578616d706c6503636f6d0000010001c00c0005000100000e10000603636e6ec010c02d000100010000003c00040a000001", 0, []string{"cnn.example.com", "10.0.0.1"}},
+		{"NXDOMAIN", "abcd8183000100000000000003777777076578616d706c6503636f6d0000010001", 3, nil},
+	}
+	for _, tt := range tests {
+		tt := tt // NOTE: https://github.com/golang/go/wiki/CommonMistakes#using-goroutines-on-loop-iterator-variables
+		t.Run(tt.testName, func(t *testing.T) {
+			t.Parallel()
+			in, err := hex.DecodeString(tt.input)
+			if err != nil {
+				t.Fatalf("invalid input on %s: %v", tt.testName, err)
+			}
+			msg, err := ParseDNSMessage(in)
+			if err != nil {
+				t.Fatalf("could not parse message: %v", err)
+			}
+			if msg.RCode != tt.expectedRCode {
+				t.Errorf("expected rcode %d, got %d", tt.expectedRCode, msg.RCode)
+			}
+			if len(msg.Answers) != len(tt.expectedData) {
+				t.Fatalf("expected %d answers, got %d", len(tt.expectedData), len(msg.Answers))
+			}
+			for i, a := range msg.Answers {
+				if a.Data != tt.expectedData[i] {
+					t.Errorf("expected %q, got %q", tt.expectedData[i], a.Data)
+				}
+			}
+		})
+	}
+}
+
+func TestParseDNSMessageFail(t *testing.T) {
+	t.Parallel()
+
+	var tests = []struct {
+		testName string
+		input    string
+	}{
+		{"Fails on short message", "abcd"},
+		{"Fails on truncated answer", "abcd8180000100010000000003777777076578616d706c6503636f6d0000010001c00c0001"},
+		{"Fails on pointer loop", "abcd81800001000000000000c00c"},
+	}
+	for _, tt := range tests {
+		tt := tt // NOTE: https://github.com/golang/go/wiki/CommonMistakes#using-goroutines-on-loop-iterator-variables
+		t.Run(tt.testName, func(t *testing.T) {
+			t.Parallel()
+			in, err := hex.DecodeString(tt.input)
+			if err != nil {
+				t.Fatalf("invalid input on %s: %v", tt.testName, err)
+			}
+			if _, err := ParseDNSMessage(in); err == nil {
+				t.Fatal("should have gotten an error")
+			}
+		})
+	}
+}
+
+func TestParseDNSMessageAdditional(t *testing.T) {
+	t.Parallel()
+	record := func(name string, rtype uint16, data []byte) []byte {
+		var r []byte
+		r = append(r, encodeDNSName(name)...)
+		r = append(r, PutUint16(rtype)...)
+		// class IN with the mDNS cache flush bit
+		r = append(r, PutUint16(0x8001)...)
+		r = append(r, PutUint32(120)...)
+		r = append(r, PutUint16(uint16(len(data)))...)
+		return append(r, data...)
+	}
+	var srv []byte
+	srv = append(srv, PutUint16(0)...)
+	srv = append(srv, PutUint16(0)...)
+	srv = append(srv, PutUint16(631)...)
+	srv = append(srv, encodeDNSName("printer.local")...)
+
+	var resp []byte
+	resp = append(resp, 0x00, 0x00, 0x84, 0x00, 0x00, 0x00, 0x00, 0x01, 0x00, 0x00, 0x00, 0x02)
+	resp = append(resp, record("_ipp._tcp.local", DNSTypePTR, encodeDNSName("Printer._ipp._tcp.local"))...)
+	resp = append(resp, record("Printer._ipp._tcp.local", DNSTypeSRV, srv)...)
+	resp = append(resp, record("Printer._ipp._tcp.local", DNSTypeTXT, []byte("\x0arp=printer\x05ty=HP"))...)
+
+	msg, err := ParseDNSMessage(resp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(msg.Answers) != 1 || msg.Answers[0].Data != "Printer._ipp._tcp.local" {
+		t.Errorf("unexpected answers %v", msg.Answers)
+	}
+	if len(msg.Additional) != 2 {
+		t.Fatalf("expected 2 additional records, got %d", len(msg.Additional))
+	}
+	if msg.Additional[0].Data != "0 0 631 printer.local" {
+		t.Errorf("unexpected SRV data %q", msg.Additional[0].Data)
+	}
+	if msg.Additional[1].Data != `"rp=printer" "ty=HP"` {
+		t.Errorf("unexpected TXT data %q", msg.Additional[1].Data)
+	}
+
+	// the answers are still returned if the additional section is broken
+	msg, err = ParseDNSMessage(resp[:len(resp)-3])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(msg.Answers) != 1 || len(msg.Additional) != 1 {
+		t.Errorf("expected 1 answer and 1 additional record, got %d and %d", len(msg.Answers), len(msg.Additional))
+	}
+}
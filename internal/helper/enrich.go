@@ -0,0 +1,175 @@
+package helper
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/netip"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Enrichment contains public information about an IP address
+type Enrichment struct {
+	RDNS    []string
+	ASN     string
+	ASName  string
+	Prefix  string
+	Country string
+}
+
+// Fields returns all non empty values of the enrichment so they
+// can be added to log entries or findings
+func (e Enrichment) Fields() map[string]string {
+	ret := make(map[string]string)
+	if len(e.RDNS) > 0 {
+		ret["rdns"] = strings.Join(e.RDNS, ",")
+	}
+	if e.ASN != "" {
+		ret["asn"] = e.ASN
+	}
+	if e.ASName != "" {
+		ret["as_name"] = e.ASName
+	}
+	if e.Prefix != "" {
+		ret["prefix"] = e.Prefix
+	}
+	if e.Country != "" {
+		ret["country"] = e.Country
+	}
+	return ret
+}
+
+// Enricher looks up the reverse DNS name and the ASN of public IP
+// addresses. ASN data is queried from the Team Cymru DNS service so
+// no local database is needed. A nil Enricher does nothing.
+type Enricher struct {
+	resolver *net.Resolver
+	timeout  time.Duration
+	mu       sync.Mutex
+	cache    map[netip.Addr]Enrichment
+}
+
+// NewEnricher returns an Enricher using the system resolver
+func NewEnricher(timeout time.Duration) *Enricher {
+	return &Enricher{
+		resolver: net.DefaultResolver,
+		timeout:  timeout,
+		cache:    make(map[netip.Addr]Enrichment),
+	}
+}
+
+// IsPublicIP returns true if the IP is routable on the internet
+func IsPublicIP(ip netip.Addr) bool {
+	ip = ip.Unmap()
+	if !ip.IsGlobalUnicast() || ip.IsPrivate() {
+		return false
+	}
+	for _, p := range nonPublicPrefixes {
+		if p.Contains(ip) {
+			return false
+		}
+	}
+	return true
+}
+
+// ranges that are global unicast but not reachable on the internet
+var nonPublicPrefixes = []netip.Prefix{
+	netip.MustParsePrefix("100.64.0.0/10"),
+	netip.MustParsePrefix("192.0.0.0/24"),
+	netip.MustParsePrefix("192.0.2.0/24"),
+	netip.MustParsePrefix("198.18.0.0/15"),
+	netip.MustParsePrefix("198.51.100.0/24"),
+	netip.MustParsePrefix("203.0.113.0/24"),
+	netip.MustParsePrefix("240.0.0.0/4"),
+	// discard only
+	netip.MustParsePrefix("100::/64"),
+	// local use NAT64
+	netip.MustParsePrefix("64:ff9b:1::/48"),
+	// documentation
+	netip.MustParsePrefix("2001:db8::/32"),
+	netip.MustParsePrefix("3fff::/20"),
+	// deprecated site-local
+	netip.MustParsePrefix("fec0::/10"),
+}
+
+// Lookup returns the enrichment for ip. The second return value is
+// false if the ip is not public or the Enricher is nil. Lookup errors
+// are ignored and result in empty values.
+func (e *Enricher) Lookup(ctx context.Context, ip netip.Addr) (Enrichment, bool) {
+	if e == nil || !IsPublicIP(ip) {
+		return Enrichment{}, false
+	}
+	ip = ip.Unmap()
+
+	e.mu.Lock()
+	cached, ok := e.cache[ip]
+	e.mu.Unlock()
+	if ok {
+		return cached, true
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, e.timeout)
+	defer cancel()
+
+	var ret Enrichment
+	if names, err := e.resolver.LookupAddr(ctx, ip.String()); err == nil {
+		for _, name := range names {
+			ret.RDNS = append(ret.RDNS, strings.TrimSuffix(name, "."))
+		}
+	}
+	if txt, err := e.resolver.LookupTXT(ctx, cymruOriginName(ip)); err == nil && len(txt) > 0 {
+		ret.ASN, ret.Prefix, ret.Country = parseCymruOrigin(txt[0])
+	}
+	if ret.ASN != "" {
+		if txt, err := e.resolver.LookupTXT(ctx, fmt.Sprintf("AS%s.asn.cymru.com", ret.ASN)); err == nil && len(txt) > 0 {
+			ret.ASName = parseCymruASName(txt[0])
+		}
+	}
+
+	e.mu.Lock()
+	e.cache[ip] = ret
+	e.mu.Unlock()
+	return ret, true
+}
+
+// cymruOriginName returns the name to query the origin ASN of an IP
+func cymruOriginName(ip netip.Addr) string {
+	if ip.Is4() {
+		b := ip.As4()
+		return fmt.Sprintf("%d.%d.%d.%d.origin.asn.cymru.com", b[3], b[2], b[1], b[0])
+	}
+	b := ip.As16()
+	var sb strings.Builder
+	for i := len(b) - 1; i >= 0; i-- {
+		fmt.Fprintf(&sb, "%x.%x.", b[i]&0x0f, b[i]>>4)
+	}
+	sb.WriteString("origin6.asn.cymru.com")
+	return sb.String()
+}
+
+// parseCymruOrigin parses answers like
+// "13335 | 1.1.1.0/24 | AU | apnic | 2011-08-11"
+func parseCymruOrigin(txt string) (string, string, string) {
+	parts := strings.Split(txt, "|")
+	if len(parts) < 3 {
+		return "", "", ""
+	}
+	// multiple origin ASNs are separated by a space, use the first one
+	asn := strings.Fields(parts[0])
+	if len(asn) == 0 {
+		return "", "", ""
+	}
+	return asn[0], strings.TrimSpace(parts[1]), strings.TrimSpace(parts[2])
+}
+
+// parseCymruASName parses answers like
+// "13335 | US | arin | 2010-07-14 | CLOUDFLARENET, US"
+func parseCymruASName(txt string) string {
+	parts := strings.Split(txt, "|")
+	if len(parts) < 5 {
+		return ""
+	}
+	return strings.TrimSpace(parts[4])
+}
@@ -0,0 +1,74 @@
+package helper
+
+import (
+	"net/netip"
+	"testing"
+)
+
+func TestIsPublicIP(t *testing.T) {
+	t.Parallel()
+
+	var tests = []struct {
+		input    string
+		expected bool
+	}{
+		{"1.1.1.1", true},
+		{"2606:4700::1111", true},
+		{"::ffff:8.8.8.8", true},
+		{"10.0.0.1", false},
+		{"192.168.1.1", false},
+		{"127.0.0.1", false},
+		{"169.254.169.254", false},
+		{"100.64.0.1", false},
+		{"203.0.113.5", false},
+		{"fd00::1", false},
+		{"fe80::1", false},
+		{"3fff::1", false},
+		{"fec0::1", false},
+		{"224.0.0.1", false},
+	}
+	for _, tt := range tests {
+		tt := tt // NOTE: https://github.com/golang/go/wiki/CommonMistakes#using-goroutines-on-loop-iterator-variables
+		t.Run(tt.input, func(t *testing.T) {
+			t.Parallel()
+			if x := IsPublicIP(netip.MustParseAddr(tt.input)); x != tt.expected {
+				t.Errorf("IsPublicIP(%s): expected %t but got %t", tt.input, tt.expected, x)
+			}
+		})
+	}
+}
+
+func TestCymruOriginName(t *testing.T) {
+	t.Parallel()
+
+	var tests = []struct {
+		input    string
+		expected string
+	}{
+		{"1.2.3.4", "4.3.2.1.origin.asn.cymru.com"},
+		{"2001:4860::1", "1.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.6.8.4.1.0.0.2.origin6.asn.cymru.com"},
+	}
+	for _, tt := range tests {
+		tt := tt // NOTE: https://github.com/golang/go/wiki/CommonMistakes#using-goroutines-on-loop-iterator-variables
+		t.Run(tt.input, func(t *testing.T) {
+			t.Parallel()
+			if x := cymruOriginName(netip.MustParseAddr(tt.input)); x != tt.expected {
+				t.Errorf("expected %s but got %s", tt.expected, x)
+			}
+		})
+	}
+}
+
+func TestParseCymru(t *testing.T) {
+	t.Parallel()
+	asn, prefix, country := parseCymruOrigin("13335 209242 | 1.1.1.0/24 | AU | apnic | 2011-08-11")
+	if asn != "13335" || prefix != "1.1.1.0/24" || country != "AU" {
+		t.Errorf("unexpected origin result %q %q %q", asn, prefix, country)
+	}
+	if asn, _, _ := parseCymruOrigin("invalid"); asn != "" {
+		t.Errorf("expected empty asn on invalid input, got %q", asn)
+	}
+	if name := parseCymruASName("13335 | US | arin | 2010-07-14 | CLOUDFLARENET, US"); name != "CLOUDFLARENET, US" {
+		t.Errorf("unexpected AS name %q", name)
+	}
+}
@@ -0,0 +1,67 @@
+package helper
+
+import (
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ExpiryMargin is the time before their expiry at which time-limited
+// credentials are renewed
+const ExpiryMargin = 5 * time.Minute
+
+// UsernameExpiry returns the expiry of time-limited credentials of the TURN
+// REST API. Their username contains the expiry as unix timestamp, usually
+// as 1700000000:alice but some implementations use alice:1700000000
+func UsernameExpiry(username string) (time.Time, bool) {
+	for _, part := range strings.Split(username, ":") {
+		// 9 to 11 digits cover the years 1973 to 5138
+		if len(part) < 9 || len(part) > 11 {
+			continue
+		}
+		ts, err := strconv.ParseInt(part, 10, 64)
+		if err != nil {
+			continue
+		}
+		return time.Unix(ts, 0), true
+	}
+	return time.Time{}, false
+}
+
+// Expires returns the expiry of time-limited credentials
+func (c Credentials) Expires() (time.Time, bool) {
+	return UsernameExpiry(c.Username)
+}
+
+// ExpiresWithin returns true if the credentials are time-limited and expire
+// within d
+func (c Credentials) ExpiresWithin(d time.Duration) bool {
+	expires, ok := c.Expires()
+	return ok && time.Until(expires) <= d
+}
+
+// CountdownInterval returns the time until the next message of a countdown
+// with left remaining. Messages get more frequent towards the end and one
+// is always shown once ExpiryMargin is reached
+func CountdownInterval(left time.Duration) time.Duration {
+	var interval time.Duration
+	switch {
+	case left > 2*time.Hour:
+		interval = time.Hour
+	case left > 30*time.Minute:
+		interval = 10 * time.Minute
+	case left > ExpiryMargin:
+		interval = 5 * time.Minute
+	default:
+		interval = time.Minute
+	}
+	if left > ExpiryMargin && left-ExpiryMargin < interval {
+		interval = left - ExpiryMargin
+	} else if left > 0 && left < interval {
+		interval = left
+	}
+	if interval < time.Second {
+		interval = time.Second
+	}
+	return interval
+}
@@ -0,0 +1,70 @@
+package helper
+
+import (
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestUsernameExpiry(t *testing.T) {
+	t.Parallel()
+	tests := map[string]int64{
+		"1700000000:alice":   1700000000,
+		"alice:1700000000":   1700000000,
+		"1700000000":         1700000000,
+		"12345:1700000000":   1700000000,
+		"alice":              0,
+		"alice:123":          0,
+		"alice:17000000001a": 0,
+		"":                   0,
+	}
+	for username, want := range tests {
+		got, ok := UsernameExpiry(username)
+		if ok != (want != 0) {
+			t.Errorf("%q: got ok %t", username, ok)
+			continue
+		}
+		if ok && got.Unix() != want {
+			t.Errorf("%q: got %d, want %d", username, got.Unix(), want)
+		}
+	}
+}
+
+func TestExpiresWithin(t *testing.T) {
+	t.Parallel()
+	soon := Credentials{Username: formatExpiry(time.Now().Add(time.Minute))}
+	if !soon.ExpiresWithin(ExpiryMargin) {
+		t.Error("credentials expiring in a minute should expire within the margin")
+	}
+	later := Credentials{Username: formatExpiry(time.Now().Add(time.Hour))}
+	if later.ExpiresWithin(ExpiryMargin) {
+		t.Error("credentials expiring in an hour should not expire within the margin")
+	}
+	if (Credentials{Username: "alice"}).ExpiresWithin(ExpiryMargin) {
+		t.Error("credentials without expiry never expire")
+	}
+}
+
+func TestCountdownInterval(t *testing.T) {
+	t.Parallel()
+	tests := map[time.Duration]time.Duration{
+		10 * time.Hour:                  time.Hour,
+		time.Hour:                       10 * time.Minute,
+		20 * time.Minute:                5 * time.Minute,
+		ExpiryMargin + 2*time.Minute:    2 * time.Minute,
+		ExpiryMargin:                    time.Minute,
+		30 * time.Second:                30 * time.Second,
+		0:                               time.Minute,
+		-time.Hour:                      time.Minute,
+		ExpiryMargin + time.Millisecond: time.Second,
+	}
+	for left, want := range tests {
+		if got := CountdownInterval(left); got != want {
+			t.Errorf("%s left: got %s, want %s", left, got, want)
+		}
+	}
+}
+
+func formatExpiry(t time.Time) string {
+	return strconv.FormatInt(t.Unix(), 10) + ":alice"
+}
@@ -0,0 +1,101 @@
+package helper
+
+import (
+	"bytes"
+	"strings"
+)
+
+// FingerprintBanner tries to identify the service and product from
+// the first bytes a TCP service sent. The probes of the probe database
+// are tried before the built in fingerprints. The service is empty if it
+// could not be identified.
+func FingerprintBanner(banner []byte) (string, string) {
+	if len(banner) == 0 {
+		return "", ""
+	}
+	if db := currentProbeDB(); db != nil {
+		if service, product, ok := db.Match(banner); ok {
+			return service, product
+		}
+	}
+
+	firstLine := bannerFirstLine(banner)
+
+	switch {
+	case strings.HasPrefix(firstLine, "SSH-"):
+		return "ssh", firstLine
+	case strings.HasPrefix(firstLine, "HTTP/"):
+		return "http", httpHeader(banner, "Server")
+	case strings.HasPrefix(firstLine, "RFB "):
+		return "vnc", firstLine
+	case strings.HasPrefix(firstLine, "+OK"):
+		return "pop3", strings.TrimSpace(strings.TrimPrefix(firstLine, "+OK"))
+	case strings.HasPrefix(firstLine, "* OK"):
+		return "imap", strings.TrimSpace(strings.TrimPrefix(firstLine, "* OK"))
+	case strings.HasPrefix(firstLine, "220"):
+		product := strings.TrimSpace(strings.TrimLeft(firstLine[3:], "- "))
+		upper := strings.ToUpper(firstLine)
+		if strings.Contains(upper, "FTP") {
+			return "ftp", product
+		}
+		if strings.Contains(upper, "SMTP") || strings.Contains(upper, "MAIL") {
+			return "smtp", product
+		}
+		return "ftp/smtp", product
+	}
+
+	// MySQL greeting: 3 byte length, sequence id 0, protocol version 10
+	if len(banner) > 5 && banner[3] == 0x00 && banner[4] == 0x0a {
+		version := banner[5:]
+		if i := bytes.IndexByte(version, 0x00); i >= 0 {
+			return "mysql", string(version[:i])
+		}
+	}
+
+	return "", ""
+}
+
+// httpHeader returns the value of a header in a raw HTTP response
+func httpHeader(response []byte, name string) string {
+	prefix := strings.ToLower(name) + ":"
+	for _, line := range strings.Split(string(response), "\n") {
+		line = strings.TrimSpace(line)
+		// end of headers
+		if line == "" {
+			break
+		}
+		if strings.HasPrefix(strings.ToLower(line), prefix) {
+			return strings.TrimSpace(line[len(prefix):])
+		}
+	}
+	return ""
+}
+
+// turnSoftware maps substrings of the SOFTWARE attribute to the TURN
+// server implementation. The first match wins
+var turnSoftware = []struct {
+	match   string
+	product string
+}{
+	{"coturn", "coturn"},
+	{"rfc5766-turn-server", "rfc5766-turn-server"},
+	{"eturnal", "eturnal"},
+	{"ejabberd", "ejabberd"},
+	{"pion", "pion"},
+	{"return server", "reTurn"},
+	{"restund", "restund"},
+	{"stuntman", "stuntman"},
+	{"stunserver", "stuntman"},
+}
+
+// FingerprintTURNSoftware guesses the TURN server implementation from the
+// SOFTWARE attribute. It returns an empty string if the software is unknown
+func FingerprintTURNSoftware(software string) string {
+	lower := strings.ToLower(software)
+	for _, s := range turnSoftware {
+		if strings.Contains(lower, s.match) {
+			return s.product
+		}
+	}
+	return ""
+}
@@ -0,0 +1,56 @@
+package helper
+
+import "testing"
+
+func TestFingerprintBanner(t *testing.T) {
+	t.Parallel()
+
+	var tests = []struct {
+		testName        string
+		input           string
+		expectedService string
+		expectedProduct string
+	}{
+		{"SSH", "SSH-2.0-OpenSSH_8.9p1 Ubuntu-3\r\n", "ssh", "SSH-2.0-OpenSSH_8.9p1 Ubuntu-3"},
+		{"HTTP", "HTTP/1.0 200 OK\r\nContent-Type: text/html\r\nServer: nginx/1.18.0\r\n\r\n<html>", "http", "nginx/1.18.0"},
+		{"HTTP without server header", "HTTP/1.1 404 Not Found\r\n\r\n", "http", ""},
+		{"FTP", "220 ProFTPD Server (Debian) [::ffff:10.0.0.1]\r\n", "ftp", "ProFTPD Server (Debian) [::ffff:10.0.0.1]"},
+		{"SMTP", "220 mail.corp.local ESMTP Postfix\r\n", "smtp", "mail.corp.local ESMTP Postfix"},
+		{"MySQL", "\x4a\x00\x00\x00\x0a8.0.32\x00\x08\x00\x00\x00", "mysql", "8.0.32"},
+		{"Unknown", "\x00\x01\x02", "", ""},
+		{"Empty", "", "", ""},
+	}
+	for _, tt := range tests {
+		tt := tt // NOTE: https://github.com/golang/go/wiki/CommonMistakes#using-goroutines-on-loop-iterator-variables
+		t.Run(tt.testName, func(t *testing.T) {
+			t.Parallel()
+			service, product := FingerprintBanner([]byte(tt.input))
+			if service != tt.expectedService {
+				t.Errorf("Service: expected %q but got %q", tt.expectedService, service)
+			}
+			if product != tt.expectedProduct {
+				t.Errorf("Product: expected %q but got %q", tt.expectedProduct, product)
+			}
+		})
+	}
+}
+
+func TestFingerprintTURNSoftware(t *testing.T) {
+	t.Parallel()
+
+	var tests = []struct {
+		input    string
+		expected string
+	}{
+		{"Coturn-4.5.2 'dan Eider'", "coturn"},
+		{"eturnal 1.12.0", "eturnal"},
+		{"reTurn Server 1.14", "reTurn"},
+		{"Citrix-3.2.4.5 'Marshal West'", ""},
+		{"", ""},
+	}
+	for _, tt := range tests {
+		if got := FingerprintTURNSoftware(tt.input); got != tt.expected {
+			t.Errorf("FingerprintTURNSoftware(%q) = %q, want %q", tt.input, got, tt.expected)
+		}
+	}
+}
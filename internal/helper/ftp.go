@@ -0,0 +1,285 @@
+package helper
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"net/netip"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// FTPDialFunc opens a TCP connection to the address. It is used for
+// the data connections of passive mode
+type FTPDialFunc func(addr netip.AddrPort) (net.Conn, error)
+
+// FTPError is a negative reply of the FTP server
+type FTPError struct {
+	Code    int
+	Message string
+}
+
+func (e *FTPError) Error() string {
+	return fmt.Sprintf("%d %s", e.Code, e.Message)
+}
+
+// FTPClient is a minimal FTP client for binary transfers in passive mode
+type FTPClient struct {
+	conn    net.Conn
+	r       *bufio.Reader
+	host    netip.Addr
+	dial    FTPDialFunc
+	timeout time.Duration
+}
+
+var pasvRegex = regexp.MustCompile(`(\d+),(\d+),(\d+),(\d+),(\d+),(\d+)`)
+
+// NewFTPClient reads the greeting of the server on conn. host is the
+// address of the server and used for the data connections
+func NewFTPClient(conn net.Conn, host netip.Addr, dial FTPDialFunc, timeout time.Duration) (*FTPClient, error) {
+	c := &FTPClient{
+		conn:    conn,
+		r:       bufio.NewReader(conn),
+		host:    host,
+		dial:    dial,
+		timeout: timeout,
+	}
+	if _, _, err := c.expect(2); err != nil {
+		return nil, fmt.Errorf("invalid greeting: %w", err)
+	}
+	return c, nil
+}
+
+// Login authenticates with the username and password. Anonymous logins
+// use the username anonymous
+func (c *FTPClient) Login(username, password string) error {
+	code, msg, err := c.cmd("USER " + username)
+	if err != nil {
+		return err
+	}
+	switch {
+	case code == 230:
+		return nil
+	case code != 331:
+		return &FTPError{Code: code, Message: msg}
+	}
+	if _, _, err := c.cmdExpect(2, "PASS "+password); err != nil {
+		return err
+	}
+	// all transfers are binary
+	if _, _, err := c.cmdExpect(2, "TYPE I"); err != nil {
+		return err
+	}
+	return nil
+}
+
+// Size returns the size of the remote file
+func (c *FTPClient) Size(path string) (int64, error) {
+	_, msg, err := c.cmdExpect(2, "SIZE "+path)
+	if err != nil {
+		return 0, err
+	}
+	size, err := strconv.ParseInt(strings.TrimSpace(msg), 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid size %q: %w", msg, err)
+	}
+	return size, nil
+}
+
+// Retrieve downloads the file starting at offset. The transfer is
+// finished by closing the returned reader
+func (c *FTPClient) Retrieve(path string, offset int64) (io.ReadCloser, error) {
+	data, err := c.passive()
+	if err != nil {
+		return nil, err
+	}
+	if offset > 0 {
+		if _, _, err := c.cmdExpect(3, fmt.Sprintf("REST %d", offset)); err != nil {
+			data.Close()
+			return nil, err
+		}
+	}
+	if _, _, err := c.cmdExpect(1, "RETR "+path); err != nil {
+		data.Close()
+		return nil, err
+	}
+	return &ftpTransfer{Conn: data, c: c}, nil
+}
+
+// Store uploads a file. If appendData is set the data is appended to
+// the remote file. The transfer is finished by closing the returned writer
+func (c *FTPClient) Store(path string, appendData bool) (io.WriteCloser, error) {
+	data, err := c.passive()
+	if err != nil {
+		return nil, err
+	}
+	command := "STOR "
+	if appendData {
+		command = "APPE "
+	}
+	if _, _, err := c.cmdExpect(1, command+path); err != nil {
+		data.Close()
+		return nil, err
+	}
+	return &ftpTransfer{Conn: data, c: c}, nil
+}
+
+// Quit ends the session and closes the connection
+func (c *FTPClient) Quit() error {
+	_, _, _ = c.cmd("QUIT")
+	return c.conn.Close()
+}
+
+// passive opens a data connection. The address in the reply is ignored
+// as servers behind NAT often report an unreachable address, only the
+// port is used
+func (c *FTPClient) passive() (net.Conn, error) {
+	port, err := c.epsv()
+	if err != nil {
+		port, err = c.pasv()
+		if err != nil {
+			return nil, err
+		}
+	}
+	return c.dial(netip.AddrPortFrom(c.host, port))
+}
+
+func (c *FTPClient) epsv() (uint16, error) {
+	_, msg, err := c.cmdExpect(2, "EPSV")
+	if err != nil {
+		return 0, err
+	}
+	return ParseEPSV(msg)
+}
+
+func (c *FTPClient) pasv() (uint16, error) {
+	_, msg, err := c.cmdExpect(2, "PASV")
+	if err != nil {
+		return 0, err
+	}
+	return ParsePASV(msg)
+}
+
+// ParseEPSV returns the port of an EPSV reply like
+// "Entering Extended Passive Mode (|||6446|)"
+func ParseEPSV(msg string) (uint16, error) {
+	start := strings.Index(msg, "(")
+	end := strings.LastIndex(msg, ")")
+	if start < 0 || end < start+1 {
+		return 0, fmt.Errorf("invalid EPSV reply %q", msg)
+	}
+	fields := strings.Split(msg[start+1:end], msg[start+1:start+2])
+	if len(fields) != 5 {
+		return 0, fmt.Errorf("invalid EPSV reply %q", msg)
+	}
+	port, err := strconv.ParseUint(fields[3], 10, 16)
+	if err != nil {
+		return 0, fmt.Errorf("invalid EPSV port %q: %w", fields[3], err)
+	}
+	return uint16(port), nil
+}
+
+// ParsePASV returns the port of a PASV reply like
+// "Entering Passive Mode (10,0,0,1,195,80)"
+func ParsePASV(msg string) (uint16, error) {
+	m := pasvRegex.FindStringSubmatch(msg)
+	if m == nil {
+		return 0, fmt.Errorf("invalid PASV reply %q", msg)
+	}
+	high, err := strconv.ParseUint(m[5], 10, 8)
+	if err != nil {
+		return 0, fmt.Errorf("invalid PASV port in %q: %w", msg, err)
+	}
+	low, err := strconv.ParseUint(m[6], 10, 8)
+	if err != nil {
+		return 0, fmt.Errorf("invalid PASV port in %q: %w", msg, err)
+	}
+	return uint16(high<<8 | low), nil
+}
+
+// cmdExpect sends the command and returns an error if the reply does
+// not start with the expected digit
+func (c *FTPClient) cmdExpect(expected int, command string) (int, string, error) {
+	if err := ConnectionWrite(c.conn, []byte(command+"\r\n"), c.timeout); err != nil {
+		return 0, "", err
+	}
+	return c.expect(expected)
+}
+
+func (c *FTPClient) cmd(command string) (int, string, error) {
+	if err := ConnectionWrite(c.conn, []byte(command+"\r\n"), c.timeout); err != nil {
+		return 0, "", err
+	}
+	return c.readReply()
+}
+
+func (c *FTPClient) expect(expected int) (int, string, error) {
+	code, msg, err := c.readReply()
+	if err != nil {
+		return 0, "", err
+	}
+	if code/100 != expected {
+		return code, msg, &FTPError{Code: code, Message: msg}
+	}
+	return code, msg, nil
+}
+
+// readReply reads a single or multiline reply and returns the code and
+// the text of the last line
+func (c *FTPClient) readReply() (int, string, error) {
+	if err := c.conn.SetReadDeadline(time.Now().Add(c.timeout)); err != nil {
+		return 0, "", fmt.Errorf("could not set read deadline: %w", err)
+	}
+	return ReadFTPReply(c.r)
+}
+
+// ReadFTPReply reads a reply as defined in RFC959 section 4.2
+func ReadFTPReply(r *bufio.Reader) (int, string, error) {
+	var multiline string
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return 0, "", err
+		}
+		line = strings.TrimRight(line, "\r\n")
+		if len(line) < 4 {
+			if multiline != "" {
+				continue
+			}
+			return 0, "", fmt.Errorf("invalid reply %q", line)
+		}
+		code, err := strconv.Atoi(line[:3])
+		if err != nil {
+			if multiline != "" {
+				continue
+			}
+			return 0, "", fmt.Errorf("invalid reply %q", line)
+		}
+		switch {
+		case multiline == "" && line[3] == '-':
+			multiline = line[:3]
+		case multiline != "" && (line[:3] != multiline || line[3] != ' '):
+			// continuation line
+		default:
+			return code, line[4:], nil
+		}
+	}
+}
+
+// ftpTransfer is a data connection. Closing it reads the final reply
+// of the transfer on the control connection
+type ftpTransfer struct {
+	net.Conn
+	c *FTPClient
+}
+
+func (t *ftpTransfer) Close() error {
+	if err := t.Conn.Close(); err != nil {
+		return err
+	}
+	_, _, err := t.c.expect(2)
+	return err
+}
@@ -0,0 +1,63 @@
+package helper
+
+import (
+	"bufio"
+	"strings"
+	"testing"
+)
+
+func TestReadFTPReply(t *testing.T) {
+	t.Parallel()
+
+	var tests = []struct {
+		name    string
+		input   string
+		code    int
+		message string
+	}{
+		{"single", "220 FTP server ready\r\n", 220, "FTP server ready"},
+		{"multiline", "230-Welcome\r\n230-to the server\r\n 230 indented\r\n230 Login successful\r\n", 230, "Login successful"},
+		{"multiline other codes", "211-Features:\r\n EPSV\r\n211 End\r\n", 211, "End"},
+	}
+	for _, tt := range tests {
+		tt := tt // NOTE: https://github.com/golang/go/wiki/CommonMistakes#using-goroutines-on-loop-iterator-variables
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			code, msg, err := ReadFTPReply(bufio.NewReader(strings.NewReader(tt.input)))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if code != tt.code || msg != tt.message {
+				t.Errorf("expected %d %q but got %d %q", tt.code, tt.message, code, msg)
+			}
+		})
+	}
+
+	if _, _, err := ReadFTPReply(bufio.NewReader(strings.NewReader("garbage\r\n"))); err == nil {
+		t.Error("expected an error on an invalid reply")
+	}
+}
+
+func TestParsePassive(t *testing.T) {
+	t.Parallel()
+	port, err := ParseEPSV("Entering Extended Passive Mode (|||6446|)")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if port != 6446 {
+		t.Errorf("expected port 6446, got %d", port)
+	}
+	port, err = ParsePASV("Entering Passive Mode (10,0,0,1,195,80).")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if port != 195*256+80 {
+		t.Errorf("expected port %d, got %d", 195*256+80, port)
+	}
+	if _, err := ParseEPSV("Entering Extended Passive Mode"); err == nil {
+		t.Error("expected an error on an invalid EPSV reply")
+	}
+	if _, err := ParsePASV("Entering Passive Mode (10,0,0,1,300,80)"); err == nil {
+		t.Error("expected an error on an invalid PASV port")
+	}
+}
@@ -1,9 +1,12 @@
 package helper
 
 import (
+	"bufio"
 	"encoding/binary"
 	"math/rand"
 	"net/netip"
+	"os"
+	"strings"
 	"unicode"
 )
 
@@ -31,15 +34,13 @@ func RandomString(length int) string {
 	return string(b)
 }
 
+// IsPrivateIP returns true if the IP is in the IPv4 private ranges, the
+// IPv6 unique local range or a loopback or link-local range. IPv4 mapped
+// IPv6 addresses are checked as IPv4. Documentation and other reserved
+// ranges are not private
 func IsPrivateIP(ip netip.Addr) bool {
-	if ip.IsGlobalUnicast() || ip.IsInterfaceLocalMulticast() ||
-		ip.IsLinkLocalMulticast() || ip.IsLinkLocalUnicast() ||
-		ip.IsLoopback() || ip.IsMulticast() || ip.IsPrivate() ||
-		ip.IsUnspecified() {
-		return true
-	}
-
-	return false
+	ip = ip.Unmap()
+	return ip.IsPrivate() || ip.IsLoopback() || ip.IsLinkLocalUnicast()
 }
 
 // RandomChannelNumber generates a random valid channel number
@@ -71,3 +72,27 @@ func PutUint32(v uint32) []byte {
 	binary.BigEndian.PutUint32(buf, v)
 	return buf
 }
+
+// ReadWordlist reads a file and returns all non empty lines. The lines are
+// converted to UTF-8 with NormalizeCredential
+func ReadWordlist(filename string) ([]string, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var ret []string
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(NormalizeCredential(scanner.Text()))
+		if line == "" {
+			continue
+		}
+		ret = append(ret, line)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return ret, nil
+}
@@ -1,6 +1,11 @@
 package helper
 
-import "testing"
+import (
+	"net/netip"
+	"os"
+	"path/filepath"
+	"testing"
+)
 
 func TestRandomChannelNumber(t *testing.T) {
 	for i := 0; i < 1000; i++ {
@@ -11,6 +16,43 @@ func TestRandomChannelNumber(t *testing.T) {
 	}
 }
 
+func TestIsPrivateIP(t *testing.T) {
+	t.Parallel()
+
+	var tests = []struct {
+		input    string
+		expected bool
+	}{
+		{"10.0.0.1", true},
+		{"172.16.5.4", true},
+		{"192.168.1.1", true},
+		{"127.0.0.1", true},
+		{"169.254.169.254", true},
+		{"::ffff:10.0.0.1", true},
+		{"::1", true},
+		{"fc00::1", true},
+		{"fd12:3456::1", true},
+		{"fe80::1", true},
+		{"2001:db8::1", false},
+		{"192.0.2.1", false},
+		{"100.64.0.1", false},
+		{"240.0.0.1", false},
+		{"1.1.1.1", false},
+		{"::ffff:8.8.8.8", false},
+		{"2606:4700::1111", false},
+		{"2a00:1450:4001::200e", false},
+	}
+	for _, tt := range tests {
+		tt := tt // NOTE: https://github.com/golang/go/wiki/CommonMistakes#using-goroutines-on-loop-iterator-variables
+		t.Run(tt.input, func(t *testing.T) {
+			t.Parallel()
+			if x := IsPrivateIP(netip.MustParseAddr(tt.input)); x != tt.expected {
+				t.Errorf("IsPrivateIP(%s): expected %t but got %t", tt.input, tt.expected, x)
+			}
+		})
+	}
+}
+
 func TestPutUint16(t *testing.T) {
 	t.Parallel()
 	out := PutUint16(16)
@@ -26,3 +68,24 @@ func TestPutUint32(t *testing.T) {
 		t.Error("UINT32 length is not 4")
 	}
 }
+
+func TestReadWordlist(t *testing.T) {
+	t.Parallel()
+	filename := filepath.Join(t.TempDir(), "wordlist.txt")
+	if err := os.WriteFile(filename, []byte("public\n\n  private \r\ncisco\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	words, err := ReadWordlist(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []string{"public", "private", "cisco"}
+	if len(words) != len(expected) {
+		t.Fatalf("expected %d words, got %d", len(expected), len(words))
+	}
+	for i := range expected {
+		if words[i] != expected[i] {
+			t.Errorf("expected %q got %q", expected[i], words[i])
+		}
+	}
+}
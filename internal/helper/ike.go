@@ -0,0 +1,305 @@
+package helper
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"math/rand"
+	"strings"
+)
+
+// IKENonESPMarker prefixes IKE messages sent to the NAT traversal port 4500
+// to distinguish them from ESP packets (RFC 3948)
+var IKENonESPMarker = []byte{0, 0, 0, 0}
+
+// ISAKMP payload types (RFC 2408) used by stunner
+const (
+	ikePayloadNone         uint8 = 0
+	ikePayloadSA           uint8 = 1
+	ikePayloadProposal     uint8 = 2
+	ikePayloadTransform    uint8 = 3
+	ikePayloadNotification uint8 = 11
+	ikePayloadVendorID     uint8 = 13
+)
+
+// ISAKMP exchange types
+const (
+	IKEExchangeMainMode      uint8 = 2
+	IKEExchangeInformational uint8 = 5
+)
+
+// IKE attribute types of a transform (RFC 2409 appendix A)
+const (
+	ikeAttrEncryption uint16 = 1
+	ikeAttrHash       uint16 = 2
+	ikeAttrAuth       uint16 = 3
+	ikeAttrGroup      uint16 = 4
+	ikeAttrLifeType   uint16 = 11
+	ikeAttrLifeDur    uint16 = 12
+	ikeAttrKeyLength  uint16 = 14
+)
+
+// ikeHeaderLength is the size of the ISAKMP header
+const ikeHeaderLength = 28
+
+// ikeTransform is a proposed set of main mode attributes
+type ikeTransform struct {
+	encryption, keyLength, hash, auth, group uint16
+}
+
+// ikeTransforms are offered in the main mode request. Gateways only answer
+// with a SA if one of them matches their policy, so weak and strong
+// combinations are proposed
+var ikeTransforms = []ikeTransform{
+	{encryption: 7, keyLength: 256, hash: 4, auth: 1, group: 14},
+	{encryption: 7, keyLength: 128, hash: 2, auth: 1, group: 2},
+	{encryption: 5, hash: 2, auth: 1, group: 2},
+	{encryption: 5, hash: 1, auth: 1, group: 2},
+	{encryption: 5, hash: 2, auth: 3, group: 2},
+}
+
+var (
+	ikeEncryptions = map[uint16]string{1: "DES", 5: "3DES", 7: "AES-CBC"}
+	ikeHashes      = map[uint16]string{1: "MD5", 2: "SHA1", 4: "SHA2-256", 5: "SHA2-384", 6: "SHA2-512"}
+	ikeAuths       = map[uint16]string{1: "PSK", 3: "RSA-Sig", 65001: "XAUTH-InitPSK", 65005: "XAUTH-InitRSA"}
+	ikeGroups      = map[uint16]string{1: "modp768", 2: "modp1024", 5: "modp1536", 14: "modp2048"}
+	ikeNotifies    = map[uint16]string{
+		5:  "INVALID-MAJOR-VERSION",
+		7:  "INVALID-EXCHANGE-TYPE",
+		14: "NO-PROPOSAL-CHOSEN",
+		16: "PAYLOAD-MALFORMED",
+		24: "AUTHENTICATION-FAILED",
+	}
+)
+
+// ikeVendorIDs maps the hex encoded prefixes of well known vendor IDs to
+// the implementation or feature they announce
+var ikeVendorIDs = []struct {
+	prefix string
+	name   string
+}{
+	{"4a131c81070358455c5728f20e95452f", "RFC 3947 NAT-T"},
+	{"afcad71368a1f1c96b8696fc77570100", "Dead Peer Detection v1.0"},
+	{"4048b7d56ebce88525e7de7f00d6c2d3", "IKE Fragmentation"},
+	{"12f5f28c457168a9702d9fe274cc0100", "Cisco Unity"},
+	{"1f07f70eaa6514d3b0fa96542a50", "Cisco VPN Concentrator"},
+	{"09002689dfd6b712", "XAUTH"},
+	{"1e2b516905991c7d7c96fcbfb587e461", "Microsoft Windows"},
+	{"f4ed19e0c114eb516faaac0ee37daf2807b4381f", "Check Point"},
+	{"8299031757a36082c6a621de", "Fortinet FortiGate"},
+	{"882fe56d6fd20dbc2251613b2ebe5beb", "strongSwan"},
+	{"4865617274426561745f4e6f74696679", "Heartbeat Notify"},
+}
+
+// IKEResponse holds the parsed parts of an ISAKMP response
+type IKEResponse struct {
+	// Version is the major and minor version like 1.0
+	Version      string
+	ExchangeType uint8
+	// Transform is the accepted transform of a SA payload
+	Transform string
+	// Notify is the notification message type of an informational
+	// exchange, for example 14 if no proposal was chosen
+	Notify uint16
+	// VendorIDs are the names of known vendor IDs or the hex encoded ID
+	VendorIDs []string
+}
+
+// IKEMainMode builds an IKEv1 main mode request with a SA payload proposing
+// ikeTransforms and the NAT-T vendor ID, which makes gateways announce
+// their own vendor IDs. The initiator SPI is random and returned to match
+// the response
+func IKEMainMode() ([]byte, []byte) {
+	spi := make([]byte, 8)
+	binary.BigEndian.PutUint64(spi, rand.Uint64())
+
+	var transforms []byte
+	for i, t := range ikeTransforms {
+		var attrs []byte
+		attrs = append(attrs, ikeAttribute(ikeAttrEncryption, t.encryption)...)
+		if t.keyLength > 0 {
+			attrs = append(attrs, ikeAttribute(ikeAttrKeyLength, t.keyLength)...)
+		}
+		attrs = append(attrs, ikeAttribute(ikeAttrHash, t.hash)...)
+		attrs = append(attrs, ikeAttribute(ikeAttrAuth, t.auth)...)
+		attrs = append(attrs, ikeAttribute(ikeAttrGroup, t.group)...)
+		// lifetime of 28800 seconds
+		attrs = append(attrs, ikeAttribute(ikeAttrLifeType, 1)...)
+		attrs = append(attrs, ikeAttribute(ikeAttrLifeDur, 28800)...)
+
+		next := ikePayloadTransform
+		if i == len(ikeTransforms)-1 {
+			next = ikePayloadNone
+		}
+		// transform number and KEY_IKE
+		body := append([]byte{uint8(i + 1), 1, 0, 0}, attrs...)
+		transforms = append(transforms, ikePayload(next, body)...)
+	}
+	// proposal 1 for protocol ISAKMP without SPI
+	proposal := ikePayload(ikePayloadNone, append([]byte{1, 1, 0, uint8(len(ikeTransforms))}, transforms...))
+	// DOI IPsec and situation identity only
+	sa := ikePayload(ikePayloadVendorID, append([]byte{0, 0, 0, 1, 0, 0, 0, 1}, proposal...))
+	natt, _ := hex.DecodeString(ikeVendorIDs[0].prefix)
+	vendorID := ikePayload(ikePayloadNone, natt)
+
+	msg := make([]byte, ikeHeaderLength)
+	copy(msg[0:8], spi)
+	msg[16] = ikePayloadSA
+	// IKEv1
+	msg[17] = 0x10
+	msg[18] = IKEExchangeMainMode
+	msg = append(msg, sa...)
+	msg = append(msg, vendorID...)
+	binary.BigEndian.PutUint32(msg[24:28], uint32(len(msg)))
+	return msg, spi
+}
+
+// ikePayload prepends the generic payload header to body
+func ikePayload(next uint8, body []byte) []byte {
+	p := []byte{next, 0}
+	p = append(p, PutUint16(uint16(len(body)+4))...)
+	return append(p, body...)
+}
+
+// ikeAttribute encodes a basic attribute with a two byte value
+func ikeAttribute(attrType, value uint16) []byte {
+	return append(PutUint16(0x8000|attrType), PutUint16(value)...)
+}
+
+// ParseIKEResponse parses the response to a request with the initiator SPI
+// spi
+func ParseIKEResponse(data []byte, spi []byte) (*IKEResponse, error) {
+	if len(data) < ikeHeaderLength {
+		return nil, fmt.Errorf("invalid ike message length %d", len(data))
+	}
+	if !bytes.Equal(data[0:8], spi) {
+		return nil, fmt.Errorf("response does not belong to the request")
+	}
+	length := int(binary.BigEndian.Uint32(data[24:28]))
+	if length < ikeHeaderLength || length > len(data) {
+		return nil, fmt.Errorf("invalid ike length %d", length)
+	}
+	resp := &IKEResponse{
+		Version:      fmt.Sprintf("%d.%d", data[17]>>4, data[17]&0x0f),
+		ExchangeType: data[18],
+	}
+	// the payloads of encrypted messages can not be read
+	if data[19]&0x01 != 0 {
+		return resp, nil
+	}
+
+	next := data[16]
+	payloads := data[ikeHeaderLength:length]
+	for next != ikePayloadNone {
+		if len(payloads) < 4 {
+			return nil, fmt.Errorf("truncated ike payload %d", next)
+		}
+		payloadLength := int(binary.BigEndian.Uint16(payloads[2:4]))
+		if payloadLength < 4 || payloadLength > len(payloads) {
+			return nil, fmt.Errorf("invalid length %d of ike payload %d", payloadLength, next)
+		}
+		body := payloads[4:payloadLength]
+		switch next {
+		case ikePayloadSA:
+			transform, err := parseIKESA(body)
+			if err != nil {
+				return nil, err
+			}
+			resp.Transform = transform
+		case ikePayloadNotification:
+			// DOI, protocol, SPI size and the message type
+			if len(body) < 8 {
+				return nil, fmt.Errorf("invalid ike notification length %d", len(body))
+			}
+			resp.Notify = binary.BigEndian.Uint16(body[6:8])
+		case ikePayloadVendorID:
+			resp.VendorIDs = append(resp.VendorIDs, ikeVendorName(body))
+		}
+		next = payloads[0]
+		payloads = payloads[payloadLength:]
+	}
+	return resp, nil
+}
+
+// parseIKESA returns the attributes of the transform the responder chose
+func parseIKESA(body []byte) (string, error) {
+	// DOI, situation and the proposal header
+	if len(body) < 16 {
+		return "", fmt.Errorf("invalid ike sa length %d", len(body))
+	}
+	proposal := body[8:]
+	proposalLength := int(binary.BigEndian.Uint16(proposal[2:4]))
+	spiSize := int(proposal[6])
+	if proposalLength > len(proposal) || 8+spiSize+8 > proposalLength {
+		return "", fmt.Errorf("invalid ike proposal length %d", proposalLength)
+	}
+	transform := proposal[8+spiSize : proposalLength]
+	transformLength := int(binary.BigEndian.Uint16(transform[2:4]))
+	if transformLength < 8 || transformLength > len(transform) {
+		return "", fmt.Errorf("invalid ike transform length %d", transformLength)
+	}
+
+	var parts []string
+	attrs := transform[8:transformLength]
+	for len(attrs) >= 4 {
+		attrType := binary.BigEndian.Uint16(attrs[0:2])
+		if attrType&0x8000 == 0 {
+			// variable length attributes like long lifetimes are skipped
+			valueLength := int(binary.BigEndian.Uint16(attrs[2:4]))
+			if 4+valueLength > len(attrs) {
+				return "", fmt.Errorf("invalid ike attribute length %d", valueLength)
+			}
+			attrs = attrs[4+valueLength:]
+			continue
+		}
+		value := binary.BigEndian.Uint16(attrs[2:4])
+		switch attrType & 0x7fff {
+		case ikeAttrEncryption:
+			parts = append(parts, "Enc="+ikeName(ikeEncryptions, value))
+		case ikeAttrKeyLength:
+			parts = append(parts, fmt.Sprintf("KeyLength=%d", value))
+		case ikeAttrHash:
+			parts = append(parts, "Hash="+ikeName(ikeHashes, value))
+		case ikeAttrAuth:
+			parts = append(parts, "Auth="+ikeName(ikeAuths, value))
+		case ikeAttrGroup:
+			parts = append(parts, fmt.Sprintf("Group=%d:%s", value, ikeName(ikeGroups, value)))
+		}
+		attrs = attrs[4:]
+	}
+	return strings.Join(parts, " "), nil
+}
+
+func ikeName(names map[uint16]string, value uint16) string {
+	if name, ok := names[value]; ok {
+		return name
+	}
+	return fmt.Sprintf("%d", value)
+}
+
+// ikeVendorName returns the name of a known vendor ID or the hex encoded ID
+func ikeVendorName(vendorID []byte) string {
+	id := hex.EncodeToString(vendorID)
+	for _, v := range ikeVendorIDs {
+		if strings.HasPrefix(id, v.prefix) {
+			return v.name
+		}
+	}
+	return id
+}
+
+// String returns a short description of the gateway
+func (r IKEResponse) String() string {
+	s := fmt.Sprintf("IKEv%s", r.Version)
+	if r.Transform != "" {
+		s += fmt.Sprintf(" accepted %s", r.Transform)
+	}
+	if r.Notify != 0 {
+		s += fmt.Sprintf(" notify %s", ikeName(ikeNotifies, r.Notify))
+	}
+	if len(r.VendorIDs) > 0 {
+		s += fmt.Sprintf(" vendor ids %s", strings.Join(r.VendorIDs, ", "))
+	}
+	return s
+}
@@ -0,0 +1,62 @@
+package helper
+
+import (
+	"encoding/binary"
+	"testing"
+)
+
+func TestIKEMainMode(t *testing.T) {
+	t.Parallel()
+	req, spi := IKEMainMode()
+	if int(binary.BigEndian.Uint32(req[24:28])) != len(req) {
+		t.Errorf("invalid length in header")
+	}
+	if req[17] != 0x10 || req[18] != IKEExchangeMainMode {
+		t.Errorf("invalid header %x", req[:ikeHeaderLength])
+	}
+
+	// a responder echos the first matching transform
+	resp, err := ParseIKEResponse(req, spi)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.Version != "1.0" || resp.Transform != "Enc=AES-CBC KeyLength=256 Hash=SHA2-256 Auth=PSK Group=14:modp2048" {
+		t.Errorf("unexpected response %+v", resp)
+	}
+	if len(resp.VendorIDs) != 1 || resp.VendorIDs[0] != "RFC 3947 NAT-T" {
+		t.Errorf("unexpected vendor ids %v", resp.VendorIDs)
+	}
+
+	if _, err := ParseIKEResponse(req, make([]byte, 8)); err == nil {
+		t.Errorf("expected an error for a different spi")
+	}
+}
+
+func TestParseIKEResponseNotify(t *testing.T) {
+	t.Parallel()
+	spi := []byte{1, 2, 3, 4, 5, 6, 7, 8}
+	msg := make([]byte, ikeHeaderLength)
+	copy(msg, spi)
+	msg[16] = ikePayloadNotification
+	msg[17] = 0x10
+	msg[18] = IKEExchangeInformational
+	// DOI IPsec, protocol ISAKMP, no SPI and NO-PROPOSAL-CHOSEN
+	msg = append(msg, ikePayload(ikePayloadVendorID, []byte{0, 0, 0, 1, 1, 0, 0, 14})...)
+	msg = append(msg, ikePayload(ikePayloadNone, []byte{0xde, 0xad, 0xbe, 0xef})...)
+	binary.BigEndian.PutUint32(msg[24:28], uint32(len(msg)))
+
+	resp, err := ParseIKEResponse(msg, spi)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.Notify != 14 || len(resp.VendorIDs) != 1 || resp.VendorIDs[0] != "deadbeef" {
+		t.Errorf("unexpected response %+v", resp)
+	}
+	if s := resp.String(); s != "IKEv1.0 notify NO-PROPOSAL-CHOSEN vendor ids deadbeef" {
+		t.Errorf("unexpected string %q", s)
+	}
+
+	if _, err := ParseIKEResponse(msg[:len(msg)-2], spi); err == nil {
+		t.Errorf("expected an error for a truncated message")
+	}
+}
@@ -6,6 +6,8 @@ import (
 	"strings"
 )
 
+// PrivateRanges are scanned if no IPs are supplied. The IPv6 unique local
+// and link-local ranges are too large to be scanned
 var PrivateRanges = []string{
 	"127.0.0.1/32",
 	"10.0.0.0/8",
@@ -13,11 +15,31 @@ var PrivateRanges = []string{
 	"192.168.0.0/16",
 }
 
+// LoopbackRanges are only scanned on request
+var LoopbackRanges = []string{
+	"::1/128",
+}
+
+// ScanRanges returns the ranges to scan. Without IPs the private ranges
+// are scanned, loopback adds the IPv6 loopback address
+func ScanRanges(ips []string, loopback bool) []string {
+	ranges := ips
+	if len(ranges) == 0 {
+		ranges = PrivateRanges
+	}
+	if loopback {
+		ranges = append(append([]string{}, ranges...), LoopbackRanges...)
+	}
+	return ranges
+}
+
 type IP struct {
 	IP    netip.Addr
 	Error error
 }
 
+// IPIterator returns all addresses of the ranges. Addresses outside of the
+// scope are skipped and reported as an error once per range
 func IPIterator(ranges []string) <-chan IP {
 	c := make(chan IP)
 	go func() {
@@ -30,13 +52,19 @@ func IPIterator(ranges []string) <-chan IP {
 					c <- IP{Error: err}
 					continue
 				}
-				GenerateSinglePrivateIPs(prefix, c)
+				if skipped := generateScopedIPs(prefix, c); skipped > 0 {
+					c <- IP{Error: fmt.Errorf("skipped %d addresses of %s: %w", skipped, prefix, ErrOutOfScope)}
+				}
 			} else {
 				tmp, err := netip.ParseAddr(ipRange)
 				if err != nil {
 					c <- IP{Error: fmt.Errorf("Invalid IP %s: %w", ipRange, err)}
 					continue
 				}
+				if !InScope(tmp) {
+					c <- IP{Error: fmt.Errorf("skipped %s: %w", tmp, ErrOutOfScope)}
+					continue
+				}
 				c <- IP{IP: tmp}
 			}
 		}
@@ -55,3 +83,17 @@ func GenerateSinglePrivateIPs(prefix netip.Prefix, c chan<- IP) {
 		ip = ip.Next()
 	}
 }
+
+// generateScopedIPs sends all addresses of the prefix that are in scope
+// and returns the number of skipped addresses
+func generateScopedIPs(prefix netip.Prefix, c chan<- IP) int {
+	skipped := 0
+	for ip := prefix.Addr(); prefix.Contains(ip); ip = ip.Next() {
+		if !InScope(ip) {
+			skipped++
+			continue
+		}
+		c <- IP{IP: ip}
+	}
+	return skipped
+}
@@ -0,0 +1,295 @@
+package helper
+
+import (
+	"encoding/asn1"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Kerberos encryption types from RFC3961 and RFC4757
+const (
+	KerberosETypeAES128 = 17
+	KerberosETypeAES256 = 18
+	KerberosETypeRC4    = 23
+)
+
+// Kerberos error codes from RFC4120 section 7.5.9
+const (
+	KerberosErrPrincipalUnknown  = 6
+	KerberosErrETypeNotSupported = 14
+	KerberosErrClientRevoked     = 18
+	KerberosErrPreauthRequired   = 25
+)
+
+const (
+	kerberosMsgASREQ    = 10
+	kerberosMsgASREP    = 11
+	kerberosMsgKRBError = 30
+	// PA-PAC-REQUEST from MS-KILE
+	kerberosPAPacRequest = 128
+)
+
+// KerberosError is a KRB-ERROR returned by the KDC
+type KerberosError struct {
+	Code int
+	Text string
+}
+
+func (e *KerberosError) Error() string {
+	if e.Text != "" {
+		return fmt.Sprintf("kerberos error %d: %s", e.Code, e.Text)
+	}
+	return fmt.Sprintf("kerberos error %d", e.Code)
+}
+
+// ASREP is the part of an AS-REP needed for offline cracking
+type ASREP struct {
+	Realm    string
+	Username string
+	EType    int
+	// Cipher is the encrypted part which is encrypted with the key
+	// derived from the password of the user
+	Cipher []byte
+}
+
+// Hashcat returns the encrypted part in the format of hashcat mode 18200
+// for RC4 and 32100 and 32200 for AES
+func (r ASREP) Hashcat() string {
+	if r.EType == KerberosETypeRC4 {
+		if len(r.Cipher) < 16 {
+			return ""
+		}
+		return fmt.Sprintf("$krb5asrep$%d$%s@%s:%s$%s", r.EType, r.Username, r.Realm, hex.EncodeToString(r.Cipher[:16]), hex.EncodeToString(r.Cipher[16:]))
+	}
+	// the AES checksum is appended to the cipher text
+	if len(r.Cipher) < 12 {
+		return ""
+	}
+	checksum := r.Cipher[len(r.Cipher)-12:]
+	return fmt.Sprintf("$krb5asrep$%d$%s$%s$%s$%s", r.EType, r.Username, r.Realm, hex.EncodeToString(checksum), hex.EncodeToString(r.Cipher[:len(r.Cipher)-12]))
+}
+
+// KerberosASREQ returns an AS-REQ without pre-authentication for the user.
+// The encryption types are offered in the given order
+func KerberosASREQ(realm, username string, nonce uint32, etypes []int) []byte {
+	realm = strings.ToUpper(realm)
+
+	var etypeList [][]byte
+	for _, e := range etypes {
+		etypeList = append(etypeList, derInteger(int64(e)))
+	}
+
+	pacRequest := derSequence(derContext(0, derTLV(0x01, []byte{0xff})))
+	padata := derSequence(derSequence(
+		derContext(1, derInteger(kerberosPAPacRequest)),
+		derContext(2, derTLV(0x04, pacRequest)),
+	))
+
+	body := derSequence(
+		// forwardable, renewable and proxiable
+		derContext(0, derTLV(0x03, []byte{0x00, 0x50, 0x80, 0x00, 0x00})),
+		derContext(1, kerberosPrincipal(1, username)),
+		derContext(2, derGeneralString(realm)),
+		derContext(3, kerberosPrincipal(2, "krbtgt", realm)),
+		derContext(5, derTLV(0x18, []byte(time.Date(2037, 9, 13, 2, 48, 5, 0, time.UTC).Format("20060102150405Z")))),
+		derContext(7, derInteger(int64(nonce))),
+		derContext(8, derSequence(etypeList...)),
+	)
+
+	req := derSequence(
+		derContext(1, derInteger(5)),
+		derContext(2, derInteger(kerberosMsgASREQ)),
+		derContext(3, padata),
+		derContext(4, body),
+	)
+	return derTLV(0x60|kerberosMsgASREQ, req)
+}
+
+// KerberosTCPFrame prefixes a message with the length as required for
+// Kerberos over TCP
+func KerberosTCPFrame(msg []byte) []byte {
+	return append(PutUint32(uint32(len(msg))), msg...)
+}
+
+// ParseKerberosTCPFrame returns the message of a Kerberos over TCP
+// frame. If the frame is incomplete nil is returned
+func ParseKerberosTCPFrame(buf []byte) []byte {
+	if len(buf) < 4 {
+		return nil
+	}
+	length := binary.BigEndian.Uint32(buf[:4])
+	if uint64(len(buf)-4) < uint64(length) {
+		return nil
+	}
+	return buf[4 : 4+length]
+}
+
+// ParseKerberosASReply parses the reply to an AS-REQ. A KRB-ERROR is
+// returned as *KerberosError
+func ParseKerberosASReply(buf []byte) (*ASREP, error) {
+	var app asn1.RawValue
+	if _, err := asn1.Unmarshal(buf, &app); err != nil {
+		return nil, fmt.Errorf("invalid kerberos message: %w", err)
+	}
+	if app.Class != asn1.ClassApplication {
+		return nil, fmt.Errorf("invalid kerberos message class %d", app.Class)
+	}
+	fields, err := derContextFields(app.Bytes)
+	if err != nil {
+		return nil, err
+	}
+
+	switch app.Tag {
+	case kerberosMsgKRBError:
+		code, err := derFieldInt(fields, 6)
+		if err != nil {
+			return nil, err
+		}
+		krbErr := &KerberosError{Code: code}
+		if text, err := derFieldString(fields, 11); err == nil {
+			krbErr.Text = text
+		}
+		return nil, krbErr
+	case kerberosMsgASREP:
+		rep := &ASREP{}
+		if rep.Realm, err = derFieldString(fields, 3); err != nil {
+			return nil, err
+		}
+		cname, err := derContextFields(fields[4])
+		if err != nil {
+			return nil, fmt.Errorf("invalid cname: %w", err)
+		}
+		var names []asn1.RawValue
+		if _, err := asn1.Unmarshal(cname[1], &names); err != nil {
+			return nil, fmt.Errorf("invalid cname: %w", err)
+		}
+		var parts []string
+		for _, n := range names {
+			parts = append(parts, string(n.Bytes))
+		}
+		rep.Username = strings.Join(parts, "/")
+
+		encPart, err := derContextFields(fields[6])
+		if err != nil {
+			return nil, fmt.Errorf("invalid enc-part: %w", err)
+		}
+		if rep.EType, err = derFieldInt(encPart, 0); err != nil {
+			return nil, err
+		}
+		var cipher []byte
+		if _, err := asn1.Unmarshal(encPart[2], &cipher); err != nil {
+			return nil, fmt.Errorf("invalid cipher: %w", err)
+		}
+		rep.Cipher = cipher
+		return rep, nil
+	}
+	return nil, fmt.Errorf("unexpected kerberos message type %d", app.Tag)
+}
+
+// kerberosPrincipal encodes a PrincipalName
+func kerberosPrincipal(nameType int64, names ...string) []byte {
+	var parts [][]byte
+	for _, n := range names {
+		parts = append(parts, derGeneralString(n))
+	}
+	return derSequence(
+		derContext(0, derInteger(nameType)),
+		derContext(1, derSequence(parts...)),
+	)
+}
+
+// derContextFields parses a SEQUENCE of context specific fields and
+// returns the content of every field by tag
+func derContextFields(buf []byte) (map[int][]byte, error) {
+	var seq asn1.RawValue
+	if _, err := asn1.Unmarshal(buf, &seq); err != nil {
+		return nil, fmt.Errorf("invalid sequence: %w", err)
+	}
+	if seq.Tag != asn1.TagSequence {
+		return nil, fmt.Errorf("expected a sequence, got tag %d", seq.Tag)
+	}
+	fields := make(map[int][]byte)
+	rest := seq.Bytes
+	for len(rest) > 0 {
+		var field asn1.RawValue
+		var err error
+		rest, err = asn1.Unmarshal(rest, &field)
+		if err != nil {
+			return nil, fmt.Errorf("invalid field: %w", err)
+		}
+		if field.Class == asn1.ClassContextSpecific {
+			fields[field.Tag] = field.Bytes
+		}
+	}
+	return fields, nil
+}
+
+var errMissingField = errors.New("missing field")
+
+func derFieldInt(fields map[int][]byte, tag int) (int, error) {
+	buf, ok := fields[tag]
+	if !ok {
+		return 0, fmt.Errorf("field %d: %w", tag, errMissingField)
+	}
+	var v int
+	if _, err := asn1.Unmarshal(buf, &v); err != nil {
+		return 0, fmt.Errorf("invalid integer in field %d: %w", tag, err)
+	}
+	return v, nil
+}
+
+// derFieldString returns the content of a string field. The GeneralString
+// used by Kerberos is not supported by encoding/asn1 so the raw bytes are used
+func derFieldString(fields map[int][]byte, tag int) (string, error) {
+	buf, ok := fields[tag]
+	if !ok {
+		return "", fmt.Errorf("field %d: %w", tag, errMissingField)
+	}
+	var v asn1.RawValue
+	if _, err := asn1.Unmarshal(buf, &v); err != nil {
+		return "", fmt.Errorf("invalid string in field %d: %w", tag, err)
+	}
+	return string(v.Bytes), nil
+}
+
+func derTLV(tag byte, content []byte) []byte {
+	ret := []byte{tag}
+	ret = append(ret, derLength(len(content))...)
+	return append(ret, content...)
+}
+
+func derLength(n int) []byte {
+	if n < 0x80 {
+		return []byte{byte(n)}
+	}
+	var b []byte
+	for ; n > 0; n >>= 8 {
+		b = append([]byte{byte(n)}, b...)
+	}
+	return append([]byte{0x80 | byte(len(b))}, b...)
+}
+
+func derSequence(content ...[]byte) []byte {
+	var buf []byte
+	for _, c := range content {
+		buf = append(buf, c...)
+	}
+	return derTLV(0x30, buf)
+}
+
+func derContext(tag byte, content []byte) []byte {
+	return derTLV(0xa0|tag, content)
+}
+
+func derInteger(v int64) []byte {
+	b, _ := asn1.Marshal(v)
+	return b
+}
+
+func derGeneralString(s string) []byte {
+	return derTLV(0x1b, []byte(s))
+}
@@ -0,0 +1,106 @@
+package helper
+
+import (
+	"bytes"
+	"encoding/asn1"
+	"errors"
+	"testing"
+)
+
+func TestKerberosASREQ(t *testing.T) {
+	t.Parallel()
+	req := KerberosASREQ("corp.local", "alice", 12345, []int{KerberosETypeRC4, KerberosETypeAES256})
+
+	var app asn1.RawValue
+	rest, err := asn1.Unmarshal(req, &app)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rest) != 0 {
+		t.Errorf("unexpected trailing data %x", rest)
+	}
+	if app.Class != asn1.ClassApplication || app.Tag != 10 {
+		t.Fatalf("expected AS-REQ, got class %d tag %d", app.Class, app.Tag)
+	}
+	fields, err := derContextFields(app.Bytes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v, err := derFieldInt(fields, 1); err != nil || v != 5 {
+		t.Errorf("expected pvno 5, got %d (%v)", v, err)
+	}
+	body, err := derContextFields(fields[4])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if realm, err := derFieldString(body, 2); err != nil || realm != "CORP.LOCAL" {
+		t.Errorf("expected realm CORP.LOCAL, got %q (%v)", realm, err)
+	}
+	if nonce, err := derFieldInt(body, 7); err != nil || nonce != 12345 {
+		t.Errorf("expected nonce 12345, got %d (%v)", nonce, err)
+	}
+	var etypes []int
+	if _, err := asn1.Unmarshal(body[8], &etypes); err != nil {
+		t.Fatal(err)
+	}
+	if len(etypes) != 2 || etypes[0] != KerberosETypeRC4 {
+		t.Errorf("unexpected etypes %v", etypes)
+	}
+}
+
+func TestParseKerberosASReply(t *testing.T) {
+	t.Parallel()
+	cipher := bytes.Repeat([]byte{0xaa}, 16)
+	cipher = append(cipher, bytes.Repeat([]byte{0xbb}, 20)...)
+
+	rep := derTLV(0x60|kerberosMsgASREP, derSequence(
+		derContext(0, derInteger(5)),
+		derContext(1, derInteger(kerberosMsgASREP)),
+		derContext(3, derGeneralString("CORP.LOCAL")),
+		derContext(4, kerberosPrincipal(1, "alice")),
+		derContext(6, derSequence(
+			derContext(0, derInteger(KerberosETypeRC4)),
+			derContext(2, derTLV(0x04, cipher)),
+		)),
+	))
+	asrep, err := ParseKerberosASReply(KerberosTCPFrame(rep)[4:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if asrep.Realm != "CORP.LOCAL" || asrep.Username != "alice" || asrep.EType != KerberosETypeRC4 {
+		t.Errorf("unexpected AS-REP %+v", asrep)
+	}
+	expected := "$krb5asrep$23$alice@CORP.LOCAL:aaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaa$bbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbb"
+	if x := asrep.Hashcat(); x != expected {
+		t.Errorf("expected %s but got %s", expected, x)
+	}
+
+	asrep.EType = KerberosETypeAES256
+	expected = "$krb5asrep$18$alice$CORP.LOCAL$bbbbbbbbbbbbbbbbbbbbbbbb$aaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaabbbbbbbbbbbbbbbb"
+	if x := asrep.Hashcat(); x != expected {
+		t.Errorf("expected %s but got %s", expected, x)
+	}
+
+	krbErr := derTLV(0x60|kerberosMsgKRBError, derSequence(
+		derContext(0, derInteger(5)),
+		derContext(1, derInteger(kerberosMsgKRBError)),
+		derContext(6, derInteger(KerberosErrPreauthRequired)),
+		derContext(9, derGeneralString("CORP.LOCAL")),
+	))
+	_, err = ParseKerberosASReply(krbErr)
+	var e *KerberosError
+	if !errors.As(err, &e) || e.Code != KerberosErrPreauthRequired {
+		t.Errorf("expected KDC_ERR_PREAUTH_REQUIRED, got %v", err)
+	}
+}
+
+func TestParseKerberosTCPFrame(t *testing.T) {
+	t.Parallel()
+	frame := KerberosTCPFrame([]byte("test"))
+	if x := ParseKerberosTCPFrame(frame); !bytes.Equal(x, []byte("test")) {
+		t.Errorf("unexpected message %q", x)
+	}
+	if x := ParseKerberosTCPFrame(frame[:6]); x != nil {
+		t.Errorf("expected nil on an incomplete frame, got %q", x)
+	}
+}
@@ -0,0 +1,197 @@
+package helper
+
+import (
+	"encoding/asn1"
+	"fmt"
+	"strings"
+)
+
+// LDAP protocol operations (RFC 4511)
+const (
+	ldapSearchRequest     = 3
+	ldapSearchResultEntry = 4
+	ldapSearchResultDone  = 5
+)
+
+// ldapRootDSEAttributes are requested from the rootDSE
+var ldapRootDSEAttributes = []string{
+	"defaultNamingContext",
+	"rootDomainNamingContext",
+	"dnsHostName",
+	"ldapServiceName",
+}
+
+// LDAPRootDSE holds the attributes of the rootDSE identifying a domain
+// controller
+type LDAPRootDSE struct {
+	DefaultNamingContext    string
+	RootDomainNamingContext string
+	DNSHostName             string
+	// LDAPServiceName is in the format forest:host$@REALM on Active
+	// Directory
+	LDAPServiceName string
+}
+
+// CLDAPRootDSE builds a connectionless LDAP (RFC 1798) search for the
+// attributes of the rootDSE as sent by Active Directory clients to port
+// 389 over UDP
+func CLDAPRootDSE(messageID int64) []byte {
+	var attributes [][]byte
+	for _, a := range ldapRootDSEAttributes {
+		attributes = append(attributes, derTLV(0x04, []byte(a)))
+	}
+	search := derTLV(0x60|ldapSearchRequest, concat(
+		// the rootDSE has an empty base object
+		derTLV(0x04, nil),
+		// scope baseObject and derefAliases neverDerefAliases
+		derTLV(0x0a, []byte{0}),
+		derTLV(0x0a, []byte{0}),
+		// no size and time limit
+		derInteger(0),
+		derInteger(0),
+		// typesOnly false
+		derTLV(0x01, []byte{0}),
+		// (objectClass=*)
+		derTLV(0x87, []byte("objectClass")),
+		derSequence(attributes...),
+	))
+	return derSequence(derInteger(messageID), search)
+}
+
+func concat(parts ...[]byte) []byte {
+	var buf []byte
+	for _, p := range parts {
+		buf = append(buf, p...)
+	}
+	return buf
+}
+
+// LDAPMessageID returns the message id of a LDAP message like the request
+// built by CLDAPRootDSE
+func LDAPMessageID(msg []byte) (int64, error) {
+	var seq asn1.RawValue
+	if _, err := asn1.Unmarshal(msg, &seq); err != nil {
+		return 0, fmt.Errorf("invalid ldap message: %w", err)
+	}
+	var id int64
+	if _, err := asn1.Unmarshal(seq.Bytes, &id); err != nil {
+		return 0, fmt.Errorf("invalid ldap message id: %w", err)
+	}
+	return id, nil
+}
+
+// ParseCLDAPResponse parses the search result entry and the search result
+// done message of the response to the request with messageID
+func ParseCLDAPResponse(buf []byte, messageID int64) (*LDAPRootDSE, error) {
+	var dse *LDAPRootDSE
+	rest := buf
+	for len(rest) > 0 {
+		var msg asn1.RawValue
+		var err error
+		rest, err = asn1.Unmarshal(rest, &msg)
+		if err != nil {
+			return nil, fmt.Errorf("invalid ldap message: %w", err)
+		}
+		if msg.Tag != asn1.TagSequence {
+			return nil, fmt.Errorf("expected a sequence, got tag %d", msg.Tag)
+		}
+		var id int64
+		opBytes, err := asn1.Unmarshal(msg.Bytes, &id)
+		if err != nil {
+			return nil, fmt.Errorf("invalid ldap message id: %w", err)
+		}
+		if id != messageID {
+			return nil, fmt.Errorf("response does not belong to the request")
+		}
+		var op asn1.RawValue
+		if _, err := asn1.Unmarshal(opBytes, &op); err != nil {
+			return nil, fmt.Errorf("invalid ldap operation: %w", err)
+		}
+		if op.Class != asn1.ClassApplication {
+			return nil, fmt.Errorf("unexpected ldap operation class %d", op.Class)
+		}
+		switch op.Tag {
+		case ldapSearchResultEntry:
+			dse, err = parseLDAPEntry(op.Bytes)
+			if err != nil {
+				return nil, err
+			}
+		case ldapSearchResultDone:
+			// the result code is the first element
+			var code asn1.Enumerated
+			if _, err := asn1.Unmarshal(op.Bytes, &code); err != nil {
+				return nil, fmt.Errorf("invalid ldap result: %w", err)
+			}
+			if code != 0 {
+				return nil, fmt.Errorf("ldap search failed with result code %d", code)
+			}
+		default:
+			return nil, fmt.Errorf("unexpected ldap operation %d", op.Tag)
+		}
+	}
+	if dse == nil {
+		return nil, fmt.Errorf("no ldap search result entry")
+	}
+	return dse, nil
+}
+
+// parseLDAPEntry reads the first value of the requested attributes
+func parseLDAPEntry(buf []byte) (*LDAPRootDSE, error) {
+	var objectName []byte
+	rest, err := asn1.Unmarshal(buf, &objectName)
+	if err != nil {
+		return nil, fmt.Errorf("invalid ldap object name: %w", err)
+	}
+	var attributes []struct {
+		Type   []byte
+		Values [][]byte `asn1:"set"`
+	}
+	if _, err := asn1.Unmarshal(rest, &attributes); err != nil {
+		return nil, fmt.Errorf("invalid ldap attributes: %w", err)
+	}
+
+	dse := &LDAPRootDSE{}
+	for _, a := range attributes {
+		if len(a.Values) == 0 {
+			continue
+		}
+		value := string(a.Values[0])
+		switch strings.ToLower(string(a.Type)) {
+		case "defaultnamingcontext":
+			dse.DefaultNamingContext = value
+		case "rootdomainnamingcontext":
+			dse.RootDomainNamingContext = value
+		case "dnshostname":
+			dse.DNSHostName = value
+		case "ldapservicename":
+			dse.LDAPServiceName = value
+		}
+	}
+	return dse, nil
+}
+
+// Domain returns the DNS name of the domain of the server
+func (d LDAPRootDSE) Domain() string {
+	return dnToDNSName(d.DefaultNamingContext)
+}
+
+// Forest returns the DNS name of the forest root domain of the server
+func (d LDAPRootDSE) Forest() string {
+	if forest, _, ok := strings.Cut(d.LDAPServiceName, ":"); ok && forest != "" {
+		return forest
+	}
+	return dnToDNSName(d.RootDomainNamingContext)
+}
+
+// dnToDNSName converts the DC components of a distinguished name like
+// DC=corp,DC=example,DC=com to corp.example.com
+func dnToDNSName(dn string) string {
+	var labels []string
+	for _, rdn := range strings.Split(dn, ",") {
+		key, value, ok := strings.Cut(strings.TrimSpace(rdn), "=")
+		if ok && strings.EqualFold(key, "dc") {
+			labels = append(labels, value)
+		}
+	}
+	return strings.Join(labels, ".")
+}
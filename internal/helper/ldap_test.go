@@ -0,0 +1,63 @@
+package helper
+
+import (
+	"bytes"
+	"testing"
+)
+
+func ldapAttribute(name string, values ...string) []byte {
+	var vals []byte
+	for _, v := range values {
+		vals = append(vals, derTLV(0x04, []byte(v))...)
+	}
+	return derSequence(derTLV(0x04, []byte(name)), derTLV(0x31, vals))
+}
+
+func TestCLDAPRootDSE(t *testing.T) {
+	t.Parallel()
+	req := CLDAPRootDSE(7)
+	if req[0] != 0x30 || !bytes.Contains(req, []byte("\x87\x0bobjectClass")) || !bytes.Contains(req, []byte("defaultNamingContext")) {
+		t.Errorf("invalid request %x", req)
+	}
+	if id, err := LDAPMessageID(req); err != nil || id != 7 {
+		t.Errorf("unexpected message id %d: %v", id, err)
+	}
+}
+
+func TestParseCLDAPResponse(t *testing.T) {
+	t.Parallel()
+	entry := derTLV(0x64, concat(
+		derTLV(0x04, nil),
+		derSequence(
+			ldapAttribute("defaultNamingContext", "DC=child,DC=corp,DC=local"),
+			ldapAttribute("rootDomainNamingContext", "DC=corp,DC=local"),
+			ldapAttribute("dnsHostName", "dc01.child.corp.local"),
+			ldapAttribute("ldapServiceName", "corp.local:dc01$@CHILD.CORP.LOCAL"),
+		),
+	))
+	done := derTLV(0x65, concat(derTLV(0x0a, []byte{0}), derTLV(0x04, nil), derTLV(0x04, nil)))
+	resp := append(derSequence(derInteger(7), entry), derSequence(derInteger(7), done)...)
+
+	dse, err := ParseCLDAPResponse(resp, 7)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if dse.DNSHostName != "dc01.child.corp.local" || dse.Domain() != "child.corp.local" || dse.Forest() != "corp.local" {
+		t.Errorf("unexpected rootDSE %+v", dse)
+	}
+
+	if _, err := ParseCLDAPResponse(resp, 8); err == nil {
+		t.Errorf("expected an error for a different message id")
+	}
+	failed := derTLV(0x65, concat(derTLV(0x0a, []byte{1}), derTLV(0x04, nil), derTLV(0x04, nil)))
+	if _, err := ParseCLDAPResponse(derSequence(derInteger(7), failed), 7); err == nil {
+		t.Errorf("expected an error for a failed search")
+	}
+}
+
+func TestDNToDNSName(t *testing.T) {
+	t.Parallel()
+	if name := dnToDNSName("CN=Configuration, DC=corp,dc=example,DC=com"); name != "corp.example.com" {
+		t.Errorf("unexpected name %q", name)
+	}
+}
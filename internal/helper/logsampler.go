@@ -0,0 +1,112 @@
+package helper
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"sort"
+	"sync"
+)
+
+// ErrorLogger is the part of the logger needed by the LogSampler
+type ErrorLogger interface {
+	Errorf(format string, args ...interface{})
+	Warnf(format string, args ...interface{})
+}
+
+var numberRegex = regexp.MustCompile(`[0-9]+`)
+
+// LogSampler limits the number of similar error messages written to the
+// log so big scans don't flood the output with identical timeouts or
+// permission errors. Messages are similar if they use the same format
+// and their errors have the same root cause. A nil LogSampler does nothing.
+type LogSampler struct {
+	log        ErrorLogger
+	limit      int
+	mu         sync.Mutex
+	counts     map[string]int
+	formats    map[string]string
+	suppressed map[string]int
+}
+
+// NewLogSampler returns a LogSampler that logs limit messages of every
+// kind. A limit of 0 or less logs all messages
+func NewLogSampler(log ErrorLogger, limit int) *LogSampler {
+	return &LogSampler{
+		log:        log,
+		limit:      limit,
+		counts:     make(map[string]int),
+		formats:    make(map[string]string),
+		suppressed: make(map[string]int),
+	}
+}
+
+// Errorf logs the message unless the limit of similar messages is reached
+func (s *LogSampler) Errorf(format string, args ...interface{}) {
+	if s == nil {
+		return
+	}
+	if s.limit <= 0 {
+		s.log.Errorf(format, args...)
+		return
+	}
+
+	key := sampleKey(format, args...)
+	s.mu.Lock()
+	s.counts[key]++
+	count := s.counts[key]
+	if count > s.limit {
+		s.formats[key] = format
+		s.suppressed[key]++
+	}
+	s.mu.Unlock()
+
+	if count > s.limit {
+		return
+	}
+	s.log.Errorf(format, args...)
+	if count == s.limit {
+		s.log.Warnf("further errors similar to the last one are suppressed")
+	}
+}
+
+// Flush logs the number of suppressed messages of every kind
+// and resets the counters
+func (s *LogSampler) Flush() {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	keys := make([]string, 0, len(s.suppressed))
+	for key := range s.suppressed {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		s.log.Warnf("%d similar errors suppressed: %q", s.suppressed[key], s.formats[key])
+	}
+
+	s.counts = make(map[string]int)
+	s.formats = make(map[string]string)
+	s.suppressed = make(map[string]int)
+}
+
+// sampleKey returns the format and the root cause of all error
+// arguments. Other arguments like the scanned IP are ignored and
+// numbers in the root causes are normalized.
+func sampleKey(format string, args ...interface{}) string {
+	key := format
+	for _, arg := range args {
+		err, ok := arg.(error)
+		if !ok || err == nil {
+			continue
+		}
+		for errors.Unwrap(err) != nil {
+			err = errors.Unwrap(err)
+		}
+		key = fmt.Sprintf("%s|%s", key, numberRegex.ReplaceAllString(err.Error(), "N"))
+	}
+	return key
+}
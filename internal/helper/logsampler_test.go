@@ -0,0 +1,88 @@
+package helper
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+type testLogger struct {
+	errors   []string
+	warnings []string
+}
+
+func (l *testLogger) Errorf(format string, args ...interface{}) {
+	l.errors = append(l.errors, fmt.Sprintf(format, args...))
+}
+
+func (l *testLogger) Warnf(format string, args ...interface{}) {
+	l.warnings = append(l.warnings, fmt.Sprintf(format, args...))
+}
+
+func TestLogSampler(t *testing.T) {
+	t.Parallel()
+	log := &testLogger{}
+	s := NewLogSampler(log, 2)
+	for i := 0; i < 10; i++ {
+		s.Errorf("error on scanning 10.0.0.%d: %v", i, fmt.Errorf("error on sending request: %w", ErrTimeout))
+	}
+	s.Errorf("error on scanning 10.0.0.1: %v", errors.New("permission denied"))
+
+	if len(log.errors) != 3 {
+		t.Fatalf("expected 3 logged errors, got %d: %v", len(log.errors), log.errors)
+	}
+	if len(log.warnings) != 1 {
+		t.Fatalf("expected 1 warning, got %d: %v", len(log.warnings), log.warnings)
+	}
+
+	s.Flush()
+	if len(log.warnings) != 2 {
+		t.Fatalf("expected 2 warnings after flush, got %d: %v", len(log.warnings), log.warnings)
+	}
+	if !strings.HasPrefix(log.warnings[1], "8 similar errors suppressed") {
+		t.Errorf("unexpected summary %q", log.warnings[1])
+	}
+
+	// counters are reset on flush
+	s.Errorf("error on scanning 10.0.0.1: %v", ErrTimeout)
+	if len(log.errors) != 4 {
+		t.Errorf("expected error to be logged after flush")
+	}
+}
+
+func TestLogSamplerNoLimit(t *testing.T) {
+	t.Parallel()
+	log := &testLogger{}
+	s := NewLogSampler(log, 0)
+	for i := 0; i < 10; i++ {
+		s.Errorf("error: %v", ErrTimeout)
+	}
+	s.Flush()
+	if len(log.errors) != 10 {
+		t.Errorf("expected 10 logged errors, got %d", len(log.errors))
+	}
+	if len(log.warnings) != 0 {
+		t.Errorf("expected no warnings, got %v", log.warnings)
+	}
+}
+
+func TestLogSamplerNil(t *testing.T) {
+	t.Parallel()
+	var s *LogSampler
+	s.Errorf("error: %v", ErrTimeout)
+	s.Flush()
+}
+
+func TestSampleKey(t *testing.T) {
+	t.Parallel()
+	a := sampleKey("error on %s: %v", "10.0.0.1", errors.New("read udp 10.0.0.5:1234: connection refused"))
+	b := sampleKey("error on %s: %v", "10.0.0.2", errors.New("read udp 10.0.0.6:4321: connection refused"))
+	if a != b {
+		t.Errorf("expected equal keys, got %q and %q", a, b)
+	}
+	c := sampleKey("error on %s: %v", "10.0.0.1", ErrTimeout)
+	if a == c {
+		t.Errorf("expected different keys for different errors")
+	}
+}
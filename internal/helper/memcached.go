@@ -0,0 +1,82 @@
+package helper
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// memcachedFrameLength is the size of the frame header of the memcached
+// UDP protocol
+const memcachedFrameLength = 8
+
+// MemcachedStats holds the parsed parts of the stats of a memcached server
+type MemcachedStats struct {
+	Version   string
+	Uptime    time.Duration
+	CurrItems int
+}
+
+// MemcachedStatsRequest builds a stats command in a memcached UDP frame
+// (request id, sequence number, number of datagrams and a reserved field)
+func MemcachedStatsRequest(requestID uint16) []byte {
+	buf := make([]byte, memcachedFrameLength)
+	binary.BigEndian.PutUint16(buf[0:2], requestID)
+	binary.BigEndian.PutUint16(buf[4:6], 1)
+	return append(buf, []byte("stats\r\n")...)
+}
+
+// ParseMemcachedFrame returns the sequence number, the total number of
+// datagrams and the payload of a response to the request with requestID
+func ParseMemcachedFrame(buf []byte, requestID uint16) (uint16, uint16, []byte, error) {
+	if len(buf) < memcachedFrameLength {
+		return 0, 0, nil, fmt.Errorf("invalid memcached frame length %d", len(buf))
+	}
+	if binary.BigEndian.Uint16(buf[0:2]) != requestID {
+		return 0, 0, nil, fmt.Errorf("response does not belong to the request")
+	}
+	seq := binary.BigEndian.Uint16(buf[2:4])
+	total := binary.BigEndian.Uint16(buf[4:6])
+	if total == 0 || seq >= total {
+		return 0, 0, nil, fmt.Errorf("invalid memcached sequence %d of %d", seq, total)
+	}
+	return seq, total, buf[memcachedFrameLength:], nil
+}
+
+// ParseMemcachedStats parses the STAT lines of a stats response. Responses
+// split across datagrams can be passed partially
+func ParseMemcachedStats(payload []byte) (*MemcachedStats, error) {
+	if !bytes.HasPrefix(payload, []byte("STAT ")) {
+		return nil, fmt.Errorf("invalid memcached stats response %q", bannerFirstLine(payload))
+	}
+	stats := &MemcachedStats{}
+	scanner := bufio.NewScanner(bytes.NewReader(payload))
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) != 3 || fields[0] != "STAT" {
+			continue
+		}
+		switch fields[1] {
+		case "version":
+			stats.Version = fields[2]
+		case "uptime":
+			if seconds, err := strconv.ParseInt(fields[2], 10, 64); err == nil {
+				stats.Uptime = time.Duration(seconds) * time.Second
+			}
+		case "curr_items":
+			if items, err := strconv.Atoi(fields[2]); err == nil {
+				stats.CurrItems = items
+			}
+		}
+	}
+	return stats, nil
+}
+
+// String returns a short description of the server
+func (s MemcachedStats) String() string {
+	return fmt.Sprintf("memcached %s uptime %s items %d", s.Version, s.Uptime, s.CurrItems)
+}
@@ -0,0 +1,42 @@
+package helper
+
+import (
+	"bytes"
+	"testing"
+	"time"
+)
+
+func TestMemcachedStatsRequest(t *testing.T) {
+	t.Parallel()
+	req := MemcachedStatsRequest(0x1234)
+	expected := []byte("\x12\x34\x00\x00\x00\x01\x00\x00stats\r\n")
+	if !bytes.Equal(req, expected) {
+		t.Errorf("expected %q, got %q", expected, req)
+	}
+}
+
+func TestParseMemcached(t *testing.T) {
+	t.Parallel()
+	resp := []byte("\x12\x34\x00\x00\x00\x02\x00\x00STAT pid 1\r\nSTAT uptime 3600\r\nSTAT time 1700000000\r\nSTAT version 1.6.21\r\nSTAT curr_items 42\r\n")
+	seq, total, payload, err := ParseMemcachedFrame(resp, 0x1234)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if seq != 0 || total != 2 {
+		t.Errorf("unexpected sequence %d of %d", seq, total)
+	}
+	stats, err := ParseMemcachedStats(payload)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats.Version != "1.6.21" || stats.Uptime != time.Hour || stats.CurrItems != 42 {
+		t.Errorf("unexpected stats %+v", stats)
+	}
+
+	if _, _, _, err := ParseMemcachedFrame(resp, 1); err == nil {
+		t.Errorf("expected an error for a different request id")
+	}
+	if _, err := ParseMemcachedStats([]byte("ERROR\r\n")); err == nil {
+		t.Errorf("expected an error for an invalid response")
+	}
+}
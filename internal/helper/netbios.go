@@ -0,0 +1,298 @@
+package helper
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math/rand"
+	"net"
+	"net/netip"
+	"strings"
+)
+
+// NetBIOS name service resource record types
+const (
+	// NBNSTypeNB is the general name service resource record type
+	NBNSTypeNB uint16 = 0x0020
+	// NBNSTypeNBSTAT is the node status resource record type
+	NBNSTypeNBSTAT uint16 = 0x0021
+)
+
+// NBNSResponse holds the parsed parts of a NetBIOS name query response
+type NBNSResponse struct {
+	ID    uint16
+	Name  string
+	Addrs []netip.Addr
+}
+
+// NBNSQuery builds a broadcast NetBIOS name query (RFC1002 section 4.2.12)
+// for the workstation service of the name
+func NBNSQuery(name string) []byte {
+	var nbns []byte
+
+	// transactionID
+	nbns = append(nbns, PutUint16(uint16(rand.Uint32()))...)
+	// FLAGS: name query, recursion desired, broadcast
+	nbns = append(nbns, []byte{0x01, 0x10}...)
+	// Questions: 1
+	nbns = append(nbns, PutUint16(1)...)
+	// Answer RRs: 0
+	nbns = append(nbns, PutUint16(0)...)
+	// Authority RRs: 0
+	nbns = append(nbns, PutUint16(0)...)
+	// Additional RRs: 0
+	nbns = append(nbns, PutUint16(0)...)
+
+	nbns = append(nbns, encodeNetBIOSName(name, 0x00)...)
+	nbns = append(nbns, PutUint16(NBNSTypeNB)...)
+	// Class: IN
+	nbns = append(nbns, PutUint16(1)...)
+
+	return nbns
+}
+
+// NBSTATQuery builds a node status request (RFC1002 section 4.2.17) for
+// the wildcard name, which is answered with the name table of the host
+func NBSTATQuery() []byte {
+	var nbns []byte
+
+	// transactionID
+	nbns = append(nbns, PutUint16(uint16(rand.Uint32()))...)
+	// FLAGS: name query, unicast
+	nbns = append(nbns, []byte{0x00, 0x00}...)
+	// Questions: 1
+	nbns = append(nbns, PutUint16(1)...)
+	// Answer RRs: 0
+	nbns = append(nbns, PutUint16(0)...)
+	// Authority RRs: 0
+	nbns = append(nbns, PutUint16(0)...)
+	// Additional RRs: 0
+	nbns = append(nbns, PutUint16(0)...)
+
+	// the wildcard name is padded with null bytes instead of spaces
+	wildcard := make([]byte, 16)
+	wildcard[0] = '*'
+	nbns = append(nbns, encodeNetBIOSRaw(wildcard)...)
+	nbns = append(nbns, PutUint16(NBNSTypeNBSTAT)...)
+	// Class: IN
+	nbns = append(nbns, PutUint16(1)...)
+
+	return nbns
+}
+
+// encodeNetBIOSName returns the first level encoding of the name as a
+// single label. The name is padded to 15 bytes and the suffix is appended
+func encodeNetBIOSName(name string, suffix byte) []byte {
+	raw := []byte(strings.ToUpper(name))
+	if len(raw) > 15 {
+		raw = raw[:15]
+	}
+	for len(raw) < 15 {
+		raw = append(raw, ' ')
+	}
+	return encodeNetBIOSRaw(append(raw, suffix))
+}
+
+// encodeNetBIOSRaw returns the first level encoding of the 16 bytes of a
+// padded name and its suffix
+func encodeNetBIOSRaw(raw []byte) []byte {
+	buf := []byte{32}
+	for _, b := range raw {
+		buf = append(buf, 'A'+b>>4, 'A'+b&0x0f)
+	}
+	// terminate with a null byte
+	return append(buf, 0x00)
+}
+
+// decodeNetBIOSName reverses the first level encoding and strips the
+// padding and the suffix
+func decodeNetBIOSName(encoded string) (string, error) {
+	if len(encoded) != 32 {
+		return "", fmt.Errorf("invalid netbios name length %d", len(encoded))
+	}
+	var raw []byte
+	for i := 0; i < len(encoded); i += 2 {
+		hi, lo := encoded[i]-'A', encoded[i+1]-'A'
+		if hi > 0x0f || lo > 0x0f {
+			return "", fmt.Errorf("invalid netbios name encoding %q", encoded)
+		}
+		raw = append(raw, hi<<4|lo)
+	}
+	return strings.TrimRight(string(raw[:15]), " "), nil
+}
+
+// ParseNBNSResponse parses a positive NetBIOS name query response
+func ParseNBNSResponse(buf []byte) (*NBNSResponse, error) {
+	if len(buf) < 12 {
+		return nil, fmt.Errorf("invalid nbns message length %d", len(buf))
+	}
+	flags := binary.BigEndian.Uint16(buf[2:4])
+	if flags&0x8000 == 0 {
+		return nil, fmt.Errorf("message is not a response")
+	}
+	if rcode := flags & 0x000f; rcode != 0 {
+		return nil, fmt.Errorf("negative response with rcode %d", rcode)
+	}
+	if anCount := binary.BigEndian.Uint16(buf[6:8]); anCount == 0 {
+		return nil, fmt.Errorf("response contains no answer")
+	}
+
+	resp := &NBNSResponse{
+		ID: binary.BigEndian.Uint16(buf[0:2]),
+	}
+	encoded, offset, err := readDNSName(buf, 12)
+	if err != nil {
+		return nil, fmt.Errorf("invalid answer: %w", err)
+	}
+	if resp.Name, err = decodeNetBIOSName(encoded); err != nil {
+		return nil, err
+	}
+	// type, class, ttl and rdlength
+	if offset+10 > len(buf) {
+		return nil, fmt.Errorf("answer is truncated")
+	}
+	dataLen := int(binary.BigEndian.Uint16(buf[offset+8 : offset+10]))
+	offset += 10
+	if offset+dataLen > len(buf) {
+		return nil, fmt.Errorf("data of answer is truncated")
+	}
+	// every entry consists of the name flags and an IPv4 address
+	for data := buf[offset : offset+dataLen]; len(data) >= 6; data = data[6:] {
+		ip, _ := netip.AddrFromSlice(data[2:6])
+		resp.Addrs = append(resp.Addrs, ip)
+	}
+	return resp, nil
+}
+
+// NetBIOSName is an entry of the name table of a host
+type NetBIOSName struct {
+	Name string
+	// Suffix is the 16th byte of the name and identifies the service
+	Suffix byte
+	Group  bool
+	Flags  uint16
+}
+
+// netbiosSuffixes are the services of common unique and group names
+var netbiosSuffixes = map[bool]map[byte]string{
+	false: {
+		0x00: "workstation",
+		0x03: "messenger",
+		0x1b: "domain master browser",
+		0x1d: "master browser",
+		0x20: "file server",
+	},
+	true: {
+		0x00: "domain",
+		0x1c: "domain controllers",
+		0x1e: "browser election",
+	},
+}
+
+// Service returns the service of the name or an empty string if it is
+// unknown
+func (n NetBIOSName) Service() string {
+	return netbiosSuffixes[n.Group][n.Suffix]
+}
+
+func (n NetBIOSName) String() string {
+	kind := "UNIQUE"
+	if n.Group {
+		kind = "GROUP"
+	}
+	s := fmt.Sprintf("%s<%02x> %s", n.Name, n.Suffix, kind)
+	if service := n.Service(); service != "" {
+		s += " " + service
+	}
+	return s
+}
+
+// NBSTATResponse holds the name table and MAC address of a host
+type NBSTATResponse struct {
+	ID    uint16
+	Names []NetBIOSName
+	// MAC is the unit ID of the host. Samba reports all zeros
+	MAC net.HardwareAddr
+}
+
+// Hostname returns the unique workstation name of the host
+func (r NBSTATResponse) Hostname() string {
+	for _, n := range r.Names {
+		if !n.Group && n.Suffix == 0x00 {
+			return n.Name
+		}
+	}
+	return ""
+}
+
+// Domain returns the workgroup or domain the host is a member of
+func (r NBSTATResponse) Domain() string {
+	for _, n := range r.Names {
+		if n.Group && n.Suffix == 0x00 {
+			return n.Name
+		}
+	}
+	return ""
+}
+
+// ParseNBSTATResponse parses a node status response
+func ParseNBSTATResponse(buf []byte) (*NBSTATResponse, error) {
+	if len(buf) < 12 {
+		return nil, fmt.Errorf("invalid nbns message length %d", len(buf))
+	}
+	flags := binary.BigEndian.Uint16(buf[2:4])
+	if flags&0x8000 == 0 {
+		return nil, fmt.Errorf("message is not a response")
+	}
+	if rcode := flags & 0x000f; rcode != 0 {
+		return nil, fmt.Errorf("negative response with rcode %d", rcode)
+	}
+	if anCount := binary.BigEndian.Uint16(buf[6:8]); anCount == 0 {
+		return nil, fmt.Errorf("response contains no answer")
+	}
+
+	resp := &NBSTATResponse{
+		ID: binary.BigEndian.Uint16(buf[0:2]),
+	}
+	_, offset, err := readDNSName(buf, 12)
+	if err != nil {
+		return nil, fmt.Errorf("invalid answer: %w", err)
+	}
+	// type, class, ttl and rdlength
+	if offset+10 > len(buf) {
+		return nil, fmt.Errorf("answer is truncated")
+	}
+	if t := binary.BigEndian.Uint16(buf[offset : offset+2]); t != NBNSTypeNBSTAT {
+		return nil, fmt.Errorf("answer is not a node status but type %d", t)
+	}
+	dataLen := int(binary.BigEndian.Uint16(buf[offset+8 : offset+10]))
+	offset += 10
+	if offset+dataLen > len(buf) {
+		return nil, fmt.Errorf("data of answer is truncated")
+	}
+	data := buf[offset : offset+dataLen]
+	if len(data) < 1 {
+		return nil, fmt.Errorf("data of answer is truncated")
+	}
+
+	// every name consists of 15 bytes name, the suffix and 2 bytes flags
+	count := int(data[0])
+	data = data[1:]
+	if len(data) < count*18 {
+		return nil, fmt.Errorf("name table is truncated")
+	}
+	for i := 0; i < count; i++ {
+		entry := data[i*18 : (i+1)*18]
+		flags := binary.BigEndian.Uint16(entry[16:18])
+		resp.Names = append(resp.Names, NetBIOSName{
+			Name:   strings.TrimRight(string(entry[:15]), " \x00"),
+			Suffix: entry[15],
+			Group:  flags&0x8000 != 0,
+			Flags:  flags,
+		})
+	}
+	// the statistics start with the unit ID
+	if stats := data[count*18:]; len(stats) >= 6 {
+		resp.MAC = net.HardwareAddr(append([]byte{}, stats[:6]...))
+	}
+	return resp, nil
+}
@@ -0,0 +1,108 @@
+package helper
+
+import (
+	"encoding/hex"
+	"net/netip"
+	"testing"
+)
+
+func TestNBNSQuery(t *testing.T) {
+	t.Parallel()
+	q := NBNSQuery("fileserver")
+	// skip the random transaction id
+	expected := "01100001000000000000" + "20" + "4547454a454d454646444546464346474546464343414341434143414341414100" + "00200001"
+	if h := hex.EncodeToString(q[2:]); h != expected {
+		t.Errorf("expected %q, got %q", expected, h)
+	}
+}
+
+func TestParseNBNSResponse(t *testing.T) {
+	t.Parallel()
+	var resp []byte
+	resp = append(resp, 0x13, 0x37, 0x85, 0x00)
+	resp = append(resp, 0x00, 0x00, 0x00, 0x01, 0x00, 0x00, 0x00, 0x00)
+	resp = append(resp, encodeNetBIOSName("fileserver", 0x00)...)
+	resp = append(resp, PutUint16(NBNSTypeNB)...)
+	resp = append(resp, PutUint16(1)...)
+	resp = append(resp, PutUint32(300)...)
+	resp = append(resp, PutUint16(6)...)
+	resp = append(resp, 0x00, 0x00, 10, 0, 0, 5)
+
+	r, err := ParseNBNSResponse(resp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.ID != 0x1337 || r.Name != "FILESERVER" {
+		t.Errorf("unexpected response %+v", r)
+	}
+	if len(r.Addrs) != 1 || r.Addrs[0] != netip.MustParseAddr("10.0.0.5") {
+		t.Errorf("unexpected addresses %v", r.Addrs)
+	}
+
+	if _, err := ParseNBNSResponse(resp[:len(resp)-3]); err == nil {
+		t.Error("expected an error on a truncated response")
+	}
+	// a query is not a response
+	if _, err := ParseNBNSResponse(NBNSQuery("fileserver")); err == nil {
+		t.Error("expected an error on a query")
+	}
+}
+
+func TestNBSTATQuery(t *testing.T) {
+	t.Parallel()
+	q := NBSTATQuery()
+	// skip the random transaction id
+	expected := "00000001000000000000" + "20" + "434b41414141414141414141414141414141414141414141414141414141414100" + "00210001"
+	if h := hex.EncodeToString(q[2:]); h != expected {
+		t.Errorf("expected %q, got %q", expected, h)
+	}
+}
+
+func TestParseNBSTATResponse(t *testing.T) {
+	t.Parallel()
+	entry := func(name string, suffix byte, flags uint16) []byte {
+		raw := []byte(name)
+		for len(raw) < 15 {
+			raw = append(raw, ' ')
+		}
+		raw = append(raw, suffix)
+		return append(raw, PutUint16(flags)...)
+	}
+	var data []byte
+	data = append(data, 3)
+	data = append(data, entry("FILESERVER", 0x00, 0x0400)...)
+	data = append(data, entry("CORP", 0x00, 0x8400)...)
+	data = append(data, entry("FILESERVER", 0x20, 0x0400)...)
+	data = append(data, 0x00, 0x15, 0x5d, 0x01, 0x02, 0x03)
+
+	var resp []byte
+	resp = append(resp, 0x13, 0x37, 0x84, 0x00)
+	resp = append(resp, 0x00, 0x00, 0x00, 0x01, 0x00, 0x00, 0x00, 0x00)
+	resp = append(resp, NBSTATQuery()[12:46]...)
+	resp = append(resp, PutUint16(NBNSTypeNBSTAT)...)
+	resp = append(resp, PutUint16(1)...)
+	resp = append(resp, PutUint32(0)...)
+	resp = append(resp, PutUint16(uint16(len(data)))...)
+	resp = append(resp, data...)
+
+	r, err := ParseNBSTATResponse(resp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.Hostname() != "FILESERVER" || r.Domain() != "CORP" {
+		t.Errorf("unexpected hostname %q and domain %q", r.Hostname(), r.Domain())
+	}
+	if len(r.Names) != 3 || r.Names[2].String() != "FILESERVER<20> UNIQUE file server" {
+		t.Errorf("unexpected names %v", r.Names)
+	}
+	if r.MAC.String() != "00:15:5d:01:02:03" {
+		t.Errorf("unexpected MAC %s", r.MAC)
+	}
+
+	if _, err := ParseNBSTATResponse(resp[:len(resp)-30]); err == nil {
+		t.Error("expected an error on a truncated response")
+	}
+	if _, err := ParseNBSTATResponse(NBSTATQuery()); err == nil {
+		t.Error("expected an error on a query")
+	}
+}
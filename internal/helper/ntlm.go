@@ -0,0 +1,238 @@
+package helper
+
+import (
+	"crypto/hmac"
+	"crypto/md5"
+	"crypto/rand"
+	"encoding/binary"
+	"fmt"
+	"math/bits"
+	"strings"
+	"time"
+	"unicode/utf16"
+)
+
+// NTLM negotiate flags from MS-NLMP 2.2.2.5
+const (
+	ntlmNegotiateUnicode          uint32 = 0x00000001
+	ntlmRequestTarget             uint32 = 0x00000004
+	ntlmNegotiateSign             uint32 = 0x00000010
+	ntlmNegotiateNTLM             uint32 = 0x00000200
+	ntlmNegotiateAnonymous        uint32 = 0x00000800
+	ntlmNegotiateAlwaysSign       uint32 = 0x00008000
+	ntlmNegotiateExtendedSecurity uint32 = 0x00080000
+	ntlmNegotiateTargetInfo       uint32 = 0x00800000
+	ntlmNegotiate128              uint32 = 0x20000000
+	ntlmNegotiate56               uint32 = 0x80000000
+
+	ntlmFlags = ntlmNegotiateUnicode | ntlmRequestTarget | ntlmNegotiateSign | ntlmNegotiateNTLM |
+		ntlmNegotiateAlwaysSign | ntlmNegotiateExtendedSecurity | ntlmNegotiateTargetInfo |
+		ntlmNegotiate128 | ntlmNegotiate56
+
+	// ntlmAvTimestamp is the AV_PAIR of the server time in the target info
+	ntlmAvTimestamp = 7
+)
+
+var (
+	ntlmSignature = []byte("NTLMSSP\x00")
+	// object identifiers of SPNEGO and NTLMSSP
+	oidSPNEGO  = []byte{0x2b, 0x06, 0x01, 0x05, 0x05, 0x02}
+	oidNTLMSSP = []byte{0x2b, 0x06, 0x01, 0x04, 0x01, 0x82, 0x37, 0x02, 0x02, 0x0a}
+)
+
+// NTLMChallenge is the CHALLENGE_MESSAGE of the server
+type NTLMChallenge struct {
+	Flags           uint32
+	ServerChallenge []byte
+	TargetInfo      []byte
+}
+
+// NTLMNegotiate returns the NEGOTIATE_MESSAGE wrapped in a SPNEGO
+// NegTokenInit
+func NTLMNegotiate() []byte {
+	msg := make([]byte, 32)
+	copy(msg, ntlmSignature)
+	binary.LittleEndian.PutUint32(msg[8:], 1)
+	binary.LittleEndian.PutUint32(msg[12:], ntlmFlags)
+	// the domain and workstation fields stay empty
+
+	mechTypes := derContext(0, derSequence(derTLV(0x06, oidNTLMSSP)))
+	mechToken := derContext(2, derTLV(0x04, msg))
+	return derTLV(0x60, concat(derTLV(0x06, oidSPNEGO), derContext(0, derSequence(mechTypes, mechToken))))
+}
+
+// ParseNTLMChallenge returns the CHALLENGE_MESSAGE of a security buffer.
+// The message is located by its signature, so it may be sent raw or
+// wrapped in a SPNEGO NegTokenResp
+func ParseNTLMChallenge(buf []byte) (*NTLMChallenge, error) {
+	start := strings.Index(string(buf), string(ntlmSignature))
+	if start < 0 {
+		return nil, fmt.Errorf("no NTLM challenge in the security buffer")
+	}
+	msg := buf[start:]
+	if len(msg) < 48 {
+		return nil, fmt.Errorf("NTLM challenge is too short")
+	}
+	if t := binary.LittleEndian.Uint32(msg[8:]); t != 2 {
+		return nil, fmt.Errorf("expected an NTLM challenge but got message type %d", t)
+	}
+	c := &NTLMChallenge{
+		Flags:           binary.LittleEndian.Uint32(msg[20:]),
+		ServerChallenge: msg[24:32],
+	}
+	length := int(binary.LittleEndian.Uint16(msg[40:]))
+	offset := int(binary.LittleEndian.Uint32(msg[44:]))
+	if offset+length > len(msg) {
+		return nil, fmt.Errorf("NTLM target info exceeds the message")
+	}
+	c.TargetInfo = msg[offset : offset+length]
+	return c, nil
+}
+
+// timestamp returns the server time of the target info as FILETIME
+func (c *NTLMChallenge) timestamp() ([]byte, bool) {
+	info := c.TargetInfo
+	for len(info) >= 4 {
+		id := binary.LittleEndian.Uint16(info)
+		length := int(binary.LittleEndian.Uint16(info[2:]))
+		if len(info) < 4+length || id == 0 {
+			break
+		}
+		if id == ntlmAvTimestamp && length == 8 {
+			return info[4:12], true
+		}
+		info = info[4+length:]
+	}
+	return nil, false
+}
+
+// NTLMAuthenticate returns the NTLMv2 AUTHENTICATE_MESSAGE to the
+// challenge wrapped in a SPNEGO NegTokenResp and the session key. An
+// empty username logs in anonymously without a session key
+func NTLMAuthenticate(challenge *NTLMChallenge, domain, username, password string) ([]byte, []byte, error) {
+	flags := ntlmFlags & challenge.Flags
+	var lmResponse, ntResponse, sessionKey []byte
+	if username == "" {
+		flags |= ntlmNegotiateAnonymous
+		lmResponse = []byte{0}
+	} else {
+		clientChallenge := make([]byte, 8)
+		if _, err := rand.Read(clientChallenge); err != nil {
+			return nil, nil, err
+		}
+		timestamp, ok := challenge.timestamp()
+		if !ok {
+			timestamp = fileTime(time.Now())
+		}
+		ntResponse, sessionKey = ntlmV2Response(domain, username, password, challenge.ServerChallenge, clientChallenge, timestamp, challenge.TargetInfo)
+		// the LMv2 response is replaced by zeros as the NTLMv2 response
+		// carries the timestamp
+		lmResponse = make([]byte, 24)
+	}
+
+	fields := [][]byte{lmResponse, ntResponse, utf16LE(domain), utf16LE(username), nil, nil}
+	msg := make([]byte, 64)
+	copy(msg, ntlmSignature)
+	binary.LittleEndian.PutUint32(msg[8:], 3)
+	for i, field := range fields {
+		binary.LittleEndian.PutUint16(msg[12+8*i:], uint16(len(field)))
+		binary.LittleEndian.PutUint16(msg[14+8*i:], uint16(len(field)))
+		binary.LittleEndian.PutUint32(msg[16+8*i:], uint32(len(msg)))
+		msg = append(msg, field...)
+	}
+	binary.LittleEndian.PutUint32(msg[60:], flags)
+
+	return derContext(1, derSequence(derContext(2, derTLV(0x04, msg)))), sessionKey, nil
+}
+
+// ntlmV2Response returns the NTLMv2 response and the session base key
+// as defined in MS-NLMP 3.3.2
+func ntlmV2Response(domain, username, password string, serverChallenge, clientChallenge, timestamp, targetInfo []byte) ([]byte, []byte) {
+	ntHash := md4Sum(utf16LE(password))
+	responseKey := hmacMD5(ntHash[:], utf16LE(strings.ToUpper(username)+domain))
+
+	temp := []byte{0x01, 0x01, 0, 0, 0, 0, 0, 0}
+	temp = append(temp, timestamp...)
+	temp = append(temp, clientChallenge...)
+	temp = append(temp, 0, 0, 0, 0)
+	temp = append(temp, targetInfo...)
+	temp = append(temp, 0, 0, 0, 0)
+
+	proof := hmacMD5(responseKey, append(append([]byte{}, serverChallenge...), temp...))
+	return append(proof, temp...), hmacMD5(responseKey, proof)
+}
+
+// fileTime returns the time as FILETIME, the 100ns intervals since 1601
+func fileTime(t time.Time) []byte {
+	const epochDiff = 116444736000000000
+	buf := make([]byte, 8)
+	binary.LittleEndian.PutUint64(buf, uint64(t.UnixNano()/100+epochDiff))
+	return buf
+}
+
+func hmacMD5(key, data []byte) []byte {
+	mac := hmac.New(md5.New, key)
+	mac.Write(data)
+	return mac.Sum(nil)
+}
+
+// utf16LE encodes the string as UTF-16 little endian
+func utf16LE(s string) []byte {
+	encoded := utf16.Encode([]rune(s))
+	buf := make([]byte, 2*len(encoded))
+	for i, c := range encoded {
+		binary.LittleEndian.PutUint16(buf[2*i:], c)
+	}
+	return buf
+}
+
+// md4Sum returns the MD4 hash of RFC 1320 the NT hash is based on. It is
+// not part of the standard library
+func md4Sum(data []byte) [16]byte {
+	a, b, c, d := uint32(0x67452301), uint32(0xefcdab89), uint32(0x98badcfe), uint32(0x10325476)
+
+	msg := append(append([]byte{}, data...), 0x80)
+	for len(msg)%64 != 56 {
+		msg = append(msg, 0)
+	}
+	msg = binary.LittleEndian.AppendUint64(msg, uint64(len(data))*8)
+
+	f := func(x, y, z uint32) uint32 { return x&y | ^x&z }
+	g := func(x, y, z uint32) uint32 { return x&y | x&z | y&z }
+	h := func(x, y, z uint32) uint32 { return x ^ y ^ z }
+	for block := 0; block < len(msg); block += 64 {
+		var x [16]uint32
+		for i := range x {
+			x[i] = binary.LittleEndian.Uint32(msg[block+4*i:])
+		}
+		aa, bb, cc, dd := a, b, c, d
+		for _, k := range []int{0, 4, 8, 12} {
+			a = bits.RotateLeft32(a+f(b, c, d)+x[k], 3)
+			d = bits.RotateLeft32(d+f(a, b, c)+x[k+1], 7)
+			c = bits.RotateLeft32(c+f(d, a, b)+x[k+2], 11)
+			b = bits.RotateLeft32(b+f(c, d, a)+x[k+3], 19)
+		}
+		for _, k := range []int{0, 1, 2, 3} {
+			a = bits.RotateLeft32(a+g(b, c, d)+x[k]+0x5a827999, 3)
+			d = bits.RotateLeft32(d+g(a, b, c)+x[k+4]+0x5a827999, 5)
+			c = bits.RotateLeft32(c+g(d, a, b)+x[k+8]+0x5a827999, 9)
+			b = bits.RotateLeft32(b+g(c, d, a)+x[k+12]+0x5a827999, 13)
+		}
+		for _, k := range []int{0, 2, 1, 3} {
+			a = bits.RotateLeft32(a+h(b, c, d)+x[k]+0x6ed9eba1, 3)
+			d = bits.RotateLeft32(d+h(a, b, c)+x[k+8]+0x6ed9eba1, 9)
+			c = bits.RotateLeft32(c+h(d, a, b)+x[k+4]+0x6ed9eba1, 11)
+			b = bits.RotateLeft32(b+h(c, d, a)+x[k+12]+0x6ed9eba1, 15)
+		}
+		a += aa
+		b += bb
+		c += cc
+		d += dd
+	}
+
+	var sum [16]byte
+	for i, v := range []uint32{a, b, c, d} {
+		binary.LittleEndian.PutUint32(sum[4*i:], v)
+	}
+	return sum
+}
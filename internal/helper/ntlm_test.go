@@ -0,0 +1,83 @@
+package helper
+
+import (
+	"bytes"
+	"encoding/hex"
+	"testing"
+)
+
+func TestMD4Sum(t *testing.T) {
+	t.Parallel()
+
+	// test vectors from RFC 1320
+	var tests = []struct {
+		input string
+		sum   string
+	}{
+		{"", "31d6cfe0d16ae931b73c59d7e0c089c0"},
+		{"abc", "a448017aaf21d8525fc10ae87aa6729d"},
+		{"12345678901234567890123456789012345678901234567890123456789012345678901234567890", "e33b4ddc9c38f2199c3e7b164fcc0536"},
+	}
+	for _, tt := range tests {
+		sum := md4Sum([]byte(tt.input))
+		if got := hex.EncodeToString(sum[:]); got != tt.sum {
+			t.Errorf("expected %s for %q but got %s", tt.sum, tt.input, got)
+		}
+	}
+}
+
+func TestNTLMV2Response(t *testing.T) {
+	t.Parallel()
+
+	// test vectors from MS-NLMP 4.2.4
+	unhex := func(s string) []byte {
+		b, err := hex.DecodeString(s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return b
+	}
+	targetInfo := unhex("02000c0044006f006d00610069006e0001000c0053006500720076006500720000000000")
+	response, sessionKey := ntlmV2Response("Domain", "User", "Password", unhex("0123456789abcdef"), unhex("aaaaaaaaaaaaaaaa"), make([]byte, 8), targetInfo)
+	if proof := unhex("68cd0ab851e51c96aabc927bebef6a1c"); !bytes.Equal(response[:16], proof) {
+		t.Errorf("expected the proof %x but got %x", proof, response[:16])
+	}
+	if key := unhex("8de40ccadbc14a82f15cb0ad0de95ca3"); !bytes.Equal(sessionKey, key) {
+		t.Errorf("expected the session key %x but got %x", key, sessionKey)
+	}
+}
+
+func TestParseNTLMChallenge(t *testing.T) {
+	t.Parallel()
+
+	msg := make([]byte, 48)
+	copy(msg, ntlmSignature)
+	msg[8] = 2
+	msg[20] = 0x01
+	copy(msg[24:], "12345678")
+	// the target info with a timestamp follows the header
+	info := []byte{0x07, 0x00, 0x08, 0x00, 1, 2, 3, 4, 5, 6, 7, 8, 0, 0, 0, 0}
+	msg[40] = byte(len(info))
+	msg[44] = 48
+	msg = append(msg, info...)
+
+	// wrapped in a NegTokenResp
+	buf := derContext(1, derSequence(derContext(2, derTLV(0x04, msg))))
+	c, err := ParseNTLMChallenge(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(c.ServerChallenge) != "12345678" {
+		t.Errorf("unexpected server challenge %q", c.ServerChallenge)
+	}
+	if !bytes.Equal(c.TargetInfo, info) {
+		t.Errorf("unexpected target info %x", c.TargetInfo)
+	}
+	if ts, ok := c.timestamp(); !ok || !bytes.Equal(ts, info[4:12]) {
+		t.Errorf("unexpected timestamp %x", ts)
+	}
+
+	if _, err := ParseNTLMChallenge([]byte("garbage")); err == nil {
+		t.Error("expected an error without a challenge")
+	}
+}
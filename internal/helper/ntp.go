@@ -0,0 +1,94 @@
+package helper
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"math/rand"
+	"net/netip"
+	"strings"
+	"time"
+)
+
+// ntpEpochOffset is the number of seconds between the NTP epoch 1900 and
+// the unix epoch 1970
+const ntpEpochOffset = 2208988800
+
+// NTP modes used by stunner
+const (
+	NTPModeClient uint8 = 3
+	NTPModeServer uint8 = 4
+)
+
+// NTPResponse holds the parsed parts of a NTP server response
+type NTPResponse struct {
+	Leap    uint8
+	Version uint8
+	Mode    uint8
+	Stratum uint8
+	// RefID is the kiss code or reference clock of stratum 0 and 1 servers
+	// like GPS and the IPv4 address of the upstream server otherwise
+	RefID string
+	// Time is the transmit timestamp of the server
+	Time time.Time
+}
+
+// NTPRequest builds a NTPv4 client request (RFC 5905). The transmit
+// timestamp is random so the response can be matched to the request
+func NTPRequest() []byte {
+	ntp := make([]byte, 48)
+	// LI: 0, VN: 4, Mode: 3 (client)
+	ntp[0] = 4<<3 | NTPModeClient
+	binary.BigEndian.PutUint64(ntp[40:48], rand.Uint64())
+	return ntp
+}
+
+// ParseNTPResponse parses the server response to request
+func ParseNTPResponse(buf []byte, request []byte) (*NTPResponse, error) {
+	if len(buf) < 48 {
+		return nil, fmt.Errorf("invalid ntp message length %d", len(buf))
+	}
+	resp := &NTPResponse{
+		Leap:    buf[0] >> 6,
+		Version: (buf[0] >> 3) & 0x07,
+		Mode:    buf[0] & 0x07,
+		Stratum: buf[1],
+	}
+	if resp.Mode != NTPModeServer {
+		return nil, fmt.Errorf("message is not a server response but mode %d", resp.Mode)
+	}
+	// the server copies the transmit timestamp of the request to the origin
+	// timestamp
+	if len(request) >= 48 && !bytes.Equal(buf[24:32], request[40:48]) {
+		return nil, fmt.Errorf("response does not belong to the request")
+	}
+
+	refID := buf[12:16]
+	if resp.Stratum <= 1 {
+		resp.RefID = strings.TrimRight(string(refID), "\x00")
+	} else {
+		ip, _ := netip.AddrFromSlice(refID)
+		resp.RefID = ip.String()
+	}
+
+	seconds := binary.BigEndian.Uint32(buf[40:44])
+	fraction := binary.BigEndian.Uint32(buf[44:48])
+	if seconds != 0 {
+		nanos := (int64(fraction) * int64(time.Second)) >> 32
+		resp.Time = time.Unix(int64(seconds)-ntpEpochOffset, nanos).UTC()
+	}
+	return resp, nil
+}
+
+// String returns a short description of the server
+func (r NTPResponse) String() string {
+	s := fmt.Sprintf("NTPv%d stratum %d refid %s", r.Version, r.Stratum, r.RefID)
+	if r.Stratum == 0 {
+		// stratum 0 responses are kiss-o'-death packets
+		s = fmt.Sprintf("NTPv%d kiss code %s", r.Version, r.RefID)
+	}
+	if !r.Time.IsZero() {
+		s += fmt.Sprintf(" time %s", r.Time.Format(time.RFC3339))
+	}
+	return s
+}
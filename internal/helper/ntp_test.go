@@ -0,0 +1,64 @@
+package helper
+
+import (
+	"encoding/binary"
+	"testing"
+	"time"
+)
+
+func ntpResponse(request []byte, stratum uint8, refID []byte) []byte {
+	resp := make([]byte, 48)
+	// LI: 0, VN: 4, Mode: 4 (server)
+	resp[0] = 4<<3 | NTPModeServer
+	resp[1] = stratum
+	copy(resp[12:16], refID)
+	copy(resp[24:32], request[40:48])
+	binary.BigEndian.PutUint32(resp[40:44], 1700000000+ntpEpochOffset)
+	binary.BigEndian.PutUint32(resp[44:48], 1<<31)
+	return resp
+}
+
+func TestNTPRequest(t *testing.T) {
+	t.Parallel()
+	request := NTPRequest()
+	if len(request) != 48 {
+		t.Fatalf("invalid request length %d", len(request))
+	}
+	if request[0] != 0x23 {
+		t.Errorf("expected version 4 and client mode, got %02x", request[0])
+	}
+}
+
+func TestParseNTPResponse(t *testing.T) {
+	t.Parallel()
+	request := NTPRequest()
+
+	resp, err := ParseNTPResponse(ntpResponse(request, 2, []byte{10, 0, 0, 1}), request)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.Version != 4 || resp.Stratum != 2 || resp.RefID != "10.0.0.1" {
+		t.Errorf("unexpected response %+v", resp)
+	}
+	if expected := time.Unix(1700000000, 500000000).UTC(); !resp.Time.Equal(expected) {
+		t.Errorf("expected time %s, got %s", expected, resp.Time)
+	}
+
+	resp, err = ParseNTPResponse(ntpResponse(request, 1, []byte("GPS\x00")), request)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.RefID != "GPS" {
+		t.Errorf("expected refid GPS, got %q", resp.RefID)
+	}
+
+	if _, err := ParseNTPResponse(ntpResponse(request, 2, nil), NTPRequest()); err == nil {
+		t.Error("expected an error for a response to another request")
+	}
+	if _, err := ParseNTPResponse(request, request); err == nil {
+		t.Error("expected an error for a client request")
+	}
+	if _, err := ParseNTPResponse(request[:47], request); err == nil {
+		t.Error("expected an error for a short message")
+	}
+}
@@ -0,0 +1,62 @@
+package helper
+
+import (
+	"io"
+	"time"
+)
+
+// Sizes of a typical WebRTC video stream: packets of up to 1200 bytes
+// every 20 milliseconds
+const (
+	MediaPacketSize     = 1200
+	MediaPacketInterval = 20 * time.Millisecond
+)
+
+// PacedWriter splits writes into packets of at most size bytes and
+// sends one packet per interval
+type PacedWriter struct {
+	w        io.Writer
+	size     int
+	interval time.Duration
+	next     time.Time
+}
+
+// NewPacedWriter returns a PacedWriter writing to w
+func NewPacedWriter(w io.Writer, size int, interval time.Duration) *PacedWriter {
+	return &PacedWriter{
+		w:        w,
+		size:     size,
+		interval: interval,
+	}
+}
+
+// Write blocks until all packets of b are written
+func (p *PacedWriter) Write(b []byte) (int, error) {
+	written := 0
+	for len(b) > 0 {
+		if wait := time.Until(p.next); wait > 0 {
+			time.Sleep(wait)
+		}
+		n := len(b)
+		if n > p.size {
+			n = p.size
+		}
+		m, err := p.w.Write(b[:n])
+		written += m
+		if err != nil {
+			return written, err
+		}
+		p.next = time.Now().Add(p.interval)
+		b = b[n:]
+	}
+	return written, nil
+}
+
+// SetWriteDeadline sets the write deadline of the underlying writer if it
+// supports deadlines
+func (p *PacedWriter) SetWriteDeadline(t time.Time) error {
+	if wd, ok := p.w.(writeDeadliner); ok {
+		return wd.SetWriteDeadline(t)
+	}
+	return nil
+}
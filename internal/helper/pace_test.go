@@ -0,0 +1,43 @@
+package helper
+
+import (
+	"bytes"
+	"testing"
+	"time"
+)
+
+type recordWriter struct {
+	writes [][]byte
+	times  []time.Time
+}
+
+func (r *recordWriter) Write(b []byte) (int, error) {
+	r.writes = append(r.writes, append([]byte(nil), b...))
+	r.times = append(r.times, time.Now())
+	return len(b), nil
+}
+
+func TestPacedWriter(t *testing.T) {
+	t.Parallel()
+	rec := &recordWriter{}
+	p := NewPacedWriter(rec, 4, 10*time.Millisecond)
+	data := []byte("0123456789")
+	n, err := p.Write(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != len(data) {
+		t.Errorf("expected %d bytes written, got %d", len(data), n)
+	}
+	if len(rec.writes) != 3 {
+		t.Fatalf("expected 3 packets, got %d", len(rec.writes))
+	}
+	if !bytes.Equal(bytes.Join(rec.writes, nil), data) {
+		t.Errorf("unexpected data %q", rec.writes)
+	}
+	for i := 1; i < len(rec.times); i++ {
+		if d := rec.times[i].Sub(rec.times[i-1]); d < 10*time.Millisecond {
+			t.Errorf("packet %d was sent after %s", i, d)
+		}
+	}
+}
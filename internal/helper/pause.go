@@ -0,0 +1,123 @@
+package helper
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"sync"
+)
+
+// Pauser pauses and resumes long running scans. Workers call Wait
+// before every probe and block as long as the scan is paused, so no
+// state is lost. A nil Pauser never pauses.
+type Pauser struct {
+	mu sync.Mutex
+	// resume is closed on resume and nil while running
+	resume chan struct{}
+}
+
+// NewPauser returns a running Pauser
+func NewPauser() *Pauser {
+	return &Pauser{}
+}
+
+// Pause pauses all workers. It returns false if already paused
+func (p *Pauser) Pause() bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.resume != nil {
+		return false
+	}
+	p.resume = make(chan struct{})
+	return true
+}
+
+// Resume resumes all workers. It returns false if not paused
+func (p *Pauser) Resume() bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.resume == nil {
+		return false
+	}
+	close(p.resume)
+	p.resume = nil
+	return true
+}
+
+// Toggle pauses a running and resumes a paused Pauser and
+// returns true if it is paused afterwards
+func (p *Pauser) Toggle() bool {
+	if p.Pause() {
+		return true
+	}
+	p.Resume()
+	return false
+}
+
+// Paused returns true if the Pauser is paused
+func (p *Pauser) Paused() bool {
+	if p == nil {
+		return false
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.resume != nil
+}
+
+// Wait blocks while the Pauser is paused and returns true
+// if it had to wait
+func (p *Pauser) Wait() bool {
+	if p == nil {
+		return false
+	}
+	p.mu.Lock()
+	resume := p.resume
+	p.mu.Unlock()
+	if resume == nil {
+		return false
+	}
+	<-resume
+	return true
+}
+
+// PauseSignals returns the signals that toggle the Pauser. They
+// are empty on systems without SIGUSR1
+func PauseSignals() []os.Signal {
+	return pauseSignals
+}
+
+// StatsSignals returns the signals that print the statistics. They are
+// empty on systems without SIGUSR2
+func StatsSignals() []os.Signal {
+	return statsSignals
+}
+
+// ServeHTTP implements the control API. POST /pause and POST /resume
+// change the state, GET /status returns the current state
+func (p *Pauser) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch r.URL.Path {
+	case "/pause", "/resume":
+		if r.Method != http.MethodPost {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		if r.URL.Path == "/pause" {
+			p.Pause()
+		} else {
+			p.Resume()
+		}
+	case "/status":
+		if r.Method != http.MethodGet {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+	default:
+		http.NotFound(w, r)
+		return
+	}
+	state := "running"
+	if p.Paused() {
+		state = "paused"
+	}
+	fmt.Fprintln(w, state)
+}
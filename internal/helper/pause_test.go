@@ -0,0 +1,101 @@
+package helper
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestPauser(t *testing.T) {
+	t.Parallel()
+	p := NewPauser()
+	if p.Wait() {
+		t.Fatal("running pauser should not wait")
+	}
+	if !p.Pause() {
+		t.Fatal("expected pause to change the state")
+	}
+	if p.Pause() {
+		t.Fatal("pausing a paused pauser should not change the state")
+	}
+
+	done := make(chan bool)
+	go func() {
+		done <- p.Wait()
+	}()
+	select {
+	case <-done:
+		t.Fatal("wait returned while paused")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	if p.Toggle() {
+		t.Fatal("expected toggle to resume")
+	}
+	select {
+	case waited := <-done:
+		if !waited {
+			t.Error("expected wait to report the pause")
+		}
+	case <-time.After(time.Second):
+		t.Fatal("wait did not return after resume")
+	}
+	if p.Resume() {
+		t.Error("resuming a running pauser should not change the state")
+	}
+}
+
+func TestPauserNil(t *testing.T) {
+	t.Parallel()
+	var p *Pauser
+	if p.Wait() || p.Paused() {
+		t.Error("nil pauser should never pause")
+	}
+}
+
+func TestPauserHTTP(t *testing.T) {
+	t.Parallel()
+	p := NewPauser()
+
+	var tests = []struct {
+		method   string
+		path     string
+		code     int
+		expected string
+	}{
+		{http.MethodGet, "/status", http.StatusOK, "running"},
+		{http.MethodGet, "/pause", http.StatusMethodNotAllowed, ""},
+		{http.MethodPost, "/pause", http.StatusOK, "paused"},
+		{http.MethodGet, "/status", http.StatusOK, "paused"},
+		{http.MethodPost, "/resume", http.StatusOK, "running"},
+		{http.MethodGet, "/invalid", http.StatusNotFound, ""},
+	}
+	for _, tt := range tests {
+		rec := httptest.NewRecorder()
+		p.ServeHTTP(rec, httptest.NewRequest(tt.method, tt.path, nil))
+		if rec.Code != tt.code {
+			t.Errorf("%s %s: got status %d, want %d", tt.method, tt.path, rec.Code, tt.code)
+			continue
+		}
+		if tt.expected != "" && strings.TrimSpace(rec.Body.String()) != tt.expected {
+			t.Errorf("%s %s: got %q, want %q", tt.method, tt.path, rec.Body.String(), tt.expected)
+		}
+	}
+}
+
+func TestListenControl(t *testing.T) {
+	t.Parallel()
+	l, err := ListenControl("127.0.0.1:0", "")
+	if err != nil {
+		t.Fatalf("ListenControl() error = %v", err)
+	}
+	l.Close()
+
+	// security descriptors only apply to named pipes
+	if l, err := ListenControl("127.0.0.1:0", "D:P(A;;GA;;;SY)"); err == nil {
+		l.Close()
+		t.Error("expected an error for a security descriptor on a TCP address")
+	}
+}
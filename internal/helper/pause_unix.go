@@ -0,0 +1,12 @@
+//go:build !windows
+
+package helper
+
+import (
+	"os"
+	"syscall"
+)
+
+var pauseSignals = []os.Signal{syscall.SIGUSR1}
+
+var statsSignals = []os.Signal{syscall.SIGUSR2}
@@ -0,0 +1,11 @@
+//go:build windows
+
+package helper
+
+import "os"
+
+// windows has no SIGUSR1 so only the control API can be used
+var pauseSignals []os.Signal
+
+// and no SIGUSR2 to print the statistics
+var statsSignals []os.Signal
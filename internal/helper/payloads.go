@@ -0,0 +1,118 @@
+package helper
+
+import (
+	"bufio"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// DefaultUDPPayloadName is the name of payloads without a name
+const DefaultUDPPayloadName = "payload"
+
+// maxUDPPayloadPorts limits the ports of a single payload, as every port
+// is probed on every host
+const maxUDPPayloadPorts = 1024
+
+// UDPPayload is a raw payload sent to UDP ports of every host
+type UDPPayload struct {
+	Name  string
+	Ports []uint16
+	Data  []byte
+	// Split allows the payload to be sent in several datagrams if it is
+	// larger than the relay forwards
+	Split bool
+}
+
+// ReadUDPPayloads reads a payload file
+func ReadUDPPayloads(filename string) ([]UDPPayload, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return ParseUDPPayloads(f)
+}
+
+// ParseUDPPayloads parses payloads with one payload per line in a format
+// similar to nmap-payloads. The ports are followed by the hex or base64
+// encoded payload and an optional name used in the output. A trailing split
+// marks payloads the service reassembles from several datagrams. Empty
+// lines and lines starting with # are skipped
+//
+//	udp 47808 hex:810a001101040005010c0c023fffff194b bacnet
+//	udp 10001,10002 base64:AQAAAA== ubiquiti
+//	udp 9000-9002 hex:0d0a0d0a
+//	udp 7000 hex:0d0a0d0a stream split
+func ParseUDPPayloads(r io.Reader) ([]UDPPayload, error) {
+	var ret []UDPPayload
+	scanner := bufio.NewScanner(r)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		fields := strings.Fields(text)
+		split := len(fields) > 3 && fields[len(fields)-1] == "split"
+		if split {
+			fields = fields[:len(fields)-1]
+		}
+		if len(fields) < 3 || len(fields) > 4 {
+			return nil, fmt.Errorf("line %d: expected udp, ports, payload, an optional name and an optional split", line)
+		}
+		if !strings.EqualFold(fields[0], "udp") {
+			return nil, fmt.Errorf("line %d: unsupported protocol %q, only udp is supported", line, fields[0])
+		}
+
+		ports, err := ParsePorts(fields[1])
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", line, err)
+		}
+		if len(ports) > maxUDPPayloadPorts {
+			return nil, fmt.Errorf("line %d: more than %d ports", line, maxUDPPayloadPorts)
+		}
+
+		data, err := decodeUDPPayload(fields[2])
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", line, err)
+		}
+
+		name := DefaultUDPPayloadName
+		if len(fields) == 4 {
+			name = fields[3]
+		}
+		ret = append(ret, UDPPayload{Name: name, Ports: ports, Data: data, Split: split})
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return ret, nil
+}
+
+// decodeUDPPayload decodes a payload in the format hex:data or base64:data
+func decodeUDPPayload(value string) ([]byte, error) {
+	encoding, encoded, ok := strings.Cut(value, ":")
+	if !ok {
+		return nil, fmt.Errorf("payload %q needs to start with hex: or base64:", value)
+	}
+	var data []byte
+	var err error
+	switch strings.ToLower(encoding) {
+	case "hex":
+		data, err = hex.DecodeString(encoded)
+	case "base64":
+		data, err = base64.StdEncoding.DecodeString(encoded)
+	default:
+		return nil, fmt.Errorf("unsupported payload encoding %q. Supported values: hex and base64", encoding)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("invalid %s payload: %w", encoding, err)
+	}
+	if len(data) == 0 {
+		return nil, fmt.Errorf("payload is empty")
+	}
+	return data, nil
+}
@@ -0,0 +1,55 @@
+package helper
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseUDPPayloads(t *testing.T) {
+	t.Parallel()
+	input := `# comment
+udp 47808 hex:810a0011 bacnet
+
+UDP 10001,9000-9001 base64:AQAAAA==
+udp 7000 hex:0d0a stream split
+`
+	payloads, err := ParseUDPPayloads(strings.NewReader(input))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(payloads) != 3 {
+		t.Fatalf("expected 3 payloads, got %d", len(payloads))
+	}
+	if payloads[0].Name != "bacnet" || !reflect.DeepEqual(payloads[0].Ports, []uint16{47808}) || !bytes.Equal(payloads[0].Data, []byte{0x81, 0x0a, 0x00, 0x11}) {
+		t.Errorf("unexpected payload %+v", payloads[0])
+	}
+	if payloads[1].Name != DefaultUDPPayloadName || !reflect.DeepEqual(payloads[1].Ports, []uint16{10001, 9000, 9001}) || !bytes.Equal(payloads[1].Data, []byte{1, 0, 0, 0}) || payloads[1].Split {
+		t.Errorf("unexpected payload %+v", payloads[1])
+	}
+	if payloads[2].Name != "stream" || !payloads[2].Split {
+		t.Errorf("unexpected payload %+v", payloads[2])
+	}
+}
+
+func TestParseUDPPayloadsFail(t *testing.T) {
+	t.Parallel()
+	for _, input := range []string{
+		"udp 123",
+		"tcp 80 hex:00",
+		"udp 0 hex:00",
+		"udp abc hex:00",
+		"udp 1-2000 hex:00",
+		"udp 123 00",
+		"udp 123 hex:0",
+		"udp 123 base64:!!",
+		"udp 123 hex:",
+		"udp 123 rot13:abc",
+		"udp 123 hex:00 name extra",
+	} {
+		if _, err := ParseUDPPayloads(strings.NewReader(input)); err == nil {
+			t.Errorf("expected an error on %q", input)
+		}
+	}
+}
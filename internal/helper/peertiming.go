@@ -0,0 +1,97 @@
+package helper
+
+import (
+	"sort"
+	"time"
+)
+
+// PeerVerdict is the inferred handling of a peer by the relay
+type PeerVerdict string
+
+const (
+	// PeerAllowed means the relay connected to the peer
+	PeerAllowed PeerVerdict = "allowed"
+	// PeerFiltered means the relay rejected the peer without trying to
+	// connect, so the peer is on the deny list
+	PeerFiltered PeerVerdict = "filtered"
+	// PeerDropped means the relay tried to connect but the peer did not
+	// answer or refused the connection
+	PeerDropped PeerVerdict = "dropped"
+)
+
+const (
+	// a deny list check adds no network round trip, so a rejection within
+	// a few control round trips did not wait for the peer
+	filteredFactor = 3
+	filteredMargin = 20 * time.Millisecond
+)
+
+// PeerTiming is the outcome of connecting to a peer through the relay.
+// Servers often answer denied peers and dead hosts with the same error, but
+// only the latter involves a connection attempt on the relay which shows in
+// the response time
+type PeerTiming struct {
+	// Baseline is the round trip time of a request the relay answers
+	// without contacting a peer
+	Baseline time.Duration
+	// Samples are the response times of the connect requests
+	Samples []time.Duration
+	// Connected is set if the relay connected to the peer
+	Connected bool
+	// Code is the error code of the last response
+	Code int
+	// Timeout is set if the relay did not answer in time
+	Timeout bool
+}
+
+// Median returns the median of the samples
+func (p PeerTiming) Median() time.Duration {
+	if len(p.Samples) == 0 {
+		return 0
+	}
+	samples := append([]time.Duration(nil), p.Samples...)
+	sort.Slice(samples, func(i, j int) bool { return samples[i] < samples[j] })
+	return samples[len(samples)/2]
+}
+
+// Threshold returns the response time up to which an error is considered
+// a rejection by the deny list
+func (p PeerTiming) Threshold() time.Duration {
+	return p.Baseline*filteredFactor + filteredMargin
+}
+
+// Verdict infers how the relay handled the peer
+func (p PeerTiming) Verdict() PeerVerdict {
+	switch {
+	case p.Timeout:
+		// the relay is still trying to connect
+		return PeerDropped
+	case p.Connected:
+		return PeerAllowed
+	case p.Median() <= p.Threshold():
+		return PeerFiltered
+	default:
+		return PeerDropped
+	}
+}
+
+// RangeVerdict summarizes the verdicts of the probes of a network range.
+// A range is blocked if all probes were filtered
+func RangeVerdict(verdicts []PeerVerdict) string {
+	filtered := 0
+	for _, v := range verdicts {
+		if v == PeerFiltered {
+			filtered++
+		}
+	}
+	switch {
+	case len(verdicts) == 0:
+		return "unknown"
+	case filtered == len(verdicts):
+		return "blocked"
+	case filtered > 0:
+		return "partially blocked"
+	default:
+		return "not blocked"
+	}
+}
@@ -0,0 +1,49 @@
+package helper
+
+import (
+	"testing"
+	"time"
+)
+
+func TestPeerTimingVerdict(t *testing.T) {
+	t.Parallel()
+	ms := time.Millisecond
+	tests := []struct {
+		name   string
+		timing PeerTiming
+		want   PeerVerdict
+	}{
+		{name: "success", timing: PeerTiming{Baseline: 10 * ms, Samples: []time.Duration{900 * ms}, Connected: true}, want: PeerAllowed},
+		{name: "fast 403", timing: PeerTiming{Baseline: 10 * ms, Samples: []time.Duration{11 * ms, 12 * ms, 400 * ms}, Code: 403}, want: PeerFiltered},
+		{name: "slow 403", timing: PeerTiming{Baseline: 10 * ms, Samples: []time.Duration{11 * ms, 900 * ms, 950 * ms}, Code: 403}, want: PeerDropped},
+		{name: "slow 447", timing: PeerTiming{Baseline: 10 * ms, Samples: []time.Duration{3 * time.Second}, Code: 447}, want: PeerDropped},
+		{name: "timeout", timing: PeerTiming{Baseline: 10 * ms, Timeout: true}, want: PeerDropped},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			if got := tt.timing.Verdict(); got != tt.want {
+				t.Errorf("Verdict() = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRangeVerdict(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		verdicts []PeerVerdict
+		want     string
+	}{
+		{verdicts: nil, want: "unknown"},
+		{verdicts: []PeerVerdict{PeerFiltered, PeerFiltered}, want: "blocked"},
+		{verdicts: []PeerVerdict{PeerFiltered, PeerDropped}, want: "partially blocked"},
+		{verdicts: []PeerVerdict{PeerAllowed, PeerDropped}, want: "not blocked"},
+	}
+	for _, tt := range tests {
+		if got := RangeVerdict(tt.verdicts); got != tt.want {
+			t.Errorf("RangeVerdict(%v) = %s, want %s", tt.verdicts, got, tt.want)
+		}
+	}
+}
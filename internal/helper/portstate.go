@@ -0,0 +1,60 @@
+package helper
+
+import "time"
+
+// PortState is the state of a TCP port inferred from a TURN Connect request
+type PortState string
+
+const (
+	// PortOpen means the relay connected to the port
+	PortOpen PortState = "open"
+	// PortClosed means the connection was refused or the host was
+	// unreachable, so the relay got an answer right away
+	PortClosed PortState = "closed"
+	// PortFiltered means the connection attempt of the relay timed out
+	PortFiltered PortState = "filtered"
+	// PortForbidden means the relay does not allow connections to the peer
+	PortForbidden PortState = "forbidden"
+	// PortUnknown means the relay answered with an unexpected error
+	PortUnknown PortState = "unknown"
+)
+
+// a relay retransmits the SYN after one second, so a connection that failed
+// earlier was refused instead of timing out
+const closedMargin = 1 * time.Second
+
+// ConnectProbe is the outcome of a Connect request without a data connection
+type ConnectProbe struct {
+	// Baseline is the round trip time of a request the relay answers
+	// without contacting a peer
+	Baseline time.Duration
+	// Elapsed is the response time of the Connect request
+	Elapsed time.Duration
+	// Connected is set if the relay connected to the peer
+	Connected bool
+	// Code is the error code of the response
+	Code int
+	// Timeout is set if the relay did not answer in time
+	Timeout bool
+}
+
+// State infers the state of the port. Relays answer refused connections and
+// timeouts with the same error 447, so they are told apart by the response
+// time
+func (p ConnectProbe) State() PortState {
+	switch {
+	case p.Connected:
+		return PortOpen
+	case p.Timeout:
+		// the relay is still trying to connect
+		return PortFiltered
+	case p.Code == 403:
+		return PortForbidden
+	case p.Code != 447:
+		return PortUnknown
+	case p.Elapsed <= p.Baseline+closedMargin:
+		return PortClosed
+	default:
+		return PortFiltered
+	}
+}
@@ -0,0 +1,32 @@
+package helper
+
+import (
+	"testing"
+	"time"
+)
+
+func TestConnectProbeState(t *testing.T) {
+	t.Parallel()
+	baseline := 10 * time.Millisecond
+	tests := []struct {
+		name  string
+		probe ConnectProbe
+		want  PortState
+	}{
+		{"connected", ConnectProbe{Baseline: baseline, Elapsed: 15 * time.Millisecond, Connected: true}, PortOpen},
+		{"refused", ConnectProbe{Baseline: baseline, Elapsed: 20 * time.Millisecond, Code: 447}, PortClosed},
+		{"connect timeout", ConnectProbe{Baseline: baseline, Elapsed: 3 * time.Second, Code: 447}, PortFiltered},
+		{"no response", ConnectProbe{Baseline: baseline, Elapsed: 5 * time.Second, Timeout: true}, PortFiltered},
+		{"denied peer", ConnectProbe{Baseline: baseline, Elapsed: 12 * time.Millisecond, Code: 403}, PortForbidden},
+		{"other error", ConnectProbe{Baseline: baseline, Elapsed: 12 * time.Millisecond, Code: 486}, PortUnknown},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			if got := tt.probe.State(); got != tt.want {
+				t.Errorf("State() = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
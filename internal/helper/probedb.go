@@ -0,0 +1,168 @@
+package helper
+
+import (
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+)
+
+// ErrInvalidSignature is returned if a probe database is not signed with
+// the trusted key
+var ErrInvalidSignature = errors.New("invalid signature")
+
+// ProbeDefinition identifies a service from its banner
+type ProbeDefinition struct {
+	// Service is the name of the service like redis
+	Service string `json:"service"`
+	// Pattern is a regular expression matched against the whole banner
+	Pattern string `json:"pattern"`
+	// Product is expanded with the submatches of Pattern like $1. The
+	// first line of the banner is used if it is empty
+	Product string `json:"product,omitempty"`
+
+	re *regexp.Regexp
+}
+
+// ProbeDB is a versioned set of probe definitions that extends the
+// fingerprints built into stunner. It is distributed as JSON signed with
+// ed25519, so new probes do not need a new release
+type ProbeDB struct {
+	Version int               `json:"version"`
+	Probes  []ProbeDefinition `json:"probes"`
+}
+
+// DefaultProbeDBFile returns the path of the probe database in the user
+// config directory, for example ~/.config/stunner/probes.json
+func DefaultProbeDBFile() (string, error) {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "stunner", "probes.json"), nil
+}
+
+// ReadProbeDB reads a probe database from a file
+func ReadProbeDB(filename string) (*ProbeDB, error) {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	return ParseProbeDB(data)
+}
+
+// ParseProbeDB parses a probe database in the format
+//
+//	{
+//	  "version": 2,
+//	  "probes": [
+//	    {"service": "redis", "pattern": "^-ERR.*redis", "product": "redis"},
+//	    {"service": "rtsp", "pattern": "^RTSP/1\\.0 .*\\r\\nServer: ([^\\r\\n]+)", "product": "$1"}
+//	  ]
+//	}
+func ParseProbeDB(data []byte) (*ProbeDB, error) {
+	var db ProbeDB
+	if err := json.Unmarshal(data, &db); err != nil {
+		return nil, fmt.Errorf("invalid probe database: %w", err)
+	}
+	if db.Version <= 0 {
+		return nil, fmt.Errorf("invalid probe database: version needs to be greater than 0")
+	}
+	for i := range db.Probes {
+		p := &db.Probes[i]
+		if p.Service == "" {
+			return nil, fmt.Errorf("probe %d: service must not be empty", i)
+		}
+		re, err := regexp.Compile(p.Pattern)
+		if err != nil {
+			return nil, fmt.Errorf("probe %d (%s): invalid pattern: %w", i, p.Service, err)
+		}
+		p.re = re
+	}
+	return &db, nil
+}
+
+// Match returns the service and product of the first probe matching the
+// banner
+func (db *ProbeDB) Match(banner []byte) (string, string, bool) {
+	for _, p := range db.Probes {
+		submatches := p.re.FindSubmatchIndex(banner)
+		if submatches == nil {
+			continue
+		}
+		if p.Product == "" {
+			return p.Service, bannerFirstLine(banner), true
+		}
+		product := p.re.Expand(nil, []byte(p.Product), banner, submatches)
+		return p.Service, strings.TrimSpace(string(product)), true
+	}
+	return "", "", false
+}
+
+// bannerFirstLine returns the first line of a banner without surrounding spaces
+func bannerFirstLine(banner []byte) string {
+	line := string(banner)
+	if i := strings.IndexAny(line, "\r\n"); i >= 0 {
+		line = line[:i]
+	}
+	return strings.TrimSpace(line)
+}
+
+// ParseProbeDBKey parses a base64 encoded ed25519 public key
+func ParseProbeDBKey(s string) (ed25519.PublicKey, error) {
+	key, err := base64.StdEncoding.DecodeString(strings.TrimSpace(s))
+	if err != nil {
+		return nil, fmt.Errorf("invalid public key: %w", err)
+	}
+	if len(key) != ed25519.PublicKeySize {
+		return nil, fmt.Errorf("invalid public key: expected %d bytes, got %d", ed25519.PublicKeySize, len(key))
+	}
+	return ed25519.PublicKey(key), nil
+}
+
+// VerifyProbeDB checks the base64 encoded ed25519 signature of a probe
+// database
+func VerifyProbeDB(data, signature []byte, key ed25519.PublicKey) error {
+	sig, err := base64.StdEncoding.DecodeString(strings.TrimSpace(string(signature)))
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidSignature, err)
+	}
+	if !ed25519.Verify(key, data, sig) {
+		return ErrInvalidSignature
+	}
+	return nil
+}
+
+// ProbeDBHash returns the hex encoded SHA-256 of a probe database, used to
+// pin a database to an exact content
+func ProbeDBHash(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+var (
+	probeDBMu sync.RWMutex
+	probeDB   *ProbeDB
+)
+
+// SetProbeDB adds the probes of db to the fingerprinting of banners. A nil
+// database only uses the built in fingerprints
+func SetProbeDB(db *ProbeDB) {
+	probeDBMu.Lock()
+	defer probeDBMu.Unlock()
+	probeDB = db
+}
+
+func currentProbeDB() *ProbeDB {
+	probeDBMu.RLock()
+	defer probeDBMu.RUnlock()
+	return probeDB
+}
@@ -0,0 +1,91 @@
+package helper
+
+import (
+	"crypto/ed25519"
+	"encoding/base64"
+	"errors"
+	"testing"
+)
+
+const testProbeDB = `{
+  "version": 2,
+  "probes": [
+    {"service": "redis", "pattern": "^-ERR .*redis"},
+    {"service": "rtsp", "pattern": "^RTSP/1\\.0 [^\\r\\n]*\\r\\nServer: ([^\\r\\n]+)", "product": "$1"}
+  ]
+}`
+
+func TestParseProbeDB(t *testing.T) {
+	t.Parallel()
+	db, err := ParseProbeDB([]byte(testProbeDB))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		banner  string
+		service string
+		product string
+	}{
+		{"-ERR unknown command 'GET', this is redis\r\n", "redis", "-ERR unknown command 'GET', this is redis"},
+		{"RTSP/1.0 200 OK\r\nServer: GStreamer RTSP server\r\n\r\n", "rtsp", "GStreamer RTSP server"},
+		{"SSH-2.0-OpenSSH_9.6\r\n", "", ""},
+	}
+	for _, tt := range tests {
+		service, product, _ := db.Match([]byte(tt.banner))
+		if service != tt.service || product != tt.product {
+			t.Errorf("%q: expected %q %q, got %q %q", tt.banner, tt.service, tt.product, service, product)
+		}
+	}
+
+	for _, invalid := range []string{
+		`{"version": 0, "probes": []}`,
+		`{"version": 1, "probes": [{"service": "", "pattern": "x"}]}`,
+		`{"version": 1, "probes": [{"service": "x", "pattern": "("}]}`,
+	} {
+		if _, err := ParseProbeDB([]byte(invalid)); err == nil {
+			t.Errorf("expected an error for %s", invalid)
+		}
+	}
+}
+
+func TestVerifyProbeDB(t *testing.T) {
+	t.Parallel()
+	public, private, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, err := ParseProbeDBKey(base64.StdEncoding.EncodeToString(public))
+	if err != nil {
+		t.Fatal(err)
+	}
+	data := []byte(testProbeDB)
+	signature := []byte(base64.StdEncoding.EncodeToString(ed25519.Sign(private, data)) + "\n")
+	if err := VerifyProbeDB(data, signature, key); err != nil {
+		t.Error(err)
+	}
+	tampered := append([]byte{}, data...)
+	tampered[len(tampered)-2] = ' '
+	if err := VerifyProbeDB(tampered, signature, key); !errors.Is(err, ErrInvalidSignature) {
+		t.Errorf("expected an invalid signature, got %v", err)
+	}
+	if _, err := ParseProbeDBKey("dGVzdA=="); err == nil {
+		t.Error("expected an error for a short key")
+	}
+}
+
+func TestFingerprintBannerProbeDB(t *testing.T) {
+	// no t.Parallel as the probe database is global
+	db, err := ParseProbeDB([]byte(testProbeDB))
+	if err != nil {
+		t.Fatal(err)
+	}
+	SetProbeDB(db)
+	defer SetProbeDB(nil)
+	if service, _ := FingerprintBanner([]byte("-ERR wrong redis\r\n")); service != "redis" {
+		t.Errorf("expected redis, got %q", service)
+	}
+	// the built in fingerprints still work
+	if service, _ := FingerprintBanner([]byte("SSH-2.0-OpenSSH_9.6\r\n")); service != "ssh" {
+		t.Errorf("expected ssh, got %q", service)
+	}
+}
@@ -0,0 +1,102 @@
+package helper
+
+import (
+	"fmt"
+	"io"
+	"sync/atomic"
+	"time"
+)
+
+// Progress counts the bytes of a transfer. It is safe to read the
+// progress from another goroutine while the transfer is running.
+type Progress struct {
+	// offset is the number of bytes already transferred on a resume
+	offset int64
+	// total is the expected size of the transfer, -1 if unknown
+	total int64
+	start time.Time
+	done  atomic.Int64
+}
+
+// NewProgress returns a Progress for a transfer of total bytes that
+// is resumed at offset. A negative total means the size is unknown
+func NewProgress(offset, total int64) *Progress {
+	return &Progress{
+		offset: offset,
+		total:  total,
+		start:  time.Now(),
+	}
+}
+
+// Add adds n transferred bytes
+func (p *Progress) Add(n int) {
+	p.done.Add(int64(n))
+}
+
+// Transferred returns the number of bytes including the resume offset
+func (p *Progress) Transferred() int64 {
+	return p.offset + p.done.Load()
+}
+
+// Reader returns a reader that counts all bytes read from r
+func (p *Progress) Reader(r io.Reader) io.Reader {
+	return &progressReader{r: r, p: p}
+}
+
+// Writer returns a writer that counts all bytes written to w
+func (p *Progress) Writer(w io.Writer) io.Writer {
+	return &progressWriter{w: w, p: p}
+}
+
+// String returns the transferred bytes, the percentage if the total
+// size is known and the transfer rate
+func (p *Progress) String() string {
+	done := p.done.Load()
+	ret := FormatBytes(p.offset + done)
+	if p.total >= 0 {
+		ret += fmt.Sprintf(" / %s", FormatBytes(p.total))
+		if p.total > 0 {
+			ret += fmt.Sprintf(" (%.1f%%)", float64(p.offset+done)*100/float64(p.total))
+		}
+	}
+	if elapsed := time.Since(p.start).Seconds(); elapsed > 0 {
+		ret += fmt.Sprintf(" %s/s", FormatBytes(int64(float64(done)/elapsed)))
+	}
+	return ret
+}
+
+type progressReader struct {
+	r io.Reader
+	p *Progress
+}
+
+func (r *progressReader) Read(b []byte) (int, error) {
+	n, err := r.r.Read(b)
+	r.p.Add(n)
+	return n, err
+}
+
+type progressWriter struct {
+	w io.Writer
+	p *Progress
+}
+
+func (w *progressWriter) Write(b []byte) (int, error) {
+	n, err := w.w.Write(b)
+	w.p.Add(n)
+	return n, err
+}
+
+// FormatBytes formats a byte count with binary units
+func FormatBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := int64(unit), 0
+	for x := n / unit; x >= unit; x /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
@@ -0,0 +1,55 @@
+package helper
+
+import (
+	"bytes"
+	"io"
+	"strings"
+	"testing"
+)
+
+func TestFormatBytes(t *testing.T) {
+	t.Parallel()
+
+	var tests = []struct {
+		input    int64
+		expected string
+	}{
+		{0, "0 B"},
+		{1023, "1023 B"},
+		{1024, "1.0 KiB"},
+		{1536, "1.5 KiB"},
+		{10 * 1024 * 1024, "10.0 MiB"},
+		{3 * 1024 * 1024 * 1024, "3.0 GiB"},
+	}
+	for _, tt := range tests {
+		tt := tt // NOTE: https://github.com/golang/go/wiki/CommonMistakes#using-goroutines-on-loop-iterator-variables
+		t.Run(tt.expected, func(t *testing.T) {
+			t.Parallel()
+			if x := FormatBytes(tt.input); x != tt.expected {
+				t.Errorf("expected %s but got %s", tt.expected, x)
+			}
+		})
+	}
+}
+
+func TestProgress(t *testing.T) {
+	t.Parallel()
+	p := NewProgress(1024, 4096)
+	var out bytes.Buffer
+	if _, err := io.Copy(p.Writer(&out), p.Reader(strings.NewReader(strings.Repeat("a", 1024)))); err != nil {
+		t.Fatal(err)
+	}
+	// bytes are counted by the reader and the writer
+	if x := p.Transferred(); x != 3072 {
+		t.Errorf("expected 3072 transferred bytes, got %d", x)
+	}
+	if x := p.String(); !strings.HasPrefix(x, "3.0 KiB / 4.0 KiB (75.0%)") {
+		t.Errorf("unexpected progress %q", x)
+	}
+
+	p = NewProgress(0, -1)
+	p.Add(10)
+	if x := p.String(); strings.Contains(x, "/ ") || !strings.HasPrefix(x, "10 B") {
+		t.Errorf("unexpected progress %q", x)
+	}
+}
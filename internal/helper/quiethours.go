@@ -0,0 +1,143 @@
+package helper
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var weekdayNames = map[string]time.Weekday{
+	"sun": time.Sunday,
+	"mon": time.Monday,
+	"tue": time.Tuesday,
+	"wed": time.Wednesday,
+	"thu": time.Thursday,
+	"fri": time.Friday,
+	"sat": time.Saturday,
+}
+
+// QuietWindow is a daily time window. Windows ending before they start
+// span midnight and end on the following day
+type QuietWindow struct {
+	days [7]bool
+	// start and end in minutes since midnight
+	start int
+	end   int
+}
+
+// QuietHours are the time windows in which no activity is allowed.
+// Empty QuietHours are never active
+type QuietHours []QuietWindow
+
+// ParseQuietHours parses windows in the format "[days ]HH:MM-HH:MM" in
+// local time. Days are a comma separated list of weekdays or ranges like
+// "mon-fri". Without days the window applies to every day. Examples:
+// "18:00-08:00", "sat,sun 00:00-24:00"
+func ParseQuietHours(specs []string) (QuietHours, error) {
+	var q QuietHours
+	for _, spec := range specs {
+		w, err := parseQuietWindow(spec)
+		if err != nil {
+			return nil, fmt.Errorf("invalid quiet hours %q: %w", spec, err)
+		}
+		q = append(q, w)
+	}
+	return q, nil
+}
+
+func parseQuietWindow(spec string) (QuietWindow, error) {
+	var w QuietWindow
+	fields := strings.Fields(spec)
+	var window string
+	switch len(fields) {
+	case 1:
+		for i := range w.days {
+			w.days[i] = true
+		}
+		window = fields[0]
+	case 2:
+		for _, part := range strings.Split(strings.ToLower(fields[0]), ",") {
+			from, to, isRange := strings.Cut(part, "-")
+			first, ok := weekdayNames[from]
+			if !ok {
+				return w, fmt.Errorf("unknown weekday %q", from)
+			}
+			last := first
+			if isRange {
+				if last, ok = weekdayNames[to]; !ok {
+					return w, fmt.Errorf("unknown weekday %q", to)
+				}
+			}
+			for d := first; ; d = (d + 1) % 7 {
+				w.days[d] = true
+				if d == last {
+					break
+				}
+			}
+		}
+		window = fields[1]
+	default:
+		return w, fmt.Errorf("expected [days ]HH:MM-HH:MM")
+	}
+
+	start, end, ok := strings.Cut(window, "-")
+	if !ok {
+		return w, fmt.Errorf("expected a time window in the format HH:MM-HH:MM")
+	}
+	var err error
+	if w.start, err = parseClock(start); err != nil {
+		return w, err
+	}
+	if w.end, err = parseClock(end); err != nil {
+		return w, err
+	}
+	if w.start == w.end {
+		return w, fmt.Errorf("start and end are equal")
+	}
+	return w, nil
+}
+
+// parseClock returns the minutes since midnight of HH:MM. 24:00 is
+// allowed to end a window at midnight
+func parseClock(s string) (int, error) {
+	h, m, ok := strings.Cut(s, ":")
+	if !ok {
+		return 0, fmt.Errorf("invalid time %q", s)
+	}
+	hour, err := strconv.Atoi(h)
+	if err != nil {
+		return 0, fmt.Errorf("invalid hour in %q", s)
+	}
+	minute, err := strconv.Atoi(m)
+	if err != nil {
+		return 0, fmt.Errorf("invalid minute in %q", s)
+	}
+	if hour < 0 || minute < 0 || minute > 59 || hour > 24 || (hour == 24 && minute != 0) {
+		return 0, fmt.Errorf("invalid time %q", s)
+	}
+	return hour*60 + minute, nil
+}
+
+// Active returns true if t is inside one of the windows
+func (q QuietHours) Active(t time.Time) bool {
+	minute := t.Hour()*60 + t.Minute()
+	day := t.Weekday()
+	yesterday := (day + 6) % 7
+	for _, w := range q {
+		if w.start < w.end {
+			if w.days[day] && minute >= w.start && minute < w.end {
+				return true
+			}
+			continue
+		}
+		// the window spans midnight
+		if w.days[day] && minute >= w.start {
+			return true
+		}
+		if w.days[yesterday] && minute < w.end {
+			return true
+		}
+	}
+	return false
+}
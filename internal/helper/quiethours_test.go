@@ -0,0 +1,70 @@
+package helper
+
+import (
+	"testing"
+	"time"
+)
+
+func TestQuietHours(t *testing.T) {
+	t.Parallel()
+	q, err := ParseQuietHours([]string{"18:00-08:00", "sat,sun 00:00-24:00", "fri 12:00-13:00"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// 2024-01-01 is a Monday
+	tests := []struct {
+		time   string
+		active bool
+	}{
+		{"2024-01-01 07:59", true},
+		{"2024-01-01 08:00", false},
+		{"2024-01-01 17:59", false},
+		{"2024-01-01 18:00", true},
+		{"2024-01-01 23:59", true},
+		{"2024-01-05 12:30", true},
+		{"2024-01-04 12:30", false},
+		{"2024-01-06 12:00", true},
+		{"2024-01-07 23:00", true},
+	}
+	for _, tt := range tests {
+		ts, err := time.Parse("2006-01-02 15:04", tt.time)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if x := q.Active(ts); x != tt.active {
+			t.Errorf("%s: expected %t but got %t", tt.time, tt.active, x)
+		}
+	}
+
+	var empty QuietHours
+	if empty.Active(time.Now()) {
+		t.Error("empty quiet hours should never be active")
+	}
+}
+
+func TestQuietHoursWeekdayRange(t *testing.T) {
+	t.Parallel()
+	// the window starts on friday and ends on saturday
+	q, err := ParseQuietHours([]string{"mon-fri 22:00-06:00"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	saturday := time.Date(2024, 1, 6, 5, 0, 0, 0, time.UTC)
+	if !q.Active(saturday) {
+		t.Error("expected the friday window to continue on saturday")
+	}
+	sunday := time.Date(2024, 1, 7, 23, 0, 0, 0, time.UTC)
+	if q.Active(sunday) {
+		t.Error("expected sunday to be excluded")
+	}
+}
+
+func TestParseQuietHoursFail(t *testing.T) {
+	t.Parallel()
+	for _, spec := range []string{"", "18:00", "25:00-08:00", "18:00-18:00", "xyz 18:00-08:00", "mon 18:60-19:00", "mon tue 18:00-19:00"} {
+		if _, err := ParseQuietHours([]string{spec}); err == nil {
+			t.Errorf("expected an error on %q", spec)
+		}
+	}
+}
@@ -0,0 +1,43 @@
+package helper
+
+import (
+	"sync"
+	"time"
+)
+
+// RateLimiter spreads events evenly over time so at most rate events per
+// second happen across all goroutines sharing it. A nil RateLimiter does
+// not limit anything
+type RateLimiter struct {
+	interval time.Duration
+
+	mu   sync.Mutex
+	next time.Time
+}
+
+// NewRateLimiter returns a RateLimiter for rate events per second. It
+// returns nil if rate is 0 or less
+func NewRateLimiter(rate float64) *RateLimiter {
+	if rate <= 0 {
+		return nil
+	}
+	return &RateLimiter{
+		interval: time.Duration(float64(time.Second) / rate),
+	}
+}
+
+// Wait blocks until the next event is allowed
+func (r *RateLimiter) Wait() {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	now := time.Now()
+	start := now
+	if r.next.After(start) {
+		start = r.next
+	}
+	r.next = start.Add(r.interval)
+	r.mu.Unlock()
+	time.Sleep(time.Until(start))
+}
@@ -0,0 +1,40 @@
+package helper
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestRateLimiter(t *testing.T) {
+	t.Parallel()
+	limiter := NewRateLimiter(100)
+	start := time.Now()
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 3; j++ {
+				limiter.Wait()
+			}
+		}()
+	}
+	wg.Wait()
+	// the first event is allowed immediately, the other 11 wait 10ms each
+	if elapsed := time.Since(start); elapsed < 110*time.Millisecond {
+		t.Errorf("12 events at 100/s took only %s", elapsed)
+	}
+}
+
+func TestRateLimiterDisabled(t *testing.T) {
+	t.Parallel()
+	for _, rate := range []float64{0, -1} {
+		limiter := NewRateLimiter(rate)
+		if limiter != nil {
+			t.Fatalf("expected no limiter for rate %v", rate)
+		}
+		// a nil limiter never blocks
+		limiter.Wait()
+	}
+}
@@ -0,0 +1,131 @@
+package helper
+
+import (
+	"fmt"
+	"sort"
+)
+
+// RelayPortPattern describes how a relay assigns the ports of allocations
+type RelayPortPattern struct {
+	// Kind is one of sequential, range, random or unknown
+	Kind string
+	// Step is the most common difference between two consecutive ports
+	Step int
+	Min  uint16
+	Max  uint16
+	// EstimatedMin and EstimatedMax are the bounds of the port range
+	// extrapolated from the observed ports
+	EstimatedMin uint16
+	EstimatedMax uint16
+	// AllEven is set if all ports are even as suggested by RFC 8656
+	AllEven bool
+	// Reused is the number of ports that were assigned more than once
+	Reused int
+}
+
+const (
+	// share of the port differences that need to match for a sequential
+	// assignment
+	sequentialShare = 0.75
+	// maximum difference between two ports of a sequential assignment,
+	// relays skipping odd ports or ports in use still count
+	sequentialMaxStep = 16
+	// ranges narrower than this are probably a port range per user or per
+	// worker instead of the full range of the relay
+	narrowRange = 2048
+)
+
+// AnalyzeRelayPorts infers the assignment pattern from the relayed ports
+// of consecutive allocations
+func AnalyzeRelayPorts(ports []uint16) RelayPortPattern {
+	p := RelayPortPattern{Kind: "unknown"}
+	if len(ports) == 0 {
+		return p
+	}
+
+	p.Min, p.Max = ports[0], ports[0]
+	p.AllEven = true
+	seen := make(map[uint16]bool)
+	for _, port := range ports {
+		if port < p.Min {
+			p.Min = port
+		}
+		if port > p.Max {
+			p.Max = port
+		}
+		if port%2 != 0 {
+			p.AllEven = false
+		}
+		if seen[port] {
+			p.Reused++
+		}
+		seen[port] = true
+	}
+
+	// extrapolate the range assuming uniformly distributed ports
+	n := len(ports)
+	p.EstimatedMin, p.EstimatedMax = p.Min, p.Max
+	if n > 1 {
+		gap := int(p.Max-p.Min) / (n - 1)
+		if int(p.Min)-gap > 0 {
+			p.EstimatedMin = p.Min - uint16(gap)
+		} else {
+			p.EstimatedMin = 0
+		}
+		if int(p.Max)+gap < 65535 {
+			p.EstimatedMax = p.Max + uint16(gap)
+		} else {
+			p.EstimatedMax = 65535
+		}
+	}
+	if n < 3 {
+		return p
+	}
+
+	diffs := make(map[int]int)
+	for i := 1; i < n; i++ {
+		diffs[int(ports[i])-int(ports[i-1])]++
+	}
+	var steps []int
+	for step := range diffs {
+		steps = append(steps, step)
+	}
+	sort.Slice(steps, func(i, j int) bool {
+		if diffs[steps[i]] != diffs[steps[j]] {
+			return diffs[steps[i]] > diffs[steps[j]]
+		}
+		return abs(steps[i]) < abs(steps[j])
+	})
+	p.Step = steps[0]
+
+	switch {
+	case p.Step != 0 && abs(p.Step) <= sequentialMaxStep && float64(diffs[p.Step]) >= sequentialShare*float64(n-1):
+		p.Kind = "sequential"
+	case int(p.EstimatedMax-p.EstimatedMin) < narrowRange:
+		p.Kind = "range"
+	default:
+		p.Kind = "random"
+	}
+	return p
+}
+
+// String returns a human readable description of the pattern
+func (p RelayPortPattern) String() string {
+	switch p.Kind {
+	case "sequential":
+		return fmt.Sprintf("sequential with a step of %d between %d and %d", p.Step, p.Min, p.Max)
+	case "range":
+		return fmt.Sprintf("random in a narrow range of about %d-%d, probably a port range per user or worker", p.EstimatedMin, p.EstimatedMax)
+	case "random":
+		return fmt.Sprintf("random in a range of about %d-%d", p.EstimatedMin, p.EstimatedMax)
+	default:
+		return "unknown, not enough allocations"
+	}
+}
+
+func abs(i int) int {
+	if i < 0 {
+		return -i
+	}
+	return i
+}
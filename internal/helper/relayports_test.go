@@ -0,0 +1,48 @@
+package helper
+
+import "testing"
+
+func TestAnalyzeRelayPorts(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		name     string
+		ports    []uint16
+		wantKind string
+		wantStep int
+		wantEven bool
+	}{
+		{name: "empty", ports: nil, wantKind: "unknown"},
+		{name: "too few", ports: []uint16{50000, 50002}, wantKind: "unknown", wantEven: true},
+		{name: "sequential even", ports: []uint16{49152, 49154, 49156, 49158, 49162, 49164}, wantKind: "sequential", wantStep: 2, wantEven: true},
+		{name: "sequential descending", ports: []uint16{60010, 60009, 60008, 60007, 60006}, wantKind: "sequential", wantStep: -1},
+		{name: "narrow range", ports: []uint16{40210, 40014, 40390, 40122, 40288, 40056}, wantKind: "range", wantEven: true},
+		{name: "random", ports: []uint16{51234, 63020, 49876, 58110, 55002, 64444}, wantKind: "random", wantEven: true},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			got := AnalyzeRelayPorts(tt.ports)
+			if got.Kind != tt.wantKind {
+				t.Errorf("Kind = %s, want %s (%s)", got.Kind, tt.wantKind, got)
+			}
+			if tt.wantKind == "sequential" && got.Step != tt.wantStep {
+				t.Errorf("Step = %d, want %d", got.Step, tt.wantStep)
+			}
+			if len(tt.ports) > 0 && got.AllEven != tt.wantEven {
+				t.Errorf("AllEven = %t, want %t", got.AllEven, tt.wantEven)
+			}
+		})
+	}
+}
+
+func TestAnalyzeRelayPortsReuse(t *testing.T) {
+	t.Parallel()
+	got := AnalyzeRelayPorts([]uint16{50000, 50000, 50000, 50002})
+	if got.Reused != 2 {
+		t.Errorf("Reused = %d, want 2", got.Reused)
+	}
+	if got.Min != 50000 || got.Max != 50002 {
+		t.Errorf("got range %d-%d, want 50000-50002", got.Min, got.Max)
+	}
+}
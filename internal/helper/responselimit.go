@@ -0,0 +1,26 @@
+package helper
+
+import "sync"
+
+// DefaultResponseLimit is the maximum number of bytes read from the
+// response of a probed service if no limit is set
+const DefaultResponseLimit = 64 * 1024
+
+var (
+	responseLimitMu sync.RWMutex
+	responseLimit   = DefaultResponseLimit
+)
+
+// SetResponseLimit sets the maximum number of bytes read from the response
+// of a probed service with ConnectionReadResponse. A limit of 0 disables it
+func SetResponseLimit(limit int) {
+	responseLimitMu.Lock()
+	defer responseLimitMu.Unlock()
+	responseLimit = limit
+}
+
+func currentResponseLimit() int {
+	responseLimitMu.RLock()
+	defer responseLimitMu.RUnlock()
+	return responseLimit
+}
@@ -0,0 +1,142 @@
+package helper
+
+import (
+	"encoding/binary"
+	"fmt"
+)
+
+// SunRPC constants (RFC 5531) and the portmapper program (RFC 1833)
+const (
+	rpcCall            = 0
+	rpcReply           = 1
+	rpcVersion         = 2
+	rpcMsgAccepted     = 0
+	rpcSuccess         = 0
+	rpcPortmapProgram  = 100000
+	rpcPortmapVersion  = 2
+	rpcPortmapProcDump = 4
+)
+
+const (
+	// rpcMappingWordCount is the number of 32 bit words of a mapping
+	// including the value follows flag
+	rpcMappingWordCount = 5
+	// rpcMaxMappings limits the mappings parsed from a reply
+	rpcMaxMappings = 1024
+)
+
+// rpcPrograms are the names of well known RPC programs
+var rpcPrograms = map[uint32]string{
+	100000: "portmapper",
+	100001: "rstatd",
+	100002: "rusersd",
+	100003: "nfs",
+	100004: "ypserv",
+	100005: "mountd",
+	100007: "ypbind",
+	100008: "walld",
+	100009: "yppasswdd",
+	100011: "rquotad",
+	100021: "nlockmgr",
+	100024: "status",
+	100026: "bootparam",
+	100083: "ttdbserverd",
+	100227: "nfs_acl",
+	150001: "pcnfsd",
+}
+
+// RPCMapping is a program registered at the portmapper
+type RPCMapping struct {
+	Program  uint32
+	Version  uint32
+	Protocol uint32
+	Port     uint32
+}
+
+// Name returns the name of a well known program or its number
+func (m RPCMapping) Name() string {
+	if name, ok := rpcPrograms[m.Program]; ok {
+		return name
+	}
+	return fmt.Sprintf("%d", m.Program)
+}
+
+// String returns the mapping like nfs v3 tcp/2049
+func (m RPCMapping) String() string {
+	protocol := fmt.Sprintf("proto%d", m.Protocol)
+	switch m.Protocol {
+	case 6:
+		protocol = "tcp"
+	case 17:
+		protocol = "udp"
+	}
+	return fmt.Sprintf("%s v%d %s/%d", m.Name(), m.Version, protocol, m.Port)
+}
+
+// RPCPortmapDump builds a portmapper v2 DUMP call with AUTH_NULL
+// credentials
+func RPCPortmapDump(xid uint32) []byte {
+	buf := make([]byte, 40)
+	binary.BigEndian.PutUint32(buf[0:4], xid)
+	binary.BigEndian.PutUint32(buf[4:8], rpcCall)
+	binary.BigEndian.PutUint32(buf[8:12], rpcVersion)
+	binary.BigEndian.PutUint32(buf[12:16], rpcPortmapProgram)
+	binary.BigEndian.PutUint32(buf[16:20], rpcPortmapVersion)
+	binary.BigEndian.PutUint32(buf[20:24], rpcPortmapProcDump)
+	// credentials and verifier are AUTH_NULL with an empty body
+	return buf
+}
+
+// ParseRPCPortmapDump parses the reply to the DUMP call with xid
+func ParseRPCPortmapDump(buf []byte, xid uint32) ([]RPCMapping, error) {
+	if len(buf) < 24 {
+		return nil, fmt.Errorf("invalid rpc reply length %d", len(buf))
+	}
+	if binary.BigEndian.Uint32(buf[0:4]) != xid {
+		return nil, fmt.Errorf("response does not belong to the request")
+	}
+	if msgType := binary.BigEndian.Uint32(buf[4:8]); msgType != rpcReply {
+		return nil, fmt.Errorf("message is not a rpc reply but type %d", msgType)
+	}
+	if status := binary.BigEndian.Uint32(buf[8:12]); status != rpcMsgAccepted {
+		return nil, fmt.Errorf("rpc call was denied")
+	}
+	// the verifier has a variable length padded to four bytes
+	verifierLength := int(binary.BigEndian.Uint32(buf[16:20]))
+	if verifierLength > 400 {
+		return nil, fmt.Errorf("invalid rpc verifier length %d", verifierLength)
+	}
+	offset := 20 + (verifierLength+3)/4*4
+	if len(buf) < offset+4 {
+		return nil, fmt.Errorf("truncated rpc reply")
+	}
+	if status := binary.BigEndian.Uint32(buf[offset : offset+4]); status != rpcSuccess {
+		return nil, fmt.Errorf("rpc call failed with status %d", status)
+	}
+	offset += 4
+
+	// the mappings are a linked list where every entry is preceded by a
+	// value follows flag
+	var mappings []RPCMapping
+	for {
+		if len(buf) < offset+4 {
+			return nil, fmt.Errorf("truncated rpc mapping list")
+		}
+		if binary.BigEndian.Uint32(buf[offset:offset+4]) == 0 {
+			return mappings, nil
+		}
+		if len(buf) < offset+4*rpcMappingWordCount {
+			return nil, fmt.Errorf("truncated rpc mapping")
+		}
+		if len(mappings) >= rpcMaxMappings {
+			return nil, fmt.Errorf("more than %d rpc mappings", rpcMaxMappings)
+		}
+		mappings = append(mappings, RPCMapping{
+			Program:  binary.BigEndian.Uint32(buf[offset+4 : offset+8]),
+			Version:  binary.BigEndian.Uint32(buf[offset+8 : offset+12]),
+			Protocol: binary.BigEndian.Uint32(buf[offset+12 : offset+16]),
+			Port:     binary.BigEndian.Uint32(buf[offset+16 : offset+20]),
+		})
+		offset += 4 * rpcMappingWordCount
+	}
+}
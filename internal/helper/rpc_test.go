@@ -0,0 +1,52 @@
+package helper
+
+import (
+	"encoding/binary"
+	"testing"
+)
+
+func TestRPCPortmapDump(t *testing.T) {
+	t.Parallel()
+	req := RPCPortmapDump(0xdeadbeef)
+	if len(req) != 40 || binary.BigEndian.Uint32(req[0:4]) != 0xdeadbeef || binary.BigEndian.Uint32(req[12:16]) != 100000 || binary.BigEndian.Uint32(req[20:24]) != 4 {
+		t.Errorf("invalid request %x", req)
+	}
+}
+
+func TestParseRPCPortmapDump(t *testing.T) {
+	t.Parallel()
+	words := []uint32{
+		// xid, reply, accepted, AUTH_NULL verifier, success
+		0xdeadbeef, 1, 0, 0, 0, 0,
+		1, 100000, 2, 6, 111,
+		1, 100003, 3, 6, 2049,
+		1, 100005, 3, 17, 20048,
+		1, 400000, 1, 17, 1000,
+		0,
+	}
+	buf := make([]byte, 4*len(words))
+	for i, w := range words {
+		binary.BigEndian.PutUint32(buf[4*i:], w)
+	}
+
+	mappings, err := ParseRPCPortmapDump(buf, 0xdeadbeef)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []string{"portmapper v2 tcp/111", "nfs v3 tcp/2049", "mountd v3 udp/20048", "400000 v1 udp/1000"}
+	if len(mappings) != len(expected) {
+		t.Fatalf("expected %d mappings, got %d", len(expected), len(mappings))
+	}
+	for i, m := range mappings {
+		if m.String() != expected[i] {
+			t.Errorf("expected %q, got %q", expected[i], m.String())
+		}
+	}
+
+	if _, err := ParseRPCPortmapDump(buf, 1); err == nil {
+		t.Errorf("expected an error for a different xid")
+	}
+	if _, err := ParseRPCPortmapDump(buf[:len(buf)-4], 0xdeadbeef); err == nil {
+		t.Errorf("expected an error for a truncated list")
+	}
+}
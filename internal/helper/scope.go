@@ -0,0 +1,263 @@
+package helper
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"net/netip"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// ErrOutOfScope is returned for targets that are not in the scope of the
+// engagement
+var ErrOutOfScope = errors.New("out of scope")
+
+// PortRange is a range of ports including both ends
+type PortRange struct {
+	From uint16
+	To   uint16
+}
+
+// Scope are the targets that may be reached through the relay during an
+// engagement
+type Scope struct {
+	// Allowed are the ranges that may be contacted
+	Allowed []netip.Prefix
+	// Forbidden are excluded from the allowed ranges
+	Forbidden []netip.Prefix
+	// Domains are the names that may be resolved and connected to,
+	// including their subdomains
+	Domains []string
+	// Ports are the allowed ports. All ports are allowed if empty
+	Ports []PortRange
+}
+
+// ReadScope reads a scope file
+func ReadScope(filename string) (*Scope, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return ParseScope(f)
+}
+
+// ParseScope parses a scope with one rule per line. Empty lines and lines
+// starting with # are skipped
+//
+//	allow 10.10.0.0/16       allow a range or a single address
+//	allow acme.internal      allow a domain and its subdomains
+//	deny 10.10.99.0/24       forbid a range even if it is allowed
+//	ports 22,80,443,8000-8100
+func ParseScope(r io.Reader) (*Scope, error) {
+	s := &Scope{}
+	scanner := bufio.NewScanner(r)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		keyword, value, _ := strings.Cut(text, " ")
+		value = strings.TrimSpace(value)
+		if value == "" {
+			return nil, fmt.Errorf("line %d: missing value for %q", line, keyword)
+		}
+		switch strings.ToLower(keyword) {
+		case "allow":
+			if prefix, err := parseScopePrefix(value); err == nil {
+				s.Allowed = append(s.Allowed, prefix)
+				continue
+			}
+			if strings.ContainsAny(value, "/:") {
+				return nil, fmt.Errorf("line %d: invalid range %q", line, value)
+			}
+			s.Domains = append(s.Domains, strings.TrimSuffix(strings.TrimPrefix(strings.ToLower(value), "*."), "."))
+		case "deny":
+			prefix, err := parseScopePrefix(value)
+			if err != nil {
+				return nil, fmt.Errorf("line %d: %w", line, err)
+			}
+			s.Forbidden = append(s.Forbidden, prefix)
+		case "ports":
+			ports, err := parsePortRanges(value)
+			if err != nil {
+				return nil, fmt.Errorf("line %d: %w", line, err)
+			}
+			s.Ports = append(s.Ports, ports...)
+		default:
+			return nil, fmt.Errorf("line %d: unknown rule %q. Supported rules: allow, deny and ports", line, keyword)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if len(s.Allowed) == 0 && len(s.Domains) == 0 {
+		return nil, fmt.Errorf("the scope allows nothing, add at least one allow rule")
+	}
+	return s, nil
+}
+
+// parseScopePrefix parses a range in CIDR notation or a single address
+func parseScopePrefix(value string) (netip.Prefix, error) {
+	if strings.Contains(value, "/") {
+		prefix, err := netip.ParsePrefix(value)
+		if err != nil {
+			return netip.Prefix{}, fmt.Errorf("invalid range %q: %w", value, err)
+		}
+		return prefix.Masked(), nil
+	}
+	ip, err := netip.ParseAddr(value)
+	if err != nil {
+		return netip.Prefix{}, fmt.Errorf("invalid address %q: %w", value, err)
+	}
+	return netip.PrefixFrom(ip, ip.BitLen()), nil
+}
+
+// parsePortRanges parses comma separated ports and ranges like 80,8000-8100
+func parsePortRanges(value string) ([]PortRange, error) {
+	var ret []PortRange
+	for _, part := range strings.Split(value, ",") {
+		part = strings.TrimSpace(part)
+		from, to, isRange := strings.Cut(part, "-")
+		start, err := strconv.ParseUint(from, 10, 16)
+		if err != nil {
+			return nil, fmt.Errorf("invalid port %q", part)
+		}
+		end := start
+		if isRange {
+			end, err = strconv.ParseUint(to, 10, 16)
+			if err != nil || end < start {
+				return nil, fmt.Errorf("invalid port range %q", part)
+			}
+		}
+		ret = append(ret, PortRange{From: uint16(start), To: uint16(end)})
+	}
+	return ret, nil
+}
+
+// ParsePorts parses a comma separated list of ports and port ranges like
+// 161,1161,9000-9002 and returns every port
+func ParsePorts(value string) ([]uint16, error) {
+	ranges, err := parsePortRanges(value)
+	if err != nil {
+		return nil, err
+	}
+	var ports []uint16
+	for _, r := range ranges {
+		if r.From == 0 {
+			return nil, fmt.Errorf("invalid port 0")
+		}
+		for port := int(r.From); port <= int(r.To); port++ {
+			ports = append(ports, uint16(port))
+		}
+	}
+	return ports, nil
+}
+
+// ContainsAddr returns true if the address is allowed and not forbidden
+func (s *Scope) ContainsAddr(ip netip.Addr) bool {
+	ip = ip.Unmap()
+	for _, prefix := range s.Forbidden {
+		if prefix.Contains(ip) {
+			return false
+		}
+	}
+	for _, prefix := range s.Allowed {
+		if prefix.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// ContainsPort returns true if the port is allowed
+func (s *Scope) ContainsPort(port uint16) bool {
+	if len(s.Ports) == 0 {
+		return true
+	}
+	for _, r := range s.Ports {
+		if port >= r.From && port <= r.To {
+			return true
+		}
+	}
+	return false
+}
+
+// ContainsName returns true if the name is one of the allowed domains or a
+// subdomain of them
+func (s *Scope) ContainsName(name string) bool {
+	name = strings.TrimSuffix(strings.ToLower(name), ".")
+	for _, domain := range s.Domains {
+		if name == domain || strings.HasSuffix(name, "."+domain) {
+			return true
+		}
+	}
+	return false
+}
+
+var (
+	scopeMu  sync.RWMutex
+	scope    *Scope
+	scopeLog ErrorLogger
+)
+
+// SetScope enforces the scope on all following requests and logs blocked
+// targets to log. A nil scope allows all targets
+func SetScope(log ErrorLogger, s *Scope) {
+	scopeMu.Lock()
+	defer scopeMu.Unlock()
+	scope = s
+	scopeLog = log
+}
+
+func currentScope() (*Scope, ErrorLogger) {
+	scopeMu.RLock()
+	defer scopeMu.RUnlock()
+	return scope, scopeLog
+}
+
+// InScope returns true if the address is in the current scope. Blocked
+// addresses are not logged, this is meant for filtering large ranges
+func InScope(ip netip.Addr) bool {
+	s, _ := currentScope()
+	return s == nil || s.ContainsAddr(ip)
+}
+
+// CheckScope returns an error wrapping ErrOutOfScope and logs the attempt
+// if the target is not in the current scope. A port of 0 only checks the
+// address
+func CheckScope(ip netip.Addr, port uint16) error {
+	s, log := currentScope()
+	if s == nil {
+		return nil
+	}
+	var err error
+	switch {
+	case !s.ContainsAddr(ip):
+		err = fmt.Errorf("%s is %w", ip, ErrOutOfScope)
+	case port != 0 && !s.ContainsPort(port):
+		err = fmt.Errorf("port %d on %s is %w", port, ip, ErrOutOfScope)
+	}
+	if err != nil && log != nil {
+		log.Warnf("[scope] blocked: %v", err)
+	}
+	return err
+}
+
+// CheckScopeName returns an error wrapping ErrOutOfScope and logs the
+// attempt if the name is not in the current scope
+func CheckScopeName(name string) error {
+	s, log := currentScope()
+	if s == nil || s.ContainsName(name) {
+		return nil
+	}
+	err := fmt.Errorf("%s is %w", name, ErrOutOfScope)
+	if log != nil {
+		log.Warnf("[scope] blocked: %v", err)
+	}
+	return err
+}
@@ -0,0 +1,116 @@
+package helper
+
+import (
+	"errors"
+	"net/netip"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+const testScope = `# test engagement
+allow 10.10.0.0/16
+allow 192.168.5.10
+allow *.Acme.Internal.
+deny 10.10.99.0/24
+ports 22,80,8000-8100
+`
+
+func TestParseScope(t *testing.T) {
+	t.Parallel()
+	s, err := ParseScope(strings.NewReader(testScope))
+	if err != nil {
+		t.Fatal(err)
+	}
+	addrs := map[string]bool{
+		"10.10.1.1":         true,
+		"10.10.99.1":        false,
+		"10.11.0.1":         false,
+		"192.168.5.10":      true,
+		"192.168.5.11":      false,
+		"::ffff:10.10.1.1":  true,
+		"::ffff:10.10.99.1": false,
+		"fd00::1":           false,
+	}
+	for addr, want := range addrs {
+		if got := s.ContainsAddr(netip.MustParseAddr(addr)); got != want {
+			t.Errorf("%s: got %t, want %t", addr, got, want)
+		}
+	}
+	ports := map[uint16]bool{22: true, 23: false, 8000: true, 8050: true, 8101: false}
+	for port, want := range ports {
+		if got := s.ContainsPort(port); got != want {
+			t.Errorf("port %d: got %t, want %t", port, got, want)
+		}
+	}
+	names := map[string]bool{"acme.internal": true, "www.acme.internal.": true, "notacme.internal": false, "internal": false}
+	for name, want := range names {
+		if got := s.ContainsName(name); got != want {
+			t.Errorf("%s: got %t, want %t", name, got, want)
+		}
+	}
+
+	for _, invalid := range []string{"deny 10.0.0.0/8", "allow 10.0.0.0/33", "ports 80-22\nallow 10.0.0.1", "scan 10.0.0.1", "allow"} {
+		if _, err := ParseScope(strings.NewReader(invalid)); err == nil {
+			t.Errorf("%q: expected an error", invalid)
+		}
+	}
+}
+
+// no t.Parallel as the scope is global
+func TestCheckScope(t *testing.T) {
+	s, err := ParseScope(strings.NewReader(testScope))
+	if err != nil {
+		t.Fatal(err)
+	}
+	SetScope(nil, s)
+	defer SetScope(nil, nil)
+
+	if err := CheckScope(netip.MustParseAddr("10.10.1.1"), 80); err != nil {
+		t.Errorf("unexpected error %v", err)
+	}
+	if err := CheckScope(netip.MustParseAddr("10.10.1.1"), 0); err != nil {
+		t.Errorf("unexpected error %v for port 0", err)
+	}
+	if err := CheckScope(netip.MustParseAddr("10.10.1.1"), 443); !errors.Is(err, ErrOutOfScope) {
+		t.Errorf("expected port 443 to be out of scope, got %v", err)
+	}
+	if err := CheckScopeName("evil.example.com"); !errors.Is(err, ErrOutOfScope) {
+		t.Errorf("expected the name to be out of scope, got %v", err)
+	}
+
+	var ips []netip.Addr
+	var skipped int
+	for ip := range IPIterator([]string{"10.10.98.254/31", "10.10.99.0/30", "10.11.0.1"}) {
+		if ip.Error != nil {
+			if !errors.Is(ip.Error, ErrOutOfScope) {
+				t.Fatal(ip.Error)
+			}
+			skipped++
+			continue
+		}
+		ips = append(ips, ip.IP)
+	}
+	if len(ips) != 2 || ips[0] != netip.MustParseAddr("10.10.98.254") {
+		t.Errorf("unexpected addresses %v", ips)
+	}
+	if skipped != 2 {
+		t.Errorf("expected 2 skipped ranges, got %d", skipped)
+	}
+}
+
+func TestParsePorts(t *testing.T) {
+	t.Parallel()
+	ports, err := ParsePorts("161, 1161,9000-9002")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(ports, []uint16{161, 1161, 9000, 9001, 9002}) {
+		t.Errorf("unexpected ports %v", ports)
+	}
+	for _, value := range []string{"", "0", "abc", "70000", "10-5"} {
+		if _, err := ParsePorts(value); err == nil {
+			t.Errorf("expected an error on %q", value)
+		}
+	}
+}
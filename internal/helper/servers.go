@@ -0,0 +1,117 @@
+package helper
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// ServerAlias is a saved TURN server with everything needed to connect
+// to it, so it can be referred to by its name
+type ServerAlias struct {
+	// TurnServer is in the format host:port or a TURN URI
+	TurnServer string `yaml:"turnserver"`
+	Protocol   string `yaml:"protocol,omitempty"`
+	TLS        *bool  `yaml:"tls,omitempty"`
+	TLSVerify  *bool  `yaml:"tlsverify,omitempty"`
+	Username   string `yaml:"username,omitempty"`
+	Password   string `yaml:"password,omitempty"`
+	// Credentials is a credential provider used by commands supporting
+	// --credentials
+	Credentials string `yaml:"credentials,omitempty"`
+}
+
+// serversFile is the format of the servers file
+type serversFile struct {
+	Servers map[string]ServerAlias `yaml:"servers"`
+}
+
+// DefaultServersFile returns the path of the servers file in the user
+// config directory, for example ~/.config/stunner/servers.yaml
+func DefaultServersFile() (string, error) {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "stunner", "servers.yaml"), nil
+}
+
+// ReadServerAliases reads all aliases from a servers file
+func ReadServerAliases(filename string) (map[string]ServerAlias, error) {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	return ParseServerAliases(data)
+}
+
+// ParseServerAliases parses a servers file in the format
+//
+//	servers:
+//	  corp-sbc:
+//	    turnserver: turns:sbc.corp.com:443?transport=tcp
+//	    username: user
+//	    password: pass
+func ParseServerAliases(data []byte) (map[string]ServerAlias, error) {
+	var f serversFile
+	decoder := yaml.NewDecoder(strings.NewReader(string(data)))
+	decoder.KnownFields(true)
+	if err := decoder.Decode(&f); err != nil {
+		return nil, fmt.Errorf("invalid servers file: %w", err)
+	}
+	for name, alias := range f.Servers {
+		if alias.TurnServer == "" {
+			return nil, fmt.Errorf("server %s: turnserver must not be empty", name)
+		}
+		if alias.Protocol != "" && alias.Protocol != "tcp" && alias.Protocol != "udp" {
+			return nil, fmt.Errorf("server %s: protocol needs to be either tcp or udp", name)
+		}
+	}
+	return f.Servers, nil
+}
+
+// GetServerAlias returns the alias with the given name
+func GetServerAlias(aliases map[string]ServerAlias, name string) (ServerAlias, error) {
+	alias, ok := aliases[name]
+	if !ok {
+		names := make([]string, 0, len(aliases))
+		for n := range aliases {
+			names = append(names, n)
+		}
+		sort.Strings(names)
+		return ServerAlias{}, fmt.Errorf("unknown server %q. Saved servers: %s", name, strings.Join(names, ", "))
+	}
+	return alias, nil
+}
+
+// Flags returns the values of the alias by the name of the command line
+// flag they set. Empty values are not included
+func (a ServerAlias) Flags() map[string]string {
+	ret := map[string]string{
+		"turnserver": a.TurnServer,
+	}
+	if a.Protocol != "" {
+		ret["protocol"] = a.Protocol
+	}
+	if a.TLS != nil {
+		ret["tls"] = strconv.FormatBool(*a.TLS)
+	}
+	if a.TLSVerify != nil {
+		ret["tlsverify"] = strconv.FormatBool(*a.TLSVerify)
+	}
+	if a.Username != "" {
+		ret["username"] = a.Username
+	}
+	if a.Password != "" {
+		ret["password"] = a.Password
+	}
+	if a.Credentials != "" {
+		ret["credentials"] = a.Credentials
+	}
+	return ret
+}
@@ -0,0 +1,79 @@
+package helper
+
+import (
+	"testing"
+)
+
+func TestParseServerAliases(t *testing.T) {
+	t.Parallel()
+	data := []byte(`servers:
+  corp-sbc:
+    turnserver: turns:sbc.corp.com:443?transport=tcp
+    tlsverify: true
+    username: user
+    password: pass
+  lab:
+    turnserver: 10.0.0.1:3478
+    protocol: tcp
+    tls: false
+    credentials: env
+`)
+	aliases, err := ParseServerAliases(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(aliases) != 2 {
+		t.Fatalf("expected 2 servers, got %d", len(aliases))
+	}
+
+	alias, err := GetServerAlias(aliases, "corp-sbc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	flags := alias.Flags()
+	expected := map[string]string{
+		"turnserver": "turns:sbc.corp.com:443?transport=tcp",
+		"tlsverify":  "true",
+		"username":   "user",
+		"password":   "pass",
+	}
+	if len(flags) != len(expected) {
+		t.Errorf("expected %d flags, got %v", len(expected), flags)
+	}
+	for name, value := range expected {
+		if flags[name] != value {
+			t.Errorf("flag %s: expected %q, got %q", name, value, flags[name])
+		}
+	}
+
+	alias, err = GetServerAlias(aliases, "lab")
+	if err != nil {
+		t.Fatal(err)
+	}
+	flags = alias.Flags()
+	if flags["tls"] != "false" || flags["protocol"] != "tcp" || flags["credentials"] != "env" {
+		t.Errorf("unexpected flags %v", flags)
+	}
+	if _, ok := flags["username"]; ok {
+		t.Error("empty username should not be set")
+	}
+
+	if _, err := GetServerAlias(aliases, "unknown"); err == nil {
+		t.Error("expected an error for an unknown server")
+	}
+}
+
+func TestParseServerAliasesInvalid(t *testing.T) {
+	t.Parallel()
+	tests := map[string]string{
+		"missing turnserver": "servers:\n  a:\n    username: user\n",
+		"invalid protocol":   "servers:\n  a:\n    turnserver: 1.1.1.1:3478\n    protocol: sctp\n",
+		"unknown field":      "servers:\n  a:\n    turnserver: 1.1.1.1:3478\n    pasword: typo\n",
+		"invalid yaml":       "servers: [",
+	}
+	for name, data := range tests {
+		if _, err := ParseServerAliases([]byte(data)); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+}
@@ -0,0 +1,187 @@
+package helper
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"math/rand"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Shaping hides the timing and sizes of the data written by the client, so
+// the traffic between the operator and the relay can not be matched as
+// easily with the traffic between the relay and the target. The data
+// itself is not changed
+type Shaping struct {
+	// Jitter is the upper bound of the random delay before every packet
+	Jitter time.Duration
+	// Batch is the time the data is collected for after the first write
+	// before it is sent
+	Batch time.Duration
+	// MinSize and MaxSize are the bounds of the random packet sizes the
+	// data is split into. Sizes are not changed if MaxSize is 0
+	MinSize int
+	MaxSize int
+}
+
+var shapingLevels = map[string]Shaping{
+	"off": {},
+	"low": {
+		Jitter:  20 * time.Millisecond,
+		MinSize: 512,
+		MaxSize: 1400,
+	},
+	"medium": {
+		Jitter:  100 * time.Millisecond,
+		Batch:   50 * time.Millisecond,
+		MinSize: 256,
+		MaxSize: 1400,
+	},
+	"high": {
+		Jitter:  500 * time.Millisecond,
+		Batch:   250 * time.Millisecond,
+		MinSize: 64,
+		MaxSize: 1400,
+	},
+}
+
+// ShapingLevels returns the names of all available shaping levels
+func ShapingLevels() []string {
+	names := make([]string, 0, len(shapingLevels))
+	for name := range shapingLevels {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// GetShaping returns the shaping of the given level
+func GetShaping(level string) (Shaping, error) {
+	s, ok := shapingLevels[strings.ToLower(level)]
+	if !ok {
+		return Shaping{}, fmt.Errorf("invalid shaping level %q. Supported values: %s", level, strings.Join(ShapingLevels(), ", "))
+	}
+	return s, nil
+}
+
+// Enabled returns true if the shaping changes the traffic
+func (s Shaping) Enabled() bool {
+	return s.Jitter > 0 || s.Batch > 0 || s.MaxSize > 0
+}
+
+func (s Shaping) Validate() error {
+	if s.Jitter < 0 {
+		return fmt.Errorf("jitter can not be negative")
+	}
+	if s.Batch < 0 {
+		return fmt.Errorf("batch can not be negative")
+	}
+	if s.MinSize < 0 || s.MaxSize < 0 {
+		return fmt.Errorf("packet sizes can not be negative")
+	}
+	if s.MaxSize > 0 && (s.MinSize == 0 || s.MinSize > s.MaxSize) {
+		return fmt.Errorf("minimum packet size needs to be between 1 and %d", s.MaxSize)
+	}
+	return nil
+}
+
+// ShapedCopy copies from src to dst until EOF like CopyContext. The data
+// read is collected for the batch time and written in packets of random
+// size with a random delay in front of every packet. The write timeout of
+// opts applies to every packet
+func ShapedCopy(ctx context.Context, dst io.Writer, src io.Reader, s Shaping, opts CopyOptions) (int64, error) {
+	c := newCopier(ctx, dst, src, opts)
+	chunks := make(chan []byte)
+	readErr := make(chan error, 1)
+	done := make(chan struct{})
+	defer close(done)
+
+	go func() {
+		defer close(chunks)
+		for {
+			buf := make([]byte, c.opts.BufferSize)
+			n, err := c.read(buf)
+			if n > 0 {
+				select {
+				case chunks <- buf[:n]:
+				case <-done:
+					return
+				}
+			}
+			if err != nil {
+				readErr <- err
+				return
+			}
+		}
+	}()
+
+	var written int64
+	for {
+		var batch []byte
+		var ok bool
+		select {
+		case batch, ok = <-chunks:
+		case <-ctx.Done():
+			return written, ctx.Err()
+		}
+		if !ok {
+			if err := <-readErr; err != io.EOF {
+				return written, err
+			}
+			return written, nil
+		}
+		if s.Batch > 0 {
+			timer := time.NewTimer(s.Batch)
+		collect:
+			for {
+				select {
+				case chunk, ok := <-chunks:
+					if !ok {
+						break collect
+					}
+					batch = append(batch, chunk...)
+				case <-timer.C:
+					break collect
+				case <-ctx.Done():
+					timer.Stop()
+					return written, ctx.Err()
+				}
+			}
+			timer.Stop()
+		}
+		n, err := s.write(c, batch)
+		written += int64(n)
+		if err != nil {
+			return written, err
+		}
+	}
+}
+
+// write splits b into packets and writes them with the random delays
+func (s Shaping) write(c *copier, b []byte) (int, error) {
+	written := 0
+	for len(b) > 0 {
+		if s.Jitter > 0 {
+			select {
+			case <-time.After(time.Duration(rand.Int63n(int64(s.Jitter) + 1))):
+			case <-c.ctx.Done():
+				return written, c.ctx.Err()
+			}
+		}
+		n := len(b)
+		if s.MaxSize > 0 {
+			if size := s.MinSize + rand.Intn(s.MaxSize-s.MinSize+1); size < n {
+				n = size
+			}
+		}
+		m, err := c.write(b[:n])
+		written += m
+		if err != nil {
+			return written, err
+		}
+		b = b[n:]
+	}
+	return written, nil
+}
@@ -0,0 +1,76 @@
+package helper
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"testing"
+	"time"
+)
+
+func TestShapedCopySizes(t *testing.T) {
+	t.Parallel()
+	rec := &recordWriter{}
+	data := bytes.Repeat([]byte("0123456789"), 100)
+	s := Shaping{Jitter: time.Millisecond, MinSize: 10, MaxSize: 50}
+	n, err := ShapedCopy(context.Background(), rec, bytes.NewReader(data), s, CopyOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != int64(len(data)) {
+		t.Errorf("expected %d bytes written, got %d", len(data), n)
+	}
+	if !bytes.Equal(bytes.Join(rec.writes, nil), data) {
+		t.Error("data was changed")
+	}
+	for i, w := range rec.writes {
+		// the last packet holds the rest
+		if len(w) > s.MaxSize || (len(w) < s.MinSize && i != len(rec.writes)-1) {
+			t.Errorf("packet %d has %d bytes", i, len(w))
+		}
+	}
+}
+
+func TestShapedCopyBatch(t *testing.T) {
+	t.Parallel()
+	rec := &recordWriter{}
+	r, w := io.Pipe()
+	go func() {
+		for i := 0; i < 5; i++ {
+			_, _ = w.Write([]byte("data"))
+			time.Sleep(5 * time.Millisecond)
+		}
+		w.Close()
+	}()
+	if _, err := ShapedCopy(context.Background(), rec, r, Shaping{Batch: 500 * time.Millisecond}, CopyOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	if len(rec.writes) != 1 {
+		t.Fatalf("expected the writes to be batched into 1 packet, got %d", len(rec.writes))
+	}
+	if string(rec.writes[0]) != "datadatadatadatadata" {
+		t.Errorf("unexpected data %q", rec.writes[0])
+	}
+}
+
+func TestGetShaping(t *testing.T) {
+	t.Parallel()
+	for _, level := range ShapingLevels() {
+		s, err := GetShaping(level)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := s.Validate(); err != nil {
+			t.Errorf("level %s: %v", level, err)
+		}
+		if s.Enabled() != (level != "off") {
+			t.Errorf("level %s: enabled is %t", level, s.Enabled())
+		}
+	}
+	if _, err := GetShaping("paranoid"); err == nil {
+		t.Error("expected an error for an unknown level")
+	}
+	if err := (Shaping{MinSize: 100, MaxSize: 10}).Validate(); err == nil {
+		t.Error("expected an error for a minimum size above the maximum")
+	}
+}
@@ -0,0 +1,80 @@
+package helper
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"net/netip"
+	"net/textproto"
+	"strconv"
+	"strings"
+)
+
+// SIPResponse holds the parts of a SIP response identifying the server
+type SIPResponse struct {
+	StatusCode int
+	Reason     string
+	// Server is sent by proxies and registrars, User-Agent by PBXes and
+	// SBCs acting as user agent
+	Server    string
+	UserAgent string
+	// Allow are the supported methods
+	Allow string
+}
+
+// SIPOptions builds a SIP OPTIONS request (RFC 3261 section 11) over UDP to
+// the target. The client address is unknown behind the relay, so rport
+// (RFC 3581) makes the server answer to the address the request came from
+func SIPOptions(target netip.AddrPort) []byte {
+	branch := "z9hG4bK" + RandomString(16)
+	var b strings.Builder
+	fmt.Fprintf(&b, "OPTIONS sip:%s SIP/2.0\r\n", target)
+	fmt.Fprintf(&b, "Via: SIP/2.0/UDP stunner.invalid;branch=%s;rport\r\n", branch)
+	b.WriteString("Max-Forwards: 70\r\n")
+	fmt.Fprintf(&b, "To: <sip:%s>\r\n", target)
+	fmt.Fprintf(&b, "From: <sip:stunner@stunner.invalid>;tag=%s\r\n", RandomString(10))
+	fmt.Fprintf(&b, "Call-ID: %s\r\n", RandomString(24))
+	b.WriteString("CSeq: 1 OPTIONS\r\n")
+	b.WriteString("Contact: <sip:stunner@stunner.invalid>\r\n")
+	b.WriteString("Accept: application/sdp\r\n")
+	b.WriteString("Content-Length: 0\r\n\r\n")
+	return []byte(b.String())
+}
+
+// ParseSIPResponse parses the status line and headers of a SIP response
+func ParseSIPResponse(data []byte) (*SIPResponse, error) {
+	r := textproto.NewReader(bufio.NewReader(bytes.NewReader(data)))
+	line, err := r.ReadLine()
+	if err != nil {
+		return nil, fmt.Errorf("invalid sip response: %w", err)
+	}
+	proto, status, ok := strings.Cut(line, " ")
+	if !ok || proto != "SIP/2.0" {
+		return nil, fmt.Errorf("invalid sip status line %q", line)
+	}
+	code, reason, _ := strings.Cut(status, " ")
+	statusCode, err := strconv.Atoi(code)
+	if err != nil || statusCode < 100 || statusCode > 699 {
+		return nil, fmt.Errorf("invalid sip status code %q", code)
+	}
+	header, err := r.ReadMIMEHeader()
+	// the body is not needed, so a missing empty line is not an error
+	if err != nil && len(header) == 0 {
+		return nil, fmt.Errorf("invalid sip headers: %w", err)
+	}
+	return &SIPResponse{
+		StatusCode: statusCode,
+		Reason:     reason,
+		Server:     header.Get("Server"),
+		UserAgent:  header.Get("User-Agent"),
+		Allow:      header.Get("Allow"),
+	}, nil
+}
+
+// Product returns the Server or the User-Agent header
+func (r SIPResponse) Product() string {
+	if r.Server != "" {
+		return r.Server
+	}
+	return r.UserAgent
+}
@@ -0,0 +1,44 @@
+package helper
+
+import (
+	"net/netip"
+	"strings"
+	"testing"
+)
+
+func TestSIPOptions(t *testing.T) {
+	t.Parallel()
+	req := string(SIPOptions(netip.MustParseAddrPort("10.0.0.1:5060")))
+	if !strings.HasPrefix(req, "OPTIONS sip:10.0.0.1:5060 SIP/2.0\r\n") {
+		t.Errorf("invalid request line in %q", req)
+	}
+	if !strings.Contains(req, ";rport\r\n") || !strings.HasSuffix(req, "Content-Length: 0\r\n\r\n") {
+		t.Errorf("invalid request %q", req)
+	}
+}
+
+func TestParseSIPResponse(t *testing.T) {
+	t.Parallel()
+	data := "SIP/2.0 200 OK\r\nVia: SIP/2.0/UDP stunner.invalid;branch=z9hG4bKabc;rport=40000;received=10.0.0.2\r\nCSeq: 1 OPTIONS\r\nUser-Agent: FPBX-16.0.33(18.13.0)\r\nAllow: INVITE, ACK, CANCEL, OPTIONS, BYE\r\nContent-Length: 0\r\n\r\n"
+	resp, err := ParseSIPResponse([]byte(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != 200 || resp.Reason != "OK" || resp.Product() != "FPBX-16.0.33(18.13.0)" || resp.Allow != "INVITE, ACK, CANCEL, OPTIONS, BYE" {
+		t.Errorf("unexpected response %+v", resp)
+	}
+
+	resp, err = ParseSIPResponse([]byte("SIP/2.0 404 Not Found\r\nServer: Kamailio (5.7.2 (x86_64/linux))\r\n\r\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != 404 || resp.Product() != "Kamailio (5.7.2 (x86_64/linux))" {
+		t.Errorf("unexpected response %+v", resp)
+	}
+
+	for _, invalid := range []string{"", "HTTP/1.1 200 OK\r\n\r\n", "SIP/2.0 abc\r\n\r\n"} {
+		if _, err := ParseSIPResponse([]byte(invalid)); err == nil {
+			t.Errorf("expected an error for %q", invalid)
+		}
+	}
+}
@@ -0,0 +1,434 @@
+package helper
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"time"
+)
+
+// SMB2 commands from MS-SMB2 2.2.1
+const (
+	smb2Negotiate      uint16 = 0x0000
+	smb2SessionSetup   uint16 = 0x0001
+	smb2Logoff         uint16 = 0x0002
+	smb2TreeConnect    uint16 = 0x0003
+	smb2TreeDisconnect uint16 = 0x0004
+	smb2Create         uint16 = 0x0005
+	smb2Close          uint16 = 0x0006
+	smb2Read           uint16 = 0x0008
+	smb2Write          uint16 = 0x0009
+)
+
+const (
+	smbHeaderLen = 64
+
+	smbFlagResponse = 0x00000001
+	smbFlagAsync    = 0x00000002
+	smbFlagSigned   = 0x00000008
+
+	// negotiate security modes
+	smbSigningEnabled  = 0x0001
+	smbSigningRequired = 0x0002
+
+	// session flags of the session setup response
+	smbSessionGuest = 0x0001
+	smbSessionNull  = 0x0002
+
+	// smbMaxChunk is the largest read or write. Larger ones need multiple
+	// credits which the dialects 2.0.2 and 2.1 without large MTU lack
+	smbMaxChunk = 64 * 1024
+
+	// access masks and create dispositions from MS-SMB2 2.2.13
+	smbAccessRead  = 0x00100081 // SYNCHRONIZE, FILE_READ_ATTRIBUTES, FILE_READ_DATA
+	smbAccessWrite = 0x00100186 // SYNCHRONIZE, FILE_WRITE_ATTRIBUTES, FILE_READ_ATTRIBUTES, FILE_APPEND_DATA, FILE_WRITE_DATA
+	smbFileOpen    = 0x00000001
+	smbFileOpenIf  = 0x00000003
+	// smbFileOverwriteIf truncates an existing file
+	smbFileOverwriteIf = 0x00000005
+)
+
+// NT status codes of MS-ERREF 2.3.1
+const (
+	smbStatusSuccess                = 0x00000000
+	smbStatusPending                = 0x00000103
+	smbStatusMoreProcessingRequired = 0xc0000016
+	smbStatusEndOfFile              = 0xc0000011
+)
+
+var smbStatusNames = map[uint32]string{
+	0xc000000d: "STATUS_INVALID_PARAMETER",
+	0xc0000022: "STATUS_ACCESS_DENIED",
+	0xc0000033: "STATUS_OBJECT_NAME_INVALID",
+	0xc0000034: "STATUS_OBJECT_NAME_NOT_FOUND",
+	0xc000003a: "STATUS_OBJECT_PATH_NOT_FOUND",
+	0xc0000043: "STATUS_SHARING_VIOLATION",
+	0xc000006d: "STATUS_LOGON_FAILURE",
+	0xc0000071: "STATUS_PASSWORD_EXPIRED",
+	0xc0000072: "STATUS_ACCOUNT_DISABLED",
+	0xc000007f: "STATUS_DISK_FULL",
+	0xc00000ba: "STATUS_FILE_IS_A_DIRECTORY",
+	0xc00000bb: "STATUS_NOT_SUPPORTED",
+	0xc00000cc: "STATUS_BAD_NETWORK_NAME",
+	0xc0000234: "STATUS_ACCOUNT_LOCKED_OUT",
+}
+
+// SMBError is a negative response of the SMB server
+type SMBError struct {
+	Status uint32
+}
+
+func (e *SMBError) Error() string {
+	if name, ok := smbStatusNames[e.Status]; ok {
+		return fmt.Sprintf("%s (0x%08x)", name, e.Status)
+	}
+	return fmt.Sprintf("NT status 0x%08x", e.Status)
+}
+
+// SMBClient is a minimal SMB2 client for file transfers on a single
+// share. It speaks the dialects 2.0.2 and 2.1, which all current servers
+// still offer, logs in with NTLMv2 and signs the requests if the server
+// requires it
+type SMBClient struct {
+	conn      net.Conn
+	timeout   time.Duration
+	messageID uint64
+	sessionID uint64
+	treeID    uint32
+	// signingKey is set if the requests are signed
+	signingKey []byte
+	signing    bool
+	maxRead    uint32
+	maxWrite   uint32
+}
+
+// NewSMBClient negotiates the dialect with the server on conn
+func NewSMBClient(conn net.Conn, timeout time.Duration) (*SMBClient, error) {
+	c := &SMBClient{
+		conn:    conn,
+		timeout: timeout,
+	}
+
+	req := make([]byte, 36)
+	binary.LittleEndian.PutUint16(req[0:], 36)
+	binary.LittleEndian.PutUint16(req[2:], 2)
+	binary.LittleEndian.PutUint16(req[4:], smbSigningEnabled)
+	if _, err := rand.Read(req[12:28]); err != nil {
+		return nil, err
+	}
+	req = append(req, 0x02, 0x02, 0x10, 0x02)
+	_, resp, err := c.request(smb2Negotiate, req)
+	if err != nil {
+		return nil, fmt.Errorf("could not negotiate the dialect: %w", err)
+	}
+	if len(resp) < 64 {
+		return nil, fmt.Errorf("invalid negotiate response")
+	}
+	if dialect := binary.LittleEndian.Uint16(resp[4:]); dialect != 0x0202 && dialect != 0x0210 {
+		return nil, fmt.Errorf("server selected the unsupported dialect 0x%04x", dialect)
+	}
+	c.signing = binary.LittleEndian.Uint16(resp[2:])&smbSigningRequired != 0
+	c.maxRead = binary.LittleEndian.Uint32(resp[32:])
+	c.maxWrite = binary.LittleEndian.Uint32(resp[36:])
+	return c, nil
+}
+
+// Login authenticates with NTLMv2. An empty username logs in anonymously
+func (c *SMBClient) Login(domain, username, password string) error {
+	header, resp, err := c.sessionSetup(NTLMNegotiate())
+	if err == nil {
+		return fmt.Errorf("server did not send an NTLM challenge")
+	}
+	var smbErr *SMBError
+	if !errors.As(err, &smbErr) || smbErr.Status != smbStatusMoreProcessingRequired {
+		return err
+	}
+	c.sessionID = binary.LittleEndian.Uint64(header[40:])
+	challenge, err := ParseNTLMChallenge(securityBuffer(resp))
+	if err != nil {
+		return err
+	}
+	token, sessionKey, err := NTLMAuthenticate(challenge, domain, username, password)
+	if err != nil {
+		return err
+	}
+	_, resp, err = c.sessionSetup(token)
+	if err != nil {
+		return err
+	}
+	if len(resp) < 4 {
+		return fmt.Errorf("invalid session setup response")
+	}
+	if flags := binary.LittleEndian.Uint16(resp[2:]); flags&(smbSessionGuest|smbSessionNull) != 0 {
+		// guest and anonymous sessions have no session key to sign with
+		if c.signing {
+			return fmt.Errorf("server requires signing which guest and anonymous logins can not do")
+		}
+		return nil
+	}
+	if c.signing {
+		c.signingKey = sessionKey
+	}
+	return nil
+}
+
+// TreeConnect connects to the share \\host\share
+func (c *SMBClient) TreeConnect(host, share string) error {
+	path := utf16LE(fmt.Sprintf(`\\%s\%s`, host, share))
+	req := make([]byte, 8)
+	binary.LittleEndian.PutUint16(req[0:], 9)
+	binary.LittleEndian.PutUint16(req[4:], smbHeaderLen+8)
+	binary.LittleEndian.PutUint16(req[6:], uint16(len(path)))
+	header, _, err := c.request(smb2TreeConnect, append(req, path...))
+	if err != nil {
+		return fmt.Errorf("could not connect to share %s: %w", share, err)
+	}
+	c.treeID = binary.LittleEndian.Uint32(header[36:])
+	return nil
+}
+
+// Open opens the file on the share for reading or writing. Writing
+// creates the file and truncates it unless keep is set
+func (c *SMBClient) Open(name string, write, keep bool) (*SMBFile, error) {
+	access, disposition, share := uint32(smbAccessRead), uint32(smbFileOpen), uint32(1)
+	if write {
+		access, disposition, share = smbAccessWrite, smbFileOverwriteIf, 0
+		if keep {
+			disposition = smbFileOpenIf
+		}
+	}
+	encoded := utf16LE(strings.ReplaceAll(strings.Trim(name, `/\`), "/", `\`))
+	req := make([]byte, 56)
+	binary.LittleEndian.PutUint16(req[0:], 57)
+	// impersonation level Impersonation
+	binary.LittleEndian.PutUint32(req[4:], 2)
+	binary.LittleEndian.PutUint32(req[24:], access)
+	// FILE_ATTRIBUTE_NORMAL
+	binary.LittleEndian.PutUint32(req[28:], 0x80)
+	binary.LittleEndian.PutUint32(req[32:], share)
+	binary.LittleEndian.PutUint32(req[36:], disposition)
+	// FILE_NON_DIRECTORY_FILE
+	binary.LittleEndian.PutUint32(req[40:], 0x40)
+	binary.LittleEndian.PutUint16(req[44:], smbHeaderLen+56)
+	binary.LittleEndian.PutUint16(req[46:], uint16(len(encoded)))
+	_, resp, err := c.request(smb2Create, append(req, encoded...))
+	if err != nil {
+		return nil, fmt.Errorf("could not open %s: %w", name, err)
+	}
+	if len(resp) < 80 {
+		return nil, fmt.Errorf("invalid create response")
+	}
+	f := &SMBFile{
+		c:    c,
+		size: int64(binary.LittleEndian.Uint64(resp[48:])),
+	}
+	copy(f.fileID[:], resp[64:80])
+	return f, nil
+}
+
+// Logoff disconnects the share, ends the session and closes the connection
+func (c *SMBClient) Logoff() error {
+	if c.treeID != 0 {
+		_, _, _ = c.request(smb2TreeDisconnect, []byte{0x04, 0x00, 0x00, 0x00})
+	}
+	if c.sessionID != 0 {
+		_, _, _ = c.request(smb2Logoff, []byte{0x04, 0x00, 0x00, 0x00})
+	}
+	return c.conn.Close()
+}
+
+func (c *SMBClient) sessionSetup(token []byte) ([]byte, []byte, error) {
+	req := make([]byte, 24)
+	binary.LittleEndian.PutUint16(req[0:], 25)
+	req[3] = smbSigningEnabled
+	binary.LittleEndian.PutUint16(req[12:], smbHeaderLen+24)
+	binary.LittleEndian.PutUint16(req[14:], uint16(len(token)))
+	return c.request(smb2SessionSetup, append(req, token...))
+}
+
+// securityBuffer returns the security buffer of a session setup response
+func securityBuffer(resp []byte) []byte {
+	if len(resp) < 8 {
+		return nil
+	}
+	offset := int(binary.LittleEndian.Uint16(resp[4:])) - smbHeaderLen
+	length := int(binary.LittleEndian.Uint16(resp[6:]))
+	if offset < 0 || offset+length > len(resp) {
+		return nil
+	}
+	return resp[offset : offset+length]
+}
+
+// request sends the command and returns the header and the body of the
+// response. Negative responses are returned with an SMBError
+func (c *SMBClient) request(command uint16, body []byte) ([]byte, []byte, error) {
+	msg := make([]byte, 4+smbHeaderLen, 4+smbHeaderLen+len(body))
+	header := msg[4:]
+	copy(header, "\xfeSMB")
+	binary.LittleEndian.PutUint16(header[4:], smbHeaderLen)
+	binary.LittleEndian.PutUint16(header[12:], command)
+	// one credit is enough as there is a single request in flight
+	binary.LittleEndian.PutUint16(header[14:], 1)
+	binary.LittleEndian.PutUint64(header[24:], c.messageID)
+	binary.LittleEndian.PutUint32(header[32:], 0xfeff)
+	binary.LittleEndian.PutUint32(header[36:], c.treeID)
+	binary.LittleEndian.PutUint64(header[40:], c.sessionID)
+	msg = append(msg, body...)
+	if c.signingKey != nil {
+		binary.LittleEndian.PutUint32(msg[4+16:], smbFlagSigned)
+		mac := hmac.New(sha256.New, c.signingKey)
+		mac.Write(msg[4:])
+		copy(msg[4+48:4+64], mac.Sum(nil))
+	}
+	// the direct TCP transport prefixes the length
+	binary.BigEndian.PutUint32(msg, uint32(len(msg)-4))
+	messageID := c.messageID
+	c.messageID++
+	if err := ConnectionWrite(c.conn, msg, c.timeout); err != nil {
+		return nil, nil, err
+	}
+
+	for {
+		resp, err := ConnectionReadMessage(c.conn, 4, func(h []byte) (int, error) {
+			return 4 + int(binary.BigEndian.Uint32(h)&0xffffff), nil
+		}, c.timeout)
+		if err != nil {
+			return nil, nil, err
+		}
+		resp = resp[4:]
+		if len(resp) < smbHeaderLen || string(resp[:4]) != "\xfeSMB" {
+			return nil, nil, fmt.Errorf("invalid SMB2 response")
+		}
+		status := binary.LittleEndian.Uint32(resp[8:])
+		flags := binary.LittleEndian.Uint32(resp[16:])
+		if binary.LittleEndian.Uint64(resp[24:]) != messageID || flags&smbFlagResponse == 0 {
+			// oplock breaks and other unsolicited messages
+			continue
+		}
+		if status == smbStatusPending && flags&smbFlagAsync != 0 {
+			// interim response, the final one follows
+			continue
+		}
+		header, body := resp[:smbHeaderLen], resp[smbHeaderLen:]
+		if status != smbStatusSuccess {
+			return header, body, &SMBError{Status: status}
+		}
+		return header, body, nil
+	}
+}
+
+// SMBFile is an open file on the share. Reads and writes start at the
+// offset set with Seek
+type SMBFile struct {
+	c      *SMBClient
+	fileID [16]byte
+	size   int64
+	offset int64
+}
+
+// Size returns the size of the file when it was opened
+func (f *SMBFile) Size() int64 {
+	return f.size
+}
+
+func (f *SMBFile) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += f.offset
+	case io.SeekEnd:
+		offset += f.size
+	default:
+		return 0, fmt.Errorf("invalid whence %d", whence)
+	}
+	if offset < 0 {
+		return 0, fmt.Errorf("negative offset %d", offset)
+	}
+	f.offset = offset
+	return offset, nil
+}
+
+func (f *SMBFile) Read(p []byte) (int, error) {
+	length := f.chunk(len(p), f.c.maxRead)
+	req := make([]byte, 49)
+	binary.LittleEndian.PutUint16(req[0:], 49)
+	req[2] = smbHeaderLen + 16
+	binary.LittleEndian.PutUint32(req[4:], uint32(length))
+	binary.LittleEndian.PutUint64(req[8:], uint64(f.offset))
+	copy(req[16:32], f.fileID[:])
+	_, resp, err := f.c.request(smb2Read, req)
+	var smbErr *SMBError
+	if errors.As(err, &smbErr) && smbErr.Status == smbStatusEndOfFile {
+		return 0, io.EOF
+	}
+	if err != nil {
+		return 0, err
+	}
+	if len(resp) < 16 {
+		return 0, fmt.Errorf("invalid read response")
+	}
+	offset := int(resp[2]) - smbHeaderLen
+	count := int(binary.LittleEndian.Uint32(resp[4:]))
+	if offset < 0 || offset+count > len(resp) || count > len(p) {
+		return 0, fmt.Errorf("invalid read response")
+	}
+	if count == 0 {
+		return 0, io.EOF
+	}
+	n := copy(p, resp[offset:offset+count])
+	f.offset += int64(n)
+	return n, nil
+}
+
+func (f *SMBFile) Write(p []byte) (int, error) {
+	written := 0
+	for written < len(p) {
+		length := f.chunk(len(p)-written, f.c.maxWrite)
+		req := make([]byte, 48, 48+length)
+		binary.LittleEndian.PutUint16(req[0:], 49)
+		binary.LittleEndian.PutUint16(req[2:], smbHeaderLen+48)
+		binary.LittleEndian.PutUint32(req[4:], uint32(length))
+		binary.LittleEndian.PutUint64(req[8:], uint64(f.offset))
+		copy(req[16:32], f.fileID[:])
+		_, resp, err := f.c.request(smb2Write, append(req, p[written:written+length]...))
+		if err != nil {
+			return written, err
+		}
+		if len(resp) < 8 {
+			return written, fmt.Errorf("invalid write response")
+		}
+		count := int(binary.LittleEndian.Uint32(resp[4:]))
+		if count == 0 || count > length {
+			return written, fmt.Errorf("server wrote %d of %d bytes", count, length)
+		}
+		written += count
+		f.offset += int64(count)
+	}
+	return written, nil
+}
+
+// Close closes the file handle
+func (f *SMBFile) Close() error {
+	req := make([]byte, 24)
+	binary.LittleEndian.PutUint16(req[0:], 24)
+	copy(req[8:24], f.fileID[:])
+	_, _, err := f.c.request(smb2Close, req)
+	return err
+}
+
+// chunk returns the size of the next read or write of at most n bytes
+func (f *SMBFile) chunk(n int, limit uint32) int {
+	if n > smbMaxChunk {
+		n = smbMaxChunk
+	}
+	if limit > 0 && n > int(limit) {
+		n = int(limit)
+	}
+	return n
+}
@@ -0,0 +1,156 @@
+package helper
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"net"
+	"testing"
+	"time"
+)
+
+// smbTestServer answers the requests on conn with handle until the
+// connection is closed
+func smbTestServer(t *testing.T, conn net.Conn, handle func(command uint16, body []byte) (uint32, []byte)) {
+	t.Helper()
+	go func() {
+		for {
+			req, err := ConnectionReadMessage(conn, 4, func(h []byte) (int, error) {
+				return 4 + int(binary.BigEndian.Uint32(h)&0xffffff), nil
+			}, 5*time.Second)
+			if err != nil {
+				return
+			}
+			header := append([]byte{}, req[4:4+smbHeaderLen]...)
+			status, body := handle(binary.LittleEndian.Uint16(header[12:]), req[4+smbHeaderLen:])
+			binary.LittleEndian.PutUint32(header[8:], status)
+			binary.LittleEndian.PutUint32(header[16:], smbFlagResponse)
+			binary.LittleEndian.PutUint64(header[40:], 0x1234)
+			binary.LittleEndian.PutUint32(header[36:], 7)
+			resp := append(make([]byte, 4), header...)
+			resp = append(resp, body...)
+			binary.BigEndian.PutUint32(resp, uint32(len(resp)-4))
+			if _, err := conn.Write(resp); err != nil {
+				return
+			}
+		}
+	}()
+}
+
+func TestSMBClientRead(t *testing.T) {
+	t.Parallel()
+	client, server := net.Pipe()
+	defer server.Close()
+
+	content := bytes.Repeat([]byte("0123456789"), 10000)
+	var commands []uint16
+	var share, name string
+	smbTestServer(t, server, func(command uint16, body []byte) (uint32, []byte) {
+		commands = append(commands, command)
+		switch command {
+		case smb2Negotiate:
+			resp := make([]byte, 64)
+			binary.LittleEndian.PutUint16(resp[4:], 0x0210)
+			binary.LittleEndian.PutUint32(resp[32:], 1<<20)
+			binary.LittleEndian.PutUint32(resp[36:], 1<<20)
+			return smbStatusSuccess, resp
+		case smb2SessionSetup:
+			if !bytes.Contains(body, ntlmSignature) {
+				t.Error("session setup without an NTLM message")
+			}
+			if body[bytes.Index(body, ntlmSignature)+8] == 1 {
+				challenge := make([]byte, 48)
+				copy(challenge, ntlmSignature)
+				challenge[8] = 2
+				challenge[44] = 48
+				resp := make([]byte, 8)
+				binary.LittleEndian.PutUint16(resp[4:], smbHeaderLen+8)
+				binary.LittleEndian.PutUint16(resp[6:], uint16(len(challenge)))
+				return smbStatusMoreProcessingRequired, append(resp, challenge...)
+			}
+			return smbStatusSuccess, make([]byte, 8)
+		case smb2TreeConnect:
+			share = string(body[8:])
+			return smbStatusSuccess, make([]byte, 16)
+		case smb2Create:
+			name = string(body[56:])
+			resp := make([]byte, 88)
+			binary.LittleEndian.PutUint64(resp[48:], uint64(len(content)))
+			return smbStatusSuccess, resp
+		case smb2Read:
+			length := int(binary.LittleEndian.Uint32(body[4:]))
+			offset := int(binary.LittleEndian.Uint64(body[8:]))
+			if offset >= len(content) {
+				return smbStatusEndOfFile, make([]byte, 8)
+			}
+			data := content[offset:]
+			if len(data) > length {
+				data = data[:length]
+			}
+			resp := make([]byte, 16)
+			resp[2] = smbHeaderLen + 16
+			binary.LittleEndian.PutUint32(resp[4:], uint32(len(data)))
+			return smbStatusSuccess, append(resp, data...)
+		default:
+			return smbStatusSuccess, make([]byte, 8)
+		}
+	})
+
+	c, err := NewSMBClient(client, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Login("DOMAIN", "user", "password"); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.TreeConnect("10.0.0.1", "share"); err != nil {
+		t.Fatal(err)
+	}
+	if share != string(utf16LE(`\\10.0.0.1\share`)) {
+		t.Errorf("unexpected share path %q", share)
+	}
+	f, err := c.Open("/dir/file.txt", false, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if name != string(utf16LE(`dir\file.txt`)) {
+		t.Errorf("unexpected file name %q", name)
+	}
+	if f.Size() != int64(len(content)) {
+		t.Errorf("expected a size of %d, got %d", len(content), f.Size())
+	}
+	if _, err := f.Seek(10, io.SeekStart); err != nil {
+		t.Fatal(err)
+	}
+	var data []byte
+	buf := make([]byte, 1<<20)
+	for {
+		n, err := f.Read(buf)
+		data = append(data, buf[:n]...)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if !bytes.Equal(data, content[10:]) {
+		t.Errorf("read %d bytes that differ from the file", len(data))
+	}
+	if err := f.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Logoff(); err != nil {
+		t.Fatal(err)
+	}
+	// the file is read in chunks of 64KiB
+	expected := []uint16{smb2Negotiate, smb2SessionSetup, smb2SessionSetup, smb2TreeConnect, smb2Create, smb2Read, smb2Read, smb2Read, smb2Close, smb2TreeDisconnect, smb2Logoff}
+	if len(commands) != len(expected) {
+		t.Fatalf("expected the commands %v, got %v", expected, commands)
+	}
+	for i := range expected {
+		if commands[i] != expected[i] {
+			t.Fatalf("expected the commands %v, got %v", expected, commands)
+		}
+	}
+}
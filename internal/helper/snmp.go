@@ -0,0 +1,464 @@
+package helper
+
+import (
+	"encoding/asn1"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"math/big"
+	"net"
+	"net/netip"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+)
+
+// SNMP PDU types (RFC 3416)
+const (
+	snmpGetRequest  = 0
+	snmpGetResponse = 2
+	snmpVersion2c   = 1
+)
+
+// SNMP application types of values (RFC 2578) and the exceptions of
+// RFC 3416 that replace the value of a variable binding
+const (
+	snmpIPAddress      = 0
+	snmpCounter32      = 1
+	snmpGauge32        = 2
+	snmpTimeTicks      = 3
+	snmpOpaque         = 4
+	snmpCounter64      = 6
+	snmpNoSuchObject   = 0
+	snmpNoSuchInstance = 1
+	snmpEndOfMibView   = 2
+)
+
+// Objects of the system group every agent implements
+var (
+	SNMPSysDescr = asn1.ObjectIdentifier{1, 3, 6, 1, 2, 1, 1, 1, 0}
+	SNMPSysName  = asn1.ObjectIdentifier{1, 3, 6, 1, 2, 1, 1, 5, 0}
+)
+
+// snmpObjectNames are the names of the objects logged instead of the OID
+var snmpObjectNames = map[string]string{
+	SNMPSysDescr.String(): "sysDescr",
+	SNMPSysName.String():  "sysName",
+}
+
+// snmpErrorStatus are the names of the error-status values of RFC 3416
+var snmpErrorStatus = []string{
+	"noError", "tooBig", "noSuchName", "badValue", "readOnly", "genErr",
+	"noAccess", "wrongType", "wrongLength", "wrongEncoding", "wrongValue",
+	"noCreation", "inconsistentValue", "resourceUnavailable", "commitFailed",
+	"undoFailed", "authorizationError", "notWritable", "inconsistentName",
+}
+
+// SNMPResponse holds the parsed parts of a GetResponse PDU
+type SNMPResponse struct {
+	Version   int
+	Community string
+	RequestID int32
+	// ErrorStatus is 0 on success, the bindings are a copy of the request
+	// otherwise
+	ErrorStatus int
+	// ErrorIndex is the 1 based index of the binding that caused the error
+	ErrorIndex int
+	Bindings   []SNMPBinding
+}
+
+// SNMPBinding is a variable binding of a response with the value in
+// readable form
+type SNMPBinding struct {
+	OID   asn1.ObjectIdentifier
+	Value string
+	// Exception is set if the agent has no value for the OID, like
+	// noSuchObject
+	Exception string
+}
+
+type snmpMessage struct {
+	Version   int
+	Community []byte
+	PDU       asn1.RawValue
+}
+
+type snmpPDU struct {
+	RequestID   int32
+	ErrorStatus int
+	ErrorIndex  int
+	Bindings    []snmpRawBinding
+}
+
+type snmpRawBinding struct {
+	OID   asn1.ObjectIdentifier
+	Value asn1.RawValue
+}
+
+// SNMPGetRequest builds a SNMP v2c GetRequest for the OIDs
+func SNMPGetRequest(community string, requestID int32, oids ...asn1.ObjectIdentifier) []byte {
+	var bindings [][]byte
+	for _, oid := range oids {
+		o, _ := asn1.Marshal(oid)
+		bindings = append(bindings, derSequence(o, asn1.NullBytes))
+	}
+	pdu := derTLV(0xa0|snmpGetRequest, concat(
+		derInteger(int64(requestID)),
+		// error-status and error-index
+		derInteger(0),
+		derInteger(0),
+		derSequence(bindings...),
+	))
+	return derSequence(derInteger(snmpVersion2c), derTLV(0x04, []byte(community)), pdu)
+}
+
+// SNMPRequestID returns the request id of a SNMP message like the request
+// built by SNMPGetRequest
+func SNMPRequestID(msg []byte) (int32, error) {
+	var m snmpMessage
+	if _, err := asn1.Unmarshal(msg, &m); err != nil {
+		return 0, fmt.Errorf("invalid snmp message: %w", err)
+	}
+	var pdu snmpPDU
+	if _, err := asn1.UnmarshalWithParams(m.PDU.FullBytes, &pdu, fmt.Sprintf("tag:%d", m.PDU.Tag)); err != nil {
+		return 0, fmt.Errorf("invalid snmp pdu: %w", err)
+	}
+	return pdu.RequestID, nil
+}
+
+// ParseSNMPResponse parses the GetResponse to the request with requestID
+func ParseSNMPResponse(buf []byte, requestID int32) (*SNMPResponse, error) {
+	var m snmpMessage
+	if _, err := asn1.Unmarshal(buf, &m); err != nil {
+		return nil, fmt.Errorf("invalid snmp message: %w", err)
+	}
+	if m.PDU.Class != asn1.ClassContextSpecific || m.PDU.Tag != snmpGetResponse {
+		return nil, fmt.Errorf("message is not a GetResponse but pdu %d", m.PDU.Tag)
+	}
+	var pdu snmpPDU
+	if _, err := asn1.UnmarshalWithParams(m.PDU.FullBytes, &pdu, fmt.Sprintf("tag:%d", snmpGetResponse)); err != nil {
+		return nil, fmt.Errorf("invalid snmp pdu: %w", err)
+	}
+	if pdu.RequestID != requestID {
+		return nil, fmt.Errorf("response does not belong to the request")
+	}
+
+	resp := &SNMPResponse{
+		Version:     m.Version,
+		Community:   string(m.Community),
+		RequestID:   pdu.RequestID,
+		ErrorStatus: pdu.ErrorStatus,
+		ErrorIndex:  pdu.ErrorIndex,
+	}
+	for _, b := range pdu.Bindings {
+		binding := SNMPBinding{OID: b.OID}
+		if b.Value.Class == asn1.ClassContextSpecific {
+			binding.Exception = snmpException(b.Value.Tag)
+		} else {
+			binding.Value = snmpValue(b.Value)
+		}
+		resp.Bindings = append(resp.Bindings, binding)
+	}
+	return resp, nil
+}
+
+// Value returns the value of the binding of the OID
+func (r SNMPResponse) Value(oid asn1.ObjectIdentifier) (string, bool) {
+	for _, b := range r.Bindings {
+		if b.OID.Equal(oid) && b.Exception == "" {
+			return b.Value, true
+		}
+	}
+	return "", false
+}
+
+// String returns the bindings or the error of the response
+func (r SNMPResponse) String() string {
+	if r.ErrorStatus != 0 {
+		return fmt.Sprintf("error %s at binding %d", SNMPErrorStatusString(r.ErrorStatus), r.ErrorIndex)
+	}
+	var bindings []string
+	for _, b := range r.Bindings {
+		bindings = append(bindings, b.String())
+	}
+	return strings.Join(bindings, ", ")
+}
+
+// String returns the name of the object and its value
+func (b SNMPBinding) String() string {
+	name := b.OID.String()
+	if n, ok := snmpObjectNames[name]; ok {
+		name = n
+	}
+	if b.Exception != "" {
+		return fmt.Sprintf("%s %s", name, b.Exception)
+	}
+	return fmt.Sprintf("%s %s", name, b.Value)
+}
+
+// SNMPErrorStatusString returns the name of the error-status
+func SNMPErrorStatusString(status int) string {
+	if status >= 0 && status < len(snmpErrorStatus) {
+		return snmpErrorStatus[status]
+	}
+	return fmt.Sprintf("%d", status)
+}
+
+func snmpException(tag int) string {
+	switch tag {
+	case snmpNoSuchObject:
+		return "noSuchObject"
+	case snmpNoSuchInstance:
+		return "noSuchInstance"
+	case snmpEndOfMibView:
+		return "endOfMibView"
+	}
+	return fmt.Sprintf("exception %d", tag)
+}
+
+// snmpValue returns a value in readable form. Strings are quoted, binary
+// strings like MAC addresses are hex encoded
+func snmpValue(v asn1.RawValue) string {
+	switch v.Class {
+	case asn1.ClassUniversal:
+		switch v.Tag {
+		case asn1.TagInteger:
+			var i *big.Int
+			if _, err := asn1.Unmarshal(v.FullBytes, &i); err == nil {
+				return i.String()
+			}
+		case asn1.TagOctetString:
+			return snmpString(v.Bytes)
+		case asn1.TagNull:
+			return "null"
+		case asn1.TagOID:
+			var oid asn1.ObjectIdentifier
+			if _, err := asn1.Unmarshal(v.FullBytes, &oid); err == nil {
+				return oid.String()
+			}
+		}
+	case asn1.ClassApplication:
+		switch v.Tag {
+		case snmpIPAddress:
+			if ip, ok := netip.AddrFromSlice(v.Bytes); ok {
+				return ip.String()
+			}
+		case snmpCounter32, snmpGauge32, snmpCounter64:
+			return new(big.Int).SetBytes(v.Bytes).String()
+		case snmpTimeTicks:
+			// hundredths of a second
+			ticks := new(big.Int).SetBytes(v.Bytes).Uint64()
+			return fmt.Sprintf("%d (%dd %02d:%02d:%02d)", ticks, ticks/8640000, ticks/360000%24, ticks/6000%60, ticks/100%60)
+		case snmpOpaque:
+			return hex.EncodeToString(v.Bytes)
+		}
+	}
+	return hex.EncodeToString(v.Bytes)
+}
+
+func snmpString(b []byte) string {
+	s := strings.TrimRight(string(b), "\x00")
+	if !utf8.ValidString(s) {
+		return hex.EncodeToString(b)
+	}
+	for _, r := range s {
+		if !unicode.IsPrint(r) && !unicode.IsSpace(r) {
+			return hex.EncodeToString(b)
+		}
+	}
+	return fmt.Sprintf("%q", s)
+}
+
+// SNMPv3 message constants (RFC 3412 and RFC 3414)
+const (
+	snmpVersion3       = 3
+	snmpMaxSize        = 65507
+	snmpFlagReportable = 0x04
+	snmpSecurityUSM    = 3
+	snmpReport         = 8
+)
+
+// snmpEnterprises are the vendors of common enterprise numbers in engine
+// IDs (IANA private enterprise numbers)
+var snmpEnterprises = map[uint32]string{
+	9:     "Cisco",
+	11:    "HP",
+	171:   "D-Link",
+	311:   "Microsoft",
+	674:   "Dell",
+	1916:  "Extreme Networks",
+	1991:  "Brocade",
+	2011:  "Huawei",
+	2620:  "Check Point",
+	2636:  "Juniper",
+	3375:  "F5",
+	4526:  "Netgear",
+	6527:  "Nokia",
+	6876:  "VMware",
+	8072:  "net-snmp",
+	12356: "Fortinet",
+	14988: "MikroTik",
+	25461: "Palo Alto Networks",
+	25506: "H3C",
+	30065: "Arista",
+	41112: "Ubiquiti",
+}
+
+// SNMPEngine holds the USM parameters of an SNMPv3 agent as reported on
+// engine discovery
+type SNMPEngine struct {
+	ID []byte
+	// Boots is the number of times the engine was restarted
+	Boots int
+	// Time is the time since the last boot
+	Time time.Duration
+	// Enterprise is the IANA private enterprise number of the vendor
+	Enterprise uint32
+	// Vendor is the name of the enterprise if it is known
+	Vendor string
+	// Data is the decoded vendor specific part of the engine ID like the
+	// MAC or IP address of the agent
+	Data string
+}
+
+type snmpV3Message struct {
+	Version    int
+	GlobalData struct {
+		MsgID         int32
+		MaxSize       int
+		Flags         []byte
+		SecurityModel int
+	}
+	SecurityParameters []byte
+	Data               asn1.RawValue
+}
+
+type snmpUSMParameters struct {
+	EngineID   []byte
+	Boots      int
+	Time       int
+	UserName   []byte
+	AuthParams []byte
+	PrivParams []byte
+}
+
+// SNMPv3Discovery builds an unauthenticated SNMPv3 GetRequest with empty
+// USM parameters. Agents answer it with a report containing their engine
+// ID, boots and time (RFC 3414 section 4) without knowing any user
+func SNMPv3Discovery(msgID int32) []byte {
+	usm := derSequence(
+		derTLV(0x04, nil),
+		derInteger(0),
+		derInteger(0),
+		derTLV(0x04, nil),
+		derTLV(0x04, nil),
+		derTLV(0x04, nil),
+	)
+	pdu := derTLV(0xa0|snmpGetRequest, concat(
+		derInteger(int64(msgID)),
+		derInteger(0),
+		derInteger(0),
+		derSequence(),
+	))
+	return derSequence(
+		derInteger(snmpVersion3),
+		derSequence(derInteger(int64(msgID)), derInteger(snmpMaxSize), derTLV(0x04, []byte{snmpFlagReportable}), derInteger(snmpSecurityUSM)),
+		derTLV(0x04, usm),
+		derSequence(derTLV(0x04, nil), derTLV(0x04, nil), pdu),
+	)
+}
+
+// SNMPv3MessageID returns the message id of a SNMPv3 message like the
+// request built by SNMPv3Discovery
+func SNMPv3MessageID(msg []byte) (int32, error) {
+	var m snmpV3Message
+	if _, err := asn1.Unmarshal(msg, &m); err != nil {
+		return 0, fmt.Errorf("invalid snmpv3 message: %w", err)
+	}
+	return m.GlobalData.MsgID, nil
+}
+
+// ParseSNMPv3Report parses the report answering the discovery with msgID
+func ParseSNMPv3Report(buf []byte, msgID int32) (*SNMPEngine, error) {
+	var m snmpV3Message
+	if _, err := asn1.Unmarshal(buf, &m); err != nil {
+		return nil, fmt.Errorf("invalid snmpv3 message: %w", err)
+	}
+	if m.Version != snmpVersion3 {
+		return nil, fmt.Errorf("message is not SNMPv3 but version %d", m.Version)
+	}
+	if m.GlobalData.MsgID != msgID {
+		return nil, fmt.Errorf("response does not belong to the request")
+	}
+	if m.GlobalData.SecurityModel != snmpSecurityUSM {
+		return nil, fmt.Errorf("unsupported security model %d", m.GlobalData.SecurityModel)
+	}
+	var scoped struct {
+		ContextEngineID []byte
+		ContextName     []byte
+		PDU             asn1.RawValue
+	}
+	if _, err := asn1.Unmarshal(m.Data.FullBytes, &scoped); err != nil {
+		return nil, fmt.Errorf("invalid snmpv3 scoped pdu: %w", err)
+	}
+	if scoped.PDU.Class != asn1.ClassContextSpecific || scoped.PDU.Tag != snmpReport {
+		return nil, fmt.Errorf("message is not a report but pdu %d", scoped.PDU.Tag)
+	}
+	var usm snmpUSMParameters
+	if _, err := asn1.Unmarshal(m.SecurityParameters, &usm); err != nil {
+		return nil, fmt.Errorf("invalid usm parameters: %w", err)
+	}
+	if len(usm.EngineID) == 0 {
+		return nil, fmt.Errorf("empty engine id")
+	}
+
+	engine := &SNMPEngine{
+		ID:    usm.EngineID,
+		Boots: usm.Boots,
+		Time:  time.Duration(usm.Time) * time.Second,
+	}
+	engine.Enterprise, engine.Data = parseSNMPEngineID(usm.EngineID)
+	engine.Vendor = snmpEnterprises[engine.Enterprise]
+	return engine, nil
+}
+
+// parseSNMPEngineID returns the enterprise number and the decoded data of
+// an engine ID in the format of RFC 3411 or the older format of 12 bytes
+func parseSNMPEngineID(id []byte) (uint32, string) {
+	if len(id) < 5 {
+		return 0, ""
+	}
+	enterprise := binary.BigEndian.Uint32(id[0:4])
+	if enterprise&0x80000000 == 0 {
+		return enterprise, hex.EncodeToString(id[4:])
+	}
+	enterprise &^= 0x80000000
+	data := id[5:]
+	switch id[4] {
+	case 1, 2:
+		if ip, ok := netip.AddrFromSlice(data); ok {
+			return enterprise, "IP " + ip.String()
+		}
+	case 3:
+		if len(data) == 6 {
+			return enterprise, "MAC " + net.HardwareAddr(data).String()
+		}
+	case 4:
+		return enterprise, "text " + snmpString(data)
+	}
+	return enterprise, hex.EncodeToString(data)
+}
+
+// String returns the engine ID with its vendor, the boots and the uptime
+func (e SNMPEngine) String() string {
+	vendor := e.Vendor
+	if vendor == "" {
+		vendor = fmt.Sprintf("enterprise %d", e.Enterprise)
+	}
+	if e.Data != "" {
+		vendor += ", " + e.Data
+	}
+	seconds := int64(e.Time / time.Second)
+	return fmt.Sprintf("engine ID %x (%s), boots %d, uptime %dd %02d:%02d:%02d", e.ID, vendor, e.Boots, seconds/86400, seconds/3600%24, seconds/60%60, seconds%60)
+}
@@ -0,0 +1,148 @@
+package helper
+
+import (
+	"encoding/asn1"
+	"testing"
+)
+
+func snmpTestBinding(oid asn1.ObjectIdentifier, value []byte) []byte {
+	o, _ := asn1.Marshal(oid)
+	return derSequence(o, value)
+}
+
+func snmpTestResponse(requestID int32, errorStatus, errorIndex int64, bindings ...[]byte) []byte {
+	pdu := derTLV(0xa2, concat(derInteger(int64(requestID)), derInteger(errorStatus), derInteger(errorIndex), derSequence(bindings...)))
+	return derSequence(derInteger(1), derTLV(0x04, []byte("public")), pdu)
+}
+
+func TestSNMPGetRequest(t *testing.T) {
+	t.Parallel()
+	req := SNMPGetRequest("public", 1234, SNMPSysDescr, SNMPSysName)
+	id, err := SNMPRequestID(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if id != 1234 {
+		t.Errorf("unexpected request id %d", id)
+	}
+	// a GetRequest is no response
+	if _, err := ParseSNMPResponse(req, 1234); err == nil {
+		t.Errorf("expected an error on parsing a request")
+	}
+}
+
+func TestParseSNMPResponse(t *testing.T) {
+	t.Parallel()
+	uptime, _ := asn1.Marshal(asn1.ObjectIdentifier{1, 3, 6, 1, 2, 1, 1, 3, 0})
+	resp := snmpTestResponse(42, 0, 0,
+		snmpTestBinding(SNMPSysDescr, derTLV(0x04, []byte("Cisco IOS Software, C2960 Software"))),
+		snmpTestBinding(SNMPSysName, derTLV(0x04, []byte("switch01.corp.local"))),
+		derSequence(uptime, derTLV(0x43, []byte{0x01, 0x00, 0x00, 0x00})),
+		snmpTestBinding(asn1.ObjectIdentifier{1, 3, 6, 1, 2, 1, 2, 2, 1, 6, 1}, derTLV(0x04, []byte{0x00, 0x1b, 0x54, 0xaa, 0xbb, 0xcc})),
+		snmpTestBinding(asn1.ObjectIdentifier{1, 3, 6, 1, 2, 1, 1, 6, 0}, derTLV(0x80, nil)),
+	)
+	msg, err := ParseSNMPResponse(resp, 42)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if msg.Community != "public" || msg.Version != 1 || msg.ErrorStatus != 0 || len(msg.Bindings) != 5 {
+		t.Fatalf("unexpected response %+v", msg)
+	}
+	if name, ok := msg.Value(SNMPSysName); !ok || name != `"switch01.corp.local"` {
+		t.Errorf("unexpected sysName %s", name)
+	}
+	expected := `sysDescr "Cisco IOS Software, C2960 Software", sysName "switch01.corp.local", 1.3.6.1.2.1.1.3.0 16777216 (1d 22:36:12), 1.3.6.1.2.1.2.2.1.6.1 001b54aabbcc, 1.3.6.1.2.1.1.6.0 noSuchObject`
+	if s := msg.String(); s != expected {
+		t.Errorf("expected %s but got %s", expected, s)
+	}
+
+	if _, err := ParseSNMPResponse(resp, 43); err == nil {
+		t.Errorf("expected an error for a different request id")
+	}
+	if _, err := ParseSNMPResponse(resp[:len(resp)-3], 42); err == nil {
+		t.Errorf("expected an error for a truncated response")
+	}
+}
+
+func TestParseSNMPResponseError(t *testing.T) {
+	t.Parallel()
+	null, _ := asn1.Marshal(asn1.RawValue{Tag: asn1.TagNull})
+	resp := snmpTestResponse(7, 2, 1, snmpTestBinding(SNMPSysDescr, null))
+	msg, err := ParseSNMPResponse(resp, 7)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s := msg.String(); s != "error noSuchName at binding 1" {
+		t.Errorf("unexpected response %s", s)
+	}
+	if _, ok := msg.Value(SNMPSysDescr); !ok {
+		t.Errorf("expected the copy of the request binding")
+	}
+}
+
+func snmpTestReport(msgID int32, engineID []byte, boots, engineTime int64) []byte {
+	usm := derSequence(derTLV(0x04, engineID), derInteger(boots), derInteger(engineTime), derTLV(0x04, nil), derTLV(0x04, nil), derTLV(0x04, nil))
+	unknownEngineIDs, _ := asn1.Marshal(asn1.ObjectIdentifier{1, 3, 6, 1, 6, 3, 15, 1, 1, 4, 0})
+	pdu := derTLV(0xa8, concat(derInteger(int64(msgID)), derInteger(0), derInteger(0), derSequence(derSequence(unknownEngineIDs, derTLV(0x41, []byte{0x05})))))
+	return derSequence(
+		derInteger(3),
+		derSequence(derInteger(int64(msgID)), derInteger(65507), derTLV(0x04, []byte{0x00}), derInteger(3)),
+		derTLV(0x04, usm),
+		derSequence(derTLV(0x04, engineID), derTLV(0x04, nil), pdu),
+	)
+}
+
+func TestSNMPv3Discovery(t *testing.T) {
+	t.Parallel()
+	req := SNMPv3Discovery(99)
+	id, err := SNMPv3MessageID(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if id != 99 {
+		t.Errorf("unexpected message id %d", id)
+	}
+	// the request has no engine ID and is no report
+	if _, err := ParseSNMPv3Report(req, 99); err == nil {
+		t.Errorf("expected an error on parsing a request")
+	}
+}
+
+func TestParseSNMPv3Report(t *testing.T) {
+	t.Parallel()
+	// Cisco engine ID with the MAC address format
+	engineID := []byte{0x80, 0x00, 0x00, 0x09, 0x03, 0x00, 0x1b, 0x54, 0xaa, 0xbb, 0xcc}
+	engine, err := ParseSNMPv3Report(snmpTestReport(99, engineID, 12, 97262), 99)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := "engine ID 8000000903001b54aabbcc (Cisco, MAC 00:1b:54:aa:bb:cc), boots 12, uptime 1d 03:01:02"
+	if s := engine.String(); s != expected {
+		t.Errorf("expected %s but got %s", expected, s)
+	}
+	if _, err := ParseSNMPv3Report(snmpTestReport(99, engineID, 12, 97262), 98); err == nil {
+		t.Errorf("expected an error for a different message id")
+	}
+}
+
+func TestParseSNMPEngineID(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		id         []byte
+		enterprise uint32
+		data       string
+	}{
+		{[]byte{0x80, 0x00, 0x1f, 0x88, 0x80, 0x0e, 0x4b, 0x1a, 0x3c}, 8072, "0e4b1a3c"},
+		{[]byte{0x80, 0x00, 0x0a, 0x4c, 0x01, 10, 0, 0, 1}, 2636, "IP 10.0.0.1"},
+		{[]byte{0x80, 0x00, 0x00, 0x09, 0x04, 'r', 't', 'r'}, 9, `text "rtr"`},
+		// format of RFC 1910 with 12 bytes
+		{[]byte{0x00, 0x00, 0x00, 0x09, 0x0a, 0x00, 0x00, 0x01, 0x00, 0x00, 0x00, 0x00}, 9, "0a00000100000000"},
+		{[]byte{0x80}, 0, ""},
+	}
+	for _, tt := range tests {
+		enterprise, data := parseSNMPEngineID(tt.id)
+		if enterprise != tt.enterprise || data != tt.data {
+			t.Errorf("%x: expected %d %q, got %d %q", tt.id, tt.enterprise, tt.data, enterprise, data)
+		}
+	}
+}
@@ -0,0 +1,166 @@
+package helper
+
+import (
+	"encoding/binary"
+	"fmt"
+	"net/netip"
+	"strings"
+)
+
+// SOCKS5 address types from https://datatracker.ietf.org/doc/html/rfc1928#section-5
+const (
+	SOCKSAddressIPv4   byte = 0x01
+	SOCKSAddressDomain byte = 0x03
+	SOCKSAddressIPv6   byte = 0x04
+)
+
+// SOCKSAddress is the destination of a SOCKS5 request or UDP datagram.
+// Address is the IP address or the domain name depending on Type
+type SOCKSAddress struct {
+	Type    byte
+	Address []byte
+	Port    uint16
+}
+
+// String returns the address in the format host:port
+func (a SOCKSAddress) String() string {
+	if a.Type == SOCKSAddressDomain {
+		return fmt.Sprintf("%s:%d", a.Address, a.Port)
+	}
+	ip, _ := netip.AddrFromSlice(a.Address)
+	return netip.AddrPortFrom(ip, a.Port).String()
+}
+
+// SOCKSAddressLength returns the length of the address at the start of
+// buf including the type and the port. The length of a domain name is
+// only known once its length byte was read, so buf needs at least two bytes
+func SOCKSAddressLength(buf []byte) (int, error) {
+	if len(buf) < 2 {
+		return 0, fmt.Errorf("address too short")
+	}
+	switch buf[0] {
+	case SOCKSAddressIPv4:
+		return 1 + 4 + 2, nil
+	case SOCKSAddressIPv6:
+		return 1 + 16 + 2, nil
+	case SOCKSAddressDomain:
+		return 1 + 1 + int(buf[1]) + 2, nil
+	default:
+		return 0, fmt.Errorf("address type %#x not supported", buf[0])
+	}
+}
+
+// ParseSOCKSAddress parses the address at the start of buf and returns the
+// rest of buf
+func ParseSOCKSAddress(buf []byte) (SOCKSAddress, []byte, error) {
+	length, err := SOCKSAddressLength(buf)
+	if err != nil {
+		return SOCKSAddress{}, nil, err
+	}
+	if len(buf) < length {
+		return SOCKSAddress{}, nil, fmt.Errorf("address needs %d bytes but got %d", length, len(buf))
+	}
+	addr := SOCKSAddress{
+		Type:    buf[0],
+		Address: buf[1 : length-2],
+		Port:    binary.BigEndian.Uint16(buf[length-2 : length]),
+	}
+	if addr.Type == SOCKSAddressDomain {
+		addr.Address = addr.Address[1:]
+		if len(addr.Address) == 0 {
+			return SOCKSAddress{}, nil, fmt.Errorf("empty domain name")
+		}
+	}
+	return addr, buf[length:], nil
+}
+
+// socksAddress encodes the IP address and port
+func socksAddress(addr netip.AddrPort) []byte {
+	ip := addr.Addr().Unmap()
+	buf := []byte{SOCKSAddressIPv4}
+	if ip.Is6() {
+		buf[0] = SOCKSAddressIPv6
+	}
+	buf = append(buf, ip.AsSlice()...)
+	return append(buf, PutUint16(addr.Port())...)
+}
+
+// SOCKSReply builds the reply to a request with the bound address
+func SOCKSReply(reply byte, bound netip.AddrPort) []byte {
+	if !bound.IsValid() {
+		bound = netip.AddrPortFrom(netip.IPv4Unspecified(), 0)
+	}
+	return append([]byte{0x05, reply, 0x00}, socksAddress(bound)...)
+}
+
+// ParseSOCKSDatagram parses a UDP datagram of an association and returns
+// its destination and payload. Fragments are not supported as no common
+// client sends them
+func ParseSOCKSDatagram(buf []byte) (SOCKSAddress, []byte, error) {
+	if len(buf) < 4 {
+		return SOCKSAddress{}, nil, fmt.Errorf("datagram too short")
+	}
+	if buf[2] != 0 {
+		return SOCKSAddress{}, nil, fmt.Errorf("fragmented datagrams are not supported")
+	}
+	return ParseSOCKSAddress(buf[3:])
+}
+
+// SOCKSDatagram builds a UDP datagram of an association with the data
+// received from the source
+func SOCKSDatagram(source netip.AddrPort, data []byte) []byte {
+	buf := append([]byte{0x00, 0x00, 0x00}, socksAddress(source)...)
+	return append(buf, data...)
+}
+
+// ParseSOCKSUsers parses users in the format username:password. The
+// password may contain colons
+func ParseSOCKSUsers(values []string) (map[string]string, error) {
+	users := make(map[string]string)
+	for _, value := range values {
+		username, password, ok := strings.Cut(value, ":")
+		if !ok {
+			return nil, fmt.Errorf("user %q must be in the format username:password", value)
+		}
+		// RFC 1929 encodes both lengths in a single byte
+		if username == "" || len(username) > 255 {
+			return nil, fmt.Errorf("invalid username %q", username)
+		}
+		if password == "" || len(password) > 255 {
+			return nil, fmt.Errorf("invalid password for user %q", username)
+		}
+		if _, ok := users[username]; ok {
+			return nil, fmt.Errorf("user %q is specified multiple times", username)
+		}
+		users[username] = password
+	}
+	return users, nil
+}
+
+// ParseAllowList parses addresses and ranges in CIDR notation
+func ParseAllowList(values []string) ([]netip.Prefix, error) {
+	var allowed []netip.Prefix
+	for _, value := range values {
+		prefix, err := parseScopePrefix(strings.TrimSpace(value))
+		if err != nil {
+			return nil, err
+		}
+		allowed = append(allowed, prefix)
+	}
+	return allowed, nil
+}
+
+// InAllowList returns true if the address is in one of the ranges. All
+// addresses are allowed if the list is empty
+func InAllowList(allow []netip.Prefix, ip netip.Addr) bool {
+	if len(allow) == 0 {
+		return true
+	}
+	ip = ip.Unmap()
+	for _, prefix := range allow {
+		if prefix.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
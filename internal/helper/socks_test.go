@@ -0,0 +1,135 @@
+package helper
+
+import (
+	"bytes"
+	"net/netip"
+	"strings"
+	"testing"
+)
+
+func TestParseSOCKSDatagram(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		name     string
+		buf      []byte
+		expected string
+		data     []byte
+	}{
+		{"ipv4", []byte{0, 0, 0, 0x01, 10, 0, 0, 1, 0x00, 0x35, 'a', 'b'}, "10.0.0.1:53", []byte("ab")},
+		{"ipv6", append([]byte{0, 0, 0, 0x04}, append(netip.MustParseAddr("fd00::1").AsSlice(), 0x00, 0xa1, 'x')...), "[fd00::1]:161", []byte("x")},
+		{"domain", []byte{0, 0, 0, 0x03, 4, 'h', 'o', 's', 't', 0x01, 0xbb}, "host:443", nil},
+	}
+	for _, tt := range tests {
+		addr, data, err := ParseSOCKSDatagram(tt.buf)
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		if addr.String() != tt.expected {
+			t.Errorf("%s: expected %s but got %s", tt.name, tt.expected, addr)
+		}
+		if !bytes.Equal(data, tt.data) {
+			t.Errorf("%s: expected data %q but got %q", tt.name, tt.data, data)
+		}
+	}
+}
+
+func TestParseSOCKSDatagramFail(t *testing.T) {
+	t.Parallel()
+	for _, buf := range [][]byte{
+		{0, 0, 0},
+		{0, 0, 1, 0x01, 10, 0, 0, 1, 0x00, 0x35},
+		{0, 0, 0, 0x02, 10, 0, 0, 1, 0x00, 0x35},
+		{0, 0, 0, 0x01, 10, 0, 0},
+		{0, 0, 0, 0x03, 0, 0x00, 0x35},
+		{0, 0, 0, 0x03, 10, 'h', 'o', 's', 't'},
+	} {
+		if _, _, err := ParseSOCKSDatagram(buf); err == nil {
+			t.Errorf("expected an error on %x", buf)
+		}
+	}
+}
+
+func TestSOCKSDatagram(t *testing.T) {
+	t.Parallel()
+	source := netip.MustParseAddrPort("[::ffff:10.0.0.1]:53")
+	buf := SOCKSDatagram(source, []byte("answer"))
+	expected := []byte{0, 0, 0, 0x01, 10, 0, 0, 1, 0x00, 0x35, 'a', 'n', 's', 'w', 'e', 'r'}
+	if !bytes.Equal(buf, expected) {
+		t.Errorf("expected %x but got %x", expected, buf)
+	}
+	addr, data, err := ParseSOCKSDatagram(SOCKSDatagram(netip.MustParseAddrPort("[fd00::2]:5060"), []byte("sip")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if addr.String() != "[fd00::2]:5060" || string(data) != "sip" {
+		t.Errorf("unexpected datagram to %s with %q", addr, data)
+	}
+}
+
+func TestSOCKSReply(t *testing.T) {
+	t.Parallel()
+	reply := SOCKSReply(0x00, netip.MustParseAddrPort("127.0.0.1:40000"))
+	expected := []byte{0x05, 0x00, 0x00, 0x01, 127, 0, 0, 1, 0x9c, 0x40}
+	if !bytes.Equal(reply, expected) {
+		t.Errorf("expected %x but got %x", expected, reply)
+	}
+	reply = SOCKSReply(0x07, netip.AddrPort{})
+	expected = []byte{0x05, 0x07, 0x00, 0x01, 0, 0, 0, 0, 0, 0}
+	if !bytes.Equal(reply, expected) {
+		t.Errorf("expected %x but got %x", expected, reply)
+	}
+}
+
+func TestParseSOCKSUsers(t *testing.T) {
+	t.Parallel()
+	users, err := ParseSOCKSUsers([]string{"alice:secret", "bob:with:colon"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(users) != 2 || users["alice"] != "secret" || users["bob"] != "with:colon" {
+		t.Errorf("unexpected users %v", users)
+	}
+	for _, value := range []string{"alice", ":secret", "alice:", strings.Repeat("a", 256) + ":secret"} {
+		if _, err := ParseSOCKSUsers([]string{value}); err == nil {
+			t.Errorf("expected an error on %q", value)
+		}
+	}
+	if _, err := ParseSOCKSUsers([]string{"alice:a", "alice:b"}); err == nil {
+		t.Errorf("expected an error on a duplicate user")
+	}
+}
+
+func TestParseAllowList(t *testing.T) {
+	t.Parallel()
+	allowed, err := ParseAllowList([]string{"10.8.0.0/24", " 192.168.1.5", "fd00::1/64"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []string{"10.8.0.0/24", "192.168.1.5/32", "fd00::/64"}
+	if len(allowed) != len(expected) {
+		t.Fatalf("expected %v but got %v", expected, allowed)
+	}
+	for i, prefix := range allowed {
+		if prefix.String() != expected[i] {
+			t.Errorf("expected %s but got %s", expected[i], prefix)
+		}
+	}
+	if _, err := ParseAllowList([]string{"10.8.0.0/33"}); err == nil {
+		t.Errorf("expected an error on an invalid range")
+	}
+}
+
+func TestInAllowList(t *testing.T) {
+	t.Parallel()
+	allow := []netip.Prefix{netip.MustParsePrefix("10.8.0.0/24")}
+	if !InAllowList(allow, netip.MustParseAddr("::ffff:10.8.0.5")) {
+		t.Errorf("expected a mapped address in the range to be allowed")
+	}
+	if InAllowList(allow, netip.MustParseAddr("10.8.1.5")) {
+		t.Errorf("expected an address out of the range to be denied")
+	}
+	if !InAllowList(nil, netip.MustParseAddr("10.8.1.5")) {
+		t.Errorf("expected an empty list to allow everything")
+	}
+}
@@ -0,0 +1,46 @@
+package helper
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"net/http"
+)
+
+// SSDPMulticast is the multicast address and port of SSDP
+const SSDPMulticast = "239.255.255.250:1900"
+
+// SSDPResponse holds the headers of a M-SEARCH response identifying a
+// UPnP device
+type SSDPResponse struct {
+	// Location is the URL of the device description
+	Location string
+	// Server is the operating system and UPnP stack of the device
+	Server string
+	// ST is the search target the response is for
+	ST string
+	// USN is the unique service name of the device or service
+	USN string
+}
+
+// SSDPSearch builds a M-SEARCH request (UPnP device architecture 1.1
+// section 1.3.2) for the search target st like ssdp:all. host is the
+// multicast address or the unicast address and port of the device
+func SSDPSearch(host, st string) []byte {
+	return []byte(fmt.Sprintf("M-SEARCH * HTTP/1.1\r\nHOST: %s\r\nMAN: \"ssdp:discover\"\r\nMX: 1\r\nST: %s\r\n\r\n", host, st))
+}
+
+// ParseSSDPResponse parses the response to a M-SEARCH request
+func ParseSSDPResponse(data []byte) (*SSDPResponse, error) {
+	resp, err := http.ReadResponse(bufio.NewReader(bytes.NewReader(data)), nil)
+	if err != nil {
+		return nil, fmt.Errorf("invalid ssdp response: %w", err)
+	}
+	resp.Body.Close()
+	return &SSDPResponse{
+		Location: resp.Header.Get("Location"),
+		Server:   resp.Header.Get("Server"),
+		ST:       resp.Header.Get("St"),
+		USN:      resp.Header.Get("Usn"),
+	}, nil
+}
@@ -0,0 +1,32 @@
+package helper
+
+import "testing"
+
+func TestSSDPSearch(t *testing.T) {
+	t.Parallel()
+	expected := "M-SEARCH * HTTP/1.1\r\nHOST: 10.0.0.1:1900\r\nMAN: \"ssdp:discover\"\r\nMX: 1\r\nST: ssdp:all\r\n\r\n"
+	if s := string(SSDPSearch("10.0.0.1:1900", "ssdp:all")); s != expected {
+		t.Errorf("expected %q, got %q", expected, s)
+	}
+}
+
+func TestParseSSDPResponse(t *testing.T) {
+	t.Parallel()
+	data := "HTTP/1.1 200 OK\r\nCACHE-CONTROL: max-age=1800\r\nLOCATION: http://10.0.0.1:49152/rootDesc.xml\r\nSERVER: Linux/5.4 UPnP/1.1 MiniUPnPd/2.2\r\nST: upnp:rootdevice\r\nUSN: uuid:1234::upnp:rootdevice\r\n\r\n"
+	resp, err := ParseSSDPResponse([]byte(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := SSDPResponse{
+		Location: "http://10.0.0.1:49152/rootDesc.xml",
+		Server:   "Linux/5.4 UPnP/1.1 MiniUPnPd/2.2",
+		ST:       "upnp:rootdevice",
+		USN:      "uuid:1234::upnp:rootdevice",
+	}
+	if *resp != expected {
+		t.Errorf("expected %+v, got %+v", expected, *resp)
+	}
+	if _, err := ParseSSDPResponse([]byte("garbage")); err == nil {
+		t.Error("expected an error for an invalid response")
+	}
+}
@@ -0,0 +1,77 @@
+package helper
+
+import (
+	"encoding/binary"
+	"fmt"
+	"strings"
+)
+
+// TFTP opcodes (RFC 1350)
+const (
+	TFTPOpRRQ   uint16 = 1
+	TFTPOpData  uint16 = 3
+	TFTPOpError uint16 = 5
+)
+
+// TFTP error codes used by stunner
+const (
+	TFTPErrorNotDefined uint16 = 0
+	TFTPErrorNotFound   uint16 = 1
+)
+
+// tftpBlockSize is the size of a data block without the blksize option
+const tftpBlockSize = 512
+
+// TFTPPacket holds a parsed DATA or ERROR packet
+type TFTPPacket struct {
+	Opcode uint16
+	// Block and Data are set for DATA packets
+	Block uint16
+	Data  []byte
+	// ErrorCode and ErrorMessage are set for ERROR packets
+	ErrorCode    uint16
+	ErrorMessage string
+}
+
+// TFTPReadRequest builds a read request for the file in binary mode
+func TFTPReadRequest(filename string) []byte {
+	var tftp []byte
+	tftp = append(tftp, PutUint16(TFTPOpRRQ)...)
+	tftp = append(tftp, []byte(filename)...)
+	tftp = append(tftp, 0x00)
+	tftp = append(tftp, []byte("octet")...)
+	return append(tftp, 0x00)
+}
+
+// TFTPError builds an error packet, used to abort a transfer
+func TFTPError(code uint16, message string) []byte {
+	var tftp []byte
+	tftp = append(tftp, PutUint16(TFTPOpError)...)
+	tftp = append(tftp, PutUint16(code)...)
+	tftp = append(tftp, []byte(message)...)
+	return append(tftp, 0x00)
+}
+
+// ParseTFTPPacket parses the response to a read request
+func ParseTFTPPacket(buf []byte) (*TFTPPacket, error) {
+	if len(buf) < 4 {
+		return nil, fmt.Errorf("invalid tftp packet length %d", len(buf))
+	}
+	p := &TFTPPacket{
+		Opcode: binary.BigEndian.Uint16(buf[0:2]),
+	}
+	switch p.Opcode {
+	case TFTPOpData:
+		if len(buf)-4 > tftpBlockSize {
+			return nil, fmt.Errorf("data block of %d bytes is too large", len(buf)-4)
+		}
+		p.Block = binary.BigEndian.Uint16(buf[2:4])
+		p.Data = buf[4:]
+	case TFTPOpError:
+		p.ErrorCode = binary.BigEndian.Uint16(buf[2:4])
+		p.ErrorMessage = strings.TrimRight(string(buf[4:]), "\x00")
+	default:
+		return nil, fmt.Errorf("unexpected tftp opcode %d", p.Opcode)
+	}
+	return p, nil
+}
@@ -0,0 +1,43 @@
+package helper
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestTFTPReadRequest(t *testing.T) {
+	t.Parallel()
+	expected := []byte("\x00\x01startup-config\x00octet\x00")
+	if r := TFTPReadRequest("startup-config"); !bytes.Equal(r, expected) {
+		t.Errorf("expected %q, got %q", expected, r)
+	}
+}
+
+func TestParseTFTPPacket(t *testing.T) {
+	t.Parallel()
+	p, err := ParseTFTPPacket([]byte("\x00\x03\x00\x01hostname router"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.Opcode != TFTPOpData || p.Block != 1 || string(p.Data) != "hostname router" {
+		t.Errorf("unexpected packet %+v", p)
+	}
+
+	p, err = ParseTFTPPacket(TFTPError(TFTPErrorNotFound, "File not found"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.Opcode != TFTPOpError || p.ErrorCode != TFTPErrorNotFound || p.ErrorMessage != "File not found" {
+		t.Errorf("unexpected packet %+v", p)
+	}
+
+	for _, invalid := range [][]byte{
+		{0x00, 0x03},
+		TFTPReadRequest("x"),
+		append([]byte{0x00, 0x03, 0x00, 0x01}, make([]byte, 513)...),
+	} {
+		if _, err := ParseTFTPPacket(invalid); err == nil {
+			t.Errorf("expected an error for %q", invalid)
+		}
+	}
+}
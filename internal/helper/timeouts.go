@@ -0,0 +1,114 @@
+package helper
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Timeouts overrides the global timeout for single phases of a scan so
+// slow services don't force a long timeout on everything else
+type Timeouts struct {
+	// Setup is used for the connection to the TURN server, the allocation
+	// and the permission
+	Setup time.Duration
+	// ChannelBind is used for the ChannelBind request
+	ChannelBind time.Duration
+	// SNMP, SNMPv3, DNS, NTP, NetBIOS, MDNS, SSDP, TFTP, SIP, IKE, CLDAP,
+	// Memcached, RPC and WSD are used for the probes of the UDP scans
+	SNMP      time.Duration
+	SNMPv3    time.Duration
+	DNS       time.Duration
+	NTP       time.Duration
+	NetBIOS   time.Duration
+	MDNS      time.Duration
+	SSDP      time.Duration
+	TFTP      time.Duration
+	SIP       time.Duration
+	IKE       time.Duration
+	CLDAP     time.Duration
+	Memcached time.Duration
+	RPC       time.Duration
+	WSD       time.Duration
+	// TCP is used for reading and writing on relayed TCP connections
+	TCP time.Duration
+}
+
+func (t *Timeouts) fields() map[string]*time.Duration {
+	return map[string]*time.Duration{
+		"setup":       &t.Setup,
+		"channelbind": &t.ChannelBind,
+		"snmp":        &t.SNMP,
+		"snmpv3":      &t.SNMPv3,
+		"dns":         &t.DNS,
+		"ntp":         &t.NTP,
+		"netbios":     &t.NetBIOS,
+		"mdns":        &t.MDNS,
+		"ssdp":        &t.SSDP,
+		"tftp":        &t.TFTP,
+		"sip":         &t.SIP,
+		"ike":         &t.IKE,
+		"cldap":       &t.CLDAP,
+		"memcached":   &t.Memcached,
+		"rpc":         &t.RPC,
+		"wsd":         &t.WSD,
+		"tcp":         &t.TCP,
+	}
+}
+
+// TimeoutPhases returns the names of all phases that can be overridden
+func TimeoutPhases() []string {
+	var t Timeouts
+	var names []string
+	for name := range t.fields() {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// ParseTimeouts parses overrides in the format phase=duration, for
+// example "snmp=5s"
+func ParseTimeouts(specs []string) (Timeouts, error) {
+	var t Timeouts
+	fields := t.fields()
+	for _, spec := range specs {
+		name, value, ok := strings.Cut(spec, "=")
+		if !ok {
+			return Timeouts{}, fmt.Errorf("invalid timeout %q, expected phase=duration", spec)
+		}
+		field, ok := fields[strings.ToLower(strings.TrimSpace(name))]
+		if !ok {
+			return Timeouts{}, fmt.Errorf("invalid timeout phase %q. Supported values: %s", name, strings.Join(TimeoutPhases(), ", "))
+		}
+		d, err := time.ParseDuration(strings.TrimSpace(value))
+		if err != nil {
+			return Timeouts{}, fmt.Errorf("invalid duration in %q: %w", spec, err)
+		}
+		if d <= 0 {
+			return Timeouts{}, fmt.Errorf("timeout in %q needs to be positive", spec)
+		}
+		*field = d
+	}
+	return t, nil
+}
+
+// Phase returns the timeout of the phase with the given name
+func (t Timeouts) Phase(name string) (time.Duration, bool) {
+	field, ok := t.fields()[name]
+	if !ok {
+		return 0, false
+	}
+	return *field, true
+}
+
+// WithDefault returns the timeouts with all unset phases set to d
+func (t Timeouts) WithDefault(d time.Duration) Timeouts {
+	for _, field := range t.fields() {
+		if *field == 0 {
+			*field = d
+		}
+	}
+	return t
+}
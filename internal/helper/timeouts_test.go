@@ -0,0 +1,57 @@
+package helper
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseTimeouts(t *testing.T) {
+	t.Parallel()
+	timeouts, err := ParseTimeouts([]string{"snmp=5s", "DNS=300ms", " channelbind = 2s"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := Timeouts{
+		ChannelBind: 2 * time.Second,
+		SNMP:        5 * time.Second,
+		DNS:         300 * time.Millisecond,
+	}
+	if timeouts != expected {
+		t.Errorf("expected %+v but got %+v", expected, timeouts)
+	}
+
+	timeouts = timeouts.WithDefault(time.Second)
+	expected.Setup = time.Second
+	expected.SNMPv3 = time.Second
+	expected.NTP = time.Second
+	expected.NetBIOS = time.Second
+	expected.MDNS = time.Second
+	expected.SSDP = time.Second
+	expected.TFTP = time.Second
+	expected.SIP = time.Second
+	expected.IKE = time.Second
+	expected.CLDAP = time.Second
+	expected.Memcached = time.Second
+	expected.RPC = time.Second
+	expected.WSD = time.Second
+	expected.TCP = time.Second
+	if timeouts != expected {
+		t.Errorf("expected %+v but got %+v", expected, timeouts)
+	}
+
+	if d, ok := timeouts.Phase("snmp"); !ok || d != 5*time.Second {
+		t.Errorf("unexpected snmp phase %s", d)
+	}
+	if _, ok := timeouts.Phase("http"); ok {
+		t.Errorf("expected no http phase")
+	}
+}
+
+func TestParseTimeoutsFail(t *testing.T) {
+	t.Parallel()
+	for _, spec := range []string{"snmp", "http=1s", "dns=abc", "dns=0s", "dns=-1s"} {
+		if _, err := ParseTimeouts([]string{spec}); err == nil {
+			t.Errorf("expected an error on %q", spec)
+		}
+	}
+}
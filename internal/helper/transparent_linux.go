@@ -0,0 +1,99 @@
+//go:build linux
+
+package helper
+
+import (
+	"context"
+	"encoding/binary"
+	"net"
+	"net/netip"
+	"syscall"
+	"unsafe"
+)
+
+const (
+	// soOriginalDst is SO_ORIGINAL_DST of netfilter. IP6T_SO_ORIGINAL_DST
+	// has the same value
+	soOriginalDst = 80
+	// ipv6Transparent is IPV6_TRANSPARENT which is missing in syscall
+	ipv6Transparent = 75
+)
+
+// ListenTransparent listens on the TCP address for connections redirected
+// with iptables. IP_TRANSPARENT is set on the socket, so connections of
+// TPROXY rules are accepted as well. This needs CAP_NET_ADMIN, without it
+// only REDIRECT rules work and tproxy is false
+func ListenTransparent(address string) (listener net.Listener, tproxy bool, err error) {
+	tproxy = true
+	lc := net.ListenConfig{
+		Control: func(network, _ string, c syscall.RawConn) error {
+			return c.Control(func(fd uintptr) {
+				level, opt := syscall.SOL_IP, syscall.IP_TRANSPARENT
+				if network == "tcp6" {
+					level, opt = syscall.SOL_IPV6, ipv6Transparent
+				}
+				if err := syscall.SetsockoptInt(int(fd), level, opt, 1); err != nil {
+					tproxy = false
+				}
+			})
+		},
+	}
+	listener, err = lc.Listen(context.Background(), "tcp", address)
+	if err != nil {
+		return nil, false, err
+	}
+	return listener, tproxy, nil
+}
+
+// OriginalDestination returns the address a redirected connection was sent
+// to. Connections redirected with REDIRECT carry it in SO_ORIGINAL_DST,
+// connections of TPROXY rules keep it as their local address
+func OriginalDestination(conn net.Conn) (netip.AddrPort, error) {
+	local, err := netip.ParseAddrPort(conn.LocalAddr().String())
+	if err != nil {
+		return netip.AddrPort{}, err
+	}
+	local = netip.AddrPortFrom(local.Addr().Unmap(), local.Port())
+	tcpConn, ok := conn.(*net.TCPConn)
+	if !ok {
+		return local, nil
+	}
+	raw, err := tcpConn.SyscallConn()
+	if err != nil {
+		return netip.AddrPort{}, err
+	}
+	var dst netip.AddrPort
+	var sockErr error
+	if err := raw.Control(func(fd uintptr) {
+		dst, sockErr = originalDst(int(fd), local.Addr().Is4())
+	}); err != nil {
+		return netip.AddrPort{}, err
+	}
+	if sockErr != nil {
+		// no NAT entry, so the connection was not redirected by REDIRECT
+		return local, nil
+	}
+	return dst, nil
+}
+
+// originalDst reads SO_ORIGINAL_DST of the socket
+func originalDst(fd int, ipv4 bool) (netip.AddrPort, error) {
+	if ipv4 {
+		// the option returns a sockaddr_in which fits into the request
+		mreq, err := syscall.GetsockoptIPv6Mreq(fd, syscall.SOL_IP, soOriginalDst)
+		if err != nil {
+			return netip.AddrPort{}, err
+		}
+		raw := mreq.Multiaddr
+		ip := netip.AddrFrom4([4]byte{raw[4], raw[5], raw[6], raw[7]})
+		return netip.AddrPortFrom(ip, binary.BigEndian.Uint16(raw[2:4])), nil
+	}
+	// the option returns a sockaddr_in6 which is the start of the MTU info
+	info, err := syscall.GetsockoptIPv6MTUInfo(fd, syscall.SOL_IPV6, soOriginalDst)
+	if err != nil {
+		return netip.AddrPort{}, err
+	}
+	// the port is in network byte order
+	port := binary.BigEndian.Uint16((*[2]byte)(unsafe.Pointer(&info.Addr.Port))[:])
+	return netip.AddrPortFrom(netip.AddrFrom16(info.Addr.Addr).Unmap(), port), nil
+}
@@ -0,0 +1,37 @@
+//go:build linux
+
+package helper
+
+import (
+	"net"
+	"net/netip"
+	"testing"
+)
+
+func TestOriginalDestinationNotRedirected(t *testing.T) {
+	t.Parallel()
+	listener, _, err := ListenTransparent("127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	client, err := net.Dial("tcp", listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	conn, err := listener.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	// without a NAT entry the local address is the destination
+	dst, err := OriginalDestination(conn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := netip.MustParseAddrPort(listener.Addr().String()); dst != want {
+		t.Errorf("got %s, want %s", dst, want)
+	}
+}
@@ -0,0 +1,19 @@
+//go:build !linux
+
+package helper
+
+import (
+	"fmt"
+	"net"
+	"net/netip"
+)
+
+// ListenTransparent is only supported on linux
+func ListenTransparent(address string) (net.Listener, bool, error) {
+	return nil, false, fmt.Errorf("the transparent proxy is only supported on linux")
+}
+
+// OriginalDestination is only supported on linux
+func OriginalDestination(conn net.Conn) (netip.AddrPort, error) {
+	return netip.AddrPort{}, fmt.Errorf("the transparent proxy is only supported on linux")
+}
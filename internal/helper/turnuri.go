@@ -0,0 +1,129 @@
+package helper
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// TurnURI is a parsed STUN or TURN URI like turns:host:5349?transport=tcp
+// as defined in RFC 7064 and RFC 7065
+type TurnURI struct {
+	Host     string
+	Port     uint16
+	Protocol string
+	TLS      bool
+	// ExplicitTransport is set if the protocol was given with the
+	// transport parameter and not derived from the scheme
+	ExplicitTransport bool
+}
+
+// Server returns the server in the format host:port
+func (u TurnURI) Server() string {
+	return net.JoinHostPort(u.Host, strconv.Itoa(int(u.Port)))
+}
+
+// IsTurnURI returns true if s starts with one of the stun:, stuns:, turn:
+// or turns: schemes. A host named like a scheme followed by a port, for
+// example turn:3478, is no URI
+func IsTurnURI(s string) bool {
+	scheme, rest, ok := strings.Cut(s, ":")
+	if !ok {
+		return false
+	}
+	if _, err := strconv.ParseUint(rest, 10, 16); err == nil {
+		return false
+	}
+	switch strings.ToLower(scheme) {
+	case "stun", "stuns", "turn", "turns":
+		return true
+	}
+	return false
+}
+
+// ParseTurnURI parses a STUN or TURN URI. Without a port the default port
+// of the scheme is used, 3478 or 5349 for the secure variants. The
+// transport defaults to udp for turn: and stun: and to tcp for turns: and
+// stuns:
+func ParseTurnURI(s string) (*TurnURI, error) {
+	scheme, rest, ok := strings.Cut(s, ":")
+	if !ok || !IsTurnURI(s) {
+		return nil, fmt.Errorf("%q is no STUN or TURN URI", s)
+	}
+	scheme = strings.ToLower(scheme)
+	// some configs use the URL form turn://host
+	rest = strings.TrimPrefix(rest, "//")
+
+	u := &TurnURI{
+		Port:     3478,
+		Protocol: "udp",
+	}
+	if strings.HasSuffix(scheme, "s") {
+		u.TLS = true
+		u.Port = 5349
+		u.Protocol = "tcp"
+	}
+
+	hostport, query, hasQuery := strings.Cut(rest, "?")
+	if hasQuery {
+		if strings.HasPrefix(scheme, "stun") {
+			return nil, fmt.Errorf("invalid URI %q: STUN URIs have no parameters", s)
+		}
+		values, err := url.ParseQuery(query)
+		if err != nil {
+			return nil, fmt.Errorf("invalid parameters in URI %q: %w", s, err)
+		}
+		for key := range values {
+			if key != "transport" {
+				return nil, fmt.Errorf("invalid URI %q: unknown parameter %s", s, key)
+			}
+		}
+		switch transport := strings.ToLower(values.Get("transport")); transport {
+		case "udp", "tcp":
+			u.Protocol = transport
+			u.ExplicitTransport = true
+		default:
+			return nil, fmt.Errorf("invalid URI %q: unsupported transport %q", s, transport)
+		}
+	}
+
+	host := hostport
+	switch {
+	case strings.HasPrefix(hostport, "["):
+		end := strings.Index(hostport, "]")
+		if end < 0 {
+			return nil, fmt.Errorf("invalid URI %q: missing ] in IPv6 address", s)
+		}
+		host = hostport[1:end]
+		if port := hostport[end+1:]; port != "" {
+			if !strings.HasPrefix(port, ":") {
+				return nil, fmt.Errorf("invalid URI %q: unexpected %q after the IPv6 address", s, port)
+			}
+			if err := u.setPort(port[1:]); err != nil {
+				return nil, fmt.Errorf("invalid URI %q: %w", s, err)
+			}
+		}
+	case strings.Contains(hostport, ":"):
+		var port string
+		host, port, _ = strings.Cut(hostport, ":")
+		if err := u.setPort(port); err != nil {
+			return nil, fmt.Errorf("invalid URI %q: %w", s, err)
+		}
+	}
+	if host == "" {
+		return nil, fmt.Errorf("invalid URI %q: missing host", s)
+	}
+	u.Host = host
+	return u, nil
+}
+
+func (u *TurnURI) setPort(port string) error {
+	p, err := strconv.ParseUint(port, 10, 16)
+	if err != nil || p == 0 {
+		return fmt.Errorf("invalid port %q", port)
+	}
+	u.Port = uint16(p)
+	return nil
+}
@@ -0,0 +1,70 @@
+package helper
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParseTurnURI(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		uri      string
+		server   string
+		protocol string
+		tls      bool
+	}{
+		{"turn:turn.example.com", "turn.example.com:3478", "udp", false},
+		{"turn:turn.example.com:3479?transport=tcp", "turn.example.com:3479", "tcp", false},
+		{"turns:turn.example.com", "turn.example.com:5349", "tcp", true},
+		{"TURNS:turn.example.com:443?transport=udp", "turn.example.com:443", "udp", true},
+		{"turn:[2001:db8::1]:3478?transport=udp", "[2001:db8::1]:3478", "udp", false},
+		{"turns:[2001:db8::1]", "[2001:db8::1]:5349", "tcp", true},
+		{"stun:192.0.2.1", "192.0.2.1:3478", "udp", false},
+		{"turn://192.0.2.1:80", "192.0.2.1:80", "udp", false},
+	}
+	for _, tt := range tests {
+		u, err := ParseTurnURI(tt.uri)
+		if err != nil {
+			t.Errorf("%s: %v", tt.uri, err)
+			continue
+		}
+		if u.ExplicitTransport != strings.Contains(tt.uri, "transport=") {
+			t.Errorf("%s: unexpected ExplicitTransport %t", tt.uri, u.ExplicitTransport)
+		}
+		if u.Server() != tt.server || u.Protocol != tt.protocol || u.TLS != tt.tls {
+			t.Errorf("%s: expected %s %s %t, got %s %s %t", tt.uri, tt.server, tt.protocol, tt.tls, u.Server(), u.Protocol, u.TLS)
+		}
+	}
+
+	for _, uri := range []string{
+		"turn:",
+		"turn:host:0",
+		"turn:host:port",
+		"turn:host?transport=sctp",
+		"turn:host?foo=bar",
+		"stun:host?transport=tcp",
+		"turn:[2001:db8::1",
+		"http://host",
+	} {
+		if _, err := ParseTurnURI(uri); err == nil {
+			t.Errorf("expected an error on %s", uri)
+		}
+	}
+}
+
+func TestIsTurnURI(t *testing.T) {
+	t.Parallel()
+	for s, expected := range map[string]bool{
+		"turn:host":        true,
+		"turns:host:5349":  true,
+		"STUN:host":        true,
+		"host:3478":        false,
+		"turn:3478":        false,
+		"192.0.2.1:3478":   false,
+		"[2001:db8::1]:80": false,
+	} {
+		if x := IsTurnURI(s); x != expected {
+			t.Errorf("%s: expected %t, got %t", s, expected, x)
+		}
+	}
+}
@@ -0,0 +1,113 @@
+package helper
+
+import (
+	"strconv"
+	"strings"
+	"time"
+)
+
+// CommonTURNUsernames are usernames often configured as static credentials
+// in WebRTC deployments and in the default configs of media servers
+var CommonTURNUsernames = []string{
+	"turn", "turnuser", "turnserver", "stun", "coturn", "webrtc", "rtc",
+	"user", "username", "test", "testuser", "guest", "demo", "anonymous",
+	"admin", "root", "default", "public", "client", "media", "video",
+	"janus", "jitsi", "jvb", "kurento", "mediasoup", "livekit", "openvidu",
+	"bbb", "bigbluebutton", "nextcloud", "talk", "spreed", "matrix",
+	"synapse", "element", "asterisk", "freeswitch", "kamailio", "rocketchat",
+	"mattermost", "peer", "p2p",
+}
+
+// realmAffixes are combined with the words of the realm
+var realmAffixes = []string{"turn", "stun", "webrtc", "user", "rtc", "media"}
+
+// RealmUsernames returns username candidates derived from the realm, for
+// example example, example-turn, turnexample and turn@turn.example.com
+// for the realm turn.example.com. Realms with diacritics also result in
+// the candidates of their ASCII spelling
+func RealmUsernames(realm string) []string {
+	realm = strings.ToLower(strings.TrimSuffix(strings.TrimSpace(realm), "."))
+	if realm == "" {
+		return nil
+	}
+
+	labels := strings.Split(realm, ".")
+	words := []string{realm}
+	if len(labels) > 1 {
+		// the registered domain and all labels except the TLD
+		words = append(words, strings.Join(labels[len(labels)-2:], "."))
+		for i := len(labels) - 2; i >= 0; i-- {
+			words = append(words, labels[i])
+		}
+	}
+
+	var ret []string
+	for _, word := range words {
+		ret = append(ret, word)
+		if strings.Contains(word, ".") {
+			continue
+		}
+		for _, affix := range realmAffixes {
+			if affix == word {
+				continue
+			}
+			ret = append(ret,
+				word+affix, word+"-"+affix, word+"_"+affix,
+				affix+word, affix+"-"+word, affix+"_"+word,
+			)
+		}
+		ret = append(ret, word+"1", word+"123")
+	}
+	for _, affix := range realmAffixes {
+		ret = append(ret, affix+"@"+realm)
+	}
+	if !isASCII(realm) {
+		if folded := FoldASCII(realm); folded != realm {
+			ret = append(ret, RealmUsernames(folded)...)
+		}
+	}
+	return uniqueNonEmpty(ret)
+}
+
+// TimestampUsernames returns usernames in the format of the TURN REST API
+// used by coturn with use-auth-secret: the expiry as a unix timestamp,
+// the separator and the user id. Expiry timestamps between from and to are
+// generated in the given steps. An empty user id results in the bare
+// timestamp which is accepted by most implementations.
+func TimestampUsernames(users []string, from, to time.Time, step time.Duration, separator string) []string {
+	if step <= 0 || to.Before(from) {
+		return nil
+	}
+	if len(users) == 0 {
+		users = []string{""}
+	}
+	var ret []string
+	for t := from; !t.After(to); t = t.Add(step) {
+		ts := strconv.FormatInt(t.Unix(), 10)
+		for _, user := range users {
+			if user == "" {
+				ret = append(ret, ts)
+				continue
+			}
+			ret = append(ret, ts+separator+user)
+		}
+	}
+	return ret
+}
+
+// uniqueNonEmpty removes empty and duplicate entries keeping the order
+func uniqueNonEmpty(in []string) []string {
+	seen := make(map[string]struct{}, len(in))
+	ret := make([]string, 0, len(in))
+	for _, s := range in {
+		if s == "" {
+			continue
+		}
+		if _, ok := seen[s]; ok {
+			continue
+		}
+		seen[s] = struct{}{}
+		ret = append(ret, s)
+	}
+	return ret
+}
@@ -0,0 +1,62 @@
+package helper
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestRealmUsernames(t *testing.T) {
+	t.Parallel()
+	names := RealmUsernames("TURN.Example.com.")
+	seen := make(map[string]bool)
+	for _, n := range names {
+		if seen[n] {
+			t.Errorf("duplicate candidate %s", n)
+		}
+		seen[n] = true
+	}
+	for _, expected := range []string{"turn.example.com", "example.com", "example", "example-turn", "turnexample", "example_webrtc", "example123", "turn@turn.example.com"} {
+		if !seen[expected] {
+			t.Errorf("missing candidate %s", expected)
+		}
+	}
+	// the TLD on its own is useless
+	if seen["com"] {
+		t.Error("unexpected candidate com")
+	}
+	// affixes are not combined with themselves
+	if seen["turnturn"] {
+		t.Error("unexpected candidate turnturn")
+	}
+	if x := RealmUsernames(" "); x != nil {
+		t.Errorf("expected no candidates for an empty realm, got %v", x)
+	}
+
+	seen = make(map[string]bool)
+	for _, n := range RealmUsernames("turn.münchen.de") {
+		seen[n] = true
+	}
+	for _, expected := range []string{"münchen", "münchen-turn", "munchen", "munchen-turn", "turn@turn.munchen.de"} {
+		if !seen[expected] {
+			t.Errorf("missing candidate %s", expected)
+		}
+	}
+}
+
+func TestTimestampUsernames(t *testing.T) {
+	t.Parallel()
+	from := time.Unix(1700000000, 0)
+	names := TimestampUsernames([]string{"", "alice"}, from, from.Add(2*time.Hour), time.Hour, ":")
+	expected := []string{
+		"1700000000", "1700000000:alice",
+		"1700003600", "1700003600:alice",
+		"1700007200", "1700007200:alice",
+	}
+	if !reflect.DeepEqual(names, expected) {
+		t.Errorf("expected %v, got %v", expected, names)
+	}
+	if x := TimestampUsernames(nil, from, from.Add(-time.Hour), time.Hour, ":"); x != nil {
+		t.Errorf("expected no candidates, got %v", x)
+	}
+}
@@ -0,0 +1,119 @@
+package helper
+
+import (
+	"crypto/rand"
+	"encoding/xml"
+	"fmt"
+	"strings"
+)
+
+// WS-Discovery of April 2005 as implemented by Windows, printers and
+// ONVIF cameras. Devices implementing the OASIS standard of 2009 answer
+// with its namespace
+const (
+	wsdAction         = "http://schemas.xmlsoap.org/ws/2005/04/discovery/Probe"
+	wsdTo             = "urn:schemas-xmlsoap-org:ws:2005:04:discovery"
+	wsdMatchAction    = "http://schemas.xmlsoap.org/ws/2005/04/discovery/ProbeMatches"
+	wsdMatchActionV11 = "http://docs.oasis-open.org/ws-dd/ns/discovery/2009/01/ProbeMatches"
+)
+
+// WSDProbeMatch is a device that answered a WS-Discovery probe
+type WSDProbeMatch struct {
+	// Address is the endpoint reference of the device, usually urn:uuid:...
+	Address string
+	// Types are the device types like wsdp:Device or
+	// tdn:NetworkVideoTransmitter for ONVIF cameras
+	Types []string
+	// Scopes often contain the name, model and location of the device
+	Scopes []string
+	// XAddrs are the URLs of the metadata and management endpoints
+	XAddrs []string
+}
+
+type wsdEnvelope struct {
+	RelatesTo string `xml:"Header>RelatesTo"`
+	Action    string `xml:"Header>Action"`
+	Matches   []struct {
+		Address string `xml:"EndpointReference>Address"`
+		Types   string `xml:"Types"`
+		Scopes  string `xml:"Scopes"`
+		XAddrs  string `xml:"XAddrs"`
+	} `xml:"Body>ProbeMatches>ProbeMatch"`
+}
+
+// WSDProbe builds a WS-Discovery Probe for all types and scopes. It
+// returns the message and its message id the matches relate to
+func WSDProbe() ([]byte, string) {
+	messageID := "urn:uuid:" + randomUUID()
+	msg := `<?xml version="1.0" encoding="utf-8"?>` +
+		`<soap:Envelope xmlns:soap="http://www.w3.org/2003/05/soap-envelope" xmlns:wsa="http://schemas.xmlsoap.org/ws/2004/08/addressing" xmlns:wsd="http://schemas.xmlsoap.org/ws/2005/04/discovery">` +
+		`<soap:Header>` +
+		`<wsa:To>` + wsdTo + `</wsa:To>` +
+		`<wsa:Action>` + wsdAction + `</wsa:Action>` +
+		`<wsa:MessageID>` + messageID + `</wsa:MessageID>` +
+		`</soap:Header>` +
+		`<soap:Body><wsd:Probe/></soap:Body>` +
+		`</soap:Envelope>`
+	return []byte(msg), messageID
+}
+
+// WSDMessageID returns the message id of a WS-Discovery message like the
+// probe built by WSDProbe
+func WSDMessageID(msg []byte) (string, error) {
+	var envelope struct {
+		MessageID string `xml:"Header>MessageID"`
+	}
+	if err := xml.Unmarshal(msg, &envelope); err != nil {
+		return "", fmt.Errorf("invalid ws-discovery message: %w", err)
+	}
+	return envelope.MessageID, nil
+}
+
+// ParseWSDProbeMatches parses the ProbeMatches answering the probe with
+// messageID
+func ParseWSDProbeMatches(data []byte, messageID string) ([]WSDProbeMatch, error) {
+	var envelope wsdEnvelope
+	if err := xml.Unmarshal(data, &envelope); err != nil {
+		return nil, fmt.Errorf("invalid ws-discovery message: %w", err)
+	}
+	if action := strings.TrimSpace(envelope.Action); action != wsdMatchAction && action != wsdMatchActionV11 {
+		return nil, fmt.Errorf("message is not a ProbeMatches but %q", action)
+	}
+	if strings.TrimSpace(envelope.RelatesTo) != messageID {
+		return nil, fmt.Errorf("response does not belong to the request")
+	}
+	var matches []WSDProbeMatch
+	for _, m := range envelope.Matches {
+		matches = append(matches, WSDProbeMatch{
+			Address: strings.TrimSpace(m.Address),
+			Types:   strings.Fields(m.Types),
+			Scopes:  strings.Fields(m.Scopes),
+			XAddrs:  strings.Fields(m.XAddrs),
+		})
+	}
+	return matches, nil
+}
+
+// String returns a short description of the device
+func (m WSDProbeMatch) String() string {
+	s := fmt.Sprintf("types %s", strings.Join(m.Types, " "))
+	if len(m.XAddrs) > 0 {
+		s += fmt.Sprintf(", xaddrs %s", strings.Join(m.XAddrs, " "))
+	}
+	if len(m.Scopes) > 0 {
+		s += fmt.Sprintf(", scopes %s", strings.Join(m.Scopes, " "))
+	}
+	if m.Address != "" {
+		s += fmt.Sprintf(", endpoint %s", m.Address)
+	}
+	return s
+}
+
+// randomUUID returns a random version 4 UUID
+func randomUUID() string {
+	b := make([]byte, 16)
+	_, _ = rand.Read(b)
+	b[6] = b[6]&0x0f | 0x40
+	b[8] = b[8]&0x3f | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:])
+}
@@ -0,0 +1,75 @@
+package helper
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+const wsdTestMatches = `<?xml version="1.0" encoding="UTF-8"?>
+<SOAP-ENV:Envelope xmlns:SOAP-ENV="http://www.w3.org/2003/05/soap-envelope" xmlns:wsa="http://schemas.xmlsoap.org/ws/2004/08/addressing" xmlns:d="http://schemas.xmlsoap.org/ws/2005/04/discovery" xmlns:dn="http://www.onvif.org/ver10/network/wsdl">
+<SOAP-ENV:Header>
+<wsa:MessageID>urn:uuid:0a6dc791-2be6-4991-9af1-454778a1917a</wsa:MessageID>
+<wsa:RelatesTo>%s</wsa:RelatesTo>
+<wsa:To>http://schemas.xmlsoap.org/ws/2004/08/addressing/role/anonymous</wsa:To>
+<wsa:Action>http://schemas.xmlsoap.org/ws/2005/04/discovery/ProbeMatches</wsa:Action>
+</SOAP-ENV:Header>
+<SOAP-ENV:Body>
+<d:ProbeMatches>
+<d:ProbeMatch>
+<wsa:EndpointReference><wsa:Address>urn:uuid:4d454930-0000-1000-8000-bcbac2ab1234</wsa:Address></wsa:EndpointReference>
+<d:Types>dn:NetworkVideoTransmitter</d:Types>
+<d:Scopes>onvif://www.onvif.org/type/video_encoder onvif://www.onvif.org/name/IPCAM onvif://www.onvif.org/hardware/DS-2CD2042WD</d:Scopes>
+<d:XAddrs>http://10.0.0.64/onvif/device_service</d:XAddrs>
+<d:MetadataVersion>10</d:MetadataVersion>
+</d:ProbeMatch>
+</d:ProbeMatches>
+</SOAP-ENV:Body>
+</SOAP-ENV:Envelope>`
+
+func TestWSDProbe(t *testing.T) {
+	t.Parallel()
+	msg, messageID := WSDProbe()
+	if !strings.HasPrefix(messageID, "urn:uuid:") || len(messageID) != len("urn:uuid:")+36 {
+		t.Errorf("invalid message id %q", messageID)
+	}
+	if !bytes.Contains(msg, []byte("<wsd:Probe/>")) {
+		t.Errorf("invalid probe %s", msg)
+	}
+	id, err := WSDMessageID(msg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if id != messageID {
+		t.Errorf("expected message id %q, got %q", messageID, id)
+	}
+}
+
+func TestParseWSDProbeMatches(t *testing.T) {
+	t.Parallel()
+	messageID := "urn:uuid:2bf43d5c-6f02-4c36-9d51-0d6b4b3d4bd4"
+	resp := []byte(strings.Replace(wsdTestMatches, "%s", messageID, 1))
+	matches, err := ParseWSDProbeMatches(resp, messageID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(matches) != 1 {
+		t.Fatalf("expected 1 match, got %d", len(matches))
+	}
+	m := matches[0]
+	if m.Address != "urn:uuid:4d454930-0000-1000-8000-bcbac2ab1234" || len(m.Scopes) != 3 || len(m.XAddrs) != 1 {
+		t.Errorf("unexpected match %+v", m)
+	}
+	expected := "types dn:NetworkVideoTransmitter, xaddrs http://10.0.0.64/onvif/device_service, scopes onvif://www.onvif.org/type/video_encoder onvif://www.onvif.org/name/IPCAM onvif://www.onvif.org/hardware/DS-2CD2042WD, endpoint urn:uuid:4d454930-0000-1000-8000-bcbac2ab1234"
+	if s := m.String(); s != expected {
+		t.Errorf("expected %s but got %s", expected, s)
+	}
+
+	if _, err := ParseWSDProbeMatches(resp, "urn:uuid:other"); err == nil {
+		t.Errorf("expected an error for a different message id")
+	}
+	probe, _ := WSDProbe()
+	if _, err := ParseWSDProbeMatches(probe, messageID); err == nil {
+		t.Errorf("expected an error on parsing a probe")
+	}
+}
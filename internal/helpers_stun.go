@@ -1,12 +1,15 @@
 package internal
 
 import (
+	"bytes"
 	"crypto/hmac"
 	"crypto/md5"
 	"crypto/sha1"
 	"encoding/binary"
 	"fmt"
 	"hash/crc32"
+
+	"golang.org/x/text/secure/precis"
 )
 
 const (
@@ -39,9 +42,24 @@ func generateFingerprint(buf []byte) []byte {
 	return ret
 }
 
+// opaqueString prepares a username, realm or password with the
+// OpaqueString profile of RFC 8265, which replaced SASLprep in RFC 8489. It
+// maps non-ASCII spaces and normalizes to NFC, so credentials typed in
+// another normalization form match the ones configured on the server. The
+// username is prepared for the long-term key and for the USERNAME attribute
+// alike, as the server derives the key from the username it receives.
+// Strings the profile rejects, like empty ones, are returned unchanged
+func opaqueString(s string) string {
+	prepared, err := precis.OpaqueString.String(s)
+	if err != nil {
+		return s
+	}
+	return prepared
+}
+
 func calculateMessageIntegrity(buf []byte, username, realm, password string) ([]byte, error) {
-	// key = MD5(username ":" realm ":" SASLprep(password))
-	key := fmt.Sprintf("%s:%s:%s", username, realm, password)
+	// key = MD5(OpaqueString(username) ":" OpaqueString(realm) ":" OpaqueString(password))
+	key := fmt.Sprintf("%s:%s:%s", opaqueString(username), opaqueString(realm), opaqueString(password))
 	// key := password
 	md := md5.New()
 	if _, err := md.Write([]byte(key)); err != nil {
@@ -55,3 +73,66 @@ func calculateMessageIntegrity(buf []byte, username, realm, password string) ([]
 	}
 	return x.Sum(nil), nil
 }
+
+// attributeOffset returns the offset of the first attribute of the type in
+// a raw message or -1 if it is missing
+func attributeOffset(raw []byte, attr AttributeType) int {
+	pos := headerSize
+	for pos+4 <= len(raw) {
+		if AttributeType(binary.BigEndian.Uint16(raw[pos:pos+2])) == attr {
+			return pos
+		}
+		pos += 4 + int(align(binary.BigEndian.Uint16(raw[pos+2:pos+4])))
+	}
+	return -1
+}
+
+// HasValidMagicCookie returns false if the magic cookie of a received
+// message was changed
+func (s *Stun) HasValidMagicCookie() bool {
+	return len(s.raw) >= headerSize && bytes.Equal(s.raw[4:8], MagicCookie)
+}
+
+// VerifyFingerprint checks the FINGERPRINT attribute of a received message.
+// present is false if the message has none
+func (s *Stun) VerifyFingerprint() (present bool, err error) {
+	pos := attributeOffset(s.raw, AttrFingerprint)
+	if pos < 0 {
+		return false, nil
+	}
+	if pos+4+fingerPrintSize > len(s.raw) {
+		return true, fmt.Errorf("truncated fingerprint")
+	}
+	expected := generateFingerprint(s.raw[:pos])
+	if !bytes.Equal(expected, s.raw[pos+4:pos+4+fingerPrintSize]) {
+		return true, fmt.Errorf("fingerprint %02x does not match the message, expected %02x", s.raw[pos+4:pos+4+fingerPrintSize], expected)
+	}
+	return true, nil
+}
+
+// VerifyMessageIntegrity checks the MESSAGE-INTEGRITY attribute of a
+// received message with the long term credentials. present is false if the
+// message has none
+func (s *Stun) VerifyMessageIntegrity(username, realm, password string) (present bool, err error) {
+	pos := attributeOffset(s.raw, AttrMessageIntegrity)
+	if pos < 0 {
+		return false, nil
+	}
+	end := pos + 4 + messageIntegritySize
+	if end > len(s.raw) {
+		return true, fmt.Errorf("truncated message integrity")
+	}
+	// the integrity is calculated with the length up to and including
+	// itself, attributes following it like FINGERPRINT are excluded
+	buf := make([]byte, pos)
+	copy(buf, s.raw[:pos])
+	binary.BigEndian.PutUint16(buf[2:4], uint16(end-headerSize))
+	expected, err := calculateMessageIntegrity(buf, username, realm, password)
+	if err != nil {
+		return true, err
+	}
+	if !hmac.Equal(expected, s.raw[pos+4:end]) {
+		return true, fmt.Errorf("message integrity does not match the message")
+	}
+	return true, nil
+}
@@ -31,3 +31,154 @@ func TestPadding(t *testing.T) {
 		})
 	}
 }
+
+func TestVerifyFingerprint(t *testing.T) {
+	t.Parallel()
+	s := BindingRequest()
+	s.Attributes = append(s.Attributes, Attribute{Type: AttrSoftware, Value: []byte("stunner")})
+	s.Fingerprint = true
+	buf, err := s.Serialize()
+	if err != nil {
+		t.Fatal(err)
+	}
+	received, err := fromBytes(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !received.HasValidMagicCookie() {
+		t.Error("magic cookie should be valid")
+	}
+	present, err := received.VerifyFingerprint()
+	if !present || err != nil {
+		t.Fatalf("expected a valid fingerprint, got %t %v", present, err)
+	}
+
+	// change the software like a middlebox would
+	mangled := bytes.Replace(buf, []byte("stunner"), []byte("STUNNER"), 1)
+	received, err = fromBytes(mangled)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := received.VerifyFingerprint(); err == nil {
+		t.Error("expected an error for a changed message")
+	}
+
+	s.Fingerprint = false
+	buf, err = s.Serialize()
+	if err != nil {
+		t.Fatal(err)
+	}
+	received, err = fromBytes(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if present, err := received.VerifyFingerprint(); present || err != nil {
+		t.Errorf("expected no fingerprint, got %t %v", present, err)
+	}
+}
+
+func TestVerifyMessageIntegrity(t *testing.T) {
+	t.Parallel()
+	s := AllocateRequestAuth("user", "pass", "nonce", "realm", RequestedTransportUDP, AllocateProtocolIgnore)
+	s.Fingerprint = true
+	buf, err := s.Serialize()
+	if err != nil {
+		t.Fatal(err)
+	}
+	received, err := fromBytes(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	present, err := received.VerifyMessageIntegrity("user", "realm", "pass")
+	if !present || err != nil {
+		t.Fatalf("expected a valid message integrity, got %t %v", present, err)
+	}
+	if _, err := received.VerifyMessageIntegrity("user", "realm", "wrong"); err == nil {
+		t.Error("expected an error for a wrong password")
+	}
+
+	// a changed cookie breaks the integrity as well
+	mangled := append([]byte{}, buf...)
+	mangled[4] ^= 0xff
+	received, err = fromBytes(mangled)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if received.HasValidMagicCookie() {
+		t.Error("magic cookie should be invalid")
+	}
+	if _, err := received.VerifyMessageIntegrity("user", "realm", "pass"); err == nil {
+		t.Error("expected an error for a changed message")
+	}
+}
+
+func TestMessageIntegrityOpaqueString(t *testing.T) {
+	t.Parallel()
+	buf := []byte("message")
+	// é precomposed and as e with a combining acute accent
+	nfc, err := calculateMessageIntegrity(buf, "usér", "réalm", "paéss")
+	if err != nil {
+		t.Fatal(err)
+	}
+	nfd, err := calculateMessageIntegrity(buf, "usér", "réalm", "paéss")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(nfc, nfd) {
+		t.Error("the normalization form changed the key")
+	}
+
+	// non-ASCII spaces are mapped to a space
+	space, err := calculateMessageIntegrity(buf, "user", "realm", "pass word")
+	if err != nil {
+		t.Fatal(err)
+	}
+	nbsp, err := calculateMessageIntegrity(buf, "user", "realm", "pass word")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(space, nbsp) {
+		t.Error("a non-breaking space changed the key")
+	}
+
+	// strings rejected by the profile are used as they are
+	if opaqueString("pass\tword") != "pass\tword" {
+		t.Error("a rejected string was changed")
+	}
+}
+
+func TestSerializePreparesUsername(t *testing.T) {
+	t.Parallel()
+
+	var tests = []struct {
+		name     string
+		username string
+		expected string
+	}{
+		{"nfc", "us\u00e9r", "us\u00e9r"},
+		{"nfd", "use\u0301r", "us\u00e9r"},
+		{"non-breaking space", "j\u00fcrgen\u00a0m\u00fcller", "j\u00fcrgen m\u00fcller"},
+		{"cjk", "\u30e6\u30fc\u30b6\u30fc", "\u30e6\u30fc\u30b6\u30fc"},
+	}
+	for _, tt := range tests {
+		tt := tt // NOTE: https://github.com/golang/go/wiki/CommonMistakes#using-goroutines-on-loop-iterator-variables
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			s := AllocateRequestAuth(tt.username, "pass", "nonce", "realm", RequestedTransportUDP, AllocateProtocolIgnore)
+			buf, err := s.Serialize()
+			if err != nil {
+				t.Fatal(err)
+			}
+			received, err := fromBytes(buf)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if username := string(received.GetAttribute(AttrUsername).Value); username != tt.expected {
+				t.Errorf("expected the username %q, got %q", tt.expected, username)
+			}
+			if _, err := received.VerifyMessageIntegrity(tt.username, "realm", "pass"); err != nil {
+				t.Error(err)
+			}
+		})
+	}
+}
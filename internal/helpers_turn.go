@@ -3,6 +3,7 @@ package internal
 import (
 	"bytes"
 	"encoding/binary"
+	"errors"
 	"fmt"
 	"net"
 	"net/netip"
@@ -24,6 +25,15 @@ func xor(content, key []byte) []byte {
 	return buf
 }
 
+// AddressFamily returns the address family to request for an allocation
+// that relays to ip. IPv4-mapped IPv6 addresses are relayed over IPv4
+func AddressFamily(ip netip.Addr) AllocateProtocol {
+	if ip.Unmap().Is6() {
+		return AllocateProtocolIPv6
+	}
+	return AllocateProtocolIgnore
+}
+
 // xorAddr implements the XOR required for the STUN and TURN protocol
 //
 //		0                   1                   2                   3
@@ -39,6 +49,9 @@ func xorAddr(ip netip.Addr, port uint16, transactionID []byte) ([]byte, error) {
 	var family uint16
 	var key []byte
 
+	// IPv4-mapped IPv6 addresses are sent as IPv4 addresses
+	ip = ip.Unmap()
+
 	if ip.Is6() {
 		family = uint16(0x02)
 		key = append(MagicCookie, transactionID...)
@@ -120,6 +133,70 @@ func ConvertXORAddr(input []byte, transactionID string) (string, uint16, error)
 	return ip.String(), port, nil
 }
 
+// checkScope returns an error if a peer of the message is not in the scope
+// of the engagement. Permissions ignore the port so only the address is
+// checked for them. Peers that can not be parsed are out of scope
+func checkScope(s *Stun) error {
+	for _, a := range s.Attributes {
+		if a.Type != AttrXorPeerAddress {
+			continue
+		}
+		var ip netip.Addr
+		host, port, err := ConvertXORAddr(a.Value, s.Header.TransactionID)
+		if err == nil {
+			ip, _ = netip.ParseAddr(host)
+		}
+		if s.Header.MessageType.Method == MsgTypeMethodCreatePermission {
+			port = 0
+		}
+		if err := helper.CheckScope(ip, port); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ErrPayloadTooLarge is returned if a payload exceeds the maximum payload
+// size of the relay and can not be split
+var ErrPayloadTooLarge = errors.New("payload too large")
+
+// SplitPayload splits payload into chunks of at most maxPayload bytes so
+// they can be sent in multiple messages. A maxPayload of 0 disables the
+// splitting. If the application protocol does not allow the payload to be
+// split ErrPayloadTooLarge is returned for oversized payloads
+func SplitPayload(payload []byte, maxPayload int, splittable bool) ([][]byte, error) {
+	if maxPayload <= 0 || len(payload) <= maxPayload {
+		return [][]byte{payload}, nil
+	}
+	if !splittable {
+		return nil, fmt.Errorf("%d bytes exceed the maximum payload size of %d bytes: %w", len(payload), maxPayload, ErrPayloadTooLarge)
+	}
+	var chunks [][]byte
+	for len(payload) > 0 {
+		n := maxPayload
+		if len(payload) < n {
+			n = len(payload)
+		}
+		chunks = append(chunks, payload[:n])
+		payload = payload[n:]
+	}
+	return chunks, nil
+}
+
+// IsTransientError returns true if err contains an error response of the
+// server that indicates a temporary problem so the request can be retried
+func IsTransientError(err error) bool {
+	var respErr *ResponseError
+	if !errors.As(err, &respErr) {
+		return false
+	}
+	switch respErr.Code {
+	case ErrorInsufficientCapacity, ErrorAllocationQuotaReached, ErrorServerError:
+		return true
+	}
+	return false
+}
+
 // SetupTurnConnection executes the following:
 //
 //	Allocate Unauth (to get realm and nonce)
@@ -128,23 +205,35 @@ func ConvertXORAddr(input []byte, transactionID string) (string, uint16, error)
 //
 // it returns the connection, the realm, the nonce and an error
 func SetupTurnConnection(logger DebugLogger, connectProtocol string, turnServer string, useTLS bool, tlsVerify bool, timeout time.Duration, targetHost netip.Addr, targetPort uint16, username, password string) (net.Conn, string, string, error) {
+	remote, realm, nonce, _, err := setupTurnAllocation(logger, connectProtocol, turnServer, useTLS, tlsVerify, timeout, targetHost, targetPort, username, password)
+	return remote, realm, nonce, err
+}
+
+// setupTurnAllocation is SetupTurnConnection that also returns the
+// lifetime granted by the server
+func setupTurnAllocation(logger DebugLogger, connectProtocol string, turnServer string, useTLS bool, tlsVerify bool, timeout time.Duration, targetHost netip.Addr, targetPort uint16, username, password string) (net.Conn, string, string, time.Duration, error) {
+	throttle := currentAllocationThrottle()
+	throttle.wait()
+	remote, realm, nonce, lifetime, err := setupTurnConnection(logger, connectProtocol, turnServer, useTLS, tlsVerify, timeout, targetHost, targetPort, username, password)
+	throttle.done(err)
+	return remote, realm, nonce, lifetime, err
+}
+
+func setupTurnConnection(logger DebugLogger, connectProtocol string, turnServer string, useTLS bool, tlsVerify bool, timeout time.Duration, targetHost netip.Addr, targetPort uint16, username, password string) (net.Conn, string, string, time.Duration, error) {
 	remote, err := Connect(connectProtocol, turnServer, useTLS, tlsVerify, timeout)
 	if err != nil {
-		return nil, "", "", err
+		return nil, "", "", 0, err
 	}
 
-	addressFamily := AllocateProtocolIgnore
-	if targetHost.Is6() {
-		addressFamily = AllocateProtocolIPv6
-	}
+	addressFamily := AddressFamily(targetHost)
 
 	allocateRequest := AllocateRequest(RequestedTransportUDP, addressFamily)
 	allocateResponse, err := allocateRequest.SendAndReceive(logger, remote, timeout)
 	if err != nil {
-		return nil, "", "", fmt.Errorf("error on sending AllocateRequest: %w", err)
+		return nil, "", "", 0, fmt.Errorf("error on sending AllocateRequest: %w", err)
 	}
 	if allocateResponse.Header.MessageType.Class != MsgTypeClassError {
-		return nil, "", "", fmt.Errorf("MessageClass is not Error (should be not authenticated)")
+		return nil, "", "", 0, fmt.Errorf("MessageClass is not Error (should be not authenticated)")
 	}
 
 	realm := string(allocateResponse.GetAttribute(AttrRealm).Value)
@@ -153,22 +242,38 @@ func SetupTurnConnection(logger DebugLogger, connectProtocol string, turnServer
 	allocateRequest = AllocateRequestAuth(username, password, nonce, realm, RequestedTransportUDP, addressFamily)
 	allocateResponse, err = allocateRequest.SendAndReceive(logger, remote, timeout)
 	if err != nil {
-		return nil, "", "", fmt.Errorf("error on sending AllocateRequest Auth: %w", err)
+		return nil, "", "", 0, fmt.Errorf("error on sending AllocateRequest Auth: %w", err)
 	}
 	if allocateResponse.Header.MessageType.Class == MsgTypeClassError {
-		return nil, "", "", fmt.Errorf("error on AllocateRequest Auth: %s", allocateResponse.GetErrorString())
+		return nil, "", "", 0, fmt.Errorf("error on AllocateRequest Auth: %w", allocateResponse.GetError())
+	}
+	lifetime := DefaultAllocationLifetime
+	if v := allocateResponse.GetAttribute(AttrLifetime).Value; len(v) == 4 {
+		lifetime = time.Duration(binary.BigEndian.Uint32(v)) * time.Second
 	}
 	permissionRequest, err := CreatePermissionRequest(username, password, nonce, realm, targetHost, targetPort)
 	if err != nil {
-		return nil, "", "", fmt.Errorf("error on generating CreatePermissionRequest: %w", err)
+		return nil, "", "", 0, fmt.Errorf("error on generating CreatePermissionRequest: %w", err)
 	}
 	permissionResponse, err := permissionRequest.SendAndReceive(logger, remote, timeout)
 	if err != nil {
-		return nil, "", "", fmt.Errorf("error on sending CreatePermissionRequest: %w", err)
+		return nil, "", "", 0, fmt.Errorf("error on sending CreatePermissionRequest: %w", err)
 	}
 	if permissionResponse.Header.MessageType.Class == MsgTypeClassError {
-		return nil, "", "", fmt.Errorf("error on CreatePermission: %s", permissionResponse.GetErrorString())
+		return nil, "", "", 0, fmt.Errorf("error on CreatePermission: %w", permissionResponse.GetError())
 	}
 
-	return remote, realm, nonce, nil
+	return remote, realm, nonce, lifetime, nil
+}
+
+// SetupTurnChannel executes SetupTurnConnection followed by a ChannelBind
+// to the target
+//
+// it returns the connection, the bound channel number and an error
+func SetupTurnChannel(logger DebugLogger, connectProtocol string, turnServer string, useTLS bool, tlsVerify bool, timeout time.Duration, targetHost netip.Addr, targetPort uint16, username, password string) (net.Conn, []byte, error) {
+	allocation, err := NewAllocation(logger, connectProtocol, turnServer, useTLS, tlsVerify, timeout, targetHost, targetPort, username, password)
+	if err != nil {
+		return nil, nil, err
+	}
+	return allocation.Conn, allocation.Channel, nil
 }
@@ -2,8 +2,13 @@ package internal
 
 import (
 	"encoding/hex"
+	"errors"
+	"fmt"
 	"net/netip"
+	"strings"
 	"testing"
+
+	"github.com/firefart/stunner/internal/helper"
 )
 
 func TestXorAddr(t *testing.T) {
@@ -20,6 +25,49 @@ func TestXorAddr(t *testing.T) {
 	}
 }
 
+func TestXorAddrIPv6(t *testing.T) {
+	t.Parallel()
+	transactionID := "0123456789ab"
+	x, err := xorAddr(netip.MustParseAddr("2001:db8::1"), 443, []byte(transactionID))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(x) != 20 || x[1] != 0x02 {
+		t.Fatalf("expected an IPv6 address but got %x", x)
+	}
+	host, port, err := ConvertXORAddr(x, transactionID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if host != "2001:db8::1" || port != 443 {
+		t.Errorf("expected [2001:db8::1]:443 but got [%s]:%d", host, port)
+	}
+
+	// mapped addresses are sent as IPv4
+	x, err = xorAddr(netip.MustParseAddr("::ffff:127.0.0.1"), 22, []byte("ASDF"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if h := hex.EncodeToString(x); h != "000121045e12a443" {
+		t.Errorf("expected the IPv4 address but got %q", h)
+	}
+}
+
+func TestAddressFamily(t *testing.T) {
+	t.Parallel()
+	tests := map[string]AllocateProtocol{
+		"10.0.0.1":        AllocateProtocolIgnore,
+		"::ffff:10.0.0.1": AllocateProtocolIgnore,
+		"2001:db8::1":     AllocateProtocolIPv6,
+		"fd00::1":         AllocateProtocolIPv6,
+	}
+	for ip, want := range tests {
+		if got := AddressFamily(netip.MustParseAddr(ip)); got != want {
+			t.Errorf("%s: got %d, want %d", ip, got, want)
+		}
+	}
+}
+
 func TestConvertXORAddr(t *testing.T) {
 	t.Parallel()
 
@@ -52,3 +100,112 @@ func TestConvertXORAddr(t *testing.T) {
 		})
 	}
 }
+
+func TestIsTransientError(t *testing.T) {
+	t.Parallel()
+
+	var tests = []struct {
+		testName string
+		err      error
+		expected bool
+	}{
+		{"Insufficient Capacity", fmt.Errorf("error on Connect response: %w", &ResponseError{Code: ErrorInsufficientCapacity}), true},
+		{"Allocation Quota Reached", &ResponseError{Code: ErrorAllocationQuotaReached}, true},
+		{"Server Error", &ResponseError{Code: ErrorServerError}, true},
+		{"Forbidden", &ResponseError{Code: ErrorForbidden}, false},
+		{"Other Error", fmt.Errorf("timeout"), false},
+		{"nil", nil, false},
+	}
+	for _, tt := range tests {
+		tt := tt // NOTE: https://github.com/golang/go/wiki/CommonMistakes#using-goroutines-on-loop-iterator-variables
+		t.Run(tt.testName, func(t *testing.T) {
+			t.Parallel()
+			if x := IsTransientError(tt.err); x != tt.expected {
+				t.Errorf("expected %t but got %t", tt.expected, x)
+			}
+		})
+	}
+}
+
+func TestSplitPayload(t *testing.T) {
+	t.Parallel()
+
+	var tests = []struct {
+		testName   string
+		size       int
+		maxPayload int
+		splittable bool
+		expected   int
+		expectErr  bool
+	}{
+		{"Disabled", 3000, 0, false, 1, false},
+		{"Fits", 1000, 1000, false, 1, false},
+		{"Split", 2500, 1000, true, 3, false},
+		{"Not splittable", 2500, 1000, false, 0, true},
+	}
+	for _, tt := range tests {
+		tt := tt // NOTE: https://github.com/golang/go/wiki/CommonMistakes#using-goroutines-on-loop-iterator-variables
+		t.Run(tt.testName, func(t *testing.T) {
+			t.Parallel()
+			chunks, err := SplitPayload(make([]byte, tt.size), tt.maxPayload, tt.splittable)
+			if tt.expectErr {
+				if !errors.Is(err, ErrPayloadTooLarge) {
+					t.Errorf("expected ErrPayloadTooLarge, got %v", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(chunks) != tt.expected {
+				t.Errorf("expected %d chunks, got %d", tt.expected, len(chunks))
+			}
+			total := 0
+			for _, c := range chunks {
+				if tt.maxPayload > 0 && len(c) > tt.maxPayload {
+					t.Errorf("chunk of %d bytes exceeds %d", len(c), tt.maxPayload)
+				}
+				total += len(c)
+			}
+			if total != tt.size {
+				t.Errorf("expected %d bytes in total, got %d", tt.size, total)
+			}
+		})
+	}
+}
+
+// no t.Parallel as the scope is global
+func TestCheckScope(t *testing.T) {
+	scope, err := helper.ParseScope(strings.NewReader("allow 10.0.0.0/8\nports 53"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	helper.SetScope(nil, scope)
+	defer helper.SetScope(nil, nil)
+
+	// permissions ignore the port
+	permission, err := CreatePermissionRequest("user", "pass", "nonce", "realm", netip.MustParseAddr("10.0.0.1"), 80)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := checkScope(permission); err != nil {
+		t.Errorf("unexpected error %v", err)
+	}
+	bind, err := ChannelBindRequest("user", "pass", "nonce", "realm", netip.MustParseAddr("10.0.0.1"), 80, []byte{0x40, 0x00})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := checkScope(bind); !errors.Is(err, helper.ErrOutOfScope) {
+		t.Errorf("expected port 80 to be out of scope, got %v", err)
+	}
+	permissions, err := CreatePermissionsRequest("user", "pass", "nonce", "realm", []netip.Addr{netip.MustParseAddr("10.0.0.1"), netip.MustParseAddr("fd00::1")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := checkScope(permissions); !errors.Is(err, helper.ErrOutOfScope) {
+		t.Errorf("expected fd00::1 to be out of scope, got %v", err)
+	}
+	if err := checkScope(RefreshRequest("user", "pass", "nonce", "realm")); err != nil {
+		t.Errorf("unexpected error %v for a request without peer", err)
+	}
+}
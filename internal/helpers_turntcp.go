@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"net"
 	"net/netip"
+	"sync"
 	"time"
 )
 
@@ -17,85 +18,101 @@ import (
 //
 // it returns the controlConnection, the dataConnection and an error
 func SetupTurnTCPConnection(logger DebugLogger, turnServer string, useTLS bool, tlsVerify bool, timeout time.Duration, targetHost netip.Addr, targetPort uint16, username, password string) (*net.TCPConn, *net.TCPConn, error) {
-	// protocol needs to be tcp
-	controlConnectionRaw, err := Connect("tcp", turnServer, useTLS, tlsVerify, timeout)
-	if err != nil {
-		return nil, nil, fmt.Errorf("error on establishing control connection: %w", err)
-	}
-
-	controlConnection, ok := controlConnectionRaw.(*net.TCPConn)
-	if !ok {
-		return nil, nil, fmt.Errorf("could not cast control connection to TCPConn")
-	}
-	if err := controlConnection.SetKeepAlive(true); err != nil {
-		return nil, nil, fmt.Errorf("could not set KeepAlive on control connection: %w", err)
-	}
-
-	logger.Debugf("opened turn tcp control connection from %s to %s", controlConnection.LocalAddr().String(), controlConnection.RemoteAddr().String())
-
-	addressFamily := AllocateProtocolIgnore
-	if targetHost.Is6() {
-		addressFamily = AllocateProtocolIPv6
-	}
+	addressFamily := AddressFamily(targetHost)
 
-	allocateRequest := AllocateRequest(RequestedTransportTCP, addressFamily)
-	allocateResponse, err := allocateRequest.SendAndReceive(logger, controlConnection, timeout)
+	allocation, err := NewTCPAllocation(logger, turnServer, useTLS, tlsVerify, timeout, addressFamily, username, password)
 	if err != nil {
-		return nil, nil, fmt.Errorf("error on sending allocate request 1: %w", err)
-	}
-	if allocateResponse.Header.MessageType.Class != MsgTypeClassError {
-		return nil, nil, fmt.Errorf("MessageClass is not Error (should be not authenticated)")
+		return nil, nil, err
 	}
-
-	realm := string(allocateResponse.GetAttribute(AttrRealm).Value)
-	nonce := string(allocateResponse.GetAttribute(AttrNonce).Value)
-
-	allocateRequest = AllocateRequestAuth(username, password, nonce, realm, RequestedTransportTCP, addressFamily)
-	allocateResponse, err = allocateRequest.SendAndReceive(logger, controlConnection, timeout)
+	dataConnection, err := allocation.Dial(targetHost, targetPort)
 	if err != nil {
-		return nil, nil, fmt.Errorf("error on sending allocate request 2: %w", err)
-	}
-	if allocateResponse.Header.MessageType.Class == MsgTypeClassError {
-		return nil, nil, fmt.Errorf("error on allocate response: %s", allocateResponse.GetErrorString())
+		allocation.Close()
+		return nil, nil, err
 	}
+	return allocation.Control, dataConnection, nil
+}
 
-	connectRequest, err := ConnectRequestAuth(username, password, nonce, realm, targetHost, targetPort)
-	if err != nil {
-		return nil, nil, fmt.Errorf("error on generating Connect request: %w", err)
-	}
-	connectResponse, err := connectRequest.SendAndReceive(logger, controlConnection, timeout)
-	if err != nil {
-		return nil, nil, fmt.Errorf("error on sending Connect request: %w", err)
-	}
-	if connectResponse.Header.MessageType.Class == MsgTypeClassError {
-		return nil, nil, fmt.Errorf("error on Connect response: %s", connectResponse.GetErrorString())
-	}
+// relayRefreshInterval is the interval the allocation of a RelayConn is
+// refreshed in. It is well below the default lifetime of 10 minutes
+const relayRefreshInterval = 2 * time.Minute
 
-	connectionID := connectResponse.GetAttribute(AttrConnectionID).Value
+// RelayConn is the data connection of a TCP allocation. The allocation is
+// refreshed in the background and closing the connection also closes the
+// control connection which releases the allocation
+type RelayConn struct {
+	*net.TCPConn
+	control   *net.TCPConn
+	closeOnce sync.Once
+	stop      chan struct{}
+}
 
-	dataConnectionRaw, err := Connect("tcp", turnServer, useTLS, tlsVerify, timeout)
-	if err != nil {
-		return nil, nil, fmt.Errorf("error on establishing data connection: %w", err)
-	}
+func (c *RelayConn) Close() error {
+	c.closeOnce.Do(func() {
+		close(c.stop)
+	})
+	err := c.TCPConn.Close()
+	if cerr := c.control.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
 
-	dataConnection, ok := dataConnectionRaw.(*net.TCPConn)
-	if !ok {
-		return nil, nil, fmt.Errorf("could not cast data connection to TCPConn")
-	}
-	if err := dataConnection.SetKeepAlive(true); err != nil {
-		return nil, nil, fmt.Errorf("could not set KeepAlive on data connection: %w", err)
+// refresh refreshes the allocation until the connection is closed
+func (c *RelayConn) refresh(logger DebugLogger, timeout time.Duration, username, password string) {
+	tick := time.NewTicker(relayRefreshInterval)
+	defer tick.Stop()
+	// the first refresh is answered with the current realm and nonce
+	realm := ""
+	nonce := ""
+	for {
+		select {
+		case <-c.stop:
+			return
+		case <-tick.C:
+		}
+		logger.Debugf("[conn %s] refreshing allocation", ConnID(c.control))
+		for attempt := 0; attempt < 2; attempt++ {
+			refresh := RefreshRequest(username, password, nonce, realm)
+			response, err := refresh.SendAndReceive(logger, c.control, timeout)
+			if err != nil {
+				logger.Debugf("[conn %s] error on refreshing the allocation: %v", ConnID(c.control), err)
+				return
+			}
+			if response.Header.MessageType.Class != MsgTypeClassError {
+				break
+			}
+			if attempt == 1 {
+				logger.Debugf("[conn %s] error on refreshing the allocation: %v", ConnID(c.control), response.GetError())
+				return
+			}
+			if r := response.GetAttribute(AttrRealm).Value; len(r) > 0 {
+				realm = string(r)
+			}
+			nonce = string(response.GetAttribute(AttrNonce).Value)
+		}
 	}
+}
 
-	logger.Debugf("opened turn tcp data connection from %s to %s", dataConnection.LocalAddr().String(), dataConnection.RemoteAddr().String())
-
-	connectionBindRequest := ConnectionBindRequest(connectionID, username, password, nonce, realm)
-	connectionBindResponse, err := connectionBindRequest.SendAndReceive(logger, dataConnection, timeout)
+// DialTurnTCP connects to the target via a TCP allocation and returns
+// the data connection. It can be used like a connection returned by
+// net.Dial and supports CloseWrite. The allocation is kept alive until
+// the connection is closed.
+func DialTurnTCP(logger DebugLogger, turnServer string, useTLS bool, tlsVerify bool, timeout time.Duration, targetHost netip.Addr, targetPort uint16, username, password string) (*RelayConn, error) {
+	controlConnection, dataConnection, err := SetupTurnTCPConnection(logger, turnServer, useTLS, tlsVerify, timeout, targetHost, targetPort, username, password)
 	if err != nil {
-		return nil, nil, fmt.Errorf("error on sending ConnectionBind request: %w", err)
+		return nil, err
 	}
-	if connectionBindResponse.Header.MessageType.Class == MsgTypeClassError {
-		return nil, nil, fmt.Errorf("error on ConnectionBind reposnse: %s", connectionBindResponse.GetErrorString())
+	// the setup leaves the deadlines of the handshake on the connection
+	if err := dataConnection.SetDeadline(time.Time{}); err != nil {
+		dataConnection.Close()
+		controlConnection.Close()
+		return nil, fmt.Errorf("could not reset deadline: %w", err)
 	}
-
-	return controlConnection, dataConnection, nil
+	c := &RelayConn{
+		TCPConn: dataConnection,
+		control: controlConnection,
+		stop:    make(chan struct{}),
+	}
+	go c.refresh(logger, timeout, username, password)
+	return c, nil
 }
@@ -1,5 +1,34 @@
 package internal
 
+import (
+	"fmt"
+	"hash/fnv"
+	"net"
+)
+
 type DebugLogger interface {
 	Debugf(format string, args ...interface{})
 }
+
+// ConnID returns a short ID of the connection derived from its local and
+// remote address. It is used to tell apart the debug logs of multiple
+// connections that are used in parallel
+func ConnID(conn net.Conn) string {
+	if conn == nil {
+		return "------"
+	}
+	h := fnv.New32a()
+	if a := conn.LocalAddr(); a != nil {
+		_, _ = h.Write([]byte(a.String()))
+	}
+	if a := conn.RemoteAddr(); a != nil {
+		_, _ = h.Write([]byte(a.String()))
+	}
+	return fmt.Sprintf("%06x", h.Sum32()&0xffffff)
+}
+
+// logTag returns the prefix of debug logs for a STUN transaction on
+// the connection
+func logTag(conn net.Conn, transactionID string) string {
+	return fmt.Sprintf("[conn %s tx %02x]", ConnID(conn), transactionID)
+}
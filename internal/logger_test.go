@@ -0,0 +1,40 @@
+package internal
+
+import (
+	"net"
+	"strings"
+	"testing"
+)
+
+type addrConn struct {
+	net.Conn
+	local, remote net.Addr
+}
+
+func (c addrConn) LocalAddr() net.Addr  { return c.local }
+func (c addrConn) RemoteAddr() net.Addr { return c.remote }
+
+func TestConnID(t *testing.T) {
+	t.Parallel()
+	server := &net.UDPAddr{IP: net.IPv4(192, 0, 2, 1), Port: 3478}
+	a := addrConn{local: &net.UDPAddr{IP: net.IPv4(10, 0, 0, 1), Port: 50000}, remote: server}
+	b := addrConn{local: &net.UDPAddr{IP: net.IPv4(10, 0, 0, 1), Port: 50001}, remote: server}
+
+	if len(ConnID(a)) != 6 {
+		t.Errorf("expected a 6 character id, got %q", ConnID(a))
+	}
+	if ConnID(a) != ConnID(a) {
+		t.Error("id is not stable")
+	}
+	if ConnID(a) == ConnID(b) {
+		t.Errorf("different connections got the same id %s", ConnID(a))
+	}
+	if x := ConnID(nil); x == "" {
+		t.Error("expected a placeholder for a nil connection")
+	}
+
+	tag := logTag(a, "abcdefghijkl")
+	if !strings.Contains(tag, ConnID(a)) || !strings.Contains(tag, "6162636465666768696a6b6c") {
+		t.Errorf("unexpected tag %s", tag)
+	}
+}
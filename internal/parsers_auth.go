@@ -0,0 +1,98 @@
+package internal
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"encoding/binary"
+)
+
+// AuthMessage is an authenticated STUN message as seen on the wire. It
+// contains everything needed to check passwords offline.
+type AuthMessage struct {
+	Header    Header
+	Username  string
+	Realm     string
+	Nonce     string
+	Integrity []byte
+	// Signed is the part of the message the MESSAGE-INTEGRITY is
+	// calculated over, including the adjusted length in the header
+	Signed []byte
+}
+
+// CheckPassword returns true if the long term credentials with the
+// password result in the MESSAGE-INTEGRITY of the message
+func (m AuthMessage) CheckPassword(password string) bool {
+	integrity, err := calculateMessageIntegrity(m.Signed, m.Username, m.Realm, password)
+	if err != nil {
+		return false
+	}
+	return hmac.Equal(integrity, m.Integrity)
+}
+
+// ParseAuthMessages parses all STUN messages in the payload of a
+// UDP datagram or a TCP segment and returns the ones that contain a
+// MESSAGE-INTEGRITY and a USERNAME. ChannelData messages and data
+// that is no STUN message are skipped.
+func ParseAuthMessages(payload []byte) []AuthMessage {
+	var ret []AuthMessage
+	for len(payload) >= 4 {
+		if IsChannelData(payload) {
+			// TCP streams pad channel data to a multiple of 4 bytes
+			length := int(align(binary.BigEndian.Uint16(payload[2:4]))) + 4
+			if length > len(payload) {
+				break
+			}
+			payload = payload[length:]
+			continue
+		}
+		if len(payload) < headerSize || !bytes.Equal(payload[4:8], MagicCookie) {
+			break
+		}
+		length := int(binary.BigEndian.Uint16(payload[2:4])) + headerSize
+		if length > len(payload) {
+			break
+		}
+		if m, ok := parseAuthMessage(payload[:length]); ok {
+			ret = append(ret, m)
+		}
+		payload = payload[length:]
+	}
+	return ret
+}
+
+// parseAuthMessage extracts the credentials of a single STUN message
+func parseAuthMessage(msg []byte) (AuthMessage, bool) {
+	s, err := fromBytes(msg)
+	if err != nil {
+		return AuthMessage{}, false
+	}
+	username := s.GetAttribute(AttrUsername)
+	integrity := s.GetAttribute(AttrMessageIntegrity)
+	if username.Type != AttrUsername || integrity.Type != AttrMessageIntegrity {
+		return AuthMessage{}, false
+	}
+
+	// find the offset of the MESSAGE-INTEGRITY attribute
+	offset := headerSize
+	for _, a := range s.Attributes {
+		if a.Type == AttrMessageIntegrity {
+			break
+		}
+		offset += 4 + int(a.Length) + int(a.padding)
+	}
+
+	// the length covers all attributes up to and including the
+	// MESSAGE-INTEGRITY so a following FINGERPRINT is excluded
+	signed := make([]byte, offset)
+	copy(signed, msg[:offset])
+	binary.BigEndian.PutUint16(signed[2:4], uint16(offset-headerSize+4+messageIntegritySize))
+
+	return AuthMessage{
+		Header:    s.Header,
+		Username:  string(username.Value),
+		Realm:     string(s.GetAttribute(AttrRealm).Value),
+		Nonce:     string(s.GetAttribute(AttrNonce).Value),
+		Integrity: integrity.Value,
+		Signed:    signed,
+	}, true
+}
@@ -0,0 +1,64 @@
+package internal
+
+import (
+	"testing"
+)
+
+func TestParseAuthMessages(t *testing.T) {
+	t.Parallel()
+	req := AllocateRequestAuth("user", "secret", "nonce", "realm", RequestedTransportUDP, AllocateProtocolIgnore)
+	buf, err := req.Serialize()
+	if err != nil {
+		t.Fatal(err)
+	}
+	unauth, err := AllocateRequest(RequestedTransportUDP, AllocateProtocolIgnore).Serialize()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// a TCP segment with padded channel data, an unauthenticated and an authenticated message
+	var payload []byte
+	payload = append(payload, 0x40, 0x00, 0x00, 0x03, 'a', 'b', 'c', 0x00)
+	payload = append(payload, unauth...)
+	payload = append(payload, buf...)
+
+	messages := ParseAuthMessages(payload)
+	if len(messages) != 1 {
+		t.Fatalf("expected 1 message, got %d", len(messages))
+	}
+	m := messages[0]
+	if m.Username != "user" || m.Realm != "realm" || m.Nonce != "nonce" {
+		t.Errorf("unexpected credentials %+v", m)
+	}
+	if m.Header.MessageType.Method != MsgTypeMethodAllocate {
+		t.Errorf("unexpected method %v", m.Header.MessageType.Method)
+	}
+	if !m.CheckPassword("secret") {
+		t.Error("expected the password to match")
+	}
+	if m.CheckPassword("wrong") {
+		t.Error("expected a wrong password to not match")
+	}
+}
+
+func TestParseAuthMessagesInvalid(t *testing.T) {
+	t.Parallel()
+	var tests = []struct {
+		testName string
+		input    []byte
+	}{
+		{"Empty", nil},
+		{"No STUN", []byte("GET / HTTP/1.1\r\n\r\n")},
+		{"Truncated", []byte{0x00, 0x03, 0x00, 0x40, 0x21, 0x12, 0xa4, 0x42, 0x00}},
+		{"Truncated channel data", []byte{0x40, 0x00, 0x00, 0xff, 0x01}},
+	}
+	for _, tt := range tests {
+		tt := tt // NOTE: https://github.com/golang/go/wiki/CommonMistakes#using-goroutines-on-loop-iterator-variables
+		t.Run(tt.testName, func(t *testing.T) {
+			t.Parallel()
+			if m := ParseAuthMessages(tt.input); len(m) != 0 {
+				t.Errorf("expected no messages, got %+v", m)
+			}
+		})
+	}
+}
@@ -22,7 +22,12 @@ func fromBytes(data []byte) (*Stun, error) {
 		return nil, fmt.Errorf("attribute message size (%d) missmatch to received data (%d). extra data: %s", expectedPacketSize, len(data), extraData)
 	}
 	attributesRaw := data[headerSize:expectedPacketSize]
-	t.Attributes = parseAttributes(attributesRaw)
+	attributes, err := parseAttributes(attributesRaw)
+	if err != nil {
+		return nil, err
+	}
+	t.Attributes = attributes
+	t.raw = data
 	return t, nil
 }
 
@@ -56,31 +61,30 @@ func parseSTUNMessageType(msgType []byte) MessageType {
 	}
 }
 
-func parseAttributes(attributes []byte) []Attribute {
+func parseAttributes(attributes []byte) ([]Attribute, error) {
 	var attrs []Attribute
-	if len(attributes) == 0 {
-		return attrs
-	}
-	attrsRemaining := true
 	inLength := len(attributes)
-	var bufPos uint16
-	for attrsRemaining {
+	bufPos := 0
+	for bufPos < inLength {
+		if bufPos+4 > inLength {
+			return nil, fmt.Errorf("truncated attribute header at offset %d", bufPos)
+		}
 		attr := Attribute{}
 		attr.Type = AttributeType(binary.BigEndian.Uint16(attributes[bufPos : 2+bufPos]))
 		bufPos += 2
 		attr.Length = binary.BigEndian.Uint16(attributes[bufPos : 2+bufPos])
 		bufPos += 2
-		attr.Value = attributes[bufPos : attr.Length+bufPos]
-		bufPos += attr.Length
+		if bufPos+int(attr.Length) > inLength {
+			return nil, fmt.Errorf("attribute %d with length %d exceeds the message", attr.Type, attr.Length)
+		}
+		attr.Value = attributes[bufPos : int(attr.Length)+bufPos]
+		bufPos += int(attr.Length)
 		// Padding
-		if rem := bufPos % 4; rem != 0 {
+		if rem := uint16(bufPos % 4); rem != 0 {
 			attr.padding = 4 - rem
-			bufPos += attr.padding
-		}
-		if int(bufPos) >= inLength {
-			attrsRemaining = false
+			bufPos += int(attr.padding)
 		}
 		attrs = append(attrs, attr)
 	}
-	return attrs
+	return attrs, nil
 }
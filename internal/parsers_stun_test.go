@@ -67,3 +67,38 @@ func TestFromBytesFail(t *testing.T) {
 		})
 	}
 }
+
+func TestParseError(t *testing.T) {
+	t.Parallel()
+
+	var tests = []struct {
+		testName     string
+		input        string
+		expectedCode ErrorCode
+		expectedText string
+	}{
+		{"Unauthorized", "00000401556e617574686f72697a6564", ErrorUnauthorized, "Unauthorized"},
+		// same code with a localized reason phrase
+		{"Localized", "00000401" + hex.EncodeToString([]byte("Nicht autorisiert")), ErrorUnauthorized, "Nicht autorisiert"},
+		{"No reason phrase", "00000508", ErrorInsufficientCapacity, ""},
+		{"Reserved bits set", "0000fc25", ErrorAllocationMismatch, ""},
+		{"Too short", "0000", 0, ""},
+	}
+	for _, tt := range tests {
+		tt := tt // NOTE: https://github.com/golang/go/wiki/CommonMistakes#using-goroutines-on-loop-iterator-variables
+		t.Run(tt.testName, func(t *testing.T) {
+			t.Parallel()
+			in, err := hex.DecodeString(tt.input)
+			if err != nil {
+				t.Fatalf("invalid input on %s: %v", tt.testName, err)
+			}
+			e := ParseError(in)
+			if e.ErrorCode != tt.expectedCode {
+				t.Errorf("expected code %d but got %d", tt.expectedCode, e.ErrorCode)
+			}
+			if e.ErrorText != tt.expectedText {
+				t.Errorf("expected text %q but got %q", tt.expectedText, e.ErrorText)
+			}
+		})
+	}
+}
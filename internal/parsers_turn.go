@@ -3,8 +3,16 @@ package internal
 import (
 	"encoding/binary"
 	"fmt"
+	"net/netip"
 )
 
+// IsChannelData returns true if buf is a ChannelData message. The first
+// two bits of STUN messages are always 0 while channel numbers start
+// with 0b01
+func IsChannelData(buf []byte) bool {
+	return len(buf) >= 4 && buf[0]&0xc0 == 0x40
+}
+
 // ExtractChannelData extracts the channel and length from a UDP data packet
 func ExtractChannelData(buf []byte) ([]byte, []byte, error) {
 	if len(buf) < 4 {
@@ -18,3 +26,65 @@ func ExtractChannelData(buf []byte) ([]byte, []byte, error) {
 	}
 	return channelNumber, data, nil
 }
+
+// SplitChannelData splits a stream into the payloads of the contained
+// ChannelData messages. STUN messages in between are skipped. Over TCP the
+// messages are padded to a multiple of 4 bytes so padded needs to be set.
+// The bytes of an incomplete message at the end are returned as rest.
+func SplitChannelData(buf []byte, padded bool) ([][]byte, []byte) {
+	var payloads [][]byte
+	for len(buf) >= 4 {
+		length := binary.BigEndian.Uint16(buf[2:4])
+		if !IsChannelData(buf) {
+			// the length of STUN messages does not include the header
+			total := int(length) + headerSize
+			if total > len(buf) {
+				break
+			}
+			buf = buf[total:]
+			continue
+		}
+		total := int(length) + 4
+		if padded {
+			total = int(align(length)) + 4
+		}
+		if total > len(buf) {
+			break
+		}
+		payloads = append(payloads, buf[4:4+int(length)])
+		buf = buf[total:]
+	}
+	return payloads, buf
+}
+
+// ExtractDataIndication returns the payload of a DATA indication
+func ExtractDataIndication(buf []byte) ([]byte, error) {
+	s, err := fromBytes(buf)
+	if err != nil {
+		return nil, err
+	}
+	if s.Header.MessageType.Class != MsgTypeClassIndication || s.Header.MessageType.Method != MsgTypeMethodDataInd {
+		return nil, fmt.Errorf("expected a data indication, got %s %s", MessageTypeMethodString(s.Header.MessageType.Method), MessageTypeClassString(s.Header.MessageType.Class))
+	}
+	return s.GetAttribute(AttrData).Value, nil
+}
+
+// ParseDataIndication returns the peer and the payload of a DATA indication
+func ParseDataIndication(buf []byte) (netip.AddrPort, []byte, error) {
+	s, err := fromBytes(buf)
+	if err != nil {
+		return netip.AddrPort{}, nil, err
+	}
+	if s.Header.MessageType.Class != MsgTypeClassIndication || s.Header.MessageType.Method != MsgTypeMethodDataInd {
+		return netip.AddrPort{}, nil, fmt.Errorf("expected a data indication, got %s %s", MessageTypeMethodString(s.Header.MessageType.Method), MessageTypeClassString(s.Header.MessageType.Class))
+	}
+	host, port, err := ConvertXORAddr(s.GetAttribute(AttrXorPeerAddress).Value, s.Header.TransactionID)
+	if err != nil {
+		return netip.AddrPort{}, nil, fmt.Errorf("invalid peer address: %w", err)
+	}
+	ip, err := netip.ParseAddr(host)
+	if err != nil {
+		return netip.AddrPort{}, nil, fmt.Errorf("invalid peer address: %w", err)
+	}
+	return netip.AddrPortFrom(ip, port), s.GetAttribute(AttrData).Value, nil
+}
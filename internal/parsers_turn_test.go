@@ -1 +1,114 @@
 package internal
+
+import (
+	"bytes"
+	"net/netip"
+	"testing"
+)
+
+func TestExtractDataIndication(t *testing.T) {
+	t.Parallel()
+	s := newStun()
+	s.Header.MessageType = MessageType{
+		Class:  MsgTypeClassIndication,
+		Method: MsgTypeMethodDataInd,
+	}
+	s.Attributes = []Attribute{{
+		Type:  AttrData,
+		Value: []byte("hello"),
+	}}
+	buf, err := s.Serialize()
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := ExtractDataIndication(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(data, []byte("hello")) {
+		t.Errorf("expected %q, got %q", "hello", data)
+	}
+
+	s.Header.MessageType.Method = MsgTypeMethodSend
+	buf, err = s.Serialize()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ExtractDataIndication(buf); err == nil {
+		t.Error("expected an error on a send indication")
+	}
+}
+
+func TestParseDataIndication(t *testing.T) {
+	t.Parallel()
+	s := newStun()
+	peer := netip.MustParseAddrPort("10.0.0.5:5355")
+	peerXOR, err := xorAddr(peer.Addr(), peer.Port(), []byte(s.Header.TransactionID))
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.Header.MessageType = MessageType{
+		Class:  MsgTypeClassIndication,
+		Method: MsgTypeMethodDataInd,
+	}
+	s.Attributes = []Attribute{{
+		Type:  AttrXorPeerAddress,
+		Value: peerXOR,
+	}, {
+		Type:  AttrData,
+		Value: []byte("hello"),
+	}}
+	buf, err := s.Serialize()
+	if err != nil {
+		t.Fatal(err)
+	}
+	from, data, err := ParseDataIndication(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if from != peer {
+		t.Errorf("expected peer %s, got %s", peer, from)
+	}
+	if !bytes.Equal(data, []byte("hello")) {
+		t.Errorf("expected %q, got %q", "hello", data)
+	}
+
+	s.Attributes = s.Attributes[1:]
+	buf, err = s.Serialize()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := ParseDataIndication(buf); err == nil {
+		t.Error("expected an error on a missing peer address")
+	}
+}
+
+func TestSplitChannelData(t *testing.T) {
+	t.Parallel()
+	refresh, err := RefreshRequest("user", "pass", "nonce", "realm").Serialize()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var stream []byte
+	stream = append(stream, 0x40, 0x00, 0x00, 0x05, 'h', 'e', 'l', 'l', 'o', 0x00, 0x00, 0x00)
+	stream = append(stream, refresh...)
+	stream = append(stream, 0x40, 0x00, 0x00, 0x04, 't', 'e', 's', 't')
+	stream = append(stream, 0x40, 0x00, 0x00, 0x08, 'i', 'n', 'c')
+
+	payloads, rest := SplitChannelData(stream, true)
+	if len(payloads) != 2 {
+		t.Fatalf("expected 2 payloads, got %d", len(payloads))
+	}
+	if !bytes.Equal(payloads[0], []byte("hello")) || !bytes.Equal(payloads[1], []byte("test")) {
+		t.Errorf("unexpected payloads %q", payloads)
+	}
+	if len(rest) != 7 {
+		t.Errorf("expected 7 remaining bytes, got %d", len(rest))
+	}
+
+	// without padding the next message starts directly after the payload
+	payloads, rest = SplitChannelData([]byte{0x40, 0x00, 0x00, 0x01, 'a', 0x40, 0x00, 0x00, 0x01, 'b'}, false)
+	if len(payloads) != 2 || len(rest) != 0 || !bytes.Equal(payloads[1], []byte("b")) {
+		t.Errorf("unexpected result %q %q", payloads, rest)
+	}
+}
@@ -0,0 +1,356 @@
+// Package pcap reads the UDP and TCP payloads of pcap and pcapng files
+// without depending on libpcap
+package pcap
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net/netip"
+	"os"
+	"time"
+)
+
+// link types from https://www.tcpdump.org/linktypes.html
+const (
+	linkTypeNull     = 0
+	linkTypeEthernet = 1
+	linkTypeRaw      = 101
+	linkTypeLinuxSLL = 113
+	// some systems use these values for raw IP
+	linkTypeRawAlt1   = 12
+	linkTypeRawAlt2   = 14
+	linkTypeIPv4      = 228
+	linkTypeIPv6      = 229
+	linkTypeLinuxSLL2 = 276
+)
+
+// pcapng block types
+const (
+	blockSectionHeader        = 0x0a0d0d0a
+	blockInterfaceDescription = 0x00000001
+	blockSimplePacket         = 0x00000003
+	blockEnhancedPacket       = 0x00000006
+)
+
+// ErrUnknownFormat is returned if the file is neither a pcap nor a pcapng file
+var ErrUnknownFormat = errors.New("unknown capture file format")
+
+// Packet is the payload of a single UDP datagram or TCP segment
+type Packet struct {
+	Time     time.Time
+	Src      netip.AddrPort
+	Dst      netip.AddrPort
+	Protocol string
+	Payload  []byte
+}
+
+// ReadFile reads all UDP and TCP packets with a payload from a pcap or pcapng file
+func ReadFile(filename string) ([]Packet, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return Read(f)
+}
+
+// Read reads all UDP and TCP packets with a payload from a pcap or pcapng
+// stream. Packets that can not be decoded are skipped.
+func Read(r io.Reader) ([]Packet, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	if len(data) < 4 {
+		return nil, ErrUnknownFormat
+	}
+	if binary.LittleEndian.Uint32(data) == blockSectionHeader {
+		return readPcapNG(data)
+	}
+	return readPcap(data)
+}
+
+// readPcap reads the classic libpcap format
+func readPcap(data []byte) ([]Packet, error) {
+	if len(data) < 24 {
+		return nil, ErrUnknownFormat
+	}
+	var order binary.ByteOrder
+	nano := false
+	switch {
+	case bytes.Equal(data[:4], []byte{0xd4, 0xc3, 0xb2, 0xa1}):
+		order = binary.LittleEndian
+	case bytes.Equal(data[:4], []byte{0xa1, 0xb2, 0xc3, 0xd4}):
+		order = binary.BigEndian
+	case bytes.Equal(data[:4], []byte{0x4d, 0x3c, 0xb2, 0xa1}):
+		order = binary.LittleEndian
+		nano = true
+	case bytes.Equal(data[:4], []byte{0xa1, 0xb2, 0x3c, 0x4d}):
+		order = binary.BigEndian
+		nano = true
+	default:
+		return nil, ErrUnknownFormat
+	}
+	linkType := order.Uint32(data[20:24]) & 0x0fffffff
+
+	var ret []Packet
+	pos := 24
+	for pos+16 <= len(data) {
+		sec := int64(order.Uint32(data[pos : pos+4]))
+		frac := int64(order.Uint32(data[pos+4 : pos+8]))
+		capLen := int(order.Uint32(data[pos+8 : pos+12]))
+		pos += 16
+		if pos+capLen > len(data) {
+			return ret, fmt.Errorf("truncated packet at offset %d", pos)
+		}
+		if !nano {
+			frac *= 1000
+		}
+		if p, ok := decodeLink(linkType, data[pos:pos+capLen]); ok {
+			p.Time = time.Unix(sec, frac)
+			ret = append(ret, p)
+		}
+		pos += capLen
+	}
+	return ret, nil
+}
+
+// pcapngInterface holds the information of an interface description block
+type pcapngInterface struct {
+	linkType uint32
+	// tsUnit is the duration of one timestamp tick
+	tsUnit time.Duration
+}
+
+// readPcapNG reads the pcapng format. Multiple sections are supported
+func readPcapNG(data []byte) ([]Packet, error) {
+	var ret []Packet
+	var order binary.ByteOrder = binary.LittleEndian
+	var interfaces []pcapngInterface
+	pos := 0
+	for pos+12 <= len(data) {
+		if binary.LittleEndian.Uint32(data[pos:pos+4]) == blockSectionHeader {
+			// the byte order magic defines the order of the section
+			switch {
+			case bytes.Equal(data[pos+8:pos+12], []byte{0x4d, 0x3c, 0x2b, 0x1a}):
+				order = binary.LittleEndian
+			case bytes.Equal(data[pos+8:pos+12], []byte{0x1a, 0x2b, 0x3c, 0x4d}):
+				order = binary.BigEndian
+			default:
+				return ret, fmt.Errorf("invalid byte order magic at offset %d", pos)
+			}
+			interfaces = nil
+		}
+		blockType := order.Uint32(data[pos : pos+4])
+		blockLen := int(order.Uint32(data[pos+4 : pos+8]))
+		if blockLen < 12 || pos+blockLen > len(data) {
+			return ret, fmt.Errorf("invalid block length %d at offset %d", blockLen, pos)
+		}
+		body := data[pos+8 : pos+blockLen-4]
+		pos += blockLen
+
+		switch blockType {
+		case blockInterfaceDescription:
+			if len(body) < 8 {
+				continue
+			}
+			interfaces = append(interfaces, pcapngInterface{
+				linkType: uint32(order.Uint16(body[0:2])),
+				tsUnit:   pcapngTimestampUnit(body[8:], order),
+			})
+		case blockEnhancedPacket:
+			if len(body) < 20 {
+				continue
+			}
+			id := int(order.Uint32(body[0:4]))
+			if id >= len(interfaces) {
+				continue
+			}
+			ts := uint64(order.Uint32(body[4:8]))<<32 | uint64(order.Uint32(body[8:12]))
+			capLen := int(order.Uint32(body[12:16]))
+			if 20+capLen > len(body) {
+				continue
+			}
+			if p, ok := decodeLink(interfaces[id].linkType, body[20:20+capLen]); ok {
+				p.Time = time.Unix(0, 0).Add(time.Duration(ts) * interfaces[id].tsUnit)
+				ret = append(ret, p)
+			}
+		case blockSimplePacket:
+			// simple packets belong to the first interface and have no timestamp
+			if len(body) < 4 || len(interfaces) == 0 {
+				continue
+			}
+			capLen := int(order.Uint32(body[0:4]))
+			if 4+capLen > len(body) {
+				capLen = len(body) - 4
+			}
+			if p, ok := decodeLink(interfaces[0].linkType, body[4:4+capLen]); ok {
+				ret = append(ret, p)
+			}
+		}
+	}
+	return ret, nil
+}
+
+// pcapngTimestampUnit returns the timestamp resolution from the if_tsresol
+// option of an interface. The default is microseconds
+func pcapngTimestampUnit(options []byte, order binary.ByteOrder) time.Duration {
+	for len(options) >= 4 {
+		code := order.Uint16(options[0:2])
+		length := int(order.Uint16(options[2:4]))
+		if code == 0 || 4+length > len(options) {
+			break
+		}
+		if code == 9 && length == 1 {
+			resol := options[4]
+			unit := time.Second
+			if resol&0x80 == 0 {
+				for i := byte(0); i < resol && unit > 1; i++ {
+					unit /= 10
+				}
+			} else {
+				for i := byte(0); i < resol&0x7f && unit > 1; i++ {
+					unit /= 2
+				}
+			}
+			return unit
+		}
+		// options are padded to 32 bits
+		options = options[4+(length+3)&^3:]
+	}
+	return time.Microsecond
+}
+
+// decodeLink strips the link layer header
+func decodeLink(linkType uint32, data []byte) (Packet, bool) {
+	switch linkType {
+	case linkTypeEthernet:
+		if len(data) < 14 {
+			return Packet{}, false
+		}
+		etherType := binary.BigEndian.Uint16(data[12:14])
+		data = data[14:]
+		// skip VLAN tags
+		for (etherType == 0x8100 || etherType == 0x88a8) && len(data) >= 4 {
+			etherType = binary.BigEndian.Uint16(data[2:4])
+			data = data[4:]
+		}
+		return decodeIP(data)
+	case linkTypeLinuxSLL:
+		if len(data) < 16 {
+			return Packet{}, false
+		}
+		return decodeIP(data[16:])
+	case linkTypeLinuxSLL2:
+		if len(data) < 20 {
+			return Packet{}, false
+		}
+		return decodeIP(data[20:])
+	case linkTypeNull:
+		// the family uses the byte order of the capturing host so the IP version is used instead
+		if len(data) < 4 {
+			return Packet{}, false
+		}
+		return decodeIP(data[4:])
+	case linkTypeRaw, linkTypeRawAlt1, linkTypeRawAlt2, linkTypeIPv4, linkTypeIPv6:
+		return decodeIP(data)
+	}
+	return Packet{}, false
+}
+
+// decodeIP decodes an IPv4 or IPv6 packet based on the version
+func decodeIP(data []byte) (Packet, bool) {
+	if len(data) < 1 {
+		return Packet{}, false
+	}
+	switch data[0] >> 4 {
+	case 4:
+		return decodeIPv4(data)
+	case 6:
+		return decodeIPv6(data)
+	}
+	return Packet{}, false
+}
+
+func decodeIPv4(data []byte) (Packet, bool) {
+	if len(data) < 20 {
+		return Packet{}, false
+	}
+	headerLen := int(data[0]&0x0f) * 4
+	totalLen := int(binary.BigEndian.Uint16(data[2:4]))
+	// fragments are not reassembled
+	if binary.BigEndian.Uint16(data[6:8])&0x3fff != 0 {
+		return Packet{}, false
+	}
+	if headerLen < 20 || totalLen < headerLen || len(data) < headerLen {
+		return Packet{}, false
+	}
+	// the capture might be truncated or contain ethernet padding
+	if totalLen < len(data) {
+		data = data[:totalLen]
+	}
+	src, _ := netip.AddrFromSlice(data[12:16])
+	dst, _ := netip.AddrFromSlice(data[16:20])
+	return decodeTransport(data[9], src, dst, data[headerLen:])
+}
+
+func decodeIPv6(data []byte) (Packet, bool) {
+	if len(data) < 40 {
+		return Packet{}, false
+	}
+	payloadLen := int(binary.BigEndian.Uint16(data[4:6]))
+	next := data[6]
+	src, _ := netip.AddrFromSlice(data[8:24])
+	dst, _ := netip.AddrFromSlice(data[24:40])
+	payload := data[40:]
+	if payloadLen < len(payload) {
+		payload = payload[:payloadLen]
+	}
+	// skip hop-by-hop, routing and destination options headers
+	for next == 0 || next == 43 || next == 60 {
+		if len(payload) < 8 {
+			return Packet{}, false
+		}
+		extLen := (int(payload[1]) + 1) * 8
+		if extLen > len(payload) {
+			return Packet{}, false
+		}
+		next = payload[0]
+		payload = payload[extLen:]
+	}
+	return decodeTransport(next, src, dst, payload)
+}
+
+// decodeTransport decodes UDP and TCP. Packets without payload are skipped
+func decodeTransport(protocol byte, src, dst netip.Addr, data []byte) (Packet, bool) {
+	var p Packet
+	switch protocol {
+	case 17:
+		if len(data) < 8 {
+			return Packet{}, false
+		}
+		p.Protocol = "udp"
+		p.Payload = data[8:]
+	case 6:
+		if len(data) < 20 {
+			return Packet{}, false
+		}
+		offset := int(data[12]>>4) * 4
+		if offset < 20 || offset > len(data) {
+			return Packet{}, false
+		}
+		p.Protocol = "tcp"
+		p.Payload = data[offset:]
+	default:
+		return Packet{}, false
+	}
+	if len(p.Payload) == 0 {
+		return Packet{}, false
+	}
+	p.Src = netip.AddrPortFrom(src.Unmap(), binary.BigEndian.Uint16(data[0:2]))
+	p.Dst = netip.AddrPortFrom(dst.Unmap(), binary.BigEndian.Uint16(data[2:4]))
+	return p, true
+}
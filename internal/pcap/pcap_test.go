@@ -0,0 +1,132 @@
+package pcap
+
+import (
+	"bytes"
+	"encoding/binary"
+	"net/netip"
+	"testing"
+)
+
+func udpIPv4(payload []byte) []byte {
+	udp := make([]byte, 8)
+	binary.BigEndian.PutUint16(udp[0:2], 50000)
+	binary.BigEndian.PutUint16(udp[2:4], 3478)
+	binary.BigEndian.PutUint16(udp[4:6], uint16(8+len(payload)))
+	udp = append(udp, payload...)
+
+	ip := make([]byte, 20)
+	ip[0] = 0x45
+	binary.BigEndian.PutUint16(ip[2:4], uint16(20+len(udp)))
+	ip[9] = 17
+	copy(ip[12:16], []byte{10, 0, 0, 1})
+	copy(ip[16:20], []byte{192, 0, 2, 10})
+	return append(ip, udp...)
+}
+
+func tcpIPv6(payload []byte) []byte {
+	tcp := make([]byte, 20)
+	binary.BigEndian.PutUint16(tcp[0:2], 50000)
+	binary.BigEndian.PutUint16(tcp[2:4], 443)
+	tcp[12] = 5 << 4
+	tcp = append(tcp, payload...)
+
+	ip := make([]byte, 40)
+	ip[0] = 0x60
+	binary.BigEndian.PutUint16(ip[4:6], uint16(len(tcp)))
+	ip[6] = 6
+	copy(ip[8:24], netip.MustParseAddr("fd00::1").AsSlice())
+	copy(ip[24:40], netip.MustParseAddr("2001:db8::1").AsSlice())
+	return append(ip, tcp...)
+}
+
+func TestReadPcap(t *testing.T) {
+	t.Parallel()
+	ethernet := append([]byte{0, 1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 0x08, 0x00}, udpIPv4([]byte("hello"))...)
+
+	var buf bytes.Buffer
+	header := make([]byte, 24)
+	copy(header, []byte{0xd4, 0xc3, 0xb2, 0xa1})
+	binary.LittleEndian.PutUint32(header[20:24], linkTypeEthernet)
+	buf.Write(header)
+	record := make([]byte, 16)
+	binary.LittleEndian.PutUint32(record[0:4], 1600000000)
+	binary.LittleEndian.PutUint32(record[4:8], 500)
+	binary.LittleEndian.PutUint32(record[8:12], uint32(len(ethernet)))
+	binary.LittleEndian.PutUint32(record[12:16], uint32(len(ethernet)))
+	buf.Write(record)
+	buf.Write(ethernet)
+
+	packets, err := Read(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(packets) != 1 {
+		t.Fatalf("expected 1 packet, got %d", len(packets))
+	}
+	p := packets[0]
+	if p.Protocol != "udp" || string(p.Payload) != "hello" {
+		t.Errorf("unexpected packet %+v", p)
+	}
+	if p.Src.String() != "10.0.0.1:50000" || p.Dst.String() != "192.0.2.10:3478" {
+		t.Errorf("unexpected addresses %s -> %s", p.Src, p.Dst)
+	}
+	if p.Time.UnixMicro() != 1600000000000500 {
+		t.Errorf("unexpected time %s", p.Time)
+	}
+}
+
+func pcapngBlock(blockType uint32, body []byte) []byte {
+	for len(body)%4 != 0 {
+		body = append(body, 0)
+	}
+	block := make([]byte, 8)
+	binary.LittleEndian.PutUint32(block[0:4], blockType)
+	binary.LittleEndian.PutUint32(block[4:8], uint32(12+len(body)))
+	block = append(block, body...)
+	return binary.LittleEndian.AppendUint32(block, uint32(12+len(body)))
+}
+
+func TestReadPcapNG(t *testing.T) {
+	t.Parallel()
+	packet := tcpIPv6([]byte("data"))
+
+	var buf bytes.Buffer
+	shb := []byte{0x4d, 0x3c, 0x2b, 0x1a, 1, 0, 0, 0, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff}
+	buf.Write(pcapngBlock(blockSectionHeader, shb))
+	idb := make([]byte, 8)
+	binary.LittleEndian.PutUint16(idb[0:2], linkTypeRaw)
+	// if_tsresol nanoseconds
+	idb = append(idb, 9, 0, 1, 0, 9, 0, 0, 0)
+	buf.Write(pcapngBlock(blockInterfaceDescription, idb))
+	epb := make([]byte, 20)
+	binary.LittleEndian.PutUint32(epb[4:8], 0)
+	binary.LittleEndian.PutUint32(epb[8:12], 1000)
+	binary.LittleEndian.PutUint32(epb[12:16], uint32(len(packet)))
+	binary.LittleEndian.PutUint32(epb[16:20], uint32(len(packet)))
+	buf.Write(pcapngBlock(blockEnhancedPacket, append(epb, packet...)))
+
+	packets, err := Read(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(packets) != 1 {
+		t.Fatalf("expected 1 packet, got %d", len(packets))
+	}
+	p := packets[0]
+	if p.Protocol != "tcp" || string(p.Payload) != "data" {
+		t.Errorf("unexpected packet %+v", p)
+	}
+	if p.Src.String() != "[fd00::1]:50000" || p.Dst.String() != "[2001:db8::1]:443" {
+		t.Errorf("unexpected addresses %s -> %s", p.Src, p.Dst)
+	}
+	if p.Time.UnixNano() != 1000 {
+		t.Errorf("unexpected time %d", p.Time.UnixNano())
+	}
+}
+
+func TestReadInvalid(t *testing.T) {
+	t.Parallel()
+	if _, err := Read(bytes.NewReader([]byte("no capture file at all"))); err == nil {
+		t.Error("expected an error")
+	}
+}
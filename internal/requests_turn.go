@@ -4,6 +4,8 @@ import (
 	"encoding/binary"
 	"fmt"
 	"net/netip"
+
+	"github.com/firefart/stunner/internal/helper"
 )
 
 // AllocateRequest returns an ALLOCATE request
@@ -100,6 +102,38 @@ func SendRequest(target netip.Addr, port uint16) (*Stun, error) {
 	return s, nil
 }
 
+// SendIndication returns a SEND indication carrying data to the target. If
+// dontFragment is set the server is asked to set the DF bit on the relayed
+// packet so oversized payloads are dropped instead of fragmented
+func SendIndication(target netip.Addr, port uint16, data []byte, dontFragment bool) (*Stun, error) {
+	s := newStun()
+	targetXOR, err := xorAddr(target, port, []byte(s.Header.TransactionID))
+	if err != nil {
+		return nil, err
+	}
+
+	s.Header.MessageType = MessageType{
+		Class:  MsgTypeClassIndication,
+		Method: MsgTypeMethodSend,
+	}
+
+	s.Attributes = []Attribute{{
+		Type:  AttrXorPeerAddress,
+		Value: targetXOR,
+	}, {
+		Type:  AttrData,
+		Value: data,
+	},
+	}
+	if dontFragment {
+		s.Attributes = append(s.Attributes, Attribute{
+			Type: AttrDontFragment,
+		})
+	}
+
+	return s, nil
+}
+
 // CreatePermissionRequest returns a CREATE PERMISSION request
 func CreatePermissionRequest(username, password, nonce, realm string, target netip.Addr, port uint16) (*Stun, error) {
 	s := newStun()
@@ -197,3 +231,51 @@ func RefreshRequest(username, password, nonce, realm string) *Stun {
 
 	return s
 }
+
+// DeallocateRequest returns a REFRESH request with a lifetime of 0 which
+// deletes the allocation on the server
+func DeallocateRequest(username, password, nonce, realm string) *Stun {
+	s := RefreshRequest(username, password, nonce, realm)
+	s.Attributes = append(s.Attributes, Attribute{
+		Type:  AttrLifetime,
+		Value: helper.PutUint32(0),
+	})
+	return s
+}
+
+// CreatePermissionsRequest returns a CREATE PERMISSION request installing
+// permissions for all targets at once. The server either grants or denies
+// the whole request
+func CreatePermissionsRequest(username, password, nonce, realm string, targets []netip.Addr) (*Stun, error) {
+	s := newStun()
+	s.Username = username
+	s.Password = password
+	s.Header.MessageType = MessageType{
+		Class:  MsgTypeClassRequest,
+		Method: MsgTypeMethodCreatePermission,
+	}
+
+	for _, target := range targets {
+		// the port is ignored for permissions
+		targetXOR, err := xorAddr(target, 0, []byte(s.Header.TransactionID))
+		if err != nil {
+			return nil, err
+		}
+		s.Attributes = append(s.Attributes, Attribute{
+			Type:  AttrXorPeerAddress,
+			Value: targetXOR,
+		})
+	}
+	s.Attributes = append(s.Attributes, Attribute{
+		Type:  AttrUsername,
+		Value: []byte(username),
+	}, Attribute{
+		Type:  AttrRealm,
+		Value: []byte(realm),
+	}, Attribute{
+		Type:  AttrNonce,
+		Value: []byte(nonce),
+	})
+
+	return s, nil
+}
@@ -0,0 +1,82 @@
+package results
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+
+	"filippo.io/age"
+)
+
+// ageHeader is the start of every age encrypted file
+var ageHeader = []byte("age-encryption.org/")
+
+var (
+	encryptMu  sync.Mutex
+	recipients []age.Recipient
+	// openWriters are all encrypted writers that are not closed yet
+	openWriters = make(map[*Writer]struct{})
+)
+
+// ParseRecipients parses age recipients in the format age1... or files
+// containing one recipient per line like the -R option of age
+func ParseRecipients(specs []string) ([]age.Recipient, error) {
+	var ret []age.Recipient
+	for _, spec := range specs {
+		if strings.HasPrefix(spec, "age1") {
+			r, err := age.ParseX25519Recipient(spec)
+			if err != nil {
+				return nil, fmt.Errorf("invalid recipient %q: %w", spec, err)
+			}
+			ret = append(ret, r)
+			continue
+		}
+		f, err := os.Open(spec)
+		if err != nil {
+			return nil, fmt.Errorf("could not read recipients file: %w", err)
+		}
+		r, err := age.ParseRecipients(f)
+		f.Close()
+		if err != nil {
+			return nil, fmt.Errorf("invalid recipients file %s: %w", spec, err)
+		}
+		ret = append(ret, r...)
+	}
+	return ret, nil
+}
+
+// SetRecipients encrypts all output files created afterwards to the
+// recipients. Without recipients the files are written in plain text
+func SetRecipients(r []age.Recipient) {
+	encryptMu.Lock()
+	defer encryptMu.Unlock()
+	recipients = r
+}
+
+func currentRecipients() []age.Recipient {
+	encryptMu.Lock()
+	defer encryptMu.Unlock()
+	return recipients
+}
+
+// CloseEncrypted closes all open encrypted writers. age only writes
+// the last chunk on close so this needs to be called before exiting
+// on an interrupt, otherwise the files can not be decrypted
+func CloseEncrypted() {
+	encryptMu.Lock()
+	writers := make([]*Writer, 0, len(openWriters))
+	for w := range openWriters {
+		writers = append(writers, w)
+	}
+	encryptMu.Unlock()
+	for _, w := range writers {
+		_ = w.Close()
+	}
+}
+
+// isEncrypted returns true if the file content is age encrypted
+func isEncrypted(buf []byte) bool {
+	return bytes.HasPrefix(buf, ageHeader)
+}
@@ -0,0 +1,92 @@
+package results
+
+import (
+	"bufio"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"filippo.io/age"
+)
+
+// not parallel as the recipients are global
+func TestEncryptedWriter(t *testing.T) {
+	identity, err := age.GenerateX25519Identity()
+	if err != nil {
+		t.Fatal(err)
+	}
+	recipients, err := ParseRecipients([]string{identity.Recipient().String()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	SetRecipients(recipients)
+	defer SetRecipients(nil)
+
+	filename := filepath.Join(t.TempDir(), "results.jsonl.age")
+	w, err := NewWriter(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Write(Finding{Module: "test", Host: "10.0.0.1"}); err != nil {
+		t.Fatal(err)
+	}
+	CloseEncrypted()
+	// the deferred close of the caller must not fail afterwards
+	if err := w.Close(); err != nil {
+		t.Errorf("second close returned %v", err)
+	}
+	if err := w.Write(Finding{Module: "test"}); err == nil {
+		t.Error("expected an error on writing to a closed writer")
+	}
+
+	if _, err := ReadFile(filename); err == nil {
+		t.Error("expected an error on reading an encrypted file")
+	}
+	if _, err := NewWriter(filename); err == nil {
+		t.Error("expected an error on appending to an encrypted file")
+	}
+
+	f, err := os.Open(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	r, err := age.Decrypt(f, identity)
+	if err != nil {
+		t.Fatal(err)
+	}
+	scanner := bufio.NewScanner(r)
+	if !scanner.Scan() {
+		t.Fatalf("no finding in decrypted file: %v", scanner.Err())
+	}
+	var finding Finding
+	if err := json.Unmarshal(scanner.Bytes(), &finding); err != nil {
+		t.Fatal(err)
+	}
+	if finding.Host != "10.0.0.1" {
+		t.Errorf("unexpected finding %+v", finding)
+	}
+}
+
+func TestParseRecipients(t *testing.T) {
+	t.Parallel()
+	identity, err := age.GenerateX25519Identity()
+	if err != nil {
+		t.Fatal(err)
+	}
+	filename := filepath.Join(t.TempDir(), "recipients.txt")
+	if err := os.WriteFile(filename, []byte("# team key\n"+identity.Recipient().String()+"\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	r, err := ParseRecipients([]string{identity.Recipient().String(), filename})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(r) != 2 {
+		t.Errorf("expected 2 recipients, got %d", len(r))
+	}
+	if _, err := ParseRecipients([]string{"age1invalid"}); err == nil {
+		t.Error("expected an error on an invalid recipient")
+	}
+}
@@ -0,0 +1,136 @@
+package results
+
+import (
+	"fmt"
+	"net/netip"
+	"sort"
+)
+
+// key identifies the same finding across multiple runs
+func (f Finding) key() string {
+	return fmt.Sprintf("%s|%d|%s|%s", f.Host, f.Port, f.Protocol, f.Service)
+}
+
+// Merge dedupes the findings by host, port, protocol and service. The merged
+// finding keeps the earliest time and start, the latest end, the details of all findings (earlier
+// values win) and all relays the finding was seen through in Relays. The
+// scan IDs of all runs that produced the finding are kept in ScanIDs.
+func Merge(findings []Finding) []Finding {
+	merged := make(map[string]*Finding)
+	var order []string
+	for _, f := range findings {
+		k := f.key()
+		m, ok := merged[k]
+		if !ok {
+			f := f
+			f.Relays = f.AllRelays()
+			f.ScanIDs = f.AllScanIDs()
+			f.Details = copyDetails(f.Details)
+			f.Metadata = copyDetails(f.Metadata)
+			merged[k] = &f
+			order = append(order, k)
+			continue
+		}
+		if !f.Time.IsZero() && (m.Time.IsZero() || f.Time.Before(m.Time)) {
+			m.Time = f.Time
+		}
+		if f.Start != nil && (m.Start == nil || f.Start.Before(*m.Start)) {
+			m.Start = f.Start
+		}
+		if f.End != nil && (m.End == nil || f.End.After(*m.End)) {
+			m.End = f.End
+		}
+		m.Relays = uniqueStrings(append(m.Relays, f.AllRelays()...))
+		m.ScanIDs = uniqueStrings(append(m.ScanIDs, f.AllScanIDs()...))
+		if m.Error == "" {
+			m.Error = f.Error
+		}
+		for key, value := range f.Details {
+			if m.Details == nil {
+				m.Details = make(map[string]string)
+			}
+			if _, ok := m.Details[key]; !ok {
+				m.Details[key] = value
+			}
+		}
+		for key, value := range f.Metadata {
+			if m.Metadata == nil {
+				m.Metadata = make(map[string]string)
+			}
+			if _, ok := m.Metadata[key]; !ok {
+				m.Metadata[key] = value
+			}
+		}
+	}
+
+	ret := make([]Finding, 0, len(order))
+	for _, k := range order {
+		f := merged[k]
+		sort.Strings(f.Relays)
+		if len(f.Relays) > 0 {
+			f.Relay = f.Relays[0]
+		}
+		// scan IDs start with the time so the first one is the earliest run
+		sort.Strings(f.ScanIDs)
+		if len(f.ScanIDs) > 0 {
+			f.ScanID = f.ScanIDs[0]
+		}
+		ret = append(ret, *f)
+	}
+	sort.SliceStable(ret, func(i, j int) bool {
+		if ret[i].Host != ret[j].Host {
+			return hostLess(ret[i].Host, ret[j].Host)
+		}
+		if ret[i].Port != ret[j].Port {
+			return ret[i].Port < ret[j].Port
+		}
+		if ret[i].Protocol != ret[j].Protocol {
+			return ret[i].Protocol < ret[j].Protocol
+		}
+		return ret[i].Service < ret[j].Service
+	})
+	return ret
+}
+
+// HostRelays returns all relays each host was reachable through. Findings
+// that only record an error are ignored
+func HostRelays(findings []Finding) map[string][]string {
+	ret := make(map[string][]string)
+	for _, f := range findings {
+		if f.Error != "" && f.Service == "" {
+			continue
+		}
+		ret[f.Host] = uniqueStrings(append(ret[f.Host], f.AllRelays()...))
+	}
+	for host := range ret {
+		sort.Strings(ret[host])
+	}
+	return ret
+}
+
+// hostLess sorts IPs numerically and everything else alphabetically
+// after the IPs
+func hostLess(a, b string) bool {
+	ipA, errA := netip.ParseAddr(a)
+	ipB, errB := netip.ParseAddr(b)
+	switch {
+	case errA == nil && errB == nil:
+		return ipA.Less(ipB)
+	case errA == nil:
+		return true
+	case errB == nil:
+		return false
+	}
+	return a < b
+}
+
+func copyDetails(details map[string]string) map[string]string {
+	if details == nil {
+		return nil
+	}
+	ret := make(map[string]string, len(details))
+	for k, v := range details {
+		ret[k] = v
+	}
+	return ret
+}
@@ -0,0 +1,209 @@
+package results
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+
+	"filippo.io/age"
+)
+
+// Finding is a single result produced by a module
+type Finding struct {
+	Time     time.Time         `json:"time"`
+	Module   string            `json:"module"`
+	Relay    string            `json:"relay"`
+	Relays   []string          `json:"relays,omitempty"`
+	Host     string            `json:"host"`
+	Port     uint16            `json:"port,omitempty"`
+	Protocol string            `json:"protocol,omitempty"`
+	Service  string            `json:"service,omitempty"`
+	Details  map[string]string `json:"details,omitempty"`
+	// Error contains the full error message if the module failed on this target
+	Error string `json:"error,omitempty"`
+	// ScanID and Metadata identify the run that produced the finding
+	ScanID   string            `json:"scan_id,omitempty"`
+	ScanIDs  []string          `json:"scan_ids,omitempty"`
+	Metadata map[string]string `json:"metadata,omitempty"`
+	// Transport is the transport used to connect to the TURN server
+	Transport string `json:"transport,omitempty"`
+	// Start and End are the time the target was probed first and last
+	Start *time.Time `json:"start,omitempty"`
+	End   *time.Time `json:"end,omitempty"`
+}
+
+// AllRelays returns Relay and Relays combined and without duplicates
+func (f Finding) AllRelays() []string {
+	return uniqueStrings(append([]string{f.Relay}, f.Relays...))
+}
+
+// AllScanIDs returns ScanID and ScanIDs combined and without duplicates
+func (f Finding) AllScanIDs() []string {
+	return uniqueStrings(append([]string{f.ScanID}, f.ScanIDs...))
+}
+
+// uniqueStrings removes empty and duplicate entries keeping the order
+func uniqueStrings(in []string) []string {
+	seen := make(map[string]struct{})
+	var ret []string
+	for _, s := range in {
+		if s == "" {
+			continue
+		}
+		if _, ok := seen[s]; ok {
+			continue
+		}
+		seen[s] = struct{}{}
+		ret = append(ret, s)
+	}
+	return ret
+}
+
+// Writer writes findings as JSON lines to a file. All methods
+// are safe to call on a nil Writer so callers don't need to check
+// if an output file was requested.
+type Writer struct {
+	mu   sync.Mutex
+	file *os.File
+	// encrypted is the age stream if recipients are set
+	encrypted io.WriteCloser
+	enc       *json.Encoder
+	closed    bool
+}
+
+// NewWriter creates the output file. If filename is empty
+// a nil Writer is returned. If recipients are set with SetRecipients
+// the file is encrypted with age. As age files can not be appended
+// to, the file must not exist in this case
+func NewWriter(filename string) (*Writer, error) {
+	if filename == "" {
+		return nil, nil
+	}
+	recipients := currentRecipients()
+	if len(recipients) == 0 {
+		f, err := os.OpenFile(filename, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o600)
+		if err != nil {
+			return nil, fmt.Errorf("could not create output file: %w", err)
+		}
+		return &Writer{
+			file: f,
+			enc:  json.NewEncoder(f),
+		}, nil
+	}
+
+	f, err := os.OpenFile(filename, os.O_CREATE|os.O_WRONLY|os.O_EXCL, 0o600)
+	if err != nil {
+		if errors.Is(err, os.ErrExist) {
+			return nil, fmt.Errorf("encrypted output file %s already exists", filename)
+		}
+		return nil, fmt.Errorf("could not create output file: %w", err)
+	}
+	encrypted, err := age.Encrypt(f, recipients...)
+	if err != nil {
+		f.Close()
+		return nil, fmt.Errorf("could not encrypt output file: %w", err)
+	}
+	w := &Writer{
+		file:      f,
+		encrypted: encrypted,
+		enc:       json.NewEncoder(encrypted),
+	}
+	encryptMu.Lock()
+	openWriters[w] = struct{}{}
+	encryptMu.Unlock()
+	return w, nil
+}
+
+// Write writes a single finding. If the time is not set the
+// current time is used. Findings without a scan ID get the ID, the
+// metadata and the transport of the current run
+func (w *Writer) Write(f Finding) error {
+	if w == nil {
+		return nil
+	}
+	if f.Time.IsZero() {
+		f.Time = time.Now()
+	}
+	if f.ScanID == "" {
+		run := CurrentRun()
+		f.ScanID = run.ID
+		if f.Metadata == nil {
+			f.Metadata = run.Metadata
+		}
+		if f.Transport == "" {
+			f.Transport = run.Transport
+		}
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.closed {
+		return fmt.Errorf("could not write finding: writer is closed")
+	}
+	if err := w.enc.Encode(f); err != nil {
+		return fmt.Errorf("could not write finding: %w", err)
+	}
+	return nil
+}
+
+// Close finishes the encryption and closes the underlying file. Closing
+// an already closed Writer is a no-op
+func (w *Writer) Close() error {
+	if w == nil {
+		return nil
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.closed {
+		return nil
+	}
+	w.closed = true
+	if w.encrypted != nil {
+		encryptMu.Lock()
+		delete(openWriters, w)
+		encryptMu.Unlock()
+		if err := w.encrypted.Close(); err != nil {
+			w.file.Close()
+			return fmt.Errorf("could not finish encryption: %w", err)
+		}
+	}
+	return w.file.Close()
+}
+
+// ReadFile reads all findings from a JSON lines file
+func ReadFile(filename string) ([]Finding, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var ret []Finding
+	reader := bufio.NewReader(f)
+	if header, _ := reader.Peek(len(ageHeader)); isEncrypted(header) {
+		return nil, fmt.Errorf("%s is encrypted, decrypt it first with age -d", filename)
+	}
+	scanner := bufio.NewScanner(reader)
+	// allow long lines for big banners
+	scanner.Buffer(make([]byte, 0, 64*1024), 10*1024*1024)
+	line := 0
+	for scanner.Scan() {
+		line++
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+		var finding Finding
+		if err := json.Unmarshal(scanner.Bytes(), &finding); err != nil {
+			return nil, fmt.Errorf("invalid finding in line %d: %w", line, err)
+		}
+		ret = append(ret, finding)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return ret, nil
+}
@@ -0,0 +1,176 @@
+package results
+
+import (
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestWriterNil(t *testing.T) {
+	t.Parallel()
+	w, err := NewWriter("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if w != nil {
+		t.Fatal("expected a nil writer")
+	}
+	if err := w.Write(Finding{Module: "test"}); err != nil {
+		t.Errorf("write on nil writer returned %v", err)
+	}
+	if err := w.Close(); err != nil {
+		t.Errorf("close on nil writer returned %v", err)
+	}
+}
+
+func TestWriteAndRead(t *testing.T) {
+	t.Parallel()
+	filename := filepath.Join(t.TempDir(), "results.jsonl")
+	w, err := NewWriter(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	findings := []Finding{
+		{Module: "auto", Relay: "1.2.3.4:3478", Host: "10.0.0.1", Port: 22, Protocol: "tcp", Service: "ssh"},
+		{Module: "auto", Relay: "1.2.3.4:3478", Host: "10.0.0.2", Port: 161, Protocol: "udp", Service: "snmp", Details: map[string]string{"community": "public"}},
+	}
+	for _, f := range findings {
+		if err := w.Write(f); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	read, err := ReadFile(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(read) != len(findings) {
+		t.Fatalf("expected %d findings, got %d", len(findings), len(read))
+	}
+	for i := range findings {
+		if read[i].Time.IsZero() {
+			t.Error("time was not set")
+		}
+		if read[i].Host != findings[i].Host || read[i].Service != findings[i].Service {
+			t.Errorf("expected %+v, got %+v", findings[i], read[i])
+		}
+	}
+	if read[1].Details["community"] != "public" {
+		t.Errorf("details were not preserved: %+v", read[1].Details)
+	}
+}
+
+func TestMerge(t *testing.T) {
+	t.Parallel()
+	t1 := time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC)
+	t2 := t1.Add(time.Hour)
+	findings := []Finding{
+		{Time: t2, Module: "auto", Relay: "relay2:3478", Host: "10.0.0.10", Port: 22, Protocol: "tcp", Service: "ssh", Details: map[string]string{"product": "OpenSSH"}},
+		{Time: t1, Module: "auto", Relay: "relay1:3478", Host: "10.0.0.10", Port: 22, Protocol: "tcp", Service: "ssh", Details: map[string]string{"product": "other", "banner": "SSH-2.0"}},
+		{Time: t1, Module: "auto", Relay: "relay1:3478", Host: "10.0.0.9", Port: 161, Protocol: "udp", Service: "snmp"},
+		{Time: t1, Module: "auto", Relay: "relay1:3478", Host: "10.0.0.10", Port: 22, Protocol: "tcp", Service: "ssh"},
+	}
+	merged := Merge(findings)
+	if len(merged) != 2 {
+		t.Fatalf("expected 2 findings, got %d: %+v", len(merged), merged)
+	}
+	// IPs are sorted numerically
+	if merged[0].Host != "10.0.0.9" {
+		t.Errorf("expected 10.0.0.9 first, got %s", merged[0].Host)
+	}
+	ssh := merged[1]
+	if !ssh.Time.Equal(t1) {
+		t.Errorf("expected earliest time %s, got %s", t1, ssh.Time)
+	}
+	if !reflect.DeepEqual(ssh.Relays, []string{"relay1:3478", "relay2:3478"}) {
+		t.Errorf("unexpected relays %v", ssh.Relays)
+	}
+	if ssh.Details["product"] != "OpenSSH" || ssh.Details["banner"] != "SSH-2.0" {
+		t.Errorf("unexpected details %v", ssh.Details)
+	}
+	// input must not be modified
+	if len(findings[0].Details) != 1 {
+		t.Errorf("input details were modified: %v", findings[0].Details)
+	}
+
+	hosts := HostRelays(merged)
+	if !reflect.DeepEqual(hosts["10.0.0.10"], []string{"relay1:3478", "relay2:3478"}) {
+		t.Errorf("unexpected host relays %v", hosts["10.0.0.10"])
+	}
+}
+
+func TestHostRelaysIgnoresErrors(t *testing.T) {
+	t.Parallel()
+	findings := []Finding{
+		{Module: "auto", Relay: "relay1:3478", Host: "10.0.0.1", Error: "timeout"},
+		{Module: "auto", Relay: "relay2:3478", Host: "10.0.0.2", Port: 80, Protocol: "tcp", Service: "http", Error: "error on reading banner"},
+	}
+	hosts := HostRelays(findings)
+	if _, ok := hosts["10.0.0.1"]; ok {
+		t.Errorf("host with only an error should not be reachable: %v", hosts)
+	}
+	if !reflect.DeepEqual(hosts["10.0.0.2"], []string{"relay2:3478"}) {
+		t.Errorf("unexpected host relays %v", hosts["10.0.0.2"])
+	}
+}
+
+func TestMergeScanIDs(t *testing.T) {
+	t.Parallel()
+	findings := []Finding{
+		{Module: "auto", Relay: "relay1:3478", Host: "10.0.0.1", Port: 22, Protocol: "tcp", Service: "ssh", ScanID: "20220102T000000Z-00000002", Metadata: map[string]string{"ticket": "SEC-2"}},
+		{Module: "auto", Relay: "relay2:3478", Host: "10.0.0.1", Port: 22, Protocol: "tcp", Service: "ssh", ScanID: "20220101T000000Z-00000001", Metadata: map[string]string{"ticket": "SEC-1", "engagement": "ACME"}},
+	}
+	merged := Merge(findings)
+	if len(merged) != 1 {
+		t.Fatalf("expected 1 finding, got %d", len(merged))
+	}
+	if merged[0].ScanID != "20220101T000000Z-00000001" {
+		t.Errorf("expected the earliest scan id, got %s", merged[0].ScanID)
+	}
+	if !reflect.DeepEqual(merged[0].ScanIDs, []string{"20220101T000000Z-00000001", "20220102T000000Z-00000002"}) {
+		t.Errorf("unexpected scan ids %v", merged[0].ScanIDs)
+	}
+	if !reflect.DeepEqual(merged[0].Metadata, map[string]string{"ticket": "SEC-2", "engagement": "ACME"}) {
+		t.Errorf("unexpected metadata %v", merged[0].Metadata)
+	}
+}
+
+func TestHostTimes(t *testing.T) {
+	t.Parallel()
+	var nilTimes *HostTimes
+	nilTimes.Record("10.0.0.1", time.Now(), time.Now())
+	if len(nilTimes.Findings("auto", "relay")) != 0 {
+		t.Error("nil HostTimes returned findings")
+	}
+
+	base := time.Date(2024, 7, 1, 10, 0, 0, 0, time.UTC)
+	times := NewHostTimes()
+	times.Record("10.0.0.2", base.Add(time.Second), base.Add(2*time.Second))
+	times.Record("10.0.0.1", base.Add(3*time.Second), base.Add(4*time.Second))
+	times.Record("10.0.0.2", base, base.Add(5*time.Second))
+
+	findings := times.Findings("auto", "relay")
+	if len(findings) != 2 {
+		t.Fatalf("expected 2 findings, got %d", len(findings))
+	}
+	f := findings[0]
+	if f.Host != "10.0.0.2" || !f.Start.Equal(base) || !f.End.Equal(base.Add(5*time.Second)) {
+		t.Errorf("unexpected first finding %+v", f)
+	}
+	if f.Details["probes"] != "2" || f.Details["duration"] != "5s" {
+		t.Errorf("unexpected details %v", f.Details)
+	}
+
+	// merging keeps the earliest start and the latest end
+	later := times.Findings("auto", "relay")[0]
+	end := base.Add(time.Minute)
+	later.End = &end
+	merged := Merge([]Finding{f, later})
+	if len(merged) != 1 || !merged[0].Start.Equal(base) || !merged[0].End.Equal(end) {
+		t.Errorf("unexpected merged finding %+v", merged)
+	}
+}
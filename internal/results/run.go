@@ -0,0 +1,91 @@
+package results
+
+import (
+	"fmt"
+	"math/rand"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// Run identifies a single invocation of stunner. The scan ID and the
+// metadata are added to all written findings so results can be traced
+// back to the engagement they were created for
+type Run struct {
+	ID       string
+	Metadata map[string]string
+	// Transport is the transport used to connect to the TURN server
+	Transport string
+}
+
+var (
+	runMu      sync.RWMutex
+	currentRun Run
+)
+
+// SetRun sets the run that is added to all findings written afterwards
+func SetRun(r Run) {
+	runMu.Lock()
+	defer runMu.Unlock()
+	currentRun = r
+}
+
+// SetTransport sets the transport of the current run
+func SetTransport(transport string) {
+	runMu.Lock()
+	defer runMu.Unlock()
+	currentRun.Transport = transport
+}
+
+// CurrentRun returns the run set by SetRun
+func CurrentRun() Run {
+	runMu.RLock()
+	defer runMu.RUnlock()
+	return currentRun
+}
+
+// NewScanID returns a unique scan ID consisting of the current UTC time
+// and a random suffix so IDs sort by the start of the run
+func NewScanID() string {
+	return fmt.Sprintf("%s-%08x", time.Now().UTC().Format("20060102T150405Z"), rand.Uint32())
+}
+
+// ParseMetadata parses metadata in the format key=value, for example
+// engagement=ACME-2024-07 or ticket=SEC-1234
+func ParseMetadata(specs []string) (map[string]string, error) {
+	if len(specs) == 0 {
+		return nil, nil
+	}
+	ret := make(map[string]string, len(specs))
+	for _, spec := range specs {
+		key, value, ok := strings.Cut(spec, "=")
+		key = strings.TrimSpace(key)
+		if !ok || key == "" || strings.ContainsAny(key, " \t") {
+			return nil, fmt.Errorf("invalid metadata %q, expected key=value", spec)
+		}
+		if _, ok := ret[key]; ok {
+			return nil, fmt.Errorf("duplicate metadata key %q", key)
+		}
+		ret[key] = strings.TrimSpace(value)
+	}
+	return ret, nil
+}
+
+// LogHook adds the scan ID and the metadata of the run to every log entry
+type LogHook struct {
+	Run Run
+}
+
+func (h LogHook) Levels() []logrus.Level {
+	return logrus.AllLevels
+}
+
+func (h LogHook) Fire(entry *logrus.Entry) error {
+	entry.Data["scan_id"] = h.Run.ID
+	for k, v := range h.Run.Metadata {
+		entry.Data[k] = v
+	}
+	return nil
+}
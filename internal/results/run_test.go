@@ -0,0 +1,63 @@
+package results
+
+import (
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// not parallel as the run is global
+func TestWriterAddsRun(t *testing.T) {
+	run := Run{ID: "20220101T000000Z-00000001", Metadata: map[string]string{"engagement": "ACME"}}
+	SetRun(run)
+	SetTransport("tls")
+	defer SetRun(Run{})
+
+	filename := filepath.Join(t.TempDir(), "results.jsonl")
+	w, err := NewWriter(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Write(Finding{Module: "test", Host: "10.0.0.1"}); err != nil {
+		t.Fatal(err)
+	}
+	// findings of previous runs keep their scan id
+	if err := w.Write(Finding{Module: "test", Host: "10.0.0.2", ScanID: "other"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	read, err := ReadFile(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if read[0].ScanID != run.ID || !reflect.DeepEqual(read[0].Metadata, run.Metadata) || read[0].Transport != "tls" {
+		t.Errorf("run was not added: %+v", read[0])
+	}
+	if read[1].ScanID != "other" || read[1].Metadata != nil {
+		t.Errorf("existing scan id was overwritten: %+v", read[1])
+	}
+}
+
+func TestParseMetadata(t *testing.T) {
+	t.Parallel()
+	m, err := ParseMetadata([]string{"engagement=ACME-2024", "ticket = SEC-1234", "note=a=b"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := map[string]string{"engagement": "ACME-2024", "ticket": "SEC-1234", "note": "a=b"}
+	if !reflect.DeepEqual(m, expected) {
+		t.Errorf("expected %v, got %v", expected, m)
+	}
+
+	for _, spec := range []string{"engagement", "=value", "two words=x"} {
+		if _, err := ParseMetadata([]string{spec}); err == nil {
+			t.Errorf("expected an error on %q", spec)
+		}
+	}
+	if _, err := ParseMetadata([]string{"a=1", "a=2"}); err == nil {
+		t.Error("expected an error on a duplicate key")
+	}
+}
@@ -0,0 +1,84 @@
+package results
+
+import (
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// HostTimes records when every target was probed first and last, so the
+// findings can be matched with the IDS and NetFlow logs of the defenders.
+// All methods are safe to call on a nil HostTimes
+type HostTimes struct {
+	mu    sync.Mutex
+	hosts map[string]*hostTime
+}
+
+type hostTime struct {
+	start  time.Time
+	end    time.Time
+	probes int
+}
+
+// NewHostTimes returns an empty HostTimes
+func NewHostTimes() *HostTimes {
+	return &HostTimes{
+		hosts: make(map[string]*hostTime),
+	}
+}
+
+// Record adds a probe of the host that ran from start to end
+func (h *HostTimes) Record(host string, start, end time.Time) {
+	if h == nil {
+		return
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	t, ok := h.hosts[host]
+	if !ok {
+		h.hosts[host] = &hostTime{start: start, end: end, probes: 1}
+		return
+	}
+	if start.Before(t.start) {
+		t.start = start
+	}
+	if end.After(t.end) {
+		t.end = end
+	}
+	t.probes++
+}
+
+// Findings returns a finding with the service timing for every recorded
+// host, sorted by the start of the probes
+func (h *HostTimes) Findings(module, relay string) []Finding {
+	if h == nil {
+		return nil
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	ret := make([]Finding, 0, len(h.hosts))
+	for host, t := range h.hosts {
+		start, end := t.start, t.end
+		ret = append(ret, Finding{
+			Time:    end,
+			Module:  module,
+			Relay:   relay,
+			Host:    host,
+			Service: "timing",
+			Start:   &start,
+			End:     &end,
+			Details: map[string]string{
+				"probes":   strconv.Itoa(t.probes),
+				"duration": end.Sub(start).Round(time.Millisecond).String(),
+			},
+		})
+	}
+	sort.Slice(ret, func(i, j int) bool {
+		if !ret[i].Start.Equal(*ret[j].Start) {
+			return ret[i].Start.Before(*ret[j].Start)
+		}
+		return hostLess(ret[i].Host, ret[j].Host)
+	})
+	return ret
+}
@@ -0,0 +1,226 @@
+// Package sdp extracts ICE servers and candidates from SDP offers and
+// answers. The input can be a plain SDP, a SIP trace or a dump from
+// the webrtc-internals page of the browser.
+package sdp
+
+import (
+	"fmt"
+	"net"
+	"net/netip"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Server is a STUN or TURN server from an ICE server configuration
+type Server struct {
+	URL string
+	// Scheme is one of stun, stuns, turn and turns
+	Scheme     string
+	Host       string
+	Port       uint16
+	Transport  string
+	Username   string
+	Credential string
+}
+
+// Address returns the server in the format host:port
+func (s Server) Address() string {
+	return net.JoinHostPort(s.Host, strconv.Itoa(int(s.Port)))
+}
+
+// TLS returns true if the server is contacted via TLS or DTLS
+func (s Server) TLS() bool {
+	return s.Scheme == "stuns" || s.Scheme == "turns"
+}
+
+// IsTURN returns true for turn and turns servers
+func (s Server) IsTURN() bool {
+	return s.Scheme == "turn" || s.Scheme == "turns"
+}
+
+// Candidate is an ICE candidate from an a=candidate line
+type Candidate struct {
+	Foundation string
+	Component  int
+	Transport  string
+	Priority   uint32
+	// Address is the connection address. Browsers hide host candidates
+	// behind mDNS names so this is not always an IP
+	Address string
+	Port    uint16
+	// Type is one of host, srflx, prflx and relay
+	Type           string
+	RelatedAddress string
+	RelatedPort    uint16
+}
+
+// Addr returns the connection address as an IP if it is one
+func (c Candidate) Addr() (netip.Addr, bool) {
+	ip, err := netip.ParseAddr(c.Address)
+	if err != nil {
+		return netip.Addr{}, false
+	}
+	return ip.Unmap(), true
+}
+
+// Info contains everything extracted from the input
+type Info struct {
+	Servers    []Server
+	Candidates []Candidate
+}
+
+var (
+	// iceServerRegex matches a flat JSON object as used in the iceServers
+	// configuration. The urls are an array so the object has no nested braces
+	iceServerRegex  = regexp.MustCompile(`\{[^{}]*\}`)
+	usernameRegex   = regexp.MustCompile(`"username"\s*:\s*"((?:[^"\\]|\\.)*)"`)
+	credentialRegex = regexp.MustCompile(`"credential"\s*:\s*"((?:[^"\\]|\\.)*)"`)
+	serverURLRegex  = regexp.MustCompile(`(?i)\b(stuns?|turns?):(\[[0-9a-f:.]+\]|[a-z0-9][a-z0-9.\-]*)(?::(\d+))?(?:\?transport=(udp|tcp))?`)
+	candidateRegex  = regexp.MustCompile(`candidate:(\S+) (\d+) (\S+) (\d+) (\S+) (\d+) typ (\S+)(?: raddr (\S+) rport (\d+))?`)
+)
+
+// Parse extracts all ICE servers and candidates. Duplicates are removed.
+func Parse(data []byte) Info {
+	text := unescape(string(data))
+
+	var info Info
+	seenServers := make(map[string]struct{})
+	addServer := func(s Server) {
+		key := fmt.Sprintf("%s|%s|%s", s.URL, s.Username, s.Credential)
+		if _, ok := seenServers[key]; ok {
+			return
+		}
+		seenServers[key] = struct{}{}
+		info.Servers = append(info.Servers, s)
+	}
+
+	// servers with credentials first so the ones without credentials
+	// can be skipped if the same URL was already found
+	for _, obj := range iceServerRegex.FindAllString(text, -1) {
+		var username, credential string
+		if m := usernameRegex.FindStringSubmatch(obj); m != nil {
+			username = unquote(m[1])
+		}
+		if m := credentialRegex.FindStringSubmatch(obj); m != nil {
+			credential = unquote(m[1])
+		}
+		if username == "" && credential == "" {
+			continue
+		}
+		for _, s := range parseServerURLs(obj) {
+			s.Username = username
+			s.Credential = credential
+			addServer(s)
+		}
+	}
+	withCredentials := make(map[string]struct{})
+	for _, s := range info.Servers {
+		withCredentials[s.URL] = struct{}{}
+	}
+	for _, s := range parseServerURLs(text) {
+		if _, ok := withCredentials[s.URL]; ok {
+			continue
+		}
+		addServer(s)
+	}
+
+	seenCandidates := make(map[string]struct{})
+	for _, m := range candidateRegex.FindAllStringSubmatch(text, -1) {
+		c, ok := parseCandidate(m)
+		if !ok {
+			continue
+		}
+		key := fmt.Sprintf("%s|%s|%d|%s", c.Transport, c.Address, c.Port, c.Type)
+		if _, ok := seenCandidates[key]; ok {
+			continue
+		}
+		seenCandidates[key] = struct{}{}
+		info.Candidates = append(info.Candidates, c)
+	}
+
+	return info
+}
+
+// unescape converts escaped line breaks and quotes of JSON dumps so
+// SDPs embedded in JSON strings can be parsed line by line
+func unescape(s string) string {
+	r := strings.NewReplacer(`\r\n`, "\n", `\n`, "\n", `\r`, "\n", `\"`, `"`, `\/`, `/`)
+	return r.Replace(s)
+}
+
+// unquote resolves the remaining JSON escapes of a string value
+func unquote(s string) string {
+	if x, err := strconv.Unquote(`"` + s + `"`); err == nil {
+		return x
+	}
+	return s
+}
+
+// parseServerURLs parses all stun and turn URLs as defined in RFC7064 and RFC7065
+func parseServerURLs(text string) []Server {
+	var ret []Server
+	for _, m := range serverURLRegex.FindAllStringSubmatch(text, -1) {
+		s := Server{
+			URL:       m[0],
+			Scheme:    strings.ToLower(m[1]),
+			Host:      strings.Trim(m[2], "[]"),
+			Transport: strings.ToLower(m[4]),
+		}
+		switch {
+		case m[3] != "":
+			port, err := strconv.ParseUint(m[3], 10, 16)
+			if err != nil {
+				continue
+			}
+			s.Port = uint16(port)
+		case s.TLS():
+			s.Port = 5349
+		default:
+			s.Port = 3478
+		}
+		if s.Transport == "" {
+			// turns and stuns default to TLS over TCP
+			s.Transport = "udp"
+			if s.TLS() {
+				s.Transport = "tcp"
+			}
+		}
+		ret = append(ret, s)
+	}
+	return ret
+}
+
+// parseCandidate converts the submatches of candidateRegex
+func parseCandidate(m []string) (Candidate, bool) {
+	component, err := strconv.Atoi(m[2])
+	if err != nil {
+		return Candidate{}, false
+	}
+	priority, err := strconv.ParseUint(m[4], 10, 32)
+	if err != nil {
+		return Candidate{}, false
+	}
+	port, err := strconv.ParseUint(m[6], 10, 16)
+	if err != nil {
+		return Candidate{}, false
+	}
+	c := Candidate{
+		Foundation: m[1],
+		Component:  component,
+		Transport:  strings.ToLower(m[3]),
+		Priority:   uint32(priority),
+		Address:    m[5],
+		Port:       uint16(port),
+		Type:       m[7],
+	}
+	if m[8] != "" {
+		relatedPort, err := strconv.ParseUint(m[9], 10, 16)
+		if err != nil {
+			return Candidate{}, false
+		}
+		c.RelatedAddress = m[8]
+		c.RelatedPort = uint16(relatedPort)
+	}
+	return c, true
+}
@@ -0,0 +1,84 @@
+package sdp
+
+import (
+	"testing"
+)
+
+const testSDP = "v=0\r\n" +
+	"o=- 4611731400430051336 2 IN IP4 127.0.0.1\r\n" +
+	"s=-\r\n" +
+	"t=0 0\r\n" +
+	"m=audio 54321 UDP/TLS/RTP/SAVPF 111\r\n" +
+	"c=IN IP4 203.0.113.10\r\n" +
+	"a=ice-ufrag:F7gI\r\n" +
+	"a=ice-pwd:x9cml/YzichV2+XlhiMu8g\r\n" +
+	"a=candidate:1467250027 1 udp 2122260223 192.168.1.20 46243 typ host generation 0\r\n" +
+	"a=candidate:3d3c4f0a-mdns 1 udp 2122262783 4f2a1e8c-7d1e-4b8e-9d1a-0c3b1d2e3f40.local 51234 typ host\r\n" +
+	"a=candidate:435653019 1 tcp 1845501695 198.51.100.7 9 typ srflx raddr 192.168.1.20 rport 46243 tcptype active\r\n" +
+	"a=candidate:1853887674 1 udp 33562367 203.0.113.10 54321 typ relay raddr 198.51.100.7 rport 46243\r\n" +
+	"a=candidate:1467250027 1 udp 2122260223 192.168.1.20 46243 typ host generation 0\r\n"
+
+func TestParseSDP(t *testing.T) {
+	t.Parallel()
+	info := Parse([]byte(testSDP))
+	if len(info.Servers) != 0 {
+		t.Errorf("expected no servers, got %v", info.Servers)
+	}
+	if len(info.Candidates) != 4 {
+		t.Fatalf("expected 4 candidates, got %d: %v", len(info.Candidates), info.Candidates)
+	}
+
+	host := info.Candidates[0]
+	if host.Type != "host" || host.Address != "192.168.1.20" || host.Port != 46243 || host.Transport != "udp" || host.Priority != 2122260223 {
+		t.Errorf("unexpected host candidate %+v", host)
+	}
+	if _, ok := info.Candidates[1].Addr(); ok {
+		t.Errorf("expected mDNS candidate to have no IP")
+	}
+	relay := info.Candidates[3]
+	if relay.Type != "relay" || relay.RelatedAddress != "198.51.100.7" || relay.RelatedPort != 46243 {
+		t.Errorf("unexpected relay candidate %+v", relay)
+	}
+	if ip, ok := relay.Addr(); !ok || ip.String() != "203.0.113.10" {
+		t.Errorf("unexpected relay address %v", ip)
+	}
+}
+
+func TestParseWebRTCInternals(t *testing.T) {
+	t.Parallel()
+	dump := `{"getUserMedia":[],"PeerConnections":{"1-1":{"constraints":"","rtcConfiguration":"{ iceServers: [turn:turn.example.com:3478?transport=udp, turns:turn.example.com:443?transport=tcp, stun:stun.example.com] }",` +
+		`"config":{"iceServers":[{"urls":["turn:turn.example.com:3478?transport=udp","turns:turn.example.com:443?transport=tcp"],"username":"1700000000:user","credential":"c2VjcmV0\/Zm9v"},{"urls":"turn:[2001:db8::1]"}]},` +
+		`"updateLog":[{"type":"setLocalDescription","value":"type: offer, sdp: v=0\r\na=candidate:1853887674 1 udp 33562367 203.0.113.10 54321 typ relay raddr 198.51.100.7 rport 46243\r\n"}]}}}`
+
+	info := Parse([]byte(dump))
+	if len(info.Candidates) != 1 {
+		t.Errorf("expected 1 candidate, got %d", len(info.Candidates))
+	}
+
+	var tests = []struct {
+		url        string
+		host       string
+		port       uint16
+		transport  string
+		tls        bool
+		username   string
+		credential string
+	}{
+		{"turn:turn.example.com:3478?transport=udp", "turn.example.com", 3478, "udp", false, "1700000000:user", "c2VjcmV0/Zm9v"},
+		{"turns:turn.example.com:443?transport=tcp", "turn.example.com", 443, "tcp", true, "1700000000:user", "c2VjcmV0/Zm9v"},
+		{"stun:stun.example.com", "stun.example.com", 3478, "udp", false, "", ""},
+		{"turn:[2001:db8::1]", "2001:db8::1", 3478, "udp", false, "", ""},
+	}
+	if len(info.Servers) != len(tests) {
+		t.Fatalf("expected %d servers, got %d: %+v", len(tests), len(info.Servers), info.Servers)
+	}
+	for i, tt := range tests {
+		s := info.Servers[i]
+		if s.URL != tt.url || s.Host != tt.host || s.Port != tt.port || s.Transport != tt.transport || s.TLS() != tt.tls || s.Username != tt.username || s.Credential != tt.credential {
+			t.Errorf("server %d: unexpected %+v", i, s)
+		}
+	}
+	if x := info.Servers[3].Address(); x != "[2001:db8::1]:3478" {
+		t.Errorf("unexpected address %s", x)
+	}
+}
@@ -0,0 +1,211 @@
+package internal
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// session is an open connection to the TURN server. If an allocation was
+// granted on it, the credentials to delete it are kept
+type session struct {
+	allocated bool
+	username  string
+	password  string
+	realm     string
+	nonce     string
+}
+
+var (
+	sessionsMu sync.Mutex
+	sessions   = make(map[net.Conn]*session)
+
+	shutdownCtx, shutdownCancel = context.WithCancel(context.Background())
+
+	drainMu sync.Mutex
+	drains  = make(map[*func()]struct{})
+)
+
+// OnDrain registers a function that is called on Shutdown before Context
+// is canceled and the allocations are deleted. It may block to let active
+// connections finish and should limit the time it waits. The returned
+// function removes it again
+func OnDrain(f func()) func() {
+	drainMu.Lock()
+	defer drainMu.Unlock()
+	drains[&f] = struct{}{}
+	return func() {
+		drainMu.Lock()
+		defer drainMu.Unlock()
+		delete(drains, &f)
+	}
+}
+
+// drain calls all registered drain functions concurrently and waits for
+// them
+func drain() {
+	drainMu.Lock()
+	var wg sync.WaitGroup
+	for f := range drains {
+		wg.Add(1)
+		go func(f func()) {
+			defer wg.Done()
+			f()
+		}(*f)
+	}
+	drainMu.Unlock()
+	wg.Wait()
+}
+
+// Context returns a context that is canceled on Shutdown
+func Context() context.Context {
+	return shutdownCtx
+}
+
+// trackConn registers a connection to the TURN server so it is closed on
+// Shutdown. Allocations granted on it are detected by SendAndReceive
+func trackConn(conn net.Conn) {
+	sessionsMu.Lock()
+	defer sessionsMu.Unlock()
+	sessions[conn] = &session{}
+}
+
+// trackAllocation registers a connection with an allocation that was
+// granted before, for example to another process
+func trackAllocation(conn net.Conn, username, password, realm, nonce string) {
+	sessionsMu.Lock()
+	defer sessionsMu.Unlock()
+	sessions[conn] = &session{
+		allocated: true,
+		username:  username,
+		password:  password,
+		realm:     realm,
+		nonce:     nonce,
+	}
+}
+
+// untrackConn removes a connection that was closed or handed off
+func untrackConn(conn net.Conn) {
+	sessionsMu.Lock()
+	defer sessionsMu.Unlock()
+	delete(sessions, conn)
+}
+
+// trackedConn removes itself from the sessions when closed
+type trackedConn struct {
+	net.Conn
+}
+
+func newTrackedConn(conn net.Conn) net.Conn {
+	t := &trackedConn{Conn: conn}
+	trackConn(t)
+	return t
+}
+
+func (c *trackedConn) Read(b []byte) (int, error) {
+	n, err := c.Conn.Read(b)
+	counters.bytesReceived.Add(uint64(n))
+	return n, err
+}
+
+func (c *trackedConn) Write(b []byte) (int, error) {
+	n, err := c.Conn.Write(b)
+	counters.bytesSent.Add(uint64(n))
+	return n, err
+}
+
+func (c *trackedConn) Close() error {
+	untrackConn(c)
+	return c.Conn.Close()
+}
+
+// observeResponse records allocations granted and deleted on conn, and the
+// latest nonce to delete them with
+func observeResponse(conn net.Conn, req, resp *Stun) {
+	if req.Username == "" || resp.Header.MessageType.Class != MsgTypeClassSuccess {
+		return
+	}
+	sessionsMu.Lock()
+	defer sessionsMu.Unlock()
+	s, ok := sessions[conn]
+	if !ok {
+		return
+	}
+	switch req.Header.MessageType.Method {
+	case MsgTypeMethodAllocate:
+		s.allocated = true
+	case MsgTypeMethodRefresh:
+		if bytes.Equal(req.GetAttribute(AttrLifetime).Value, []byte{0, 0, 0, 0}) {
+			s.allocated = false
+			return
+		}
+	}
+	if s.allocated {
+		s.username = req.Username
+		s.password = req.Password
+		s.realm = string(req.GetAttribute(AttrRealm).Value)
+		s.nonce = string(req.GetAttribute(AttrNonce).Value)
+	}
+}
+
+// Shutdown waits for the functions registered with OnDrain, cancels
+// Context, deletes all allocations with a lifetime 0 refresh and closes
+// all connections to the TURN server. Channels and permissions are deleted
+// with their allocation. It returns the number of deleted allocations. New
+// connections can still be opened afterwards, so the caller should exit.
+func Shutdown(logger DebugLogger, timeout time.Duration) int {
+	drain()
+	shutdownCancel()
+
+	sessionsMu.Lock()
+	open := make(map[net.Conn]session, len(sessions))
+	for conn, s := range sessions {
+		open[conn] = *s
+	}
+	sessions = make(map[net.Conn]*session)
+	sessionsMu.Unlock()
+
+	var released int32
+	var wg sync.WaitGroup
+	for conn, s := range open {
+		wg.Add(1)
+		go func(conn net.Conn, s session) {
+			defer wg.Done()
+			defer conn.Close()
+			if !s.allocated {
+				return
+			}
+			if err := s.release(logger, conn, timeout); err != nil {
+				logger.Debugf("[conn %s] could not delete allocation: %v", ConnID(conn), err)
+				return
+			}
+			atomic.AddInt32(&released, 1)
+		}(conn, s)
+	}
+	wg.Wait()
+	return int(released)
+}
+
+// release sends a lifetime 0 refresh. A stale nonce is replaced once
+func (s session) release(logger DebugLogger, conn net.Conn, timeout time.Duration) error {
+	nonce := s.nonce
+	for i := 0; i < 2; i++ {
+		resp, err := DeallocateRequest(s.username, s.password, nonce, s.realm).SendAndReceive(logger, conn, timeout)
+		if err != nil {
+			return err
+		}
+		if resp.Header.MessageType.Class != MsgTypeClassError {
+			return nil
+		}
+		var respErr *ResponseError
+		if !errors.As(resp.GetError(), &respErr) || respErr.Code != ErrorStaleNonce {
+			return resp.GetError()
+		}
+		nonce = string(resp.GetAttribute(AttrNonce).Value)
+	}
+	return errors.New("nonce is still stale")
+}
@@ -0,0 +1,87 @@
+package internal
+
+import (
+	"net"
+	"testing"
+	"time"
+)
+
+// no t.Parallel as the test uses the global sessions and cancels Context
+func TestShutdown(t *testing.T) {
+	client, server := net.Pipe()
+	defer server.Close()
+	conn := newTrackedConn(client)
+
+	closedClient, closedServer := net.Pipe()
+	defer closedServer.Close()
+	closed := newTrackedConn(closedClient)
+	if err := closed.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	done := make(chan []MessageTypeMethod, 1)
+	go func() {
+		var methods []MessageTypeMethod
+		defer func() { done <- methods }()
+		buf := make([]byte, 1024)
+		for i := 0; i < 2; i++ {
+			n, err := server.Read(buf)
+			if err != nil {
+				return
+			}
+			req, err := fromBytes(buf[:n])
+			if err != nil {
+				return
+			}
+			methods = append(methods, req.Header.MessageType.Method)
+			resp := newStun()
+			resp.Header.TransactionID = req.Header.TransactionID
+			resp.Header.MessageType = MessageType{Class: MsgTypeClassSuccess, Method: req.Header.MessageType.Method}
+			data, err := resp.Serialize()
+			if err != nil {
+				return
+			}
+			if _, err := server.Write(data); err != nil {
+				return
+			}
+		}
+	}()
+	allocateRequest := AllocateRequestAuth("user", "pass", "nonce", "realm", RequestedTransportUDP, AllocateProtocolIgnore)
+	if _, err := allocateRequest.SendAndReceive(nilLogger{}, conn, time.Second); err != nil {
+		t.Fatal(err)
+	}
+
+	// drains run before the context is canceled
+	drained := false
+	removeDrain := OnDrain(func() {
+		drained = Context().Err() == nil
+	})
+	defer removeDrain()
+	removed := OnDrain(func() {
+		t.Error("removed drain was called")
+	})
+	removed()
+
+	if released := Shutdown(nilLogger{}, time.Second); released != 1 {
+		t.Errorf("Shutdown() deleted %d allocations, want 1", released)
+	}
+	if !drained {
+		t.Error("drain was not called before the context was canceled")
+	}
+	if Context().Err() == nil {
+		t.Error("context was not canceled")
+	}
+	methods := <-done
+	if len(methods) != 2 || methods[1] != MsgTypeMethodRefresh {
+		t.Fatalf("expected an Allocate and a Refresh, got %v", methods)
+	}
+	// the connection is closed
+	if _, err := client.Write([]byte{0}); err == nil {
+		t.Error("connection is still open")
+	}
+	sessionsMu.Lock()
+	defer sessionsMu.Unlock()
+	if len(sessions) != 0 {
+		t.Errorf("%d sessions left", len(sessions))
+	}
+}
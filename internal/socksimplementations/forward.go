@@ -0,0 +1,54 @@
+package socksimplementations
+
+import (
+	"net"
+	"net/netip"
+
+	socks "github.com/firefart/gosocks"
+	"github.com/firefart/stunner/internal/helper"
+
+	"github.com/sirupsen/logrus"
+)
+
+// ForwardServer relays all connections to a fixed target like a local port
+// forward of ssh. The connections are handed to the same handler as the
+// CONNECT command of the SOCKS5 server
+type ForwardServer struct {
+	// Connect handles the forwarded connections
+	Connect socks.ProxyHandler
+	// Target is the address all connections are forwarded to
+	Target netip.AddrPort
+	// Allow are the networks clients may connect from. All clients are
+	// allowed if empty
+	Allow []netip.Prefix
+	Log   *logrus.Logger
+
+	tracker
+}
+
+// Serve accepts the connections of the listener until it is closed or the
+// server is shut down
+func (s *ForwardServer) Serve(listener net.Listener) {
+	s.serve(s.Log, listener, s.handle)
+}
+
+// handle relays the connection to the target
+func (s *ForwardServer) handle(conn net.Conn) {
+	defer conn.Close()
+	defer s.Log.Debug("[forward] client connection closed")
+
+	client, err := netip.ParseAddrPort(conn.RemoteAddr().String())
+	if err != nil {
+		s.Log.Errorf("[forward] invalid client address %s: %v", conn.RemoteAddr(), err)
+		return
+	}
+	if !helper.InAllowList(s.Allow, client.Addr()) {
+		s.Log.Warnf("[forward] refusing connection from %s as it is not in the allow list", client)
+		return
+	}
+	s.Log.Debugf("[forward] got connection from %s", client)
+	// there is no reply, failed connections are closed
+	relay(s.Log, s.Connect, conn, socksAddress(s.Target), func(socks.RequestReplyReason, netip.AddrPort) error {
+		return nil
+	})
+}
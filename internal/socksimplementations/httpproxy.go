@@ -0,0 +1,199 @@
+package socksimplementations
+
+import (
+	"bufio"
+	"crypto/subtle"
+	"encoding/base64"
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"strconv"
+	"strings"
+	"time"
+
+	socks "github.com/firefart/gosocks"
+	"github.com/firefart/stunner/internal/helper"
+
+	"github.com/sirupsen/logrus"
+)
+
+// HTTPServer is an HTTP/1.1 proxy that only supports the CONNECT method.
+// The CONNECT requests are handed to the same handler as the CONNECT
+// command of the SOCKS5 server
+type HTTPServer struct {
+	// Connect handles the CONNECT requests
+	Connect socks.ProxyHandler
+	// Users are the usernames and passwords clients authenticate with using
+	// basic authentication. No authentication is needed if empty
+	Users map[string]string
+	// Allow are the networks clients may connect from. All clients are
+	// allowed if empty
+	Allow []netip.Prefix
+	// Timeout is the time the client has to send its request
+	Timeout time.Duration
+	Log     *logrus.Logger
+
+	tracker
+}
+
+// Serve accepts the connections of the listener until it is closed or the
+// server is shut down
+func (s *HTTPServer) Serve(listener net.Listener) {
+	s.serve(s.Log, listener, s.handle)
+}
+
+// handle reads the CONNECT request of the client and relays the
+// connection
+func (s *HTTPServer) handle(conn net.Conn) {
+	defer conn.Close()
+	defer s.Log.Debug("[http] client connection closed")
+
+	client, err := netip.ParseAddrPort(conn.RemoteAddr().String())
+	if err != nil {
+		s.Log.Errorf("[http] invalid client address %s: %v", conn.RemoteAddr(), err)
+		return
+	}
+	s.Log.Debugf("[http] got connection from %s", client)
+	if !helper.InAllowList(s.Allow, client.Addr()) {
+		s.Log.Warnf("[http] refusing connection from %s as it is not in the allow list", client)
+		return
+	}
+	if err := conn.SetDeadline(time.Now().Add(s.Timeout)); err != nil {
+		s.Log.Errorf("[http] could not set deadline: %v", err)
+		return
+	}
+	reader := bufio.NewReader(conn)
+	req, err := http.ReadRequest(reader)
+	if err != nil {
+		s.Log.Errorf("[http] invalid request from %s: %v", client, err)
+		s.reply(conn, http.StatusBadRequest, nil)
+		return
+	}
+	if req.Method != http.MethodConnect {
+		s.Log.Warnf("[http] refusing method %s of %s", req.Method, client)
+		s.reply(conn, http.StatusMethodNotAllowed, http.Header{"Allow": {http.MethodConnect}})
+		return
+	}
+	if !s.authenticate(req) {
+		s.Log.Warnf("[http] invalid credentials of %s", client)
+		s.reply(conn, http.StatusProxyAuthRequired, http.Header{"Proxy-Authenticate": {`Basic realm="stunner"`}})
+		return
+	}
+	requested, err := parseHostPort(req.Host)
+	if err != nil {
+		s.Log.Errorf("[http] invalid target of %s: %v", client, err)
+		s.reply(conn, http.StatusBadRequest, nil)
+		return
+	}
+	if err := conn.SetDeadline(time.Time{}); err != nil {
+		s.Log.Errorf("[http] could not reset deadline: %v", err)
+		return
+	}
+
+	// the reader may already hold data the client sent after the request
+	buffered := &bufferedConn{Conn: conn, reader: reader}
+	relay(s.Log, s.Connect, buffered, requested, func(reason socks.RequestReplyReason, _ netip.AddrPort) error {
+		return s.reply(conn, replyStatus(reason), nil)
+	})
+}
+
+// authenticate checks the basic authentication of the proxy request
+func (s *HTTPServer) authenticate(req *http.Request) bool {
+	if len(s.Users) == 0 {
+		return true
+	}
+	auth := req.Header.Get("Proxy-Authorization")
+	const prefix = "Basic "
+	if len(auth) < len(prefix) || !strings.EqualFold(auth[:len(prefix)], prefix) {
+		return false
+	}
+	decoded, err := base64.StdEncoding.DecodeString(auth[len(prefix):])
+	if err != nil {
+		return false
+	}
+	username, password, ok := strings.Cut(string(decoded), ":")
+	if !ok {
+		return false
+	}
+	expected, ok := s.Users[username]
+	// compare anyway so unknown users take as long as wrong passwords
+	valid := subtle.ConstantTimeCompare([]byte(expected), []byte(password)) == 1 && ok
+	if valid {
+		s.Log.Debugf("[http] %s authenticated as %q", req.RemoteAddr, username)
+	}
+	return valid
+}
+
+// reply sends a response without a body
+func (s *HTTPServer) reply(conn net.Conn, status int, header http.Header) error {
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "HTTP/1.1 %d %s\r\n", status, http.StatusText(status))
+	if status != http.StatusOK {
+		// the connection is closed after errors
+		fmt.Fprintf(&sb, "Connection: close\r\nContent-Length: 0\r\n")
+	}
+	for key, values := range header {
+		for _, v := range values {
+			fmt.Fprintf(&sb, "%s: %s\r\n", key, v)
+		}
+	}
+	sb.WriteString("\r\n")
+	if err := helper.ConnectionWrite(conn, []byte(sb.String()), s.Timeout); err != nil {
+		s.Log.Debugf("[http] could not send reply: %v", err)
+		return err
+	}
+	return nil
+}
+
+// replyStatus returns the status code of the reply of the SOCKS handler
+func replyStatus(reason socks.RequestReplyReason) int {
+	switch reason {
+	case socks.RequestReplySucceeded:
+		return http.StatusOK
+	case socks.RequestReplyConnectionNotAllowed:
+		return http.StatusForbidden
+	case socks.RequestReplyTTLExpired:
+		return http.StatusGatewayTimeout
+	default:
+		return http.StatusBadGateway
+	}
+}
+
+// parseHostPort converts the target of a CONNECT request to the address
+// of a SOCKS request
+func parseHostPort(hostport string) (helper.SOCKSAddress, error) {
+	host, p, err := net.SplitHostPort(hostport)
+	if err != nil {
+		return helper.SOCKSAddress{}, err
+	}
+	port, err := strconv.ParseUint(p, 10, 16)
+	if err != nil || port == 0 {
+		return helper.SOCKSAddress{}, fmt.Errorf("invalid port %q", p)
+	}
+	if ip, err := netip.ParseAddr(host); err == nil {
+		if ip.Zone() != "" {
+			return helper.SOCKSAddress{}, fmt.Errorf("addresses with a zone are not supported")
+		}
+		addressType := helper.SOCKSAddressIPv4
+		if ip.Is6() {
+			addressType = helper.SOCKSAddressIPv6
+		}
+		return helper.SOCKSAddress{Type: addressType, Address: ip.AsSlice(), Port: uint16(port)}, nil
+	}
+	if host == "" || len(host) > 255 {
+		return helper.SOCKSAddress{}, fmt.Errorf("invalid host %q", host)
+	}
+	return helper.SOCKSAddress{Type: helper.SOCKSAddressDomain, Address: []byte(host), Port: uint16(port)}, nil
+}
+
+// bufferedConn reads from the buffered reader of the connection first, so
+// no data read together with the request is lost
+type bufferedConn struct {
+	net.Conn
+	reader *bufio.Reader
+}
+
+func (c *bufferedConn) Read(b []byte) (int, error) {
+	return c.reader.Read(b)
+}
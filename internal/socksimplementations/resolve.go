@@ -0,0 +1,82 @@
+package socksimplementations
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/netip"
+
+	socks "github.com/firefart/gosocks"
+	"github.com/firefart/stunner/internal"
+	"github.com/firefart/stunner/internal/helper"
+	"github.com/sirupsen/logrus"
+)
+
+// Resolver resolves a domain name without using the local resolver, for
+// example with a DNS server reached through the relay
+type Resolver func(ctx context.Context, name string) (netip.Addr, error)
+
+// destination returns the IP address of the request if it is in the scope
+// of the engagement. IPv4-mapped IPv6 addresses are returned as IPv4
+// addresses, so they are relayed over IPv4. Domain names are resolved with resolve if set,
+// otherwise locally. With strict set names that would be resolved locally
+// are refused, so no DNS query for a target leaves the local machine
+func destination(ctx context.Context, log *logrus.Logger, request socks.Request, strict bool, resolve Resolver) (netip.Addr, *socks.Error) {
+	target, socksErr := resolveDestination(ctx, log, request, strict, resolve)
+	if socksErr != nil {
+		return netip.Addr{}, socksErr
+	}
+	if err := helper.CheckScope(target, request.DestinationPort); err != nil {
+		return netip.Addr{}, &socks.Error{Reason: socks.RequestReplyConnectionNotAllowed, Err: err}
+	}
+	return target.Unmap(), nil
+}
+
+// relayError returns the reply to an error of the TURN server. Servers
+// without IPv6 support reject allocations and peers of the other address
+// family, which is reported as an unsupported address type
+func relayError(err error) *socks.Error {
+	var stunErr *internal.ResponseError
+	if errors.As(err, &stunErr) && (stunErr.Code == internal.ErrorAddressFamilyNotSupported || stunErr.Code == internal.ErrorPeerAddressFamilyMissmatch) {
+		return &socks.Error{Reason: socks.RequestReplyAddressTypeNotSupported, Err: fmt.Errorf("the TURN server does not relay to this address family: %w", err)}
+	}
+	return &socks.Error{Reason: socks.RequestReplyHostUnreachable, Err: err}
+}
+
+func resolveDestination(ctx context.Context, log *logrus.Logger, request socks.Request, strict bool, resolve Resolver) (netip.Addr, *socks.Error) {
+	switch request.AddressType {
+	case socks.RequestAddressTypeIPv4, socks.RequestAddressTypeIPv6:
+		target, ok := netip.AddrFromSlice(request.DestinationAddress)
+		if !ok {
+			return netip.Addr{}, &socks.Error{Reason: socks.RequestReplyAddressTypeNotSupported, Err: fmt.Errorf("%02x is no ip address", request.DestinationAddress)}
+		}
+		return target, nil
+	case socks.RequestAddressTypeDomainname:
+		name := string(request.DestinationAddress)
+		// out of scope names are not even resolved
+		if err := helper.CheckScopeName(name); err != nil {
+			return netip.Addr{}, &socks.Error{Reason: socks.RequestReplyConnectionNotAllowed, Err: err}
+		}
+		if resolve != nil {
+			target, err := resolve(ctx, name)
+			if err != nil {
+				return netip.Addr{}, &socks.Error{Reason: socks.RequestReplyHostUnreachable, Err: err}
+			}
+			return target, nil
+		}
+		if strict {
+			log.Warnf("[socks] refusing connection to %s:%d as it needs a local DNS lookup. Configure the client to resolve names itself or resolve them through the relay", name, request.DestinationPort)
+			return netip.Addr{}, &socks.Error{Reason: socks.RequestReplyAddressTypeNotSupported, Err: fmt.Errorf("local DNS resolution of %s refused", name)}
+		}
+		names, err := helper.ResolveName(ctx, name)
+		if err != nil {
+			return netip.Addr{}, &socks.Error{Reason: socks.RequestReplyHostUnreachable, Err: err}
+		}
+		if len(names) == 0 {
+			return netip.Addr{}, &socks.Error{Reason: socks.RequestReplyHostUnreachable, Err: fmt.Errorf("%s could not be resolved", name)}
+		}
+		return names[0], nil
+	default:
+		return netip.Addr{}, &socks.Error{Reason: socks.RequestReplyAddressTypeNotSupported, Err: fmt.Errorf("AddressType %#x not implemented", request.AddressType)}
+	}
+}
@@ -0,0 +1,298 @@
+package socksimplementations
+
+import (
+	"bytes"
+	"context"
+	"crypto/subtle"
+	"fmt"
+	"io"
+	"net"
+	"net/netip"
+	"sync"
+	"time"
+
+	socks "github.com/firefart/gosocks"
+	"github.com/firefart/stunner/internal/helper"
+
+	"github.com/sirupsen/logrus"
+)
+
+// Server is a SOCKS5 server. gosocks only parses
+// CONNECT requests, so the requests are parsed here and CONNECT is handed
+// to a gosocks handler while BIND and UDP ASSOCIATE have handlers of their
+// own
+type Server struct {
+	// Connect handles the CONNECT command
+	Connect socks.ProxyHandler
+	// Bind handles the BIND command. Not supported if nil
+	Bind *SocksTurnBindHandler
+	// Associate handles the UDP ASSOCIATE command. Not supported if nil
+	Associate *SocksTurnUDPHandler
+	// Users are the usernames and passwords clients authenticate with as
+	// defined in RFC 1929. No authentication is needed if empty
+	Users map[string]string
+	// Allow are the networks clients may connect from. All clients are
+	// allowed if empty
+	Allow []netip.Prefix
+	// Timeout is the time the client has to send its request
+	Timeout time.Duration
+	Log     *logrus.Logger
+
+	tracker
+}
+
+// Serve accepts the connections of the listener until it is closed or the
+// server is shut down
+func (s *Server) Serve(listener net.Listener) {
+	s.serve(s.Log, listener, s.handle)
+}
+
+// handle reads the request of the client and hands it to the handler of
+// the command
+func (s *Server) handle(conn net.Conn) {
+	defer conn.Close()
+	defer s.Log.Debug("[socks] client connection closed")
+
+	client, err := netip.ParseAddrPort(conn.RemoteAddr().String())
+	if err != nil {
+		s.Log.Errorf("[socks] invalid client address %s: %v", conn.RemoteAddr(), err)
+		return
+	}
+	s.Log.Debugf("[socks] got connection from %s", client)
+	if !helper.InAllowList(s.Allow, client.Addr()) {
+		s.Log.Warnf("[socks] refusing connection from %s as it is not in the allow list", client)
+		return
+	}
+	if err := conn.SetDeadline(time.Now().Add(s.Timeout)); err != nil {
+		s.Log.Errorf("[socks] could not set deadline: %v", err)
+		return
+	}
+	if err := s.handshake(conn); err != nil {
+		s.Log.Errorf("[socks] handshake with %s failed: %v", client, err)
+		return
+	}
+	command, requested, err := readRequest(conn)
+	if err != nil {
+		s.Log.Errorf("[socks] invalid request from %s: %v", client, err)
+		reply(s.Log, conn, s.Timeout, socks.RequestReplyGeneralFailure, netip.AddrPort{})
+		return
+	}
+	// the handlers set their own deadlines
+	if err := conn.SetDeadline(time.Time{}); err != nil {
+		s.Log.Errorf("[socks] could not reset deadline: %v", err)
+		return
+	}
+
+	switch {
+	case command == socks.RequestCmdConnect:
+		s.connect(conn, requested)
+	case command == socks.RequestCmdBind && s.Bind != nil:
+		s.Bind.handle(conn, client, requested)
+	case command == socks.RequestCmdAssociate && s.Associate != nil:
+		s.Associate.handle(conn, client, requested)
+	default:
+		s.Log.Warnf("[socks] refusing unsupported command %#x of %s", byte(command), client)
+		reply(s.Log, conn, s.Timeout, socks.RequestReplyCommandNotSupported, netip.AddrPort{})
+	}
+}
+
+// connect hands the CONNECT request to the gosocks handler and copies the
+// data like the proxy of gosocks does
+func (s *Server) connect(conn net.Conn, requested helper.SOCKSAddress) {
+	relay(s.Log, s.Connect, conn, requested, func(reason socks.RequestReplyReason, bound netip.AddrPort) error {
+		return reply(s.Log, conn, s.Timeout, reason, bound)
+	})
+}
+
+// relay connects to the requested address with the handler, sends the
+// reply with send and copies the data until one side closes the connection
+func relay(log *logrus.Logger, handler socks.ProxyHandler, conn net.Conn, requested helper.SOCKSAddress, send func(reason socks.RequestReplyReason, bound netip.AddrPort) error) {
+	if h, ok := handler.(sessionHandler); ok {
+		handler = h.session()
+	}
+	defer func() {
+		if err := handler.Cleanup(); err != nil {
+			log.Errorf("[socks] error on cleanup: %v", err)
+		}
+	}()
+
+	log.Infof("[socks] Connecting to %s", requested)
+	remote, socksErr := handler.PreHandler(socksRequest(socks.RequestCmdConnect, requested))
+	if socksErr != nil {
+		log.Errorf("[socks] socks error: %v", socksErr.Err)
+		_ = send(socksErr.Reason, netip.AddrPort{})
+		return
+	}
+	defer remote.Close()
+
+	// the local address of the connection to the TURN server means nothing
+	// to the client, so the relayed address is reported if it is known
+	var bound netip.AddrPort
+	if r, ok := handler.(relayedHandler); ok {
+		bound = r.Relayed()
+	}
+	if err := send(socks.RequestReplySucceeded, bound); err != nil {
+		return
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		if err := handler.CopyFromClientToRemote(ctx, conn, remote); err != nil {
+			log.Errorf("[socks] error on copy from Client to Remote: %v", err)
+		}
+	}()
+	go func() {
+		defer wg.Done()
+		if err := handler.CopyFromRemoteToClient(ctx, remote, conn); err != nil {
+			log.Errorf("[socks] error on copy from Remote to Client: %v", err)
+		}
+	}()
+	go handler.Refresh(ctx)
+	wg.Wait()
+}
+
+// sessionHandler is a handler with state of a single connection. It
+// returns a copy of itself for every connection
+type sessionHandler interface {
+	session() socks.ProxyHandler
+}
+
+// relayedHandler is a handler that knows the relayed address of the
+// allocation the remote connection was opened on
+type relayedHandler interface {
+	Relayed() netip.AddrPort
+}
+
+// socksRequest converts the requested address to the request of the
+// gosocks handlers
+func socksRequest(command socks.RequestCmd, requested helper.SOCKSAddress) socks.Request {
+	return socks.Request{
+		Version:            socks.Version5,
+		Command:            command,
+		AddressType:        socks.RequestAddressType(requested.Type),
+		DestinationAddress: requested.Address,
+		DestinationPort:    requested.Port,
+	}
+}
+
+// socksAddress converts an IP address and port to the address of a SOCKS
+// request
+func socksAddress(addr netip.AddrPort) helper.SOCKSAddress {
+	addressType := helper.SOCKSAddressIPv4
+	if addr.Addr().Is6() {
+		addressType = helper.SOCKSAddressIPv6
+	}
+	return helper.SOCKSAddress{Type: addressType, Address: addr.Addr().AsSlice(), Port: addr.Port()}
+}
+
+// handshake negotiates the authentication method and authenticates the
+// client if users are configured
+func (s *Server) handshake(conn net.Conn) error {
+	header := make([]byte, 2)
+	if _, err := io.ReadFull(conn, header); err != nil {
+		return err
+	}
+	if header[0] != byte(socks.Version5) {
+		return fmt.Errorf("version %#x not supported", header[0])
+	}
+	methods := make([]byte, header[1])
+	if _, err := io.ReadFull(conn, methods); err != nil {
+		return err
+	}
+	method := byte(socks.MethodNoAuthRequired)
+	if len(s.Users) > 0 {
+		method = socks.MethodUsernamePassword
+	}
+	if !bytes.Contains(methods, []byte{method}) {
+		_ = helper.ConnectionWrite(conn, []byte{byte(socks.Version5), socks.MethodNoAcceptableMethods}, s.Timeout)
+		if len(s.Users) > 0 {
+			return fmt.Errorf("client does not support username and password authentication")
+		}
+		return fmt.Errorf("we currently only support no authentication")
+	}
+	if err := helper.ConnectionWrite(conn, []byte{byte(socks.Version5), method}, s.Timeout); err != nil {
+		return err
+	}
+	if method == socks.MethodUsernamePassword {
+		return s.authenticate(conn)
+	}
+	return nil
+}
+
+// authenticate checks the username and password of the client as defined
+// in https://datatracker.ietf.org/doc/html/rfc1929
+func (s *Server) authenticate(conn net.Conn) error {
+	header := make([]byte, 2)
+	if _, err := io.ReadFull(conn, header); err != nil {
+		return err
+	}
+	if header[0] != 0x01 {
+		return fmt.Errorf("authentication version %#x not supported", header[0])
+	}
+	username := make([]byte, header[1])
+	if _, err := io.ReadFull(conn, username); err != nil {
+		return err
+	}
+	length := make([]byte, 1)
+	if _, err := io.ReadFull(conn, length); err != nil {
+		return err
+	}
+	password := make([]byte, length[0])
+	if _, err := io.ReadFull(conn, password); err != nil {
+		return err
+	}
+
+	expected, ok := s.Users[string(username)]
+	// compare anyway so unknown users take as long as wrong passwords
+	valid := subtle.ConstantTimeCompare([]byte(expected), password) == 1 && ok
+	status := byte(0x00)
+	if !valid {
+		status = 0x01
+	}
+	if err := helper.ConnectionWrite(conn, []byte{0x01, status}, s.Timeout); err != nil {
+		return err
+	}
+	if !valid {
+		return fmt.Errorf("invalid credentials for user %q", username)
+	}
+	s.Log.Debugf("[socks] %s authenticated as %q", conn.RemoteAddr(), username)
+	return nil
+}
+
+// readRequest reads the command and the address of a request
+func readRequest(conn net.Conn) (socks.RequestCmd, helper.SOCKSAddress, error) {
+	// version, command, reserved and the start of the address
+	buf := make([]byte, 5)
+	if _, err := io.ReadFull(conn, buf); err != nil {
+		return 0, helper.SOCKSAddress{}, err
+	}
+	if buf[0] != byte(socks.Version5) {
+		return 0, helper.SOCKSAddress{}, fmt.Errorf("version %#x not supported", buf[0])
+	}
+	length, err := helper.SOCKSAddressLength(buf[3:])
+	if err != nil {
+		return 0, helper.SOCKSAddress{}, err
+	}
+	rest := make([]byte, length-2)
+	if _, err := io.ReadFull(conn, rest); err != nil {
+		return 0, helper.SOCKSAddress{}, err
+	}
+	addr, _, err := helper.ParseSOCKSAddress(append(buf[3:], rest...))
+	if err != nil {
+		return 0, helper.SOCKSAddress{}, err
+	}
+	return socks.RequestCmd(buf[1]), addr, nil
+}
+
+// reply sends the reply to a request
+func reply(log *logrus.Logger, conn net.Conn, timeout time.Duration, reason socks.RequestReplyReason, bound netip.AddrPort) error {
+	if err := helper.ConnectionWrite(conn, helper.SOCKSReply(reason.Value(), bound), timeout); err != nil {
+		log.Debugf("[socks] could not send reply: %v", err)
+		return err
+	}
+	return nil
+}
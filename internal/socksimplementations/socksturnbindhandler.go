@@ -0,0 +1,290 @@
+package socksimplementations
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/netip"
+	"time"
+
+	socks "github.com/firefart/gosocks"
+	"github.com/firefart/stunner/internal"
+	"github.com/firefart/stunner/internal/helper"
+
+	"github.com/sirupsen/logrus"
+)
+
+// SocksTurnBindHandler handles the SOCKS5 BIND command with a TCP
+// allocation. The peer connects to the relayed address of the allocation
+// and the connection is handed to the client, like the data connection of
+// active FTP
+type SocksTurnBindHandler struct {
+	Ctx                    context.Context
+	TURNUsername           string
+	TURNPassword           string
+	Server                 string
+	Timeout                time.Duration
+	UseTLS                 bool
+	TlsVerify              bool
+	DropNonPrivateRequests bool
+	Log                    *logrus.Logger
+	// AcceptTimeout is the time to wait for the peer to connect
+	AcceptTimeout time.Duration
+	// ACL restricts the destinations by range and port. Can be nil
+	ACL *helper.ACL
+	// StrictDNS refuses requests with domain names that would be resolved
+	// locally. Only IP addresses and names resolved by Resolver are allowed
+	StrictDNS bool
+	// Resolver resolves domain names instead of the local resolver. Can be nil
+	Resolver Resolver
+	// Credentials returns the credentials for every new allocation instead
+	// of TURNUsername and TURNPassword. Can be nil
+	Credentials helper.CredentialProvider
+	// CopyOptions are the buffer size and the write timeout of the data copy
+	CopyOptions helper.CopyOptions
+	// QuietHours are the time windows in which new connections are refused
+	QuietHours helper.QuietHours
+	// Pauser refuses new connections while it is paused. Can be nil
+	Pauser *helper.Pauser
+}
+
+// handle allocates a relay, sends its address in the first reply and the
+// address of the peer in the second reply once it connected
+func (s *SocksTurnBindHandler) handle(conn net.Conn, client netip.AddrPort, requested helper.SOCKSAddress) {
+	if s.QuietHours.Active(time.Now()) {
+		s.Log.Warnf("[socks] refusing BIND of %s during quiet hours", client)
+		reply(s.Log, conn, s.Timeout, socks.RequestReplyConnectionNotAllowed, netip.AddrPort{})
+		return
+	}
+	if s.Pauser.Paused() {
+		s.Log.Warnf("[socks] refusing BIND of %s while paused", client)
+		reply(s.Log, conn, s.Timeout, socks.RequestReplyConnectionNotAllowed, netip.AddrPort{})
+		return
+	}
+
+	// the requested address is the peer that is expected to connect
+	peer, socksErr := destination(s.Ctx, s.Log, socksRequest(socks.RequestCmdBind, requested), s.StrictDNS, s.Resolver)
+	if socksErr != nil {
+		s.Log.Errorf("[socks] socks error: %v", socksErr.Err)
+		reply(s.Log, conn, s.Timeout, socksErr.Reason, netip.AddrPort{})
+		return
+	}
+	if s.DropNonPrivateRequests && !helper.IsPrivateIP(peer) {
+		s.Log.Debugf("dropping BIND for non private peer %s", peer.String())
+		reply(s.Log, conn, s.Timeout, socks.RequestReplyHostUnreachable, netip.AddrPort{})
+		return
+	}
+	if !s.ACL.Allowed(peer, requested.Port) {
+		s.Log.Warnf("[socks] BIND for %s denied by the ACL", peer.String())
+		reply(s.Log, conn, s.Timeout, socks.RequestReplyConnectionNotAllowed, netip.AddrPort{})
+		return
+	}
+
+	allocation, err := s.allocate(peer)
+	if err != nil {
+		s.Log.Errorf("[socks] could not allocate a relay for the BIND of %s: %v", client, err)
+		reason := socks.RequestReplyGeneralFailure
+		if relayErr := relayError(err); relayErr.Reason == socks.RequestReplyAddressTypeNotSupported {
+			reason = relayErr.Reason
+		}
+		reply(s.Log, conn, s.Timeout, reason, netip.AddrPort{})
+		return
+	}
+	defer allocation.Close()
+
+	s.Log.Infof("[socks] waiting for %s to connect to %s", peer, allocation.Relayed())
+	if err := reply(s.Log, conn, s.Timeout, socks.RequestReplySucceeded, allocation.Relayed()); err != nil {
+		return
+	}
+
+	remote, from, early, err := s.accept(conn, allocation, peer)
+	if err != nil {
+		s.Log.Errorf("[socks] BIND of %s failed: %v", client, err)
+		reply(s.Log, conn, s.Timeout, socks.RequestReplyTTLExpired, netip.AddrPort{})
+		return
+	}
+	defer remote.Close()
+	s.Log.Infof("[socks] %s connected to %s", from, allocation.Relayed())
+	if err := reply(s.Log, conn, s.Timeout, socks.RequestReplySucceeded, from); err != nil {
+		return
+	}
+
+	stop := make(chan struct{})
+	defer close(stop)
+	go func() {
+		if err := s.keepAlive(allocation, peer, stop); err != nil {
+			s.Log.Errorf("[socks] error on refreshing the allocation of the BIND: %v", err)
+		}
+	}()
+	// data the client sent while waiting for the peer
+	if len(early) > 0 {
+		if err := helper.ConnectionWrite(remote, early, s.Timeout); err != nil {
+			s.Log.Debugf("[socks] error on copy from the client to the peer: %v", err)
+			return
+		}
+	}
+
+	ctx := s.Ctx
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		if _, err := helper.CopyContext(ctx, conn, remote, s.CopyOptions); err != nil {
+			s.Log.Debugf("[socks] error on copy from the peer to the client: %v", err)
+		}
+		// stop the other direction as well
+		cancel()
+		conn.Close()
+	}()
+	if _, err := helper.CopyContext(ctx, remote, conn, s.CopyOptions); err != nil {
+		s.Log.Debugf("[socks] error on copy from the client to the peer: %v", err)
+	}
+	cancel()
+	remote.Close()
+	<-done
+}
+
+// allocate allocates a TCP relay and permits the peer to connect to it
+func (s *SocksTurnBindHandler) allocate(peer netip.Addr) (*internal.TCPAllocation, error) {
+	creds, err := s.credentials()
+	if err != nil {
+		return nil, fmt.Errorf("could not get credentials from %s: %w", s.Credentials, err)
+	}
+	addressFamily := internal.AddressFamily(peer)
+	allocation, err := internal.NewTCPAllocation(s.Log, s.Server, s.UseTLS, s.TlsVerify, s.Timeout, addressFamily, creds.Username, creds.Password)
+	if err != nil {
+		return nil, err
+	}
+	if !allocation.Relayed().IsValid() {
+		allocation.Close()
+		return nil, fmt.Errorf("the server did not send the relayed address")
+	}
+	if err := allocation.Permit(peer); err != nil {
+		allocation.Close()
+		return nil, err
+	}
+	return allocation, nil
+}
+
+// accept waits for the peer to connect. It gives up early if the client
+// closes its connection. Data the client sent while waiting is returned,
+// so it can be sent to the peer
+func (s *SocksTurnBindHandler) accept(conn net.Conn, allocation *internal.TCPAllocation, peer netip.Addr) (*net.TCPConn, netip.AddrPort, []byte, error) {
+	type result struct {
+		remote *net.TCPConn
+		from   netip.AddrPort
+		err    error
+	}
+	accepted := make(chan result, 1)
+	go func() {
+		remote, from, err := s.waitForPeer(allocation, peer)
+		accepted <- result{remote: remote, from: from, err: err}
+	}()
+
+	type clientRead struct {
+		data []byte
+		err  error
+	}
+	watched := make(chan clientRead, 1)
+	go func() {
+		// the client should send no data before the second reply
+		buf := make([]byte, 1)
+		n, err := conn.Read(buf)
+		watched <- clientRead{data: buf[:n], err: err}
+	}()
+
+	var early []byte
+	var r result
+	select {
+	case r = <-accepted:
+		// stop watching the client connection
+		if err := conn.SetReadDeadline(time.Now()); err != nil {
+			s.Log.Debugf("[socks] could not set deadline: %v", err)
+		}
+		early = (<-watched).data
+		if err := conn.SetReadDeadline(time.Time{}); err != nil {
+			s.Log.Debugf("[socks] could not reset deadline: %v", err)
+		}
+	case c := <-watched:
+		if len(c.data) == 0 || c.err != nil {
+			// unblocks Accept
+			allocation.Close()
+			if r := <-accepted; r.remote != nil {
+				r.remote.Close()
+			}
+			return nil, netip.AddrPort{}, nil, fmt.Errorf("client closed the connection")
+		}
+		// the client sent data early, a close is noticed on the copy
+		early = c.data
+		r = <-accepted
+	}
+
+	if r.err != nil {
+		return nil, netip.AddrPort{}, nil, r.err
+	}
+	// the data connection has the deadlines of the handshake
+	if err := r.remote.SetDeadline(time.Time{}); err != nil {
+		r.remote.Close()
+		return nil, netip.AddrPort{}, nil, fmt.Errorf("could not reset deadline: %w", err)
+	}
+	return r.remote, r.from, early, nil
+}
+
+// waitForPeer accepts the connection of the peer within the accept
+// timeout. The permission of the peer expires after 5 minutes, so it is
+// renewed along with the allocation while waiting
+func (s *SocksTurnBindHandler) waitForPeer(allocation *internal.TCPAllocation, peer netip.Addr) (*net.TCPConn, netip.AddrPort, error) {
+	deadline := time.Now().Add(s.AcceptTimeout)
+	for {
+		wait := time.Until(deadline)
+		if wait > refreshInterval {
+			wait = refreshInterval
+		}
+		remote, from, err := allocation.Accept(wait)
+		if !errors.Is(err, helper.ErrTimeout) || !time.Now().Before(deadline) {
+			return remote, from, err
+		}
+		if err := s.renew(allocation, peer); err != nil {
+			return nil, netip.AddrPort{}, err
+		}
+	}
+}
+
+// keepAlive renews the allocation and the permission of the peer every
+// refresh interval until stop is closed or a refresh fails
+func (s *SocksTurnBindHandler) keepAlive(allocation *internal.TCPAllocation, peer netip.Addr, stop <-chan struct{}) error {
+	tick := time.NewTicker(refreshInterval)
+	defer tick.Stop()
+	for {
+		select {
+		case <-stop:
+			return nil
+		case <-tick.C:
+		}
+		if err := s.renew(allocation, peer); err != nil {
+			return err
+		}
+	}
+}
+
+// renew refreshes the allocation and the permission of the peer
+func (s *SocksTurnBindHandler) renew(allocation *internal.TCPAllocation, peer netip.Addr) error {
+	s.Log.Debugf("[socks] refreshing the allocation of the BIND for %s", peer)
+	if _, err := allocation.Refresh(); err != nil {
+		return err
+	}
+	return allocation.Permit(peer)
+}
+
+// credentials returns the credentials for a new allocation
+func (s *SocksTurnBindHandler) credentials() (helper.Credentials, error) {
+	if s.Credentials == nil {
+		return helper.Credentials{Username: s.TURNUsername, Password: s.TURNPassword}, nil
+	}
+	return s.Credentials.Credentials(s.Ctx)
+}
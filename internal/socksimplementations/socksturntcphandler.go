@@ -2,6 +2,7 @@ package socksimplementations
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"io"
 	"net"
@@ -27,30 +28,86 @@ type SocksTurnTCPHandler struct {
 	TlsVerify              bool
 	DropNonPrivateRequests bool
 	Log                    *logrus.Logger
+	// ConnectRetries is the number of times a connection is retried if
+	// the server returned a temporary error like Insufficient Capacity
+	ConnectRetries int
+	// RetryBackoff is the time to wait before the first retry. It is
+	// doubled on every further retry
+	RetryBackoff time.Duration
+	// Enricher adds ASN and reverse DNS information to the connection
+	// log of public destinations. Can be nil
+	Enricher *helper.Enricher
+	// Pace sends the client data in packets of the size and interval of
+	// a WebRTC video stream instead of as fast as possible
+	Pace bool
+	// Shaping adds random delays, batching and random packet sizes to the
+	// client data. Applied on top of Pace
+	Shaping helper.Shaping
+	// CopyOptions are the buffer size and the write timeout of the data copy
+	CopyOptions helper.CopyOptions
+	// SessionTimeout closes connections after this time. 0 disables it
+	SessionTimeout time.Duration
+	// QuietHours are the time windows in which new connections are refused
+	QuietHours helper.QuietHours
+	// Pauser refuses new connections while it is paused. Can be nil
+	Pauser *helper.Pauser
+	// Allocation is a handed off allocation all connections are opened
+	// on. If nil every connection uses its own allocation
+	Allocation *internal.TCPAllocation
+	// Pool shares allocations between connections. Not used if nil or if
+	// Allocation is set
+	Pool *internal.TCPAllocationPool
+	// Stats counts the traffic of the connections. Can be nil
+	Stats *Stats
+	// ACL restricts the destinations by range and port. Can be nil
+	ACL *helper.ACL
+	// StrictDNS refuses requests with domain names that would be resolved
+	// locally. Only IP addresses and names resolved by Resolver are allowed
+	StrictDNS bool
+	// Resolver resolves domain names instead of the local resolver. Can be nil
+	Resolver Resolver
+	// Credentials returns the credentials for every new allocation instead
+	// of TURNUsername and TURNPassword. Can be nil
+	Credentials helper.CredentialProvider
+
+	// the state of a single connection, set on the copy returned by session
+	//
+	// allocation is the allocation of the control connection. It is nil
+	// for handed off and pooled allocations
+	allocation *internal.TCPAllocation
+	// controlCredentials are the credentials the allocation of the control
+	// connection was created with
+	controlCredentials helper.Credentials
+	// target is the peer the allocation has a permission for
+	target netip.Addr
+	// remote is the data connection to the target
+	remote net.Conn
+	// relayed is the relayed address of the allocation of the data
+	// connection, it is zero if it is not known
+	relayed netip.AddrPort
+}
+
+// session returns a copy of the handler for a single connection, so the
+// state of the connection is not shared with other connections
+func (s *SocksTurnTCPHandler) session() socks.ProxyHandler {
+	c := *s
+	return &c
 }
 
 // PreHandler connects to the STUN server, sets the connection up and returns the data connections
 func (s *SocksTurnTCPHandler) PreHandler(request socks.Request) (io.ReadWriteCloser, *socks.Error) {
-	var target netip.Addr
-	var err error
-	switch request.AddressType {
-	case socks.RequestAddressTypeIPv4, socks.RequestAddressTypeIPv6:
-		tmp, ok := netip.AddrFromSlice(request.DestinationAddress)
-		if !ok {
-			return nil, &socks.Error{Reason: socks.RequestReplyAddressTypeNotSupported, Err: fmt.Errorf("%02x is no ip address", request.DestinationAddress)}
-		}
-		target = tmp
-	case socks.RequestAddressTypeDomainname:
-		names, err := helper.ResolveName(s.Ctx, string(request.DestinationAddress))
-		if err != nil {
-			return nil, &socks.Error{Reason: socks.RequestReplyHostUnreachable, Err: err}
-		}
-		if len(names) == 0 {
-			return nil, &socks.Error{Reason: socks.RequestReplyHostUnreachable, Err: fmt.Errorf("%s could not be resolved", string(request.DestinationAddress))}
-		}
-		target = names[0]
-	default:
-		return nil, &socks.Error{Reason: socks.RequestReplyAddressTypeNotSupported, Err: fmt.Errorf("AddressType %#x not implemented", request.AddressType)}
+	if s.QuietHours.Active(time.Now()) {
+		s.Log.Warnf("[socks] refusing connection to port %d during quiet hours", request.DestinationPort)
+		return nil, &socks.Error{Reason: socks.RequestReplyConnectionNotAllowed, Err: fmt.Errorf("connections are not allowed during quiet hours")}
+	}
+	if s.Pauser.Paused() {
+		s.Log.Warnf("[socks] refusing connection to port %d while paused", request.DestinationPort)
+		return nil, &socks.Error{Reason: socks.RequestReplyConnectionNotAllowed, Err: fmt.Errorf("connections are not allowed while paused")}
+	}
+
+	target, socksErr := destination(s.Ctx, s.Log, request, s.StrictDNS, s.Resolver)
+	if socksErr != nil {
+		return nil, socksErr
 	}
 
 	if s.DropNonPrivateRequests && !helper.IsPrivateIP(target) {
@@ -58,54 +115,245 @@ func (s *SocksTurnTCPHandler) PreHandler(request socks.Request) (io.ReadWriteClo
 		return nil, &socks.Error{Reason: socks.RequestReplyHostUnreachable, Err: fmt.Errorf("dropping non private connection to %s:%d", target.String(), request.DestinationPort)}
 	}
 
-	controlConnection, dataConnection, err := internal.SetupTurnTCPConnection(s.Log, s.Server, s.UseTLS, s.TlsVerify, s.Timeout, target, request.DestinationPort, s.TURNUsername, s.TURNPassword)
+	if !s.ACL.Allowed(target, request.DestinationPort) {
+		return nil, &socks.Error{Reason: socks.RequestReplyConnectionNotAllowed, Err: fmt.Errorf("%s:%d is denied by the ACL", target.String(), request.DestinationPort)}
+	}
+
+	creds, err := s.credentials()
+	if err != nil {
+		s.Log.Errorf("[socks] could not get credentials from %s: %v", s.Credentials, err)
+		return nil, &socks.Error{Reason: socks.RequestReplyGeneralFailure, Err: err}
+	}
+
+	allocation, dataConnection, err := s.setupConnection(target, request.DestinationPort, creds)
 	if err != nil {
-		return nil, &socks.Error{Reason: socks.RequestReplyHostUnreachable, Err: err}
+		return nil, relayError(err)
 	}
 
-	// we need to keep this connection open
-	s.ControlConnection = controlConnection
+	s.logConnection(target, request.DestinationPort)
+
+	if allocation != nil {
+		// we need to keep this connection open
+		s.allocation = allocation
+		s.ControlConnection = allocation.Control
+	}
+	s.controlCredentials = creds
+	s.target = target
+	s.relayed = s.relayedAddress(allocation, dataConnection)
+	if s.Stats != nil {
+		dataConnection = s.Stats.track(dataConnection, netip.AddrPortFrom(target, request.DestinationPort))
+	}
+	s.remote = dataConnection
 	return dataConnection, nil
 }
 
-// Refresh is used to refresh an active connection every 2 minutes
+// setupConnection connects to the target via the TURN server and returns
+// the new allocation, which is nil if the connection was opened on a
+// shared allocation, and the data connection. Temporary errors of busy
+// servers are retried with an exponential backoff
+func (s *SocksTurnTCPHandler) setupConnection(target netip.Addr, port uint16, creds helper.Credentials) (*internal.TCPAllocation, net.Conn, error) {
+	backoff := s.RetryBackoff
+	for i := 0; ; i++ {
+		var allocation *internal.TCPAllocation
+		var dataConnection net.Conn
+		var err error
+		switch {
+		case s.Allocation != nil:
+			// the control connection belongs to the shared allocation
+			dataConnection, err = s.Allocation.Dial(target, port)
+		case s.Pool != nil:
+			// the control connection belongs to the pool
+			dataConnection, err = s.Pool.Dial(target, port)
+		default:
+			allocation, dataConnection, err = s.allocate(target, port, creds)
+		}
+		if err == nil {
+			return allocation, dataConnection, nil
+		}
+		if i >= s.ConnectRetries || !internal.IsTransientError(err) {
+			return nil, nil, err
+		}
+		s.Log.Warnf("[socks] connection to %s:%d failed with a temporary error, retrying in %s (%d/%d): %v", target.String(), port, backoff, i+1, s.ConnectRetries, err)
+		select {
+		case <-s.Ctx.Done():
+			return nil, nil, s.Ctx.Err()
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+	}
+}
+
+// relayedAddress returns the relayed address of the allocation the data
+// connection was opened on. Handed off allocations do not know it
+func (s *SocksTurnTCPHandler) relayedAddress(allocation *internal.TCPAllocation, dataConnection net.Conn) netip.AddrPort {
+	switch {
+	case allocation != nil:
+		return allocation.Relayed()
+	case s.Allocation != nil:
+		return s.Allocation.Relayed()
+	}
+	if pooled, ok := dataConnection.(*internal.PooledConn); ok {
+		return pooled.Relayed()
+	}
+	return netip.AddrPort{}
+}
+
+// Relayed returns the relayed address of the allocation of the connection,
+// which is reported to the client as the bound address
+func (s *SocksTurnTCPHandler) Relayed() netip.AddrPort {
+	return s.relayed
+}
+
+// credentials returns the credentials for a new allocation
+func (s *SocksTurnTCPHandler) credentials() (helper.Credentials, error) {
+	// a handed off allocation needs no credentials and the pool gets
+	// them when it allocates
+	if s.Credentials == nil || s.Allocation != nil || s.Pool != nil {
+		return helper.Credentials{Username: s.TURNUsername, Password: s.TURNPassword}, nil
+	}
+	return s.Credentials.Credentials(s.Ctx)
+}
+
+// allocate allocates a TCP relay and connects it to the target
+func (s *SocksTurnTCPHandler) allocate(target netip.Addr, port uint16, creds helper.Credentials) (*internal.TCPAllocation, net.Conn, error) {
+	addressFamily := internal.AddressFamily(target)
+	allocation, err := internal.NewTCPAllocation(s.Log, s.Server, s.UseTLS, s.TlsVerify, s.Timeout, addressFamily, creds.Username, creds.Password)
+	if err != nil {
+		return nil, nil, err
+	}
+	dataConnection, err := allocation.Dial(target, port)
+	if err != nil {
+		allocation.Close()
+		return nil, nil, err
+	}
+	return allocation, dataConnection, nil
+}
+
+// logConnection logs every destination reached through the relay. If the
+// destination is public it is enriched with ASN and reverse DNS data
+func (s *SocksTurnTCPHandler) logConnection(target netip.Addr, port uint16) {
+	entry := logrus.NewEntry(s.Log)
+	if enrichment, ok := s.Enricher.Lookup(s.Ctx, target); ok {
+		for k, v := range enrichment.Fields() {
+			entry = entry.WithField(k, v)
+		}
+	}
+	entry.Infof("[socks] connected to %s:%d", target.String(), port)
+}
+
+// refreshInterval is the time between two refreshes of an allocation
+const refreshInterval = 2 * time.Minute
+
+// finalLifetime is requested on the last refresh before the credentials
+// expire. Servers cap it at their maximum lifetime, an hour by default
+const finalLifetime = 24 * time.Hour
+
+// Refresh refreshes the allocation of the connection and the permission
+// of the target before they expire, every 2 minutes or after half of the
+// granted lifetime if that is shorter. Before time-limited credentials
+// expire the allocation is refreshed with the longest lifetime, so running
+// transfers outlive the credentials instead of failing on the next
+// refresh. On handed off allocations only the permission is renewed and
+// pooled allocations are renewed by the pool. If a refresh fails the
+// connection is closed
 func (s *SocksTurnTCPHandler) Refresh(ctx context.Context) {
-	nonce := ""
-	realm := ""
-	tick := time.NewTicker(2 * time.Minute)
-	select {
-	case <-ctx.Done():
+	switch {
+	case s.Allocation != nil:
+		// the owner of a handed off allocation refreshes it, but only the
+		// connection knows its target
+		s.refreshPermission(ctx, s.Allocation)
+		return
+	case s.allocation == nil:
+		// the pool refreshes its allocations and the permissions of the
+		// targets they were used for
 		return
-	case <-tick.C:
+	}
+	for {
+		timer := time.NewTimer(s.refreshDelay())
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+		final := s.controlCredentials.ExpiresWithin(2 * refreshInterval)
+		var lifetime time.Duration
+		if final {
+			lifetime = finalLifetime
+		}
 		s.Log.Debug("[socks] refreshing connection")
-		refresh := internal.RefreshRequest(s.TURNUsername, s.TURNPassword, nonce, realm)
-		response, err := refresh.SendAndReceive(s.Log, s.ControlConnection, s.Timeout)
+		granted, err := s.allocation.RefreshLifetime(lifetime)
 		if err != nil {
-			s.Log.Error(err)
+			s.teardown(ctx, fmt.Errorf("could not refresh the allocation: %w", err))
+			return
+		}
+		if final {
+			// refreshes after the expiry are rejected
+			s.Log.Warnf("[socks] the credentials of the connection expire soon, refreshed it a last time for %s", granted)
+			return
+		}
+		// permissions expire after 5 minutes and are not renewed by the
+		// data of the connection
+		if err := s.allocation.Permit(s.target); err != nil {
+			s.teardown(ctx, fmt.Errorf("could not refresh the permission of %s: %w", s.target, err))
+			return
+		}
+	}
+}
+
+// refreshPermission renews the permission of the target on a shared
+// allocation every 2 minutes. If it fails the connection is closed
+func (s *SocksTurnTCPHandler) refreshPermission(ctx context.Context, allocation *internal.TCPAllocation) {
+	for {
+		timer := time.NewTimer(refreshInterval)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
 			return
+		case <-timer.C:
 		}
-		// should happen on a stale nonce
-		if response.Header.MessageType.Class == internal.MsgTypeClassError {
-			realm := string(response.GetAttribute(internal.AttrRealm).Value)
-			nonce := string(response.GetAttribute(internal.AttrNonce).Value)
-			refresh = internal.RefreshRequest(s.TURNUsername, s.TURNPassword, nonce, realm)
-			response, err = refresh.SendAndReceive(s.Log, s.ControlConnection, s.Timeout)
-			if err != nil {
-				s.Log.Error(err)
-				return
-			}
-			if response.Header.MessageType.Class == internal.MsgTypeClassError {
-				s.Log.Error(response.GetErrorString())
-				return
-			}
+		// permissions expire after 5 minutes and are not renewed by the
+		// data of the connection
+		if err := allocation.Permit(s.target); err != nil {
+			s.teardown(ctx, fmt.Errorf("could not refresh the permission of %s: %w", s.target, err))
+			return
 		}
 	}
 }
 
+// refreshDelay returns the time until the next refresh
+func (s *SocksTurnTCPHandler) refreshDelay() time.Duration {
+	delay := refreshInterval
+	if half := s.allocation.Lifetime() / 2; half > 0 && half < delay {
+		delay = half
+	}
+	return delay
+}
+
+// teardown closes the connection after a failed refresh, so the copies
+// end instead of waiting on a connection the server dropped
+func (s *SocksTurnTCPHandler) teardown(ctx context.Context, err error) {
+	// the connection was closed while refreshing
+	if ctx.Err() != nil {
+		return
+	}
+	s.Log.Errorf("[socks] closing the connection to %s: %v", s.target, err)
+	s.remote.Close()
+	// shared allocations stay open for the other connections
+	if s.allocation != nil {
+		s.allocation.Close()
+	}
+}
+
 // CopyFromRemoteToClient is used to copy data
 func (s *SocksTurnTCPHandler) CopyFromRemoteToClient(ctx context.Context, remote io.ReadCloser, client io.WriteCloser) error {
-	i, err := io.Copy(client, remote)
+	ctx, cancel := s.sessionContext(ctx)
+	defer cancel()
+	i, err := helper.CopyContext(ctx, client, remote, s.CopyOptions)
 	if err != nil {
+		// stop the other direction as well
+		remote.Close()
+		client.Close()
 		return fmt.Errorf("CopyFromRemoteToClient: %w", err)
 	}
 	s.Log.Debugf("[socks] wrote %d bytes to client", i)
@@ -114,18 +362,63 @@ func (s *SocksTurnTCPHandler) CopyFromRemoteToClient(ctx context.Context, remote
 
 // CopyFromClientToRemote is used to copy data
 func (s *SocksTurnTCPHandler) CopyFromClientToRemote(ctx context.Context, client io.ReadCloser, remote io.WriteCloser) error {
-	i, err := io.Copy(remote, client)
+	ctx, cancel := s.sessionContext(ctx)
+	defer cancel()
+	var w io.Writer = remote
+	if s.Pace {
+		w = helper.NewPacedWriter(remote, helper.MediaPacketSize, helper.MediaPacketInterval)
+	}
+	var i int64
+	var err error
+	if s.Shaping.Enabled() {
+		i, err = helper.ShapedCopy(ctx, w, client, s.Shaping, s.CopyOptions)
+	} else {
+		i, err = helper.CopyContext(ctx, w, client, s.CopyOptions)
+	}
 	if err != nil {
+		// stop the other direction as well
+		remote.Close()
+		client.Close()
 		return fmt.Errorf("CopyFromClientToRemote: %w", err)
 	}
 	s.Log.Debugf("[socks] wrote %d bytes to remote", i)
 	return nil
 }
 
-// Cleanup closes the stored control connection
+// sessionContext returns a context that is done when the context of the
+// session or the context of the handler is done, so copies stop on
+// shutdown. It also ends once the session timeout is reached
+func (s *SocksTurnTCPHandler) sessionContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	var cancel context.CancelFunc
+	if s.SessionTimeout > 0 {
+		ctx, cancel = context.WithTimeout(ctx, s.SessionTimeout)
+	} else {
+		ctx, cancel = context.WithCancel(ctx)
+	}
+	if s.Ctx == nil {
+		return ctx, cancel
+	}
+	go func() {
+		select {
+		case <-s.Ctx.Done():
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+	return ctx, cancel
+}
+
+// Cleanup closes the stored control connection. It may already be closed
+// by a failed refresh
 func (s *SocksTurnTCPHandler) Cleanup() error {
-	if s.ControlConnection != nil {
-		return s.ControlConnection.Close()
+	var err error
+	if s.allocation != nil {
+		err = s.allocation.Close()
+	} else if s.ControlConnection != nil {
+		err = s.ControlConnection.Close()
 	}
-	return nil
+	if errors.Is(err, net.ErrClosed) {
+		return nil
+	}
+	return err
 }
@@ -2,10 +2,12 @@ package socksimplementations
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"io"
 	"net"
 	"net/netip"
+	"sync"
 	"time"
 
 	socks "github.com/firefart/gosocks"
@@ -15,137 +17,315 @@ import (
 	"github.com/sirupsen/logrus"
 )
 
-// SocksTurnUDPHandler is the implementation of a UDP TURN server
+// SocksTurnUDPHandler handles the SOCKS5 UDP ASSOCIATE command. Every
+// destination of an association gets its own allocation with a channel
+// bound to it, the datagrams are relayed as ChannelData
 type SocksTurnUDPHandler struct {
 	Ctx                    context.Context
 	TURNUsername           string
 	TURNPassword           string
 	Server                 string
 	ConnectProtocol        string
-	channelNumber          []byte
 	Timeout                time.Duration
 	UseTLS                 bool
 	TlsVerify              bool
 	DropNonPrivateRequests bool
 	Log                    *logrus.Logger
+	// ACL restricts the destinations by range and port. Can be nil
+	ACL *helper.ACL
+	// StrictDNS refuses requests with domain names that would be resolved
+	// locally. Only IP addresses and names resolved by Resolver are allowed
+	StrictDNS bool
+	// Resolver resolves domain names instead of the local resolver. Can be nil
+	Resolver Resolver
+	// Credentials returns the credentials for every new allocation instead
+	// of TURNUsername and TURNPassword. Can be nil
+	Credentials helper.CredentialProvider
+	// QuietHours are the time windows in which new associations are refused
+	QuietHours helper.QuietHours
+	// Pauser refuses new associations while it is paused. Can be nil
+	Pauser *helper.Pauser
 }
 
-// PreHandler creates a connection to the target server and returns a connection to send data
-func (s *SocksTurnUDPHandler) PreHandler(request socks.Request) (io.ReadWriteCloser, *socks.Error) {
-	var target netip.Addr
-	var err error
-	switch request.AddressType {
-	case socks.RequestAddressTypeIPv4, socks.RequestAddressTypeIPv6:
-		tmp, ok := netip.AddrFromSlice(request.DestinationAddress)
-		if !ok {
-			return nil, &socks.Error{Reason: socks.RequestReplyAddressTypeNotSupported, Err: fmt.Errorf("%02x is no ip address", request.DestinationAddress)}
-		}
-		target = tmp
-	case socks.RequestAddressTypeDomainname:
-		names, err := helper.ResolveName(s.Ctx, string(request.DestinationAddress))
-		if err != nil {
-			return nil, &socks.Error{Reason: socks.RequestReplyHostUnreachable, Err: err}
-		}
-		if len(names) == 0 {
-			return nil, &socks.Error{Reason: socks.RequestReplyHostUnreachable, Err: fmt.Errorf("%s could not be resolved", string(request.DestinationAddress))}
-		}
-		target = names[0]
-	default:
-		return nil, &socks.Error{Reason: socks.RequestReplyAddressTypeNotSupported, Err: fmt.Errorf("AddressType %#x not implemented", request.AddressType)}
+// handle sets up the association requested on the control connection.
+// The association lasts until the client closes the control connection
+func (s *SocksTurnUDPHandler) handle(conn net.Conn, client netip.AddrPort, requested helper.SOCKSAddress) {
+	if s.QuietHours.Active(time.Now()) {
+		s.Log.Warnf("[socks] refusing UDP association of %s during quiet hours", client)
+		reply(s.Log, conn, s.Timeout, socks.RequestReplyConnectionNotAllowed, netip.AddrPort{})
+		return
 	}
-
-	if s.DropNonPrivateRequests && !helper.IsPrivateIP(target) {
-		s.Log.Debugf("dropping non private connection to %s:%d", target.String(), request.DestinationPort)
-		return nil, &socks.Error{Reason: socks.RequestReplyHostUnreachable, Err: fmt.Errorf("dropping non private connection to %s:%d", target.String(), request.DestinationPort)}
+	if s.Pauser.Paused() {
+		s.Log.Warnf("[socks] refusing UDP association of %s while paused", client)
+		reply(s.Log, conn, s.Timeout, socks.RequestReplyConnectionNotAllowed, netip.AddrPort{})
+		return
 	}
 
-	remote, realm, nonce, err := internal.SetupTurnConnection(s.Log, s.ConnectProtocol, s.Server, s.UseTLS, s.TlsVerify, s.Timeout, target, request.DestinationPort, s.TURNUsername, s.TURNPassword)
+	// the relay socket is bound to the address the client reached us on
+	local, err := netip.ParseAddrPort(conn.LocalAddr().String())
 	if err != nil {
-		return nil, &socks.Error{Reason: socks.RequestReplyHostUnreachable, Err: err}
+		s.Log.Errorf("[socks] invalid local address %s: %v", conn.LocalAddr(), err)
+		return
 	}
-	defer remote.Close()
-
-	s.channelNumber = helper.RandomChannelNumber()
-	channelBindRequest, err := internal.ChannelBindRequest(s.TURNUsername, s.TURNPassword, nonce, realm, target, request.DestinationPort, s.channelNumber)
+	udpConn, err := net.ListenUDP("udp", net.UDPAddrFromAddrPort(netip.AddrPortFrom(local.Addr(), 0)))
 	if err != nil {
-		return nil, &socks.Error{Reason: socks.RequestReplyHostUnreachable, Err: fmt.Errorf("error on generating ChannelBindRequest: %w", err)}
+		s.Log.Errorf("[socks] could not listen for the UDP association of %s: %v", client, err)
+		reply(s.Log, conn, s.Timeout, socks.RequestReplyGeneralFailure, netip.AddrPort{})
+		return
 	}
-	s.Log.Debugf("ChannelBind Request:\n%s", channelBindRequest.String())
-	channelBindResponse, err := channelBindRequest.SendAndReceive(s.Log, remote, s.Timeout)
-	if err != nil {
-		return nil, &socks.Error{Reason: socks.RequestReplyHostUnreachable, Err: fmt.Errorf("error on sending ChannelBindRequest: %w", err)}
+	defer udpConn.Close()
+	bound := udpConn.LocalAddr().(*net.UDPAddr).AddrPort()
+	if err := reply(s.Log, conn, s.Timeout, socks.RequestReplySucceeded, bound); err != nil {
+		return
 	}
-	s.Log.Debugf("ChannelBind Response:\n%s", channelBindResponse.String())
-	if channelBindResponse.Header.MessageType.Class == internal.MsgTypeClassError {
-		return nil, &socks.Error{Reason: socks.RequestReplyGeneralFailure, Err: fmt.Errorf("error on ChannelBind: %s", channelBindResponse.GetErrorString())}
+
+	// datagrams are only accepted from the client of the control
+	// connection and, if it sent one, the port of the request
+	source := netip.AddrPortFrom(client.Addr().Unmap(), 0)
+	if requested.Type != helper.SOCKSAddressDomain {
+		if ip, ok := netip.AddrFromSlice(requested.Address); ok && !ip.IsUnspecified() {
+			source = netip.AddrPortFrom(ip.Unmap(), requested.Port)
+		} else {
+			source = netip.AddrPortFrom(source.Addr(), requested.Port)
+		}
 	}
-	return remote, nil
-}
 
-// CopyFromRemoteToClient is used to send data and remove the extra channel data header
-func (s *SocksTurnUDPHandler) CopyFromRemoteToClient(ctx context.Context, remote io.ReadCloser, client io.WriteCloser) error {
-	clientConn, ok := client.(net.Conn)
-	if !ok {
-		return fmt.Errorf("could not cast client to net.Conn")
+	ctx := s.Ctx
+	if ctx == nil {
+		ctx = context.Background()
 	}
-	remoteConn, ok := remote.(net.Conn)
-	if !ok {
-		return fmt.Errorf("could not cast remote to net.Conn")
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	a := &udpAssociation{
+		handler: s,
+		ctx:     ctx,
+		conn:    udpConn,
+		source:  source,
+		targets: make(map[string]netip.AddrPort),
+		peers:   make(map[netip.AddrPort]*internal.Allocation),
+		pending: make(map[netip.AddrPort]chan struct{}),
 	}
+	defer a.close()
 
-	recv, err := helper.ConnectionRead(remoteConn, s.Timeout)
-	if err != nil {
-		return err
+	go func() {
+		// the control connection carries no data, it only ends the association
+		_, _ = io.Copy(io.Discard, conn)
+		cancel()
+	}()
+	go func() {
+		<-ctx.Done()
+		udpConn.Close()
+	}()
+
+	s.Log.Infof("[socks] UDP association of %s on %s", client, bound)
+	a.serve()
+	s.Log.Infof("[socks] UDP association of %s closed", client)
+}
+
+// credentials returns the credentials for a new allocation
+func (s *SocksTurnUDPHandler) credentials() (helper.Credentials, error) {
+	if s.Credentials == nil {
+		return helper.Credentials{Username: s.TURNUsername, Password: s.TURNPassword}, nil
 	}
+	return s.Credentials.Credentials(s.Ctx)
+}
 
-	channel, data, err := internal.ExtractChannelData(recv)
-	if err != nil {
-		return err
+// udpAssociation relays the datagrams of a client. The allocations of
+// its destinations are set up on the first datagram to them
+type udpAssociation struct {
+	handler *SocksTurnUDPHandler
+	ctx     context.Context
+	conn    *net.UDPConn
+	// source is the address datagrams are accepted from. A port of 0 is
+	// replaced by the port of the first datagram
+	source netip.AddrPort
+	// targets caches the destinations of the requested addresses, so
+	// names are only resolved once
+	targets map[string]netip.AddrPort
+
+	mu    sync.Mutex
+	peers map[netip.AddrPort]*internal.Allocation
+	// pending holds the peers whose allocation is being set up, the
+	// channel is closed when the setup is done
+	pending map[netip.AddrPort]chan struct{}
+	closed  bool
+}
+
+// client returns the address datagrams are accepted from and sent to
+func (a *udpAssociation) client() netip.AddrPort {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.source
+}
+
+// setSource sets the port of the client on its first datagram
+func (a *udpAssociation) setSource(from netip.AddrPort) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.source.Port() == 0 {
+		a.source = from
 	}
-	s.Log.Debugf("received %d bytes on channel %02x", len(data), channel)
+}
 
-	err = helper.ConnectionWrite(clientConn, data, s.Timeout)
-	if err != nil {
-		return err
+// serve relays the datagrams of the client until the socket is closed
+func (a *udpAssociation) serve() {
+	s := a.handler
+	buf := make([]byte, 65535)
+	for {
+		n, from, err := a.conn.ReadFromUDPAddrPort(buf)
+		if err != nil {
+			if !errors.Is(err, net.ErrClosed) {
+				s.Log.Errorf("[socks] error reading datagram: %v", err)
+			}
+			return
+		}
+		from = netip.AddrPortFrom(from.Addr().Unmap(), from.Port())
+		if source := a.client(); from.Addr() != source.Addr() || (source.Port() != 0 && from.Port() != source.Port()) {
+			s.Log.Debugf("[socks] dropping datagram from %s as it is not the client of the association", from)
+			continue
+		}
+		a.setSource(from)
+
+		requested, data, err := helper.ParseSOCKSDatagram(buf[:n])
+		if err != nil {
+			s.Log.Debugf("[socks] dropping invalid datagram from %s: %v", from, err)
+			continue
+		}
+		peer, err := a.target(requested)
+		if err != nil {
+			s.Log.Warnf("[socks] dropping datagram to %s: %v", requested, err)
+			continue
+		}
+		allocation, err := a.allocation(peer)
+		if err != nil {
+			s.Log.Errorf("[socks] could not relay UDP to %s: %v", peer, err)
+			continue
+		}
+		// ChannelData over TCP needs to be padded to a multiple of 4 bytes
+		msg, err := internal.ChannelData(allocation.Channel, data, s.ConnectProtocol == "tcp")
+		if err != nil {
+			s.Log.Debugf("[socks] dropping datagram to %s: %v", peer, err)
+			continue
+		}
+		if err := helper.ConnectionWrite(allocation.Conn, msg, s.Timeout); err != nil {
+			s.Log.Errorf("[socks] error sending datagram to %s: %v", peer, err)
+			a.drop(peer, allocation)
+		}
 	}
-	return nil
 }
 
-// CopyFromClientToRemote is used to send data and add the extra channel data header
-func (s *SocksTurnUDPHandler) CopyFromClientToRemote(ctx context.Context, client io.ReadCloser, remote io.WriteCloser) error {
-	clientConn, ok := client.(net.Conn)
-	if !ok {
-		return fmt.Errorf("could not cast client to net.Conn")
+// target returns the destination of the requested address if it is in
+// the scope of the engagement
+func (a *udpAssociation) target(requested helper.SOCKSAddress) (netip.AddrPort, error) {
+	key := requested.String()
+	if peer, ok := a.targets[key]; ok {
+		return peer, nil
+	}
+	s := a.handler
+	target, socksErr := destination(a.ctx, s.Log, socksRequest(socks.RequestCmdAssociate, requested), s.StrictDNS, s.Resolver)
+	if socksErr != nil {
+		return netip.AddrPort{}, socksErr.Err
+	}
+	if s.DropNonPrivateRequests && !helper.IsPrivateIP(target) {
+		return netip.AddrPort{}, fmt.Errorf("dropping non private destination %s", target.String())
 	}
-	remoteConn, ok := remote.(net.Conn)
-	if !ok {
-		return fmt.Errorf("could not cast remote to net.Conn")
+	if !s.ACL.Allowed(target, requested.Port) {
+		return netip.AddrPort{}, fmt.Errorf("%s:%d is denied by the ACL", target.String(), requested.Port)
 	}
+	peer := netip.AddrPortFrom(target, requested.Port)
+	a.targets[key] = peer
+	return peer, nil
+}
 
-	toSend, err := helper.ConnectionRead(clientConn, s.Timeout)
-	if err != nil {
-		return err
+// allocation returns the allocation of the peer and sets it up on first use.
+// The lock is not held during the setup, so the receivers of the other
+// peers are not blocked by the round trips to the TURN server
+func (a *udpAssociation) allocation(peer netip.AddrPort) (*internal.Allocation, error) {
+	a.mu.Lock()
+	for {
+		if allocation, ok := a.peers[peer]; ok {
+			a.mu.Unlock()
+			return allocation, nil
+		}
+		wait, ok := a.pending[peer]
+		if !ok {
+			break
+		}
+		a.mu.Unlock()
+		<-wait
+		a.mu.Lock()
 	}
-	toSend = internal.Padding(toSend)
-	toSendLen := len(toSend)
+	if a.closed {
+		a.mu.Unlock()
+		return nil, fmt.Errorf("association is closed")
+	}
+	done := make(chan struct{})
+	a.pending[peer] = done
+	a.mu.Unlock()
+
+	allocation, err := a.setup(peer)
 
-	var buf []byte
-	buf = append(buf, s.channelNumber...)
-	buf = append(buf, helper.PutUint16(uint16(toSendLen))...)
-	buf = append(buf, toSend...)
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	delete(a.pending, peer)
+	close(done)
+	if err != nil {
+		return nil, err
+	}
+	if a.closed {
+		allocation.Close()
+		return nil, fmt.Errorf("association is closed")
+	}
+	a.peers[peer] = allocation
+	a.handler.Log.Infof("[socks] relaying UDP to %s", peer)
+	go a.receive(peer, allocation)
+	return allocation, nil
+}
 
-	err = helper.ConnectionWrite(remoteConn, buf, s.Timeout)
+// setup allocates a relay for the peer
+func (a *udpAssociation) setup(peer netip.AddrPort) (*internal.Allocation, error) {
+	s := a.handler
+	creds, err := s.credentials()
 	if err != nil {
-		return err
+		return nil, fmt.Errorf("could not get credentials from %s: %w", s.Credentials, err)
 	}
-	return nil
+	return internal.NewAllocation(s.Log, s.ConnectProtocol, s.Server, s.UseTLS, s.TlsVerify, s.Timeout, peer.Addr(), peer.Port(), creds.Username, creds.Password)
 }
 
-// Refresh is not used in this implementation
-func (s *SocksTurnUDPHandler) Refresh(_ context.Context) {
+// receive sends the data of the peer to the client until the allocation
+// fails or is closed
+func (a *udpAssociation) receive(peer netip.AddrPort, allocation *internal.Allocation) {
+	s := a.handler
+	err := allocation.Relay(func(payload []byte) error {
+		if _, err := a.conn.WriteToUDPAddrPort(helper.SOCKSDatagram(peer, payload), a.client()); err != nil {
+			s.Log.Debugf("[socks] could not send datagram of %s to the client: %v", peer, err)
+		}
+		return nil
+	})
+	if a.ctx.Err() == nil {
+		s.Log.Errorf("[socks] error receiving data of %s: %v", peer, err)
+	}
+	a.drop(peer, allocation)
 }
 
-// Cleanup is not used in this implementation
-func (s *SocksTurnUDPHandler) Cleanup() error {
-	return nil
+// drop closes the allocation of the peer. The next datagram to the peer
+// sets up a new one
+func (a *udpAssociation) drop(peer netip.AddrPort, allocation *internal.Allocation) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.peers[peer] == allocation {
+		delete(a.peers, peer)
+	}
+	allocation.Close()
+}
+
+// close closes the allocations of all peers
+func (a *udpAssociation) close() {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.closed = true
+	for peer, allocation := range a.peers {
+		allocation.Close()
+		delete(a.peers, peer)
+	}
 }
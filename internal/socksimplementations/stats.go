@@ -0,0 +1,222 @@
+package socksimplementations
+
+import (
+	"fmt"
+	"net"
+	"net/netip"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/firefart/stunner/internal/helper"
+)
+
+// maxDestinations is the number of destinations that are counted on their
+// own. Further destinations are counted as otherDestinations, so a scan
+// through the proxy does not grow the statistics without bounds
+const maxDestinations = 1024
+
+const otherDestinations = "other"
+
+// Stats counts the traffic of the connections of the proxy. Sent bytes go
+// from the client to the destination, received bytes the other way
+type Stats struct {
+	mu           sync.Mutex
+	started      time.Time
+	total        uint64
+	closed       trafficStats
+	active       map[*statsConn]struct{}
+	destinations map[string]*DestinationStats
+}
+
+// StatsSnapshot are the statistics at one point in time
+type StatsSnapshot struct {
+	Started           time.Time          `json:"started"`
+	BytesSent         uint64             `json:"bytes_sent"`
+	BytesReceived     uint64             `json:"bytes_received"`
+	TotalConnections  uint64             `json:"total_connections"`
+	ActiveConnections int                `json:"active_connections"`
+	Connections       []ConnectionStats  `json:"connections"`
+	Destinations      []DestinationStats `json:"destinations"`
+}
+
+// ConnectionStats are the counters of an active connection
+type ConnectionStats struct {
+	Destination   string    `json:"destination"`
+	Started       time.Time `json:"started"`
+	BytesSent     uint64    `json:"bytes_sent"`
+	BytesReceived uint64    `json:"bytes_received"`
+}
+
+// DestinationStats are the counters of all connections to a destination
+type DestinationStats struct {
+	Destination   string `json:"destination"`
+	Connections   uint64 `json:"connections"`
+	BytesSent     uint64 `json:"bytes_sent"`
+	BytesReceived uint64 `json:"bytes_received"`
+}
+
+type trafficStats struct {
+	sent     uint64
+	received uint64
+}
+
+// NewStats returns empty statistics
+func NewStats() *Stats {
+	return &Stats{
+		started:      time.Now(),
+		active:       make(map[*statsConn]struct{}),
+		destinations: make(map[string]*DestinationStats),
+	}
+}
+
+// track counts the traffic of the connection to the destination until it
+// is closed
+func (s *Stats) track(conn net.Conn, destination netip.AddrPort) net.Conn {
+	c := &statsConn{
+		Conn:        conn,
+		stats:       s,
+		destination: destination.String(),
+		started:     time.Now(),
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.total++
+	s.active[c] = struct{}{}
+	s.destination(c.destination).Connections++
+	return c
+}
+
+// destination returns the counters of the destination. The caller needs
+// to hold the lock
+func (s *Stats) destination(name string) *DestinationStats {
+	if d, ok := s.destinations[name]; ok {
+		return d
+	}
+	if len(s.destinations) >= maxDestinations {
+		name = otherDestinations
+		if d, ok := s.destinations[name]; ok {
+			return d
+		}
+	}
+	d := &DestinationStats{Destination: name}
+	s.destinations[name] = d
+	return d
+}
+
+// untrack adds the counters of a closed connection to the totals
+func (s *Stats) untrack(c *statsConn) {
+	sent, received := c.sent.Load(), c.received.Load()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.active, c)
+	s.closed.sent += sent
+	s.closed.received += received
+	d := s.destination(c.destination)
+	d.BytesSent += sent
+	d.BytesReceived += received
+}
+
+// Snapshot returns the current statistics. Connections are sorted by
+// their start and destinations by their traffic
+func (s *Stats) Snapshot() StatsSnapshot {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	snapshot := StatsSnapshot{
+		Started:           s.started,
+		BytesSent:         s.closed.sent,
+		BytesReceived:     s.closed.received,
+		TotalConnections:  s.total,
+		ActiveConnections: len(s.active),
+		Connections:       []ConnectionStats{},
+		Destinations:      []DestinationStats{},
+	}
+	// the destination counters only contain closed connections
+	destinations := make(map[string]DestinationStats, len(s.destinations))
+	for name, d := range s.destinations {
+		destinations[name] = *d
+	}
+	for c := range s.active {
+		sent, received := c.sent.Load(), c.received.Load()
+		snapshot.BytesSent += sent
+		snapshot.BytesReceived += received
+		snapshot.Connections = append(snapshot.Connections, ConnectionStats{
+			Destination:   c.destination,
+			Started:       c.started,
+			BytesSent:     sent,
+			BytesReceived: received,
+		})
+		name := c.destination
+		if _, ok := destinations[name]; !ok {
+			name = otherDestinations
+		}
+		d := destinations[name]
+		d.BytesSent += sent
+		d.BytesReceived += received
+		destinations[name] = d
+	}
+	for _, d := range destinations {
+		snapshot.Destinations = append(snapshot.Destinations, d)
+	}
+	sort.Slice(snapshot.Connections, func(i, j int) bool {
+		return snapshot.Connections[i].Started.Before(snapshot.Connections[j].Started)
+	})
+	sort.Slice(snapshot.Destinations, func(i, j int) bool {
+		a, b := snapshot.Destinations[i], snapshot.Destinations[j]
+		if a.BytesSent+a.BytesReceived != b.BytesSent+b.BytesReceived {
+			return a.BytesSent+a.BytesReceived > b.BytesSent+b.BytesReceived
+		}
+		return a.Destination < b.Destination
+	})
+	return snapshot
+}
+
+// String returns a summary with the destinations with the most traffic
+func (s StatsSnapshot) String() string {
+	const top = 10
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "%s sent, %s received, %d active connections, %d connections in %s",
+		helper.FormatBytes(int64(s.BytesSent)), helper.FormatBytes(int64(s.BytesReceived)), s.ActiveConnections, s.TotalConnections, time.Since(s.Started).Round(time.Second))
+	for i, d := range s.Destinations {
+		if i == top {
+			fmt.Fprintf(&sb, "\n  and %d more destinations", len(s.Destinations)-top)
+			break
+		}
+		fmt.Fprintf(&sb, "\n  %s: %s sent, %s received, %d connections",
+			d.Destination, helper.FormatBytes(int64(d.BytesSent)), helper.FormatBytes(int64(d.BytesReceived)), d.Connections)
+	}
+	return sb.String()
+}
+
+// statsConn counts the bytes written to and read from the destination
+type statsConn struct {
+	net.Conn
+	stats       *Stats
+	destination string
+	started     time.Time
+	sent        atomic.Uint64
+	received    atomic.Uint64
+	closeOnce   sync.Once
+}
+
+func (c *statsConn) Read(b []byte) (int, error) {
+	n, err := c.Conn.Read(b)
+	c.received.Add(uint64(n))
+	return n, err
+}
+
+func (c *statsConn) Write(b []byte) (int, error) {
+	n, err := c.Conn.Write(b)
+	c.sent.Add(uint64(n))
+	return n, err
+}
+
+func (c *statsConn) Close() error {
+	err := c.Conn.Close()
+	c.closeOnce.Do(func() {
+		c.stats.untrack(c)
+	})
+	return err
+}
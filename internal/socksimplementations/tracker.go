@@ -0,0 +1,104 @@
+package socksimplementations
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sync"
+	"sync/atomic"
+
+	"github.com/sirupsen/logrus"
+)
+
+// tracker keeps track of the listeners and the active connections of a
+// server, so it can be shut down without cutting off its clients
+type tracker struct {
+	mu        sync.Mutex
+	listeners map[net.Listener]struct{}
+	closing   bool
+	// active are the connections that are handled
+	active sync.WaitGroup
+	count  atomic.Int64
+}
+
+// serve accepts the connections of the listener and handles them with
+// handle until the listener is closed or the server is shut down
+func (t *tracker) serve(log *logrus.Logger, listener net.Listener, handle func(conn net.Conn)) {
+	if !t.trackListener(listener) {
+		listener.Close()
+		return
+	}
+	defer t.untrackListener(listener)
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return
+			}
+			log.Errorf("error accepting connection: %v", err)
+			continue
+		}
+		t.mu.Lock()
+		if t.closing {
+			t.mu.Unlock()
+			conn.Close()
+			return
+		}
+		t.active.Add(1)
+		t.mu.Unlock()
+		t.count.Add(1)
+		go func() {
+			defer t.active.Done()
+			defer t.count.Add(-1)
+			handle(conn)
+		}()
+	}
+}
+
+func (t *tracker) trackListener(listener net.Listener) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.closing {
+		return false
+	}
+	if t.listeners == nil {
+		t.listeners = make(map[net.Listener]struct{})
+	}
+	t.listeners[listener] = struct{}{}
+	return true
+}
+
+func (t *tracker) untrackListener(listener net.Listener) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	delete(t.listeners, listener)
+}
+
+// Active returns the number of connections that are handled
+func (t *tracker) Active() int {
+	return int(t.count.Load())
+}
+
+// Shutdown closes the listeners and waits for the active connections to
+// finish. If ctx is done before, its error is returned and the remaining
+// connections are left open
+func (t *tracker) Shutdown(ctx context.Context) error {
+	t.mu.Lock()
+	t.closing = true
+	for listener := range t.listeners {
+		listener.Close()
+	}
+	t.mu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		t.active.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
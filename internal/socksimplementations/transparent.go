@@ -0,0 +1,68 @@
+package socksimplementations
+
+import (
+	"net"
+	"net/netip"
+
+	socks "github.com/firefart/gosocks"
+	"github.com/firefart/stunner/internal/helper"
+
+	"github.com/sirupsen/logrus"
+)
+
+// TransparentServer relays connections redirected to it with iptables to
+// their original destination. The connections are handed to the same
+// handler as the CONNECT command of the SOCKS5 server
+type TransparentServer struct {
+	// Connect handles the redirected connections
+	Connect socks.ProxyHandler
+	// Allow are the networks clients may connect from. All clients are
+	// allowed if empty
+	Allow []netip.Prefix
+	Log   *logrus.Logger
+
+	tracker
+}
+
+// Serve accepts the connections of the listener until it is closed or the
+// server is shut down
+func (s *TransparentServer) Serve(listener net.Listener) {
+	listen, _ := netip.ParseAddrPort(listener.Addr().String())
+	s.serve(s.Log, listener, func(conn net.Conn) {
+		s.handle(conn, listen.Port())
+	})
+}
+
+// handle relays the connection to its original destination. Connections
+// to the port of the listener itself were not redirected and are refused,
+// so they do not loop
+func (s *TransparentServer) handle(conn net.Conn, listenPort uint16) {
+	defer conn.Close()
+	defer s.Log.Debug("[transparent] client connection closed")
+
+	client, err := netip.ParseAddrPort(conn.RemoteAddr().String())
+	if err != nil {
+		s.Log.Errorf("[transparent] invalid client address %s: %v", conn.RemoteAddr(), err)
+		return
+	}
+	if !helper.InAllowList(s.Allow, client.Addr()) {
+		s.Log.Warnf("[transparent] refusing connection from %s as it is not in the allow list", client)
+		return
+	}
+	destination, err := helper.OriginalDestination(conn)
+	if err != nil {
+		s.Log.Errorf("[transparent] could not get the original destination of %s: %v", client, err)
+		return
+	}
+	local, _ := netip.ParseAddrPort(conn.LocalAddr().String())
+	if destination.Port() == listenPort && destination.Addr() == local.Addr().Unmap() {
+		s.Log.Warnf("[transparent] refusing connection from %s as it was not redirected", client)
+		return
+	}
+	s.Log.Debugf("[transparent] got connection from %s to %s", client, destination)
+
+	// there is no reply, failed connections are closed
+	relay(s.Log, s.Connect, conn, socksAddress(destination), func(socks.RequestReplyReason, netip.AddrPort) error {
+		return nil
+	})
+}
@@ -0,0 +1,90 @@
+package internal
+
+import (
+	"fmt"
+	"net"
+	"sync/atomic"
+)
+
+// ConnectionStats are the counters of all connections to the TURN server
+// since the start. Relayed TCP data connections are not included
+type ConnectionStats struct {
+	BytesSent     uint64 `json:"bytes_sent"`
+	BytesReceived uint64 `json:"bytes_received"`
+	// Transactions is the number of requests that got a response
+	Transactions uint64 `json:"transactions"`
+	// Errors is the number of requests that failed or got an error response
+	Errors uint64 `json:"errors"`
+	// Allocations is the number of granted allocations
+	Allocations uint64 `json:"allocations"`
+	// Refreshes is the number of successful refreshes including deletions
+	Refreshes       uint64 `json:"refreshes"`
+	OpenConnections int    `json:"open_connections"`
+	OpenAllocations int    `json:"open_allocations"`
+}
+
+func (s ConnectionStats) String() string {
+	return fmt.Sprintf("%d bytes sent, %d bytes received, %d transactions, %d errors, %d allocations, %d refreshes, %d open connections, %d open allocations",
+		s.BytesSent, s.BytesReceived, s.Transactions, s.Errors, s.Allocations, s.Refreshes, s.OpenConnections, s.OpenAllocations)
+}
+
+var counters struct {
+	bytesSent     atomic.Uint64
+	bytesReceived atomic.Uint64
+	transactions  atomic.Uint64
+	errors        atomic.Uint64
+	allocations   atomic.Uint64
+	refreshes     atomic.Uint64
+}
+
+// Stats returns the current counters. It is safe to call concurrently and
+// is the source for logs and the control API
+func Stats() ConnectionStats {
+	s := ConnectionStats{
+		BytesSent:     counters.bytesSent.Load(),
+		BytesReceived: counters.bytesReceived.Load(),
+		Transactions:  counters.transactions.Load(),
+		Errors:        counters.errors.Load(),
+		Allocations:   counters.allocations.Load(),
+		Refreshes:     counters.refreshes.Load(),
+	}
+	sessionsMu.Lock()
+	defer sessionsMu.Unlock()
+	s.OpenConnections = len(sessions)
+	for _, session := range sessions {
+		if session.allocated {
+			s.OpenAllocations++
+		}
+	}
+	return s
+}
+
+// countMessage counts the bytes of a message on connections that are not
+// counted by trackedConn, like the control connections of TCP allocations
+func countMessage(conn net.Conn, sent, received int) {
+	if _, ok := conn.(*trackedConn); ok {
+		return
+	}
+	counters.bytesSent.Add(uint64(sent))
+	counters.bytesReceived.Add(uint64(received))
+}
+
+// countTransaction counts a request and its response. resp is nil if the
+// request failed
+func countTransaction(req, resp *Stun) {
+	if resp == nil {
+		counters.errors.Add(1)
+		return
+	}
+	counters.transactions.Add(1)
+	if resp.Header.MessageType.Class == MsgTypeClassError {
+		counters.errors.Add(1)
+		return
+	}
+	switch req.Header.MessageType.Method {
+	case MsgTypeMethodAllocate:
+		counters.allocations.Add(1)
+	case MsgTypeMethodRefresh:
+		counters.refreshes.Add(1)
+	}
+}
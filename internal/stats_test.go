@@ -0,0 +1,69 @@
+package internal
+
+import (
+	"net"
+	"testing"
+	"time"
+)
+
+// no t.Parallel as the counters are global
+func TestStats(t *testing.T) {
+	client, server := net.Pipe()
+	defer server.Close()
+	conn := newTrackedConn(client)
+	defer conn.Close()
+
+	go func() {
+		buf := make([]byte, 1024)
+		for _, class := range []MessageTypeClass{MsgTypeClassSuccess, MsgTypeClassError} {
+			n, err := server.Read(buf)
+			if err != nil {
+				return
+			}
+			req, err := fromBytes(buf[:n])
+			if err != nil {
+				return
+			}
+			resp := newStun()
+			resp.Header.TransactionID = req.Header.TransactionID
+			resp.Header.MessageType = MessageType{Class: class, Method: req.Header.MessageType.Method}
+			data, err := resp.Serialize()
+			if err != nil {
+				return
+			}
+			if _, err := server.Write(data); err != nil {
+				return
+			}
+		}
+	}()
+
+	before := Stats()
+	allocateRequest := AllocateRequestAuth("user", "pass", "nonce", "realm", RequestedTransportUDP, AllocateProtocolIgnore)
+	if _, err := allocateRequest.SendAndReceive(nilLogger{}, conn, time.Second); err != nil {
+		t.Fatal(err)
+	}
+	refreshRequest := RefreshRequest("user", "pass", "nonce", "realm")
+	if _, err := refreshRequest.SendAndReceive(nilLogger{}, conn, time.Second); err != nil {
+		t.Fatal(err)
+	}
+	after := Stats()
+
+	if got := after.Transactions - before.Transactions; got != 2 {
+		t.Errorf("got %d transactions, want 2", got)
+	}
+	if got := after.Errors - before.Errors; got != 1 {
+		t.Errorf("got %d errors, want 1", got)
+	}
+	if got := after.Allocations - before.Allocations; got != 1 {
+		t.Errorf("got %d allocations, want 1", got)
+	}
+	if got := after.Refreshes - before.Refreshes; got != 0 {
+		t.Errorf("got %d refreshes for an error response, want 0", got)
+	}
+	if after.BytesSent <= before.BytesSent || after.BytesReceived <= before.BytesReceived {
+		t.Errorf("bytes were not counted: %s", after)
+	}
+	if after.OpenAllocations != before.OpenAllocations+1 {
+		t.Errorf("got %d open allocations, want %d", after.OpenAllocations, before.OpenAllocations+1)
+	}
+}
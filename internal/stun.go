@@ -17,8 +17,18 @@ func newStun() *Stun {
 	}
 }
 
-// GetErrorString returns the error string from the Error Attribute if present
-func (s *Stun) GetErrorString() string {
+// ResponseError is the error returned by the server in an error response
+type ResponseError struct {
+	Code ErrorCode
+	Text string
+}
+
+func (e *ResponseError) Error() string {
+	return fmt.Sprintf("Error %d: %s", e.Code, e.Text)
+}
+
+// GetError returns the Error Attribute as an error or nil if not present
+func (s *Stun) GetError() error {
 	for _, a := range s.Attributes {
 		if a.Type == AttrErrorCode {
 			attrError := ParseError(a.Value)
@@ -34,9 +44,32 @@ func (s *Stun) GetErrorString() string {
 					attrError.ErrorText = "Invalid Error"
 				}
 			}
-			return fmt.Sprintf("Error %d: %s", attrError.ErrorCode, attrError.ErrorText)
+			return &ResponseError{Code: attrError.ErrorCode, Text: attrError.ErrorText}
+		}
+	}
+	// error response without an error code
+	if s.Header.MessageType.Class == MsgTypeClassError {
+		return &ResponseError{Text: "missing error code"}
+	}
+	return nil
+}
+
+// GetErrorCode returns the numeric error code of an error response. The
+// second return value is false if the message contains no error code
+func (s *Stun) GetErrorCode() (ErrorCode, bool) {
+	for _, a := range s.Attributes {
+		if a.Type == AttrErrorCode {
+			return ParseError(a.Value).ErrorCode, true
 		}
 	}
+	return 0, false
+}
+
+// GetErrorString returns the error string from the Error Attribute if present
+func (s *Stun) GetErrorString() string {
+	if err := s.GetError(); err != nil {
+		return err.Error()
+	}
 	return ""
 }
 
@@ -60,11 +93,14 @@ func (s *Stun) Serialize() ([]byte, error) {
 	var attributes []byte
 	authenticated := false
 	for _, a := range s.Attributes {
-		attributeByte := a.Serialize()
-		attributes = append(attributes, attributeByte...)
 		if a.Type == AttrUsername {
 			authenticated = true
+			// the server derives the key from the username as sent
+			a.Value = []byte(opaqueString(string(a.Value)))
+			a.Length = 0
 		}
+		attributeByte := a.Serialize()
+		attributes = append(attributes, attributeByte...)
 	}
 
 	integrityPos := len(attributes)
@@ -75,11 +111,6 @@ func (s *Stun) Serialize() ([]byte, error) {
 		attributes = append(attributes, []byte("_DUMMYDATADUMMYDATA_")...)
 	}
 
-	// fingerprintPos := len(attributes)
-	// attributes = append(attributes, PutUint16(AttrFingerprint.Value())...)
-	// attributes = append(attributes, PutUint16(fingerPrintSize)...)
-	// attributes = append(attributes, []byte("!!!!")...)
-
 	var buf []byte
 	buf = append(buf, s.Header.MessageType.Serialize()...)
 	// Length
@@ -104,11 +135,16 @@ func (s *Stun) Serialize() ([]byte, error) {
 		buf = bytes.ReplaceAll(buf, []byte("_DUMMYDATADUMMYDATA_"), messageInt)
 	}
 
-	// Fingerprint
-	// fingerPrint := generateFingerprint(buf[:fingerprintPos+headerSize])
-	// buf = bytes.ReplaceAll(buf, []byte("!!!!"), fingerPrint)
+	if s.Fingerprint {
+		// the length includes the fingerprint, the message integrity above
+		// is calculated without it
+		copy(buf[2:4], helper.PutUint16(uint16(len(attributes)+4+fingerPrintSize)))
+		fingerPrint := generateFingerprint(buf)
+		buf = append(buf, helper.PutUint16(AttrFingerprint.Value())...)
+		buf = append(buf, helper.PutUint16(fingerPrintSize)...)
+		buf = append(buf, fingerPrint...)
+	}
 
-	// trim buffer
 	return buf, nil
 }
 
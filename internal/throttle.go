@@ -0,0 +1,202 @@
+package internal
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/firefart/stunner/internal/helper"
+)
+
+// AllocationThrottle limits the rate new allocations are requested with.
+// Servers like coturn ban clients that allocate too fast for some time,
+// which shows as a series of timeouts, refused connections or rejected
+// credentials that worked before. After threshold of these failures in a
+// row the throttle cools down, halves the rate and records the number of
+// allocations and the rate that triggered the ban.
+type AllocationThrottle struct {
+	log       helper.ErrorLogger
+	threshold int
+	cooldown  time.Duration
+
+	mu           sync.Mutex
+	interval     time.Duration
+	next         time.Time
+	blockedUntil time.Time
+	consecutive  int
+	authWorked   bool
+	// allocations since the start or the end of the last cool down
+	windowStart time.Time
+	windowCount int
+
+	allocations int
+	failures    int
+	bans        []banThreshold
+}
+
+type banThreshold struct {
+	allocations int
+	rate        float64
+}
+
+var (
+	throttleMu         sync.RWMutex
+	allocationThrottle *AllocationThrottle
+)
+
+// EnableAllocationThrottle throttles all following allocations to rate
+// allocations per second, 0 means no limit. After threshold failures in a
+// row that indicate a ban the allocations are paused for cooldown, a
+// threshold of 0 disables the detection. A nil log disables the throttle
+func EnableAllocationThrottle(log helper.ErrorLogger, rate float64, threshold int, cooldown time.Duration) *AllocationThrottle {
+	throttleMu.Lock()
+	defer throttleMu.Unlock()
+	if log == nil {
+		allocationThrottle = nil
+		return nil
+	}
+	allocationThrottle = &AllocationThrottle{
+		log:       log,
+		threshold: threshold,
+		cooldown:  cooldown,
+		interval:  rateInterval(rate),
+	}
+	return allocationThrottle
+}
+
+func currentAllocationThrottle() *AllocationThrottle {
+	throttleMu.RLock()
+	defer throttleMu.RUnlock()
+	return allocationThrottle
+}
+
+func rateInterval(rate float64) time.Duration {
+	if rate <= 0 {
+		return 0
+	}
+	return time.Duration(float64(time.Second) / rate)
+}
+
+func intervalRate(interval time.Duration) float64 {
+	if interval <= 0 {
+		return 0
+	}
+	return float64(time.Second) / float64(interval)
+}
+
+// wait blocks until the next allocation is allowed
+func (t *AllocationThrottle) wait() {
+	if t == nil {
+		return
+	}
+	for {
+		t.mu.Lock()
+		now := time.Now()
+		if now.Before(t.blockedUntil) {
+			until := t.blockedUntil
+			t.mu.Unlock()
+			time.Sleep(time.Until(until))
+			continue
+		}
+		start := now
+		if t.next.After(start) {
+			start = t.next
+		}
+		t.next = start.Add(t.interval)
+		t.mu.Unlock()
+		time.Sleep(time.Until(start))
+
+		// a cool down might have started in the meantime
+		t.mu.Lock()
+		blocked := time.Now().Before(t.blockedUntil)
+		t.mu.Unlock()
+		if !blocked {
+			return
+		}
+	}
+}
+
+// done records the result of an allocation
+func (t *AllocationThrottle) done(err error) {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.allocations++
+	if t.windowCount == 0 {
+		t.windowStart = time.Now()
+	}
+	t.windowCount++
+	if err == nil {
+		t.authWorked = true
+		t.consecutive = 0
+		return
+	}
+	t.failures++
+	if !t.isBanIndicator(err) {
+		t.consecutive = 0
+		return
+	}
+	t.consecutive++
+	if t.threshold <= 0 || t.consecutive < t.threshold {
+		return
+	}
+
+	// the failing allocations do not count towards the threshold
+	allocations := t.windowCount - t.consecutive
+	rate := intervalRate(t.interval)
+	if elapsed := time.Since(t.windowStart); rate == 0 && elapsed > 0 {
+		rate = float64(t.windowCount) / elapsed.Seconds()
+	}
+	t.bans = append(t.bans, banThreshold{allocations: allocations, rate: rate})
+	if rate > 0 {
+		t.interval = rateInterval(rate / 2)
+	}
+	t.blockedUntil = time.Now().Add(t.cooldown)
+	t.log.Warnf("%d allocations failed in a row, the server probably banned us after %d allocations at %.2f/s. Cooling down for %s and continuing with %.2f allocations/s", t.consecutive, allocations, rate, t.cooldown, intervalRate(t.interval))
+	t.consecutive = 0
+	t.windowCount = 0
+}
+
+// isBanIndicator returns true for errors servers return when they ban a
+// client. Rejected credentials only count if they worked before
+func (t *AllocationThrottle) isBanIndicator(err error) bool {
+	if errors.Is(err, helper.ErrTimeout) || errors.Is(err, syscall.ECONNREFUSED) || errors.Is(err, syscall.ECONNRESET) {
+		return true
+	}
+	var respErr *ResponseError
+	if !errors.As(err, &respErr) {
+		return false
+	}
+	switch respErr.Code {
+	case ErrorUnauthorized, ErrorWrongCredentials:
+		return t.authWorked
+	case ErrorAllocationQuotaReached:
+		return true
+	}
+	return false
+}
+
+// Summary returns the number of allocations and the observed ban
+// thresholds
+func (t *AllocationThrottle) Summary() string {
+	if t == nil {
+		return ""
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	s := fmt.Sprintf("allocation throttle: %d allocations, %d failed, %d cool downs", t.allocations, t.failures, len(t.bans))
+	if len(t.bans) == 0 {
+		return s
+	}
+	var bans []string
+	for _, b := range t.bans {
+		bans = append(bans, fmt.Sprintf("%d allocations at %.2f/s", b.allocations, b.rate))
+	}
+	return fmt.Sprintf("%s. Bans after %s. Final rate %.2f allocations/s", s, strings.Join(bans, ", "), intervalRate(t.interval))
+}
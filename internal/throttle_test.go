@@ -0,0 +1,67 @@
+package internal
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/firefart/stunner/internal/helper"
+)
+
+type nopErrorLogger struct{}
+
+func (nopErrorLogger) Errorf(string, ...interface{}) {}
+func (nopErrorLogger) Warnf(string, ...interface{})  {}
+
+func TestAllocationThrottleRate(t *testing.T) {
+	t.Parallel()
+	throttle := &AllocationThrottle{log: nopErrorLogger{}, interval: rateInterval(100)}
+	start := time.Now()
+	for i := 0; i < 5; i++ {
+		throttle.wait()
+		throttle.done(nil)
+	}
+	// the first allocation is not delayed
+	if elapsed := time.Since(start); elapsed < 40*time.Millisecond {
+		t.Errorf("5 allocations at 100/s took %s, want at least 40ms", elapsed)
+	}
+}
+
+func TestAllocationThrottleBan(t *testing.T) {
+	t.Parallel()
+	throttle := &AllocationThrottle{log: nopErrorLogger{}, threshold: 3, cooldown: 50 * time.Millisecond, interval: rateInterval(1000)}
+
+	// wrong credentials are no ban if they never worked
+	for i := 0; i < 3; i++ {
+		throttle.done(fmt.Errorf("error on AllocateRequest Auth: %w", &ResponseError{Code: ErrorUnauthorized}))
+	}
+	if len(throttle.bans) != 0 {
+		t.Fatalf("got %d bans before the credentials worked, want 0", len(throttle.bans))
+	}
+
+	for i := 0; i < 4; i++ {
+		throttle.done(nil)
+	}
+	throttle.done(fmt.Errorf("error on sending AllocateRequest: %w", helper.ErrTimeout))
+	throttle.done(fmt.Errorf("error on AllocateRequest Auth: %w", &ResponseError{Code: ErrorUnauthorized}))
+	throttle.done(fmt.Errorf("error on sending AllocateRequest: %w", helper.ErrTimeout))
+	if len(throttle.bans) != 1 {
+		t.Fatalf("got %d bans, want 1", len(throttle.bans))
+	}
+	if got := throttle.bans[0].allocations; got != 7 {
+		t.Errorf("ban after %d allocations, want 7", got)
+	}
+	if got := intervalRate(throttle.interval); got < 499 || got > 501 {
+		t.Errorf("rate after the ban %.2f, want 500", got)
+	}
+
+	start := time.Now()
+	throttle.wait()
+	if elapsed := time.Since(start); elapsed < 40*time.Millisecond {
+		t.Errorf("allocation during the cool down after %s, want at least 40ms", elapsed)
+	}
+	if s := throttle.Summary(); !strings.Contains(s, "Bans after 7 allocations at 1000.00/s") {
+		t.Errorf("unexpected summary %q", s)
+	}
+}
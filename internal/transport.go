@@ -0,0 +1,82 @@
+package internal
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Transport is the way the TURN server is connected to
+type Transport struct {
+	Protocol string
+	TLS      bool
+}
+
+func (t Transport) String() string {
+	switch {
+	case t.Protocol == "udp" && t.TLS:
+		return "dtls"
+	case t.Protocol == "tcp" && t.TLS:
+		return "tls"
+	}
+	return t.Protocol
+}
+
+// ParseTransports parses a comma separated list of the transports udp,
+// tcp, tls and dtls
+func ParseTransports(s string) ([]Transport, error) {
+	var ret []Transport
+	seen := make(map[Transport]struct{})
+	for _, name := range strings.Split(s, ",") {
+		var t Transport
+		switch strings.ToLower(strings.TrimSpace(name)) {
+		case "udp":
+			t = Transport{Protocol: "udp"}
+		case "tcp":
+			t = Transport{Protocol: "tcp"}
+		case "tls":
+			t = Transport{Protocol: "tcp", TLS: true}
+		case "dtls":
+			t = Transport{Protocol: "udp", TLS: true}
+		default:
+			return nil, fmt.Errorf("invalid transport %q, supported values: udp, tcp, tls and dtls", name)
+		}
+		if _, ok := seen[t]; ok {
+			continue
+		}
+		seen[t] = struct{}{}
+		ret = append(ret, t)
+	}
+	return ret, nil
+}
+
+// ProbeTransport checks if the TURN server answers on the transport. An
+// unauthenticated allocate request is used as TURN servers answer it even
+// if STUN binding requests are disabled
+func ProbeTransport(logger DebugLogger, turnServer string, transport Transport, tlsVerify bool, timeout time.Duration) error {
+	conn, err := Connect(transport.Protocol, turnServer, transport.TLS, tlsVerify, timeout)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	allocateRequest := AllocateRequest(RequestedTransportUDP, AllocateProtocolIgnore)
+	if _, err := allocateRequest.SendAndReceive(logger, conn, timeout); err != nil {
+		return fmt.Errorf("error on sending allocate request: %w", err)
+	}
+	return nil
+}
+
+// SelectTransport returns the first transport the TURN server answers on
+func SelectTransport(logger DebugLogger, turnServer string, transports []Transport, tlsVerify bool, timeout time.Duration) (Transport, error) {
+	var errs []string
+	for _, t := range transports {
+		err := ProbeTransport(logger, turnServer, t, tlsVerify, timeout)
+		if err == nil {
+			return t, nil
+		}
+		logger.Debugf("%s is not reachable via %s: %v", turnServer, t, err)
+		errs = append(errs, fmt.Sprintf("%s: %v", t, err))
+	}
+	return Transport{}, fmt.Errorf("%s is not reachable via any transport (%s)", turnServer, strings.Join(errs, "; "))
+}
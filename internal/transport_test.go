@@ -0,0 +1,82 @@
+package internal
+
+import (
+	"net"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestParseTransports(t *testing.T) {
+	t.Parallel()
+	transports, err := ParseTransports("udp, TCP,tls,dtls,udp")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []Transport{
+		{Protocol: "udp"},
+		{Protocol: "tcp"},
+		{Protocol: "tcp", TLS: true},
+		{Protocol: "udp", TLS: true},
+	}
+	if !reflect.DeepEqual(transports, expected) {
+		t.Errorf("expected %v, got %v", expected, transports)
+	}
+	var names []string
+	for _, tr := range transports {
+		names = append(names, tr.String())
+	}
+	if !reflect.DeepEqual(names, []string{"udp", "tcp", "tls", "dtls"}) {
+		t.Errorf("unexpected names %v", names)
+	}
+	if _, err := ParseTransports("udp,sctp"); err == nil {
+		t.Error("expected an error on an unknown transport")
+	}
+}
+
+func TestSelectTransport(t *testing.T) {
+	t.Parallel()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	go func() {
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		buf := make([]byte, 1024)
+		n, err := conn.Read(buf)
+		if err != nil {
+			return
+		}
+		req, err := fromBytes(buf[:n])
+		if err != nil {
+			return
+		}
+		resp := newStun()
+		resp.Header.MessageType = MessageType{Class: MsgTypeClassError, Method: req.Header.MessageType.Method}
+		resp.Header.TransactionID = req.Header.TransactionID
+		data, err := resp.Serialize()
+		if err != nil {
+			return
+		}
+		_, _ = conn.Write(data)
+	}()
+
+	// nothing answers on UDP so TCP is selected
+	transports := []Transport{{Protocol: "udp"}, {Protocol: "tcp"}}
+	tr, err := SelectTransport(nilLogger{}, l.Addr().String(), transports, false, 200*time.Millisecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tr.String() != "tcp" {
+		t.Errorf("expected tcp, got %s", tr)
+	}
+
+	if _, err := SelectTransport(nilLogger{}, l.Addr().String(), transports[:1], false, 200*time.Millisecond); err == nil {
+		t.Error("expected an error if no transport is reachable")
+	}
+}
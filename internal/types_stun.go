@@ -24,7 +24,13 @@ type Stun struct {
 	Attributes []Attribute
 	Username   string
 	Password   string
-	Log        DebugLogger
+	// Fingerprint adds a FINGERPRINT attribute when serializing
+	Fingerprint bool
+	Log         DebugLogger
+
+	// raw is the message as received, used to verify the FINGERPRINT and
+	// MESSAGE-INTEGRITY
+	raw []byte
 }
 
 // RequestedTransport represents the requested transport
@@ -170,6 +176,8 @@ func (a *Attribute) String(transactionID string) string {
 		value = string(a.Value)
 	case AttrAlternateServer:
 		value = string(a.Value)
+	case AttrOrigin:
+		value = string(a.Value)
 	case AttrFingerprint:
 		value = fmt.Sprintf("%02x", a.Value)
 	// TURN
@@ -272,26 +280,37 @@ const (
 	AttrResponsePort   AttributeType = 0x0027
 	AttrResponseOrigin AttributeType = 0x802b
 	AttrOtherAddress   AttributeType = 0x802c
+
+	// AttrOrigin https://datatracker.ietf.org/doc/html/draft-ietf-tram-stun-origin-06#section-3
+	AttrOrigin AttributeType = 0x802f
+
+	// AttrPasswordAlgorithms https://datatracker.ietf.org/doc/html/rfc8489#section-14.11
+	AttrPasswordAlgorithms AttributeType = 0x8002
+	// AttrThirdPartyAuthorization https://datatracker.ietf.org/doc/html/rfc7635#section-6.1
+	AttrThirdPartyAuthorization AttributeType = 0x802e
 )
 
 var attrNames = map[AttributeType]string{
-	AttrMappedAddress:          "MAPPED-ADDRESS",
-	AttrUsername:               "USERNAME",
-	AttrMessageIntegrity:       "MESSAGE-INTEGRITY",
-	AttrErrorCode:              "ERROR-CODE",
-	AttrUnknownAttributes:      "UNKNOWN-ATTRIBUTES",
-	AttrRealm:                  "REALM",
-	AttrNonce:                  "NONCE",
-	AttrRequestedAddressFamily: "REQUESTED-ADDRESS-FAMILY",
-	AttrXorMappedAddress:       "XOR-MAPPED-ADDRESS",
-	AttrSoftware:               "SOFTWARE",
-	AttrAlternateServer:        "ALTERNATE-SERVER",
-	AttrFingerprint:            "FINGERPRINT",
-	AttrChangeRequest:          "CHANGE-REQUEST",
-	AttrPadding:                "PADDING",
-	AttrResponsePort:           "RESPONSE-PORT",
-	AttrResponseOrigin:         "RESPONSE-ORIGIN",
-	AttrOtherAddress:           "OTHER-ADDRESS",
+	AttrMappedAddress:           "MAPPED-ADDRESS",
+	AttrUsername:                "USERNAME",
+	AttrMessageIntegrity:        "MESSAGE-INTEGRITY",
+	AttrErrorCode:               "ERROR-CODE",
+	AttrUnknownAttributes:       "UNKNOWN-ATTRIBUTES",
+	AttrRealm:                   "REALM",
+	AttrNonce:                   "NONCE",
+	AttrRequestedAddressFamily:  "REQUESTED-ADDRESS-FAMILY",
+	AttrXorMappedAddress:        "XOR-MAPPED-ADDRESS",
+	AttrSoftware:                "SOFTWARE",
+	AttrAlternateServer:         "ALTERNATE-SERVER",
+	AttrFingerprint:             "FINGERPRINT",
+	AttrChangeRequest:           "CHANGE-REQUEST",
+	AttrPadding:                 "PADDING",
+	AttrResponsePort:            "RESPONSE-PORT",
+	AttrResponseOrigin:          "RESPONSE-ORIGIN",
+	AttrOtherAddress:            "OTHER-ADDRESS",
+	AttrOrigin:                  "ORIGIN",
+	AttrPasswordAlgorithms:      "PASSWORD-ALGORITHMS",
+	AttrThirdPartyAuthorization: "THIRD-PARTY-AUTHORIZATION",
 }
 
 /*
@@ -310,9 +329,15 @@ type Error struct {
 	ErrorText string
 }
 
-// ParseError returns an Error type from a byte slice
+// ParseError returns an Error type from a byte slice. Only the numeric
+// class and number are used for the code, the reason phrase is free text
+// that servers can localize or omit.
 func ParseError(buf []byte) Error {
-	errorCode := int(buf[2])*100 + int(buf[3])
+	if len(buf) < 4 {
+		return Error{}
+	}
+	// the class uses only the lowest 3 bits, the rest is reserved
+	errorCode := int(buf[2]&0x07)*100 + int(buf[3])
 	errorText := buf[4:]
 	return Error{
 		ErrorCode: ErrorCode(errorCode),